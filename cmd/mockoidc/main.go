@@ -0,0 +1,42 @@
+// cmd/mockoidc 启动一个本地 OIDC 提供方，自动以固定用户身份同意授权，
+// 用于在没有真实 IdP 的环境下调试后台的 OIDC 登录。
+//
+// 用法：
+//
+//	go run ./cmd/mockoidc
+//	MOCKOIDC_ADDR=127.0.0.1:9400 MOCKOIDC_EMAIL=me@example.com go run ./cmd/mockoidc
+//
+// 然后在 .env 中设置 ROUNDNFC_OIDC_ISSUER=http://127.0.0.1:9400、
+// ROUNDNFC_OIDC_CLIENT_ID=roundnfc-admin、ROUNDNFC_OIDC_CLIENT_SECRET=dev-secret，
+// 并把 MOCKOIDC_EMAIL 加入 ROUNDNFC_OIDC_ALLOWED_EMAILS。
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"backend-go/internal/authflow/oidctest"
+)
+
+func main() {
+	addr := env("MOCKOIDC_ADDR", "127.0.0.1:9400")
+	issuer := env("MOCKOIDC_ISSUER", "http://"+addr)
+	p := oidctest.NewProvider(issuer, env("MOCKOIDC_CLIENT_ID", "roundnfc-admin"), env("MOCKOIDC_CLIENT_SECRET", "dev-secret"))
+	p.SetUser(oidctest.User{
+		Subject:       env("MOCKOIDC_SUBJECT", "mock-user"),
+		Email:         env("MOCKOIDC_EMAIL", "admin@example.com"),
+		EmailVerified: true,
+		Name:          "Mock Admin",
+	})
+	log.Printf("[mockoidc] issuer=%s client_id=%s listening on %s", p.Issuer, p.ClientID, addr)
+	log.Fatal(http.ListenAndServe(addr, p))
+}
+
+func env(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
@@ -10,7 +10,9 @@ import (
 	"time"
 
 	"backend-go/internal/handler"
+	"backend-go/internal/risk/clientip"
 	"backend-go/internal/roundnfc"
+	"backend-go/pkg/tus"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -30,7 +32,16 @@ func main() {
 	if m := strings.TrimSpace(os.Getenv("GIN_MODE")); m != "" {
 		gin.SetMode(m)
 	}
+	ipCfg, err := clientip.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("client ip: %v", err)
+	}
+	resolver, err := clientip.New(ipCfg)
+	if err != nil {
+		log.Fatalf("client ip: %v", err)
+	}
 	engine := gin.New()
+	clientip.Install(engine, resolver)
 	engine.Use(gin.Logger(), gin.Recovery())
 
 	c := cors.DefaultConfig()
@@ -47,6 +58,8 @@ func main() {
 	}
 	c.AllowCredentials = true
 	c.AddAllowHeaders("Authorization", "Content-Type", "CF-Turnstile-Response", "X-App-Token")
+	c.AddAllowHeaders(tus.RequestHeaders...)
+	c.AddExposeHeaders(tus.ResponseHeaders...)
 	c.MaxAge = 12 * time.Hour
 	engine.Use(cors.New(c))
 
@@ -17,6 +17,8 @@ import (
 	"net/http"
 	"strings"
 
+	"backend-go/internal/risk/clientip"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -35,6 +37,11 @@ type Options struct {
 	// MainAddr 是主 API 端口的监听地址（如 ":8080"）。仅在 PublicAPIBase 为空
 	// 时用于自动推导。
 	MainAddr string
+	// Credentials 表示 API 对 admin 源放行 CORS 凭据，SPA 据此带 cookie 请求
+	// （后台 cookie 会话需要）。
+	Credentials bool
+	// ClientIP 与 API 端口共用，决定是否信任 X-Forwarded-Proto / Host 等头。
+	ClientIP *clientip.Resolver
 }
 
 // BuildEngine 构造一个只服务 SPA 的 gin.Engine。dist 未构建时返回 nil, false。
@@ -63,13 +70,16 @@ func BuildEngine(opts Options) (*gin.Engine, bool) {
 	mainPort := portOf(opts.MainAddr)
 
 	engine := gin.New()
+	if opts.ClientIP != nil {
+		clientip.Install(engine, opts.ClientIP)
+	}
 	engine.Use(gin.Logger(), gin.Recovery())
 
 	fileSrv := http.FileServer(http.FS(sub))
 
 	serveIndex := func(c *gin.Context) {
 		apiBase := resolveAPIBase(c, opts.PublicAPIBase, mainPort)
-		script := buildRuntimeScript(apiBase)
+		script := buildRuntimeScript(apiBase, opts.Credentials)
 		var buf bytes.Buffer
 		buf.Grow(len(head) + len(script) + len(tail))
 		buf.Write(head)
@@ -113,8 +123,8 @@ func BuildEngine(opts Options) (*gin.Engine, bool) {
 
 // buildRuntimeScript 生成 <script>window.__ROAST_RUNTIME = {...}</script>，
 // 用 encoding/json 编码 apiBase 以避免脚本注入风险。
-func buildRuntimeScript(apiBase string) string {
-	payload := map[string]string{"apiBase": apiBase}
+func buildRuntimeScript(apiBase string, credentials bool) string {
+	payload := map[string]any{"apiBase": apiBase, "credentials": credentials}
 	b, _ := json.Marshal(payload)
 	return `<script>window.__ROAST_RUNTIME=` + string(b) + `;</script>`
 }
@@ -10,8 +10,11 @@ import (
 	"time"
 
 	"backend-go/internal/adminui"
+	"backend-go/internal/auth"
 	"backend-go/internal/bootstrap/mod"
 	"backend-go/internal/handler"
+	"backend-go/internal/risk/clientip"
+	"backend-go/pkg/tus"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -25,6 +28,7 @@ type Config struct {
 	AllowAllOrigins bool     // 允许所有源（未配置 HTTP_CORS_ORIGINS 时默认开启）
 	AllowCreds      bool     // 是否允许携带凭据
 	AllowHeaders    []string // 允许的自定义头
+	ClientIP        clientip.Config
 }
 
 func loadConfig() Config {
@@ -67,7 +71,9 @@ func loadConfig() Config {
 	if v := strings.TrimSpace(os.Getenv("HTTP_CORS_CREDENTIALS")); v != "" {
 		allowCreds = strings.EqualFold(v, "true") || v == "1" || strings.EqualFold(v, "yes")
 	}
-	allowHeaders := []string{"CF-Turnstile-Response", "Authorization", "Content-Type", "X-App-Token"}
+	allowHeaders := []string{"CF-Turnstile-Response", "Authorization", "Content-Type", "X-App-Token",
+		"X-RoundNFC-App-Token", "X-API-Token", auth.CSRFHeader, "X-Captcha-Token"}
+	allowHeaders = append(allowHeaders, tus.RequestHeaders...)
 	if v := strings.TrimSpace(os.Getenv("HTTP_CORS_HEADERS")); v != "" {
 		allowHeaders = nil
 		for _, h := range strings.Split(v, ",") {
@@ -76,6 +82,22 @@ func loadConfig() Config {
 				allowHeaders = append(allowHeaders, h)
 			}
 		}
+		// cookie 会话的非安全请求必须带 CSRF 头，自定义列表漏掉它就会被预检挡住。
+		if !containsFold(allowHeaders, auth.CSRFHeader) {
+			allowHeaders = append(allowHeaders, auth.CSRFHeader)
+		}
+	}
+	if allowAll && allowCreds {
+		// 反射任意 Origin 又带凭据，等于把 cookie 会话交给所有站点；此时只对
+		// 显式列出的源（含 admin 端口）放行凭据。
+		log.Println("[cors] credentials restricted to listed origins while all origins are allowed")
+	}
+	if v := strings.TrimSpace(os.Getenv("HTTP_ADMIN_SESSION_COOKIE")); !allowCreds && (strings.EqualFold(v, "true") || v == "1") {
+		log.Println("[cors] HTTP_CORS_CREDENTIALS=false: admin SPA on another port cannot use cookie sessions")
+	}
+	ipCfg, err := clientip.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("[clientip] %v", err)
 	}
 	return Config{
 		Addr:            addr,
@@ -85,6 +107,7 @@ func loadConfig() Config {
 		AllowAllOrigins: allowAll,
 		AllowCreds:      allowCreds,
 		AllowHeaders:    allowHeaders,
+		ClientIP:        ipCfg,
 	}
 }
 
@@ -95,7 +118,13 @@ func Run(version, commit, build string) {
 		gin.SetMode(m)
 	}
 
+	// 真实客户端 IP 放在最前面，日志、限流、各模块的 c.ClientIP() 都用它。
+	resolver, err := clientip.New(cfg.ClientIP)
+	if err != nil {
+		log.Fatalf("[clientip] %v", err)
+	}
 	apiEngine := gin.New()
+	clientip.Install(apiEngine, resolver)
 	apiEngine.Use(gin.Logger(), gin.Recovery())
 
 	corsCfg := cors.DefaultConfig()
@@ -105,9 +134,16 @@ func Run(version, commit, build string) {
 		corsCfg.AllowOrigins = cfg.CORSOrigins
 	}
 	corsCfg.AllowCredentials = cfg.AllowCreds
+	if cfg.AllowAllOrigins && cfg.AllowCreds {
+		corsCfg.AllowCredentials = false
+		apiEngine.Use(credentialsFor(cfg.CORSOrigins))
+	}
 	for _, h := range cfg.AllowHeaders {
 		corsCfg.AddAllowHeaders(h)
 	}
+	// 让浏览器端能读到限流头（internal/risk/ratelimit）和 tus 续传的头。
+	corsCfg.ExposeHeaders = []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After"}
+	corsCfg.ExposeHeaders = append(corsCfg.ExposeHeaders, tus.ResponseHeaders...)
 	corsCfg.MaxAge = 12 * time.Hour
 	apiEngine.Use(cors.New(corsCfg))
 
@@ -132,6 +168,8 @@ func Run(version, commit, build string) {
 	adminEngine, adminReady := adminui.BuildEngine(adminui.Options{
 		PublicAPIBase: cfg.PublicAPIBase,
 		MainAddr:      cfg.Addr,
+		Credentials:   cfg.AllowCreds,
+		ClientIP:      resolver,
 	})
 
 	apiSrv := &http.Server{Addr: cfg.Addr, Handler: apiEngine, ReadHeaderTimeout: 10 * time.Second}
@@ -171,6 +209,17 @@ func Run(version, commit, build string) {
 	}
 }
 
+// credentialsFor 在 cors 中间件之前运行，只给 origins 里的源加
+// Access-Control-Allow-Credentials（cors 本身对所有源关闭凭据）。
+func credentialsFor(origins []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if o := c.GetHeader("Origin"); o != "" && containsFold(origins, o) {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+		c.Next()
+	}
+}
+
 func portOf(addr string) string {
 	addr = strings.TrimSpace(addr)
 	if addr == "" {
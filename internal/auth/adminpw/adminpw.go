@@ -7,6 +7,9 @@
 //  3. 都没设置：返回空字符串，调用方据此判定后台禁用。
 //
 // 仅在两个 env 都被显式设置时，HASH 胜出（更安全）。
+//
+// 这里的结果只用于首次启动时写入数据库（见 authflow.PasswordStore）；之后数据库
+// 为准，除非设置了 <MOD>_ADMIN_PASSWORD_RESET=true。
 package adminpw
 
 import (
@@ -0,0 +1,142 @@
+package apitoken
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Mount 挂载 token 管理接口，须放在后台鉴权之后：
+//
+//	GET    ""        列表（?purpose= 过滤）
+//	GET    "/scopes" 本模块可选的 scope
+//	POST   ""        创建，明文只返回这一次
+//	PATCH  "/:id"    修改名称 / 启用 / scope / IP 白名单 / 过期时间
+//	DELETE "/:id"    删除
+//
+// 这些接口只接受管理员 JWT。sensitive 非空时挂在 POST 和 PATCH 前（通常是
+// Flow.StepUp）：改 scope / 重新启用和新建一样能放大权限。
+func (s *Service) Mount(g *gin.RouterGroup, sensitive ...gin.HandlerFunc) {
+	g.Use(AdminOnly())
+	g.GET("", s.handleList)
+	g.GET("/scopes", s.handleScopes)
+	g.POST("", append(sensitive[:len(sensitive):len(sensitive)], s.handleCreate)...)
+	g.PATCH("/:id", append(sensitive[:len(sensitive):len(sensitive)], s.handleUpdate)...)
+	g.DELETE("/:id", s.handleDelete)
+}
+
+func ok(c *gin.Context, data any) {
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "ok", "data": data})
+}
+
+func fail(c *gin.Context, status int, msg string) {
+	c.JSON(status, gin.H{"code": status, "message": msg, "data": nil})
+}
+
+func (s *Service) handleList(c *gin.Context) {
+	items, err := s.List(c.Request.Context(), strings.TrimSpace(c.Query("purpose")))
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ok(c, gin.H{"items": items})
+}
+
+func (s *Service) handleScopes(c *gin.Context) {
+	ok(c, gin.H{"scopes": s.KnownScopes()})
+}
+
+// CreatePayload 是创建 token 的请求体；ExpiresInDays 与 ExpiresAt 二选一。
+type CreatePayload struct {
+	Name          string     `json:"name"`
+	Purpose       string     `json:"purpose"`
+	Scopes        []string   `json:"scopes"`
+	AllowedCIDRs  []string   `json:"allowedCidrs"`
+	ExpiresAt     *time.Time `json:"expiresAt"`
+	ExpiresInDays int        `json:"expiresInDays"`
+}
+
+// Spec 把请求体转成 Spec。
+func (p CreatePayload) Spec() Spec {
+	exp := p.ExpiresAt
+	if exp == nil && p.ExpiresInDays > 0 {
+		t := time.Now().Add(time.Duration(p.ExpiresInDays) * 24 * time.Hour)
+		exp = &t
+	}
+	return Spec{Name: p.Name, Purpose: p.Purpose, Scopes: p.Scopes, AllowedCIDRs: p.AllowedCIDRs, ExpiresAt: exp}
+}
+
+func (s *Service) handleCreate(c *gin.Context) {
+	var p CreatePayload
+	if err := c.ShouldBindJSON(&p); err != nil {
+		fail(c, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if strings.TrimSpace(p.Name) == "" {
+		fail(c, http.StatusBadRequest, "name required")
+		return
+	}
+	t, plain, err := s.Create(c.Request.Context(), p.Spec())
+	if err != nil {
+		s.failStore(c, err)
+		return
+	}
+	log.Printf("[apitoken] create id=%q name=%q scopes=%v cidrs=%v ip=%s",
+		t.ID, t.Name, t.Scopes, t.AllowedCIDRs, c.ClientIP())
+	ok(c, gin.H{"item": t, "token": plain})
+}
+
+type updatePayload struct {
+	Name         *string    `json:"name"`
+	Enabled      *bool      `json:"enabled"`
+	Scopes       *[]string  `json:"scopes"`
+	AllowedCIDRs *[]string  `json:"allowedCidrs"`
+	ExpiresAt    *time.Time `json:"expiresAt"`
+	ClearExpiry  bool       `json:"clearExpiry"`
+}
+
+func (s *Service) handleUpdate(c *gin.Context) {
+	var p updatePayload
+	if err := c.ShouldBindJSON(&p); err != nil {
+		fail(c, http.StatusBadRequest, "invalid body")
+		return
+	}
+	patch := Patch{Name: p.Name, Enabled: p.Enabled, ExpiresAt: p.ExpiresAt, ClearExpiry: p.ClearExpiry}
+	if p.Scopes != nil {
+		patch.Scopes = append([]string{}, *p.Scopes...)
+	}
+	if p.AllowedCIDRs != nil {
+		patch.AllowedCIDRs = append([]string{}, *p.AllowedCIDRs...)
+	}
+	t, err := s.Update(c.Request.Context(), c.Param("id"), patch)
+	if err != nil {
+		s.failStore(c, err)
+		return
+	}
+	log.Printf("[apitoken] update id=%q enabled=%t scopes=%v ip=%s", t.ID, t.Enabled, t.Scopes, c.ClientIP())
+	ok(c, gin.H{"item": t})
+}
+
+func (s *Service) handleDelete(c *gin.Context) {
+	if err := s.Delete(c.Request.Context(), c.Param("id")); err != nil {
+		s.failStore(c, err)
+		return
+	}
+	log.Printf("[apitoken] delete id=%q ip=%s", c.Param("id"), c.ClientIP())
+	ok(c, gin.H{"ok": true})
+}
+
+func (s *Service) failStore(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		fail(c, http.StatusNotFound, "not found")
+	case errors.Is(err, ErrBadScope), errors.Is(err, ErrBadCIDR):
+		fail(c, http.StatusBadRequest, strings.TrimPrefix(err.Error(), "apitoken: "))
+	default:
+		fail(c, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,482 @@
+// Package apitoken 是各模块共用的 API token 服务：给脚本、App、外部前端发放
+// 可撤销的长期 token，代替把后台 JWT 或整套管理权限交出去。
+//
+// 每个 token 带一组 scope（"模块:资源:动作"，如 roundnfc:badges:read），可选
+// 过期时间与 IP/CIDR 白名单，并记录使用次数、最近使用时间和来源 IP。库里只存
+// sha256 哈希，明文只在创建时返回一次。
+//
+// 路由用 Scope 声明所需权限；后台 JWT 登录的管理员不受 scope 限制。
+package apitoken
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound     = errors.New("apitoken: not found")
+	ErrInvalid      = errors.New("apitoken: invalid token")
+	ErrDisabled     = errors.New("apitoken: token disabled")
+	ErrExpired      = errors.New("apitoken: token expired")
+	ErrIPNotAllowed = errors.New("apitoken: client ip not allowed")
+	ErrBadScope     = errors.New("apitoken: unknown scope")
+	ErrBadCIDR      = errors.New("apitoken: invalid ip or cidr")
+)
+
+// Token 是对外展示的 token 元数据（不含明文和哈希）。
+type Token struct {
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	Purpose      string     `json:"purpose,omitempty"`
+	TokenPrefix  string     `json:"tokenPrefix"`
+	Scopes       []string   `json:"scopes"`
+	AllowedCIDRs []string   `json:"allowedCidrs"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	Enabled      bool       `json:"enabled"`
+	UseCount     int64      `json:"useCount"`
+	LastUsedAt   *time.Time `json:"lastUsedAt,omitempty"`
+	LastUsedIP   string     `json:"lastUsedIp,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+// Allows 判断 token 是否拥有 scope。
+func (t *Token) Allows(scope string) bool {
+	for _, have := range t.Scopes {
+		if Grants(have, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// Grants 判断已授予的 have 是否覆盖 want。have 的最后一段可以是 "*"，
+// 匹配其后任意段：roundnfc:* 覆盖 roundnfc:badges:read，roundnfc:badges:*
+// 覆盖 roundnfc:badges:write。
+func Grants(have, want string) bool {
+	if have == want {
+		return true
+	}
+	if !strings.HasSuffix(have, ":*") && have != "*" {
+		return false
+	}
+	prefix := strings.TrimSuffix(have, "*")
+	return strings.HasPrefix(want, prefix) && len(want) > len(prefix)
+}
+
+// Options 配置一个模块的 token 服务。
+type Options struct {
+	// Table 是存 token 的表名，只允许字母、数字和下划线；默认 api_tokens。
+	Table string
+	// Prefix 是明文 token 的前缀（如 "rnfca_"），便于识别和泄露扫描。
+	Prefix string
+	// Scopes 是本模块认可的全部 scope；创建 token 时只能从中选择，
+	// 或使用覆盖其中至少一项的通配（roundnfc:*）。
+	Scopes []string
+}
+
+// Service 在 SQLite 上存取 token。
+type Service struct {
+	db     *sql.DB
+	table  string
+	prefix string
+	scopes []string
+}
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// New 建表（若不存在）并返回 Service。
+func New(db *sql.DB, opts Options) (*Service, error) {
+	if opts.Table == "" {
+		opts.Table = "api_tokens"
+	}
+	if !tableName.MatchString(opts.Table) {
+		return nil, fmt.Errorf("apitoken: invalid table name %q", opts.Table)
+	}
+	ddl := `CREATE TABLE IF NOT EXISTS ` + opts.Table + ` (
+  id            TEXT PRIMARY KEY,
+  name          TEXT NOT NULL,
+  purpose       TEXT NOT NULL DEFAULT '',
+  token_hash    TEXT NOT NULL UNIQUE,
+  token_prefix  TEXT NOT NULL DEFAULT '',
+  scopes        TEXT NOT NULL DEFAULT '[]',
+  allowed_cidrs TEXT NOT NULL DEFAULT '[]',
+  expires_at    DATETIME,
+  enabled       INTEGER NOT NULL DEFAULT 1,
+  use_count     INTEGER NOT NULL DEFAULT 0,
+  last_used_at  DATETIME,
+  last_used_ip  TEXT NOT NULL DEFAULT '',
+  created_at    DATETIME NOT NULL,
+  updated_at    DATETIME NOT NULL
+);`
+	if _, err := db.Exec(ddl); err != nil {
+		return nil, err
+	}
+	return &Service{db: db, table: opts.Table, prefix: opts.Prefix, scopes: opts.Scopes}, nil
+}
+
+// KnownScopes 返回本模块认可的 scope 列表（供管理界面展示）。
+func (s *Service) KnownScopes() []string { return append([]string(nil), s.scopes...) }
+
+// Owns 判断明文看起来是否是本服务签发的 token（只看前缀）。
+func (s *Service) Owns(plain string) bool {
+	return s.prefix != "" && strings.HasPrefix(strings.TrimSpace(plain), s.prefix)
+}
+
+// Hash 返回存库用的 sha256 十六进制哈希。
+func Hash(plain string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(plain)))
+	return hex.EncodeToString(sum[:])
+}
+
+func displayPrefix(plain string) string {
+	if len(plain) <= 12 {
+		return plain
+	}
+	return plain[:12]
+}
+
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// Spec 是创建 / 修改 token 时可设置的字段。
+type Spec struct {
+	Name         string
+	Purpose      string
+	Scopes       []string
+	AllowedCIDRs []string
+	ExpiresAt    *time.Time
+}
+
+// normalizeScopes 去重并校验 scope 都在 Options.Scopes 覆盖范围内。
+func (s *Service) normalizeScopes(in []string) ([]string, error) {
+	out := []string{}
+	for _, sc := range in {
+		sc = strings.ToLower(strings.TrimSpace(sc))
+		if sc == "" || containsString(out, sc) {
+			continue
+		}
+		known := false
+		for _, k := range s.scopes {
+			if Grants(sc, k) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("%w: %s", ErrBadScope, sc)
+		}
+		out = append(out, sc)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrBadScope)
+	}
+	return out, nil
+}
+
+// NormalizeCIDRs 把单个 IP 规范成 /32 或 /128，返回去重后的前缀列表。
+func NormalizeCIDRs(in []string) ([]string, error) {
+	out := []string{}
+	for _, v := range in {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		var p netip.Prefix
+		if strings.Contains(v, "/") {
+			var err error
+			if p, err = netip.ParsePrefix(v); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrBadCIDR, v)
+			}
+			p = p.Masked()
+		} else {
+			a, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrBadCIDR, v)
+			}
+			a = a.Unmap()
+			p = netip.PrefixFrom(a, a.BitLen())
+		}
+		if s := p.String(); !containsString(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+func ipAllowed(cidrs []string, ip string) bool {
+	if len(cidrs) == 0 {
+		return true
+	}
+	a, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return false
+	}
+	a = a.Unmap()
+	for _, c := range cidrs {
+		if p, err := netip.ParsePrefix(c); err == nil && p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// Create 签发新 token，返回元数据和只出现这一次的明文。
+func (s *Service) Create(ctx context.Context, spec Spec) (*Token, string, error) {
+	scopes, err := s.normalizeScopes(spec.Scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	cidrs, err := NormalizeCIDRs(spec.AllowedCIDRs)
+	if err != nil {
+		return nil, "", err
+	}
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, "", err
+	}
+	plain := s.prefix + base64.RawURLEncoding.EncodeToString(b[:])
+	id, err := newID()
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now().UTC()
+	t := &Token{
+		ID:           id,
+		Name:         strings.TrimSpace(spec.Name),
+		Purpose:      strings.TrimSpace(spec.Purpose),
+		TokenPrefix:  displayPrefix(plain),
+		Scopes:       scopes,
+		AllowedCIDRs: cidrs,
+		ExpiresAt:    utcPtr(spec.ExpiresAt),
+		Enabled:      true,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := s.insert(ctx, t, Hash(plain)); err != nil {
+		return nil, "", err
+	}
+	return t, plain, nil
+}
+
+// Import 写入一个已有哈希的 token（用于从旧表迁移）；id 已存在时忽略。
+func (s *Service) Import(ctx context.Context, t *Token, tokenHash string) error {
+	cidrs, err := NormalizeCIDRs(t.AllowedCIDRs)
+	if err != nil {
+		return err
+	}
+	t.AllowedCIDRs = cidrs
+	return s.insert(ctx, t, tokenHash)
+}
+
+func (s *Service) insert(ctx context.Context, t *Token, tokenHash string) error {
+	scopes, _ := json.Marshal(t.Scopes)
+	cidrs, _ := json.Marshal(t.AllowedCIDRs)
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO `+s.table+`(id,name,purpose,token_hash,token_prefix,scopes,allowed_cidrs,expires_at,enabled,
+  use_count,last_used_at,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(id) DO NOTHING`,
+		t.ID, t.Name, t.Purpose, tokenHash, t.TokenPrefix, string(scopes), string(cidrs), nullTime(t.ExpiresAt),
+		boolInt(t.Enabled), t.UseCount, nullTime(t.LastUsedAt), t.CreatedAt, t.UpdatedAt)
+	return err
+}
+
+// Patch 是 Update 的可选字段；nil 表示不修改。ClearExpiry 为 true 时去掉过期时间。
+type Patch struct {
+	Name         *string
+	Enabled      *bool
+	Scopes       []string
+	AllowedCIDRs []string
+	ExpiresAt    *time.Time
+	ClearExpiry  bool
+}
+
+// Update 修改 token 的名称、启用状态、scope、IP 白名单或过期时间。
+func (s *Service) Update(ctx context.Context, id string, p Patch) (*Token, error) {
+	t, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if p.Name != nil && strings.TrimSpace(*p.Name) != "" {
+		t.Name = strings.TrimSpace(*p.Name)
+	}
+	if p.Enabled != nil {
+		t.Enabled = *p.Enabled
+	}
+	if p.Scopes != nil {
+		if t.Scopes, err = s.normalizeScopes(p.Scopes); err != nil {
+			return nil, err
+		}
+	}
+	if p.AllowedCIDRs != nil {
+		if t.AllowedCIDRs, err = NormalizeCIDRs(p.AllowedCIDRs); err != nil {
+			return nil, err
+		}
+	}
+	if p.ClearExpiry {
+		t.ExpiresAt = nil
+	} else if p.ExpiresAt != nil {
+		t.ExpiresAt = utcPtr(p.ExpiresAt)
+	}
+	t.UpdatedAt = time.Now().UTC()
+	scopes, _ := json.Marshal(t.Scopes)
+	cidrs, _ := json.Marshal(t.AllowedCIDRs)
+	_, err = s.db.ExecContext(ctx, `UPDATE `+s.table+`
+SET name=?, enabled=?, scopes=?, allowed_cidrs=?, expires_at=?, updated_at=? WHERE id=?`,
+		t.Name, boolInt(t.Enabled), string(scopes), string(cidrs), nullTime(t.ExpiresAt), t.UpdatedAt, id)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *Service) Delete(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const tokenCols = `id,name,purpose,token_prefix,scopes,allowed_cidrs,expires_at,enabled,use_count,last_used_at,last_used_ip,created_at,updated_at`
+
+func scanToken(sc interface{ Scan(...any) error }) (*Token, error) {
+	var t Token
+	var scopes, cidrs string
+	var enabled int
+	var exp, last sql.NullTime
+	if err := sc.Scan(&t.ID, &t.Name, &t.Purpose, &t.TokenPrefix, &scopes, &cidrs, &exp, &enabled,
+		&t.UseCount, &last, &t.LastUsedIP, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return nil, err
+	}
+	_ = json.Unmarshal([]byte(scopes), &t.Scopes)
+	_ = json.Unmarshal([]byte(cidrs), &t.AllowedCIDRs)
+	if t.Scopes == nil {
+		t.Scopes = []string{}
+	}
+	if t.AllowedCIDRs == nil {
+		t.AllowedCIDRs = []string{}
+	}
+	t.Enabled = enabled == 1
+	if exp.Valid {
+		t.ExpiresAt = &exp.Time
+	}
+	if last.Valid {
+		t.LastUsedAt = &last.Time
+	}
+	return &t, nil
+}
+
+func (s *Service) Get(ctx context.Context, id string) (*Token, error) {
+	t, err := scanToken(s.db.QueryRowContext(ctx, `SELECT `+tokenCols+` FROM `+s.table+` WHERE id=?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return t, err
+}
+
+// List 按创建时间倒序返回全部 token；purpose 非空时只返回该用途的。
+func (s *Service) List(ctx context.Context, purpose string) ([]Token, error) {
+	q := `SELECT ` + tokenCols + ` FROM ` + s.table
+	var args []any
+	if purpose != "" {
+		q += ` WHERE purpose=?`
+		args = append(args, purpose)
+	}
+	rows, err := s.db.QueryContext(ctx, q+` ORDER BY created_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Token{}
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *t)
+	}
+	return out, rows.Err()
+}
+
+// Verify 校验明文 token：必须存在、启用、未过期，且 ip 在白名单内。
+// 通过后累加使用次数并记录时间和 IP。
+func (s *Service) Verify(ctx context.Context, plain, ip string) (*Token, error) {
+	plain = strings.TrimSpace(plain)
+	if plain == "" {
+		return nil, ErrInvalid
+	}
+	t, err := scanToken(s.db.QueryRowContext(ctx,
+		`SELECT `+tokenCols+` FROM `+s.table+` WHERE token_hash=?`, Hash(plain)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	switch {
+	case !t.Enabled:
+		return t, ErrDisabled
+	case t.ExpiresAt != nil && !now.Before(*t.ExpiresAt):
+		return t, ErrExpired
+	case !ipAllowed(t.AllowedCIDRs, ip):
+		return t, ErrIPNotAllowed
+	}
+	_, _ = s.db.ExecContext(ctx, `UPDATE `+s.table+`
+SET use_count=use_count+1, last_used_at=?, last_used_ip=? WHERE id=?`, now, ip, t.ID)
+	t.UseCount++
+	t.LastUsedAt, t.LastUsedIP = &now, ip
+	return t, nil
+}
+
+func utcPtr(t *time.Time) *time.Time {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+func nullTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return *t
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
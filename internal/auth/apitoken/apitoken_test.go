@@ -0,0 +1,228 @@
+package apitoken
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"backend-go/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	_ "modernc.org/sqlite"
+)
+
+var testScopes = []string{"demo:items:read", "demo:items:write", "demo:users:read"}
+
+func newTestService(t *testing.T) *Service {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "tokens.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+	s, err := New(db, Options{Prefix: "demo_", Scopes: testScopes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestGrants(t *testing.T) {
+	cases := []struct {
+		have, want string
+		ok         bool
+	}{
+		{"demo:items:read", "demo:items:read", true},
+		{"demo:items:read", "demo:items:write", false},
+		{"demo:items:*", "demo:items:write", true},
+		{"demo:*", "demo:users:read", true},
+		{"demo:*", "other:users:read", false},
+		{"demo:items:*", "demo:itemsx:read", false},
+		{"demo:*", "demo:", false},
+		{"*", "demo:items:read", true},
+	}
+	for _, c := range cases {
+		if got := Grants(c.have, c.want); got != c.ok {
+			t.Errorf("Grants(%q, %q) = %v, want %v", c.have, c.want, got, c.ok)
+		}
+	}
+}
+
+func TestCreateRejectsUnknownScopeAndBadCIDR(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	if _, _, err := s.Create(ctx, Spec{Name: "x", Scopes: []string{"other:items:read"}}); !errors.Is(err, ErrBadScope) {
+		t.Fatalf("unknown scope: got %v", err)
+	}
+	if _, _, err := s.Create(ctx, Spec{Name: "x"}); !errors.Is(err, ErrBadScope) {
+		t.Fatalf("no scope: got %v", err)
+	}
+	if _, _, err := s.Create(ctx, Spec{Name: "x", Scopes: testScopes[:1], AllowedCIDRs: []string{"10.0.0.0/33"}}); !errors.Is(err, ErrBadCIDR) {
+		t.Fatalf("bad cidr: got %v", err)
+	}
+	tok, _, err := s.Create(ctx, Spec{Name: "x", Scopes: []string{"DEMO:items:*"}, AllowedCIDRs: []string{"10.1.2.3", "192.168.1.77/24"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Scopes[0] != "demo:items:*" || tok.AllowedCIDRs[0] != "10.1.2.3/32" || tok.AllowedCIDRs[1] != "192.168.1.0/24" {
+		t.Fatalf("not normalized: %+v", tok)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	tok, plain, err := s.Create(ctx, Spec{Name: "writer", Scopes: []string{"demo:items:read"}, AllowedCIDRs: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Owns(plain) || tok.TokenPrefix != plain[:12] {
+		t.Fatalf("unexpected token %q / prefix %q", plain, tok.TokenPrefix)
+	}
+
+	if _, err := s.Verify(ctx, plain, "10.9.8.7"); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if _, err := s.Verify(ctx, plain, "::ffff:10.9.8.7"); err != nil {
+		t.Fatalf("verify mapped v4: %v", err)
+	}
+	if _, err := s.Verify(ctx, plain, "192.168.0.1"); !errors.Is(err, ErrIPNotAllowed) {
+		t.Fatalf("outside cidr: got %v", err)
+	}
+	if _, err := s.Verify(ctx, plain+"x", "10.0.0.1"); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("wrong token: got %v", err)
+	}
+
+	got, err := s.Get(ctx, tok.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UseCount != 2 || got.LastUsedIP != "::ffff:10.9.8.7" || got.LastUsedAt == nil {
+		t.Fatalf("usage not recorded: %+v", got)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	if _, err := s.Update(ctx, tok.ID, Patch{ExpiresAt: &past}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(ctx, plain, "10.0.0.1"); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expired: got %v", err)
+	}
+	off := false
+	if _, err := s.Update(ctx, tok.ID, Patch{Enabled: &off, ClearExpiry: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(ctx, plain, "10.0.0.1"); !errors.Is(err, ErrDisabled) {
+		t.Fatalf("disabled: got %v", err)
+	}
+}
+
+func TestMiddlewareScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := newTestService(t)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	_, plain, err := s.Create(context.Background(), Spec{Name: "reader", Scopes: []string{"demo:items:read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	g := r.Group("", s.Required(secret, "X-API-Token"))
+	g.GET("/items", Scope("demo:items:read"), func(c *gin.Context) { c.String(http.StatusOK, c.GetString(auth.ContextKeySubject)) })
+	g.POST("/items", Scope("demo:items:write"), func(c *gin.Context) { c.Status(http.StatusOK) })
+	s.Mount(g.Group("/api-tokens"))
+
+	do := func(method, path string, header ...string) int {
+		req := httptest.NewRequest(method, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	jwt, _, err := auth.IssueToken(secret, "admin", time.Hour, auth.AMRPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := do("GET", "/items", "X-API-Token", plain); code != http.StatusOK {
+		t.Fatalf("read with header: %d", code)
+	}
+	if code := do("GET", "/items", "Authorization", "Bearer "+plain); code != http.StatusOK {
+		t.Fatalf("read with bearer: %d", code)
+	}
+	if code := do("POST", "/items", "X-API-Token", plain); code != http.StatusForbidden {
+		t.Fatalf("write without scope: %d", code)
+	}
+	if code := do("GET", "/api-tokens", "X-API-Token", plain); code != http.StatusForbidden {
+		t.Fatalf("token managing tokens: %d", code)
+	}
+	if code := do("POST", "/items", "Authorization", "Bearer "+jwt); code != http.StatusOK {
+		t.Fatalf("admin jwt: %d", code)
+	}
+	if code := do("GET", "/api-tokens", "Authorization", "Bearer "+jwt); code != http.StatusOK {
+		t.Fatalf("admin listing tokens: %d", code)
+	}
+	if code := do("GET", "/items", "X-API-Token", "demo_nope"); code != http.StatusUnauthorized {
+		t.Fatalf("bad token: %d", code)
+	}
+	if code := do("GET", "/items"); code != http.StatusUnauthorized {
+		t.Fatalf("no credentials: %d", code)
+	}
+}
+
+// Mount 的 sensitive 中间件挡在创建和修改前，列表和删除不受影响。
+func TestMountSensitive(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := newTestService(t)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	tok, _, err := s.Create(context.Background(), Spec{Name: "reader", Scopes: []string{"demo:items:read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	s.Mount(r.Group("/api-tokens", auth.Required(secret)), auth.Fresh(time.Minute))
+
+	stale, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+		Subject: "admin", AuthTime: time.Now().Add(-time.Hour).Unix(),
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	}).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, _, err := auth.IssueToken(secret, "admin", time.Hour, auth.AMRPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path, bearer, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := do("GET", "/api-tokens", stale, ""); code != http.StatusOK {
+		t.Fatalf("list: %d", code)
+	}
+	if code := do("POST", "/api-tokens", stale, `{"name":"x","scopes":["demo:items:read"]}`); code != http.StatusForbidden {
+		t.Fatalf("create with stale login: %d", code)
+	}
+	if code := do("PATCH", "/api-tokens/"+tok.ID, stale, `{"scopes":["demo:items:write"]}`); code != http.StatusForbidden {
+		t.Fatalf("widen with stale login: %d", code)
+	}
+	if got, _ := s.Get(context.Background(), tok.ID); got == nil || len(got.Scopes) != 1 || got.Scopes[0] != "demo:items:read" {
+		t.Fatalf("scopes changed: %+v", got)
+	}
+	if code := do("PATCH", "/api-tokens/"+tok.ID, fresh, `{"scopes":["demo:items:write"]}`); code != http.StatusOK {
+		t.Fatalf("update with fresh login: %d", code)
+	}
+}
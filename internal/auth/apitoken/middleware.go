@@ -0,0 +1,116 @@
+package apitoken
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"backend-go/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContextKeyToken 存放通过校验的 *Token；JWT 鉴权的请求里没有。
+const ContextKeyToken = "apitoken.token"
+
+// FromContext 取出本次请求使用的 API token；管理员 JWT 请求返回 nil。
+func FromContext(c *gin.Context) *Token {
+	v, _ := c.Get(ContextKeyToken)
+	t, _ := v.(*Token)
+	return t
+}
+
+// SetContext 标记请求已由 t 鉴权，subject 记为 "token:<id>"。
+func SetContext(c *gin.Context, t *Token) {
+	c.Set(ContextKeyToken, t)
+	c.Set(auth.ContextKeySubject, "token:"+t.ID)
+}
+
+// Extract 依次读取 headers，再看 Authorization: Bearer（仅当前缀属于本服务，
+// 以免把 JWT 当成 API token）。
+func (s *Service) Extract(c *gin.Context, headers ...string) string {
+	for _, h := range headers {
+		if v := strings.TrimSpace(c.GetHeader(h)); v != "" {
+			return v
+		}
+	}
+	if raw := auth.ExtractBearer(c.GetHeader("Authorization")); s.Owns(raw) {
+		return raw
+	}
+	return ""
+}
+
+// Required 接受 API token 或（jwtSecret 非空时）后台 JWT，两者都没有则 401。
+// headers 是额外接受 token 的请求头，例如 "X-API-Token"。上游中间件已经
+// SetContext 过的请求直接放行。
+func (s *Service) Required(jwtSecret []byte, headers ...string) gin.HandlerFunc {
+	jwtRequired := auth.Required(jwtSecret)
+	return func(c *gin.Context) {
+		if FromContext(c) != nil {
+			c.Next()
+			return
+		}
+		raw := s.Extract(c, headers...)
+		if raw == "" {
+			if len(jwtSecret) == 0 {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": 1, "message": "missing token"})
+				return
+			}
+			jwtRequired(c)
+			return
+		}
+		t, err := s.Verify(c.Request.Context(), raw, c.ClientIP())
+		if err != nil {
+			s.reject(c, t, err)
+			return
+		}
+		SetContext(c, t)
+		c.Next()
+	}
+}
+
+func (s *Service) reject(c *gin.Context, t *Token, err error) {
+	id := ""
+	if t != nil {
+		id = t.ID
+	}
+	switch {
+	case errors.Is(err, ErrInvalid), errors.Is(err, ErrDisabled), errors.Is(err, ErrExpired):
+		log.Printf("[apitoken] rejected id=%q ip=%s: %v", id, c.ClientIP(), err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": 1, "message": "invalid api token"})
+	case errors.Is(err, ErrIPNotAllowed):
+		log.Printf("[apitoken] rejected id=%q ip=%s: %v", id, c.ClientIP(), err)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"code": 1, "message": "api token not allowed from this ip"})
+	default:
+		log.Printf("[apitoken] verify failed ip=%s: %v", c.ClientIP(), err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"code": 1, "message": "token check failed"})
+	}
+}
+
+// Scope 声明路由需要的权限。API token 缺少该 scope 时 403；管理员 JWT 直接放行。
+// 放在 Required 之后。
+func Scope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if t := FromContext(c); t != nil && !t.Allows(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"code":    1,
+				"message": "insufficient scope",
+				"data":    gin.H{"requiredScope": scope},
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// AdminOnly 拒绝 API token，只允许后台 JWT（用于管理 token 本身等接口）。
+func AdminOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if FromContext(c) != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"code": 1, "message": "api tokens cannot access this endpoint"})
+			return
+		}
+		c.Next()
+	}
+}
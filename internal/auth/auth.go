@@ -2,9 +2,13 @@
 package auth
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,20 +16,57 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-const ContextKeySubject = "auth.subject"
+const (
+	ContextKeySubject = "auth.subject"
+	ContextKeyClaims  = "auth.claims"
+	ContextKeyCookie  = "auth.cookie" // 本次请求走的是 cookie 会话（值为 cookie 里的 JWT）
+)
+
+// cookie 会话模式：JWT 放在 HttpOnly cookie 里，非安全方法须带 CSRFHeader。
+const (
+	SessionCookie = "admin_session"
+	CSRFHeader    = "X-CSRF-Token"
+)
+
+// amr（RFC 8176）取值。
+const (
+	AMRPassword  = "pwd"
+	AMROTP       = "otp"
+	AMRPasskey   = "pop" // WebAuthn 断言：持有私钥的证明
+	AMRMFA       = "mfa"
+	AMRFederated = "fed" // 外部 OIDC 登录（非 RFC 8176 取值）
+)
 
 type Claims struct {
-	Subject string `json:"sub"`
+	Subject  string   `json:"sub"`
+	AuthTime int64    `json:"auth_time,omitempty"` // 最近一次真正验证身份的时间（unix 秒）
+	AMR      []string `json:"amr,omitempty"`       // 该次验证用到的方式
 	jwt.RegisteredClaims
 }
 
-func IssueToken(secret []byte, subject string, ttl time.Duration) (string, time.Time, error) {
-	exp := time.Now().Add(ttl)
+// HasAMR 判断 token 是否由给定方式之一签发。
+func (c *Claims) HasAMR(methods ...string) bool {
+	for _, have := range c.AMR {
+		for _, want := range methods {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IssueToken 签发 JWT，auth_time 记为当前时间，amr 为本次验证用到的方式。
+func IssueToken(secret []byte, subject string, ttl time.Duration, amr ...string) (string, time.Time, error) {
+	now := time.Now()
+	exp := now.Add(ttl)
 	claims := Claims{
-		Subject: subject,
+		Subject:  subject,
+		AuthTime: now.Unix(),
+		AMR:      amr,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(exp),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -52,7 +93,11 @@ func HashPassword(plain string) (string, error) {
 	return string(b), err
 }
 
+// VerifyPassword 校验 bcrypt 或 argon2id（PHC 格式）哈希。
 func VerifyPassword(hash, plain string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		return verifyArgon2id(hash, plain)
+	}
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(plain)) == nil
 }
 
@@ -64,10 +109,63 @@ func ExtractBearer(h string) string {
 	return ""
 }
 
-// Required 不通过则 401。
+// CSRFToken 由会话 JWT 派生（HMAC），无需服务端存储：换发 token 即换 CSRF token。
+func CSRFToken(secret []byte, session string) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte("csrf\x00" + session))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+func safeMethod(m string) bool {
+	return m == http.MethodGet || m == http.MethodHead || m == http.MethodOptions
+}
+
+// RevocationFunc 返回 subject 的会话截止时间：早于它签发的 token 一律作废
+// （如改密之前的会话）。零值表示不限制。
+type RevocationFunc func(subject string) (time.Time, error)
+
+// revocations 按密钥的摘要登记 RevocationFunc，使同一密钥下的所有 Required
+// （含 apitoken.Required 之类的包装）都遵守同一条截止时间。
+var revocations sync.Map // [32]byte -> RevocationFunc
+
+// SetRevocation 为 secret 签发的 token 登记截止时间查询；fn 为 nil 时取消。
+func SetRevocation(secret []byte, fn RevocationFunc) {
+	key := sha256.Sum256(secret)
+	if fn == nil {
+		revocations.Delete(key)
+		return
+	}
+	revocations.Store(key, fn)
+}
+
+// revoked 判断 token 是否签发于截止时间之前。iat 只精确到秒，截止时间同样
+// 取整，保证改密时当场换发的新 token 仍然有效。
+func revoked(secret []byte, claims *Claims) (bool, error) {
+	v, ok := revocations.Load(sha256.Sum256(secret))
+	if !ok {
+		return false, nil
+	}
+	after, err := v.(RevocationFunc)(claims.Subject)
+	if err != nil || after.IsZero() {
+		return false, err
+	}
+	if claims.IssuedAt == nil {
+		return true, nil
+	}
+	return claims.IssuedAt.Unix() < after.Unix(), nil
+}
+
+// Required 接受 Authorization: Bearer 或 SessionCookie，不通过则 401。
+// 走 cookie 时，非 GET/HEAD/OPTIONS 请求还须带与之匹配的 CSRFHeader，否则 403。
+// 签发早于 SetRevocation 登记的截止时间的 token 同样 401。
 func Required(secret []byte) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		raw := ExtractBearer(c.GetHeader("Authorization"))
+		viaCookie := false
+		if raw == "" {
+			raw, _ = c.Cookie(SessionCookie)
+			viaCookie = raw != ""
+		}
 		if raw == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": 1, "message": "missing token"})
 			return
@@ -77,7 +175,49 @@ func Required(secret []byte) gin.HandlerFunc {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": 1, "message": "invalid token"})
 			return
 		}
+		if gone, err := revoked(secret, claims); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"code": 1, "message": "session check failed"})
+			return
+		} else if gone {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": 1, "message": "token revoked"})
+			return
+		}
+		if viaCookie {
+			got := c.GetHeader(CSRFHeader)
+			if !safeMethod(c.Request.Method) && !hmac.Equal([]byte(got), []byte(CSRFToken(secret, raw))) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"code": 1, "message": "csrf token mismatch"})
+				return
+			}
+			c.Set(ContextKeyCookie, raw)
+		}
 		c.Set(ContextKeySubject, claims.Subject)
+		c.Set(ContextKeyClaims, claims)
 		c.Next()
 	}
 }
+
+// Fresh 要求 JWT 的 auth_time 在 maxAge 之内，用于没有自己登录流程、
+// 无法做 Flow.StepUp 的模块：签发方的登录和 /reauth 都会按账号的第二因素
+// 验证后才刷新 auth_time。不满足时 403，格式与 StepUp 相同，前端据此去签发方
+// 重新验证后重试。须挂在 Required 之后；没有 claims（如 API token）一律拒绝。
+func Fresh(maxAge time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := ClaimsFrom(c)
+		if claims != nil && claims.AuthTime != 0 && time.Since(time.Unix(claims.AuthTime, 0)) <= maxAge {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "reauth required",
+			"data":    gin.H{"reauthRequired": true, "maxAgeSeconds": int(maxAge.Seconds())},
+		})
+	}
+}
+
+// ClaimsFrom 取出 Required 放进上下文的 claims；非 JWT 鉴权（如 app token）时为 nil。
+func ClaimsFrom(c *gin.Context) *Claims {
+	v, _ := c.Get(ContextKeyClaims)
+	claims, _ := v.(*Claims)
+	return claims
+}
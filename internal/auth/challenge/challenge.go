@@ -0,0 +1,183 @@
+// Package challenge 保存一次性、带过期时间的短期数据（WebAuthn challenge、OAuth state 等）。
+//
+// Memory 只在单进程内有效；SQL 把数据落到共享数据库，多副本 / 重启后仍可取回。
+// 两者语义一致：Take 取出即删除，过期视为不存在。
+package challenge
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Store 是一次性短期数据的存储。
+type Store interface {
+	// Put 写入 key，ttl 到期后不可再取出。
+	Put(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Take 原子地取出并删除 key；不存在或已过期时 ok=false。
+	Take(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Add 原子地写入 key，key 已存在且未过期时不写，返回 ok=false。
+	Add(ctx context.Context, key string, value []byte, ttl time.Duration) (ok bool, err error)
+}
+
+// NewKey 生成一个 URL 安全的随机 key（nBytes 字节熵）。
+func NewKey(nBytes int) (string, error) {
+	b := make([]byte, nBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ----- memory -----
+
+type memEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// Memory 是进程内实现，写入时顺带清理过期项。
+type Memory struct {
+	mu sync.Mutex
+	m  map[string]memEntry
+}
+
+func NewMemory() *Memory { return &Memory{m: map[string]memEntry{}} }
+
+func (s *Memory) Put(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	s.put(key, value, ttl, true)
+	return nil
+}
+
+func (s *Memory) Add(_ context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return s.put(key, value, ttl, false), nil
+}
+
+func (s *Memory) put(key string, value []byte, ttl time.Duration, replace bool) bool {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, e := range s.m {
+		if now.After(e.expiresAt) {
+			delete(s.m, k)
+		}
+	}
+	if _, ok := s.m[key]; ok && !replace {
+		return false
+	}
+	s.m[key] = memEntry{value: append([]byte(nil), value...), expiresAt: now.Add(ttl)}
+	return true
+}
+
+func (s *Memory) Take(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	e, ok := s.m[key]
+	if ok {
+		delete(s.m, key)
+	}
+	s.mu.Unlock()
+	if !ok || time.Now().After(e.expiresAt) {
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+// ----- sql -----
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQL 把数据存到一张表里（SQLite 方言，需支持 RETURNING，即 3.35+）。
+// 过期行在 Put / Add 时按间隔批量清理，Take 时再按 expires_at 过滤。
+type SQL struct {
+	db    *sql.DB
+	table string
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewSQL 建表（若不存在）并返回 Store。table 只允许字母、数字和下划线。
+func NewSQL(db *sql.DB, table string) (*SQL, error) {
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("challenge: invalid table name %q", table)
+	}
+	ddl := `CREATE TABLE IF NOT EXISTS ` + table + ` (
+    key        TEXT PRIMARY KEY,
+    value      BLOB NOT NULL,
+    expires_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_` + table + `_expires ON ` + table + `(expires_at);`
+	if _, err := db.Exec(ddl); err != nil {
+		return nil, err
+	}
+	return &SQL{db: db, table: table}, nil
+}
+
+const sweepInterval = time.Minute
+
+func (s *SQL) Put(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	now := time.Now()
+	if err := s.sweep(ctx, now); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO `+s.table+`(key, value, expires_at) VALUES(?, ?, ?)
+ON CONFLICT(key) DO UPDATE SET value=excluded.value, expires_at=excluded.expires_at`,
+		key, value, now.Add(ttl).UnixMilli())
+	return err
+}
+
+// Add 只覆盖已过期的行：冲突且未过期时 upsert 不改任何行。
+func (s *SQL) Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	if err := s.sweep(ctx, now); err != nil {
+		return false, err
+	}
+	res, err := s.db.ExecContext(ctx, `
+INSERT INTO `+s.table+`(key, value, expires_at) VALUES(?, ?, ?)
+ON CONFLICT(key) DO UPDATE SET value=excluded.value, expires_at=excluded.expires_at
+WHERE `+s.table+`.expires_at < ?`,
+		key, value, now.Add(ttl).UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *SQL) sweep(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	due := now.Sub(s.lastSweep) >= sweepInterval
+	if due {
+		s.lastSweep = now
+	}
+	s.mu.Unlock()
+	if !due {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE expires_at < ?`, now.UnixMilli())
+	return err
+}
+
+func (s *SQL) Take(ctx context.Context, key string) ([]byte, bool, error) {
+	var value []byte
+	var exp int64
+	err := s.db.QueryRowContext(ctx,
+		`DELETE FROM `+s.table+` WHERE key=? RETURNING value, expires_at`, key).Scan(&value, &exp)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if time.Now().UnixMilli() > exp {
+		return nil, false, nil
+	}
+	return value, true, nil
+}
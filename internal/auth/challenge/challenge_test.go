@@ -0,0 +1,176 @@
+package challenge
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func openDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func newSQL(t *testing.T, db *sql.DB) *SQL {
+	t.Helper()
+	s, err := NewSQL(db, "challenges")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testStores(t *testing.T) map[string]Store {
+	return map[string]Store{
+		"memory": NewMemory(),
+		"sql":    newSQL(t, openDB(t, filepath.Join(t.TempDir(), "c.db"))),
+	}
+}
+
+func TestTakeOnce(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		if err := s.Put(ctx, "k", []byte("v1"), time.Minute); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// 同一个 key 再写覆盖旧值
+		if err := s.Put(ctx, "k", []byte("v2"), time.Minute); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		v, ok, err := s.Take(ctx, "k")
+		if err != nil || !ok || string(v) != "v2" {
+			t.Fatalf("%s: take = %q %v %v", name, v, ok, err)
+		}
+		if _, ok, err := s.Take(ctx, "k"); ok || err != nil {
+			t.Fatalf("%s: second take = %v %v", name, ok, err)
+		}
+		if _, ok, err := s.Take(ctx, "missing"); ok || err != nil {
+			t.Fatalf("%s: missing = %v %v", name, ok, err)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		if ok, err := s.Add(ctx, "k", []byte("v1"), time.Minute); err != nil || !ok {
+			t.Fatalf("%s: first add = %v %v", name, ok, err)
+		}
+		if ok, err := s.Add(ctx, "k", []byte("v2"), time.Minute); err != nil || ok {
+			t.Fatalf("%s: second add = %v %v", name, ok, err)
+		}
+		if v, ok, _ := s.Take(ctx, "k"); !ok || string(v) != "v1" {
+			t.Fatalf("%s: take = %q %v", name, v, ok)
+		}
+		// 过期的 key 可以再加
+		_, _ = s.Add(ctx, "short", nil, 20*time.Millisecond)
+		time.Sleep(40 * time.Millisecond)
+		if ok, err := s.Add(ctx, "short", []byte("again"), time.Minute); err != nil || !ok {
+			t.Fatalf("%s: add over expired = %v %v", name, ok, err)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		_ = s.Put(ctx, "short", []byte("x"), 20*time.Millisecond)
+		_ = s.Put(ctx, "long", []byte("y"), time.Minute)
+		time.Sleep(40 * time.Millisecond)
+		if _, ok, err := s.Take(ctx, "short"); ok || err != nil {
+			t.Fatalf("%s: expired key taken: %v %v", name, ok, err)
+		}
+		if v, ok, _ := s.Take(ctx, "long"); !ok || string(v) != "y" {
+			t.Fatalf("%s: long = %q %v", name, v, ok)
+		}
+	}
+}
+
+// Memory 不能让调用方改到已存的值
+func TestMemoryCopiesValue(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+	b := []byte("abc")
+	_ = s.Put(ctx, "k", b, time.Minute)
+	b[0] = 'x'
+	if v, _, _ := s.Take(ctx, "k"); string(v) != "abc" {
+		t.Fatalf("value = %q", v)
+	}
+}
+
+// 两个连接指向同一个库，模拟两个副本：一边写入的另一边能取到，且只能取一次。
+func TestSQLSharedAcrossReplicas(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "shared.db")
+	a, b := newSQL(t, openDB(t, path)), newSQL(t, openDB(t, path))
+	if err := a.Put(ctx, "state", []byte("hello"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	var taken atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(s *SQL) {
+			defer wg.Done()
+			v, ok, err := s.Take(ctx, "state")
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				taken.Add(1)
+				if string(v) != "hello" {
+					t.Errorf("value = %q", v)
+				}
+			}
+		}([]*SQL{a, b}[i%2])
+	}
+	wg.Wait()
+	if taken.Load() != 1 {
+		t.Fatalf("taken %d times", taken.Load())
+	}
+}
+
+func TestSQLSweep(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t, filepath.Join(t.TempDir(), "c.db"))
+	s := newSQL(t, db)
+	_ = s.Put(ctx, "old", []byte("x"), -time.Second)
+	s.lastSweep = time.Time{}
+	_ = s.Put(ctx, "new", []byte("y"), time.Minute)
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM challenges`).Scan(&n); err != nil || n != 1 {
+		t.Fatalf("rows after sweep = %d %v", n, err)
+	}
+}
+
+func TestNewSQLTableName(t *testing.T) {
+	db := openDB(t, filepath.Join(t.TempDir(), "c.db"))
+	for _, bad := range []string{"", "1abc", "a;DROP TABLE x", "a-b", "a b"} {
+		if _, err := NewSQL(db, bad); err == nil {
+			t.Fatalf("accepted table name %q", bad)
+		}
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		k, err := NewKey(16)
+		if err != nil || len(k) != 22 || seen[k] {
+			t.Fatalf("key %q %v", k, err)
+		}
+		seen[k] = true
+	}
+}
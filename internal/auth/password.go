@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 密码哈希算法。
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// DefaultAdminPassword 是生成的 .env 里的默认口令；检测到它就强制改密。
+const DefaultAdminPassword = "admin"
+
+// HashPolicy 描述新哈希使用的算法与参数。已有哈希参数较弱时，登录成功后
+// 由调用方按 NeedsRehash 的结果透明升级。
+type HashPolicy struct {
+	Algorithm     string // HashBcrypt（默认）或 HashArgon2id
+	BcryptCost    int
+	Argon2Time    uint32
+	Argon2Memory  uint32 // KiB
+	Argon2Threads uint8
+}
+
+// DefaultHashPolicy：bcrypt 默认 cost；argon2id 参数取 RFC 9106 的低内存推荐值。
+func DefaultHashPolicy() HashPolicy {
+	return HashPolicy{
+		Algorithm:     HashBcrypt,
+		BcryptCost:    bcrypt.DefaultCost,
+		Argon2Time:    3,
+		Argon2Memory:  64 * 1024,
+		Argon2Threads: 2,
+	}
+}
+
+// HashPolicyFromEnv 读取 <PREFIX>_PASSWORD_HASH_ALGO / _BCRYPT_COST /
+// _ARGON2_TIME / _ARGON2_MEMORY_KB / _ARGON2_THREADS，未设置的取默认值。
+func HashPolicyFromEnv(envPrefix string) HashPolicy {
+	p := DefaultHashPolicy()
+	num := func(name string, def int) int {
+		if v := strings.TrimSpace(os.Getenv(envPrefix + name)); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				return n
+			}
+		}
+		return def
+	}
+	if strings.EqualFold(strings.TrimSpace(os.Getenv(envPrefix+"_PASSWORD_HASH_ALGO")), HashArgon2id) {
+		p.Algorithm = HashArgon2id
+	}
+	p.BcryptCost = num("_BCRYPT_COST", p.BcryptCost)
+	p.Argon2Time = uint32(num("_ARGON2_TIME", int(p.Argon2Time)))
+	p.Argon2Memory = uint32(num("_ARGON2_MEMORY_KB", int(p.Argon2Memory)))
+	p.Argon2Threads = uint8(num("_ARGON2_THREADS", int(p.Argon2Threads)))
+	return p
+}
+
+func (p HashPolicy) withDefaults() HashPolicy {
+	d := DefaultHashPolicy()
+	if p.Algorithm == "" {
+		p.Algorithm = d.Algorithm
+	}
+	if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+		p.BcryptCost = d.BcryptCost
+	}
+	if p.Argon2Time == 0 {
+		p.Argon2Time = d.Argon2Time
+	}
+	if p.Argon2Memory == 0 {
+		p.Argon2Memory = d.Argon2Memory
+	}
+	if p.Argon2Threads == 0 {
+		p.Argon2Threads = d.Argon2Threads
+	}
+	return p
+}
+
+// HashPasswordWith 按策略生成哈希。argon2id 使用 PHC 字符串格式：
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+func HashPasswordWith(p HashPolicy, plain string) (string, error) {
+	p = p.withDefaults()
+	if p.Algorithm != HashArgon2id {
+		b, err := bcrypt.GenerateFromPassword([]byte(plain), p.BcryptCost)
+		return string(b), err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(plain), salt, p.Argon2Time, p.Argon2Memory, p.Argon2Threads, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		p.Argon2Memory, p.Argon2Time, p.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+type argon2Hash struct {
+	time, memory uint32
+	threads      uint8
+	salt, key    []byte
+}
+
+func parseArgon2id(hash string) (*argon2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != HashArgon2id {
+		return nil, errors.New("not an argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, errors.New("unsupported argon2 version")
+	}
+	h := &argon2Hash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, err
+	}
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+	if h.time == 0 || h.memory == 0 || h.threads == 0 || len(h.key) == 0 {
+		return nil, errors.New("invalid argon2id parameters")
+	}
+	return h, nil
+}
+
+func verifyArgon2id(hash, plain string) bool {
+	h, err := parseArgon2id(hash)
+	if err != nil {
+		return false
+	}
+	key := argon2.IDKey([]byte(plain), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// NeedsRehash 判断 hash 是否与策略的算法不同或参数更弱。
+func NeedsRehash(p HashPolicy, hash string) bool {
+	p = p.withDefaults()
+	if strings.HasPrefix(hash, "$argon2id$") {
+		if p.Algorithm != HashArgon2id {
+			return true
+		}
+		h, err := parseArgon2id(hash)
+		return err != nil || h.time < p.Argon2Time || h.memory < p.Argon2Memory || h.threads < p.Argon2Threads
+	}
+	if p.Algorithm != HashBcrypt {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < p.BcryptCost
+}
+
+// PasswordRules 是可选的口令强度规则；零值只拒绝空口令、默认口令和与用户名相同的口令。
+type PasswordRules struct {
+	MinLength  int // 按字符计
+	MinClasses int // 大写 / 小写 / 数字 / 符号 至少几类
+}
+
+// PasswordRulesFromEnv 读取 <PREFIX>_PASSWORD_MIN_LENGTH / _PASSWORD_MIN_CLASSES。
+func PasswordRulesFromEnv(envPrefix string) PasswordRules {
+	num := func(name string, def int) int {
+		if v := strings.TrimSpace(os.Getenv(envPrefix + name)); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+				return n
+			}
+		}
+		return def
+	}
+	return PasswordRules{
+		MinLength:  num("_PASSWORD_MIN_LENGTH", 10),
+		MinClasses: num("_PASSWORD_MIN_CLASSES", 0),
+	}
+}
+
+// Check 返回面向用户的错误说明；通过时返回 nil。
+func (r PasswordRules) Check(plain, username string) error {
+	if strings.TrimSpace(plain) == "" {
+		return errors.New("password must not be empty")
+	}
+	if plain == DefaultAdminPassword || strings.EqualFold(plain, username) {
+		return errors.New("password is too easy to guess")
+	}
+	if n := len([]rune(plain)); n < r.MinLength {
+		return fmt.Errorf("password must be at least %d characters", r.MinLength)
+	}
+	var upper, lower, digit, other bool
+	for _, ch := range plain {
+		switch {
+		case unicode.IsUpper(ch):
+			upper = true
+		case unicode.IsLower(ch):
+			lower = true
+		case unicode.IsDigit(ch):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	classes := 0
+	for _, b := range []bool{upper, lower, digit, other} {
+		if b {
+			classes++
+		}
+	}
+	if classes < r.MinClasses {
+		return fmt.Errorf("password must mix at least %d of: upper case, lower case, digits, symbols", r.MinClasses)
+	}
+	return nil
+}
@@ -0,0 +1,642 @@
+package authflow
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha1" // registers crypto.SHA1 for TPM attestation
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// AttestationPolicy restricts which authenticators may register admin passkeys.
+// The zero value accepts any authenticator, including "none" attestation.
+type AttestationPolicy struct {
+	// AllowedAAGUIDs lists authenticator models (UUID form, case-insensitive).
+	// Empty allows every AAGUID. An AAGUID is only as good as the certificate
+	// behind it, so a non-empty list needs RequireAttestation and Roots.
+	AllowedAAGUIDs []string
+	// RequireAttestation rejects "none" and self attestation, so the AAGUID is
+	// backed by a manufacturer certificate rather than merely claimed. It
+	// needs Roots: any self-made chain would pass otherwise.
+	RequireAttestation bool
+	// RequireUserVerification demands the UV flag at registration and login.
+	RequireUserVerification bool
+	// Roots, when set, must anchor every x5c attestation chain.
+	Roots *x509.CertPool
+}
+
+// AttestationPolicyFromEnv reads <PREFIX>_WEBAUTHN_AAGUIDS (comma separated),
+// <PREFIX>_WEBAUTHN_REQUIRE_ATTESTATION, <PREFIX>_WEBAUTHN_REQUIRE_UV and
+// <PREFIX>_WEBAUTHN_ATTESTATION_ROOTS (path to a PEM bundle).
+func AttestationPolicyFromEnv(envPrefix string) AttestationPolicy {
+	env := func(name string) string { return strings.TrimSpace(os.Getenv(envPrefix + name)) }
+	truthy := func(v string) bool {
+		switch strings.ToLower(v) {
+		case "1", "true", "yes", "on":
+			return true
+		}
+		return false
+	}
+	var p AttestationPolicy
+	for _, a := range strings.Split(env("_WEBAUTHN_AAGUIDS"), ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			p.AllowedAAGUIDs = append(p.AllowedAAGUIDs, a)
+		}
+	}
+	p.RequireAttestation = truthy(env("_WEBAUTHN_REQUIRE_ATTESTATION"))
+	p.RequireUserVerification = truthy(env("_WEBAUTHN_REQUIRE_UV"))
+	if path := env("_WEBAUTHN_ATTESTATION_ROOTS"); path != "" {
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("[authflow] attestation roots %s: %v", path, err)
+		} else {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pemBytes) {
+				log.Printf("[authflow] attestation roots %s: no certificates found", path)
+			}
+			p.Roots = pool
+		}
+	}
+	return p
+}
+
+// Validate rejects an AAGUID allowlist that "none" or self attestation, or a
+// chain from any CA, could satisfy by simply claiming an allowed AAGUID, and
+// RequireAttestation without the roots that would make it mean anything.
+func (p AttestationPolicy) Validate() error {
+	if len(p.AllowedAAGUIDs) > 0 && !p.RequireAttestation {
+		return errors.New("webauthn: an AAGUID allowlist requires attestation to be required")
+	}
+	if p.RequireAttestation && p.Roots == nil {
+		return errors.New("webauthn: required attestation needs attestation trust roots")
+	}
+	return nil
+}
+
+// attestationConveyance is the "attestation" preference sent to the browser.
+func (p AttestationPolicy) attestationConveyance() string {
+	if p.RequireAttestation || len(p.AllowedAAGUIDs) > 0 {
+		return "direct"
+	}
+	return "none"
+}
+
+func (p AttestationPolicy) userVerification() string {
+	if p.RequireUserVerification {
+		return "required"
+	}
+	return "preferred"
+}
+
+// check applies the policy to a verified registration.
+func (p AttestationPolicy) check(ad *authDataParsed, res *attestationResult) error {
+	if p.RequireUserVerification && ad.Flags&flagUV == 0 {
+		return errors.New("webauthn: user verification required")
+	}
+	if len(p.AllowedAAGUIDs) == 0 && !p.RequireAttestation {
+		return nil
+	}
+	// Validate refuses such a policy at load; never trust a claimed
+	// attestation if one gets here anyway.
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if res.Type == "none" || res.Type == "self" {
+		return fmt.Errorf("webauthn: %s attestation not accepted", res.Format)
+	}
+	if len(p.AllowedAAGUIDs) == 0 {
+		return nil
+	}
+	got := formatAAGUID(ad.AAGUID)
+	for _, a := range p.AllowedAAGUIDs {
+		if strings.EqualFold(strings.TrimSpace(a), got) {
+			return nil
+		}
+	}
+	return fmt.Errorf("webauthn: authenticator %s not allowed", got)
+}
+
+func formatAAGUID(b []byte) string {
+	if len(b) != 16 {
+		return ""
+	}
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+type attestationResult struct {
+	Format string
+	Type   string // "none" | "self" | "basic" | "attca"
+}
+
+var (
+	oidFIDOGenCEAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+	oidTCGKpAIKCert    = asn1.ObjectIdentifier{2, 23, 133, 8, 3}
+	oidSubjectAltName  = asn1.ObjectIdentifier{2, 5, 29, 17}
+)
+
+// verifyAttestation checks the attestation statement of a registration
+// (WebAuthn §8) for the packed, fido-u2f, tpm and none formats.
+func verifyAttestation(attObj map[interface{}]interface{}, authData []byte, ad *authDataParsed, cdHash []byte, roots *x509.CertPool) (*attestationResult, error) {
+	format, _ := attObj["fmt"].(string)
+	stmt, ok := attObj["attStmt"].(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("attStmt missing")
+	}
+	res := &attestationResult{Format: format}
+	var chain []*x509.Certificate
+	var err error
+	switch format {
+	case "none":
+		if len(stmt) != 0 {
+			return nil, errors.New("none: attStmt must be empty")
+		}
+		res.Type = "none"
+		return res, nil
+	case "packed":
+		res.Type, chain, err = verifyPacked(stmt, authData, ad, cdHash)
+	case "fido-u2f":
+		res.Type, chain, err = verifyFIDOU2F(stmt, ad, cdHash)
+	case "tpm":
+		res.Type, chain, err = verifyTPM(stmt, authData, ad, cdHash)
+	default:
+		return nil, fmt.Errorf("unsupported attestation format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", format, err)
+	}
+	if roots != nil && len(chain) > 0 {
+		inter := x509.NewCertPool()
+		for _, c := range chain[1:] {
+			inter.AddCert(c)
+		}
+		if _, err := chain[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: inter,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}); err != nil {
+			return nil, fmt.Errorf("%s: untrusted attestation chain: %w", format, err)
+		}
+	}
+	return res, nil
+}
+
+func verifyPacked(stmt map[interface{}]interface{}, authData []byte, ad *authDataParsed, cdHash []byte) (string, []*x509.Certificate, error) {
+	alg, ok := stmt["alg"].(int64)
+	if !ok {
+		return "", nil, errors.New("alg missing")
+	}
+	sig, ok := stmt["sig"].([]byte)
+	if !ok {
+		return "", nil, errors.New("sig missing")
+	}
+	if _, ok := stmt["ecdaaKeyId"]; ok {
+		return "", nil, errors.New("ECDAA is not supported")
+	}
+	signed := concatBytes(authData, cdHash)
+	if _, ok := stmt["x5c"]; !ok {
+		// self attestation: signed by the credential key itself
+		credAlg, err := coseKeyAlg(ad.CredentialPublicKey)
+		if err != nil {
+			return "", nil, err
+		}
+		if credAlg != alg {
+			return "", nil, errors.New("alg does not match credential key")
+		}
+		pub, err := parseCOSEKey(ad.CredentialPublicKey)
+		if err != nil {
+			return "", nil, err
+		}
+		return "self", nil, verifyCOSESignature(pub, alg, signed, sig)
+	}
+	chain, err := parseX5C(stmt["x5c"])
+	if err != nil {
+		return "", nil, err
+	}
+	leaf := chain[0]
+	if err := verifyCOSESignature(leaf.PublicKey, alg, signed, sig); err != nil {
+		return "", nil, err
+	}
+	if leaf.Version != 3 {
+		return "", nil, errors.New("attestation certificate must be v3")
+	}
+	subj := leaf.Subject
+	if len(subj.Country) == 0 || len(subj.Organization) == 0 || subj.CommonName == "" ||
+		len(subj.OrganizationalUnit) == 0 || subj.OrganizationalUnit[0] != "Authenticator Attestation" {
+		return "", nil, errors.New("attestation certificate subject invalid")
+	}
+	if leaf.IsCA {
+		return "", nil, errors.New("attestation certificate must not be a CA")
+	}
+	if err := checkCertAAGUID(leaf, ad.AAGUID); err != nil {
+		return "", nil, err
+	}
+	return "basic", chain, nil
+}
+
+func verifyFIDOU2F(stmt map[interface{}]interface{}, ad *authDataParsed, cdHash []byte) (string, []*x509.Certificate, error) {
+	sig, ok := stmt["sig"].([]byte)
+	if !ok {
+		return "", nil, errors.New("sig missing")
+	}
+	chain, err := parseX5C(stmt["x5c"])
+	if err != nil {
+		return "", nil, err
+	}
+	if len(chain) != 1 {
+		return "", nil, errors.New("x5c must hold exactly one certificate")
+	}
+	certKey, ok := chain[0].PublicKey.(*ecdsa.PublicKey)
+	if !ok || certKey.Curve != elliptic.P256() {
+		return "", nil, errors.New("attestation key must be ECDSA P-256")
+	}
+	pub, err := parseCOSEKey(ad.CredentialPublicKey)
+	if err != nil {
+		return "", nil, err
+	}
+	credKey, ok := pub.(*ecdsa.PublicKey)
+	if !ok || credKey.Curve != elliptic.P256() {
+		return "", nil, errors.New("credential key must be ECDSA P-256")
+	}
+	u2fKey := make([]byte, 65)
+	u2fKey[0] = 0x04
+	credKey.X.FillBytes(u2fKey[1:33])
+	credKey.Y.FillBytes(u2fKey[33:65])
+	signed := concatBytes([]byte{0x00}, ad.RPIDHash, cdHash, ad.CredentialID, u2fKey)
+	if err := verifyCOSESignature(certKey, -7, signed, sig); err != nil {
+		return "", nil, err
+	}
+	return "basic", chain, nil
+}
+
+// TPM 2.0 constants (TCG Algorithm Registry / Part 2 Structures).
+const (
+	tpmGeneratedValue  = 0xff544347
+	tpmStAttestCertify = 0x8017
+	tpmAlgRSA          = 0x0001
+	tpmAlgSHA1         = 0x0004
+	tpmAlgSHA256       = 0x000b
+	tpmAlgSHA384       = 0x000c
+	tpmAlgSHA512       = 0x000d
+	tpmAlgNull         = 0x0010
+	tpmAlgECC          = 0x0023
+)
+
+func verifyTPM(stmt map[interface{}]interface{}, authData []byte, ad *authDataParsed, cdHash []byte) (string, []*x509.Certificate, error) {
+	if v, _ := stmt["ver"].(string); v != "2.0" {
+		return "", nil, errors.New(`ver must be "2.0"`)
+	}
+	alg, ok := stmt["alg"].(int64)
+	if !ok {
+		return "", nil, errors.New("alg missing")
+	}
+	sig, _ := stmt["sig"].([]byte)
+	certInfo, _ := stmt["certInfo"].([]byte)
+	pubArea, _ := stmt["pubArea"].([]byte)
+	if len(sig) == 0 || len(certInfo) == 0 || len(pubArea) == 0 {
+		return "", nil, errors.New("sig, certInfo and pubArea are required")
+	}
+	if _, ok := stmt["x5c"]; !ok {
+		return "", nil, errors.New("x5c missing (ECDAA is not supported)")
+	}
+	chain, err := parseX5C(stmt["x5c"])
+	if err != nil {
+		return "", nil, err
+	}
+
+	pub, err := parseCOSEKey(ad.CredentialPublicKey)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := tpmPubAreaMatches(pubArea, pub); err != nil {
+		return "", nil, err
+	}
+
+	r := &tpmReader{b: certInfo}
+	if r.u32() != tpmGeneratedValue {
+		return "", nil, errors.New("certInfo magic invalid")
+	}
+	if r.u16() != tpmStAttestCertify {
+		return "", nil, errors.New("certInfo type invalid")
+	}
+	r.tpm2b() // qualifiedSigner
+	extraData := r.tpm2b()
+	r.skip(17) // clockInfo
+	r.skip(8)  // firmwareVersion
+	name := r.tpm2b()
+	r.tpm2b() // qualifiedName
+	if r.err != nil {
+		return "", nil, fmt.Errorf("certInfo: %w", r.err)
+	}
+	h, err := coseAlgHash(alg)
+	if err != nil {
+		return "", nil, err
+	}
+	hh := h.New()
+	hh.Write(authData)
+	hh.Write(cdHash)
+	if !bytes.Equal(extraData, hh.Sum(nil)) {
+		return "", nil, errors.New("certInfo extraData mismatch")
+	}
+	if len(name) < 2 {
+		return "", nil, errors.New("certInfo name invalid")
+	}
+	nh, err := tpmHash(binary.BigEndian.Uint16(name[:2]))
+	if err != nil {
+		return "", nil, err
+	}
+	nhh := nh.New()
+	nhh.Write(pubArea)
+	if !bytes.Equal(name[2:], nhh.Sum(nil)) {
+		return "", nil, errors.New("certInfo name does not match pubArea")
+	}
+
+	aik := chain[0]
+	if err := verifyCOSESignature(aik.PublicKey, alg, certInfo, sig); err != nil {
+		return "", nil, err
+	}
+	if aik.Version != 3 {
+		return "", nil, errors.New("AIK certificate must be v3")
+	}
+	if len(aik.Subject.Names) != 0 {
+		return "", nil, errors.New("AIK certificate subject must be empty")
+	}
+	hasSAN := false
+	for _, ext := range aik.Extensions {
+		if ext.Id.Equal(oidSubjectAltName) {
+			hasSAN = true
+		}
+	}
+	if !hasSAN {
+		return "", nil, errors.New("AIK certificate missing subjectAltName")
+	}
+	hasEKU := false
+	for _, eku := range aik.UnknownExtKeyUsage {
+		if eku.Equal(oidTCGKpAIKCert) {
+			hasEKU = true
+		}
+	}
+	if !hasEKU {
+		return "", nil, errors.New("AIK certificate missing tcg-kp-AIKCertificate EKU")
+	}
+	if aik.IsCA {
+		return "", nil, errors.New("AIK certificate must not be a CA")
+	}
+	if err := checkCertAAGUID(aik, ad.AAGUID); err != nil {
+		return "", nil, err
+	}
+	return "attca", chain, nil
+}
+
+// tpmPubAreaMatches checks that a TPMT_PUBLIC describes the credential key.
+func tpmPubAreaMatches(pubArea []byte, pub crypto.PublicKey) error {
+	r := &tpmReader{b: pubArea}
+	typ := r.u16()
+	r.u16() // nameAlg
+	r.u32() // objectAttributes
+	r.tpm2b()
+	if r.u16() != tpmAlgNull { // symmetric
+		return errors.New("pubArea: unexpected symmetric algorithm")
+	}
+	if r.u16() != tpmAlgNull { // scheme
+		r.u16()
+	}
+	switch typ {
+	case tpmAlgRSA:
+		r.u16() // keyBits
+		exp := r.u32()
+		n := r.tpm2b()
+		if r.err != nil {
+			return fmt.Errorf("pubArea: %w", r.err)
+		}
+		if exp == 0 {
+			exp = 65537
+		}
+		key, ok := pub.(*rsa.PublicKey)
+		if !ok || key.N.Cmp(new(big.Int).SetBytes(n)) != 0 || uint32(key.E) != exp {
+			return errors.New("pubArea does not match credential key")
+		}
+	case tpmAlgECC:
+		curveID := r.u16()
+		if r.u16() != tpmAlgNull { // kdf
+			r.u16()
+		}
+		x := r.tpm2b()
+		y := r.tpm2b()
+		if r.err != nil {
+			return fmt.Errorf("pubArea: %w", r.err)
+		}
+		key, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("pubArea does not match credential key")
+		}
+		var curve elliptic.Curve
+		switch curveID {
+		case 0x0003:
+			curve = elliptic.P256()
+		case 0x0004:
+			curve = elliptic.P384()
+		case 0x0005:
+			curve = elliptic.P521()
+		}
+		if curve != key.Curve || key.X.Cmp(new(big.Int).SetBytes(x)) != 0 || key.Y.Cmp(new(big.Int).SetBytes(y)) != 0 {
+			return errors.New("pubArea does not match credential key")
+		}
+	default:
+		return fmt.Errorf("pubArea: unsupported type %#x", typ)
+	}
+	return nil
+}
+
+// tpmReader reads big-endian TPM structures, latching the first error.
+type tpmReader struct {
+	b   []byte
+	err error
+}
+
+func (r *tpmReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.b) < n {
+		r.err = errors.New("truncated")
+		return nil
+	}
+	out := r.b[:n]
+	r.b = r.b[n:]
+	return out
+}
+
+func (r *tpmReader) skip(n int) { r.take(n) }
+
+func (r *tpmReader) u16() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *tpmReader) u32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *tpmReader) tpm2b() []byte {
+	return r.take(int(r.u16()))
+}
+
+func tpmHash(alg uint16) (crypto.Hash, error) {
+	switch alg {
+	case tpmAlgSHA1:
+		return crypto.SHA1, nil
+	case tpmAlgSHA256:
+		return crypto.SHA256, nil
+	case tpmAlgSHA384:
+		return crypto.SHA384, nil
+	case tpmAlgSHA512:
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported TPM hash %#x", alg)
+}
+
+func parseX5C(v interface{}) ([]*x509.Certificate, error) {
+	arr, ok := v.([]interface{})
+	if !ok || len(arr) == 0 {
+		return nil, errors.New("x5c missing")
+	}
+	out := make([]*x509.Certificate, 0, len(arr))
+	for _, item := range arr {
+		der, ok := item.([]byte)
+		if !ok {
+			return nil, errors.New("x5c entry is not a byte string")
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("x5c: %w", err)
+		}
+		out = append(out, cert)
+	}
+	return out, nil
+}
+
+// checkCertAAGUID compares the id-fido-gen-ce-aaguid extension, when present,
+// with the AAGUID in authenticator data.
+func checkCertAAGUID(cert *x509.Certificate, aaguid []byte) error {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidFIDOGenCEAAGUID) {
+			continue
+		}
+		if ext.Critical {
+			return errors.New("AAGUID extension must not be critical")
+		}
+		var v []byte
+		if _, err := asn1.Unmarshal(ext.Value, &v); err != nil {
+			return fmt.Errorf("AAGUID extension: %w", err)
+		}
+		if !bytes.Equal(v, aaguid) {
+			return errors.New("AAGUID does not match attestation certificate")
+		}
+	}
+	return nil
+}
+
+// coseKeyAlg returns the "alg" (label 3) of a COSE_Key.
+func coseKeyAlg(b []byte) (int64, error) {
+	v, _, err := cborDecode(b)
+	if err != nil {
+		return 0, fmt.Errorf("cose cbor: %w", err)
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return 0, errors.New("cose: not a map")
+	}
+	alg, ok := m[uint64(3)].(int64)
+	if !ok {
+		return 0, errors.New("cose: missing alg")
+	}
+	return alg, nil
+}
+
+func coseAlgHash(alg int64) (crypto.Hash, error) {
+	switch alg {
+	case -7, -257, -37:
+		return crypto.SHA256, nil
+	case -35, -258, -38:
+		return crypto.SHA384, nil
+	case -36, -259, -39:
+		return crypto.SHA512, nil
+	case -65535:
+		return crypto.SHA1, nil
+	}
+	return 0, fmt.Errorf("unsupported COSE alg %d", alg)
+}
+
+// verifyCOSESignature verifies sig over data with the hash and scheme named by a COSE alg.
+func verifyCOSESignature(pub crypto.PublicKey, alg int64, data, sig []byte) error {
+	if alg == -8 {
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(key, data, sig) {
+			return errors.New("ed25519: invalid signature")
+		}
+		return nil
+	}
+	h, err := coseAlgHash(alg)
+	if err != nil {
+		return err
+	}
+	hh := h.New()
+	hh.Write(data)
+	digest := hh.Sum(nil)
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		if alg != -7 && alg != -35 && alg != -36 {
+			return fmt.Errorf("alg %d does not match ECDSA key", alg)
+		}
+		var s ecdsaSig
+		if _, err := asn1.Unmarshal(sig, &s); err != nil {
+			return fmt.Errorf("ecdsa sig parse: %w", err)
+		}
+		if !ecdsa.Verify(key, digest, s.R, s.S) {
+			return errors.New("ecdsa: invalid signature")
+		}
+	case *rsa.PublicKey:
+		switch alg {
+		case -37, -38, -39:
+			if err := rsa.VerifyPSS(key, h, digest, sig, nil); err != nil {
+				return fmt.Errorf("rsa-pss: %w", err)
+			}
+		case -257, -258, -259, -65535:
+			if err := rsa.VerifyPKCS1v15(key, h, digest, sig); err != nil {
+				return fmt.Errorf("rsa: %w", err)
+			}
+		default:
+			return fmt.Errorf("alg %d does not match RSA key", alg)
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", pub)
+	}
+	return nil
+}
+
+func concatBytes(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
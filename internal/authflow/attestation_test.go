@@ -0,0 +1,258 @@
+package authflow
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testAAGUID = []byte{0xee, 0x88, 0x28, 0x79, 0x72, 0x1c, 0x49, 0x13, 0x97, 0x75, 0x3d, 0xfc, 0xce, 0x97, 0x07, 0x2a}
+
+// testCert issues a certificate for a fresh P-256 key, self-signed when
+// parent is nil. A CA leaf is created when isCA is set; aaguid, when given,
+// goes into the id-fido-gen-ce-aaguid extension.
+func testCert(t *testing.T, cn string, isCA bool, parent *x509.Certificate, parentKey crypto.Signer, aaguid []byte) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Organization:       []string{"Test Vendor"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         cn,
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	}
+	if aaguid != nil {
+		v, _ := asn1.Marshal(aaguid)
+		tmpl.ExtraExtensions = []pkix.Extension{{Id: oidFIDOGenCEAAGUID, Value: v}}
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func es256Sign(t *testing.T, key *ecdsa.PrivateKey, data []byte) []byte {
+	t.Helper()
+	h := sha256.Sum256(data)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+// attestationVendor is a manufacturer CA with one intermediate and one
+// batch attestation certificate.
+type attestationVendor struct {
+	root  *x509.Certificate
+	inter *x509.Certificate
+	leaf  *x509.Certificate
+	key   *ecdsa.PrivateKey
+}
+
+func newAttestationVendor(t *testing.T, aaguid []byte) *attestationVendor {
+	t.Helper()
+	root, rootKey := testCert(t, "Test Root", true, nil, nil, nil)
+	inter, interKey := testCert(t, "Test Intermediate", true, root, rootKey, nil)
+	leaf, key := testCert(t, "Test Batch", false, inter, interKey, aaguid)
+	return &attestationVendor{root: root, inter: inter, leaf: leaf, key: key}
+}
+
+func (v *attestationVendor) roots() *x509.CertPool {
+	p := x509.NewCertPool()
+	p.AddCert(v.root)
+	return p
+}
+
+// packed returns a packed attestation statement with the vendor's x5c chain.
+func (v *attestationVendor) packed(t *testing.T) func(authData, cdHash []byte) (string, cmap) {
+	return func(authData, cdHash []byte) (string, cmap) {
+		sig := es256Sign(t, v.key, concatBytes(authData, cdHash))
+		return "packed", cmap{{"alg", -7}, {"sig", sig}, {"x5c", []interface{}{v.leaf.Raw, v.inter.Raw}}}
+	}
+}
+
+// packedSelf signs with the credential key itself.
+func packedSelf(t *testing.T, a *softAuth) func(authData, cdHash []byte) (string, cmap) {
+	return func(authData, cdHash []byte) (string, cmap) {
+		return "packed", cmap{{"alg", a.alg}, {"sig", a.sign(t, concatBytes(authData, cdHash))}}
+	}
+}
+
+func registerWith(t *testing.T, a *softAuth, policy AttestationPolicy,
+	stmt func(authData, cdHash []byte) (string, cmap)) (*Credential, error) {
+	t.Helper()
+	pool, cfg := testWebAuthn(policy)
+	opts, err := beginRegistration(pool, cfg, "admin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return finishRegistration(pool, cfg, opts.SessionID, a.create(t, opts, flagUP|flagUV, stmt))
+}
+
+func TestAttestationFormats(t *testing.T) {
+	vendor := newAttestationVendor(t, testAAGUID)
+	other := newAttestationVendor(t, testAAGUID)
+	mismatched := newAttestationVendor(t, make([]byte, 16))
+
+	for _, tc := range []struct {
+		name   string
+		kind   string
+		stmt   func(a *softAuth) func(authData, cdHash []byte) (string, cmap)
+		roots  *x509.CertPool
+		want   string // AttestationType, or "" when registration must fail
+		errHas string
+	}{
+		{name: "none", kind: "ed25519", want: "none/none"},
+		{name: "packed self es256", kind: "es256", stmt: func(a *softAuth) func([]byte, []byte) (string, cmap) { return packedSelf(t, a) }, want: "packed/self"},
+		{name: "packed self ed25519", kind: "ed25519", stmt: func(a *softAuth) func([]byte, []byte) (string, cmap) { return packedSelf(t, a) }, want: "packed/self"},
+		{name: "packed x5c", kind: "es256", stmt: func(*softAuth) func([]byte, []byte) (string, cmap) { return vendor.packed(t) }, roots: vendor.roots(), want: "packed/basic"},
+		{name: "packed x5c without roots", kind: "es256", stmt: func(*softAuth) func([]byte, []byte) (string, cmap) { return vendor.packed(t) }, want: "packed/basic"},
+		{name: "packed x5c untrusted", kind: "es256", stmt: func(*softAuth) func([]byte, []byte) (string, cmap) { return other.packed(t) }, roots: vendor.roots(), errHas: "untrusted"},
+		{name: "packed x5c aaguid mismatch", kind: "es256", stmt: func(*softAuth) func([]byte, []byte) (string, cmap) { return mismatched.packed(t) }, errHas: "AAGUID"},
+		{name: "packed self wrong alg", kind: "es256", stmt: func(a *softAuth) func([]byte, []byte) (string, cmap) {
+			return func(authData, cdHash []byte) (string, cmap) {
+				return "packed", cmap{{"alg", -8}, {"sig", a.sign(t, concatBytes(authData, cdHash))}}
+			}
+		}, errHas: "alg"},
+		{name: "packed x5c bad signature", kind: "es256", stmt: func(*softAuth) func([]byte, []byte) (string, cmap) {
+			return func(authData, cdHash []byte) (string, cmap) {
+				sig := es256Sign(t, vendor.key, []byte("something else"))
+				return "packed", cmap{{"alg", -7}, {"sig", sig}, {"x5c", []interface{}{vendor.leaf.Raw}}}
+			}
+		}, errHas: "signature"},
+		{name: "none with statement", kind: "es256", stmt: func(*softAuth) func([]byte, []byte) (string, cmap) {
+			return func([]byte, []byte) (string, cmap) { return "none", cmap{{"sig", []byte{1}}} }
+		}, errHas: "empty"},
+		{name: "unknown format", kind: "es256", stmt: func(*softAuth) func([]byte, []byte) (string, cmap) {
+			return func([]byte, []byte) (string, cmap) { return "android-key", cmap{} }
+		}, errHas: "unsupported"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newSoftAuth(t, tc.kind)
+			a.aaguid = testAAGUID
+			var stmt func(authData, cdHash []byte) (string, cmap)
+			if tc.stmt != nil {
+				stmt = tc.stmt(a)
+			}
+			cred, err := registerWith(t, a, AttestationPolicy{Roots: tc.roots}, stmt)
+			if tc.want == "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errHas) {
+					t.Fatalf("err = %v, want %q", err, tc.errHas)
+				}
+				return
+			}
+			if err != nil || cred.AttestationType != tc.want || cred.AAGUID != formatAAGUID(testAAGUID) {
+				t.Fatalf("got %+v, %v", cred, err)
+			}
+		})
+	}
+}
+
+func TestAttestationPolicy(t *testing.T) {
+	vendor := newAttestationVendor(t, testAAGUID)
+	allowed := formatAAGUID(testAAGUID)
+	strict := AttestationPolicy{
+		AllowedAAGUIDs:     []string{strings.ToUpper(allowed)},
+		RequireAttestation: true,
+		Roots:              vendor.roots(),
+	}
+	if err := strict.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []AttestationPolicy{
+		{AllowedAAGUIDs: []string{allowed}},
+		{AllowedAAGUIDs: []string{allowed}, RequireAttestation: true},
+		{AllowedAAGUIDs: []string{allowed}, Roots: vendor.roots()},
+		{RequireAttestation: true},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Fatalf("Validate accepted %+v", bad)
+		}
+	}
+
+	register := func(policy AttestationPolicy, aaguid []byte, stmt func(*softAuth) func([]byte, []byte) (string, cmap)) error {
+		a := newSoftAuth(t, "es256")
+		a.aaguid = aaguid
+		var s func([]byte, []byte) (string, cmap)
+		if stmt != nil {
+			s = stmt(a)
+		}
+		_, err := registerWith(t, a, policy, s)
+		return err
+	}
+	x5c := func(*softAuth) func([]byte, []byte) (string, cmap) { return vendor.packed(t) }
+	self := func(a *softAuth) func([]byte, []byte) (string, cmap) { return packedSelf(t, a) }
+
+	if err := register(strict, testAAGUID, x5c); err != nil {
+		t.Fatalf("allowed authenticator: %v", err)
+	}
+	// claiming an allowed AAGUID without a certificate proves nothing
+	if err := register(strict, testAAGUID, nil); err == nil {
+		t.Fatal("none attestation passed the allowlist")
+	}
+	if err := register(strict, testAAGUID, self); err == nil {
+		t.Fatal("self attestation passed the allowlist")
+	}
+	strict.AllowedAAGUIDs = []string{formatAAGUID(make([]byte, 16))}
+	if err := register(strict, testAAGUID, x5c); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("disallowed authenticator: %v", err)
+	}
+
+	// a policy that skipped Validate still refuses claimed AAGUIDs
+	loose := AttestationPolicy{AllowedAAGUIDs: []string{allowed}}
+	if err := register(loose, testAAGUID, nil); err == nil {
+		t.Fatal("unvalidated allowlist accepted none attestation")
+	}
+	if err := register(loose, testAAGUID, x5c); err == nil {
+		t.Fatal("unvalidated allowlist accepted a chain without roots")
+	}
+
+	// RequireAttestation alone
+	required := AttestationPolicy{RequireAttestation: true, Roots: vendor.roots()}
+	if err := register(required, testAAGUID, nil); err == nil {
+		t.Fatal("none attestation accepted with RequireAttestation")
+	}
+	if err := register(required, testAAGUID, x5c); err != nil {
+		t.Fatalf("basic attestation with RequireAttestation: %v", err)
+	}
+	// without roots a self-made chain would do, so nothing is accepted
+	other := newAttestationVendor(t, testAAGUID)
+	if err := register(AttestationPolicy{RequireAttestation: true}, testAAGUID,
+		func(*softAuth) func([]byte, []byte) (string, cmap) { return other.packed(t) }); err == nil {
+		t.Fatal("unanchored chain accepted with RequireAttestation and no roots")
+	}
+	if err := register(required, testAAGUID,
+		func(*softAuth) func([]byte, []byte) (string, cmap) { return other.packed(t) }); err == nil {
+		t.Fatal("chain from another CA accepted")
+	}
+}
@@ -3,18 +3,47 @@ package authflow
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
 )
 
+// cborSimple is an unassigned CBOR simple value (major type 7).
+type cborSimple uint8
+
+// cborBreak is the "break" stop code that terminates an indefinite-length item.
+type cborBreak struct{}
+
+const cborMaxDepth = 32
+
 // cborDecode decodes one CBOR item from data.
 // Returns (decoded, bytesConsumed, error).
-// Supports: uint(0), negint(1), bytes(2), text(3), array(4), map(5), tag(6 — passes through).
+// Supports: uint(0), negint(1), bytes(2), text(3), array(4), map(5), tag(6 — passes through),
+// and simple/float(7). Strings, arrays and maps may use indefinite lengths.
+// false/true decode to bool, null/undefined to nil, floats to float64.
 func cborDecode(data []byte) (interface{}, int, error) {
+	v, n, err := cborDecodeItem(data, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, ok := v.(cborBreak); ok {
+		return nil, 0, fmt.Errorf("cbor: unexpected break")
+	}
+	return v, n, nil
+}
+
+func cborDecodeItem(data []byte, depth int) (interface{}, int, error) {
 	if len(data) == 0 {
 		return nil, 0, fmt.Errorf("cbor: empty input")
 	}
+	if depth > cborMaxDepth {
+		return nil, 0, fmt.Errorf("cbor: nesting too deep")
+	}
 	major := data[0] >> 5
 	info := data[0] & 0x1f
 
+	if info == 31 {
+		return cborDecodeIndefinite(data, major, depth)
+	}
+
 	var n uint64
 	head := 1
 	switch {
@@ -50,24 +79,27 @@ func cborDecode(data []byte) (interface{}, int, error) {
 	case 1:
 		return -int64(n) - 1, head, nil
 	case 2:
-		end := head + int(n)
-		if len(data) < end {
+		if n > uint64(len(data)-head) {
 			return nil, 0, fmt.Errorf("cbor: short bytes")
 		}
+		end := head + int(n)
 		b := make([]byte, n)
 		copy(b, data[head:end])
 		return b, end, nil
 	case 3:
-		end := head + int(n)
-		if len(data) < end {
+		if n > uint64(len(data)-head) {
 			return nil, 0, fmt.Errorf("cbor: short text")
 		}
+		end := head + int(n)
 		return string(data[head:end]), end, nil
 	case 4:
+		if n > uint64(len(data)) {
+			return nil, 0, fmt.Errorf("cbor: array too long")
+		}
 		arr := make([]interface{}, n)
 		off := head
 		for i := range arr {
-			v, sz, err := cborDecode(data[off:])
+			v, sz, err := cborDecodeElem(data[off:], depth)
 			if err != nil {
 				return nil, 0, err
 			}
@@ -75,27 +107,186 @@ func cborDecode(data []byte) (interface{}, int, error) {
 		}
 		return arr, off, nil
 	case 5:
+		if n > uint64(len(data)) {
+			return nil, 0, fmt.Errorf("cbor: map too long")
+		}
 		m := make(map[interface{}]interface{}, n)
 		off := head
 		for i := uint64(0); i < n; i++ {
-			k, ksz, err := cborDecode(data[off:])
+			k, ksz, err := cborDecodeElem(data[off:], depth)
 			if err != nil {
 				return nil, 0, err
 			}
 			off += ksz
-			v, vsz, err := cborDecode(data[off:])
+			v, vsz, err := cborDecodeElem(data[off:], depth)
 			if err != nil {
 				return nil, 0, err
 			}
-			m[k], off = v, off+vsz
+			if err := cborPut(m, k, v); err != nil {
+				return nil, 0, err
+			}
+			off += vsz
 		}
 		return m, off, nil
 	case 6: // tag — skip tag value, decode inner item
-		v, sz, err := cborDecode(data[head:])
+		v, sz, err := cborDecodeElem(data[head:], depth)
 		if err != nil {
 			return nil, 0, err
 		}
 		return v, head + sz, nil
+	case 7:
+		return cborDecodeSimple(info, n, head)
 	}
 	return nil, 0, fmt.Errorf("cbor: unsupported major type %d", major)
 }
+
+// cborDecodeElem decodes a nested item, rejecting a stray break.
+func cborDecodeElem(data []byte, depth int) (interface{}, int, error) {
+	v, n, err := cborDecodeItem(data, depth+1)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, ok := v.(cborBreak); ok {
+		return nil, 0, fmt.Errorf("cbor: unexpected break")
+	}
+	return v, n, nil
+}
+
+func cborDecodeIndefinite(data []byte, major byte, depth int) (interface{}, int, error) {
+	off := 1
+	// next decodes the following item, reporting whether it was the break code.
+	next := func() (interface{}, bool, error) {
+		if off >= len(data) {
+			return nil, false, fmt.Errorf("cbor: missing break")
+		}
+		v, sz, err := cborDecodeItem(data[off:], depth+1)
+		if err != nil {
+			return nil, false, err
+		}
+		off += sz
+		_, brk := v.(cborBreak)
+		return v, brk, nil
+	}
+	switch major {
+	case 2, 3:
+		var buf []byte
+		for {
+			if off < len(data) && data[off] != 0xff && data[off]>>5 != major {
+				return nil, 0, fmt.Errorf("cbor: bad chunk in indefinite string")
+			}
+			if off < len(data) && data[off]&0x1f == 31 && data[off] != 0xff {
+				return nil, 0, fmt.Errorf("cbor: nested indefinite string")
+			}
+			v, brk, err := next()
+			if err != nil {
+				return nil, 0, err
+			}
+			if brk {
+				break
+			}
+			switch chunk := v.(type) {
+			case []byte:
+				buf = append(buf, chunk...)
+			case string:
+				buf = append(buf, chunk...)
+			}
+		}
+		if major == 3 {
+			return string(buf), off, nil
+		}
+		if buf == nil {
+			buf = []byte{}
+		}
+		return buf, off, nil
+	case 4:
+		arr := []interface{}{}
+		for {
+			v, brk, err := next()
+			if err != nil {
+				return nil, 0, err
+			}
+			if brk {
+				return arr, off, nil
+			}
+			arr = append(arr, v)
+		}
+	case 5:
+		m := map[interface{}]interface{}{}
+		for {
+			k, brk, err := next()
+			if err != nil {
+				return nil, 0, err
+			}
+			if brk {
+				return m, off, nil
+			}
+			v, brk, err := next()
+			if err != nil {
+				return nil, 0, err
+			}
+			if brk {
+				return nil, 0, fmt.Errorf("cbor: map missing value")
+			}
+			if err := cborPut(m, k, v); err != nil {
+				return nil, 0, err
+			}
+		}
+	case 7:
+		return cborBreak{}, 1, nil
+	}
+	return nil, 0, fmt.Errorf("cbor: indefinite length not allowed for major type %d", major)
+}
+
+func cborDecodeSimple(info byte, n uint64, head int) (interface{}, int, error) {
+	switch info {
+	case 20:
+		return false, head, nil
+	case 21:
+		return true, head, nil
+	case 22, 23: // null, undefined
+		return nil, head, nil
+	case 24:
+		if n < 32 {
+			return nil, 0, fmt.Errorf("cbor: invalid simple value %d", n)
+		}
+		return cborSimple(n), head, nil
+	case 25:
+		return float64(cborHalf(uint16(n))), head, nil
+	case 26:
+		return float64(math.Float32frombits(uint32(n))), head, nil
+	case 27:
+		return math.Float64frombits(n), head, nil
+	}
+	return cborSimple(info), head, nil
+}
+
+// cborHalf converts an IEEE 754 half-precision float.
+func cborHalf(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h & 0x3ff)
+	switch exp {
+	case 0:
+		// subnormal: frac * 2^-24
+		f := float32(frac) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | frac<<13)
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | frac<<13)
+}
+
+// cborPut stores k=v, rejecting keys that cannot be map keys in Go.
+func cborPut(m map[interface{}]interface{}, k, v interface{}) error {
+	switch kk := k.(type) {
+	case []byte:
+		k = string(kk)
+	case []interface{}, map[interface{}]interface{}:
+		return fmt.Errorf("cbor: unsupported map key type %T", k)
+	}
+	m[k] = v
+	return nil
+}
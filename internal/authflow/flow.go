@@ -1,56 +1,93 @@
 package authflow
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 	"time"
 
 	"backend-go/internal/auth"
+	"backend-go/internal/auth/challenge"
+	"backend-go/internal/risk"
 
 	"github.com/gin-gonic/gin"
 )
 
 // Flow handles admin authentication: password+TOTP login, passkey ceremonies, and MFA management.
 type Flow struct {
-	cfg  Config
-	pool waPool
+	cfg   Config
+	pool  waPool
+	guard *guard
+	oidc  *oidcClient
+	pw    *passwords
+
+	cookiePath string // admin group path; session cookies are scoped to it
 }
 
-// New creates a new Flow.
+// New creates a new Flow. It registers the password-change cutoff for
+// JWTSecret with auth.SetRevocation, so every auth.Required on that secret
+// rejects tokens issued before the last password change.
 func New(cfg Config) *Flow {
 	f := &Flow{cfg: cfg}
-	f.pool.sess = make(map[string]*waChallenge)
+	f.pool.store = cfg.Challenges
+	if f.pool.store == nil {
+		f.pool.store = challenge.NewMemory()
+	}
+	f.guard = newGuard(&f.cfg)
+	f.pw = newPasswords(&f.cfg)
+	if len(cfg.JWTSecret) > 0 {
+		auth.SetRevocation(cfg.JWTSecret, f.sessionsValidAfter)
+	}
+	if cfg.OIDC.Enabled() {
+		f.oidc = newOIDCClient(cfg.OIDC, f.pool.store)
+	}
 	return f
 }
 
 // Mount attaches all auth routes to the /admin RouterGroup.
-// Unauthenticated: POST /login, POST /webauthn/login/begin, POST /webauthn/login/finish.
-// Authenticated: GET /me, GET /totp/status, POST /totp/setup, POST /totp/enable, DELETE /totp,
+// Unauthenticated: POST /login, POST /webauthn/login/begin, POST /webauthn/login/finish,
+//   GET /oidc/status, GET /oidc/start, GET /oidc/callback, GET /captcha.
+// Authenticated: GET /me, POST /logout, GET /totp/status, POST /totp/setup, POST /totp/enable, DELETE /totp,
 //   POST /webauthn/register/begin, POST /webauthn/register/finish,
-//   GET /webauthn/credentials, DELETE /webauthn/credentials/:id.
+//   GET /webauthn/credentials, DELETE /webauthn/credentials/:id,
+//   POST /reauth, POST /reauth/webauthn/begin, POST /reauth/webauthn/finish, POST /password.
+// DELETE /totp, DELETE /webauthn/credentials/:id and POST /password additionally require StepUp.
+// With Config.Session.Cookie the login endpoints set an HttpOnly cookie scoped
+// to the admin group's path instead of returning the JWT.
 func (f *Flow) Mount(admin *gin.RouterGroup) {
+	f.cookiePath = admin.BasePath()
 	admin.POST("/login", f.handleLogin)
 	admin.POST("/webauthn/login/begin", f.handleWALoginBegin)
 	admin.POST("/webauthn/login/finish", f.handleWALoginFinish)
+	admin.GET("/oidc/status", f.handleOIDCStatus)
+	admin.GET("/oidc/start", f.handleOIDCStart)
+	admin.GET("/oidc/callback", f.handleOIDCCallback)
+	admin.GET("/captcha", risk.ConfigHandler(f.cfg.Guard.Captcha))
 
 	g := admin.Group("", auth.Required(f.cfg.JWTSecret))
 	g.GET("/me", f.handleMe)
+	g.POST("/logout", f.handleLogout)
 	g.GET("/totp/status", f.handleTOTPStatus)
 	g.POST("/totp/setup", f.handleTOTPSetup)
 	g.POST("/totp/enable", f.handleTOTPEnable)
-	g.DELETE("/totp", f.handleTOTPDisable)
+	g.DELETE("/totp", f.StepUp(), f.handleTOTPDisable)
 	g.POST("/webauthn/register/begin", f.handleWARegisterBegin)
 	g.POST("/webauthn/register/finish", f.handleWARegisterFinish)
 	g.GET("/webauthn/credentials", f.handleWAListCredentials)
-	g.DELETE("/webauthn/credentials/:id", f.handleWADeleteCredential)
+	g.DELETE("/webauthn/credentials/:id", f.StepUp(), f.handleWADeleteCredential)
+	g.POST("/reauth", f.handleReauth)
+	g.POST("/reauth/webauthn/begin", f.handleReauthWABegin)
+	g.POST("/reauth/webauthn/finish", f.handleReauthWAFinish)
+	g.POST("/password", f.StepUp(), f.handleChangePassword)
 }
 
 // ---------- login ----------
 
 type loginPayload struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-	TOTPCode string `json:"totpCode"`
+	Username       string `json:"username"`
+	Password       string `json:"password"`
+	TOTPCode       string `json:"totpCode"`
+	TurnstileToken string `json:"turnstileToken"`
 }
 
 func (f *Flow) handleLogin(c *gin.Context) {
@@ -59,14 +96,18 @@ func (f *Flow) handleLogin(c *gin.Context) {
 		flowFail(c, http.StatusBadRequest, "invalid body")
 		return
 	}
-	if f.cfg.AdminPasswordHash == "" || len(f.cfg.JWTSecret) < 16 {
+	if hash, _ := f.pw.get(f.cfg.AdminUsername); hash == "" || len(f.cfg.JWTSecret) < 16 {
 		flowFail(c, http.StatusServiceUnavailable, "admin not configured")
 		return
 	}
-	if p.Username != f.cfg.AdminUsername || !auth.VerifyPassword(f.cfg.AdminPasswordHash, p.Password) {
-		flowFail(c, http.StatusUnauthorized, "invalid credentials")
+	if !f.guard.admit(c, "password", p.Username, p.TurnstileToken) {
 		return
 	}
+	if !f.checkPassword(p.Username, p.Password) {
+		f.guard.reject(c, "password", p.Username, "bad password", "invalid credentials")
+		return
+	}
+	amr := []string{auth.AMRPassword}
 	if f.cfg.Store != nil {
 		secret, enabled, _ := f.cfg.Store.GetTOTP(p.Username)
 		if enabled {
@@ -75,21 +116,38 @@ func (f *Flow) handleLogin(c *gin.Context) {
 				return
 			}
 			if !VerifyTOTP(secret, p.TOTPCode) {
-				flowFail(c, http.StatusUnauthorized, "invalid TOTP code")
+				f.guard.reject(c, "totp", p.Username, "bad totp", "invalid TOTP code")
 				return
 			}
+			amr = append(amr, auth.AMROTP, auth.AMRMFA)
 		}
 	}
-	tok, exp, err := auth.IssueToken(f.cfg.JWTSecret, p.Username, f.cfg.JWTTTL)
+	f.guard.succeed(c, p.Username)
+	f.issueToken(c, p.Username, amr...)
+}
+
+// issueToken writes the login response carrying a fresh JWT.
+func (f *Flow) issueToken(c *gin.Context, username string, amr ...string) {
+	tok, exp, err := auth.IssueToken(f.cfg.JWTSecret, username, f.cfg.JWTTTL, amr...)
 	if err != nil {
 		flowFail(c, http.StatusInternalServerError, "token error")
 		return
 	}
-	flowOK(c, gin.H{"token": tok, "expiresAt": exp.Format(time.RFC3339), "username": p.Username})
+	data := f.sessionData(c, username, tok, exp)
+	if f.mustChangePassword(username) {
+		data["mustChangePassword"] = true
+	}
+	flowOK(c, data)
 }
 
 func (f *Flow) handleMe(c *gin.Context) {
-	flowOK(c, gin.H{"username": c.GetString(auth.ContextKeySubject)})
+	data := gin.H{"username": c.GetString(auth.ContextKeySubject)}
+	if session := c.GetString(auth.ContextKeyCookie); session != "" {
+		// lets the SPA recover its CSRF token after a reload
+		data["session"] = true
+		data["csrfToken"] = auth.CSRFToken(f.cfg.JWTSecret, session)
+	}
+	flowOK(c, data)
 }
 
 // ---------- TOTP ----------
@@ -223,18 +281,30 @@ func (f *Flow) handleWADeleteCredential(c *gin.Context) {
 
 type waLoginBeginPayload struct {
 	Username string `json:"username"`
+	// Discoverable requests a usernameless ceremony: no allowCredentials are
+	// sent and the admin is identified by the passkey's user handle.
+	Discoverable bool `json:"discoverable"`
 }
 
 func (f *Flow) handleWALoginBegin(c *gin.Context) {
 	var p waLoginBeginPayload
 	_ = c.ShouldBindJSON(&p)
-	if p.Username == "" {
-		p.Username = f.cfg.AdminUsername
-	}
 	if f.cfg.Store == nil {
 		flowFail(c, http.StatusBadRequest, "passkeys not configured")
 		return
 	}
+	if p.Discoverable {
+		opts, err := beginLogin(&f.pool, &f.cfg, "", nil)
+		if err != nil {
+			flowFail(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		flowOK(c, opts)
+		return
+	}
+	if p.Username == "" {
+		p.Username = f.cfg.AdminUsername
+	}
 	creds, err := f.cfg.Store.GetCredentials(p.Username)
 	if err != nil || len(creds) == 0 {
 		flowFail(c, http.StatusBadRequest, "no passkeys registered")
@@ -249,8 +319,9 @@ func (f *Flow) handleWALoginBegin(c *gin.Context) {
 }
 
 type waLoginFinishPayload struct {
-	SessionID  string                      `json:"sessionId"`
-	Credential CredentialAssertionResponse `json:"credential"`
+	SessionID      string                      `json:"sessionId"`
+	Credential     CredentialAssertionResponse `json:"credential"`
+	TurnstileToken string                      `json:"turnstileToken"`
 }
 
 func (f *Flow) handleWALoginFinish(c *gin.Context) {
@@ -259,18 +330,39 @@ func (f *Flow) handleWALoginFinish(c *gin.Context) {
 		flowFail(c, http.StatusBadRequest, "invalid body")
 		return
 	}
-	cred, err := finishLogin(&f.pool, &f.cfg, p.SessionID, &p.Credential, f.cfg.Store.GetCredentials)
-	if err != nil {
-		flowFail(c, http.StatusUnauthorized, err.Error())
+	if f.cfg.Store == nil {
+		flowFail(c, http.StatusBadRequest, "passkeys not configured")
 		return
 	}
-	_ = f.cfg.Store.UpdateCounter(cred.ID, cred.Counter)
-	tok, exp, err := auth.IssueToken(f.cfg.JWTSecret, cred.Username, f.cfg.JWTTTL)
-	if err != nil {
-		flowFail(c, http.StatusInternalServerError, "token error")
+	if !f.guard.admit(c, "webauthn", "", p.TurnstileToken) {
+		return
+	}
+	cred, ok := f.verifyAssertion(c, "", p.SessionID, &p.Credential)
+	if !ok {
 		return
 	}
-	flowOK(c, gin.H{"token": tok, "expiresAt": exp.Format(time.RFC3339), "username": cred.Username})
+	f.guard.succeed(c, cred.Username)
+	f.issueToken(c, cred.Username, auth.AMRPasskey)
+}
+
+// verifyAssertion finishes a passkey ceremony and persists the new sign state.
+// On failure it records the attempt and writes the response.
+func (f *Flow) verifyAssertion(c *gin.Context, username, sessionID string, resp *CredentialAssertionResponse) (*Credential, bool) {
+	cred, err := finishLogin(&f.pool, &f.cfg, sessionID, resp, f.cfg.Store.GetCredentials)
+	if errors.Is(err, ErrCounterRegression) {
+		_ = f.cfg.Store.FlagCredential(cred.ID, "sign counter regressed at "+time.Now().UTC().Format(time.RFC3339))
+		f.guard.reject(c, "webauthn", cred.Username, "sign counter regression on "+cred.ID, "passkey rejected: possible cloned authenticator")
+		return nil, false
+	}
+	if err == nil && username != "" && cred.Username != username {
+		err = errors.New("webauthn: credential belongs to another user")
+	}
+	if err != nil {
+		f.guard.reject(c, "webauthn", username, err.Error(), err.Error())
+		return nil, false
+	}
+	_ = f.cfg.Store.UpdateSignState(cred.ID, cred.Counter, cred.BackupState)
+	return cred, true
 }
 
 // ---------- response helpers ----------
@@ -282,3 +374,7 @@ func flowOK(c *gin.Context, data any) {
 func flowFail(c *gin.Context, status int, msg string) {
 	c.JSON(status, gin.H{"code": status, "message": msg, "data": nil})
 }
+
+func flowFailData(c *gin.Context, status int, msg string, data any) {
+	c.JSON(status, gin.H{"code": status, "message": msg, "data": data})
+}
@@ -0,0 +1,320 @@
+package authflow
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backend-go/internal/risk"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GuardConfig controls brute-force protection on the login endpoints.
+// Zero values fall back to the defaults noted on each field.
+type GuardConfig struct {
+	FreeAttempts   int           // failures allowed before backoff kicks in (default 3)
+	BaseDelay      time.Duration // first backoff step, doubled on every further failure (default 2s)
+	MaxLockout     time.Duration // backoff cap, i.e. the temporary lockout length (default 15m)
+	ResetAfter     time.Duration // failure counter is forgotten after this much quiet time (default 24h)
+	TurnstileAfter int           // require a captcha token after N failures; 0 disables
+	Captcha        risk.Verifier // nil or disabled: never required
+}
+
+// GuardConfigFromEnv reads <PREFIX>_LOGIN_* variables, e.g. ROUNDNFC_LOGIN_FREE_ATTEMPTS.
+// The captcha is Turnstile with <PREFIX>_LOGIN_TURNSTILE_SECRET when set, otherwise
+// the module-wide risk.VerifierFromEnv(<PREFIX>); modules that have a shared
+// challenge store may replace it so PoW challenges work across replicas.
+func GuardConfigFromEnv(envPrefix string) GuardConfig {
+	atoi := func(name string) int {
+		n, _ := strconv.Atoi(strings.TrimSpace(os.Getenv(envPrefix + name)))
+		return n
+	}
+	var captcha risk.Verifier
+	if secret := strings.TrimSpace(os.Getenv(envPrefix + "_LOGIN_TURNSTILE_SECRET")); secret != "" {
+		captcha = risk.NewTurnstile(secret)
+	} else if v, err := risk.VerifierFromEnv(envPrefix, nil); err != nil {
+		log.Printf("[authflow] login captcha disabled: %v", err)
+	} else {
+		captcha = v
+	}
+	return GuardConfig{
+		FreeAttempts:   atoi("_LOGIN_FREE_ATTEMPTS"),
+		BaseDelay:      time.Duration(atoi("_LOGIN_BASE_DELAY_SECONDS")) * time.Second,
+		MaxLockout:     time.Duration(atoi("_LOGIN_MAX_LOCKOUT_MINUTES")) * time.Minute,
+		ResetAfter:     time.Duration(atoi("_LOGIN_RESET_HOURS")) * time.Hour,
+		TurnstileAfter: atoi("_LOGIN_TURNSTILE_AFTER"),
+		Captcha:        captcha,
+	}
+}
+
+func (g GuardConfig) withDefaults() GuardConfig {
+	if g.FreeAttempts <= 0 {
+		g.FreeAttempts = 3
+	}
+	if g.BaseDelay <= 0 {
+		g.BaseDelay = 2 * time.Second
+	}
+	if g.MaxLockout <= 0 {
+		g.MaxLockout = 15 * time.Minute
+	}
+	if g.ResetAfter <= 0 {
+		g.ResetAfter = 24 * time.Hour
+	}
+	return g
+}
+
+// LoginAttempt is the persisted throttling state for one key ("ip:…" or "user:…").
+type LoginAttempt struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// AttemptStore is optionally implemented by Store so throttling survives restarts.
+// When the Store does not implement it, attempts are tracked in memory.
+type AttemptStore interface {
+	GetLoginAttempt(key string) (*LoginAttempt, error)
+	// UpdateLoginAttempt runs fn on the current state of key (a zero
+	// LoginAttempt with Key set when there is none) and saves it when fn
+	// returns true. Read, fn and write are one atomic step, also across
+	// processes sharing the store.
+	UpdateLoginAttempt(key string, fn func(a *LoginAttempt) bool) (*LoginAttempt, error)
+	DeleteLoginAttempt(key string) error
+}
+
+// LoginEvent is emitted on failed and throttled logins so deployments can alert on them.
+type LoginEvent struct {
+	Kind       string        `json:"kind"`   // "login_failed" | "login_throttled" | "password_changed"
+	Method     string        `json:"method"` // "password" | "totp" | "webauthn"
+	Username   string        `json:"username,omitempty"`
+	IP         string        `json:"ip"`
+	Failures   int           `json:"failures"`
+	RetryAfter time.Duration `json:"retryAfter"`
+	Reason     string        `json:"reason,omitempty"`
+	At         time.Time     `json:"at"`
+}
+
+func logLoginEvent(e LoginEvent) {
+	log.Printf("[authflow] %s method=%s user=%q ip=%s failures=%d retry_after=%s reason=%q",
+		e.Kind, e.Method, e.Username, e.IP, e.Failures, e.RetryAfter, e.Reason)
+}
+
+type guard struct {
+	cfg   GuardConfig
+	store AttemptStore
+	emit  func(LoginEvent)
+}
+
+func newGuard(cfg *Config) *guard {
+	g := &guard{cfg: cfg.Guard.withDefaults(), emit: cfg.OnLoginEvent}
+	if as, ok := cfg.Store.(AttemptStore); ok {
+		g.store = as
+	} else {
+		g.store = &memAttempts{m: map[string]*LoginAttempt{}}
+	}
+	if g.emit == nil {
+		g.emit = logLoginEvent
+	}
+	return g
+}
+
+func guardKeys(ip, username string) []string {
+	keys := []string{"ip:" + ip}
+	if u := strings.ToLower(strings.TrimSpace(username)); u != "" {
+		keys = append(keys, "user:"+u)
+	}
+	return keys
+}
+
+// status returns the longest remaining lockout and the highest failure count across keys.
+func (g *guard) status(keys []string) (time.Duration, int) {
+	now := time.Now()
+	var wait time.Duration
+	var failures int
+	for _, k := range keys {
+		a, err := g.store.GetLoginAttempt(k)
+		if err != nil || a == nil {
+			continue
+		}
+		if now.Sub(a.LastFailure) > g.cfg.ResetAfter {
+			continue
+		}
+		if a.Failures > failures {
+			failures = a.Failures
+		}
+		if d := a.LockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, failures
+}
+
+// charge counts an attempt on every key before the credentials are checked,
+// so parallel requests cannot all pass the lockout check first. A key that
+// is already locked is left alone and the remaining lockout returned; the
+// attempt is admitted when none is and every key was updated. succeed
+// clears the charge.
+func (g *guard) charge(keys []string) time.Duration {
+	now := time.Now()
+	var wait time.Duration
+	for _, k := range keys {
+		_, err := g.store.UpdateLoginAttempt(k, func(a *LoginAttempt) bool {
+			if d := a.LockedUntil.Sub(now); d > 0 && now.Sub(a.LastFailure) <= g.cfg.ResetAfter {
+				if d > wait {
+					wait = d
+				}
+				return false
+			}
+			if now.Sub(a.LastFailure) > g.cfg.ResetAfter {
+				a.Failures = 0
+			}
+			a.Failures++
+			a.LastFailure = now
+			a.LockedUntil = time.Time{}
+			if d := g.delay(a.Failures); d > 0 {
+				a.LockedUntil = now.Add(d)
+			}
+			return true
+		})
+		if err != nil {
+			// fail closed: an attempt that cannot be counted is not admitted
+			log.Printf("[authflow] save login attempt %s: %v", k, err)
+			wait = max(wait, time.Second)
+		}
+	}
+	return wait
+}
+
+func (g *guard) delay(failures int) time.Duration {
+	over := failures - g.cfg.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+	d := float64(g.cfg.BaseDelay) * math.Pow(2, float64(over-1))
+	if d > float64(g.cfg.MaxLockout) {
+		return g.cfg.MaxLockout
+	}
+	return time.Duration(d)
+}
+
+func (g *guard) reset(keys []string) {
+	for _, k := range keys {
+		_ = g.store.DeleteLoginAttempt(k)
+	}
+}
+
+func (g *guard) needsTurnstile(failures int) bool {
+	return g.cfg.TurnstileAfter > 0 && g.cfg.Captcha != nil && g.cfg.Captcha.Enabled() && failures >= g.cfg.TurnstileAfter
+}
+
+// admit runs before credential checks. It answers 429 while locked out,
+// enforces the captcha once enough failures accumulated and then charges the
+// attempt (see charge). Returns false when the response has already been
+// written.
+func (g *guard) admit(c *gin.Context, method, username, turnstileToken string) bool {
+	keys := guardKeys(c.ClientIP(), username)
+	wait, failures := g.status(keys)
+	if wait > 0 {
+		g.throttled(c, method, username, failures, wait)
+		return false
+	}
+	if g.needsTurnstile(failures) {
+		ok, err := risk.Check(c, g.cfg.Captcha, turnstileToken)
+		if err != nil {
+			log.Printf("[authflow] %v", err)
+			flowFail(c, http.StatusBadGateway, "captcha verify failed")
+			return false
+		}
+		if !ok {
+			flowFailData(c, http.StatusForbidden, "captcha verification required",
+				gin.H{"needsTurnstile": true, "captchaProvider": g.cfg.Captcha.Provider()})
+			return false
+		}
+	}
+	if wait := g.charge(keys); wait > 0 {
+		g.throttled(c, method, username, failures, wait)
+		return false
+	}
+	return true
+}
+
+func (g *guard) throttled(c *gin.Context, method, username string, failures int, wait time.Duration) {
+	g.emit(LoginEvent{Kind: "login_throttled", Method: method, Username: username, IP: c.ClientIP(),
+		Failures: failures, RetryAfter: wait, At: time.Now()})
+	retryAfter(c, wait)
+}
+
+// reject emits an event for the attempt admit charged and writes the 401
+// (or 429 once locked).
+func (g *guard) reject(c *gin.Context, method, username, reason, msg string) {
+	wait, failures := g.status(guardKeys(c.ClientIP(), username))
+	g.emit(LoginEvent{Kind: "login_failed", Method: method, Username: username, IP: c.ClientIP(),
+		Failures: failures, RetryAfter: wait, Reason: reason, At: time.Now()})
+	if wait > 0 {
+		retryAfter(c, wait)
+		return
+	}
+	var data gin.H
+	if g.needsTurnstile(failures) {
+		data = gin.H{"needsTurnstile": true}
+	}
+	flowFailData(c, http.StatusUnauthorized, msg, data)
+}
+
+func (g *guard) succeed(c *gin.Context, username string) {
+	g.reset(guardKeys(c.ClientIP(), username))
+}
+
+func retryAfter(c *gin.Context, wait time.Duration) {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(secs))
+	flowFailData(c, http.StatusTooManyRequests, "too many attempts", gin.H{"retryAfter": secs})
+}
+
+// memAttempts is the in-process fallback used when Store does not persist attempts.
+type memAttempts struct {
+	mu sync.Mutex
+	m  map[string]*LoginAttempt
+}
+
+func (s *memAttempts) GetLoginAttempt(key string) (*LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.m[key]
+	if !ok {
+		return nil, nil
+	}
+	cp := *a
+	return &cp, nil
+}
+
+func (s *memAttempts) UpdateLoginAttempt(key string, fn func(a *LoginAttempt) bool) (*LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := LoginAttempt{Key: key}
+	if cur, ok := s.m[key]; ok {
+		a = *cur
+	}
+	if fn(&a) {
+		cp := a
+		s.m[key] = &cp
+	}
+	return &a, nil
+}
+
+func (s *memAttempts) DeleteLoginAttempt(key string) error {
+	s.mu.Lock()
+	delete(s.m, key)
+	s.mu.Unlock()
+	return nil
+}
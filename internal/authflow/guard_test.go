@@ -0,0 +1,97 @@
+package authflow
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"backend-go/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+func guardTestRouter(t *testing.T, guard GuardConfig) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	hash, err := auth.HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := New(Config{
+		AdminUsername:     "admin",
+		AdminPasswordHash: hash,
+		JWTSecret:         []byte(oidcTestSecret),
+		JWTTTL:            time.Hour,
+		Guard:             guard,
+		OnLoginEvent:      func(LoginEvent) {},
+	})
+	r := gin.New()
+	f.Mount(r.Group("/admin"))
+	return r
+}
+
+func login(r http.Handler, password string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/login",
+		strings.NewReader(`{"username":"admin","password":"`+password+`"}`)))
+	return w
+}
+
+func TestGuardLockout(t *testing.T) {
+	r := guardTestRouter(t, GuardConfig{FreeAttempts: 2, BaseDelay: time.Minute})
+	for i := 0; i < 3; i++ {
+		if w := login(r, "wrong"); i < 2 && w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: %d", i, w.Code)
+		} else if i == 2 && (w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60") {
+			t.Fatalf("third failure: %d retry-after=%q", w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+	// locked: even the right password is refused
+	if w := login(r, "correct horse battery"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("while locked: %d", w.Code)
+	}
+}
+
+func TestGuardSuccessResets(t *testing.T) {
+	r := guardTestRouter(t, GuardConfig{FreeAttempts: 2, BaseDelay: time.Minute})
+	login(r, "wrong")
+	login(r, "wrong")
+	if w := login(r, "correct horse battery"); w.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", w.Code, w.Body.String())
+	}
+	for i := 0; i < 2; i++ {
+		if w := login(r, "wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("counter not reset, attempt %d: %d", i, w.Code)
+		}
+	}
+}
+
+// Parallel wrong guesses must not all slip past the lockout check before
+// the first failure is recorded.
+func TestGuardParallelAttempts(t *testing.T) {
+	r := guardTestRouter(t, GuardConfig{FreeAttempts: 3, BaseDelay: time.Minute})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	codes := map[int]int{}
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := login(r, "wrong")
+			mu.Lock()
+			codes[w.Code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	// FreeAttempts guesses plus the one that triggers the lockout
+	if guesses := codes[http.StatusUnauthorized] + codes[http.StatusOK]; guesses > 4 {
+		t.Fatalf("%d guesses checked in parallel: %v", guesses, codes)
+	}
+	if codes[http.StatusTooManyRequests] < 36 {
+		t.Fatalf("codes: %v", codes)
+	}
+}
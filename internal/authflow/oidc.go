@@ -0,0 +1,505 @@
+package authflow
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"backend-go/internal/auth"
+	"backend-go/internal/auth/challenge"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCConfig enables admin sign-in through an external OpenID Connect provider
+// (authorization code flow with PKCE). A successful login is mapped to the
+// admin account only when the ID token's subject or verified email is allow-listed.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty for public clients (PKCE only)
+	RedirectURL  string // must point at <prefix>/admin/oidc/callback
+	Scopes       []string
+
+	AllowedSubjects []string
+	AllowedEmails   []string // matched case-insensitively; requires email_verified
+
+	// PostLoginRedirect is where the browser lands after the callback; the
+	// token is appended as a URL fragment (#token=…&expiresAt=…&username=…,
+	// or #session=true&csrfToken=… in cookie session mode).
+	// When empty the callback answers JSON instead.
+	PostLoginRedirect string
+
+	HTTPClient *http.Client // defaults to a client with a 10s timeout
+}
+
+// OIDCConfigFromEnv reads <PREFIX>_OIDC_* variables, e.g. ROUNDNFC_OIDC_ISSUER.
+// List values are comma separated.
+func OIDCConfigFromEnv(envPrefix string) OIDCConfig {
+	env := func(name string) string { return strings.TrimSpace(os.Getenv(envPrefix + name)) }
+	list := func(name string) []string {
+		var out []string
+		for _, v := range strings.Split(env(name), ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				out = append(out, v)
+			}
+		}
+		return out
+	}
+	return OIDCConfig{
+		Issuer:            strings.TrimRight(env("_OIDC_ISSUER"), "/"),
+		ClientID:          env("_OIDC_CLIENT_ID"),
+		ClientSecret:      env("_OIDC_CLIENT_SECRET"),
+		RedirectURL:       env("_OIDC_REDIRECT_URL"),
+		Scopes:            list("_OIDC_SCOPES"),
+		AllowedSubjects:   list("_OIDC_ALLOWED_SUBJECTS"),
+		AllowedEmails:     list("_OIDC_ALLOWED_EMAILS"),
+		PostLoginRedirect: env("_OIDC_POST_LOGIN_REDIRECT"),
+	}
+}
+
+// Enabled reports whether enough is configured to offer OIDC sign-in.
+func (o OIDCConfig) Enabled() bool {
+	return o.Issuer != "" && o.ClientID != "" && o.RedirectURL != "" &&
+		(len(o.AllowedSubjects) > 0 || len(o.AllowedEmails) > 0)
+}
+
+const (
+	oidcStateTTL  = 10 * time.Minute
+	oidcCacheTTL  = time.Hour
+	oidcClockSkew = time.Minute
+)
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcState struct {
+	Verifier string `json:"v"`
+	Nonce    string `json:"n"`
+}
+
+// oidcIDClaims are the ID token claims we look at.
+type oidcIDClaims struct {
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified any    `json:"email_verified"` // some providers send "true"
+	AZP           string `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// oidcClient caches the discovery document and JWKS of one provider.
+type oidcClient struct {
+	cfg   OIDCConfig
+	http  *http.Client
+	store challenge.Store
+
+	mu       sync.Mutex
+	disc     *oidcDiscovery
+	discAt   time.Time
+	keys     map[string]any
+	keysAt   time.Time
+	keysList []any // keys without a kid
+}
+
+func newOIDCClient(cfg OIDCConfig, store challenge.Store) *oidcClient {
+	hc := cfg.HTTPClient
+	if hc == nil {
+		hc = &http.Client{Timeout: 10 * time.Second}
+	}
+	cfg.Issuer = strings.TrimRight(cfg.Issuer, "/")
+	return &oidcClient{cfg: cfg, http: hc, store: store}
+}
+
+func (o *oidcClient) discovery(ctx context.Context) (*oidcDiscovery, error) {
+	o.mu.Lock()
+	if o.disc != nil && time.Since(o.discAt) < oidcCacheTTL {
+		d := o.disc
+		o.mu.Unlock()
+		return d, nil
+	}
+	o.mu.Unlock()
+	var d oidcDiscovery
+	if err := o.getJSON(ctx, o.cfg.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimRight(d.Issuer, "/") != o.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer mismatch %q", d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: missing endpoints")
+	}
+	o.mu.Lock()
+	o.disc, o.discAt = &d, time.Now()
+	o.mu.Unlock()
+	return &d, nil
+}
+
+func (o *oidcClient) getJSON(ctx context.Context, u string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := o.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+// authURL stores a fresh state/nonce/PKCE verifier and returns the provider URL.
+func (o *oidcClient) authURL(ctx context.Context) (string, error) {
+	d, err := o.discovery(ctx)
+	if err != nil {
+		return "", err
+	}
+	state, err := challenge.NewKey(24)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := challenge.NewKey(24)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := challenge.NewKey(32)
+	if err != nil {
+		return "", err
+	}
+	b, _ := json.Marshal(oidcState{Verifier: verifier, Nonce: nonce})
+	if err := o.store.Put(ctx, "oidc:"+state, b, oidcStateTTL); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	scopes := o.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", o.cfg.ClientID)
+	q.Set("redirect_uri", o.cfg.RedirectURL)
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// exchange redeems the code and returns the validated ID token claims.
+func (o *oidcClient) exchange(ctx context.Context, state, code string) (*oidcIDClaims, error) {
+	if state == "" || code == "" {
+		return nil, errors.New("missing state or code")
+	}
+	raw, ok, err := o.store.Take(ctx, "oidc:"+state)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("unknown or expired state")
+	}
+	var st oidcState
+	if err := json.Unmarshal(raw, &st); err != nil {
+		return nil, err
+	}
+	d, err := o.discovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.cfg.RedirectURL},
+		"code_verifier": {st.Verifier},
+	}
+	if o.cfg.ClientSecret == "" {
+		form.Set("client_id", o.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if o.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(o.cfg.ClientID), url.QueryEscape(o.cfg.ClientSecret))
+	}
+	resp, err := o.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	var tr struct {
+		IDToken   string `json:"id_token"`
+		Error     string `json:"error"`
+		ErrorDesc string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tr); err != nil {
+		return nil, fmt.Errorf("token endpoint: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.Error != "" {
+		return nil, fmt.Errorf("token endpoint: %s %s %s", resp.Status, tr.Error, tr.ErrorDesc)
+	}
+	if tr.IDToken == "" {
+		return nil, errors.New("token endpoint: no id_token")
+	}
+	return o.verifyIDToken(ctx, d, tr.IDToken, st.Nonce)
+}
+
+func (o *oidcClient) verifyIDToken(ctx context.Context, d *oidcDiscovery, raw, nonce string) (*oidcIDClaims, error) {
+	claims := &oidcIDClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return o.key(ctx, d, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(o.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(oidcClockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("id_token: %w", err)
+	}
+	if len(claims.Audience) > 1 && claims.AZP != o.cfg.ClientID {
+		return nil, errors.New("id_token: azp mismatch")
+	}
+	if claims.Nonce == "" || claims.Nonce != nonce {
+		return nil, errors.New("id_token: nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id_token: missing sub")
+	}
+	return claims, nil
+}
+
+// key returns the JWKS key for kid, refetching once when it is unknown.
+func (o *oidcClient) key(ctx context.Context, d *oidcDiscovery, kid string) (any, error) {
+	lookup := func() any {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		if time.Since(o.keysAt) > oidcCacheTTL {
+			return nil
+		}
+		if kid == "" {
+			if len(o.keysList) == 1 {
+				return o.keysList[0]
+			}
+			return nil
+		}
+		return o.keys[kid]
+	}
+	if k := lookup(); k != nil {
+		return k, nil
+	}
+	var set struct {
+		Keys []jwkKey `json:"keys"`
+	}
+	if err := o.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	keys := map[string]any{}
+	var all []any
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		all = append(all, pub)
+		if k.Kid != "" {
+			keys[k.Kid] = pub
+		}
+	}
+	o.mu.Lock()
+	o.keys, o.keysList, o.keysAt = keys, all, time.Now()
+	o.mu.Unlock()
+	if k := lookup(); k != nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("jwks: no key for kid %q", kid)
+}
+
+type jwkKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwkKey) publicKey() (any, error) {
+	dec := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err1 := dec(k.N)
+		e, err2 := dec(k.E)
+		if err1 != nil || err2 != nil || len(n) == 0 || len(e) == 0 {
+			return nil, errors.New("jwk: bad RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("jwk: unsupported curve %q", k.Crv)
+		}
+		x, err1 := dec(k.X)
+		y, err2 := dec(k.Y)
+		if err1 != nil || err2 != nil {
+			return nil, errors.New("jwk: bad EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := dec(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("jwk: bad OKP key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("jwk: unsupported kty %q", k.Kty)
+}
+
+// allowed maps the ID token to the admin account via the allow-lists.
+func (o *oidcClient) allowed(c *oidcIDClaims) bool {
+	for _, s := range o.cfg.AllowedSubjects {
+		if s == c.Subject {
+			return true
+		}
+	}
+	if c.Email == "" || !truthyClaim(c.EmailVerified) {
+		return false
+	}
+	for _, e := range o.cfg.AllowedEmails {
+		if strings.EqualFold(e, c.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+func truthyClaim(v any) bool {
+	switch t := v.(type) {
+	case bool:
+		return t
+	case string:
+		return strings.EqualFold(t, "true")
+	}
+	return false
+}
+
+// ---------- handlers ----------
+
+func (f *Flow) handleOIDCStatus(c *gin.Context) {
+	flowOK(c, gin.H{"enabled": f.oidc != nil})
+}
+
+func (f *Flow) handleOIDCStart(c *gin.Context) {
+	if f.oidc == nil {
+		flowFail(c, http.StatusNotFound, "oidc not configured")
+		return
+	}
+	if !f.guard.admit(c, "oidc", "", "") {
+		return
+	}
+	u, err := f.oidc.authURL(c.Request.Context())
+	if err != nil {
+		flowFail(c, http.StatusBadGateway, err.Error())
+		return
+	}
+	c.Redirect(http.StatusFound, u)
+}
+
+func (f *Flow) handleOIDCCallback(c *gin.Context) {
+	if f.oidc == nil {
+		flowFail(c, http.StatusNotFound, "oidc not configured")
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		f.oidcFail(c, http.StatusUnauthorized, "provider error: "+e)
+		return
+	}
+	claims, err := f.oidc.exchange(c.Request.Context(), c.Query("state"), c.Query("code"))
+	if err != nil {
+		// handleOIDCStart charged the attempt already
+		f.guard.emit(LoginEvent{Kind: "login_failed", Method: "oidc", IP: c.ClientIP(), Reason: err.Error(), At: time.Now()})
+		f.oidcFail(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if f.cfg.AdminUsername == "" || len(f.cfg.JWTSecret) < 16 {
+		f.oidcFail(c, http.StatusServiceUnavailable, "admin not configured")
+		return
+	}
+	if !f.oidc.allowed(claims) {
+		f.guard.emit(LoginEvent{Kind: "login_failed", Method: "oidc", Username: claims.Subject, IP: c.ClientIP(),
+			Reason: "subject/email not allow-listed", At: time.Now()})
+		f.oidcFail(c, http.StatusForbidden, "account not allowed")
+		return
+	}
+	f.guard.succeed(c, f.cfg.AdminUsername)
+	tok, exp, err := auth.IssueToken(f.cfg.JWTSecret, f.cfg.AdminUsername, f.cfg.JWTTTL, auth.AMRFederated)
+	if err != nil {
+		f.oidcFail(c, http.StatusInternalServerError, "token error")
+		return
+	}
+	data := f.sessionData(c, f.cfg.AdminUsername, tok, exp)
+	if dest := f.oidc.cfg.PostLoginRedirect; dest != "" {
+		frag := url.Values{}
+		for k, v := range data {
+			frag.Set(k, fmt.Sprint(v))
+		}
+		c.Redirect(http.StatusFound, dest+"#"+frag.Encode())
+		return
+	}
+	flowOK(c, data)
+}
+
+// oidcFail sends the browser back to the SPA with #oidc_error=…, or answers JSON.
+func (f *Flow) oidcFail(c *gin.Context, status int, msg string) {
+	if dest := f.oidc.cfg.PostLoginRedirect; dest != "" {
+		c.Redirect(http.StatusFound, dest+"#"+url.Values{"oidc_error": {msg}}.Encode())
+		return
+	}
+	flowFail(c, status, msg)
+}
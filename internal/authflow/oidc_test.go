@@ -0,0 +1,185 @@
+package authflow
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"backend-go/internal/auth"
+	"backend-go/internal/authflow/oidctest"
+
+	"github.com/gin-gonic/gin"
+)
+
+const oidcTestSecret = "0123456789abcdef0123456789abcdef"
+
+// newOIDCApp mounts a Flow on /admin backed by the mock provider and returns
+// the app base URL.
+func newOIDCApp(t *testing.T, idp *oidctest.Server, mutate func(*OIDCConfig)) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := "http://" + l.Addr().String()
+	oc := OIDCConfig{
+		Issuer:        idp.Issuer,
+		ClientID:      idp.ClientID,
+		ClientSecret:  idp.ClientSecret,
+		RedirectURL:   base + "/admin/oidc/callback",
+		AllowedEmails: []string{"Admin@Example.com"},
+	}
+	if mutate != nil {
+		mutate(&oc)
+	}
+	f := New(Config{
+		AdminUsername: "admin",
+		JWTSecret:     []byte(oidcTestSecret),
+		JWTTTL:        time.Hour,
+		OIDC:          oc,
+		OnLoginEvent:  func(LoginEvent) {},
+	})
+	r := gin.New()
+	f.Mount(r.Group("/admin"))
+	srv := &httptest.Server{Listener: l, Config: &http.Server{Handler: r}}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return base
+}
+
+type oidcResult struct {
+	status int
+	code   int
+	msg    string
+	token  string
+}
+
+// oidcLogin follows /oidc/start through the provider back to the callback.
+func oidcLogin(t *testing.T, base string) oidcResult {
+	t.Helper()
+	resp, err := http.Get(base + "/admin/oidc/start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode callback: %v", err)
+	}
+	return oidcResult{status: resp.StatusCode, code: body.Code, msg: body.Message, token: body.Data.Token}
+}
+
+func TestOIDCLoginIssuesAdminToken(t *testing.T) {
+	idp := oidctest.NewServer("roundnfc-admin", "s3cret")
+	defer idp.Close()
+	base := newOIDCApp(t, idp, nil)
+
+	res := oidcLogin(t, base)
+	if res.status != http.StatusOK || res.token == "" {
+		t.Fatalf("login: status %d code %d %q", res.status, res.code, res.msg)
+	}
+	claims, err := auth.ParseToken([]byte(oidcTestSecret), res.token)
+	if err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+	if claims.Subject != "admin" || !claims.HasAMR(auth.AMRFederated) {
+		t.Fatalf("unexpected claims: sub=%q amr=%v", claims.Subject, claims.AMR)
+	}
+}
+
+func TestOIDCPublicClientBySubject(t *testing.T) {
+	idp := oidctest.NewServer("spa", "")
+	defer idp.Close()
+	idp.SetUser(oidctest.User{Subject: "u-42"})
+	base := newOIDCApp(t, idp, func(o *OIDCConfig) {
+		o.AllowedEmails = nil
+		o.AllowedSubjects = []string{"u-42"}
+	})
+	if res := oidcLogin(t, base); res.status != http.StatusOK || res.token == "" {
+		t.Fatalf("login: status %d %q", res.status, res.msg)
+	}
+}
+
+func TestOIDCRejectsUnlistedAndUnverifiedEmail(t *testing.T) {
+	idp := oidctest.NewServer("roundnfc-admin", "s3cret")
+	defer idp.Close()
+	base := newOIDCApp(t, idp, nil)
+
+	idp.SetUser(oidctest.User{Subject: "x", Email: "someone@example.com", EmailVerified: true})
+	if res := oidcLogin(t, base); res.status != http.StatusForbidden {
+		t.Fatalf("unlisted email: status %d %q", res.status, res.msg)
+	}
+	idp.SetUser(oidctest.User{Subject: "y", Email: "admin@example.com", EmailVerified: false})
+	if res := oidcLogin(t, base); res.status != http.StatusForbidden {
+		t.Fatalf("unverified email: status %d %q", res.status, res.msg)
+	}
+}
+
+func TestOIDCRejectsBadNonce(t *testing.T) {
+	idp := oidctest.NewServer("roundnfc-admin", "s3cret")
+	defer idp.Close()
+	base := newOIDCApp(t, idp, nil)
+
+	idp.SetTamperNonce(true)
+	if res := oidcLogin(t, base); res.status != http.StatusUnauthorized {
+		t.Fatalf("tampered nonce: status %d %q", res.status, res.msg)
+	}
+}
+
+func TestOIDCRejectsWrongClientSecret(t *testing.T) {
+	idp := oidctest.NewServer("roundnfc-admin", "s3cret")
+	defer idp.Close()
+	base := newOIDCApp(t, idp, func(o *OIDCConfig) { o.ClientSecret = "wrong" })
+	if res := oidcLogin(t, base); res.status != http.StatusUnauthorized {
+		t.Fatalf("wrong secret: status %d %q", res.status, res.msg)
+	}
+}
+
+func TestOIDCStateIsSingleUse(t *testing.T) {
+	idp := oidctest.NewServer("roundnfc-admin", "s3cret")
+	defer idp.Close()
+	base := newOIDCApp(t, idp, nil)
+
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noFollow.Get(base + "/admin/oidc/start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = noFollow.Get(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback := resp.Header.Get("Location")
+	if u, _ := url.Parse(callback); u == nil || u.Query().Get("code") == "" {
+		t.Fatalf("provider did not return a code: %q", callback)
+	}
+	resp, err = http.Get(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("first callback: status %d", resp.StatusCode)
+	}
+	resp, err = http.Get(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("replayed callback: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,290 @@
+// Package oidctest is a minimal in-process OpenID Connect provider for tests
+// and local development (see cmd/mockoidc). It auto-approves every
+// authorization request as the configured User and signs ID tokens with a
+// throwaway ES256 key, so the admin OIDC login works without network access.
+package oidctest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// User is the identity the provider logs in as.
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider implements discovery, authorize, token, userinfo and JWKS.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty accepts public clients
+
+	mu          sync.Mutex
+	user        User
+	tamperNonce bool
+
+	key   *ecdsa.PrivateKey
+	kid   string
+	codes map[string]pendingCode
+}
+
+type pendingCode struct {
+	user        User
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+	expires     time.Time
+}
+
+// NewProvider builds a provider for issuer (the externally visible base URL).
+func NewProvider(issuer, clientID, clientSecret string) *Provider {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return &Provider{
+		Issuer:       strings.TrimRight(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		user:         User{Subject: "mock-user", Email: "admin@example.com", EmailVerified: true, Name: "Mock Admin"},
+		key:          key,
+		kid:          "oidctest-1",
+		codes:        map[string]pendingCode{},
+	}
+}
+
+// SetUser changes who subsequent logins authenticate as.
+func (p *Provider) SetUser(u User) {
+	p.mu.Lock()
+	p.user = u
+	p.mu.Unlock()
+}
+
+// SetTamperNonce makes issued ID tokens carry a wrong nonce (negative tests).
+func (p *Provider) SetTamperNonce(on bool) {
+	p.mu.Lock()
+	p.tamperNonce = on
+	p.mu.Unlock()
+}
+
+// Server is a Provider served by an httptest.Server.
+type Server struct {
+	*Provider
+	*httptest.Server
+}
+
+// NewServer starts a provider on a loopback listener. Call Close when done.
+func NewServer(clientID, clientSecret string) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	p := NewProvider("http://"+l.Addr().String(), clientID, clientSecret)
+	srv := &httptest.Server{Listener: l, Config: &http.Server{Handler: p}}
+	srv.Start()
+	return &Server{Provider: p, Server: srv}
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimRight(r.URL.Path, "/") {
+	case "/.well-known/openid-configuration":
+		p.discovery(w)
+	case "/authorize":
+		p.authorize(w, r)
+	case "/token":
+		p.token(w, r)
+	case "/userinfo":
+		p.userinfo(w, r)
+	case "/jwks":
+		p.jwks(w)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"userinfo_endpoint":                     p.Issuer + "/userinfo",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"ES256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI := q.Get("redirect_uri")
+	ru, err := url.Parse(redirectURI)
+	if err != nil || redirectURI == "" {
+		http.Error(w, "bad redirect_uri", http.StatusBadRequest)
+		return
+	}
+	fail := func(code string) {
+		v := ru.Query()
+		v.Set("error", code)
+		v.Set("state", q.Get("state"))
+		ru.RawQuery = v.Encode()
+		http.Redirect(w, r, ru.String(), http.StatusFound)
+	}
+	if q.Get("client_id") != p.ClientID {
+		fail("unauthorized_client")
+		return
+	}
+	if q.Get("response_type") != "code" {
+		fail("unsupported_response_type")
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		fail("invalid_request")
+		return
+	}
+	code := randString()
+	p.mu.Lock()
+	p.codes[code] = pendingCode{
+		user:        p.user,
+		clientID:    p.ClientID,
+		redirectURI: redirectURI,
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		expires:     time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+	v := ru.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	ru.RawQuery = v.Encode()
+	http.Redirect(w, r, ru.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	clientID, secret, hasBasic := r.BasicAuth()
+	if hasBasic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.ClientID ||
+		(p.ClientSecret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(p.ClientSecret)) != 1) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	pc, ok := p.codes[code]
+	delete(p.codes, code)
+	tamper := p.tamperNonce
+	p.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || time.Now().After(pc.expires) || pc.redirectURI != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != pc.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	nonce := pc.nonce
+	if tamper {
+		nonce = "tampered"
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            p.Issuer,
+		"sub":            pc.user.Subject,
+		"aud":            pc.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          pc.user.Email,
+		"email_verified": pc.user.EmailVerified,
+		"name":           pc.user.Name,
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	tok.Header["kid"] = p.kid
+	idToken, err := tok.SignedString(p.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": "at-" + pc.user.Subject,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (p *Provider) userinfo(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	u := p.user
+	p.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer at-"+u.Subject {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"sub": u.Subject, "email": u.Email, "email_verified": u.EmailVerified, "name": u.Name,
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter) {
+	pub := p.key.PublicKey
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	pub.X.FillBytes(x)
+	pub.Y.FillBytes(y)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "EC", "crv": "P-256", "use": "sig", "alg": "ES256", "kid": p.kid,
+			"x": base64.RawURLEncoding.EncodeToString(x),
+			"y": base64.RawURLEncoding.EncodeToString(y),
+		}},
+	})
+}
+
+func randString() string {
+	b := make([]byte, 18)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,236 @@
+package authflow
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"backend-go/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordStore is optionally implemented by a Store to keep the admin
+// password hash in the module database. On first boot the hash from the
+// environment (Config.AdminPasswordHash) is copied in; afterwards the database
+// is authoritative and the env value is ignored unless PasswordReset is set.
+type PasswordStore interface {
+	// GetAdminPassword returns an empty hash when nothing is stored yet.
+	GetAdminPassword(username string) (hash string, mustChange bool, err error)
+	SetAdminPassword(username, hash string, mustChange bool) error
+}
+
+// SessionStore is optionally implemented by a Store to persist the session
+// cutoff set when the admin password changes, so every replica rejects tokens
+// issued before it. Without it the cutoff lives in memory.
+type SessionStore interface {
+	// GetSessionsValidAfter returns the zero time when no cutoff is set.
+	GetSessionsValidAfter(username string) (time.Time, error)
+	SetSessionsValidAfter(username string, t time.Time) error
+}
+
+// passwords resolves the current admin hash from the PasswordStore, or keeps
+// it in memory when the Store has no such support.
+type passwords struct {
+	store    PasswordStore
+	sessions SessionStore
+
+	mu         sync.Mutex
+	hash       string
+	mustChange bool
+	validAfter time.Time
+}
+
+func newPasswords(cfg *Config) *passwords {
+	pw := &passwords{hash: cfg.AdminPasswordHash}
+	if cfg.AdminPasswordHash != "" {
+		pw.mustChange = auth.VerifyPassword(cfg.AdminPasswordHash, auth.DefaultAdminPassword)
+	}
+	ps, ok := cfg.Store.(PasswordStore)
+	if !ok || cfg.AdminUsername == "" {
+		return pw
+	}
+	stored, _, err := ps.GetAdminPassword(cfg.AdminUsername)
+	if err != nil {
+		log.Printf("[authflow] read admin password: %v (using env value)", err)
+		return pw
+	}
+	pw.store = ps
+	// the cutoff lives next to the password row it belongs to
+	pw.sessions, _ = cfg.Store.(SessionStore)
+	if stored != "" && !cfg.PasswordReset {
+		return pw
+	}
+	if cfg.AdminPasswordHash == "" {
+		return pw
+	}
+	if err := ps.SetAdminPassword(cfg.AdminUsername, cfg.AdminPasswordHash, pw.mustChange); err != nil {
+		log.Printf("[authflow] seed admin password: %v", err)
+	} else if stored == "" {
+		log.Printf("[authflow] admin password stored in database; env password is no longer used")
+	} else {
+		log.Printf("[authflow] admin password reset from env")
+		if err := pw.revokeSessions(cfg.AdminUsername, time.Now()); err != nil {
+			log.Printf("[authflow] revoke sessions: %v", err)
+		}
+	}
+	return pw
+}
+
+func (p *passwords) get(username string) (string, bool) {
+	if p.store != nil {
+		hash, must, err := p.store.GetAdminPassword(username)
+		if err != nil {
+			log.Printf("[authflow] read admin password: %v", err)
+			return "", false
+		}
+		return hash, must
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hash, p.mustChange
+}
+
+func (p *passwords) set(username, hash string, mustChange bool) error {
+	if p.store != nil {
+		return p.store.SetAdminPassword(username, hash, mustChange)
+	}
+	p.mu.Lock()
+	p.hash, p.mustChange = hash, mustChange
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *passwords) sessionsValidAfter(username string) (time.Time, error) {
+	if p.sessions != nil {
+		return p.sessions.GetSessionsValidAfter(username)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.validAfter, nil
+}
+
+// revokeSessions invalidates every token issued before at.
+func (p *passwords) revokeSessions(username string, at time.Time) error {
+	if p.sessions != nil {
+		return p.sessions.SetSessionsValidAfter(username, at)
+	}
+	p.mu.Lock()
+	p.validAfter = at
+	p.mu.Unlock()
+	return nil
+}
+
+// sessionsValidAfter is the auth.RevocationFunc registered for the JWT secret.
+func (f *Flow) sessionsValidAfter(subject string) (time.Time, error) {
+	if subject != f.cfg.AdminUsername {
+		return time.Time{}, nil
+	}
+	return f.pw.sessionsValidAfter(subject)
+}
+
+// checkPassword verifies the admin password. On success it upgrades a weak
+// hash in place and flags the account when the default password was used.
+func (f *Flow) checkPassword(username, plain string) bool {
+	if username != f.cfg.AdminUsername {
+		return false
+	}
+	hash, must := f.pw.get(username)
+	if hash == "" || !auth.VerifyPassword(hash, plain) {
+		return false
+	}
+	newHash, newMust := hash, must || plain == auth.DefaultAdminPassword
+	if auth.NeedsRehash(f.cfg.PasswordHashing, hash) {
+		if h, err := auth.HashPasswordWith(f.cfg.PasswordHashing, plain); err == nil {
+			newHash = h
+		}
+	}
+	if newHash != hash || newMust != must {
+		if err := f.pw.set(username, newHash, newMust); err != nil {
+			log.Printf("[authflow] update admin password: %v", err)
+		}
+	}
+	return true
+}
+
+func (f *Flow) mustChangePassword(username string) bool {
+	if username != f.cfg.AdminUsername {
+		return false
+	}
+	_, must := f.pw.get(username)
+	return must
+}
+
+// PasswordGate blocks admin APIs with 403 {"data":{"passwordChangeRequired":true}}
+// while the admin still has to replace the default password. Mount after
+// auth.Required; the flow's own routes (including POST /password) stay open.
+func (f *Flow) PasswordGate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if f.mustChangePassword(c.GetString(auth.ContextKeySubject)) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"code":    http.StatusForbidden,
+				"message": "password change required",
+				"data":    gin.H{"passwordChangeRequired": true},
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+type changePasswordPayload struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// handleChangePassword requires the current password; MFA freshness is
+// enforced by StepUp in front of it. Every earlier token and cookie session is
+// revoked; the caller continues with the token issued here.
+func (f *Flow) handleChangePassword(c *gin.Context) {
+	var p changePasswordPayload
+	if err := c.ShouldBindJSON(&p); err != nil {
+		flowFail(c, http.StatusBadRequest, "invalid body")
+		return
+	}
+	username := c.GetString(auth.ContextKeySubject)
+	if !f.guard.admit(c, "password", username, "") {
+		return
+	}
+	if !f.checkPassword(username, p.CurrentPassword) {
+		f.guard.reject(c, "password", username, "bad password on change", "current password is incorrect")
+		return
+	}
+	f.guard.succeed(c, username)
+	if p.NewPassword == p.CurrentPassword {
+		flowFail(c, http.StatusBadRequest, "new password must differ from the current one")
+		return
+	}
+	if err := f.cfg.PasswordRules.Check(p.NewPassword, username); err != nil {
+		flowFail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	hash, err := auth.HashPasswordWith(f.cfg.PasswordHashing, p.NewPassword)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		flowFail(c, http.StatusBadRequest, "password must be at most 72 bytes")
+		return
+	}
+	if err != nil {
+		flowFail(c, http.StatusInternalServerError, "hash error")
+		return
+	}
+	if err := f.pw.set(username, hash, false); err != nil {
+		flowFail(c, http.StatusInternalServerError, "save failed")
+		return
+	}
+	if err := f.pw.revokeSessions(username, time.Now()); err != nil {
+		log.Printf("[authflow] revoke sessions: %v", err)
+		flowFail(c, http.StatusInternalServerError, "save failed")
+		return
+	}
+	f.guard.emit(LoginEvent{Kind: "password_changed", Method: "password", Username: username,
+		IP: c.ClientIP(), At: time.Now()})
+	f.issueToken(c, username, auth.AMRPassword)
+}
@@ -0,0 +1,227 @@
+package authflow
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend-go/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+const newTestPassword = "another long passphrase"
+
+// passwordApp mounts the flow on /admin plus GET /admin/things behind
+// auth.Required and PasswordGate.
+func passwordApp(t *testing.T, cfg Config) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	if cfg.AdminPasswordHash == "" {
+		hash, err := auth.HashPassword(testPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.AdminPasswordHash = hash
+	}
+	cfg.AdminUsername = "admin"
+	cfg.JWTSecret = []byte(oidcTestSecret)
+	cfg.JWTTTL = time.Hour
+	cfg.OnLoginEvent = func(LoginEvent) {}
+	f := New(cfg)
+	r := gin.New()
+	admin := r.Group("/admin")
+	f.Mount(admin)
+	admin.GET("/things", auth.Required(cfg.JWTSecret), f.PasswordGate(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"code": 0})
+	})
+	return r
+}
+
+func changeBody(current, next string) string {
+	return `{"currentPassword":"` + current + `","newPassword":"` + next + `"}`
+}
+
+func passwordLogin(r http.Handler, password string) *httptest.ResponseRecorder {
+	return do(r, http.MethodPost, "/admin/login", "", `{"username":"admin","password":"`+password+`"}`)
+}
+
+func TestChangePasswordRevokesTokens(t *testing.T) {
+	store := newMemStore()
+	r := passwordApp(t, Config{Store: store})
+	// issued a minute ago: iat has second granularity
+	old := testToken(t, time.Minute, auth.AMRPassword)
+	if w := do(r, http.MethodGet, "/admin/things", old, ""); w.Code != http.StatusOK {
+		t.Fatalf("before change: %d", w.Code)
+	}
+
+	w := do(r, http.MethodPost, "/admin/password", old, changeBody(testPassword, newTestPassword))
+	fresh := decode(t, w).Data.Token
+	if w.Code != http.StatusOK || fresh == "" {
+		t.Fatalf("change: %d %s", w.Code, w.Body.String())
+	}
+	if store.validAfter.IsZero() {
+		t.Fatal("cutoff not persisted")
+	}
+	if w := do(r, http.MethodGet, "/admin/things", old, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("old token after change: %d", w.Code)
+	}
+	if w := do(r, http.MethodGet, "/admin/me", old, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("old token on /me: %d", w.Code)
+	}
+	if w := do(r, http.MethodGet, "/admin/things", fresh, ""); w.Code != http.StatusOK {
+		t.Fatalf("new token: %d %s", w.Code, w.Body.String())
+	}
+	if w := passwordLogin(r, testPassword); w.Code == http.StatusOK {
+		t.Fatal("old password still logs in")
+	}
+	if w := passwordLogin(r, newTestPassword); w.Code != http.StatusOK || decode(t, w).Data.Token == "" {
+		t.Fatalf("login with new password: %d %s", w.Code, w.Body.String())
+	}
+
+	// a replica booting with the same store honours the cutoff
+	r2 := passwordApp(t, Config{Store: store})
+	if w := do(r2, http.MethodGet, "/admin/things", old, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("old token on another replica: %d", w.Code)
+	}
+}
+
+func TestChangePasswordRevokesCookieSessions(t *testing.T) {
+	// no Store: the cutoff is kept in memory
+	r := passwordApp(t, Config{Session: SessionConfig{Cookie: true}})
+	old := testToken(t, time.Minute, auth.AMRPassword)
+	get := func(cookie string) int {
+		req := httptest.NewRequest(http.MethodGet, "/admin/things", nil)
+		req.AddCookie(&http.Cookie{Name: auth.SessionCookie, Value: cookie})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := get(old); code != http.StatusOK {
+		t.Fatalf("cookie before change: %d", code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/password", strings.NewReader(changeBody(testPassword, newTestPassword)))
+	req.AddCookie(&http.Cookie{Name: auth.SessionCookie, Value: old})
+	req.Header.Set(auth.CSRFHeader, auth.CSRFToken([]byte(oidcTestSecret), old))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("change: %d %s", w.Code, w.Body.String())
+	}
+	var fresh string
+	for _, c := range w.Result().Cookies() {
+		if c.Name == auth.SessionCookie {
+			fresh = c.Value
+		}
+	}
+	if fresh == "" || fresh == old {
+		t.Fatalf("no new session cookie: %v", w.Header().Values("Set-Cookie"))
+	}
+	if code := get(old); code != http.StatusUnauthorized {
+		t.Fatalf("old cookie after change: %d", code)
+	}
+	if code := get(fresh); code != http.StatusOK {
+		t.Fatalf("new cookie: %d", code)
+	}
+}
+
+func TestPasswordResetRevokesTokens(t *testing.T) {
+	store := newMemStore()
+	passwordApp(t, Config{Store: store})
+	old := testToken(t, time.Minute, auth.AMRPassword)
+
+	hash, err := auth.HashPassword(newTestPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := passwordApp(t, Config{Store: store, AdminPasswordHash: hash, PasswordReset: true})
+	if w := do(r, http.MethodGet, "/admin/things", old, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("old token after reset: %d", w.Code)
+	}
+	if w := passwordLogin(r, newTestPassword); w.Code != http.StatusOK {
+		t.Fatalf("login after reset: %d", w.Code)
+	}
+}
+
+func TestChangePasswordRules(t *testing.T) {
+	r := passwordApp(t, Config{Store: newMemStore(), PasswordRules: auth.PasswordRules{MinLength: 12}})
+	tok := testToken(t, time.Minute, auth.AMRPassword)
+	for _, tc := range []struct {
+		name          string
+		current, next string
+		want          int
+	}{
+		{"wrong current", "nope", newTestPassword, http.StatusUnauthorized},
+		{"unchanged", testPassword, testPassword, http.StatusBadRequest},
+		{"too short", testPassword, "short", http.StatusBadRequest},
+		{"default", testPassword, auth.DefaultAdminPassword, http.StatusBadRequest},
+		{"username", testPassword, "ADMIN", http.StatusBadRequest},
+		{"too long for bcrypt", testPassword, strings.Repeat("x", 73), http.StatusBadRequest},
+	} {
+		if w := do(r, http.MethodPost, "/admin/password", tok, changeBody(tc.current, tc.next)); w.Code != tc.want {
+			t.Fatalf("%s: %d %s", tc.name, w.Code, w.Body.String())
+		}
+	}
+	// a rejected change leaves the session alone
+	if w := do(r, http.MethodGet, "/admin/things", tok, ""); w.Code != http.StatusOK {
+		t.Fatalf("token after rejected changes: %d", w.Code)
+	}
+	// StepUp still applies in front of the handler
+	if w := do(r, http.MethodPost, "/admin/password", testToken(t, time.Hour, auth.AMRPassword),
+		changeBody(testPassword, newTestPassword)); w.Code != http.StatusForbidden {
+		t.Fatalf("stale token: %d", w.Code)
+	}
+}
+
+func TestDefaultPasswordGate(t *testing.T) {
+	hash, err := auth.HashPassword(auth.DefaultAdminPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newMemStore()
+	r := passwordApp(t, Config{Store: store, AdminPasswordHash: hash})
+	w := passwordLogin(r, auth.DefaultAdminPassword)
+	tok := decode(t, w).Data.Token
+	if w.Code != http.StatusOK || tok == "" {
+		t.Fatalf("login: %d %s", w.Code, w.Body.String())
+	}
+	if w := do(r, http.MethodGet, "/admin/things", tok, ""); w.Code != http.StatusForbidden ||
+		!strings.Contains(w.Body.String(), `"passwordChangeRequired":true`) {
+		t.Fatalf("gate: %d %s", w.Code, w.Body.String())
+	}
+	w = do(r, http.MethodPost, "/admin/password", tok, changeBody(auth.DefaultAdminPassword, newTestPassword))
+	fresh := decode(t, w).Data.Token
+	if w.Code != http.StatusOK || fresh == "" {
+		t.Fatalf("change: %d %s", w.Code, w.Body.String())
+	}
+	if w := do(r, http.MethodGet, "/admin/things", fresh, ""); w.Code != http.StatusOK {
+		t.Fatalf("after change: %d", w.Code)
+	}
+	if store.mustChange {
+		t.Fatal("mustChange still set")
+	}
+}
+
+func TestPasswordRehashOnLogin(t *testing.T) {
+	store := newMemStore()
+	policy := auth.HashPolicy{Algorithm: auth.HashArgon2id, Argon2Time: 1, Argon2Memory: 8 * 1024, Argon2Threads: 1}
+	r := passwordApp(t, Config{Store: store, PasswordHashing: policy})
+	if !strings.HasPrefix(store.hash, "$2") {
+		t.Fatalf("seeded hash = %q", store.hash)
+	}
+	if w := passwordLogin(r, "wrong"); w.Code == http.StatusOK || !strings.HasPrefix(store.hash, "$2") {
+		t.Fatalf("failed login changed the hash: %d %q", w.Code, store.hash)
+	}
+	if w := passwordLogin(r, testPassword); w.Code != http.StatusOK {
+		t.Fatalf("login: %d", w.Code)
+	}
+	if !strings.HasPrefix(store.hash, "$argon2id$") || !auth.VerifyPassword(store.hash, testPassword) {
+		t.Fatalf("hash not upgraded: %q", store.hash)
+	}
+	if w := passwordLogin(r, testPassword); w.Code != http.StatusOK {
+		t.Fatalf("login after upgrade: %d", w.Code)
+	}
+}
@@ -0,0 +1,118 @@
+package authflow
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"backend-go/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionConfig switches the flow to cookie sessions: the JWT is set as an
+// HttpOnly auth.SessionCookie scoped to the admin path instead of being
+// returned in the body, and the body carries a CSRF token the SPA echoes in
+// auth.CSRFHeader on mutating requests. Bearer tokens keep working.
+type SessionConfig struct {
+	Cookie   bool
+	SameSite http.SameSite // defaults to Strict
+	Secure   *bool         // nil: Secure when the request arrived over https
+	Domain   string        // host-only cookie when empty
+}
+
+// SessionConfigFromEnv reads <PREFIX>_SESSION_COOKIE (falling back to
+// HTTP_ADMIN_SESSION_COOKIE), <PREFIX>_SESSION_SAMESITE (strict|lax|none),
+// <PREFIX>_SESSION_COOKIE_SECURE (auto|true|false) and <PREFIX>_SESSION_COOKIE_DOMAIN.
+func SessionConfigFromEnv(envPrefix string) SessionConfig {
+	env := func(name string) string { return strings.TrimSpace(os.Getenv(envPrefix + name)) }
+	on := env("_SESSION_COOKIE")
+	if on == "" {
+		on = strings.TrimSpace(os.Getenv("HTTP_ADMIN_SESSION_COOKIE"))
+	}
+	cfg := SessionConfig{Cookie: truthy(on), Domain: env("_SESSION_COOKIE_DOMAIN")}
+	switch strings.ToLower(env("_SESSION_SAMESITE")) {
+	case "lax":
+		cfg.SameSite = http.SameSiteLaxMode
+	case "none":
+		cfg.SameSite = http.SameSiteNoneMode
+	default:
+		cfg.SameSite = http.SameSiteStrictMode
+	}
+	switch v := strings.ToLower(env("_SESSION_COOKIE_SECURE")); v {
+	case "", "auto":
+	default:
+		secure := truthy(v)
+		cfg.Secure = &secure
+	}
+	return cfg
+}
+
+func truthy(v string) bool {
+	v = strings.ToLower(strings.TrimSpace(v))
+	return v == "1" || v == "true" || v == "yes" || v == "on"
+}
+
+func (f *Flow) cookieSecure(c *gin.Context) bool {
+	if f.cfg.Session.SameSite == http.SameSiteNoneMode {
+		return true // browsers drop SameSite=None cookies without Secure
+	}
+	if f.cfg.Session.Secure != nil {
+		return *f.cfg.Session.Secure
+	}
+	return c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")
+}
+
+func (f *Flow) setSessionCookie(c *gin.Context, token string, exp time.Time) {
+	sameSite := f.cfg.Session.SameSite
+	if sameSite == 0 {
+		sameSite = http.SameSiteStrictMode
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    token,
+		Path:     f.cookiePath,
+		Domain:   f.cfg.Session.Domain,
+		Expires:  exp,
+		MaxAge:   int(time.Until(exp).Seconds()),
+		Secure:   f.cookieSecure(c),
+		HttpOnly: true,
+		SameSite: sameSite,
+	})
+}
+
+func (f *Flow) clearSessionCookie(c *gin.Context) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    "",
+		Path:     f.cookiePath,
+		Domain:   f.cfg.Session.Domain,
+		MaxAge:   -1,
+		Secure:   f.cookieSecure(c),
+		HttpOnly: true,
+		SameSite: f.cfg.Session.SameSite,
+	})
+}
+
+// sessionData is the login response body. In cookie mode the JWT goes into
+// the cookie and only the CSRF token is exposed to JavaScript.
+func (f *Flow) sessionData(c *gin.Context, username, token string, exp time.Time) gin.H {
+	data := gin.H{"expiresAt": exp.Format(time.RFC3339), "username": username}
+	if f.cfg.Session.Cookie {
+		f.setSessionCookie(c, token, exp)
+		data["session"] = true
+		data["csrfToken"] = auth.CSRFToken(f.cfg.JWTSecret, token)
+	} else {
+		data["token"] = token
+	}
+	return data
+}
+
+// handleLogout clears the session cookie. Bearer clients just drop their token.
+func (f *Flow) handleLogout(c *gin.Context) {
+	if f.cfg.Session.Cookie {
+		f.clearSessionCookie(c)
+	}
+	flowOK(c, gin.H{"ok": true})
+}
@@ -0,0 +1,78 @@
+package authflow
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend-go/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCookieSessionRequiresCSRF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	secret := []byte(oidcTestSecret)
+	hash, err := auth.HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := New(Config{
+		AdminUsername:     "admin",
+		AdminPasswordHash: hash,
+		JWTSecret:         secret,
+		JWTTTL:            time.Hour,
+		Session:           SessionConfig{Cookie: true},
+	})
+	r := gin.New()
+	admin := r.Group("/api/demo/admin")
+	f.Mount(admin)
+	admin.Group("", auth.Required(secret)).POST("/things", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/demo/admin/login",
+		strings.NewReader(`{"username":"admin","password":"correct horse battery"}`)))
+	var body struct {
+		Data struct {
+			Token     string `json:"token"`
+			Session   bool   `json:"session"`
+			CSRFToken string `json:"csrfToken"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", w.Code, w.Body.String())
+	}
+	if body.Data.Token != "" || !body.Data.Session || body.Data.CSRFToken == "" {
+		t.Fatalf("unexpected login body: %s", w.Body.String())
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].Path != "/api/demo/admin" {
+		t.Fatalf("unexpected cookie: %v", w.Header().Values("Set-Cookie"))
+	}
+
+	do := func(method, path, csrf string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.AddCookie(cookies[0])
+		if csrf != "" {
+			req.Header.Set(auth.CSRFHeader, csrf)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := do(http.MethodGet, "/api/demo/admin/me", ""); code != http.StatusOK {
+		t.Fatalf("GET with cookie: %d", code)
+	}
+	if code := do(http.MethodPost, "/api/demo/admin/things", ""); code != http.StatusForbidden {
+		t.Fatalf("POST without csrf: %d", code)
+	}
+	if code := do(http.MethodPost, "/api/demo/admin/things", "bogus"); code != http.StatusForbidden {
+		t.Fatalf("POST with wrong csrf: %d", code)
+	}
+	if code := do(http.MethodPost, "/api/demo/admin/things", body.Data.CSRFToken); code != http.StatusOK {
+		t.Fatalf("POST with csrf: %d", code)
+	}
+}
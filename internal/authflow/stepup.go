@@ -0,0 +1,172 @@
+package authflow
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"backend-go/internal/auth"
+	"backend-go/internal/auth/apitoken"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultStepUpMaxAge = 5 * time.Minute
+
+// Re-authentication methods reported in a "reauth required" response.
+const (
+	ReauthTOTP     = "totp"
+	ReauthPasskey  = "webauthn"
+	ReauthPassword = "password"
+)
+
+func (f *Flow) stepUpMaxAge() time.Duration {
+	if f.cfg.StepUpMaxAge > 0 {
+		return f.cfg.StepUpMaxAge
+	}
+	return defaultStepUpMaxAge
+}
+
+// reauthMethods lists the second factors the user has enrolled. Users without
+// any fall back to re-entering the password.
+func (f *Flow) reauthMethods(username string) []string {
+	var out []string
+	if f.cfg.Store != nil {
+		if _, enabled, _ := f.cfg.Store.GetTOTP(username); enabled {
+			out = append(out, ReauthTOTP)
+		}
+		if creds, _ := f.cfg.Store.GetCredentials(username); len(creds) > 0 {
+			out = append(out, ReauthPasskey)
+		}
+	}
+	if len(out) == 0 {
+		out = append(out, ReauthPassword)
+	}
+	return out
+}
+
+// StepUp guards sensitive routes: the bearer token must come from a TOTP or
+// passkey proof made within StepUpMaxAge (or, for users without a second
+// factor, any fresh login). Otherwise it answers 403 with
+//
+//	{"code":403,"message":"reauth required","data":{"reauthRequired":true,"methods":[...],"maxAgeSeconds":N}}
+//
+// so the SPA can prompt and retry after POST /reauth. Mount after auth.Required.
+//
+// Requests authenticated with an API token pass: a token has no session to
+// re-prove, and the route's apitoken.Scope (or AdminOnly) already decided
+// whether it may be there.
+func (f *Flow) StepUp() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if apitoken.FromContext(c) != nil {
+			c.Next()
+			return
+		}
+		claims := auth.ClaimsFrom(c)
+		username := c.GetString(auth.ContextKeySubject)
+		methods := f.reauthMethods(username)
+		if claims != nil && f.fresh(claims, methods) {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "reauth required",
+			"data": gin.H{
+				"reauthRequired": true,
+				"methods":        methods,
+				"maxAgeSeconds":  int(f.stepUpMaxAge().Seconds()),
+			},
+		})
+	}
+}
+
+func (f *Flow) fresh(claims *auth.Claims, methods []string) bool {
+	if claims.AuthTime == 0 || time.Since(time.Unix(claims.AuthTime, 0)) > f.stepUpMaxAge() {
+		return false
+	}
+	if len(methods) == 1 && methods[0] == ReauthPassword {
+		return true
+	}
+	return claims.HasAMR(auth.AMROTP, auth.AMRPasskey)
+}
+
+type reauthPayload struct {
+	Password string `json:"password"`
+	TOTPCode string `json:"totpCode"`
+}
+
+// handleReauth re-proves identity with a TOTP code (or, when no second factor
+// is enrolled, the password) and returns a token fresh for StepUp.
+func (f *Flow) handleReauth(c *gin.Context) {
+	var p reauthPayload
+	if err := c.ShouldBindJSON(&p); err != nil {
+		flowFail(c, http.StatusBadRequest, "invalid body")
+		return
+	}
+	username := c.GetString(auth.ContextKeySubject)
+	if !f.guard.admit(c, "reauth", username, "") {
+		return
+	}
+	methods := f.reauthMethods(username)
+	switch {
+	case containsStr(methods, ReauthTOTP):
+		secret, _, _ := f.cfg.Store.GetTOTP(username)
+		if strings.TrimSpace(p.TOTPCode) == "" || !VerifyTOTP(secret, p.TOTPCode) {
+			f.guard.reject(c, "totp", username, "bad totp on reauth", "invalid TOTP code")
+			return
+		}
+		f.guard.succeed(c, username)
+		f.issueToken(c, username, auth.AMROTP)
+	case containsStr(methods, ReauthPasskey):
+		flowFail(c, http.StatusBadRequest, "use a passkey to re-authenticate")
+	default:
+		if !f.checkPassword(username, p.Password) {
+			f.guard.reject(c, "password", username, "bad password on reauth", "invalid credentials")
+			return
+		}
+		f.guard.succeed(c, username)
+		f.issueToken(c, username, auth.AMRPassword)
+	}
+}
+
+func (f *Flow) handleReauthWABegin(c *gin.Context) {
+	username := c.GetString(auth.ContextKeySubject)
+	creds, err := f.cfg.Store.GetCredentials(username)
+	if err != nil || len(creds) == 0 {
+		flowFail(c, http.StatusBadRequest, "no passkeys registered")
+		return
+	}
+	opts, err := beginLogin(&f.pool, &f.cfg, username, creds)
+	if err != nil {
+		flowFail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	flowOK(c, opts)
+}
+
+func (f *Flow) handleReauthWAFinish(c *gin.Context) {
+	var p waLoginFinishPayload
+	if err := c.ShouldBindJSON(&p); err != nil {
+		flowFail(c, http.StatusBadRequest, "invalid body")
+		return
+	}
+	username := c.GetString(auth.ContextKeySubject)
+	if !f.guard.admit(c, "reauth", username, "") {
+		return
+	}
+	if _, ok := f.verifyAssertion(c, username, p.SessionID, &p.Credential); !ok {
+		return
+	}
+	f.guard.succeed(c, username)
+	f.issueToken(c, username, auth.AMRPasskey)
+}
+
+func containsStr(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,259 @@
+package authflow
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"backend-go/internal/auth"
+	"backend-go/internal/auth/apitoken"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// memStore is an in-memory Store, PasswordStore and SessionStore.
+type memStore struct {
+	mu         sync.Mutex
+	totp       map[string]string
+	creds      []Credential
+	hash       string
+	mustChange bool
+	validAfter time.Time
+}
+
+func newMemStore() *memStore { return &memStore{totp: map[string]string{}} }
+
+func (s *memStore) GetTOTP(username string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.totp[username]
+	return secret, ok, nil
+}
+
+func (s *memStore) SetTOTP(username, secret string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if enabled {
+		s.totp[username] = secret
+	} else {
+		delete(s.totp, username)
+	}
+	return nil
+}
+
+func (s *memStore) GetCredentials(username string) ([]Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Credential
+	for _, c := range s.creds {
+		if c.Username == username {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+func (s *memStore) SaveCredential(c *Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creds = append(s.creds, *c)
+	return nil
+}
+
+func (s *memStore) DeleteCredential(username, credID string) error               { return nil }
+func (s *memStore) UpdateSignState(credID string, counter uint32, bs bool) error { return nil }
+func (s *memStore) FlagCredential(credID, reason string) error                   { return nil }
+func (s *memStore) ListCredentials(username string) ([]CredentialInfo, error) {
+	return nil, nil
+}
+
+func (s *memStore) GetAdminPassword(string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hash, s.mustChange, nil
+}
+
+func (s *memStore) SetAdminPassword(_, hash string, mustChange bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hash, s.mustChange = hash, mustChange
+	return nil
+}
+
+func (s *memStore) GetSessionsValidAfter(string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.validAfter, nil
+}
+
+func (s *memStore) SetSessionsValidAfter(_ string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.validAfter = t
+	return nil
+}
+
+const testPassword = "correct horse battery"
+
+// stepUpApp mounts the flow on /admin plus DELETE /admin/things/:id behind
+// StepUp. Requests with X-Test-Token are authenticated as an API token.
+func stepUpApp(t *testing.T, store *memStore) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	hash, err := auth.HashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := New(Config{
+		Store:             store,
+		AdminUsername:     "admin",
+		AdminPasswordHash: hash,
+		JWTSecret:         []byte(oidcTestSecret),
+		JWTTTL:            time.Hour,
+		StepUpMaxAge:      5 * time.Minute,
+		OnLoginEvent:      func(LoginEvent) {},
+	})
+	r := gin.New()
+	admin := r.Group("/admin")
+	f.Mount(admin)
+	jwtRequired := auth.Required([]byte(oidcTestSecret))
+	authed := admin.Group("", func(c *gin.Context) {
+		if id := c.GetHeader("X-Test-Token"); id != "" {
+			apitoken.SetContext(c, &apitoken.Token{ID: id, Scopes: []string{"things:delete"}, Enabled: true})
+			c.Next()
+			return
+		}
+		jwtRequired(c)
+	})
+	authed.DELETE("/things/:id", apitoken.Scope("things:delete"), f.StepUp(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"code": 0})
+	})
+	return r
+}
+
+// testToken signs a JWT whose last authentication was age ago.
+func testToken(t *testing.T, age time.Duration, amr ...string) string {
+	t.Helper()
+	now := time.Now()
+	tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+		Subject:  "admin",
+		AuthTime: now.Add(-age).Unix(),
+		AMR:      amr,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now.Add(-age)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+	}).SignedString([]byte(oidcTestSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func do(r http.Handler, method, path, bearer, body string, hdr ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	for i := 0; i+1 < len(hdr); i += 2 {
+		req.Header.Set(hdr[i], hdr[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+type reauthBody struct {
+	Data struct {
+		Token          string   `json:"token"`
+		ReauthRequired bool     `json:"reauthRequired"`
+		Methods        []string `json:"methods"`
+		MaxAgeSeconds  int      `json:"maxAgeSeconds"`
+	} `json:"data"`
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder) reauthBody {
+	t.Helper()
+	var b reauthBody
+	if err := json.Unmarshal(w.Body.Bytes(), &b); err != nil {
+		t.Fatalf("%d %s: %v", w.Code, w.Body.String(), err)
+	}
+	return b
+}
+
+func TestStepUpWithoutSecondFactor(t *testing.T) {
+	r := stepUpApp(t, newMemStore())
+	if w := do(r, http.MethodDelete, "/admin/things/1", testToken(t, time.Minute, auth.AMRPassword), ""); w.Code != http.StatusOK {
+		t.Fatalf("fresh password login: %d %s", w.Code, w.Body.String())
+	}
+	stale := testToken(t, 10*time.Minute, auth.AMRPassword)
+	w := do(r, http.MethodDelete, "/admin/things/1", stale, "")
+	b := decode(t, w)
+	if w.Code != http.StatusForbidden || !b.Data.ReauthRequired || len(b.Data.Methods) != 1 ||
+		b.Data.Methods[0] != ReauthPassword || b.Data.MaxAgeSeconds != 300 {
+		t.Fatalf("stale: %d %s", w.Code, w.Body.String())
+	}
+
+	if w := do(r, http.MethodPost, "/admin/reauth", stale, `{"password":"wrong"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("reauth with a wrong password: %d", w.Code)
+	}
+	w = do(r, http.MethodPost, "/admin/reauth", stale, `{"password":"`+testPassword+`"}`)
+	fresh := decode(t, w).Data.Token
+	if w.Code != http.StatusOK || fresh == "" {
+		t.Fatalf("reauth: %d %s", w.Code, w.Body.String())
+	}
+	if w := do(r, http.MethodDelete, "/admin/things/1", fresh, ""); w.Code != http.StatusOK {
+		t.Fatalf("after reauth: %d", w.Code)
+	}
+}
+
+func TestStepUpWithTOTP(t *testing.T) {
+	store := newMemStore()
+	secret, err := genTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = store.SetTOTP("admin", secret, true)
+	r := stepUpApp(t, store)
+
+	// a fresh password-only token is not enough once TOTP is enrolled
+	pwd := testToken(t, time.Minute, auth.AMRPassword)
+	w := do(r, http.MethodDelete, "/admin/things/1", pwd, "")
+	if b := decode(t, w); w.Code != http.StatusForbidden || len(b.Data.Methods) != 1 || b.Data.Methods[0] != ReauthTOTP {
+		t.Fatalf("password token: %d %s", w.Code, w.Body.String())
+	}
+	if w := do(r, http.MethodDelete, "/admin/things/1", testToken(t, time.Minute, auth.AMRPassword, auth.AMROTP), ""); w.Code != http.StatusOK {
+		t.Fatalf("fresh otp token: %d", w.Code)
+	}
+	if w := do(r, http.MethodDelete, "/admin/things/1", testToken(t, 10*time.Minute, auth.AMROTP), ""); w.Code != http.StatusForbidden {
+		t.Fatalf("stale otp token: %d", w.Code)
+	}
+
+	// the password alone does not re-authenticate
+	if w := do(r, http.MethodPost, "/admin/reauth", pwd, `{"password":"`+testPassword+`"}`); w.Code == http.StatusOK {
+		t.Fatal("password accepted as reauth with TOTP enrolled")
+	}
+	code := totpCode(secret, uint64(time.Now().Unix()/int64(totpPeriod)))
+	w = do(r, http.MethodPost, "/admin/reauth", pwd, `{"totpCode":"`+code+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("totp reauth: %d %s", w.Code, w.Body.String())
+	}
+	if w := do(r, http.MethodDelete, "/admin/things/1", decode(t, w).Data.Token, ""); w.Code != http.StatusOK {
+		t.Fatalf("after totp reauth: %d", w.Code)
+	}
+}
+
+func TestStepUpAPIToken(t *testing.T) {
+	r := stepUpApp(t, newMemStore())
+	if w := do(r, http.MethodDelete, "/admin/things/1", "", "", "X-Test-Token", "t1"); w.Code != http.StatusOK {
+		t.Fatalf("scoped api token: %d %s", w.Code, w.Body.String())
+	}
+	if w := do(r, http.MethodDelete, "/admin/things/1", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous: %d", w.Code)
+	}
+}
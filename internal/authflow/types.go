@@ -1,6 +1,11 @@
 package authflow
 
-import "time"
+import (
+	"time"
+
+	"backend-go/internal/auth"
+	"backend-go/internal/auth/challenge"
+)
 
 // Store provides persistence for TOTP and passkey state.
 type Store interface {
@@ -10,7 +15,8 @@ type Store interface {
 	GetCredentials(username string) ([]Credential, error)
 	SaveCredential(c *Credential) error
 	DeleteCredential(username, credID string) error
-	UpdateCounter(credID string, counter uint32) error
+	UpdateSignState(credID string, counter uint32, backupState bool) error
+	FlagCredential(credID, reason string) error
 	ListCredentials(username string) ([]CredentialInfo, error)
 }
 
@@ -22,13 +28,25 @@ type Credential struct {
 	PublicKey []byte    `json:"publicKey"`
 	Counter   uint32    `json:"counter"`
 	CreatedAt time.Time `json:"createdAt"`
+
+	BackupEligible bool   `json:"backupEligible"` // BE flag, fixed at registration
+	BackupState    bool   `json:"backupState"`    // BS flag, refreshed on every login
+	Flagged        string `json:"flagged,omitempty"`
+
+	AAGUID          string `json:"aaguid"`          // authenticator model, UUID form
+	AttestationType string `json:"attestationType"` // "<fmt>/<type>", e.g. "packed/basic"
 }
 
 // CredentialInfo is the public summary of a Credential.
 type CredentialInfo struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	CreatedAt       time.Time `json:"createdAt"`
+	BackupEligible  bool      `json:"backupEligible"`
+	BackupState     bool      `json:"backupState"`
+	Flagged         string    `json:"flagged,omitempty"` // e.g. a sign counter regression
+	AAGUID          string    `json:"aaguid"`
+	AttestationType string    `json:"attestationType"`
 }
 
 // Config holds all settings for a Flow.
@@ -42,4 +60,14 @@ type Config struct {
 	WebAuthnRPID      string
 	WebAuthnRPName    string
 	WebAuthnOrigins   []string
+	WebAuthnPolicy    AttestationPolicy
+	Challenges        challenge.Store // WebAuthn ceremony state; in-memory when nil
+	Guard             GuardConfig
+	StepUpMaxAge      time.Duration      // how recent a TOTP/passkey proof StepUp accepts (default 5m)
+	OIDC              OIDCConfig         // external IdP sign-in; disabled unless OIDC.Enabled()
+	Session           SessionConfig      // HttpOnly cookie sessions instead of bearer tokens in the body
+	PasswordHashing   auth.HashPolicy    // new hashes and transparent upgrades on login
+	PasswordRules     auth.PasswordRules // enforced by POST /password
+	PasswordReset     bool               // overwrite the stored hash with AdminPasswordHash on boot
+	OnLoginEvent      func(LoginEvent)   // defaults to logging when nil
 }
@@ -1,8 +1,10 @@
 package authflow
 
 import (
+	"context"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
@@ -15,52 +17,56 @@ import (
 	"fmt"
 	"math/big"
 	"strings"
-	"sync"
 	"time"
+
+	"backend-go/internal/auth/challenge"
 )
 
 // ----- challenge sessions -----
 
+const waChallengeTTL = 5 * time.Minute
+
 type waChallenge struct {
 	username  string
 	challenge []byte
-	expiresAt time.Time
 }
 
+// waPool keeps ceremony challenges in a challenge.Store so a ceremony may
+// begin and finish on different replicas.
 type waPool struct {
-	mu   sync.Mutex
-	sess map[string]*waChallenge
+	store challenge.Store
 }
 
-func (p *waPool) store(c *waChallenge) string {
-	b := make([]byte, 16)
-	_, _ = rand.Read(b)
-	id := base64.RawURLEncoding.EncodeToString(b)
-	c.expiresAt = time.Now().Add(5 * time.Minute)
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	now := time.Now()
-	for k, v := range p.sess {
-		if now.After(v.expiresAt) {
-			delete(p.sess, k)
-		}
+type waChallengeRecord struct {
+	Username  string `json:"u"`
+	Challenge []byte `json:"c"`
+}
+
+func (p *waPool) put(c *waChallenge) (string, error) {
+	id, err := challenge.NewKey(16)
+	if err != nil {
+		return "", err
 	}
-	p.sess[id] = c
-	return id
+	b, _ := json.Marshal(waChallengeRecord{Username: c.username, Challenge: c.challenge})
+	if err := p.store.Put(context.Background(), "wa:"+id, b, waChallengeTTL); err != nil {
+		return "", err
+	}
+	return id, nil
 }
 
 func (p *waPool) pop(id string) (*waChallenge, bool) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	c, ok := p.sess[id]
-	if !ok {
+	if id == "" {
 		return nil, false
 	}
-	delete(p.sess, id)
-	if time.Now().After(c.expiresAt) {
+	b, ok, err := p.store.Take(context.Background(), "wa:"+id)
+	if err != nil || !ok {
 		return nil, false
 	}
-	return c, true
+	var rec waChallengeRecord
+	if json.Unmarshal(b, &rec) != nil {
+		return nil, false
+	}
+	return &waChallenge{username: rec.Username, challenge: rec.Challenge}, true
 }
 
 // ----- request/response types -----
@@ -75,6 +81,8 @@ type RegBeginOptions struct {
 	UserDisplayName string   `json:"userDisplayName"`
 	Timeout         int      `json:"timeout"`
 	ExcludeCredIDs  []string `json:"excludeCredentialIds"`
+	Attestation     string   `json:"attestation"`
+	UserVerify      string   `json:"userVerification"`
 	SessionID       string   `json:"sessionId"`
 }
 
@@ -98,6 +106,7 @@ type LoginBeginOptions struct {
 	RPID             string           `json:"rpId"`
 	Timeout          int              `json:"timeout"`
 	AllowCredentials []CredDescriptor `json:"allowCredentials"`
+	UserVerify       string           `json:"userVerification"`
 	SessionID        string           `json:"sessionId"`
 }
 
@@ -134,7 +143,10 @@ func beginRegistration(pool *waPool, cfg *Config, username string, existingIDs [
 	for i, id := range existingIDs {
 		excludeIDs[i] = base64.RawURLEncoding.EncodeToString(id)
 	}
-	sessID := pool.store(&waChallenge{username: username, challenge: chal})
+	sessID, err := pool.put(&waChallenge{username: username, challenge: chal})
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: store challenge: %w", err)
+	}
 	return &RegBeginOptions{
 		Challenge:       base64.RawURLEncoding.EncodeToString(chal),
 		RPID:            cfg.WebAuthnRPID,
@@ -144,6 +156,8 @@ func beginRegistration(pool *waPool, cfg *Config, username string, existingIDs [
 		UserDisplayName: username,
 		Timeout:         60000,
 		ExcludeCredIDs:  excludeIDs,
+		Attestation:     cfg.WebAuthnPolicy.attestationConveyance(),
+		UserVerify:      cfg.WebAuthnPolicy.userVerification(),
 		SessionID:       sessID,
 	}, nil
 }
@@ -194,33 +208,61 @@ func finishRegistration(pool *waPool, cfg *Config, sessionID string, resp *Crede
 	if string(ad.RPIDHash) != string(expected[:]) {
 		return nil, errors.New("webauthn: rpIdHash mismatch")
 	}
+	if ad.Flags&flagUP == 0 {
+		return nil, errors.New("webauthn: user presence required")
+	}
+	if err := checkBackupFlags(ad.Flags); err != nil {
+		return nil, err
+	}
+	if _, err := parseCOSEKey(ad.CredentialPublicKey); err != nil {
+		return nil, fmt.Errorf("webauthn: credential public key: %w", err)
+	}
+	cdHash := sha256.Sum256(cdJSON)
+	att, err := verifyAttestation(attObj, authDataRaw, ad, cdHash[:], cfg.WebAuthnPolicy.Roots)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: attestation: %w", err)
+	}
+	if err := cfg.WebAuthnPolicy.check(ad, att); err != nil {
+		return nil, err
+	}
 	return &Credential{
-		ID:        base64.RawURLEncoding.EncodeToString(ad.CredentialID),
-		Username:  sess.username,
-		PublicKey: ad.CredentialPublicKey,
-		Counter:   ad.SignCount,
-		CreatedAt: time.Now(),
+		ID:              base64.RawURLEncoding.EncodeToString(ad.CredentialID),
+		Username:        sess.username,
+		PublicKey:       ad.CredentialPublicKey,
+		Counter:         ad.SignCount,
+		BackupEligible:  ad.Flags&flagBE != 0,
+		BackupState:     ad.Flags&flagBS != 0,
+		AAGUID:          formatAAGUID(ad.AAGUID),
+		AttestationType: att.Format + "/" + att.Type,
+		CreatedAt:       time.Now(),
 	}, nil
 }
 
+// beginLogin starts an assertion. An empty username means a discoverable
+// (usernameless) ceremony: allowCredentials stays empty and the authenticator
+// picks a resident key whose user handle identifies the admin.
 func beginLogin(pool *waPool, cfg *Config, username string, creds []Credential) (*LoginBeginOptions, error) {
-	if len(creds) == 0 {
+	if username != "" && len(creds) == 0 {
 		return nil, errors.New("webauthn: no credentials registered")
 	}
 	chal := make([]byte, 32)
 	if _, err := rand.Read(chal); err != nil {
 		return nil, err
 	}
-	allow := make([]CredDescriptor, len(creds))
-	for i, c := range creds {
-		allow[i] = CredDescriptor{Type: "public-key", ID: c.ID}
+	allow := make([]CredDescriptor, 0, len(creds))
+	for _, c := range creds {
+		allow = append(allow, CredDescriptor{Type: "public-key", ID: c.ID})
+	}
+	sessID, err := pool.put(&waChallenge{username: username, challenge: chal})
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: store challenge: %w", err)
 	}
-	sessID := pool.store(&waChallenge{username: username, challenge: chal})
 	return &LoginBeginOptions{
 		Challenge:        base64.RawURLEncoding.EncodeToString(chal),
 		RPID:             cfg.WebAuthnRPID,
 		Timeout:          60000,
 		AllowCredentials: allow,
+		UserVerify:       cfg.WebAuthnPolicy.userVerification(),
 		SessionID:        sessID,
 	}, nil
 }
@@ -261,9 +303,18 @@ func finishLogin(pool *waPool, cfg *Config, sessionID string, resp *CredentialAs
 	}
 	username := sess.username
 	if username == "" {
-		uh, _ := waB64Decode(resp.Response.UserHandle)
+		uh, err := waB64Decode(resp.Response.UserHandle)
+		if err != nil || len(uh) == 0 {
+			return nil, errors.New("webauthn: userHandle required for discoverable login")
+		}
 		username = string(uh)
 	}
+	if err := checkBackupFlags(ad.Flags); err != nil {
+		return nil, err
+	}
+	if cfg.WebAuthnPolicy.RequireUserVerification && ad.Flags&flagUV == 0 {
+		return nil, errors.New("webauthn: user verification required")
+	}
 	creds, err := getCreds(username)
 	if err != nil {
 		return nil, fmt.Errorf("webauthn: get creds: %w", err)
@@ -279,7 +330,7 @@ func finishLogin(pool *waPool, cfg *Config, sessionID string, resp *CredentialAs
 			break
 		}
 	}
-	if matched == nil {
+	if matched == nil || matched.Username != username {
 		return nil, errors.New("webauthn: credential not found")
 	}
 	pubKey, err := parseCOSEKey(matched.PublicKey)
@@ -295,10 +346,23 @@ func finishLogin(pool *waPool, cfg *Config, sessionID string, resp *CredentialAs
 	if err := waVerifySignature(pubKey, verifyData, sig); err != nil {
 		return nil, fmt.Errorf("webauthn: %w", err)
 	}
+	if matched.BackupEligible != (ad.Flags&flagBE != 0) {
+		return nil, errors.New("webauthn: backup eligibility changed")
+	}
+	matched.BackupState = ad.Flags&flagBS != 0
+	// Authenticators that don't implement counters always report 0; otherwise
+	// the counter must strictly increase, or the key may have been cloned.
+	if (ad.SignCount != 0 || matched.Counter != 0) && ad.SignCount <= matched.Counter {
+		return matched, ErrCounterRegression
+	}
 	matched.Counter = ad.SignCount
 	return matched, nil
 }
 
+// ErrCounterRegression is returned by finishLogin (together with the matched
+// credential) when the signature counter did not increase.
+var ErrCounterRegression = errors.New("webauthn: sign counter regressed; possible cloned authenticator")
+
 // ----- internals -----
 
 type waClientData struct {
@@ -307,10 +371,28 @@ type waClientData struct {
 	Origin    string `json:"origin"`
 }
 
+// authenticator data flag bits
+const (
+	flagUP = 0x01
+	flagUV = 0x04
+	flagBE = 0x08
+	flagBS = 0x10
+	flagAT = 0x40
+	flagED = 0x80
+)
+
+func checkBackupFlags(flags byte) error {
+	if flags&flagBS != 0 && flags&flagBE == 0 {
+		return errors.New("webauthn: backup state set without backup eligibility")
+	}
+	return nil
+}
+
 type authDataParsed struct {
 	RPIDHash            []byte
 	Flags               byte
 	SignCount           uint32
+	AAGUID              []byte
 	CredentialID        []byte
 	CredentialPublicKey []byte
 }
@@ -325,7 +407,7 @@ func parseAuthData(data []byte, expectAT bool) (*authDataParsed, error) {
 		SignCount: binary.BigEndian.Uint32(data[33:37]),
 	}
 	if expectAT {
-		if ad.Flags&0x40 == 0 {
+		if ad.Flags&flagAT == 0 {
 			return nil, errors.New("AT flag not set")
 		}
 		if len(data) < 55 {
@@ -335,8 +417,18 @@ func parseAuthData(data []byte, expectAT bool) (*authDataParsed, error) {
 		if len(data) < 55+credIDLen {
 			return nil, errors.New("credentialId truncated")
 		}
+		ad.AAGUID = data[37:53]
 		ad.CredentialID = data[55 : 55+credIDLen]
-		ad.CredentialPublicKey = data[55+credIDLen:]
+		rest := data[55+credIDLen:]
+		// the COSE key may be followed by extension data when ED is set
+		_, n, err := cborDecode(rest)
+		if err != nil {
+			return nil, fmt.Errorf("credentialPublicKey: %w", err)
+		}
+		if n < len(rest) && ad.Flags&flagED == 0 {
+			return nil, errors.New("trailing bytes after credentialPublicKey")
+		}
+		ad.CredentialPublicKey = rest[:n]
 	}
 	return ad, nil
 }
@@ -373,6 +465,15 @@ func parseCOSEKey(b []byte) (crypto.PublicKey, error) {
 			return nil, fmt.Errorf("cose: unsupported crv %v", m[int64(-1)])
 		}
 		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(xb), Y: new(big.Int).SetBytes(yb)}, nil
+	case uint64(1): // OKP
+		if m[int64(-1)] != uint64(6) {
+			return nil, fmt.Errorf("cose: unsupported OKP crv %v", m[int64(-1)])
+		}
+		xb, _ := m[int64(-2)].([]byte)
+		if len(xb) != ed25519.PublicKeySize {
+			return nil, errors.New("cose: bad Ed25519 x")
+		}
+		return ed25519.PublicKey(xb), nil
 	case uint64(3): // RSA
 		nb, _ := m[int64(-1)].([]byte)
 		eb, _ := m[int64(-2)].([]byte)
@@ -387,6 +488,12 @@ func parseCOSEKey(b []byte) (crypto.PublicKey, error) {
 type ecdsaSig struct{ R, S *big.Int }
 
 func waVerifySignature(pub crypto.PublicKey, data, sig []byte) error {
+	if key, ok := pub.(ed25519.PublicKey); ok {
+		if !ed25519.Verify(key, data, sig) {
+			return errors.New("ed25519: invalid signature")
+		}
+		return nil
+	}
 	hash := sha256.Sum256(data)
 	switch key := pub.(type) {
 	case *ecdsa.PublicKey:
@@ -0,0 +1,447 @@
+package authflow
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"backend-go/internal/auth/challenge"
+)
+
+// ----- a minimal CBOR encoder and a software authenticator -----
+
+// cmap is a CBOR map with its keys in encoding order.
+type cmap [][2]interface{}
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= math.MaxUint8:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+}
+
+func cborEnc(v interface{}) []byte {
+	switch x := v.(type) {
+	case int:
+		return cborEnc(int64(x))
+	case int64:
+		if x < 0 {
+			return cborHead(1, uint64(-x-1))
+		}
+		return cborHead(0, uint64(x))
+	case uint64:
+		return cborHead(0, x)
+	case []byte:
+		return append(cborHead(2, uint64(len(x))), x...)
+	case string:
+		return append(cborHead(3, uint64(len(x))), x...)
+	case []interface{}:
+		out := cborHead(4, uint64(len(x)))
+		for _, e := range x {
+			out = append(out, cborEnc(e)...)
+		}
+		return out
+	case cmap:
+		out := cborHead(5, uint64(len(x)))
+		for _, kv := range x {
+			out = append(out, cborEnc(kv[0])...)
+			out = append(out, cborEnc(kv[1])...)
+		}
+		return out
+	}
+	panic("cborEnc: unsupported type")
+}
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// softAuth is an in-process authenticator holding one credential.
+type softAuth struct {
+	key     crypto.Signer
+	alg     int64
+	cose    []byte
+	credID  []byte
+	aaguid  []byte
+	counter uint32
+}
+
+func newSoftAuth(t *testing.T, kind string) *softAuth {
+	t.Helper()
+	a := &softAuth{credID: make([]byte, 16), aaguid: make([]byte, 16)}
+	_, _ = rand.Read(a.credID)
+	switch kind {
+	case "ed25519":
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.key, a.alg = priv, -8
+		a.cose = cborEnc(cmap{{1, 1}, {3, -8}, {-1, 6}, {-2, []byte(pub)}})
+	case "es256":
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.key, a.alg = priv, -7
+		a.cose = cborEnc(cmap{{1, 2}, {3, -7}, {-1, 1},
+			{-2, priv.X.FillBytes(make([]byte, 32))}, {-3, priv.Y.FillBytes(make([]byte, 32))}})
+	default:
+		t.Fatalf("unknown key kind %q", kind)
+	}
+	return a
+}
+
+func (a *softAuth) sign(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var sig []byte
+	var err error
+	if _, ok := a.key.(ed25519.PrivateKey); ok {
+		sig, err = a.key.Sign(rand.Reader, data, crypto.Hash(0))
+	} else {
+		h := sha256.Sum256(data)
+		sig, err = a.key.Sign(rand.Reader, h[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+// authData builds authenticator data; attested credential data is appended
+// when flags carries AT.
+func (a *softAuth) authData(flags byte) []byte {
+	rp := sha256.Sum256([]byte(testRPID))
+	out := append(rp[:], flags)
+	out = binary.BigEndian.AppendUint32(out, a.counter)
+	if flags&flagAT != 0 {
+		out = append(out, a.aaguid...)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(a.credID)))
+		out = append(out, a.credID...)
+		out = append(out, a.cose...)
+	}
+	return out
+}
+
+func clientData(t *testing.T, typ, chal string) []byte {
+	t.Helper()
+	b, err := json.Marshal(waClientData{Type: typ, Challenge: chal, Origin: testOrigin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// create answers a registration with the given attestation statement; stmt
+// receives authData and the clientData hash and returns (fmt, attStmt).
+func (a *softAuth) create(t *testing.T, opts *RegBeginOptions, flags byte,
+	stmt func(authData, cdHash []byte) (string, cmap)) *CredentialCreationResponse {
+	t.Helper()
+	cd := clientData(t, "webauthn.create", opts.Challenge)
+	ad := a.authData(flags | flagAT)
+	h := sha256.Sum256(cd)
+	format, st := "none", cmap{}
+	if stmt != nil {
+		format, st = stmt(ad, h[:])
+	}
+	att := cborEnc(cmap{{"fmt", format}, {"attStmt", st}, {"authData", ad}})
+	return &CredentialCreationResponse{
+		ID:    b64(a.credID),
+		RawID: b64(a.credID),
+		Type:  "public-key",
+		Response: AttestationResponse{
+			ClientDataJSON:    b64(cd),
+			AttestationObject: b64(att),
+		},
+	}
+}
+
+// get answers an assertion; userHandle is sent only when non-empty.
+func (a *softAuth) get(t *testing.T, opts *LoginBeginOptions, flags byte, userHandle string) *CredentialAssertionResponse {
+	t.Helper()
+	cd := clientData(t, "webauthn.get", opts.Challenge)
+	ad := a.authData(flags)
+	h := sha256.Sum256(cd)
+	resp := &CredentialAssertionResponse{
+		ID:    b64(a.credID),
+		RawID: b64(a.credID),
+		Type:  "public-key",
+		Response: AssertionResponse{
+			ClientDataJSON:    b64(cd),
+			AuthenticatorData: b64(ad),
+			Signature:         b64(a.sign(t, concatBytes(ad, h[:]))),
+		},
+	}
+	if userHandle != "" {
+		resp.Response.UserHandle = b64([]byte(userHandle))
+	}
+	return resp
+}
+
+func testWebAuthn(policy AttestationPolicy) (*waPool, *Config) {
+	return &waPool{store: challenge.NewMemory()}, &Config{
+		WebAuthnRPID:    testRPID,
+		WebAuthnRPName:  "Test",
+		WebAuthnOrigins: []string{testOrigin},
+		WebAuthnPolicy:  policy,
+	}
+}
+
+// register runs a full registration ceremony for admin.
+func register(t *testing.T, a *softAuth, pool *waPool, cfg *Config, flags byte) (*Credential, error) {
+	t.Helper()
+	opts, err := beginRegistration(pool, cfg, "admin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return finishRegistration(pool, cfg, opts.SessionID, a.create(t, opts, flags, nil))
+}
+
+// ----- CBOR -----
+
+func TestCBORDecode(t *testing.T) {
+	// RFC 8949 Appendix A
+	for _, tc := range []struct {
+		hex  string
+		want interface{}
+	}{
+		{"00", uint64(0)},
+		{"1819", uint64(25)},
+		{"1b000000e8d4a51000", uint64(1000000000000)},
+		{"20", int64(-1)},
+		{"3903e7", int64(-1000)},
+		{"f90001", float64(5.960464477539063e-8)},
+		{"f93c00", float64(1)},
+		{"fb3ff199999999999a", 1.1},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"f820", cborSimple(32)},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"6449455446", "IETF"},
+		{"c074323031332d30332d32315432303a30343a30305a", "2013-03-21T20:04:00Z"},
+		{"8301820203820405", []interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}},
+		{"a201020304", map[interface{}]interface{}{uint64(1): uint64(2), uint64(3): uint64(4)}},
+		{"5f42010243030405ff", []byte{1, 2, 3, 4, 5}},
+		{"7f657374726561646d696e67ff", "streaming"},
+		{"9f018202039f0405ffff", []interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}},
+		{"bf61610161629f0203ffff", map[interface{}]interface{}{"a": uint64(1), "b": []interface{}{uint64(2), uint64(3)}}},
+		{"a1420102f5", map[interface{}]interface{}{"\x01\x02": true}},
+	} {
+		data, _ := hex.DecodeString(tc.hex)
+		got, n, err := cborDecode(data)
+		if err != nil || n != len(data) || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %#v (%d bytes), %v; want %#v", tc.hex, got, n, err, tc.want)
+		}
+	}
+
+	for _, bad := range []string{
+		"",
+		"18",                 // truncated uint8
+		"45010203",           // short bytes
+		"ff",                 // break at top level
+		"81ff",               // break inside a definite array
+		"9f01",               // missing break
+		"5f4101610262ff",     // text chunk in a byte string
+		"5f5f4101ffff",       // nested indefinite string
+		"bf01ff",             // map missing value
+		"a1810102",           // array as map key
+		"f801",               // reserved simple value
+		"1c",                 // reserved additional info
+		"9b0000000100000000", // array longer than the input
+		strings.Repeat("81", cborMaxDepth+2) + "00",
+	} {
+		data, _ := hex.DecodeString(bad)
+		if v, _, err := cborDecode(data); err == nil {
+			t.Errorf("%s: decoded %#v", bad, v)
+		}
+	}
+}
+
+// ----- COSE keys -----
+
+func TestParseCOSEKey(t *testing.T) {
+	for _, kind := range []string{"ed25519", "es256"} {
+		a := newSoftAuth(t, kind)
+		pub, err := parseCOSEKey(a.cose)
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		data := []byte("signed data")
+		if err := waVerifySignature(pub, data, a.sign(t, data)); err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if err := waVerifySignature(pub, []byte("other data"), a.sign(t, data)); err == nil {
+			t.Fatalf("%s: signature over other data accepted", kind)
+		}
+		alg, err := coseKeyAlg(a.cose)
+		if err != nil || alg != a.alg {
+			t.Fatalf("%s: alg %d %v", kind, alg, err)
+		}
+	}
+	for name, key := range map[string]cmap{
+		"no kty":        {{3, -8}, {-1, 6}, {-2, make([]byte, 32)}},
+		"X25519 curve":  {{1, 1}, {3, -8}, {-1, 4}, {-2, make([]byte, 32)}},
+		"short Ed25519": {{1, 1}, {3, -8}, {-1, 6}, {-2, make([]byte, 31)}},
+		"unknown crv":   {{1, 2}, {3, -7}, {-1, 9}, {-2, []byte{1}}, {-3, []byte{1}}},
+		"EC2 without y": {{1, 2}, {3, -7}, {-1, 1}, {-2, []byte{1}}},
+		"symmetric":     {{1, 4}, {-1, make([]byte, 32)}},
+	} {
+		if _, err := parseCOSEKey(cborEnc(key)); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+// ----- ceremonies -----
+
+func TestUsernamelessLogin(t *testing.T) {
+	for _, kind := range []string{"ed25519", "es256"} {
+		a := newSoftAuth(t, kind)
+		pool, cfg := testWebAuthn(AttestationPolicy{})
+		cred, err := register(t, a, pool, cfg, flagUP|flagUV)
+		if err != nil {
+			t.Fatalf("%s register: %v", kind, err)
+		}
+		if cred.Username != "admin" || cred.AttestationType != "none/none" || cred.ID != b64(a.credID) {
+			t.Fatalf("%s credential: %+v", kind, cred)
+		}
+		getCreds := func(username string) ([]Credential, error) {
+			if username != "admin" {
+				return nil, nil
+			}
+			return []Credential{*cred}, nil
+		}
+		login := func(userHandle string) (*Credential, error) {
+			opts, err := beginLogin(pool, cfg, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(opts.AllowCredentials) != 0 {
+				t.Fatalf("discoverable login lists credentials: %+v", opts.AllowCredentials)
+			}
+			a.counter++
+			return finishLogin(pool, cfg, opts.SessionID, a.get(t, opts, flagUP|flagUV, userHandle), getCreds)
+		}
+
+		got, err := login("admin")
+		if err != nil || got.ID != cred.ID || got.Counter != a.counter {
+			t.Fatalf("%s login: %+v %v", kind, got, err)
+		}
+		if _, err := login(""); err == nil || !strings.Contains(err.Error(), "userHandle") {
+			t.Fatalf("%s: login without userHandle: %v", kind, err)
+		}
+		if _, err := login("mallory"); err == nil {
+			t.Fatalf("%s: login as another user accepted", kind)
+		}
+	}
+}
+
+func TestLoginRejectsReplayAndForeignKeys(t *testing.T) {
+	a := newSoftAuth(t, "ed25519")
+	pool, cfg := testWebAuthn(AttestationPolicy{})
+	cred, err := register(t, a, pool, cfg, flagUP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := []Credential{*cred}
+	getCreds := func(string) ([]Credential, error) { return creds, nil }
+
+	opts, _ := beginLogin(pool, cfg, "admin", creds)
+	a.counter = 1
+	resp := a.get(t, opts, flagUP, "")
+	if _, err := finishLogin(pool, cfg, opts.SessionID, resp, getCreds); err != nil {
+		t.Fatal(err)
+	}
+	// the challenge is single-use
+	if _, err := finishLogin(pool, cfg, opts.SessionID, resp, getCreds); err == nil {
+		t.Fatal("replayed assertion accepted")
+	}
+
+	// a different key claiming the same credential ID
+	other := newSoftAuth(t, "ed25519")
+	other.credID, other.counter = a.credID, 2
+	opts, _ = beginLogin(pool, cfg, "admin", creds)
+	if _, err := finishLogin(pool, cfg, opts.SessionID, other.get(t, opts, flagUP, ""), getCreds); err == nil {
+		t.Fatal("assertion from another key accepted")
+	}
+
+	// a counter that does not increase still returns the credential
+	creds[0].Counter = 5
+	a.counter = 5
+	opts, _ = beginLogin(pool, cfg, "admin", creds)
+	got, err := finishLogin(pool, cfg, opts.SessionID, a.get(t, opts, flagUP, ""), getCreds)
+	if !errors.Is(err, ErrCounterRegression) || got == nil {
+		t.Fatalf("counter regression: %v %v", got, err)
+	}
+}
+
+func TestBackupFlags(t *testing.T) {
+	a := newSoftAuth(t, "es256")
+	pool, cfg := testWebAuthn(AttestationPolicy{})
+	if _, err := register(t, a, pool, cfg, flagUP|flagBS); err == nil {
+		t.Fatal("BS without BE accepted at registration")
+	}
+	cred, err := register(t, a, pool, cfg, flagUP|flagBE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cred.BackupEligible || cred.BackupState {
+		t.Fatalf("flags: %+v", cred)
+	}
+	getCreds := func(string) ([]Credential, error) { return []Credential{*cred}, nil }
+	login := func(flags byte) (*Credential, error) {
+		opts, _ := beginLogin(pool, cfg, "admin", []Credential{*cred})
+		a.counter++
+		return finishLogin(pool, cfg, opts.SessionID, a.get(t, opts, flags, ""), getCreds)
+	}
+
+	// the passkey got synced: BS is refreshed
+	got, err := login(flagUP | flagBE | flagBS)
+	if err != nil || !got.BackupState {
+		t.Fatalf("synced login: %+v %v", got, err)
+	}
+	if _, err := login(flagUP); err == nil || !strings.Contains(err.Error(), "eligibility") {
+		t.Fatalf("BE dropped: %v", err)
+	}
+	if _, err := login(flagUP | flagBS); err == nil {
+		t.Fatal("BS without BE accepted at login")
+	}
+}
+
+func TestParseAuthDataExtensions(t *testing.T) {
+	a := newSoftAuth(t, "ed25519")
+	ad := a.authData(flagUP | flagAT)
+	ext := cborEnc(cmap{{"credProtect", 2}})
+	if _, err := parseAuthData(append(bytes.Clone(ad), ext...), true); err == nil {
+		t.Fatal("trailing bytes accepted without ED")
+	}
+	ad[32] |= flagED
+	p, err := parseAuthData(append(ad, ext...), true)
+	if err != nil || !bytes.Equal(p.CredentialPublicKey, a.cose) {
+		t.Fatalf("with extensions: %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package envinit
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"os"
 	"path/filepath"
@@ -29,10 +31,20 @@ func defaultEnv() []byte {
 			// 上传单文件大小上限（MB）
 			"AVATAR_MAX_MB=5\n" +
 			// WebP 质量（0-100）
-			"AVATAR_WEBP_QUALITY=80\n",
+			"AVATAR_WEBP_QUALITY=80\n" +
+			// 缩放图：URL 签名密钥、缓存目录（不能在 AVATAR_DIR 里）、srcset 宽度档位
+			"AVATAR_IMAGE_HMAC_KEY=" + randHex(32) + "\n" +
+			"AVATAR_VARIANT_DIR=storage/avatar/variants\n" +
+			"AVATAR_SRCSET_WIDTHS=64,128,256,512\n",
 	)
 }
 
+func randHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 // Init: 在工作目录（失败回退可执行目录）创建 config/avatar/.env 并加载
 func Init() {
 	base := paths.ExecDir()
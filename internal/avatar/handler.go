@@ -11,13 +11,16 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-type Handler struct{ svc *Service }
+type Handler struct {
+	svc    *Service
+	prefix string // API 前缀，拼缩放图 URL 用
+}
 
-func NewHandler(s *Service) *Handler { return &Handler{svc: s} }
+func NewHandler(s *Service, prefix string) *Handler { return &Handler{svc: s, prefix: prefix} }
 
 // POST /api/avatar
 // 支持 multipart/form-data（字段名：file）或原始二进制体）
-// 仅返回 { "avatarId": "<md5hex>" }
+// 返回 { "avatarId": "<md5hex>", "srcset": "<缩放图 srcset>" }
 func (h *Handler) Upload(c *gin.Context) {
 	var reader io.Reader
 	ct := c.GetHeader("Content-Type")
@@ -43,11 +46,11 @@ func (h *Handler) Upload(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"avatarId": id})
+	c.JSON(http.StatusOK, gin.H{"avatarId": id, "srcset": h.svc.Srcset(h.prefix, id)})
 }
 
 // GET /api/avatar/:id
-// 只返回 { "avatarId": "<md5hex>" }；若不存在则 404
+// 返回 { "avatarId": "<md5hex>", "srcset": ... }；若不存在则 404
 func (h *Handler) Get(c *gin.Context) {
 	id := c.Param("id")
 	if id == "" {
@@ -63,7 +66,13 @@ func (h *Handler) Get(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"avatarId": id})
+	c.JSON(http.StatusOK, gin.H{"avatarId": id, "srcset": h.svc.Srcset(h.prefix, id)})
+}
+
+// GET /api/avatar/image/:id?w=&h=&fit=&q=&sig=
+// 输出签名过的缩放图，格式按 Accept 协商。
+func (h *Handler) Image(c *gin.Context) {
+	h.svc.Images.Serve(c.Writer, c.Request, c.Param("id"))
 }
 
 func safeClose(f multipart.File) { _ = f.Close() }
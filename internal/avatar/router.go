@@ -2,13 +2,17 @@ package avatar
 
 import (
 	"backend-go/internal/avatar/envinit"
+	"backend-go/internal/risk/rules"
+
 	"github.com/gin-gonic/gin"
 )
 
 func Mount(r *gin.RouterGroup, svc *Service) {
-	h := NewHandler(svc)
+	h := NewHandler(svc, r.BasePath())
+	r = r.Group("", rules.Shared().Guard(rules.GuardOptions{Scope: "avatar"}))
 	r.POST("", h.Upload)
 	r.GET("/:id", h.Get)
+	r.GET("/image/:id", h.Image)
 }
 
 // mountStaticOnce 把 dir 暴露到 prefix；如果该前缀的 GET 路由已经存在就跳过，
@@ -2,7 +2,11 @@ package avatar
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
 	"crypto/md5" // #nosec G401: acceptable for content addressing
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"image"
@@ -15,6 +19,10 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
+
+	"backend-go/pkg/imgproxy"
+	"backend-go/pkg/objstore"
 
 	_ "golang.org/x/image/webp" // accept webp uploads (pure-Go decoder)
 )
@@ -28,6 +36,9 @@ type Service struct {
 	URLPrefix string  // 返回 URL 的前缀
 	MaxBytes  int64   // 单文件最大字节
 	Quality   float32 // 保留字段；当前编码使用无损 PNG，故此值未使用
+	// Images 生成缩放后的头像（GET /image/:id），Widths 是 srcset 的宽度档位
+	Images *imgproxy.Server
+	Widths []int
 }
 
 func NewServiceFromEnv() (*Service, error) {
@@ -42,12 +53,75 @@ func NewServiceFromEnv() (*Service, error) {
 	}
 	log.Printf("[avatar] dir=%q url_prefix=%q maxMB=%d quality=%d", dir, urlp, maxMB, q)
 
-	return &Service{
+	svc := &Service{
 		Dir:       dir,
 		URLPrefix: urlp,
 		MaxBytes:  int64(maxMB) * (1 << 20),
 		Quality:   float32(q),
-	}, nil
+	}
+	for _, v := range strings.Split(getenv("AVATAR_SRCSET_WIDTHS", "64,128,256,512"), ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n > 0 {
+			svc.Widths = append(svc.Widths, n)
+		}
+	}
+	key := []byte(os.Getenv("AVATAR_IMAGE_HMAC_KEY"))
+	if len(key) < 16 {
+		// 老配置里没有这一项：用进程内随机密钥，重启后旧的缩放 URL 失效
+		log.Printf("[avatar] AVATAR_IMAGE_HMAC_KEY not set, image URLs will change on restart")
+		key = make([]byte, 32)
+		_, _ = rand.Read(key)
+	}
+	// 缩放变体不能放进 Dir：Dir 整个被静态挂载出去了
+	cache, err := objstore.NewLocal(getenv("AVATAR_VARIANT_DIR", "storage/avatar/variants"), deriveKey(key, "avatar/objects"))
+	if err != nil {
+		return nil, err
+	}
+	svc.Images, err = imgproxy.New(imgproxy.Config{
+		Key:         deriveKey(key, "avatar/images"),
+		Cache:       cache,
+		CachePrefix: "avatar",
+		MaxSide:     1024,
+		Source:      svc.open,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
+// deriveKey 按用途从 AVATAR_IMAGE_HMAC_KEY 派生子密钥，使缩放 URL 的签名
+// 不能被当成本地存储的一次性 token 使用（反之亦然）。
+func deriveKey(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// open 打开头像原图，id 必须是 md5 十六进制。
+func (s *Service) open(_ context.Context, id string) (io.ReadCloser, error) {
+	if !isAvatarID(id) {
+		return nil, objstore.ErrNotFound
+	}
+	f, err := os.Open(filepath.Join(s.Dir, id+".png"))
+	if os.IsNotExist(err) {
+		return nil, objstore.ErrNotFound
+	}
+	return f, err
+}
+
+func isAvatarID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// Srcset 返回正方形裁剪的头像缩放图 srcset（Width / Height 只给出 1:1 的
+// 比例）；头像按内容寻址，URL 不设过期。
+func (s *Service) Srcset(apiPrefix, id string) string {
+	square := imgproxy.Options{Width: 1, Height: 1, Fit: imgproxy.FitCover}
+	return s.Images.Srcset(strings.TrimRight(apiPrefix, "/")+"/image", id, s.Widths, square, time.Time{})
 }
 
 func getenv(k, def string) string {
@@ -26,7 +26,21 @@ func defaultEnv() []byte {
 			"# Comments module config.\n\n" +
 			"COMMENTS_SQLITE_PATH=databases/comments/comments.db\n\n" +
 			"# JWT secret for admin endpoints (falls back to ROUNDNFC_JWT_SECRET if empty)\n" +
-			"COMMENTS_JWT_SECRET=" + randHex(32) + "\n",
+			"COMMENTS_JWT_SECRET=" + randHex(32) + "\n" +
+			"# 创建 / 修改 API token 需要 N 分钟内登录或重新验证过（JWT 的 auth_time）\n" +
+			"COMMENTS_STEPUP_MAX_AGE_MINUTES=5\n\n" +
+			"# 发评论限流（每 IP）：<次数>/<周期>[,burst=N][,algo=gcra|bucket]，off 关闭\n" +
+			"COMMENTS_RATELIMIT_CREATE=5/1m,burst=3\n\n" +
+			"# 发评论的人机验证：turnstile | hcaptcha | recaptcha | pow | off（留空且无 secret 即关闭）\n" +
+			"#   前端先 GET /captcha 取配置，token 放 body.captcha_token 或 X-Captcha-Token 头\n" +
+			"COMMENTS_CAPTCHA_PROVIDER=\n" +
+			"COMMENTS_CAPTCHA_SECRET=\n" +
+			"COMMENTS_CAPTCHA_SITE_KEY=\n\n" +
+			"# 垃圾分达到阈值的评论进 pending 等审核；屏蔽词逗号分隔，或用 _FILE 每行一个\n" +
+			"#   其他：COMMENTS_SPAM_MAX_LINKS=2 / _REPEAT_WINDOW=24h / _VELOCITY=5/10m / _MIN_TRAINED=10 / _ENABLED=false\n" +
+			"COMMENTS_SPAM_THRESHOLD=0.7\n" +
+			"COMMENTS_SPAM_BLOCKED_WORDS=\n" +
+			"COMMENTS_SPAM_BLOCKED_WORDS_FILE=\n",
 	)
 }
 
@@ -4,10 +4,15 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"backend-go/internal/risk"
+	"backend-go/internal/risk/rules"
+	"backend-go/internal/risk/spam"
+
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
@@ -34,6 +39,9 @@ func (h *publicHandler) ListComments(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
 		return
 	}
+	for i := range comments {
+		comments[i].SpamScore, comments[i].SpamReasons = 0, nil
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"comments": comments,
 		"total":    total,
@@ -46,11 +54,22 @@ func (h *publicHandler) CreateComment(c *gin.Context) {
 		Author   string `json:"author" binding:"required"`
 		Content  string `json:"content" binding:"required"`
 		ReplyTo  string `json:"reply_to"`
+		Captcha  string `json:"captcha_token"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: post_slug, author, content are required"})
 		return
 	}
+	ok, err := risk.Check(c, h.svc.captcha, req.Captcha)
+	if err != nil {
+		log.Printf("[comments] %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "captcha verify failed"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "captcha verification failed"})
+		return
+	}
 
 	req.Author = strings.TrimSpace(req.Author)
 	req.Content = strings.TrimSpace(req.Content)
@@ -79,8 +98,7 @@ func (h *publicHandler) CreateComment(c *gin.Context) {
 		}
 	}
 
-	ip := c.ClientIP()
-	ipHash := fmt.Sprintf("%x", sha256.Sum256([]byte(ip)))
+	ipHash := hashIP(c.ClientIP())
 
 	comment := &Comment{
 		ID:       uuid.NewString(),
@@ -91,11 +109,33 @@ func (h *publicHandler) CreateComment(c *gin.Context) {
 		IPHash:   ipHash,
 		Status:   StatusApproved,
 	}
+	// high spam scores wait for moderation instead of going live
+	score, err := h.svc.spam.Check(c.Request.Context(), spam.Submission{Text: spamText(comment), IPHash: ipHash})
+	if err != nil {
+		log.Printf("[comments] spam check: %v", err)
+	}
+	comment.SpamScore, comment.SpamReasons = score.Score, score.Reasons
+	if score.Spam {
+		comment.Status = StatusPending
+		log.Printf("[comments] comment %s held for review: score=%.2f %v", comment.ID, score.Score, score.Reasons)
+	}
 	if err := h.svc.store.InsertComment(c.Request.Context(), comment); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
 		return
 	}
-	c.JSON(http.StatusCreated, comment)
+	out := *comment
+	out.SpamScore, out.SpamReasons = 0, nil
+	c.JSON(http.StatusCreated, out)
+}
+
+// spamText is what the spam filter scores and learns from.
+func spamText(c *Comment) string {
+	return c.Author + "\n" + c.Content
+}
+
+// hashIP is the ip_hash stored on comments and matched by risk rules.
+func hashIP(ip string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(ip)))
 }
 
 type adminHandler struct {
@@ -138,7 +178,12 @@ func (h *adminHandler) UpdateStatus(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
 		return
 	}
-	if err := h.svc.store.UpdateStatus(c.Request.Context(), id, req.Status); err != nil {
+	ctx := c.Request.Context()
+	cm, err := h.svc.store.GetComment(ctx, id)
+	if err == nil {
+		err = h.svc.store.UpdateStatus(ctx, id, req.Status)
+	}
+	if err != nil {
 		if errors.Is(err, ErrNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
 			return
@@ -146,9 +191,30 @@ func (h *adminHandler) UpdateStatus(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
 		return
 	}
+	h.train(c, cm, req.Status)
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
+// train feeds moderation decisions back into the spam classifier: spam is a
+// spam sample, approved a ham sample, and moving back to pending withdraws it.
+// Deleting says nothing about the content, so it leaves training alone.
+func (h *adminHandler) train(c *gin.Context, cm *Comment, status string) {
+	ctx := c.Request.Context()
+	ref := "comment:" + cm.ID
+	var err error
+	switch status {
+	case StatusSpam:
+		err = h.svc.spam.Train(ctx, ref, spamText(cm), true)
+	case StatusApproved:
+		err = h.svc.spam.Train(ctx, ref, spamText(cm), false)
+	case StatusPending:
+		err = h.svc.spam.Forget(ctx, ref)
+	}
+	if err != nil {
+		log.Printf("[comments] spam train %s: %v", ref, err)
+	}
+}
+
 func (h *adminHandler) Delete(c *gin.Context) {
 	id := c.Param("id")
 	if err := h.svc.store.DeleteComment(c.Request.Context(), id); err != nil {
@@ -161,3 +227,56 @@ func (h *adminHandler) Delete(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
+
+// SpamStats reports how many samples the spam classifier has learned; it only
+// takes part in scoring once both classes reach COMMENTS_SPAM_MIN_TRAINED.
+func (h *adminHandler) SpamStats(c *gin.Context) {
+	st, err := h.svc.spam.Stats(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": h.svc.spam.Enabled(), "stats": st})
+}
+
+// BlockSender adds a risk rule against the ip_hash of a comment's author.
+// Body: {action, ttlSeconds, note}; action defaults to block.
+func (h *adminHandler) BlockSender(c *gin.Context) {
+	var p rules.CreatePayload
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&p); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+			return
+		}
+	}
+	if p.Action == "" {
+		p.Action = rules.ActionBlock
+	}
+	ctx := c.Request.Context()
+	cm, err := h.svc.store.GetComment(ctx, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	if cm.IPHash == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "comment has no ip hash"})
+		return
+	}
+	if p.Note == "" {
+		p.Note = "comment/" + cm.ID
+	}
+	r, err := h.svc.rules.Add(ctx, rules.Rule{Scope: riskScope, Kind: rules.KindIPHash, Value: cm.IPHash, Action: p.Action, Note: p.Note}, p.TTL())
+	if err != nil {
+		if errors.Is(err, rules.ErrInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": strings.TrimPrefix(err.Error(), "rules: ")})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "rule": r})
+}
@@ -2,7 +2,9 @@ package comments
 
 import (
 	"backend-go/internal/auth"
+	"backend-go/internal/auth/apitoken"
 	"backend-go/internal/comments/envinit"
+	"backend-go/internal/risk"
 
 	"github.com/gin-gonic/gin"
 )
@@ -22,13 +24,22 @@ func AttachTo(engine *gin.Engine, prefix string) error {
 
 	g := engine.Group(prefix)
 
-	g.GET("/comments", pub.ListComments)
-	g.POST("/comments", pub.CreateComment)
-
-	admin := g.Group("/admin", auth.Required(svc.cfg.JWTSecret))
-	admin.GET("/comments", adm.ListAll)
-	admin.PATCH("/comments/:id", adm.UpdateStatus)
-	admin.DELETE("/comments/:id", adm.Delete)
+	public := g.Group("", svc.riskGuard())
+	public.GET("/comments", pub.ListComments)
+	public.GET("/captcha", risk.ConfigHandler(svc.captcha))
+	public.POST("/comments", svc.limiter.Handle(rlCreateComment), pub.CreateComment)
+
+	// admin JWT, or an API token (X-API-Token / Bearer cmts_…) with the route's scope
+	admin := g.Group("/admin", svc.tokens.Required(svc.cfg.JWTSecret, "X-API-Token"))
+	admin.GET("/comments", apitoken.Scope(scopeCommentsRead), adm.ListAll)
+	admin.PATCH("/comments/:id", apitoken.Scope(scopeCommentsModerate), adm.UpdateStatus)
+	admin.DELETE("/comments/:id", apitoken.Scope(scopeCommentsDelete), adm.Delete)
+	admin.POST("/comments/:id/block", apitoken.AdminOnly(), adm.BlockSender)
+	admin.GET("/spam", apitoken.Scope(scopeCommentsRead), adm.SpamStats)
+	// risk rules scoped to this module (global rules are managed in RoundNFC)
+	svc.rules.Mount(admin.Group("/risk", apitoken.AdminOnly()), riskScope)
+	// comments has no login of its own: minting needs a recent login at the issuer
+	svc.tokens.Mount(admin.Group("/api-tokens"), auth.Fresh(svc.cfg.StepUpAge))
 
 	return nil
 }
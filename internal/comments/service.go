@@ -2,23 +2,60 @@ package comments
 
 import (
 	"fmt"
+	"net/http"
 	"os"
+	"strconv"
+	"time"
+
+	"backend-go/internal/auth/apitoken"
+	"backend-go/internal/auth/challenge"
+	"backend-go/internal/risk"
+	"backend-go/internal/risk/ratelimit"
+	"backend-go/internal/risk/rules"
+	"backend-go/internal/risk/spam"
+
+	"github.com/gin-gonic/gin"
 )
 
 type Config struct {
 	SQLitePath string
 	JWTSecret  []byte
+	RateLimits map[string]ratelimit.Policy
+	StepUpAge  time.Duration // creating or editing API tokens needs a login this recent
 }
 
 type Service struct {
-	cfg   Config
-	store *Store
+	cfg     Config
+	store   *Store
+	tokens  *apitoken.Service
+	limiter *ratelimit.Limiter
+	captcha risk.Verifier
+	rules   *rules.Engine
+	spam    *spam.Filter
 }
 
+// Rate-limited routes; override with COMMENTS_RATELIMIT_<NAME>.
+const rlCreateComment = "create"
+
+// riskScope is the module name used for risk rules.
+const riskScope = "comments"
+
+// API token scopes.
+const (
+	scopeCommentsRead     = "comments:comments:read"
+	scopeCommentsModerate = "comments:comments:moderate"
+	scopeCommentsDelete   = "comments:comments:delete"
+)
+
 func NewServiceFromEnv() (*Service, error) {
 	cfg := Config{
 		SQLitePath: envOr("COMMENTS_SQLITE_PATH", "databases/comments/comments.db"),
 		JWTSecret:  []byte(os.Getenv("COMMENTS_JWT_SECRET")),
+		RateLimits: ratelimit.PoliciesFromEnv("COMMENTS", map[string]string{rlCreateComment: "5/1m,burst=3"}),
+		StepUpAge:  5 * time.Minute,
+	}
+	if n, err := strconv.Atoi(os.Getenv("COMMENTS_STEPUP_MAX_AGE_MINUTES")); err == nil && n > 0 {
+		cfg.StepUpAge = time.Duration(n) * time.Minute
 	}
 	if len(cfg.JWTSecret) == 0 {
 		cfg.JWTSecret = []byte(os.Getenv("ROUNDNFC_JWT_SECRET"))
@@ -27,7 +64,54 @@ func NewServiceFromEnv() (*Service, error) {
 	if err != nil {
 		return nil, fmt.Errorf("comments: open store: %w", err)
 	}
-	return &Service{cfg: cfg, store: store}, nil
+	tokens, err := apitoken.New(store.db, apitoken.Options{
+		Prefix: "cmts_",
+		Scopes: []string{scopeCommentsRead, scopeCommentsModerate, scopeCommentsDelete},
+	})
+	if err != nil {
+		_ = store.Close()
+		return nil, fmt.Errorf("comments: api tokens: %w", err)
+	}
+	challenges, err := challenge.NewSQL(store.db, "captcha_challenges")
+	if err != nil {
+		_ = store.Close()
+		return nil, fmt.Errorf("comments: captcha store: %w", err)
+	}
+	captcha, err := risk.VerifierFromEnv("COMMENTS", challenges)
+	if err != nil {
+		_ = store.Close()
+		return nil, fmt.Errorf("comments: %w", err)
+	}
+	filter, err := spam.New(store.db, spam.ConfigFromEnv("COMMENTS"))
+	if err != nil {
+		_ = store.Close()
+		return nil, fmt.Errorf("comments: spam filter: %w", err)
+	}
+	limiter := ratelimit.New(ratelimit.Options{
+		Namespace: "comments",
+		Store:     ratelimit.SharedStore(),
+		Policies:  cfg.RateLimits,
+		Deny: func(c *gin.Context, _ ratelimit.Result) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many comments, slow down"})
+		},
+	})
+	return &Service{cfg: cfg, store: store, tokens: tokens, limiter: limiter, captcha: captcha, rules: rules.Shared(), spam: filter}, nil
+}
+
+// riskGuard applies admin-managed IP / CIDR / ip_hash rules to public routes.
+func (s *Service) riskGuard() gin.HandlerFunc {
+	return s.rules.Guard(rules.GuardOptions{
+		Scope:   riskScope,
+		Hash:    hashIP,
+		Captcha: s.captcha,
+		Deny: func(c *gin.Context, d rules.Decision) {
+			if d.Action == rules.ActionChallenge {
+				c.JSON(http.StatusForbidden, gin.H{"error": "captcha verification required", "needs_captcha": true})
+				return
+			}
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		},
+	})
 }
 
 func envOr(key, def string) string {
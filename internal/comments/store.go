@@ -56,7 +56,36 @@ CREATE TABLE IF NOT EXISTS comments (
 CREATE INDEX IF NOT EXISTS idx_comments_post ON comments(post_slug, status, created_at);
 CREATE INDEX IF NOT EXISTS idx_comments_reply ON comments(reply_to);
 `
-	_, err := s.db.Exec(ddl)
+	if _, err := s.db.Exec(ddl); err != nil {
+		return err
+	}
+	if err := s.ensureColumn("comments", "spam_score", "REAL NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	return s.ensureColumn("comments", "spam_reasons", "TEXT NOT NULL DEFAULT ''")
+}
+
+func (s *Store) ensureColumn(table, column, spec string) error {
+	rows, err := s.db.Query(`PRAGMA table_info(` + table + `)`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var defaultValue any
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + spec)
 	return err
 }
 
@@ -68,9 +97,10 @@ func (s *Store) InsertComment(ctx context.Context, c *Comment) error {
 		c.Status = StatusApproved
 	}
 	_, err := s.db.ExecContext(ctx, `
-INSERT INTO comments(id, post_slug, author, content, reply_to, ip_hash, status, created_at, updated_at)
-VALUES(?,?,?,?,?,?,?,?,?)`,
-		c.ID, c.PostSlug, c.Author, c.Content, c.ReplyTo, c.IPHash, c.Status, c.CreatedAt, c.UpdatedAt)
+INSERT INTO comments(id, post_slug, author, content, reply_to, ip_hash, status, spam_score, spam_reasons, created_at, updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?,?)`,
+		c.ID, c.PostSlug, c.Author, c.Content, c.ReplyTo, c.IPHash, c.Status, c.SpamScore, strings.Join(c.SpamReasons, "\n"),
+		c.CreatedAt, c.UpdatedAt)
 	return err
 }
 
@@ -96,7 +126,7 @@ func (s *Store) ListComments(ctx context.Context, postSlug, status string, limit
 		where = "WHERE " + strings.Join(conds, " AND ")
 	}
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, post_slug, author, content, reply_to, ip_hash, status, created_at, updated_at
+		`SELECT id, post_slug, author, content, reply_to, ip_hash, status, spam_score, spam_reasons, created_at, updated_at
 FROM comments `+where+` ORDER BY created_at ASC LIMIT ? OFFSET ?`,
 		append(args, limit, offset)...)
 	if err != nil {
@@ -106,10 +136,12 @@ FROM comments `+where+` ORDER BY created_at ASC LIMIT ? OFFSET ?`,
 	var out []Comment
 	for rows.Next() {
 		var c Comment
+		var reasons string
 		if err := rows.Scan(&c.ID, &c.PostSlug, &c.Author, &c.Content, &c.ReplyTo, &c.IPHash,
-			&c.Status, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			&c.Status, &c.SpamScore, &reasons, &c.CreatedAt, &c.UpdatedAt); err != nil {
 			return nil, 0, err
 		}
+		c.SpamReasons = splitReasons(reasons)
 		out = append(out, c)
 	}
 	var total int
@@ -121,20 +153,29 @@ FROM comments `+where+` ORDER BY created_at ASC LIMIT ? OFFSET ?`,
 
 func (s *Store) GetComment(ctx context.Context, id string) (*Comment, error) {
 	row := s.db.QueryRowContext(ctx,
-		`SELECT id, post_slug, author, content, reply_to, ip_hash, status, created_at, updated_at
+		`SELECT id, post_slug, author, content, reply_to, ip_hash, status, spam_score, spam_reasons, created_at, updated_at
 FROM comments WHERE id=?`, id)
 	var c Comment
+	var reasons string
 	err := row.Scan(&c.ID, &c.PostSlug, &c.Author, &c.Content, &c.ReplyTo, &c.IPHash,
-		&c.Status, &c.CreatedAt, &c.UpdatedAt)
+		&c.Status, &c.SpamScore, &reasons, &c.CreatedAt, &c.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNotFound
 		}
 		return nil, err
 	}
+	c.SpamReasons = splitReasons(reasons)
 	return &c, nil
 }
 
+func splitReasons(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
 func (s *Store) UpdateStatus(ctx context.Context, id, status string) error {
 	r, err := s.db.ExecContext(ctx,
 		`UPDATE comments SET status=?, updated_at=? WHERE id=?`,
@@ -3,15 +3,18 @@ package comments
 import "time"
 
 type Comment struct {
-	ID        string    `json:"id"`
-	PostSlug  string    `json:"post_slug"`
-	Author    string    `json:"author"`
-	Content   string    `json:"content"`
-	ReplyTo   string    `json:"reply_to,omitempty"`
-	IPHash    string    `json:"-"`
-	Status    string    `json:"status"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID       string `json:"id"`
+	PostSlug string `json:"post_slug"`
+	Author   string `json:"author"`
+	Content  string `json:"content"`
+	ReplyTo  string `json:"reply_to,omitempty"`
+	IPHash   string `json:"-"`
+	Status   string `json:"status"`
+	// SpamScore / SpamReasons come from risk/spam at creation; admin only.
+	SpamScore   float64   `json:"spam_score,omitempty"`
+	SpamReasons []string  `json:"spam_reasons,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
 }
 
 const (
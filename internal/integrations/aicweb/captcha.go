@@ -0,0 +1,81 @@
+package aicweb
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"backend-go/internal/auth/challenge"
+	"backend-go/internal/risk"
+	"backend-go/internal/risk/rules"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newCaptchaFromEnv 读 AICWEB_CAPTCHA_*（见 risk.VerifierFromEnv）。
+// 兼容旧配置：没设 AICWEB_CAPTCHA_PROVIDER 时沿用 TURNSTILE_SECRET，
+// TURNSTILE_ENABLED=false 关闭校验。store 保存 PoW 题目，nil 时放内存。
+func newCaptchaFromEnv(store challenge.Store) risk.Verifier {
+	if os.Getenv("TURNSTILE_ENABLED") == "false" {
+		return risk.Off{}
+	}
+	if os.Getenv("AICWEB_CAPTCHA_PROVIDER") == "" {
+		if secret := strings.TrimSpace(os.Getenv("TURNSTILE_SECRET")); secret != "" {
+			return risk.NewTurnstile(secret)
+		}
+	}
+	v, err := risk.VerifierFromEnv("AICWEB", store)
+	if err != nil {
+		panic("failed to init captcha: " + err.Error())
+	}
+	return v
+}
+
+// verifyCaptcha 校验注册 / 登录请求里的人机验证 token。token 可放在
+// X-Captcha-Token / CF-Turnstile-Response 头，或 body 的 captchaToken /
+// turnstileToken / cfTurnstileResponse 字段。返回 false 时响应已写好。
+func (h *Handler) verifyCaptcha(c *gin.Context) bool {
+	if h.captcha == nil || !h.captcha.Enabled() {
+		return true
+	}
+	var body struct {
+		CaptchaToken        string `json:"captchaToken"`
+		TurnstileToken      string `json:"turnstileToken"`
+		CFTurnstileResponse string `json:"cfTurnstileResponse"`
+	}
+	_ = c.ShouldBindBodyWithJSON(&body)
+	token := body.CaptchaToken
+	if token == "" {
+		token = body.TurnstileToken
+	}
+	if token == "" {
+		token = body.CFTurnstileResponse
+	}
+	ok, err := risk.Check(c, h.captcha, token)
+	if err != nil {
+		log.Printf("[aicweb] %v", err)
+		c.JSON(http.StatusBadGateway, NewFail(ErrBadGateway, nil))
+		return false
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, NewFail(ErrBadRequest, map[string]any{"captcha": h.captcha.Provider()}))
+		return false
+	}
+	return true
+}
+
+// riskGuard 按 risk/rules 的规则拦截公开路由；aicweb 不存 ip_hash，只匹配 IP / 网段。
+func riskGuard(captcha risk.Verifier) gin.HandlerFunc {
+	return rules.Shared().Guard(rules.GuardOptions{
+		Scope:   "aicweb",
+		Captcha: captcha,
+		Deny: func(c *gin.Context, d rules.Decision) {
+			if d.Action == rules.ActionChallenge {
+				c.JSON(http.StatusForbidden, NewFail(ErrForbidden, map[string]any{"captcha": captcha.Provider()}))
+				return
+			}
+			c.JSON(http.StatusForbidden, NewFail(ErrForbidden, nil))
+		},
+	})
+}
@@ -25,8 +25,28 @@ func defaultEnv() []byte {
 			"AICWEB_BASE_PREFIX=/api/aicweb\n" +
 			"TURNSTILE_ENABLED=false\n" +
 			"# TURNSTILE_SECRET=\n" +
+			"# 人机验证：turnstile | hcaptcha | recaptcha | pow | off；前端 GET /user/captcha 取配置\n" +
+			"# AICWEB_CAPTCHA_PROVIDER=\n" +
+			"# AICWEB_CAPTCHA_SECRET=\n" +
+			"# AICWEB_CAPTCHA_SITE_KEY=\n" +
+			"# AICWEB_CAPTCHA_POW_KEY=\n" +
 			// ★ 默认数据库放在 ./databases/aicweb/forms.db
-			"AICWEB_SQLITE_PATH=databases/aicweb/forms.db\n",
+			"AICWEB_SQLITE_PATH=databases/aicweb/forms.db\n\n" +
+			"# 注册 / 登录限流（每 IP）：<次数>/<周期>[,burst=N][,algo=gcra|bucket]，off 关闭。\n" +
+			"#   LOGIN 同时作用于 OIDC 授权页的密码登录。\n" +
+			"AICWEB_RATELIMIT_REGISTER=5/1h,burst=3\n" +
+			"AICWEB_RATELIMIT_LOGIN=10/1m\n\n" +
+			"# OIDC 提供方：让社团其他站点用 aicweb 账号登录（客户端通过 /oidc/admin/clients 登记）\n" +
+			"AICWEB_OIDC_ENABLED=false\n" +
+			"# 对外的 issuer（启用时必填），如 https://aic.example.com/api/aicweb/oidc\n" +
+			"AICWEB_OIDC_ISSUER=\n" +
+			"AICWEB_OIDC_KEY_PATH=databases/aicweb/oidc_signing_key.pem\n" +
+			"AICWEB_OIDC_TOKEN_TTL_MINUTES=60\n" +
+			"# 提供方登录态有效期（小时），0 表示每次授权都要输入密码\n" +
+			"AICWEB_OIDC_SESSION_HOURS=12\n" +
+			"# 管理接口的 JWT secret（至少 32 字节），留空沿用 ROUNDNFC_JWT_SECRET（用 RoundNFC 后台登录的 token 调用）\n" +
+			"#   两者都不足 32 字节时不启用 OIDC 提供方\n" +
+			"AICWEB_ADMIN_JWT_SECRET=\n",
 	)
 }
 
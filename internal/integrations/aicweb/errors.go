@@ -7,8 +7,11 @@ const (
 	ErrCodeSuccess  = 0
 	ErrCodeBadReq   = 400
 	ErrCodeUnauthed = 401
+	ErrCodeForbid   = 403
 	ErrCodeNotFound = 404
+	ErrCodeTooMany  = 429
 	ErrCodeInternal = 500
+	ErrCodeBadGate  = 502
 
 	// 业务自定义示例
 	ErrCodeEmailUsed = 1001
@@ -19,8 +22,11 @@ var (
 	ErrSuccess             = newError(ErrCodeSuccess, "ok")
 	ErrBadRequest          = newError(ErrCodeBadReq, "Bad Request")
 	ErrUnauthorized        = newError(ErrCodeUnauthed, "Unauthorized")
+	ErrForbidden           = newError(ErrCodeForbid, "Forbidden")
 	ErrNotFound            = newError(ErrCodeNotFound, "Not Found")
+	ErrTooManyRequests     = newError(ErrCodeTooMany, "Too Many Requests")
 	ErrInternalServerError = newError(ErrCodeInternal, "Internal Server Error")
+	ErrBadGateway          = newError(ErrCodeBadGate, "Bad Gateway")
 	ErrEmailAlreadyUse     = newError(ErrCodeEmailUsed, "The email is already in use.")
 )
 
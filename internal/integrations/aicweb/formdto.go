@@ -18,6 +18,7 @@ var blockedKeys = map[string]struct{}{
 	"turnstileToken":      {},
 	"cfTurnstileResponse": {},
 	"captcha":             {},
+	"captchaToken":        {},
 }
 
 func isBlocked(key string) bool {
@@ -9,29 +9,24 @@ import (
 	"strconv"
 	"strings"
 
+	"backend-go/internal/risk"
+
 	"github.com/gin-gonic/gin"
 )
 
 type Handler struct {
-	svc    Service
-	ts     TurnstileVerifier
-	fs     FormService
-	notify ActivationNotifier
-	avt    MediaUploader // nil = avatar upload disabled
-	bnr    MediaUploader // nil = banner upload disabled
+	svc     Service
+	captcha risk.Verifier
+	fs      FormService
+	notify  ActivationNotifier
+	avt     MediaUploader // nil = avatar upload disabled
+	bnr     MediaUploader // nil = banner upload disabled
 }
 
-func NewHandler(svc Service, ts TurnstileVerifier, fs FormService, notify ActivationNotifier, avt, bnr MediaUploader) *Handler {
-	return &Handler{svc: svc, ts: ts, fs: fs, notify: notify, avt: avt, bnr: bnr}
+func NewHandler(svc Service, captcha risk.Verifier, fs FormService, notify ActivationNotifier, avt, bnr MediaUploader) *Handler {
+	return &Handler{svc: svc, captcha: captcha, fs: fs, notify: notify, avt: avt, bnr: bnr}
 }
 
-// ---- Turnstile 适配 ----
-type ginCtx struct{ *gin.Context }
-
-func (g ginCtx) GetHeader(k string) string          { return g.Context.GetHeader(k) }
-func (g ginCtx) ClientIP() string                   { return g.Context.ClientIP() }
-func (g ginCtx) ShouldBindBodyWithJSON(v any) error { return g.Context.ShouldBindJSON(v) }
-
 type activationCreator interface {
 	CreateActivationToken(ctx context.Context, email string) (string, error)
 }
@@ -51,20 +46,12 @@ func openUploadReader(c *gin.Context) (io.ReadCloser, error) {
 
 // ---- 注册 ----
 func (h *Handler) Register(c *gin.Context) {
-	if h.ts != nil && h.ts.Enabled() {
-		token, err := getTurnstileToken(ginCtx{c})
-		if err != nil {
-			c.JSON(http.StatusBadRequest, NewFail(ErrBadRequest, map[string]any{"reason": "missing turnstile token"}))
-			return
-		}
-		if ok, codes, err := h.ts.Verify(c, token, c.ClientIP()); err != nil || !ok {
-			c.JSON(http.StatusBadRequest, NewFail(ErrBadRequest, map[string]any{"turnstile": codes}))
-			return
-		}
+	if !h.verifyCaptcha(c) {
+		return
 	}
 
 	var req RegisterRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := c.ShouldBindBodyWithJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, NewFail(ErrBadRequest, nil))
 		return
 	}
@@ -94,20 +81,12 @@ func (h *Handler) Register(c *gin.Context) {
 
 // ---- 登录 ----
 func (h *Handler) Login(c *gin.Context) {
-	if h.ts != nil && h.ts.Enabled() {
-		token, err := getTurnstileToken(ginCtx{c})
-		if err != nil {
-			c.JSON(http.StatusBadRequest, NewFail(ErrBadRequest, map[string]any{"reason": "missing turnstile token"}))
-			return
-		}
-		if ok, codes, err := h.ts.Verify(c, token, c.ClientIP()); err != nil || !ok {
-			c.JSON(http.StatusBadRequest, NewFail(ErrBadRequest, map[string]any{"turnstile": codes}))
-			return
-		}
+	if !h.verifyCaptcha(c) {
+		return
 	}
 
 	var req LoginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := c.ShouldBindBodyWithJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, NewFail(ErrBadRequest, nil))
 		return
 	}
@@ -0,0 +1,30 @@
+package aicweb
+
+import (
+	"net/http"
+
+	"backend-go/internal/risk/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 限流的路由名，可用 AICWEB_RATELIMIT_<NAME> 覆盖默认值。
+// login 同时覆盖 /user/login 与 OIDC 授权页的密码登录，按 IP 共用一份额度。
+const (
+	rlRegister = "register"
+	rlLogin    = "login"
+)
+
+func newLimiter() *ratelimit.Limiter {
+	return ratelimit.New(ratelimit.Options{
+		Namespace: "aicweb",
+		Store:     ratelimit.SharedStore(),
+		Policies: ratelimit.PoliciesFromEnv("AICWEB", map[string]string{
+			rlRegister: "5/1h,burst=3",
+			rlLogin:    "10/1m",
+		}),
+		Deny: func(c *gin.Context, _ ratelimit.Result) {
+			c.JSON(http.StatusTooManyRequests, NewFail(ErrTooManyRequests, nil))
+		},
+	})
+}
@@ -0,0 +1,340 @@
+package aicweb
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OIDCClient is a relying party (another club site) registered by an admin.
+type OIDCClient struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Public       bool      `json:"public"` // no secret; PKCE is mandatory
+	RedirectURIs []string  `json:"redirectUris"`
+	Enabled      bool      `json:"enabled"`
+	CreatedAt    time.Time `json:"createdAt"`
+
+	secretHash string
+}
+
+const oidcClientsDDL = `
+CREATE TABLE IF NOT EXISTS oidc_clients (
+  id            TEXT PRIMARY KEY,
+  name          TEXT NOT NULL,
+  secret_hash   TEXT NOT NULL DEFAULT '',
+  redirect_uris TEXT NOT NULL,
+  enabled       INTEGER NOT NULL DEFAULT 1,
+  created_at    DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS oidc_consents (
+  user_id    TEXT NOT NULL,
+  client_id  TEXT NOT NULL,
+  scope      TEXT NOT NULL,
+  updated_at DATETIME NOT NULL,
+  PRIMARY KEY(user_id, client_id)
+);
+`
+
+type oidcClientStore struct{ db *sql.DB }
+
+func newOIDCClientStore(db *sql.DB) (*oidcClientStore, error) {
+	if _, err := db.Exec(oidcClientsDDL); err != nil {
+		return nil, err
+	}
+	return &oidcClientStore{db: db}, nil
+}
+
+var errClientNotFound = errors.New("oidc client not found")
+
+func hashClientSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkSecret compares a presented client secret with the stored hash.
+func (c *OIDCClient) checkSecret(secret string) bool {
+	if c.Public || c.secretHash == "" || secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashClientSecret(secret)), []byte(c.secretHash)) == 1
+}
+
+func (c *OIDCClient) allowsRedirect(uri string) bool {
+	for _, r := range c.RedirectURIs {
+		if r == uri {
+			return true
+		}
+	}
+	return false
+}
+
+func scanOIDCClient(sc interface{ Scan(...any) error }) (*OIDCClient, error) {
+	var c OIDCClient
+	var uris string
+	var enabled int
+	if err := sc.Scan(&c.ID, &c.Name, &c.secretHash, &uris, &enabled, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+	c.Public = c.secretHash == ""
+	c.Enabled = enabled == 1
+	c.RedirectURIs = splitLines(uris)
+	return &c, nil
+}
+
+func splitLines(s string) []string {
+	out := []string{}
+	for _, v := range strings.Split(s, "\n") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+const oidcClientCols = `id,name,secret_hash,redirect_uris,enabled,created_at`
+
+func (s *oidcClientStore) get(ctx context.Context, id string) (*OIDCClient, error) {
+	c, err := scanOIDCClient(s.db.QueryRowContext(ctx, `SELECT `+oidcClientCols+` FROM oidc_clients WHERE id=?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errClientNotFound
+	}
+	return c, err
+}
+
+func (s *oidcClientStore) list(ctx context.Context) ([]OIDCClient, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+oidcClientCols+` FROM oidc_clients ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []OIDCClient{}
+	for rows.Next() {
+		c, err := scanOIDCClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *c)
+	}
+	return out, rows.Err()
+}
+
+// create registers a client and returns its plaintext secret (empty for public clients).
+func (s *oidcClientStore) create(ctx context.Context, name string, redirectURIs []string, public bool) (*OIDCClient, string, error) {
+	c := &OIDCClient{ID: randHex(12), Name: name, Public: public, RedirectURIs: redirectURIs, Enabled: true, CreatedAt: time.Now().UTC()}
+	var secret string
+	if !public {
+		secret = randHex(32)
+		c.secretHash = hashClientSecret(secret)
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO oidc_clients(`+oidcClientCols+`) VALUES(?,?,?,?,1,?)`,
+		c.ID, c.Name, c.secretHash, strings.Join(redirectURIs, "\n"), c.CreatedAt)
+	return c, secret, err
+}
+
+func (s *oidcClientStore) update(ctx context.Context, id, name string, redirectURIs []string, enabled bool) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE oidc_clients SET name=?, redirect_uris=?, enabled=? WHERE id=?`,
+		name, strings.Join(redirectURIs, "\n"), boolInt(enabled), id)
+	return affectedOrNotFound(res, err)
+}
+
+func (s *oidcClientStore) rotateSecret(ctx context.Context, id string) (string, error) {
+	secret := randHex(32)
+	res, err := s.db.ExecContext(ctx, `UPDATE oidc_clients SET secret_hash=? WHERE id=? AND secret_hash<>''`,
+		hashClientSecret(secret), id)
+	if err := affectedOrNotFound(res, err); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+func (s *oidcClientStore) delete(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM oidc_clients WHERE id=?`, id)
+	if err := affectedOrNotFound(res, err); err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `DELETE FROM oidc_consents WHERE client_id=?`, id)
+	return err
+}
+
+// hasConsent reports whether the user already approved every scope in scope.
+func (s *oidcClientStore) hasConsent(ctx context.Context, userID, clientID string, scopes []string) bool {
+	var granted string
+	if err := s.db.QueryRowContext(ctx, `SELECT scope FROM oidc_consents WHERE user_id=? AND client_id=?`,
+		userID, clientID).Scan(&granted); err != nil {
+		return false
+	}
+	have := strings.Fields(granted)
+	for _, sc := range scopes {
+		if !containsString(have, sc) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *oidcClientStore) saveConsent(ctx context.Context, userID, clientID string, scopes []string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO oidc_consents(user_id,client_id,scope,updated_at) VALUES(?,?,?,?)
+		ON CONFLICT(user_id,client_id) DO UPDATE SET scope=excluded.scope, updated_at=excluded.updated_at`,
+		userID, clientID, strings.Join(scopes, " "), time.Now().UTC())
+	return err
+}
+
+func affectedOrNotFound(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errClientNotFound
+	}
+	return nil
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validRedirectURIs requires absolute URIs without fragments (custom app
+// schemes are fine). Plain http is only accepted for loopback hosts.
+func validRedirectURIs(uris []string) ([]string, bool) {
+	var out []string
+	for _, raw := range uris {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Scheme == "" || u.Fragment != "" {
+			return nil, false
+		}
+		if (u.Scheme == "https" || u.Scheme == "http") && u.Host == "" {
+			return nil, false
+		}
+		if u.Scheme == "http" {
+			h := u.Hostname()
+			if h != "localhost" && h != "127.0.0.1" && h != "::1" {
+				return nil, false
+			}
+		}
+		out = append(out, raw)
+	}
+	return out, len(out) > 0
+}
+
+// ---- 管理接口 ----
+
+type oidcClientPayload struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirectUris"`
+	Public       bool     `json:"public"`
+	Enabled      *bool    `json:"enabled"`
+}
+
+func (p *oidcProvider) adminListClients(c *gin.Context) {
+	list, err := p.clients.list(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, NewFail(ErrInternalServerError, nil))
+		return
+	}
+	c.JSON(http.StatusOK, NewOK(list))
+}
+
+func (p *oidcProvider) adminCreateClient(c *gin.Context) {
+	var req oidcClientPayload
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, NewFail(ErrBadRequest, nil))
+		return
+	}
+	uris, ok := validRedirectURIs(req.RedirectURIs)
+	if !ok {
+		c.JSON(http.StatusBadRequest, NewFail(ErrBadRequest, map[string]any{"reason": "invalid redirectUris"}))
+		return
+	}
+	cl, secret, err := p.clients.create(c.Request.Context(), strings.TrimSpace(req.Name), uris, req.Public)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, NewFail(ErrInternalServerError, nil))
+		return
+	}
+	// secret 只在创建 / 轮换时返回一次
+	c.JSON(http.StatusOK, NewOK(map[string]any{"client": cl, "clientSecret": secret}))
+}
+
+func (p *oidcProvider) adminUpdateClient(c *gin.Context) {
+	var req oidcClientPayload
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, NewFail(ErrBadRequest, nil))
+		return
+	}
+	ctx := c.Request.Context()
+	cur, err := p.clients.get(ctx, c.Param("id"))
+	if err != nil {
+		p.adminStoreFail(c, err)
+		return
+	}
+	name, uris, enabled := cur.Name, cur.RedirectURIs, cur.Enabled
+	if v := strings.TrimSpace(req.Name); v != "" {
+		name = v
+	}
+	if req.RedirectURIs != nil {
+		var ok bool
+		if uris, ok = validRedirectURIs(req.RedirectURIs); !ok {
+			c.JSON(http.StatusBadRequest, NewFail(ErrBadRequest, map[string]any{"reason": "invalid redirectUris"}))
+			return
+		}
+	}
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+	if err := p.clients.update(ctx, cur.ID, name, uris, enabled); err != nil {
+		p.adminStoreFail(c, err)
+		return
+	}
+	cl, _ := p.clients.get(ctx, cur.ID)
+	c.JSON(http.StatusOK, NewOK(cl))
+}
+
+func (p *oidcProvider) adminRotateSecret(c *gin.Context) {
+	secret, err := p.clients.rotateSecret(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		p.adminStoreFail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, NewOK(map[string]any{"clientSecret": secret}))
+}
+
+func (p *oidcProvider) adminDeleteClient(c *gin.Context) {
+	if err := p.clients.delete(c.Request.Context(), c.Param("id")); err != nil {
+		p.adminStoreFail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, NewOK(map[string]any{"deleted": true}))
+}
+
+func (p *oidcProvider) adminStoreFail(c *gin.Context, err error) {
+	if errors.Is(err, errClientNotFound) {
+		c.JSON(http.StatusNotFound, NewFail(ErrNotFound, nil))
+		return
+	}
+	c.JSON(http.StatusInternalServerError, NewFail(ErrInternalServerError, nil))
+}
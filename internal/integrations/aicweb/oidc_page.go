@@ -0,0 +1,206 @@
+package aicweb
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"backend-go/internal/auth/challenge"
+	"backend-go/internal/risk"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 授权页：未登录时显示账号密码，已登录时只需确认授权。服务端渲染，不依赖前端工程。
+
+var scopeLabels = map[string]string{
+	"openid":  "确认你的身份",
+	"profile": "读取公开资料（昵称、头像、简介）",
+	"email":   "读取邮箱地址",
+}
+
+var oidcPageTmpl = template.Must(template.New("page").Parse(`<!doctype html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="referrer" content="no-referrer">
+<title>{{if .Error}}登录出错{{else}}登录到 {{.ClientName}}{{end}}</title>
+<style>
+body{margin:0;min-height:100vh;display:flex;align-items:center;justify-content:center;
+font-family:system-ui,-apple-system,"PingFang SC","Microsoft YaHei",sans-serif;background:#f4f5f7;color:#1d1b20}
+.card{width:100%;max-width:380px;background:#fff;border-radius:16px;padding:28px;box-shadow:0 2px 12px rgba(0,0,0,.08)}
+h1{font-size:20px;margin:0 0 8px}
+p{color:#49454f;font-size:14px;margin:4px 0}
+ul{padding-left:20px;font-size:14px;color:#49454f}
+label{display:block;font-size:13px;margin:12px 0 4px}
+input{width:100%;box-sizing:border-box;padding:10px 12px;border:1px solid #cac4d0;border-radius:8px;font-size:15px}
+.err{color:#b3261e;font-size:14px;margin:12px 0}
+.actions{display:flex;gap:12px;margin-top:20px}
+button{flex:1;padding:10px;border-radius:20px;border:0;font-size:15px;cursor:pointer}
+.primary{background:#6750a4;color:#fff}
+.secondary{background:#e8def8;color:#1d192b}
+</style>
+</head>
+<body>
+<div class="card">
+{{if .Error}}
+  <h1>无法完成登录</h1>
+  <p class="err">{{.Error}}</p>
+{{else}}
+  <h1>{{.ClientName}}</h1>
+  {{if .Username}}<p>当前账号：<b>{{.Username}}</b></p>{{else}}<p>使用 AIC 账号登录</p>{{end}}
+  <p>该应用将会：</p>
+  <ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+  {{if .Message}}<p class="err">{{.Message}}</p>{{end}}
+  <form id="authz" method="post" action="authorize">
+    <input type="hidden" name="request_id" value="{{.RequestID}}">
+    {{if not .Username}}
+    <label for="login">邮箱或用户名</label>
+    <input id="login" name="login" autocomplete="username" required autofocus>
+    <label for="password">密码</label>
+    <input id="password" name="password" type="password" autocomplete="current-password" required>
+    {{if .PoW}}<input type="hidden" id="pow" name="captcha_token" data-challenge="{{.PoW}}">
+    {{else if .Widget}}<div class="{{.Widget}}" data-sitekey="{{.SiteKey}}"></div>
+    {{end}}
+    {{end}}
+    <div class="actions">
+      <button class="secondary" type="submit" name="action" value="deny" formnovalidate>拒绝</button>
+      <button class="primary" type="submit" name="action" value="approve">{{if .Username}}授权{{else}}登录并授权{{end}}</button>
+    </div>
+  </form>
+{{end}}
+</div>
+{{if .Script}}<script src="{{.Script}}" async defer></script>{{end}}
+{{if .PoW}}<script nonce="{{.Nonce}}">
+(function(){
+  var f=document.getElementById("authz"),el=document.getElementById("pow"),done=false;
+  var ch=JSON.parse(el.getAttribute("data-challenge"));
+  async function sha(s){
+    var b=new Uint8Array(await crypto.subtle.digest("SHA-256",new TextEncoder().encode(s)));
+    return Array.from(b,function(x){return x.toString(16).padStart(2,"0")}).join("");
+  }
+  f.addEventListener("submit",async function(e){
+    if(done||!e.submitter||e.submitter.value!=="approve")return;
+    e.preventDefault();
+    var n=0;
+    for(;n<=ch.maxnumber;n++){if(await sha(ch.salt+n)===ch.challenge)break}
+    el.value=btoa(JSON.stringify({algorithm:ch.algorithm,challenge:ch.challenge,number:n,salt:ch.salt,signature:ch.signature}));
+    done=true;
+    f.requestSubmit(e.submitter);
+  });
+})();
+</script>{{end}}
+</body>
+</html>`))
+
+type oidcPageData struct {
+	Error      string
+	ClientName string
+	Username   string
+	Scopes     []string
+	Message    string
+	RequestID  string
+	// 登录表单的人机验证：PoW 是题目 JSON，由页内脚本求解；Widget / SiteKey /
+	// Script 是第三方组件
+	PoW     string
+	Nonce   string
+	Widget  string
+	SiteKey string
+	Script  string
+	csp     string
+}
+
+// captchaWidgets 是各家组件的 class、脚本和需要放行的来源。组件把 token
+// 写进表单里的 cf-turnstile-response / h-captcha-response / g-recaptcha-response。
+var captchaWidgets = map[string]struct{ class, script, origins string }{
+	"turnstile": {"cf-turnstile", "https://challenges.cloudflare.com/turnstile/v0/api.js", "https://challenges.cloudflare.com"},
+	"hcaptcha":  {"h-captcha", "https://js.hcaptcha.com/1/api.js", "https://hcaptcha.com https://*.hcaptcha.com"},
+	"recaptcha": {"g-recaptcha", "https://www.google.com/recaptcha/api.js", "https://www.google.com https://www.gstatic.com"},
+}
+
+// captchaFormToken 取登录表单里的人机验证 token。
+func captchaFormToken(c *gin.Context) string {
+	for _, k := range []string{"captcha_token", "cf-turnstile-response", "h-captcha-response", "g-recaptcha-response"} {
+		if v := strings.TrimSpace(c.PostForm(k)); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// withCaptcha 给登录表单配上人机验证。
+func (p *oidcProvider) withCaptcha(c *gin.Context, data *oidcPageData) error {
+	if p.captcha == nil || !p.captcha.Enabled() {
+		return nil
+	}
+	if ch, ok := p.captcha.(risk.Challenger); ok {
+		v, err := ch.Challenge(c.Request.Context())
+		if err != nil {
+			return err
+		}
+		b, _ := json.Marshal(v)
+		if data.Nonce, err = challenge.NewKey(16); err != nil {
+			return err
+		}
+		data.PoW = string(b)
+		data.csp = "script-src 'nonce-" + data.Nonce + "'"
+		return nil
+	}
+	w, ok := captchaWidgets[p.captcha.Provider()]
+	sv, _ := p.captcha.(*risk.SiteVerify)
+	if !ok || sv == nil || sv.SiteKey == "" {
+		return nil
+	}
+	data.Widget, data.SiteKey, data.Script = w.class, sv.SiteKey, w.script
+	data.csp = "script-src " + w.origins + "; frame-src " + w.origins + "; connect-src " + w.origins
+	return nil
+}
+
+// renderPage 暂存授权请求并渲染登录 / 授权页。request_id 一次性使用，兼作 CSRF token。
+func (p *oidcProvider) renderPage(c *gin.Context, req *authzRequest, u *user, msg string) {
+	rid, err := challenge.NewKey(24)
+	if err == nil {
+		b, _ := json.Marshal(req)
+		err = p.grants.Put(c.Request.Context(), "authz:"+rid, b, oidcRequestTTL)
+	}
+	if err != nil {
+		p.renderError(c, "服务器错误，请稍后再试")
+		return
+	}
+	data := oidcPageData{ClientName: req.ClientName, Message: msg, RequestID: rid}
+	if u != nil {
+		data.Username = u.Username
+	} else if err := p.withCaptcha(c, &data); err != nil {
+		log.Printf("[aicweb/oidc] captcha: %v", err)
+		p.renderError(c, "服务器错误，请稍后再试")
+		return
+	}
+	for _, sc := range req.Scopes {
+		data.Scopes = append(data.Scopes, scopeLabels[sc])
+	}
+	status := http.StatusOK
+	if msg != "" {
+		status = http.StatusUnauthorized
+	}
+	p.writePage(c, status, data)
+}
+
+func (p *oidcProvider) renderError(c *gin.Context, msg string) {
+	p.writePage(c, http.StatusBadRequest, oidcPageData{Error: msg})
+}
+
+func (p *oidcProvider) writePage(c *gin.Context, status int, data oidcPageData) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	csp := "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'"
+	if data.csp != "" {
+		csp += "; " + data.csp
+	}
+	c.Header("Content-Security-Policy", csp)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+	_ = oidcPageTmpl.Execute(c.Writer, data)
+}
@@ -0,0 +1,645 @@
+package aicweb
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend-go/internal/auth"
+	"backend-go/internal/auth/challenge"
+	"backend-go/internal/risk"
+	"backend-go/internal/risk/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 最小化的 OpenID Connect 提供方：社团其他站点把 aicweb 账号当作统一登录。
+// 仅支持授权码模式（公共客户端必须带 PKCE），客户端由管理员登记。
+
+const (
+	oidcRequestTTL    = 10 * time.Minute // 登录 / 授权页表单的有效期
+	oidcCodeTTL       = time.Minute
+	oidcSessionCookie = "aicweb_oidc_session"
+	// oidcMinAdminSecret 是管理接口 JWT secret 的最小长度（HS256 的 key 不能比摘要短）
+	oidcMinAdminSecret = 32
+)
+
+var oidcSupportedScopes = []string{"openid", "profile", "email"}
+
+type oidcConfig struct {
+	Issuer      string        // AICWEB_OIDC_ISSUER，必填，不从请求头推导
+	KeyPath     string        // RSA 签名私钥（PEM），不存在时自动生成
+	AdminSecret []byte        // 管理接口的 JWT secret，缺省沿用 ROUNDNFC_JWT_SECRET
+	TokenTTL    time.Duration // access_token / id_token 有效期
+	SessionTTL  time.Duration // 提供方登录态 cookie 有效期，0 表示每次都输密码
+}
+
+func oidcConfigFromEnv() oidcConfig {
+	admin := []byte(strings.TrimSpace(os.Getenv("AICWEB_ADMIN_JWT_SECRET")))
+	if len(admin) == 0 {
+		admin = []byte(os.Getenv("ROUNDNFC_JWT_SECRET"))
+	}
+	return oidcConfig{
+		Issuer:      strings.TrimRight(strings.TrimSpace(os.Getenv("AICWEB_OIDC_ISSUER")), "/"),
+		KeyPath:     envOr("AICWEB_OIDC_KEY_PATH", "databases/aicweb/oidc_signing_key.pem"),
+		AdminSecret: admin,
+		TokenTTL:    time.Duration(envIntOr("AICWEB_OIDC_TOKEN_TTL_MINUTES", 60)) * time.Minute,
+		SessionTTL:  time.Duration(envIntOr("AICWEB_OIDC_SESSION_HOURS", 12)) * time.Hour,
+	}
+}
+
+func oidcEnabledFromEnv() bool {
+	return strings.EqualFold(strings.TrimSpace(os.Getenv("AICWEB_OIDC_ENABLED")), "true")
+}
+
+type oidcProvider struct {
+	cfg     oidcConfig
+	users   *sqliteService
+	clients *oidcClientStore
+	grants  challenge.Store // 授权请求与授权码，一次性取出
+	key     *rsa.PrivateKey
+	kid     string
+	sessKey []byte
+	rl      *ratelimit.Limiter
+	captcha risk.Verifier // 登录页的人机验证，和 /user/login 用同一个
+	prefix  string        // 挂载路径，如 /api/aicweb/oidc
+}
+
+// validate 拒绝不完整的配置：issuer 由客户端校验，不能随请求的 Host /
+// X-Forwarded-Proto 变化；管理接口的 secret 为空或太短时 HS256 token 可被伪造。
+func (cfg oidcConfig) validate() error {
+	iu, err := url.Parse(cfg.Issuer)
+	if cfg.Issuer == "" || err != nil || (iu.Scheme != "https" && iu.Scheme != "http") || iu.Host == "" ||
+		iu.RawQuery != "" || iu.Fragment != "" {
+		return errors.New("AICWEB_OIDC_ISSUER must be an absolute http(s) URL")
+	}
+	if len(cfg.AdminSecret) < oidcMinAdminSecret {
+		return fmt.Errorf("admin JWT secret (AICWEB_ADMIN_JWT_SECRET or ROUNDNFC_JWT_SECRET) must be at least %d bytes", oidcMinAdminSecret)
+	}
+	return nil
+}
+
+func newOIDCProvider(cfg oidcConfig, users *sqliteService, rl *ratelimit.Limiter, captcha risk.Verifier) (*oidcProvider, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	key, err := loadOrCreateRSAKey(cfg.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("signing key: %w", err)
+	}
+	clients, err := newOIDCClientStore(users.db)
+	if err != nil {
+		return nil, err
+	}
+	grants, err := challenge.NewSQL(users.db, "oidc_grants")
+	if err != nil {
+		return nil, err
+	}
+	der := x509.MarshalPKCS1PrivateKey(key)
+	pubDER := x509.MarshalPKCS1PublicKey(&key.PublicKey)
+	kidSum := sha256.Sum256(pubDER)
+	sess := hmac.New(sha256.New, der)
+	sess.Write([]byte("aicweb-oidc-session"))
+	return &oidcProvider{
+		cfg:     cfg,
+		users:   users,
+		clients: clients,
+		grants:  grants,
+		key:     key,
+		kid:     base64.RawURLEncoding.EncodeToString(kidSum[:12]),
+		sessKey: sess.Sum(nil),
+		rl:      rl,
+		captcha: captcha,
+	}, nil
+}
+
+func loadOrCreateRSAKey(path string) (*rsa.PrivateKey, error) {
+	if b, err := os.ReadFile(path); err == nil {
+		blk, _ := pem.Decode(b)
+		if blk == nil {
+			return nil, errors.New("no PEM block in " + path)
+		}
+		if k, err := x509.ParsePKCS1PrivateKey(blk.Bytes); err == nil {
+			return k, nil
+		}
+		k, err := x509.ParsePKCS8PrivateKey(blk.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rk, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("signing key is not RSA")
+		}
+		return rk, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	out := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)})
+	if err := os.WriteFile(path, out, 0o600); err != nil {
+		return nil, err
+	}
+	log.Printf("[aicweb/oidc] generated signing key %s", path)
+	return k, nil
+}
+
+// mount 注册到 r 下的 /oidc。r 应是过了风控规则的公开分组：登录表单和
+// /user/login 一样要受 IP 拦截。
+func (p *oidcProvider) mount(r *gin.RouterGroup) {
+	g := r.Group("/oidc")
+	p.prefix = g.BasePath()
+	g.GET("/.well-known/openid-configuration", p.discovery)
+	g.GET("/jwks", p.jwks)
+	g.GET("/authorize", p.authorize)
+	g.POST("/authorize", p.authorizeSubmit)
+	g.POST("/token", p.token)
+	g.GET("/userinfo", p.userinfo)
+	g.POST("/userinfo", p.userinfo)
+
+	adm := g.Group("/admin", auth.Required(p.cfg.AdminSecret))
+	adm.GET("/clients", p.adminListClients)
+	adm.POST("/clients", p.adminCreateClient)
+	adm.PUT("/clients/:id", p.adminUpdateClient)
+	adm.POST("/clients/:id/secret", p.adminRotateSecret)
+	adm.DELETE("/clients/:id", p.adminDeleteClient)
+}
+
+func (p *oidcProvider) discovery(c *gin.Context) {
+	iss := p.cfg.Issuer
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                iss,
+		"authorization_endpoint":                iss + "/authorize",
+		"token_endpoint":                        iss + "/token",
+		"userinfo_endpoint":                     iss + "/userinfo",
+		"jwks_uri":                              iss + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      oidcSupportedScopes,
+		"claims_supported": []string{"sub", "name", "preferred_username", "picture", "profile",
+			"updated_at", "email", "email_verified", "bio", "github_name", "bilibili_uid"},
+		"prompt_values_supported": []string{"none", "login", "consent"},
+	})
+}
+
+func (p *oidcProvider) jwks(c *gin.Context) {
+	pub := p.key.PublicKey
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, gin.H{"keys": []gin.H{{
+		"kty": "RSA", "use": "sig", "alg": "RS256", "kid": p.kid,
+		"n": base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+// ---- 授权端点 ----
+
+// authzRequest 是校验过的授权请求，在登录 / 授权页往返期间暂存在 grants 里。
+type authzRequest struct {
+	ClientID      string   `json:"clientId"`
+	ClientName    string   `json:"clientName"`
+	RedirectURI   string   `json:"redirectUri"`
+	Scopes        []string `json:"scopes"`
+	State         string   `json:"state"`
+	Nonce         string   `json:"nonce"`
+	Challenge     string   `json:"challenge"`
+	Prompt        string   `json:"prompt"`
+	MaxAgeSeconds int      `json:"maxAge"` // -1 表示未指定
+}
+
+// authzCode 是授权码背后的数据。
+type authzCode struct {
+	ClientID    string   `json:"clientId"`
+	RedirectURI string   `json:"redirectUri"`
+	UserID      string   `json:"userId"`
+	Scopes      []string `json:"scopes"`
+	Nonce       string   `json:"nonce"`
+	Challenge   string   `json:"challenge"`
+	AuthTime    int64    `json:"authTime"`
+}
+
+// parseAuthz validates the query. A non-nil redirect means the error can be
+// reported back to the client; otherwise the page shows it.
+func (p *oidcProvider) parseAuthz(c *gin.Context, q url.Values) (*authzRequest, *url.URL, string) {
+	cl, err := p.clients.get(c.Request.Context(), q.Get("client_id"))
+	if err != nil || !cl.Enabled {
+		return nil, nil, "未知或已停用的应用"
+	}
+	redirectURI := q.Get("redirect_uri")
+	if !cl.allowsRedirect(redirectURI) {
+		return nil, nil, "回调地址未登记"
+	}
+	ru, _ := url.Parse(redirectURI)
+	req := &authzRequest{
+		ClientID:      cl.ID,
+		ClientName:    cl.Name,
+		RedirectURI:   redirectURI,
+		State:         q.Get("state"),
+		Nonce:         q.Get("nonce"),
+		Prompt:        q.Get("prompt"),
+		MaxAgeSeconds: -1,
+	}
+	if q.Get("response_type") != "code" {
+		return req, ru, "unsupported_response_type"
+	}
+	for _, sc := range strings.Fields(q.Get("scope")) {
+		if containsString(oidcSupportedScopes, sc) && !containsString(req.Scopes, sc) {
+			req.Scopes = append(req.Scopes, sc)
+		}
+	}
+	if !containsString(req.Scopes, "openid") {
+		return req, ru, "invalid_scope"
+	}
+	if m := q.Get("code_challenge_method"); q.Get("code_challenge") != "" && m != "S256" {
+		return req, ru, "invalid_request"
+	}
+	req.Challenge = q.Get("code_challenge")
+	if cl.Public && req.Challenge == "" {
+		return req, ru, "invalid_request"
+	}
+	if v := q.Get("max_age"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return req, ru, "invalid_request"
+		}
+		req.MaxAgeSeconds = n
+	}
+	return req, nil, ""
+}
+
+func (p *oidcProvider) authorize(c *gin.Context) {
+	req, ru, msg := p.parseAuthz(c, c.Request.URL.Query())
+	if req == nil {
+		p.renderError(c, msg)
+		return
+	}
+	if ru != nil {
+		redirectWith(c, ru, url.Values{"error": {msg}, "state": {req.State}})
+		return
+	}
+	ctx := c.Request.Context()
+	sess := p.readSession(c)
+	if sess != nil && (req.Prompt == "login" ||
+		(req.MaxAgeSeconds >= 0 && time.Since(time.Unix(sess.AuthTime, 0)) > time.Duration(req.MaxAgeSeconds)*time.Second)) {
+		sess = nil
+	}
+	var u *user
+	if sess != nil {
+		u, _ = p.users.userByID(ctx, sess.UserID)
+	}
+	if u != nil && req.Prompt != "consent" && p.clients.hasConsent(ctx, u.ID, req.ClientID, req.Scopes) {
+		p.issueCode(c, req, u.ID, sess.AuthTime)
+		return
+	}
+	if req.Prompt == "none" {
+		code := "consent_required"
+		if u == nil {
+			code = "login_required"
+		}
+		ru, _ := url.Parse(req.RedirectURI)
+		redirectWith(c, ru, url.Values{"error": {code}, "state": {req.State}})
+		return
+	}
+	p.renderPage(c, req, u, "")
+}
+
+func (p *oidcProvider) authorizeSubmit(c *gin.Context) {
+	ctx := c.Request.Context()
+	raw, ok, err := p.grants.Take(ctx, "authz:"+c.PostForm("request_id"))
+	if err != nil || !ok {
+		p.renderError(c, "页面已过期，请返回应用重新发起登录")
+		return
+	}
+	var req authzRequest
+	if json.Unmarshal(raw, &req) != nil {
+		p.renderError(c, "页面已过期，请返回应用重新发起登录")
+		return
+	}
+	ru, _ := url.Parse(req.RedirectURI)
+	if c.PostForm("action") != "approve" {
+		redirectWith(c, ru, url.Values{"error": {"access_denied"}, "state": {req.State}})
+		return
+	}
+
+	var u *user
+	var authTime int64
+	if sess := p.readSession(c); sess != nil && c.PostForm("login") == "" && req.Prompt != "login" {
+		u, _ = p.users.userByID(ctx, sess.UserID)
+		authTime = sess.AuthTime
+	}
+	if u == nil {
+		if res, _ := p.rl.Take(ctx, rlLogin, c.ClientIP()); !res.Allowed {
+			ratelimit.SetHeaders(c, res)
+			p.renderPage(c, &req, nil, "尝试过于频繁，请稍后再试")
+			return
+		}
+		ok, err := risk.Check(c, p.captcha, captchaFormToken(c))
+		if err != nil {
+			log.Printf("[aicweb/oidc] %v", err)
+			p.renderPage(c, &req, nil, "人机验证服务暂不可用，请稍后再试")
+			return
+		}
+		if !ok {
+			p.renderPage(c, &req, nil, "人机验证未通过，请重试")
+			return
+		}
+		login := strings.TrimSpace(c.PostForm("login"))
+		u, err = p.users.authenticate(ctx, login, strings.Contains(login, "@"), c.PostForm("password"))
+		if err != nil {
+			msg := "账号或密码错误"
+			if errors.Is(err, ErrNotActivated) {
+				msg = "账号尚未激活，请先查收激活邮件"
+			}
+			p.renderPage(c, &req, nil, msg)
+			return
+		}
+		authTime = time.Now().Unix()
+		p.writeSession(c, u.ID, authTime)
+	}
+	if err := p.clients.saveConsent(ctx, u.ID, req.ClientID, req.Scopes); err != nil {
+		p.renderError(c, "服务器错误，请稍后再试")
+		return
+	}
+	p.issueCode(c, &req, u.ID, authTime)
+}
+
+func (p *oidcProvider) issueCode(c *gin.Context, req *authzRequest, userID string, authTime int64) {
+	ru, _ := url.Parse(req.RedirectURI)
+	code, err := challenge.NewKey(32)
+	if err == nil {
+		b, _ := json.Marshal(authzCode{
+			ClientID: req.ClientID, RedirectURI: req.RedirectURI, UserID: userID,
+			Scopes: req.Scopes, Nonce: req.Nonce, Challenge: req.Challenge, AuthTime: authTime,
+		})
+		err = p.grants.Put(c.Request.Context(), "code:"+code, b, oidcCodeTTL)
+	}
+	if err != nil {
+		redirectWith(c, ru, url.Values{"error": {"server_error"}, "state": {req.State}})
+		return
+	}
+	redirectWith(c, ru, url.Values{"code": {code}, "state": {req.State}})
+}
+
+func redirectWith(c *gin.Context, ru *url.URL, params url.Values) {
+	u := *ru
+	q := u.Query()
+	for k, v := range params {
+		if len(v) > 0 && v[0] != "" {
+			q.Set(k, v[0])
+		}
+	}
+	u.RawQuery = q.Encode()
+	c.Redirect(http.StatusFound, u.String())
+}
+
+// ---- 登录态 cookie：userID|authTime|exp 的 HMAC 签名 ----
+
+type oidcSession struct {
+	UserID   string
+	AuthTime int64
+}
+
+func (p *oidcProvider) sign(payload string) string {
+	m := hmac.New(sha256.New, p.sessKey)
+	m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+func (p *oidcProvider) writeSession(c *gin.Context, userID string, authTime int64) {
+	if p.cfg.SessionTTL <= 0 {
+		return
+	}
+	exp := time.Now().Add(p.cfg.SessionTTL).Unix()
+	payload := fmt.Sprintf("%s|%d|%d", userID, authTime, exp)
+	val := base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + p.sign(payload)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcSessionCookie, val, int(p.cfg.SessionTTL.Seconds()), p.prefix, "", strings.HasPrefix(p.cfg.Issuer, "https://"), true)
+}
+
+func (p *oidcProvider) readSession(c *gin.Context) *oidcSession {
+	v, err := c.Cookie(oidcSessionCookie)
+	if err != nil || p.cfg.SessionTTL <= 0 {
+		return nil
+	}
+	enc, sig, ok := strings.Cut(v, ".")
+	if !ok {
+		return nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(p.sign(string(raw)))) {
+		return nil
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 {
+		return nil
+	}
+	at, err1 := strconv.ParseInt(parts[1], 10, 64)
+	exp, err2 := strconv.ParseInt(parts[2], 10, 64)
+	if err1 != nil || err2 != nil || time.Now().Unix() > exp {
+		return nil
+	}
+	return &oidcSession{UserID: parts[0], AuthTime: at}
+}
+
+// ---- 令牌端点 ----
+
+func oauthError(c *gin.Context, status int, code, desc string) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, gin.H{"error": code, "error_description": desc})
+}
+
+func (p *oidcProvider) token(c *gin.Context) {
+	ctx := c.Request.Context()
+	clientID, secret, basic := c.Request.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+	cl, err := p.clients.get(ctx, clientID)
+	if err != nil || !cl.Enabled || (!cl.Public && !cl.checkSecret(secret)) {
+		c.Header("WWW-Authenticate", `Basic realm="aicweb"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+	if c.PostForm("grant_type") != "authorization_code" {
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+	raw, ok, err := p.grants.Take(ctx, "code:"+c.PostForm("code"))
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	var code authzCode
+	if !ok || json.Unmarshal(raw, &code) != nil || code.ClientID != cl.ID || code.RedirectURI != c.PostForm("redirect_uri") {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "code is invalid, expired or was issued to another client")
+		return
+	}
+	if code.Challenge != "" {
+		sum := sha256.Sum256([]byte(c.PostForm("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != code.Challenge {
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "PKCE verification failed")
+			return
+		}
+	}
+	u, err := p.users.userByID(ctx, code.UserID)
+	if err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "account is no longer active")
+		return
+	}
+
+	iss := p.cfg.Issuer
+	now := time.Now()
+	exp := now.Add(p.cfg.TokenTTL)
+	idClaims := jwt.MapClaims{
+		"iss": iss, "sub": u.ID, "aud": cl.ID, "azp": cl.ID,
+		"iat": now.Unix(), "exp": exp.Unix(), "auth_time": code.AuthTime,
+	}
+	if code.Nonce != "" {
+		idClaims["nonce"] = code.Nonce
+	}
+	for k, v := range p.claimsFor(c, u, code.Scopes) {
+		idClaims[k] = v
+	}
+	idToken, err := p.signJWT(idClaims, "")
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	accessToken, err := p.signJWT(jwt.MapClaims{
+		"iss": iss, "sub": u.ID, "aud": iss + "/userinfo", "client_id": cl.ID,
+		"scope": strings.Join(code.Scopes, " "), "iat": now.Unix(), "exp": exp.Unix(),
+	}, "at+jwt")
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(p.cfg.TokenTTL.Seconds()),
+		"id_token":     idToken,
+		"scope":        strings.Join(code.Scopes, " "),
+	})
+}
+
+func (p *oidcProvider) signJWT(claims jwt.MapClaims, typ string) (string, error) {
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = p.kid
+	if typ != "" {
+		t.Header["typ"] = typ
+	}
+	return t.SignedString(p.key)
+}
+
+// claimsFor 按 scope 从账号与 PublicProfile 组装用户声明。
+func (p *oidcProvider) claimsFor(c *gin.Context, u *user, scopes []string) map[string]any {
+	out := map[string]any{}
+	if containsString(scopes, "email") {
+		out["email"] = u.Email
+		out["email_verified"] = true // 只有激活过（点过邮件链接）的账号能登录
+	}
+	if !containsString(scopes, "profile") {
+		return out
+	}
+	out["preferred_username"] = u.Username
+	out["name"] = u.Username
+	prof, _ := p.users.GetPublicProfile(c.Request.Context(), u.Username)
+	if prof == nil {
+		return out
+	}
+	if prof.DisplayName != "" {
+		out["name"] = prof.DisplayName
+	}
+	if prof.AvatarUrl != "" {
+		out["picture"] = p.absoluteURL(prof.AvatarUrl)
+	}
+	if prof.Bio != "" {
+		out["bio"] = prof.Bio
+	}
+	if prof.GitHubName != "" {
+		out["github_name"] = prof.GitHubName
+	}
+	if prof.BilibiliUID != "" {
+		out["bilibili_uid"] = prof.BilibiliUID
+	}
+	if !prof.UpdatedAt.IsZero() {
+		out["updated_at"] = prof.UpdatedAt.Unix()
+	}
+	return out
+}
+
+// absoluteURL 把 /assets/... 这类站内路径补成绝对地址（以 issuer 的源为准）。
+func (p *oidcProvider) absoluteURL(ref string) string {
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		return ref
+	}
+	iu, err := url.Parse(p.cfg.Issuer)
+	if err != nil {
+		return ref
+	}
+	return iu.Scheme + "://" + iu.Host + "/" + strings.TrimLeft(ref, "/")
+}
+
+// ---- userinfo ----
+
+func (p *oidcProvider) userinfo(c *gin.Context) {
+	raw := auth.ExtractBearer(c.GetHeader("Authorization"))
+	if raw == "" && c.Request.Method == http.MethodPost {
+		raw = c.PostForm("access_token")
+	}
+	iss := p.cfg.Issuer
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (any, error) {
+		if typ, _ := t.Header["typ"].(string); typ != "at+jwt" {
+			return nil, errors.New("not an access token")
+		}
+		return &p.key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(iss), jwt.WithAudience(iss+"/userinfo"), jwt.WithExpirationRequired())
+	if err != nil {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	sub, _ := claims["sub"].(string)
+	scope, _ := claims["scope"].(string)
+	u, err := p.users.userByID(c.Request.Context(), sub)
+	if err != nil {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	out := p.claimsFor(c, u, strings.Fields(scope))
+	out["sub"] = u.ID
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, out)
+}
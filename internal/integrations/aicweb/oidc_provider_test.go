@@ -0,0 +1,487 @@
+package aicweb
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"backend-go/internal/auth"
+	"backend-go/internal/risk"
+	"backend-go/internal/risk/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer      = "https://aic.example.com/api/aicweb/oidc"
+	testAdminSecret = "0123456789abcdef0123456789abcdef"
+	testRedirect    = "https://rp.example.com/callback"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+type oidcTestEnv struct {
+	r      *gin.Engine
+	p      *oidcProvider
+	userID string
+}
+
+func testOIDCConfig(t *testing.T) oidcConfig {
+	return oidcConfig{
+		Issuer:      testIssuer,
+		KeyPath:     filepath.Join(t.TempDir(), "key.pem"),
+		AdminSecret: []byte(testAdminSecret),
+		TokenTTL:    time.Hour,
+		SessionTTL:  time.Hour,
+	}
+}
+
+func newOIDCTestEnv(t *testing.T) *oidcTestEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+	for _, m := range []func(*sql.DB) error{migrateUsers, migrateProfiles, migrateProfileColumns} {
+		if err := m(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ss := &sqliteService{db: db, tokens: map[string]string{}}
+	ctx := context.Background()
+	if err := ss.Register(ctx, &RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "s3cret-pass"}); err != nil {
+		t.Fatal(err)
+	}
+	var id string
+	if err := db.QueryRow(`UPDATE users SET is_registered=1 WHERE email=? RETURNING id`, "alice@example.com").Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	rl := ratelimit.New(ratelimit.Options{Store: ratelimit.NewMemory(), Policies: map[string]ratelimit.Policy{}})
+	p, err := newOIDCProvider(testOIDCConfig(t), ss, rl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	p.mount(r.Group("/api/aicweb"))
+	return &oidcTestEnv{r: r, p: p, userID: id}
+}
+
+func (e *oidcTestEnv) client(t *testing.T, public bool, uris ...string) (*OIDCClient, string) {
+	t.Helper()
+	cl, secret, err := e.p.clients.create(context.Background(), "RP", uris, public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cl, secret
+}
+
+func (e *oidcTestEnv) do(req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	e.r.ServeHTTP(w, req)
+	return w
+}
+
+func (e *oidcTestEnv) get(path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	return e.do(req)
+}
+
+func (e *oidcTestEnv) post(path string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	return e.do(req)
+}
+
+func s256(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func authorizePath(clientID, redirectURI string, extra url.Values) string {
+	q := url.Values{
+		"client_id":             {clientID},
+		"redirect_uri":          {redirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"openid email profile"},
+		"state":                 {"st"},
+		"nonce":                 {"n-1"},
+		"code_challenge":        {s256(testVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+	for k, v := range extra {
+		q[k] = v
+	}
+	return "/api/aicweb/oidc/authorize?" + q.Encode()
+}
+
+var requestIDRe = regexp.MustCompile(`name="request_id" value="([^"]+)"`)
+
+func requestID(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	m := requestIDRe.FindStringSubmatch(w.Body.String())
+	if w.Code != http.StatusOK || m == nil {
+		t.Fatalf("authorize page: %d %s", w.Code, w.Body.String())
+	}
+	return m[1]
+}
+
+// callback checks the redirect back to the client and returns its query.
+func callback(t *testing.T, w *httptest.ResponseRecorder, redirectURI string) url.Values {
+	t.Helper()
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || err != nil {
+		t.Fatalf("expected redirect, got %d %s", w.Code, w.Body.String())
+	}
+	q := loc.Query()
+	loc.RawQuery = ""
+	if loc.String() != redirectURI {
+		t.Fatalf("redirected to %s", loc)
+	}
+	return q
+}
+
+// login runs the authorize page with a password and returns the code and the
+// provider session cookie.
+func (e *oidcTestEnv) login(t *testing.T, clientID string) (string, *http.Cookie) {
+	t.Helper()
+	rid := requestID(t, e.get(authorizePath(clientID, testRedirect, nil)))
+	w := e.post("/api/aicweb/oidc/authorize", url.Values{
+		"request_id": {rid}, "action": {"approve"}, "login": {"alice@example.com"}, "password": {"s3cret-pass"},
+	})
+	q := callback(t, w, testRedirect)
+	if q.Get("state") != "st" || q.Get("code") == "" {
+		t.Fatalf("callback query: %v", q)
+	}
+	var sess *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == oidcSessionCookie {
+			sess = c
+		}
+	}
+	if sess == nil || !sess.Secure || !sess.HttpOnly {
+		t.Fatalf("session cookie: %+v", sess)
+	}
+	return q.Get("code"), sess
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+}
+
+func (e *oidcTestEnv) token(t *testing.T, form url.Values) (int, tokenResponse) {
+	t.Helper()
+	form.Set("grant_type", "authorization_code")
+	w := e.post("/api/aicweb/oidc/token", form)
+	var tr tokenResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &tr)
+	return w.Code, tr
+}
+
+func TestOIDCProviderRequiresIssuerAndAdminSecret(t *testing.T) {
+	for name, mutate := range map[string]func(*oidcConfig){
+		"no issuer":       func(c *oidcConfig) { c.Issuer = "" },
+		"relative issuer": func(c *oidcConfig) { c.Issuer = "/api/aicweb/oidc" },
+		"issuer query":    func(c *oidcConfig) { c.Issuer = testIssuer + "?x=1" },
+		"no secret":       func(c *oidcConfig) { c.AdminSecret = nil },
+		"short secret":    func(c *oidcConfig) { c.AdminSecret = []byte("too-short") },
+	} {
+		cfg := testOIDCConfig(t)
+		mutate(&cfg)
+		if _, err := newOIDCProvider(cfg, nil, nil, nil); err == nil {
+			t.Errorf("%s: provider created", name)
+		}
+	}
+}
+
+func TestOIDCCodeFlowWithPKCE(t *testing.T) {
+	e := newOIDCTestEnv(t)
+	cl, _ := e.client(t, true, testRedirect)
+	code, sess := e.login(t, cl.ID)
+
+	form := url.Values{"client_id": {cl.ID}, "code": {code}, "redirect_uri": {testRedirect}, "code_verifier": {"wrong-verifier"}}
+	if status, tr := e.token(t, form); status != http.StatusBadRequest || tr.Error != "invalid_grant" {
+		t.Fatalf("bad verifier: %d %+v", status, tr)
+	}
+	// 校验失败也消耗了授权码
+	code, _ = e.login(t, cl.ID)
+	form.Set("code", code)
+	form.Set("code_verifier", testVerifier)
+	status, tr := e.token(t, form)
+	if status != http.StatusOK || tr.IDToken == "" || tr.AccessToken == "" {
+		t.Fatalf("token: %d %+v", status, tr)
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(tr.IDToken, claims, func(*jwt.Token) (any, error) { return &e.p.key.PublicKey, nil },
+		jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(testIssuer), jwt.WithAudience(cl.ID)); err != nil {
+		t.Fatalf("id_token: %v", err)
+	}
+	if claims["sub"] != e.userID || claims["nonce"] != "n-1" || claims["email"] != "alice@example.com" {
+		t.Fatalf("id_token claims: %v", claims)
+	}
+	if status, _ := e.token(t, form); status != http.StatusBadRequest {
+		t.Fatalf("code reused: %d", status)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/aicweb/oidc/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+tr.AccessToken)
+	w := e.do(req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"preferred_username":"alice"`) {
+		t.Fatalf("userinfo: %d %s", w.Code, w.Body.String())
+	}
+	// id_token 不能当 access token 用
+	req.Header.Set("Authorization", "Bearer "+tr.IDToken)
+	if w := e.do(req); w.Code != http.StatusUnauthorized {
+		t.Fatalf("userinfo with id_token: %d", w.Code)
+	}
+
+	// 已登录且已授权：直接回跳，不再显示页面
+	q := callback(t, e.get(authorizePath(cl.ID, testRedirect, nil), sess), testRedirect)
+	if q.Get("code") == "" {
+		t.Fatalf("silent authorize: %v", q)
+	}
+	// prompt=consent 必须重新显示授权页
+	if rid := requestID(t, e.get(authorizePath(cl.ID, testRedirect, url.Values{"prompt": {"consent"}}), sess)); rid == "" {
+		t.Fatal("no consent page")
+	}
+	// prompt=none 且没有登录态
+	if q := callback(t, e.get(authorizePath(cl.ID, testRedirect, url.Values{"prompt": {"none"}})), testRedirect); q.Get("error") != "login_required" {
+		t.Fatalf("prompt=none: %v", q)
+	}
+}
+
+func TestOIDCConsentRequiredAndDenied(t *testing.T) {
+	e := newOIDCTestEnv(t)
+	a, _ := e.client(t, true, testRedirect)
+	b, _ := e.client(t, true, testRedirect)
+	_, sess := e.login(t, a.ID)
+
+	// 另一个应用还没有授权过
+	q := callback(t, e.get(authorizePath(b.ID, testRedirect, url.Values{"prompt": {"none"}}), sess), testRedirect)
+	if q.Get("error") != "consent_required" {
+		t.Fatalf("prompt=none: %v", q)
+	}
+	rid := requestID(t, e.get(authorizePath(b.ID, testRedirect, nil), sess))
+	q = callback(t, e.post("/api/aicweb/oidc/authorize", url.Values{"request_id": {rid}, "action": {"deny"}}, sess), testRedirect)
+	if q.Get("error") != "access_denied" || q.Get("code") != "" {
+		t.Fatalf("deny: %v", q)
+	}
+	// request_id 只能用一次
+	w := e.post("/api/aicweb/oidc/authorize", url.Values{"request_id": {rid}, "action": {"approve"}}, sess)
+	if w.Code != http.StatusBadRequest || w.Header().Get("Location") != "" {
+		t.Fatalf("reused request_id: %d", w.Code)
+	}
+}
+
+func TestOIDCPublicClientNeedsPKCE(t *testing.T) {
+	e := newOIDCTestEnv(t)
+	cl, _ := e.client(t, true, testRedirect)
+	path := authorizePath(cl.ID, testRedirect, url.Values{"code_challenge": {""}, "code_challenge_method": {""}})
+	if q := callback(t, e.get(path), testRedirect); q.Get("error") != "invalid_request" {
+		t.Fatalf("no PKCE: %v", q)
+	}
+	path = authorizePath(cl.ID, testRedirect, url.Values{"code_challenge_method": {"plain"}})
+	if q := callback(t, e.get(path), testRedirect); q.Get("error") != "invalid_request" {
+		t.Fatalf("plain PKCE: %v", q)
+	}
+}
+
+func TestOIDCConfidentialClient(t *testing.T) {
+	e := newOIDCTestEnv(t)
+	cl, secret := e.client(t, false, testRedirect)
+	code, _ := e.login(t, cl.ID)
+	form := url.Values{"client_id": {cl.ID}, "client_secret": {"nope"}, "code": {code},
+		"redirect_uri": {testRedirect}, "code_verifier": {testVerifier}}
+	if status, tr := e.token(t, form); status != http.StatusUnauthorized || tr.Error != "invalid_client" {
+		t.Fatalf("wrong secret: %d %+v", status, tr)
+	}
+	form.Del("client_secret")
+	form.Del("client_id")
+	form.Set("grant_type", "authorization_code")
+	req := httptest.NewRequest(http.MethodPost, "/api/aicweb/oidc/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(cl.ID, secret)
+	if w := e.do(req); w.Code != http.StatusOK {
+		t.Fatalf("basic auth: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOIDCRedirectURIMatching(t *testing.T) {
+	e := newOIDCTestEnv(t)
+	cl, _ := e.client(t, true, testRedirect, "com.example.app:/oauth")
+	for _, bad := range []string{
+		"",
+		testRedirect + "/",
+		testRedirect + "?x=1",
+		testRedirect + "#frag",
+		"HTTPS://rp.example.com/callback",
+		"https://rp.example.com/callback/../evil",
+		"https://rp.example.com.evil.com/callback",
+		"https://evil.com/?u=" + testRedirect,
+		"com.example.app:/oauth/other",
+	} {
+		w := e.get(authorizePath(cl.ID, bad, nil))
+		if w.Code != http.StatusBadRequest || w.Header().Get("Location") != "" {
+			t.Errorf("%q: %d location=%q", bad, w.Code, w.Header().Get("Location"))
+		}
+	}
+	if q := callback(t, e.get(authorizePath(cl.ID, "com.example.app:/oauth", url.Values{"response_type": {"token"}})), "com.example.app:/oauth"); q.Get("error") != "unsupported_response_type" {
+		t.Fatalf("custom scheme: %v", q)
+	}
+
+	// 换码时 redirect_uri 必须与授权请求一致
+	code, _ := e.login(t, cl.ID)
+	form := url.Values{"client_id": {cl.ID}, "code": {code}, "redirect_uri": {"com.example.app:/oauth"}, "code_verifier": {testVerifier}}
+	if status, tr := e.token(t, form); status != http.StatusBadRequest || tr.Error != "invalid_grant" {
+		t.Fatalf("token with another redirect_uri: %d %+v", status, tr)
+	}
+
+	// 停用的应用
+	if err := e.p.clients.update(context.Background(), cl.ID, cl.Name, cl.RedirectURIs, false); err != nil {
+		t.Fatal(err)
+	}
+	if w := e.get(authorizePath(cl.ID, testRedirect, nil)); w.Code != http.StatusBadRequest || w.Header().Get("Location") != "" {
+		t.Fatalf("disabled client: %d", w.Code)
+	}
+}
+
+func TestOIDCIssuerIgnoresRequestHeaders(t *testing.T) {
+	e := newOIDCTestEnv(t)
+	req := httptest.NewRequest(http.MethodGet, "/api/aicweb/oidc/.well-known/openid-configuration", nil)
+	req.Host = "evil.example.com"
+	req.Header.Set("X-Forwarded-Proto", "http")
+	w := e.do(req)
+	var doc map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil || doc["issuer"] != testIssuer ||
+		doc["token_endpoint"] != testIssuer+"/token" {
+		t.Fatalf("discovery: %s", w.Body.String())
+	}
+}
+
+func TestOIDCAdminAuth(t *testing.T) {
+	e := newOIDCTestEnv(t)
+	sign := func(secret []byte) string {
+		tok, _, err := auth.IssueToken(secret, "admin", time.Hour, auth.AMRPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	call := func(method, path, bearer, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return e.do(req)
+	}
+	const clients = "/api/aicweb/oidc/admin/clients"
+	for name, bearer := range map[string]string{
+		"missing":      "",
+		"empty key":    sign([]byte{}),
+		"other secret": sign([]byte("another-secret-another-secret-!!")),
+		"garbage":      "not-a-jwt",
+	} {
+		if w := call(http.MethodGet, clients, bearer, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: %d", name, w.Code)
+		}
+	}
+
+	admin := sign([]byte(testAdminSecret))
+	if w := call(http.MethodGet, clients, admin, ""); w.Code != http.StatusOK {
+		t.Fatalf("list: %d %s", w.Code, w.Body.String())
+	}
+	if w := call(http.MethodPost, clients, admin, `{"name":"RP","redirectUris":["http://rp.example.com/cb"]}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("plain http redirect accepted: %d", w.Code)
+	}
+	w := call(http.MethodPost, clients, admin, `{"name":"RP","redirectUris":["https://rp.example.com/cb","http://127.0.0.1:8080/cb"]}`)
+	var created struct {
+		Data struct {
+			Client       OIDCClient `json:"client"`
+			ClientSecret string     `json:"clientSecret"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusOK || created.Data.ClientSecret == "" {
+		t.Fatalf("create: %d %s", w.Code, w.Body.String())
+	}
+	cl, err := e.p.clients.get(context.Background(), created.Data.Client.ID)
+	if err != nil || !cl.checkSecret(created.Data.ClientSecret) || cl.checkSecret("") {
+		t.Fatalf("stored client: %+v %v", cl, err)
+	}
+	if w := call(http.MethodDelete, clients+"/"+cl.ID, admin, ""); w.Code != http.StatusOK {
+		t.Fatalf("delete: %d", w.Code)
+	}
+	if w := call(http.MethodDelete, clients+"/"+cl.ID, admin, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("delete again: %d", w.Code)
+	}
+}
+
+var powRe = regexp.MustCompile(`data-challenge="([^"]+)"`)
+
+func TestOIDCLoginCaptcha(t *testing.T) {
+	e := newOIDCTestEnv(t)
+	pow, err := risk.NewPoW(risk.PoWConfig{Key: []byte("0123456789abcdef0123"), MaxNumber: 2000, TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.p.captcha = pow
+	cl, _ := e.client(t, true, testRedirect)
+
+	w := e.get(authorizePath(cl.ID, testRedirect, nil))
+	rid := requestID(t, w)
+	m := powRe.FindStringSubmatch(w.Body.String())
+	if m == nil || !strings.Contains(w.Header().Get("Content-Security-Policy"), "'nonce-") {
+		t.Fatalf("no challenge on page: %q %s", w.Header().Get("Content-Security-Policy"), w.Body.String())
+	}
+	form := url.Values{"request_id": {rid}, "action": {"approve"}, "login": {"alice@example.com"}, "password": {"s3cret-pass"}}
+	w = e.post("/api/aicweb/oidc/authorize", form)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("Location") != "" || !strings.Contains(w.Body.String(), "人机验证未通过") {
+		t.Fatalf("no captcha token: %d %s", w.Code, w.Body.String())
+	}
+	// 重新渲染的页面带新的 request_id 和新题
+	r, m := requestIDRe.FindStringSubmatch(w.Body.String()), powRe.FindStringSubmatch(w.Body.String())
+	if r == nil || m == nil {
+		t.Fatal("no challenge after failed captcha")
+	}
+	form.Set("request_id", r[1])
+
+	var ch risk.PoWChallenge
+	if err := json.Unmarshal([]byte(html.UnescapeString(m[1])), &ch); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for ; n <= ch.MaxNumber; n++ {
+		sum := sha256.Sum256([]byte(ch.Salt + strconv.Itoa(n)))
+		if hex.EncodeToString(sum[:]) == ch.Challenge {
+			break
+		}
+	}
+	sol, _ := json.Marshal(map[string]any{"algorithm": ch.Algorithm, "challenge": ch.Challenge, "number": n, "salt": ch.Salt, "signature": ch.Signature})
+	form.Set("captcha_token", base64.StdEncoding.EncodeToString(sol))
+	if q := callback(t, e.post("/api/aicweb/oidc/authorize", form), testRedirect); q.Get("code") == "" {
+		t.Fatalf("solved captcha: %v", q)
+	}
+}
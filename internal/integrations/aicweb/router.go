@@ -2,15 +2,18 @@ package aicweb
 
 import (
 	"io"
+	"log"
 	"os"
 	"strconv"
 	"strings"
 
+	"backend-go/internal/auth/challenge"
 	av "backend-go/internal/avatar"
 	em "backend-go/internal/email"
 	emenv "backend-go/internal/email/envinit"
 	"backend-go/internal/integrations/aicweb/envinit"
 	"backend-go/internal/integrations/msconsent"
+	"backend-go/internal/risk"
 
 	"github.com/gin-gonic/gin"
 )
@@ -74,7 +77,16 @@ func Mount(engine *gin.Engine, r *gin.RouterGroup) {
 		svc = NewServiceMemory()
 	}
 
-	ts := NewTurnstileFromEnv()
+	// PoW 题目跟账号库放一起，多副本共用同一个库时题目也共享。
+	var captchaStore challenge.Store
+	if ss, ok := svc.(*sqliteService); ok {
+		if st, err := challenge.NewSQL(ss.db, "captcha_challenges"); err == nil {
+			captchaStore = st
+		} else {
+			log.Printf("[aicweb] captcha store: %v; using memory", err)
+		}
+	}
+	captcha := newCaptchaFromEnv(captchaStore)
 
 	fs, err := NewFormServiceFromEnv()
 	if err != nil {
@@ -101,17 +113,20 @@ func Mount(engine *gin.Engine, r *gin.RouterGroup) {
 		bnr = &mediaAdapter{svc: bnrSvc}
 	}
 
-	h := NewHandler(svc, ts, fs, notify, avt, bnr)
+	h := NewHandler(svc, captcha, fs, notify, avt, bnr)
+	rl := newLimiter()
 
-	// 公共路由
-	r.POST("/user/register", h.Register)
-	r.POST("/user/login", h.Login)
-	r.GET("/user/activate", h.Activate)
-	r.GET("/user/profiles", h.ListProfiles)
-	r.GET("/user/profile/:username", h.GetPublicProfile)
+	// 公共路由：先过风控规则（IP / 网段拦截、要求人机验证）
+	pub := r.Group("", riskGuard(captcha))
+	pub.POST("/user/register", rl.Handle(rlRegister), h.Register)
+	pub.POST("/user/login", rl.Handle(rlLogin), h.Login)
+	pub.GET("/user/activate", h.Activate)
+	pub.GET("/user/captcha", risk.ConfigHandler(captcha))
+	pub.GET("/user/profiles", h.ListProfiles)
+	pub.GET("/user/profile/:username", h.GetPublicProfile)
 
 	// 受保护路由
-	prv := r.Group("", AuthRequired(svc))
+	prv := pub.Group("", AuthRequired(svc))
 	{
 		prv.GET("/user/profile", h.Profile)
 		prv.PUT("/user/me/profile", h.UpdateMyProfile)
@@ -120,6 +135,15 @@ func Mount(engine *gin.Engine, r *gin.RouterGroup) {
 		prv.POST("/user/form", h.SubmitForm)
 		prv.GET("/user/form", h.ListMyForms)
 	}
+
+	// OIDC 提供方：需要 SQLite 账号库。登录表单和 /user/login 一样过风控规则和人机验证
+	if ss, ok := svc.(*sqliteService); ok && oidcEnabledFromEnv() {
+		if p, err := newOIDCProvider(oidcConfigFromEnv(), ss, rl, captcha); err != nil {
+			log.Printf("[aicweb/oidc] disabled: %v", err)
+		} else {
+			p.mount(pub)
+		}
+	}
 }
 
 func Attach(engine *gin.Engine) {
@@ -100,34 +100,56 @@ func (s *sqliteService) Register(ctx context.Context, req *RegisterRequest) erro
 }
 
 func (s *sqliteService) Login(ctx context.Context, req *LoginRequest) (string, error) {
+	login, byEmail := req.Username, false
+	if req.Email != "" {
+		login, byEmail = req.Email, true
+	}
+	u, err := s.authenticate(ctx, login, byEmail, req.Password)
+	if err != nil {
+		return "", err
+	}
+	tok := randHex(32)
+	s.mu.Lock()
+	s.tokens[tok] = u.Email
+	s.mu.Unlock()
+	return tok, nil
+}
+
+// authenticate checks a password for an activated account, looked up by email
+// or username. Shared by Login and the OIDC provider's login page.
+func (s *sqliteService) authenticate(ctx context.Context, login string, byEmail bool, password string) (*user, error) {
 	var row struct {
 		id, email, username, hash string
 		isReg                     int
 		created                   time.Time
 	}
 	q := `SELECT id,email,username,password_hash,is_registered,created_at FROM users WHERE `
-	var arg any
-	if req.Email != "" {
+	if byEmail {
 		q += `email=?`
-		arg = req.Email
 	} else {
 		q += `username=?`
-		arg = req.Username
 	}
-	if err := s.db.QueryRow(q, arg).Scan(&row.id, &row.email, &row.username, &row.hash, &row.isReg, &row.created); err != nil {
-		return "", ErrUnauthorized
+	if err := s.db.QueryRowContext(ctx, q, login).Scan(&row.id, &row.email, &row.username, &row.hash, &row.isReg, &row.created); err != nil {
+		return nil, ErrUnauthorized
 	}
 	if row.isReg == 0 {
-		return "", ErrNotActivated
+		return nil, ErrNotActivated
 	}
-	if bcrypt.CompareHashAndPassword([]byte(row.hash), []byte(req.Password)) != nil {
-		return "", ErrUnauthorized
+	if bcrypt.CompareHashAndPassword([]byte(row.hash), []byte(password)) != nil {
+		return nil, ErrUnauthorized
 	}
-	tok := randHex(32)
-	s.mu.Lock()
-	s.tokens[tok] = row.email
-	s.mu.Unlock()
-	return tok, nil
+	return &user{ID: row.id, Username: row.username, Email: row.email, CreatedAt: row.created}, nil
+}
+
+// userByID returns an activated account, or ErrUnauthorized.
+func (s *sqliteService) userByID(ctx context.Context, id string) (*user, error) {
+	var u user
+	var isReg int
+	if err := s.db.QueryRowContext(ctx, `SELECT id,email,username,created_at,is_registered FROM users WHERE id=?`, id).
+		Scan(&u.ID, &u.Email, &u.Username, &u.CreatedAt, &isReg); err != nil || isReg == 0 {
+		return nil, ErrUnauthorized
+	}
+	return &u, nil
 }
 
 func (s *sqliteService) Validate(ctx context.Context, token string) (*user, error) {
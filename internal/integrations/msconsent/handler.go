@@ -1,9 +1,10 @@
 package msconsent
 
 import (
-	"crypto/rand"
-	"encoding/base64"
+	"context"
+	"database/sql"
 	"fmt"
+	"log"
 	"net/http"
 	"net/url"
 	"os"
@@ -12,7 +13,10 @@ import (
 	"sync"
 	"time"
 
+	"backend-go/internal/auth/challenge"
+
 	"github.com/gin-gonic/gin"
+	_ "modernc.org/sqlite"
 )
 
 type Config struct {
@@ -29,34 +33,63 @@ func loadConfig() Config {
 	}
 }
 
+// stateStore 保存 OAuth state。默认落到 SQLite（MSCONSENT_STATE_SQLITE_PATH），
+// 重启或多副本负载均衡时回调仍能校验；打开失败时退回进程内存。
 type stateStore struct {
-	mu sync.Mutex
-	m  map[string]time.Time
+	once  sync.Once
+	store challenge.Store
+}
+
+var states = &stateStore{}
+
+func (s *stateStore) backend() challenge.Store {
+	s.once.Do(func() {
+		dsn := firstNonEmpty(os.Getenv("MSCONSENT_STATE_SQLITE_PATH"), "databases/msconsent/state.db")
+		st, err := openStateDB(dsn)
+		if err != nil {
+			log.Printf("[msconsent] state store %s: %v; falling back to memory", dsn, err)
+			s.store = challenge.NewMemory()
+			return
+		}
+		s.store = st
+	})
+	return s.store
 }
 
-var states = &stateStore{m: map[string]time.Time{}}
+func openStateDB(dsn string) (challenge.Store, error) {
+	if !strings.HasPrefix(dsn, "file:") {
+		_ = os.MkdirAll(filepath.Dir(dsn), 0o755)
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	st, err := challenge.NewSQL(db, "msconsent_states")
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return st, nil
+}
 
 func (s *stateStore) New(ttl time.Duration) (string, error) {
-	b := make([]byte, 24)
-	if _, err := rand.Read(b); err != nil {
+	st, err := challenge.NewKey(24)
+	if err != nil {
+		return "", err
+	}
+	if err := s.backend().Put(context.Background(), "state:"+st, []byte{1}, ttl); err != nil {
 		return "", err
 	}
-	st := base64.RawURLEncoding.EncodeToString(b)
-	s.mu.Lock()
-	s.m[st] = time.Now().Add(ttl)
-	s.mu.Unlock()
 	return st, nil
 }
 
 func (s *stateStore) VerifyAndDelete(st string) bool {
-	now := time.Now()
-	s.mu.Lock()
-	exp, ok := s.m[st]
-	if ok {
-		delete(s.m, st)
-	}
-	s.mu.Unlock()
-	return ok && now.Before(exp)
+	if st == "" {
+		return false
+	}
+	_, ok, err := s.backend().Take(context.Background(), "state:"+st)
+	return err == nil && ok
 }
 
 func adminConsentURL(tenant, clientID, redirectURI, state string) (string, error) {
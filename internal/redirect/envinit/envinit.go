@@ -33,17 +33,66 @@ func defaultEnv() []byte {
 			"#   首选：直接填 _ADMIN_PASSWORD（明文），启动时会在内存里 bcrypt。\n" +
 			"#   公网部署可改填 _ADMIN_PASSWORD_HASH（用 cmd/genpw 生成），并把明文那行删掉。\n" +
 			"#   两者都设时 HASH 胜出。两者都空则后台禁用。\n" +
+			"#   首次启动后密码哈希存入数据库，之后以数据库为准；用默认口令 admin 登录会被要求先改密。\n" +
+			"#   忘记密码时把 _ADMIN_PASSWORD_RESET 设为 true 重启一次，用 env 里的口令覆盖数据库。\n" +
 			"REDIRECT_ADMIN_USERNAME=admin\n" +
 			"REDIRECT_ADMIN_PASSWORD=admin\n" +
 			"REDIRECT_ADMIN_PASSWORD_HASH=\n" +
+			"REDIRECT_ADMIN_PASSWORD_RESET=false\n" +
+			"# 改密规则：最短长度、至少包含几类字符（大写/小写/数字/符号）\n" +
+			"REDIRECT_PASSWORD_MIN_LENGTH=10\n" +
+			"REDIRECT_PASSWORD_MIN_CLASSES=0\n" +
+			"# 新密码哈希算法：bcrypt 或 argon2id；登录时参数较弱的旧哈希会自动升级\n" +
+			"REDIRECT_PASSWORD_HASH_ALGO=bcrypt\n" +
+			"REDIRECT_BCRYPT_COST=\n" +
+			"REDIRECT_ARGON2_TIME=\n" +
+			"REDIRECT_ARGON2_MEMORY_KB=\n" +
+			"REDIRECT_ARGON2_THREADS=\n" +
 			"REDIRECT_JWT_SECRET=" + randHex(32) + "\n" +
-			"REDIRECT_JWT_TTL_HOURS=12\n\n" +
+			"REDIRECT_JWT_TTL_HOURS=12\n" +
+			"# 后台 cookie 会话：JWT 放进 HttpOnly cookie，SPA 用 X-CSRF-Token 防 CSRF。\n" +
+			"#   留空跟随 HTTP_ADMIN_SESSION_COOKIE；SAMESITE 可选 strict/lax/none，SECURE 可选 auto/true/false。\n" +
+			"REDIRECT_SESSION_COOKIE=\n" +
+			"REDIRECT_SESSION_SAMESITE=strict\n" +
+			"REDIRECT_SESSION_COOKIE_SECURE=auto\n" +
+			"REDIRECT_SESSION_COOKIE_DOMAIN=\n\n" +
+			"# 登录防爆破：前 N 次失败不限速，之后按指数退避，封顶即临时锁定。\n" +
+			"#   留空使用默认值（3 次 / 2 秒起步 / 最长锁 15 分钟 / 24 小时后清零）。\n" +
+			"REDIRECT_LOGIN_FREE_ATTEMPTS=\n" +
+			"REDIRECT_LOGIN_BASE_DELAY_SECONDS=\n" +
+			"REDIRECT_LOGIN_MAX_LOCKOUT_MINUTES=\n" +
+			"REDIRECT_LOGIN_RESET_HOURS=\n" +
+			"# 失败 N 次后要求人机验证（0 关闭）。设了 LOGIN_TURNSTILE_SECRET 用 Turnstile，\n" +
+			"#   否则按 REDIRECT_CAPTCHA_PROVIDER / _SECRET / _SITE_KEY（turnstile|hcaptcha|recaptcha|pow）\n" +
+			"REDIRECT_LOGIN_TURNSTILE_AFTER=0\n" +
+			"REDIRECT_LOGIN_TURNSTILE_SECRET=\n" +
+			"# 敏感操作（关闭 TOTP、删除 passkey）需在 N 分钟内重新验证\n" +
+			"REDIRECT_STEPUP_MAX_AGE_MINUTES=5\n\n" +
 			"# TOTP (Google Authenticator)\n" +
 			"REDIRECT_TOTP_ISSUER=Redirect\n\n" +
 			"# WebAuthn / Passkey\n" +
 			"REDIRECT_WEBAUTHN_RPID=localhost\n" +
 			"REDIRECT_WEBAUTHN_RP_NAME=Redirect Admin\n" +
-			"REDIRECT_WEBAUTHN_ORIGINS=http://localhost:5174,http://localhost:8081\n",
+			"REDIRECT_WEBAUTHN_ORIGINS=http://localhost:5174,http://localhost:8081\n" +
+			"# 认证器策略：允许的 AAGUID（逗号分隔，留空不限制）、是否要求厂商证明、是否要求用户验证\n" +
+			"#   设置 AAGUID 白名单时必须同时开启 REQUIRE_ATTESTATION；开启 REQUIRE_ATTESTATION 时必须配置 ATTESTATION_ROOTS，否则拒绝启动\n" +
+			"REDIRECT_WEBAUTHN_AAGUIDS=\n" +
+			"REDIRECT_WEBAUTHN_REQUIRE_ATTESTATION=false\n" +
+			"REDIRECT_WEBAUTHN_REQUIRE_UV=false\n" +
+			"# 证明证书根 CA（PEM 文件路径，开启 REQUIRE_ATTESTATION 时必填）\n" +
+			"REDIRECT_WEBAUTHN_ATTESTATION_ROOTS=\n\n" +
+			"# OIDC 单点登录（可选）。ISSUER/CLIENT_ID/REDIRECT_URL 与白名单都填才启用。\n" +
+			"#   REDIRECT_URL 指向 <本服务>/api/redirect/admin/oidc/callback；本地调试可用 go run ./cmd/mockoidc\n" +
+			"REDIRECT_OIDC_ISSUER=\n" +
+			"REDIRECT_OIDC_CLIENT_ID=\n" +
+			"REDIRECT_OIDC_CLIENT_SECRET=\n" +
+			"REDIRECT_OIDC_REDIRECT_URL=\n" +
+			"REDIRECT_OIDC_SCOPES=openid,email,profile\n" +
+			"# 允许映射为管理员的 sub / 已验证邮箱（逗号分隔）\n" +
+			"REDIRECT_OIDC_ALLOWED_SUBJECTS=\n" +
+			"REDIRECT_OIDC_ALLOWED_EMAILS=\n" +
+			"# 登录完成后跳回的后台登录页，token 通过 URL fragment 传回\n" +
+			"REDIRECT_OIDC_POST_LOGIN_REDIRECT=\n",
 	)
 }
 
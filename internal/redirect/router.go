@@ -1,9 +1,10 @@
 package redirect
 
 import (
-	"backend-go/internal/auth"
+	"backend-go/internal/auth/apitoken"
 	"backend-go/internal/authflow"
 	"backend-go/internal/redirect/envinit"
+	"backend-go/internal/risk/rules"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,19 +18,24 @@ func Mount(r *gin.RouterGroup, svc *Service) {
 	admin := r.Group("/admin")
 	flow.Mount(admin)
 
-	authed := admin.Group("", auth.Required(svc.Admin.JWTSecret))
-	authed.GET("/rules", adm.ListRules)
-	authed.POST("/rules", adm.UpsertRule)
-	authed.PUT("/rules/:name", adm.UpsertRule)
-	authed.DELETE("/rules/:name", adm.DeleteRule)
-	authed.GET("/cards", adm.ListCards)
-	authed.POST("/cards", adm.UpsertCard)
-	authed.PUT("/cards/:hwid", adm.UpsertCard)
-	authed.DELETE("/cards/:hwid", adm.DeleteCard)
+	// admin JWT, or an API token (X-API-Token / Bearer rdrt_…) with the route's scope
+	authed := admin.Group("", svc.Tokens.Required(svc.Admin.JWTSecret, "X-API-Token"), flow.PasswordGate())
+	authed.GET("/rules", apitoken.Scope(ScopeRulesRead), adm.ListRules)
+	authed.POST("/rules", apitoken.Scope(ScopeRulesWrite), adm.UpsertRule)
+	authed.PUT("/rules/:name", apitoken.Scope(ScopeRulesWrite), adm.UpsertRule)
+	authed.DELETE("/rules/:name", apitoken.Scope(ScopeRulesWrite), adm.DeleteRule)
+	authed.GET("/cards", apitoken.Scope(ScopeCardsRead), adm.ListCards)
+	authed.POST("/cards", apitoken.Scope(ScopeCardsWrite), adm.UpsertCard)
+	authed.PUT("/cards/:hwid", apitoken.Scope(ScopeCardsWrite), adm.UpsertCard)
+	authed.DELETE("/cards/:hwid", apitoken.Scope(ScopeCardsWrite), adm.DeleteCard)
+	svc.Tokens.Mount(authed.Group("/api-tokens"), flow.StepUp())
+	// risk rules scoped to this module (global rules are managed in RoundNFC)
+	rules.Shared().Mount(authed.Group("/risk", apitoken.AdminOnly()), "redirect")
 
 	// public — fixed segments first, then the wildcard catch-all
-	r.GET("/pncs/:hwid", pub.RedirectNFC)
-	r.GET("/:name", pub.RedirectByName)
+	public := r.Group("", rules.Shared().Guard(rules.GuardOptions{Scope: "redirect"}))
+	public.GET("/pncs/:hwid", pub.RedirectNFC)
+	public.GET("/:name", pub.RedirectByName)
 }
 
 // 兼容旧用法：固定 /api/redirect
@@ -1,15 +1,20 @@
 package redirect
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
+	"backend-go/internal/auth"
 	"backend-go/internal/auth/adminpw"
+	"backend-go/internal/auth/apitoken"
+	"backend-go/internal/auth/challenge"
 	"backend-go/internal/authflow"
 	"backend-go/internal/redirect/storage"
+	"backend-go/internal/risk"
 )
 
 type AdminConfig struct {
@@ -21,13 +26,33 @@ type AdminConfig struct {
 	WARPID       string
 	WARPName     string
 	WAOrigins    []string
+	LoginGuard   authflow.GuardConfig
+	WAPolicy     authflow.AttestationPolicy
+	StepUpMaxAge time.Duration
+	OIDC         authflow.OIDCConfig
+	PWHashing    auth.HashPolicy
+	PWRules      auth.PasswordRules
+	PWReset      bool
+	Session      authflow.SessionConfig
 }
 
 type Service struct {
 	Store *storage.SQLite
 	Admin AdminConfig
+	// Challenges 存 WebAuthn challenge，与规则库同库，多副本可共享
+	Challenges challenge.Store
+	// Tokens 是带 scope 的 API token，供脚本批量维护规则 / 卡片
+	Tokens *apitoken.Service
 }
 
+// API token scopes.
+const (
+	ScopeRulesRead  = "redirect:rules:read"
+	ScopeRulesWrite = "redirect:rules:write"
+	ScopeCardsRead  = "redirect:cards:read"
+	ScopeCardsWrite = "redirect:cards:write"
+)
+
 func NewServiceFromEnv() (*Service, error) {
 	dsn := os.Getenv("REDIRECT_SQLITE_PATH")
 	if dsn == "" {
@@ -41,7 +66,34 @@ func NewServiceFromEnv() (*Service, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Service{Store: st, Admin: loadAdminConfig()}, nil
+	challenges, err := challenge.NewSQL(st.DB, "admin_challenges")
+	if err != nil {
+		_ = st.Close()
+		return nil, err
+	}
+	tokens, err := apitoken.New(st.DB, apitoken.Options{
+		Prefix: "rdrt_",
+		Scopes: []string{ScopeRulesRead, ScopeRulesWrite, ScopeCardsRead, ScopeCardsWrite},
+	})
+	if err != nil {
+		_ = st.Close()
+		return nil, err
+	}
+	admin := loadAdminConfig()
+	if err := admin.WAPolicy.Validate(); err != nil {
+		_ = st.Close()
+		return nil, fmt.Errorf("REDIRECT_WEBAUTHN_*: %w", err)
+	}
+	// keep PoW login challenges in the shared store so they work across replicas
+	if os.Getenv("REDIRECT_LOGIN_TURNSTILE_SECRET") == "" {
+		captcha, err := risk.VerifierFromEnv("REDIRECT", challenges)
+		if err != nil {
+			_ = st.Close()
+			return nil, err
+		}
+		admin.LoginGuard.Captcha = captcha
+	}
+	return &Service{Store: st, Admin: admin, Challenges: challenges, Tokens: tokens}, nil
 }
 
 func loadAdminConfig() AdminConfig {
@@ -77,6 +129,12 @@ func loadAdminConfig() AdminConfig {
 	if rpID == "" {
 		rpID = "localhost"
 	}
+	stepUp := 5 * time.Minute
+	if v := strings.TrimSpace(os.Getenv("REDIRECT_STEPUP_MAX_AGE_MINUTES")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			stepUp = time.Duration(n) * time.Minute
+		}
+	}
 	return AdminConfig{
 		Username:     username,
 		PasswordHash: adminpw.Resolve("redirect", "REDIRECT"),
@@ -86,6 +144,14 @@ func loadAdminConfig() AdminConfig {
 		WARPID:       rpID,
 		WARPName:     rpName,
 		WAOrigins:    origins,
+		LoginGuard:   authflow.GuardConfigFromEnv("REDIRECT"),
+		WAPolicy:     authflow.AttestationPolicyFromEnv("REDIRECT"),
+		StepUpMaxAge: stepUp,
+		OIDC:         authflow.OIDCConfigFromEnv("REDIRECT"),
+		PWHashing:    auth.HashPolicyFromEnv("REDIRECT"),
+		PWRules:      auth.PasswordRulesFromEnv("REDIRECT"),
+		PWReset:      strings.EqualFold(strings.TrimSpace(os.Getenv("REDIRECT_ADMIN_PASSWORD_RESET")), "true"),
+		Session:      authflow.SessionConfigFromEnv("REDIRECT"),
 	}
 }
 
@@ -101,6 +167,15 @@ func (s *Service) AuthFlowConfig() authflow.Config {
 		WebAuthnRPID:      s.Admin.WARPID,
 		WebAuthnRPName:    s.Admin.WARPName,
 		WebAuthnOrigins:   s.Admin.WAOrigins,
+		WebAuthnPolicy:    s.Admin.WAPolicy,
+		Guard:             s.Admin.LoginGuard,
+		Challenges:        s.Challenges,
+		StepUpMaxAge:      s.Admin.StepUpMaxAge,
+		OIDC:              s.Admin.OIDC,
+		PasswordHashing:   s.Admin.PWHashing,
+		PasswordRules:     s.Admin.PWRules,
+		PasswordReset:     s.Admin.PWReset,
+		Session:           s.Admin.Session,
 	}
 }
 
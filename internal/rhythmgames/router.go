@@ -1,8 +1,13 @@
 package rhythmgames
 
-import "github.com/gin-gonic/gin"
+import (
+	"backend-go/internal/risk/rules"
+
+	"github.com/gin-gonic/gin"
+)
 
 func Mount(r *gin.RouterGroup) {
+	r = r.Group("", rules.Shared().Guard(rules.GuardOptions{Scope: "rhythmgames"}))
 	// DX Rating SVG
 	r.GET("/:game/dxrating.svg", handleDXRating)
 }
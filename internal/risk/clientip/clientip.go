@@ -0,0 +1,338 @@
+// Package clientip 从反向代理链里解析真实客户端 IP。
+//
+// gin 默认信任所有代理，任何人都能伪造 X-Forwarded-For 绕过限流。这里只在
+// 直连对端属于受信代理时才读转发头，并把解析结果写回 Request.RemoteAddr，
+// 所以各模块继续用 c.ClientIP() 就能拿到同一个、不可伪造的地址。
+package clientip
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CloudflareHeader 是 Cloudflare 回源时带上的客户端 IP。
+const CloudflareHeader = "CF-Connecting-IP"
+
+// 写进 gin.Context 的 key。
+const (
+	ContextKeyPeer = "clientip.peer" // 直连对端（通常是反代）的 IP
+)
+
+// forwardingHeaders 在对端不受信时会被删掉，避免模块读到伪造的值。
+var forwardingHeaders = []string{
+	"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Real-IP",
+	"True-Client-IP", CloudflareHeader, "Forwarded",
+}
+
+// ErrSpoofed 表示受信代理转来的头里有无法解析的地址，多半是客户端伪造后
+// 被代理原样透传（例如 nginx 配了 proxy_set_header X-Real-IP $http_x_real_ip）。
+var ErrSpoofed = errors.New("clientip: malformed forwarding header")
+
+// Config 描述部署前面的代理。
+type Config struct {
+	// TrustedProxies 是会写转发头的反代网段。为空时不信任任何转发头。
+	TrustedProxies []netip.Prefix
+	// Headers 是按顺序查看的头，默认 X-Forwarded-For、X-Real-IP。
+	// X-Forwarded-For 从右往左跳过受信代理，其余头只取一个地址。
+	Headers []string
+	// CloudflareFile 是 Cloudflare 网段文件（每行一个 CIDR，# 开头为注释，
+	// 即 https://www.cloudflare.com/ips-v4 与 ips-v6 的内容）。对端在这些
+	// 网段内时直接用 CF-Connecting-IP，这些网段也算受信代理。文件变化后
+	// 一分钟内自动重新加载，用 cron 定期下载即可。
+	CloudflareFile string
+	// RejectSpoofed 为 true 时，带 ErrSpoofed 的请求直接 400。
+	RejectSpoofed bool
+}
+
+// Resolver 按 Config 解析客户端 IP，并发安全。
+type Resolver struct {
+	cfg Config
+
+	mu        sync.RWMutex
+	cf        []netip.Prefix
+	cfModTime time.Time
+	nextCheck time.Time
+	now       func() time.Time
+}
+
+// New 创建 Resolver。CloudflareFile 读不到时返回错误。
+func New(cfg Config) (*Resolver, error) {
+	if len(cfg.Headers) == 0 {
+		cfg.Headers = []string{"X-Forwarded-For", "X-Real-IP"}
+	}
+	r := &Resolver{cfg: cfg, now: time.Now}
+	if cfg.CloudflareFile != "" {
+		if err := r.reloadCloudflare(true); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// ConfigFromEnv 读：
+//
+//	HTTP_TRUSTED_PROXIES      逗号分隔的 CIDR 或 IP；默认 127.0.0.1/8,::1/128，none 表示不信任
+//	HTTP_CLIENT_IP_HEADERS    默认 X-Forwarded-For,X-Real-IP
+//	HTTP_CLOUDFLARE_IPS_FILE  Cloudflare 网段文件，留空不启用
+//	HTTP_CLIENT_IP_STRICT     默认 true：转发头格式不对时拒绝请求
+func ConfigFromEnv() (Config, error) {
+	var cfg Config
+	spec := strings.TrimSpace(os.Getenv("HTTP_TRUSTED_PROXIES"))
+	if spec == "" {
+		spec = "127.0.0.1/8,::1/128"
+	}
+	if !strings.EqualFold(spec, "none") {
+		ps, err := ParsePrefixes(strings.Split(spec, ","))
+		if err != nil {
+			return cfg, fmt.Errorf("HTTP_TRUSTED_PROXIES: %w", err)
+		}
+		cfg.TrustedProxies = ps
+	}
+	for _, h := range strings.Split(os.Getenv("HTTP_CLIENT_IP_HEADERS"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			cfg.Headers = append(cfg.Headers, http.CanonicalHeaderKey(h))
+		}
+	}
+	cfg.CloudflareFile = strings.TrimSpace(os.Getenv("HTTP_CLOUDFLARE_IPS_FILE"))
+	v := strings.TrimSpace(os.Getenv("HTTP_CLIENT_IP_STRICT"))
+	cfg.RejectSpoofed = v == "" || strings.EqualFold(v, "true") || v == "1"
+	return cfg, nil
+}
+
+// ParsePrefixes 解析 CIDR 列表，单个 IP 视为 /32 或 /128，空项跳过。
+func ParsePrefixes(items []string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, s := range items {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			a, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			out = append(out, netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", s)
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+// Resolve 返回请求的客户端地址与直连对端地址。err 为 ErrSpoofed 时 client
+// 退回到链上最后一个可信的地址。
+func (r *Resolver) Resolve(req *http.Request) (client, peer netip.Addr, err error) {
+	peer, perr := parseHostPort(req.RemoteAddr)
+	if perr != nil {
+		return peer, peer, nil
+	}
+	fromCF := r.inCloudflare(peer)
+	if !fromCF && !r.trusted(peer) {
+		return peer, peer, nil
+	}
+	if fromCF {
+		if v := strings.TrimSpace(req.Header.Get(CloudflareHeader)); v != "" {
+			a, err := parseAddr(v)
+			if err != nil {
+				return peer, peer, ErrSpoofed
+			}
+			return a, peer, nil
+		}
+	}
+	for _, h := range r.cfg.Headers {
+		values := req.Header.Values(h)
+		if len(values) == 0 {
+			continue
+		}
+		if http.CanonicalHeaderKey(h) == "X-Forwarded-For" {
+			return r.walkXFF(values, peer)
+		}
+		a, err := parseAddr(strings.TrimSpace(values[0]))
+		if err != nil {
+			return peer, peer, ErrSpoofed
+		}
+		return a, peer, nil
+	}
+	return peer, peer, nil
+}
+
+// walkXFF 从右往左跳过受信代理，第一个不受信的就是客户端；整条链都受信
+// 时取最左边的地址。
+func (r *Resolver) walkXFF(values []string, peer netip.Addr) (netip.Addr, netip.Addr, error) {
+	var hops []string
+	for _, v := range values {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	last := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		a, err := parseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return last, peer, ErrSpoofed
+		}
+		if !r.trusted(a) && !r.inCloudflare(a) {
+			return a, peer, nil
+		}
+		last = a
+	}
+	return last, peer, nil
+}
+
+func (r *Resolver) trusted(a netip.Addr) bool {
+	return contains(r.cfg.TrustedProxies, a)
+}
+
+func (r *Resolver) inCloudflare(a netip.Addr) bool {
+	if r.cfg.CloudflareFile == "" {
+		return false
+	}
+	r.maybeReload()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return contains(r.cf, a)
+}
+
+// maybeReload 每分钟最多看一次文件的修改时间，变了就重新加载。
+func (r *Resolver) maybeReload() {
+	now := r.now()
+	r.mu.Lock()
+	due := now.After(r.nextCheck)
+	if due {
+		r.nextCheck = now.Add(time.Minute)
+	}
+	r.mu.Unlock()
+	if !due {
+		return
+	}
+	if err := r.reloadCloudflare(false); err != nil {
+		log.Printf("[clientip] reload %s: %v; keeping previous ranges", r.cfg.CloudflareFile, err)
+	}
+}
+
+func (r *Resolver) reloadCloudflare(force bool) error {
+	st, err := os.Stat(r.cfg.CloudflareFile)
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	same := st.ModTime().Equal(r.cfModTime)
+	r.mu.RUnlock()
+	if same && !force {
+		return nil
+	}
+	f, err := os.Open(r.cfg.CloudflareFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	ps, err := ParsePrefixes(lines)
+	if err != nil {
+		return err
+	}
+	if len(ps) == 0 {
+		return errors.New("no ranges in file")
+	}
+	r.mu.Lock()
+	r.cf, r.cfModTime = ps, st.ModTime()
+	r.mu.Unlock()
+	return nil
+}
+
+// Middleware 解析客户端 IP 并写回 c.Request.RemoteAddr，原对端地址放进
+// ContextKeyPeer。对端不受信时删掉所有转发头。engine 需要先
+// SetTrustedProxies(nil)，让 c.ClientIP() 只看 RemoteAddr。
+func (r *Resolver) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client, peer, err := r.Resolve(c.Request)
+		if !peer.IsValid() {
+			c.Next()
+			return
+		}
+		c.Set(ContextKeyPeer, peer.String())
+		if !r.trusted(peer) && !r.inCloudflare(peer) {
+			for _, h := range forwardingHeaders {
+				c.Request.Header.Del(h)
+			}
+		}
+		if err != nil {
+			log.Printf("[clientip] %v from %s", err, peer)
+			if r.cfg.RejectSpoofed {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": "invalid forwarding headers"})
+				return
+			}
+		}
+		c.Request.RemoteAddr = net.JoinHostPort(client.String(), "0")
+		c.Next()
+	}
+}
+
+// Peer 返回 Middleware 记录的直连对端地址；没经过 Middleware 时返回 RemoteIP。
+func Peer(c *gin.Context) string {
+	if v := c.GetString(ContextKeyPeer); v != "" {
+		return v
+	}
+	return c.RemoteIP()
+}
+
+// Install 关掉 gin 自带的转发头处理，并把 Middleware 挂到 engine 最前面。
+func Install(engine *gin.Engine, r *Resolver) {
+	_ = engine.SetTrustedProxies(nil)
+	engine.TrustedPlatform = ""
+	engine.Use(r.Middleware())
+}
+
+func contains(ps []netip.Prefix, a netip.Addr) bool {
+	a = a.Unmap()
+	for _, p := range ps {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAddr(s string) (netip.Addr, error) {
+	// 容忍 "[::1]:port" / "1.2.3.4:port" 这类带端口的写法。
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), nil
+	}
+	a, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return a.Unmap(), nil
+}
+
+func parseHostPort(remote string) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		host = remote
+	}
+	return parseAddr(host)
+}
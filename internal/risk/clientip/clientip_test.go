@@ -0,0 +1,114 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newResolver(t *testing.T, cfg Config) *Resolver {
+	t.Helper()
+	r, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func request(remote string, headers map[string]string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remote
+	for k, v := range headers {
+		req.Header.Add(k, v)
+	}
+	return req
+}
+
+func TestResolve(t *testing.T) {
+	proxies, err := ParsePrefixes([]string{"10.0.0.0/8", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newResolver(t, Config{TrustedProxies: proxies})
+	cases := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+		spoofed bool
+	}{
+		{"direct client ignores headers", "203.0.113.9:5000", map[string]string{"X-Forwarded-For": "1.1.1.1"}, "203.0.113.9", false},
+		{"single proxy", "10.0.0.2:80", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7", false},
+		{"spoofed left entry skipped", "10.0.0.2:80", map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.7, 10.0.0.3"}, "198.51.100.7", false},
+		{"all trusted takes leftmost", "10.0.0.2:80", map[string]string{"X-Forwarded-For": "10.1.1.1, 10.0.0.3"}, "10.1.1.1", false},
+		{"x-real-ip fallback", "[::1]:80", map[string]string{"X-Real-IP": "2001:db8::1"}, "2001:db8::1", false},
+		{"garbage in chain", "10.0.0.2:80", map[string]string{"X-Forwarded-For": "evil, 10.0.0.3"}, "10.0.0.3", true},
+		{"garbage real ip", "10.0.0.2:80", map[string]string{"X-Real-IP": "<script>"}, "10.0.0.2", true},
+		{"trusted proxy without headers", "10.0.0.2:80", nil, "10.0.0.2", false},
+	}
+	for _, tc := range cases {
+		client, _, err := r.Resolve(request(tc.remote, tc.headers))
+		if client.String() != tc.want || (err != nil) != tc.spoofed {
+			t.Errorf("%s: got %s, %v; want %s (spoofed=%v)", tc.name, client, err, tc.want, tc.spoofed)
+		}
+	}
+}
+
+func TestCloudflareFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cf.txt")
+	if err := os.WriteFile(path, []byte("# ips-v4\n173.245.48.0/20\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	r := newResolver(t, Config{CloudflareFile: path})
+	r.now = func() time.Time { return now }
+
+	cf := map[string]string{CloudflareHeader: "198.51.100.7", "X-Forwarded-For": "6.6.6.6"}
+	if c, _, _ := r.Resolve(request("173.245.48.1:443", cf)); c.String() != "198.51.100.7" {
+		t.Fatalf("from cloudflare: %s", c)
+	}
+	if c, _, _ := r.Resolve(request("192.0.2.1:443", cf)); c.String() != "192.0.2.1" {
+		t.Fatalf("CF-Connecting-IP honoured from non-cloudflare peer: %s", c)
+	}
+
+	if err := os.WriteFile(path, []byte("192.0.2.0/24\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	_ = os.Chtimes(path, later, later)
+	now = now.Add(2 * time.Minute)
+	if c, _, _ := r.Resolve(request("192.0.2.1:443", cf)); c.String() != "198.51.100.7" {
+		t.Fatalf("ranges not reloaded: %s", c)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	proxies, _ := ParsePrefixes([]string{"10.0.0.0/8"})
+	e := gin.New()
+	Install(e, newResolver(t, Config{TrustedProxies: proxies, RejectSpoofed: true}))
+	e.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, c.ClientIP()+" "+Peer(c)+" "+c.GetHeader("X-Forwarded-Proto"))
+	})
+	do := func(remote string, h map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, request(remote, h))
+		return w
+	}
+	w := do("10.0.0.2:80", map[string]string{"X-Forwarded-For": "198.51.100.7", "X-Forwarded-Proto": "https"})
+	if w.Body.String() != "198.51.100.7 10.0.0.2 https" {
+		t.Fatalf("proxied: %q", w.Body.String())
+	}
+	w = do("203.0.113.9:80", map[string]string{"X-Forwarded-For": "198.51.100.7", "X-Forwarded-Proto": "https"})
+	if w.Body.String() != "203.0.113.9 203.0.113.9 " {
+		t.Fatalf("direct: %q", w.Body.String())
+	}
+	if w = do("10.0.0.2:80", map[string]string{"X-Forwarded-For": "nope"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("spoofed chain: %d", w.Code)
+	}
+}
@@ -0,0 +1,161 @@
+package risk
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend-go/internal/auth/challenge"
+)
+
+// PoWConfig 配置本地工作量证明。
+type PoWConfig struct {
+	Key       []byte          // HMAC 签名 key；短于 16 字节时每次启动随机生成（重启后旧题作废）
+	MaxNumber int             // 难度：答案在 [0, MaxNumber] 内均匀随机，默认 100000
+	TTL       time.Duration   // 题目有效期，默认 5 分钟
+	Store     challenge.Store // 已用过的题目（防重放）；nil 时用内存。多副本请传共享的 SQL store
+}
+
+// PoW 是 ALTCHA 风格的工作量证明：服务端下发 salt 与 sha256(salt+number)，
+// 客户端穷举 number 求解，提交时带回服务端的 HMAC 签名。不依赖第三方服务，
+// 适合访问不了 Cloudflare 的部署。下发题目不写任何状态（签名和过期时间都在
+// 题目里），Verify 通过时才把题目记为已用，每道题只能用一次。
+type PoW struct {
+	cfg PoWConfig
+}
+
+// PoWChallenge 是下发给前端的题目，字段与 ALTCHA widget 兼容。
+type PoWChallenge struct {
+	Algorithm string `json:"algorithm"`
+	Challenge string `json:"challenge"`
+	MaxNumber int    `json:"maxnumber"`
+	Salt      string `json:"salt"`
+	Signature string `json:"signature"`
+}
+
+// powSolution 是前端提交的 token（base64 JSON）。
+type powSolution struct {
+	Algorithm string `json:"algorithm"`
+	Challenge string `json:"challenge"`
+	Number    int    `json:"number"`
+	Salt      string `json:"salt"`
+	Signature string `json:"signature"`
+}
+
+// NewPoW 按 cfg 创建 PoW，零值字段取默认。
+func NewPoW(cfg PoWConfig) (*PoW, error) {
+	if len(cfg.Key) < 16 {
+		cfg.Key = make([]byte, 32)
+		if _, err := rand.Read(cfg.Key); err != nil {
+			return nil, err
+		}
+		log.Printf("[risk/pow] no key configured; using a random one (challenges do not survive restarts)")
+	}
+	if cfg.MaxNumber <= 0 {
+		cfg.MaxNumber = 100_000
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 5 * time.Minute
+	}
+	if cfg.Store == nil {
+		cfg.Store = challenge.NewMemory()
+	}
+	return &PoW{cfg: cfg}, nil
+}
+
+func (p *PoW) Provider() string { return "pow" }
+
+func (p *PoW) Enabled() bool { return true }
+
+func (p *PoW) sign(challenge string) string {
+	m := hmac.New(sha256.New, p.cfg.Key)
+	m.Write([]byte(challenge))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func powHash(salt string, number int) string {
+	sum := sha256.Sum256([]byte(salt + strconv.Itoa(number)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Challenge 生成一道新题，不落库：匿名的 GET /captcha 不该让存储变大。
+func (p *PoW) Challenge(context.Context) (any, error) {
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(p.cfg.MaxNumber)+1))
+	if err != nil {
+		return nil, err
+	}
+	exp := time.Now().Add(p.cfg.TTL).Unix()
+	salt := hex.EncodeToString(raw) + "?expires=" + strconv.FormatInt(exp, 10)
+	ch := powHash(salt, int(n.Int64()))
+	return PoWChallenge{
+		Algorithm: "SHA-256",
+		Challenge: ch,
+		MaxNumber: p.cfg.MaxNumber,
+		Salt:      salt,
+		Signature: p.sign(ch),
+	}, nil
+}
+
+// Verify 校验 base64(JSON{algorithm,challenge,number,salt,signature})。
+func (p *PoW) Verify(ctx context.Context, token, _ string) (Result, error) {
+	fail := func(code string) (Result, error) { return Result{ErrorCodes: []string{code}}, nil }
+	if token == "" {
+		return fail("missing-input-response")
+	}
+	raw, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		if raw, err = base64.RawURLEncoding.DecodeString(token); err != nil {
+			return fail("invalid-input-response")
+		}
+	}
+	var s powSolution
+	if err := json.Unmarshal(raw, &s); err != nil || !strings.EqualFold(s.Algorithm, "SHA-256") {
+		return fail("invalid-input-response")
+	}
+	if !hmac.Equal([]byte(s.Signature), []byte(p.sign(s.Challenge))) {
+		return fail("invalid-signature")
+	}
+	exp, err := powExpiry(s.Salt)
+	if err != nil || time.Now().Unix() > exp {
+		return fail("timeout-or-duplicate")
+	}
+	if s.Number < 0 || s.Number > p.cfg.MaxNumber || powHash(s.Salt, s.Number) != s.Challenge {
+		return fail("invalid-solution")
+	}
+	// 签名只证明题目是我们出的；记一笔已用，保留到题目过期为止
+	ok, err := p.cfg.Store.Add(ctx, "pow:"+s.Challenge, nil, time.Until(time.Unix(exp+1, 0)))
+	if err != nil {
+		return Result{}, err
+	}
+	if !ok {
+		return fail("timeout-or-duplicate")
+	}
+	return Result{Success: true}, nil
+}
+
+func powExpiry(salt string) (int64, error) {
+	_, q, ok := strings.Cut(salt, "?")
+	if !ok {
+		return 0, errors.New("no expiry")
+	}
+	v, err := url.ParseQuery(q)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(v.Get("expires"), 10, 64)
+}
@@ -0,0 +1,34 @@
+package ratelimit
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// PoliciesFromEnv 按路由名解析策略。defaults 是代码里声明的默认值
+// （名字 → ParsePolicy 格式），每一条都可以用 <PREFIX>_RATELIMIT_<NAME>
+// 覆盖，NAME 大写、"-" 换成 "_"；设为 off 即关闭。写错的值打日志并沿用默认。
+//
+//	ROUNDNFC_RATELIMIT_PHOTO_REQUEST=6/1m,burst=2
+//	AICWEB_RATELIMIT_LOGIN=off
+func PoliciesFromEnv(envPrefix string, defaults map[string]string) map[string]Policy {
+	out := make(map[string]Policy, len(defaults))
+	for name, def := range defaults {
+		key := envPrefix + "_RATELIMIT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		spec := def
+		if v, ok := os.LookupEnv(key); ok && strings.TrimSpace(v) != "" {
+			spec = v
+		}
+		p, err := ParsePolicy(name, spec)
+		if err != nil && spec != def {
+			log.Printf("[ratelimit] %s: %v; using default %q", key, err, def)
+			p, err = ParsePolicy(name, def)
+		}
+		if err != nil {
+			log.Printf("[ratelimit] default for %s: %v; not limited", name, err)
+		}
+		out[name] = p
+	}
+	return out
+}
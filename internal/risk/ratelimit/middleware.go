@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KeyFunc 从请求里取出限流 key 的一段，多段用 ":" 拼起来。
+type KeyFunc func(c *gin.Context) string
+
+// ByIP 按客户端 IP 限流。
+func ByIP(c *gin.Context) string { return c.ClientIP() }
+
+// ByParam 按路由参数限流，例如 ByParam("id")。
+func ByParam(name string) KeyFunc {
+	return func(c *gin.Context) string { return c.Param(name) }
+}
+
+// BySubject 按鉴权中间件写入 ctxKey 的主体限流（如 auth.ContextKeySubject），
+// 没有时退回 IP。
+func BySubject(ctxKey string) KeyFunc {
+	return func(c *gin.Context) string {
+		if v := c.GetString(ctxKey); v != "" {
+			return "sub:" + v
+		}
+		return c.ClientIP()
+	}
+}
+
+// Key 按 keys 拼出请求的限流 key；keys 为空时按 IP。
+func Key(c *gin.Context, keys ...KeyFunc) string {
+	if len(keys) == 0 {
+		return c.ClientIP()
+	}
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k(c)
+	}
+	return strings.Join(parts, ":")
+}
+
+// Handle 返回按 policy 限流的中间件：每个请求消耗一次配额并写响应头，
+// 超限时交给 Options.Deny 并中止。
+func (l *Limiter) Handle(policy string, keys ...KeyFunc) gin.HandlerFunc {
+	if _, ok := l.policies[policy]; !ok {
+		log.Printf("[ratelimit] %s: unknown policy %q, route not limited", l.ns, policy)
+	}
+	return func(c *gin.Context) {
+		if !l.Allow(c, policy, keys...) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// Allow 与 Handle 相同，但在 handler 里调用：返回 false 时响应已写好，
+// 调用方直接 return。适合只在部分分支限流的场景（如已有登录态时不计数）。
+func (l *Limiter) Allow(c *gin.Context, policy string, keys ...KeyFunc) bool {
+	res, err := l.Take(c.Request.Context(), policy, Key(c, keys...))
+	if err != nil {
+		log.Printf("[ratelimit] %s/%s: %v", l.ns, policy, err)
+		return true
+	}
+	if res.Policy.Disabled() {
+		return true
+	}
+	SetHeaders(c, res)
+	if res.Allowed {
+		return true
+	}
+	if l.deny != nil {
+		l.deny(c, res)
+	} else {
+		c.JSON(http.StatusTooManyRequests, gin.H{"code": http.StatusTooManyRequests, "message": "too many requests"})
+	}
+	c.Abort()
+	return false
+}
+
+// SetHeaders 写 RateLimit-Limit / RateLimit-Remaining / RateLimit-Reset /
+// RateLimit-Policy（IETF draft-ietf-httpapi-ratelimit-headers），被拒时加 Retry-After。
+func SetHeaders(c *gin.Context, r Result) {
+	h := c.Writer.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(r.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(r.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(r.ResetAfter)))
+	h.Set("RateLimit-Policy", strconv.Itoa(r.Limit)+";w="+strconv.Itoa(ceilSeconds(r.Policy.Period)))
+	if !r.Allowed {
+		h.Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(r.RetryAfter))))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,237 @@
+// Package ratelimit 是各模块共用的限流子系统：
+//
+//   - 算法：GCRA（默认，每个 key 只存一个时间戳）或令牌桶；
+//   - 存储：进程内 Memory（空闲 key 自动清理）或 SQLite（重启、多副本共享）；
+//   - gin 中间件：按路由声明策略，输出 RateLimit-* / Retry-After 响应头。
+//
+// 策略写在配置里，例如 ROUNDNFC_RATELIMIT_UPLOAD=12/1m,burst=4，
+// 见 PoliciesFromEnv。
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Algorithm 选择限流算法。
+type Algorithm string
+
+const (
+	// GCRA（通用信元速率算法）：与令牌桶等价，但状态只有一个「理论到达时间」。
+	GCRA Algorithm = "gcra"
+	// TokenBucket 经典令牌桶：容量 Burst，每 Period/Limit 补充一个令牌。
+	TokenBucket Algorithm = "bucket"
+)
+
+// Policy 描述一条限流规则：每 Period 允许 Limit 次，瞬时最多 Burst 次。
+type Policy struct {
+	Name      string
+	Limit     int
+	Period    time.Duration
+	Burst     int       // 0 表示等于 Limit
+	Algorithm Algorithm // 空表示 GCRA
+}
+
+// Disabled 报告该策略是否不限流（Limit 或 Period 不为正）。
+func (p Policy) Disabled() bool { return p.Limit <= 0 || p.Period <= 0 }
+
+func (p Policy) burst() int {
+	if p.Burst > 0 {
+		return p.Burst
+	}
+	return p.Limit
+}
+
+// interval 是补充一个配额所需的时间。
+func (p Policy) interval() time.Duration { return p.Period / time.Duration(p.Limit) }
+
+// String 输出 ParsePolicy 能读回的形式。
+func (p Policy) String() string {
+	if p.Disabled() {
+		return "off"
+	}
+	s := fmt.Sprintf("%d/%s", p.Limit, p.Period)
+	if p.Burst > 0 {
+		s += ",burst=" + strconv.Itoa(p.Burst)
+	}
+	if p.Algorithm != "" && p.Algorithm != GCRA {
+		s += ",algo=" + string(p.Algorithm)
+	}
+	return s
+}
+
+// ParsePolicy 解析 "12/1m"、"5/30s,burst=2"、"100/1h,algo=bucket"、"off"。
+// 周期可以省略数字（"12/m" 等同 "12/1m"）。
+func ParsePolicy(name, spec string) (Policy, error) {
+	p := Policy{Name: name}
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if spec == "" || spec == "off" || spec == "0" {
+		return p, nil
+	}
+	parts := strings.Split(spec, ",")
+	rate := strings.SplitN(strings.TrimSpace(parts[0]), "/", 2)
+	if len(rate) != 2 {
+		return p, fmt.Errorf("ratelimit: %s: want <limit>/<period>, got %q", name, spec)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(rate[0]))
+	if err != nil || n < 0 {
+		return p, fmt.Errorf("ratelimit: %s: bad limit %q", name, rate[0])
+	}
+	per := strings.TrimSpace(rate[1])
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return p, fmt.Errorf("ratelimit: %s: bad period %q", name, rate[1])
+	}
+	p.Limit, p.Period = n, d
+	for _, opt := range parts[1:] {
+		k, v, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		switch strings.TrimSpace(k) {
+		case "burst":
+			b, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil || b < 0 {
+				return p, fmt.Errorf("ratelimit: %s: bad burst %q", name, v)
+			}
+			p.Burst = b
+		case "algo":
+			switch a := Algorithm(strings.TrimSpace(v)); a {
+			case GCRA, TokenBucket:
+				p.Algorithm = a
+			default:
+				return p, fmt.Errorf("ratelimit: %s: unknown algorithm %q", name, v)
+			}
+		default:
+			return p, fmt.Errorf("ratelimit: %s: unknown option %q", name, k)
+		}
+	}
+	return p, nil
+}
+
+// Result 是一次 Take 的结果，也是响应头的来源。
+type Result struct {
+	Allowed    bool
+	Limit      int           // 瞬时上限（Burst）
+	Remaining  int           // 本次之后还能立即通过几次
+	ResetAfter time.Duration // 多久后恢复满额
+	RetryAfter time.Duration // 被拒时多久后可以重试
+	Policy     Policy
+}
+
+// State 是存储里每个 key 的状态。GCRA 只用 At（理论到达时间）；
+// 令牌桶用 Tokens 与 At（上次补充时间）。
+type State struct {
+	Tokens float64
+	At     int64 // unix 纳秒
+}
+
+// apply 按策略消耗一次配额，返回新状态、结果，以及状态还需保留多久
+// （过了这段时间状态等同于满额，可以丢弃）。
+func apply(p Policy, s State, found bool, now time.Time) (State, Result, time.Duration) {
+	burst := p.burst()
+	res := Result{Limit: burst, Policy: p}
+	if p.Algorithm == TokenBucket {
+		rate := float64(p.Limit) / float64(p.Period) // 令牌 / 纳秒
+		tokens := float64(burst)
+		if found {
+			tokens = math.Min(float64(burst), s.Tokens+float64(now.UnixNano()-s.At)*rate)
+		}
+		if tokens >= 1 {
+			tokens--
+			res.Allowed = true
+		} else {
+			res.RetryAfter = time.Duration((1 - tokens) / rate)
+		}
+		res.Remaining = int(tokens)
+		res.ResetAfter = time.Duration((float64(burst) - tokens) / rate)
+		return State{Tokens: tokens, At: now.UnixNano()}, res, res.ResetAfter
+	}
+
+	t := p.interval()
+	tat := now
+	if found && s.At > now.UnixNano() {
+		tat = time.Unix(0, s.At)
+	}
+	next := tat.Add(t)
+	allowAt := next.Add(-time.Duration(burst) * t)
+	if now.Before(allowAt) {
+		res.RetryAfter = allowAt.Sub(now)
+		res.ResetAfter = tat.Sub(now)
+		return s, res, res.ResetAfter
+	}
+	res.Allowed = true
+	res.Remaining = int(now.Sub(allowAt) / t)
+	res.ResetAfter = next.Sub(now)
+	return State{At: next.UnixNano()}, res, res.ResetAfter
+}
+
+// Options 配置 Limiter。
+type Options struct {
+	Namespace string            // 区分共用同一个 Store 的模块，如 "roundnfc"
+	Store     Store             // nil 时用新的 Memory
+	Policies  map[string]Policy // 按名字取，Take / Handle 的 policy 参数即这里的 key
+	// Deny 在被限流时写响应；nil 时返回 429 {"code":429,"message":"too many requests"}。
+	Deny func(c *gin.Context, r Result)
+}
+
+// ErrUnknownPolicy 表示 Take 用了未声明的策略名。
+var ErrUnknownPolicy = errors.New("ratelimit: unknown policy")
+
+// Limiter 把策略和存储组合起来。
+type Limiter struct {
+	ns       string
+	store    Store
+	policies map[string]Policy
+	deny     func(c *gin.Context, r Result)
+	now      func() time.Time
+}
+
+// New 创建 Limiter。
+func New(opts Options) *Limiter {
+	l := &Limiter{ns: opts.Namespace, store: opts.Store, policies: map[string]Policy{}, deny: opts.Deny, now: time.Now}
+	if l.store == nil {
+		l.store = NewMemory()
+	}
+	for name, p := range opts.Policies {
+		p.Name = name
+		l.policies[name] = p
+	}
+	return l
+}
+
+// Policy 返回名为 name 的策略。
+func (l *Limiter) Policy(name string) (Policy, bool) {
+	p, ok := l.policies[name]
+	return p, ok
+}
+
+// Take 为 policy 下的 key 消耗一次配额。策略关闭时总是放行。
+func (l *Limiter) Take(ctx context.Context, policy, key string) (Result, error) {
+	p, ok := l.policies[policy]
+	if !ok {
+		return Result{Allowed: true}, fmt.Errorf("%w %q", ErrUnknownPolicy, policy)
+	}
+	if p.Disabled() {
+		return Result{Allowed: true, Policy: p}, nil
+	}
+	now := l.now()
+	var res Result
+	err := l.store.Update(ctx, l.ns+"\x00"+policy+"\x00"+key, func(s State, found bool) (State, time.Duration) {
+		next, r, ttl := apply(p, s, found, now)
+		res = r
+		return next, ttl
+	})
+	if err != nil {
+		// 存储故障时放行，不因为限流把整站拖垮。
+		return Result{Allowed: true, Policy: p}, err
+	}
+	return res, nil
+}
@@ -0,0 +1,180 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_ "modernc.org/sqlite"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("x", "12/m,burst=4,algo=bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Limit != 12 || p.Period != time.Minute || p.Burst != 4 || p.Algorithm != TokenBucket {
+		t.Fatalf("got %+v", p)
+	}
+	if p.String() != "12/1m0s,burst=4,algo=bucket" {
+		t.Fatalf("String() = %q", p.String())
+	}
+	if p, err := ParsePolicy("x", "off"); err != nil || !p.Disabled() {
+		t.Fatalf("off: %+v %v", p, err)
+	}
+	for _, bad := range []string{"12", "x/1m", "5/1parsec", "5/1m,burst=-1", "5/1m,algo=leaky", "5/1m,foo=1"} {
+		if _, err := ParsePolicy("x", bad); err == nil {
+			t.Errorf("ParsePolicy(%q) accepted", bad)
+		}
+	}
+}
+
+// drive runs n takes at the fixed clock and returns how many were allowed.
+func drive(l *Limiter, policy, key string, n int) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		if r, _ := l.Take(context.Background(), policy, key); r.Allowed {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestAlgorithms(t *testing.T) {
+	for _, algo := range []Algorithm{GCRA, TokenBucket} {
+		t.Run(string(algo), func(t *testing.T) {
+			now := time.Unix(1_700_000_000, 0)
+			l := New(Options{Policies: map[string]Policy{"p": {Limit: 6, Period: time.Minute, Burst: 3, Algorithm: algo}}})
+			l.now = func() time.Time { return now }
+
+			if got := drive(l, "p", "a", 5); got != 3 {
+				t.Fatalf("burst: allowed %d, want 3", got)
+			}
+			r, _ := l.Take(context.Background(), "p", "a")
+			if r.Allowed || r.RetryAfter <= 0 || r.RetryAfter > 10*time.Second {
+				t.Fatalf("denied result: %+v", r)
+			}
+			if got := drive(l, "p", "b", 1); got != 1 {
+				t.Fatal("keys are not independent")
+			}
+
+			now = now.Add(10 * time.Second) // one interval
+			if got := drive(l, "p", "a", 3); got != 1 {
+				t.Fatalf("after one interval: allowed %d, want 1", got)
+			}
+			now = now.Add(time.Minute)
+			r, _ = l.Take(context.Background(), "p", "a")
+			if !r.Allowed || r.Remaining != 2 || r.Limit != 3 {
+				t.Fatalf("after full refill: %+v", r)
+			}
+		})
+	}
+}
+
+func TestMemoryEvictsIdleKeys(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	l := New(Options{Store: m, Policies: map[string]Policy{"p": {Limit: 10, Period: time.Minute}}})
+	l.now = func() time.Time { return now }
+	for _, k := range []string{"a", "b", "c"} {
+		drive(l, "p", k, 1)
+	}
+	if m.Len() != 3 {
+		t.Fatalf("len = %d", m.Len())
+	}
+	now = now.Add(2 * time.Minute)
+	drive(l, "p", "d", 1)
+	if m.Len() != 1 {
+		t.Fatalf("idle keys not evicted: len = %d", m.Len())
+	}
+}
+
+func TestSQLiteSharedAcrossLimiters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rl.db")
+	s1, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pol := map[string]Policy{"p": {Limit: 4, Period: time.Hour}}
+	a := New(Options{Namespace: "m", Store: s1, Policies: pol})
+	b := New(Options{Namespace: "m", Store: s2, Policies: pol})
+	if got := drive(a, "p", "ip", 3) + drive(b, "p", "ip", 3); got != 4 {
+		t.Fatalf("allowed %d across replicas, want 4", got)
+	}
+	other := New(Options{Namespace: "n", Store: s2, Policies: pol})
+	if drive(other, "p", "ip", 1) != 1 {
+		t.Fatal("namespaces should not share counters")
+	}
+}
+
+func TestHandleHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l := New(Options{Policies: map[string]Policy{"p": {Limit: 2, Period: time.Minute}}})
+	r := gin.New()
+	r.POST("/x/:id", l.Handle("p", ByIP, ByParam("id")), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	do := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		return w
+	}
+	w := do("/x/1")
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Remaining") != "1" {
+		t.Fatalf("first: %d %v", w.Code, w.Header())
+	}
+	do("/x/1")
+	w = do("/x/1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("limited: %d %v", w.Code, w.Header())
+	}
+	if w := do("/x/2"); w.Code != http.StatusOK {
+		t.Fatalf("other id: %d", w.Code)
+	}
+}
+
+// 两个副本并发消耗同一个 key：不能有请求因锁冲突出错（出错即放行），
+// 放行总数也不能超过配额。
+func TestSQLiteConcurrentReplicas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rl.db")
+	pol := map[string]Policy{"p": {Limit: 10, Period: time.Hour}}
+	var limiters []*Limiter
+	for i := 0; i < 2; i++ {
+		s, err := OpenSQLite(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = s.db.Close() })
+		limiters = append(limiters, New(Options{Namespace: "m", Store: s, Policies: pol}))
+	}
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(l *Limiter) {
+			defer wg.Done()
+			r, err := l.Take(context.Background(), "p", "ip")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if r.Allowed {
+				allowed.Add(1)
+			}
+		}(limiters[i%2])
+	}
+	wg.Wait()
+	if allowed.Load() != 10 {
+		t.Fatalf("allowed %d, want 10", allowed.Load())
+	}
+}
@@ -0,0 +1,229 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store 保存每个 key 的限流状态。Update 必须原子：读出状态（found=false 表示
+// 没有或已过期），交给 fn 计算新状态，再连同保留时长 ttl 写回。
+type Store interface {
+	Update(ctx context.Context, key string, fn func(s State, found bool) (State, time.Duration)) error
+}
+
+// sweepEvery 是清理过期 key 的最小间隔。
+const sweepEvery = time.Minute
+
+// Memory 是进程内存储。过期（已恢复满额）的 key 会在之后的调用里被顺带清掉，
+// 不会无限增长；进程重启即清空。
+type Memory struct {
+	mu        sync.Mutex
+	items     map[string]memEntry
+	nextSweep time.Time
+	now       func() time.Time
+}
+
+type memEntry struct {
+	s   State
+	exp time.Time
+}
+
+// NewMemory 创建空的 Memory。
+func NewMemory() *Memory {
+	return &Memory{items: map[string]memEntry{}, now: time.Now}
+}
+
+func (m *Memory) Update(_ context.Context, key string, fn func(State, bool) (State, time.Duration)) error {
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.After(m.nextSweep) {
+		for k, e := range m.items {
+			if !now.Before(e.exp) {
+				delete(m.items, k)
+			}
+		}
+		m.nextSweep = now.Add(sweepEvery)
+	}
+	e, found := m.items[key]
+	if found && !now.Before(e.exp) {
+		found = false
+	}
+	s, ttl := fn(e.s, found)
+	if ttl <= 0 {
+		delete(m.items, key)
+		return nil
+	}
+	m.items[key] = memEntry{s: s, exp: now.Add(ttl)}
+	return nil
+}
+
+// Len 返回当前保存的 key 数。
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.items)
+}
+
+// SQLite 把状态存进 SQLite，重启后限流仍然有效；多个副本指向同一个文件即可共享。
+// key 只存 sha256，库里不落原始 IP。
+type SQLite struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	nextSweep time.Time
+}
+
+// NewSQLite 在 db 上建表。调用方负责 db 的生命周期。
+func NewSQLite(db *sql.DB) (*SQLite, error) {
+	const ddl = `
+CREATE TABLE IF NOT EXISTS ratelimit_state (
+  key_hash   TEXT PRIMARY KEY,
+  tokens     REAL NOT NULL DEFAULT 0,
+  at         INTEGER NOT NULL,
+  expires_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_ratelimit_state_expires ON ratelimit_state(expires_at);`
+	if _, err := db.Exec(ddl); err != nil {
+		return nil, err
+	}
+	return &SQLite{db: db}, nil
+}
+
+// OpenSQLite 打开（必要时创建）path 处的数据库。
+func OpenSQLite(path string) (*SQLite, error) {
+	if !strings.HasPrefix(path, "file:") {
+		_ = os.MkdirAll(filepath.Dir(path), 0o755)
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	// 多个进程共用文件时，写锁冲突等一会儿而不是立刻报 SQLITE_BUSY。
+	if _, err := db.Exec(`PRAGMA busy_timeout = 5000; PRAGMA journal_mode = WAL;`); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	s, err := NewSQLite(db)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Update 用 BEGIN IMMEDIATE 从读到写一直持有写锁：普通（deferred）事务先读
+// 后写，两个副本同时升级为写锁时一方会直接拿到 SQLITE_BUSY（不等 busy_timeout），
+// Take 出错即放行，限流就被绕过了。
+func (s *SQLite) Update(ctx context.Context, key string, fn func(State, bool) (State, time.Duration)) error {
+	sum := sha256.Sum256([]byte(key))
+	hash := hex.EncodeToString(sum[:])
+	now := time.Now()
+	s.sweep(ctx, now)
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// 另一个进程持有写锁时等一会儿，而不是立刻失败
+	if _, err := conn.ExecContext(ctx, `PRAGMA busy_timeout = 5000`); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_, _ = conn.ExecContext(context.Background(), `ROLLBACK`)
+		}
+	}()
+
+	var st State
+	var exp int64
+	found := true
+	err = conn.QueryRowContext(ctx, `SELECT tokens, at, expires_at FROM ratelimit_state WHERE key_hash=?`, hash).
+		Scan(&st.Tokens, &st.At, &exp)
+	if errors.Is(err, sql.ErrNoRows) {
+		found = false
+	} else if err != nil {
+		return err
+	}
+	if found && exp <= now.UnixNano() {
+		found = false
+	}
+	next, ttl := fn(st, found)
+	if ttl <= 0 {
+		_, err = conn.ExecContext(ctx, `DELETE FROM ratelimit_state WHERE key_hash=?`, hash)
+	} else {
+		_, err = conn.ExecContext(ctx, `
+INSERT INTO ratelimit_state(key_hash, tokens, at, expires_at) VALUES(?,?,?,?)
+ON CONFLICT(key_hash) DO UPDATE SET tokens=excluded.tokens, at=excluded.at, expires_at=excluded.expires_at`,
+			hash, next.Tokens, next.At, now.Add(ttl).UnixNano())
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `COMMIT`); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+func (s *SQLite) sweep(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	due := now.After(s.nextSweep)
+	if due {
+		s.nextSweep = now.Add(sweepEvery)
+	}
+	s.mu.Unlock()
+	if !due {
+		return
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM ratelimit_state WHERE expires_at <= ?`, now.UnixNano()); err != nil {
+		log.Printf("[ratelimit] sweep: %v", err)
+	}
+}
+
+var (
+	sharedOnce  sync.Once
+	sharedStore Store
+)
+
+// SharedStore 返回进程内各模块共用的 Store，由环境变量决定：
+//
+//	RATELIMIT_STORE=memory（默认）| sqlite
+//	RATELIMIT_SQLITE_PATH=databases/ratelimit/ratelimit.db
+//
+// SQLite 打不开时退回 Memory 并打日志。
+func SharedStore() Store {
+	sharedOnce.Do(func() {
+		kind := strings.ToLower(strings.TrimSpace(os.Getenv("RATELIMIT_STORE")))
+		if kind == "sqlite" {
+			path := strings.TrimSpace(os.Getenv("RATELIMIT_SQLITE_PATH"))
+			if path == "" {
+				path = "databases/ratelimit/ratelimit.db"
+			}
+			s, err := OpenSQLite(path)
+			if err == nil {
+				sharedStore = s
+				return
+			}
+			log.Printf("[ratelimit] sqlite store %s: %v; falling back to memory", path, err)
+		}
+		sharedStore = NewMemory()
+	})
+	return sharedStore
+}
@@ -0,0 +1,179 @@
+package rules
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Mount 挂载规则管理接口，须放在后台鉴权之后：
+//
+//	GET    "/rules"         列表
+//	POST   "/rules"         新建 {kind, value, action, note, ttlSeconds[, scope]}
+//	DELETE "/rules/:id"     删除
+//	GET    "/rules/check"   ?ip= 试算某个 IP 会命中哪条规则
+//	GET    "/groups"        分组列表
+//	PUT    "/groups/:name"  创建 / 替换分组 {cidrs, note}（仅 scope 为空时）
+//	DELETE "/groups/:name"  删除分组（仅 scope 为空时）
+//
+// scope 为空表示全局管理入口，可以管理所有模块的规则和分组；否则只能
+// 看到全局规则和本模块规则，只能增删本模块的规则。
+func (e *Engine) Mount(g *gin.RouterGroup, scope string) {
+	if e == nil {
+		return
+	}
+	a := &admin{e: e, scope: scope}
+	g.GET("/rules", a.list)
+	g.POST("/rules", a.create)
+	g.DELETE("/rules/:id", a.remove)
+	g.GET("/rules/check", a.check)
+	g.GET("/groups", a.groups)
+	if scope == "" {
+		g.PUT("/groups/:name", a.putGroup)
+		g.DELETE("/groups/:name", a.deleteGroup)
+	}
+}
+
+type admin struct {
+	e     *Engine
+	scope string
+}
+
+func ok(c *gin.Context, data any) {
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "ok", "data": data})
+}
+
+func fail(c *gin.Context, status int, msg string) {
+	c.JSON(status, gin.H{"code": status, "message": msg, "data": nil})
+}
+
+func (a *admin) list(c *gin.Context) {
+	var scopes []string
+	if a.scope != "" {
+		scopes = []string{"", a.scope}
+	}
+	items, err := a.e.List(c.Request.Context(), scopes...)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ok(c, gin.H{"items": items})
+}
+
+// CreatePayload 是新建规则的请求体，也供模块的「按记录拉黑」接口复用。
+type CreatePayload struct {
+	Scope      string `json:"scope"`
+	Kind       string `json:"kind"`
+	Value      string `json:"value"`
+	Action     string `json:"action"`
+	Note       string `json:"note"`
+	TTLSeconds int    `json:"ttlSeconds"`
+}
+
+// TTL 返回 TTLSeconds 对应的时长，0 表示永久。
+func (p CreatePayload) TTL() time.Duration {
+	if p.TTLSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(p.TTLSeconds) * time.Second
+}
+
+func (a *admin) create(c *gin.Context) {
+	var p CreatePayload
+	if err := c.ShouldBindJSON(&p); err != nil {
+		fail(c, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if a.scope != "" {
+		p.Scope = a.scope
+	}
+	r, err := a.e.Add(c.Request.Context(), Rule{Scope: p.Scope, Kind: p.Kind, Value: p.Value, Action: p.Action, Note: p.Note}, p.TTL())
+	if err != nil {
+		failStore(c, err)
+		return
+	}
+	log.Printf("[risk/rules] add id=%q scope=%q %s %s=%s ip=%s", r.ID, r.Scope, r.Action, r.Kind, r.Value, c.ClientIP())
+	ok(c, gin.H{"item": r})
+}
+
+func (a *admin) remove(c *gin.Context) {
+	ctx := c.Request.Context()
+	r, err := a.e.Get(ctx, c.Param("id"))
+	if err == nil && a.scope != "" && r.Scope != a.scope {
+		err = ErrNotFound
+	}
+	if err == nil {
+		err = a.e.Delete(ctx, r.ID)
+	}
+	if err != nil {
+		failStore(c, err)
+		return
+	}
+	log.Printf("[risk/rules] delete id=%q ip=%s", r.ID, c.ClientIP())
+	ok(c, gin.H{"ok": true})
+}
+
+func (a *admin) check(c *gin.Context) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(c.Query("ip")))
+	if err != nil {
+		fail(c, http.StatusBadRequest, "invalid ip")
+		return
+	}
+	scope := a.scope
+	if scope == "" {
+		scope = strings.TrimSpace(c.Query("scope"))
+	}
+	d := a.e.Match(c.Request.Context(), scope, addr, nil)
+	ok(c, gin.H{"action": d.Action, "rule": d.Rule})
+}
+
+func (a *admin) groups(c *gin.Context) {
+	items, err := a.e.Groups(c.Request.Context())
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ok(c, gin.H{"items": items})
+}
+
+func (a *admin) putGroup(c *gin.Context) {
+	var p struct {
+		CIDRs []string `json:"cidrs"`
+		Note  string   `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		fail(c, http.StatusBadRequest, "invalid body")
+		return
+	}
+	g, err := a.e.PutGroup(c.Request.Context(), Group{Name: c.Param("name"), CIDRs: p.CIDRs, Note: p.Note})
+	if err != nil {
+		failStore(c, err)
+		return
+	}
+	log.Printf("[risk/rules] group %q cidrs=%d ip=%s", g.Name, len(g.CIDRs), c.ClientIP())
+	ok(c, gin.H{"item": g})
+}
+
+func (a *admin) deleteGroup(c *gin.Context) {
+	if err := a.e.DeleteGroup(c.Request.Context(), c.Param("name")); err != nil {
+		failStore(c, err)
+		return
+	}
+	ok(c, gin.H{"ok": true})
+}
+
+func failStore(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		fail(c, http.StatusNotFound, "not found")
+	case errors.Is(err, ErrInvalid):
+		fail(c, http.StatusBadRequest, strings.TrimPrefix(err.Error(), "rules: "))
+	default:
+		fail(c, http.StatusInternalServerError, err.Error())
+	}
+}
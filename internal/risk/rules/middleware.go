@@ -0,0 +1,86 @@
+package rules
+
+import (
+	"log"
+	"net/http"
+	"net/netip"
+
+	"backend-go/internal/risk"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GuardOptions 配置一个模块的 Guard。
+type GuardOptions struct {
+	// Scope 是模块名，匹配 Scope 为空或等于它的规则。
+	Scope string
+	// Hash 是模块存库时计算 ip_hash 的方式；nil 时跳过 ip_hash 规则。
+	Hash func(ip string) string
+	// Captcha 用于 challenge 规则；nil 或未启用时 challenge 按 block 处理。
+	Captcha risk.Verifier
+	// Deny 写拒绝响应（block，或 challenge 未通过）；nil 时用默认的
+	// {"code":403,"message":…,"data":…}。
+	Deny func(c *gin.Context, d Decision)
+}
+
+// Guard 返回按规则放行 / 拦截的中间件，挂在公开路由之前。
+//
+// challenge 只作用于非 GET/HEAD 请求：token 从 X-Captcha-Token 等头读取，
+// 通过后本请求内后续的 risk.Check 直接放行。
+func (e *Engine) Guard(opts GuardOptions) gin.HandlerFunc {
+	if e == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+	deny := opts.Deny
+	if deny == nil {
+		deny = defaultDeny(opts.Captcha)
+	}
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+		addr, _ := netip.ParseAddr(ip)
+		var hash func() string
+		if opts.Hash != nil {
+			hash = func() string { return opts.Hash(ip) }
+		}
+		d := e.Evaluate(c.Request.Context(), opts.Scope, addr, hash)
+		switch d.Action {
+		case ActionBlock:
+			log.Printf("[risk/rules] %s: block %s by %s", opts.Scope, ip, d.Rule.ID)
+			deny(c, d)
+			c.Abort()
+			return
+		case ActionChallenge:
+			if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+				break
+			}
+			if opts.Captcha == nil || !opts.Captcha.Enabled() {
+				d.Action = ActionBlock
+				deny(c, d)
+				c.Abort()
+				return
+			}
+			ok, err := risk.Check(c, opts.Captcha, "")
+			if err != nil {
+				log.Printf("[risk/rules] %s: %v", opts.Scope, err)
+			}
+			if !ok {
+				deny(c, d)
+				c.Abort()
+				return
+			}
+			c.Set(risk.ContextKeyCaptchaPassed, true)
+		}
+		c.Next()
+	}
+}
+
+func defaultDeny(v risk.Verifier) func(*gin.Context, Decision) {
+	return func(c *gin.Context, d Decision) {
+		if d.Action == ActionChallenge {
+			c.JSON(http.StatusForbidden, gin.H{"code": http.StatusForbidden, "message": "captcha verification required",
+				"data": gin.H{"needsCaptcha": true, "captchaProvider": v.Provider()}})
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{"code": http.StatusForbidden, "message": "forbidden", "data": nil})
+	}
+}
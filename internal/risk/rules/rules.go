@@ -0,0 +1,518 @@
+// Package rules 是各模块共用的风控规则：按 IP、CIDR、网段分组或模块存库的
+// ip_hash 拦截（block）、要求人机验证（challenge）或放行（allow），可带过期时间。
+//
+// 规则存在一个共享的 SQLite 库里，后台改动立即生效，其他副本最多 10 秒后
+// 生效。Guard 中间件挂在各模块公开路由之前。
+package rules
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+var (
+	ErrNotFound = errors.New("rules: not found")
+	ErrInvalid  = errors.New("rules: invalid rule")
+)
+
+// 规则动作。同时命中多条时 allow 优先，其次 block，最后 challenge。
+const (
+	ActionAllow     = "allow"
+	ActionBlock     = "block"
+	ActionChallenge = "challenge"
+)
+
+// 规则类型。
+const (
+	KindIP     = "ip"      // 单个 IP
+	KindCIDR   = "cidr"    // 网段
+	KindGroup  = "group"   // 引用一个 Group（类似 ASN，一组网段）
+	KindIPHash = "ip_hash" // 模块存库的 IP 哈希，必须指定 Scope
+)
+
+// Rule 是一条规则。Scope 为空表示对所有模块生效，否则只对该模块生效。
+type Rule struct {
+	ID        string     `json:"id"`
+	Scope     string     `json:"scope"`
+	Kind      string     `json:"kind"`
+	Value     string     `json:"value"`
+	Action    string     `json:"action"`
+	Note      string     `json:"note,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Hits      int64      `json:"hits"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// Group 是一组命名的网段，例如某个机房或云厂商的 ASN 段。
+type Group struct {
+	Name      string    `json:"name"`
+	CIDRs     []string  `json:"cidrs"`
+	Note      string    `json:"note,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Decision 是一次匹配的结果；Action 为空表示没有命中。
+type Decision struct {
+	Action string
+	Rule   *Rule
+}
+
+// reloadEvery 是从库里重新加载规则的间隔（多副本之间的同步延迟）。
+const reloadEvery = 10 * time.Second
+
+// Engine 持有规则的内存副本，并发安全。
+type Engine struct {
+	db *sql.DB
+
+	mu       sync.RWMutex
+	rules    []compiled
+	groups   map[string][]netip.Prefix
+	loadedAt time.Time
+	now      func() time.Time
+
+	hitMu sync.Mutex
+	hits  map[string]int64 // 命中计数，reload 时写回库里
+}
+
+type compiled struct {
+	Rule
+	prefix netip.Prefix // ip / cidr
+}
+
+// New 在 db 上建表并加载规则。
+func New(db *sql.DB) (*Engine, error) {
+	const ddl = `
+CREATE TABLE IF NOT EXISTS risk_rules (
+  id         TEXT PRIMARY KEY,
+  scope      TEXT NOT NULL DEFAULT '',
+  kind       TEXT NOT NULL,
+  value      TEXT NOT NULL,
+  action     TEXT NOT NULL,
+  note       TEXT NOT NULL DEFAULT '',
+  expires_at INTEGER NOT NULL DEFAULT 0,
+  hits       INTEGER NOT NULL DEFAULT 0,
+  created_at DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS risk_groups (
+  name       TEXT PRIMARY KEY,
+  cidrs      TEXT NOT NULL DEFAULT '[]',
+  note       TEXT NOT NULL DEFAULT '',
+  updated_at DATETIME NOT NULL
+);`
+	if _, err := db.Exec(ddl); err != nil {
+		return nil, err
+	}
+	e := &Engine{db: db, now: time.Now, hits: map[string]int64{}}
+	if err := e.reload(context.Background()); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Open 打开（必要时创建）path 处的规则库。
+func Open(path string) (*Engine, error) {
+	if !strings.HasPrefix(path, "file:") && path != ":memory:" {
+		_ = os.MkdirAll(filepath.Dir(path), 0o755)
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`PRAGMA busy_timeout = 5000;`); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	e, err := New(db)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+var (
+	sharedOnce   sync.Once
+	sharedEngine *Engine
+)
+
+// Shared 返回进程内各模块共用的 Engine，库路径由 RISK_SQLITE_PATH 决定
+// （默认 databases/risk/risk.db）。打不开时退回内存库并打日志。
+func Shared() *Engine {
+	sharedOnce.Do(func() {
+		path := strings.TrimSpace(os.Getenv("RISK_SQLITE_PATH"))
+		if path == "" {
+			path = "databases/risk/risk.db"
+		}
+		e, err := Open(path)
+		if err != nil {
+			log.Printf("[risk/rules] open %s: %v; rules will not persist", path, err)
+			if e, err = Open(":memory:"); err != nil {
+				log.Printf("[risk/rules] disabled: %v", err)
+				return
+			}
+		}
+		sharedEngine = e
+	})
+	return sharedEngine
+}
+
+var scopeRe = regexp.MustCompile(`^[a-z0-9_-]{0,32}$`)
+
+// normalize 校验并规范化 r 的字段。
+func normalize(r *Rule) (netip.Prefix, error) {
+	r.Scope = strings.ToLower(strings.TrimSpace(r.Scope))
+	r.Kind = strings.ToLower(strings.TrimSpace(r.Kind))
+	r.Action = strings.ToLower(strings.TrimSpace(r.Action))
+	r.Value = strings.TrimSpace(r.Value)
+	r.Note = strings.TrimSpace(r.Note)
+	if !scopeRe.MatchString(r.Scope) {
+		return netip.Prefix{}, fmt.Errorf("%w: bad scope", ErrInvalid)
+	}
+	switch r.Action {
+	case ActionAllow, ActionBlock, ActionChallenge:
+	default:
+		return netip.Prefix{}, fmt.Errorf("%w: action must be allow, block or challenge", ErrInvalid)
+	}
+	switch r.Kind {
+	case KindIP:
+		a, err := netip.ParseAddr(r.Value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("%w: bad ip", ErrInvalid)
+		}
+		a = a.Unmap()
+		r.Value = a.String()
+		return netip.PrefixFrom(a, a.BitLen()), nil
+	case KindCIDR:
+		p, err := netip.ParsePrefix(r.Value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("%w: bad cidr", ErrInvalid)
+		}
+		p = p.Masked()
+		r.Value = p.String()
+		return p, nil
+	case KindGroup:
+		if r.Value == "" {
+			return netip.Prefix{}, fmt.Errorf("%w: group name required", ErrInvalid)
+		}
+	case KindIPHash:
+		r.Value = strings.ToLower(r.Value)
+		if _, err := hex.DecodeString(r.Value); err != nil || r.Value == "" {
+			return netip.Prefix{}, fmt.Errorf("%w: ip_hash must be hex", ErrInvalid)
+		}
+		if r.Scope == "" {
+			return netip.Prefix{}, fmt.Errorf("%w: ip_hash rules need a scope", ErrInvalid)
+		}
+	default:
+		return netip.Prefix{}, fmt.Errorf("%w: kind must be ip, cidr, group or ip_hash", ErrInvalid)
+	}
+	return netip.Prefix{}, nil
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return "rr_" + hex.EncodeToString(b)
+}
+
+// Add 校验并保存一条规则，ttl>0 时到期自动失效。
+func (e *Engine) Add(ctx context.Context, r Rule, ttl time.Duration) (*Rule, error) {
+	if _, err := normalize(&r); err != nil {
+		return nil, err
+	}
+	r.ID = newID()
+	r.CreatedAt = e.now().UTC()
+	r.Hits = 0
+	r.ExpiresAt = nil
+	var exp int64
+	if ttl > 0 {
+		t := r.CreatedAt.Add(ttl)
+		r.ExpiresAt, exp = &t, t.Unix()
+	}
+	if _, err := e.db.ExecContext(ctx, `INSERT INTO risk_rules(id,scope,kind,value,action,note,expires_at,hits,created_at)
+VALUES(?,?,?,?,?,?,?,0,?)`, r.ID, r.Scope, r.Kind, r.Value, r.Action, r.Note, exp, r.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &r, e.reload(ctx)
+}
+
+// Delete 删除规则。
+func (e *Engine) Delete(ctx context.Context, id string) error {
+	res, err := e.db.ExecContext(ctx, `DELETE FROM risk_rules WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return e.reload(ctx)
+}
+
+// Get 返回一条规则。
+func (e *Engine) Get(ctx context.Context, id string) (*Rule, error) {
+	list, err := e.query(ctx, `WHERE id=?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, ErrNotFound
+	}
+	return &list[0], nil
+}
+
+// List 返回未过期的规则，按创建时间倒序。scopes 非空时只返回这些 scope 的。
+// 命中数最多滞后 10 秒。
+func (e *Engine) List(ctx context.Context, scopes ...string) ([]Rule, error) {
+	q, args := `WHERE (expires_at=0 OR expires_at>?)`, []any{e.now().Unix()}
+	if len(scopes) > 0 {
+		q += ` AND scope IN (?` + strings.Repeat(`,?`, len(scopes)-1) + `)`
+		for _, s := range scopes {
+			args = append(args, s)
+		}
+	}
+	return e.query(ctx, q+` ORDER BY created_at DESC`, args...)
+}
+
+func (e *Engine) query(ctx context.Context, where string, args ...any) ([]Rule, error) {
+	rows, err := e.db.QueryContext(ctx, `SELECT id,scope,kind,value,action,note,expires_at,hits,created_at FROM risk_rules `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Rule{}
+	for rows.Next() {
+		var r Rule
+		var exp int64
+		if err := rows.Scan(&r.ID, &r.Scope, &r.Kind, &r.Value, &r.Action, &r.Note, &exp, &r.Hits, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		if exp > 0 {
+			t := time.Unix(exp, 0).UTC()
+			r.ExpiresAt = &t
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// Groups 返回全部分组。
+func (e *Engine) Groups(ctx context.Context) ([]Group, error) {
+	rows, err := e.db.QueryContext(ctx, `SELECT name,cidrs,note,updated_at FROM risk_groups ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Group{}
+	for rows.Next() {
+		var g Group
+		var cidrs string
+		if err := rows.Scan(&g.Name, &cidrs, &g.Note, &g.UpdatedAt); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal([]byte(cidrs), &g.CIDRs)
+		if g.CIDRs == nil {
+			g.CIDRs = []string{}
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+// PutGroup 创建或整体替换一个分组。
+func (e *Engine) PutGroup(ctx context.Context, g Group) (*Group, error) {
+	g.Name = strings.TrimSpace(g.Name)
+	if g.Name == "" || len(g.Name) > 64 {
+		return nil, fmt.Errorf("%w: bad group name", ErrInvalid)
+	}
+	cidrs := make([]string, 0, len(g.CIDRs))
+	for _, s := range g.CIDRs {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad cidr %q", ErrInvalid, s)
+		}
+		cidrs = append(cidrs, p.Masked().String())
+	}
+	g.CIDRs = cidrs
+	g.Note = strings.TrimSpace(g.Note)
+	g.UpdatedAt = e.now().UTC()
+	b, _ := json.Marshal(g.CIDRs)
+	if _, err := e.db.ExecContext(ctx, `INSERT INTO risk_groups(name,cidrs,note,updated_at) VALUES(?,?,?,?)
+ON CONFLICT(name) DO UPDATE SET cidrs=excluded.cidrs, note=excluded.note, updated_at=excluded.updated_at`,
+		g.Name, string(b), g.Note, g.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &g, e.reload(ctx)
+}
+
+// DeleteGroup 删除分组；引用它的规则随之失效（不会删除规则）。
+func (e *Engine) DeleteGroup(ctx context.Context, name string) error {
+	res, err := e.db.ExecContext(ctx, `DELETE FROM risk_groups WHERE name=?`, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return e.reload(ctx)
+}
+
+// reload 从库里加载规则与分组，顺带清掉过期规则。
+func (e *Engine) reload(ctx context.Context) error {
+	now := e.now()
+	e.flushHits(ctx)
+	if _, err := e.db.ExecContext(ctx, `DELETE FROM risk_rules WHERE expires_at>0 AND expires_at<=?`, now.Unix()); err != nil {
+		return err
+	}
+	list, err := e.List(ctx)
+	if err != nil {
+		return err
+	}
+	groups, err := e.Groups(ctx)
+	if err != nil {
+		return err
+	}
+	rs := make([]compiled, 0, len(list))
+	for _, r := range list {
+		p, err := normalize(&r)
+		if err != nil {
+			continue
+		}
+		rs = append(rs, compiled{Rule: r, prefix: p})
+	}
+	gs := make(map[string][]netip.Prefix, len(groups))
+	for _, g := range groups {
+		for _, s := range g.CIDRs {
+			if p, err := netip.ParsePrefix(s); err == nil {
+				gs[g.Name] = append(gs[g.Name], p)
+			}
+		}
+	}
+	e.mu.Lock()
+	e.rules, e.groups, e.loadedAt = rs, gs, now
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *Engine) maybeReload(ctx context.Context) {
+	e.mu.RLock()
+	due := e.now().Sub(e.loadedAt) >= reloadEvery
+	e.mu.RUnlock()
+	if !due {
+		return
+	}
+	if err := e.reload(ctx); err != nil {
+		log.Printf("[risk/rules] reload: %v", err)
+		e.mu.Lock()
+		e.loadedAt = e.now() // 库暂时不可用时沿用旧规则，别每个请求都重试
+		e.mu.Unlock()
+	}
+}
+
+// Evaluate 按 scope 匹配 ip；hash 为模块存库用的 ip_hash（nil 表示该模块不支持）。
+// 命中的规则累加命中数。
+func (e *Engine) Evaluate(ctx context.Context, scope string, ip netip.Addr, hash func() string) Decision {
+	d := e.Match(ctx, scope, ip, hash)
+	if d.Rule != nil {
+		e.hitMu.Lock()
+		e.hits[d.Rule.ID]++
+		e.hitMu.Unlock()
+	}
+	return d
+}
+
+// Match 与 Evaluate 相同，但不计命中数（后台试算用）。
+func (e *Engine) Match(ctx context.Context, scope string, ip netip.Addr, hash func() string) Decision {
+	e.maybeReload(ctx)
+	ip = ip.Unmap()
+	now := e.now()
+	var ipHash string
+	hashed := false
+
+	e.mu.RLock()
+	var best *compiled
+	for i := range e.rules {
+		r := &e.rules[i]
+		if r.Scope != "" && r.Scope != scope {
+			continue
+		}
+		if r.ExpiresAt != nil && !now.Before(*r.ExpiresAt) {
+			continue
+		}
+		if best != nil && rank(r.Action) <= rank(best.Action) {
+			continue
+		}
+		matched := false
+		switch r.Kind {
+		case KindIP, KindCIDR:
+			matched = ip.IsValid() && r.prefix.Contains(ip)
+		case KindGroup:
+			for _, p := range e.groups[r.Value] {
+				if ip.IsValid() && p.Contains(ip) {
+					matched = true
+					break
+				}
+			}
+		case KindIPHash:
+			if hash != nil && ip.IsValid() {
+				if !hashed {
+					ipHash, hashed = strings.ToLower(hash()), true
+				}
+				matched = ipHash != "" && ipHash == r.Value
+			}
+		}
+		if matched {
+			best = r
+		}
+	}
+	e.mu.RUnlock()
+
+	if best == nil {
+		return Decision{}
+	}
+	r := best.Rule
+	return Decision{Action: r.Action, Rule: &r}
+}
+
+// flushHits 把内存里的命中计数累加进库。
+func (e *Engine) flushHits(ctx context.Context) {
+	e.hitMu.Lock()
+	pending := e.hits
+	e.hits = map[string]int64{}
+	e.hitMu.Unlock()
+	for id, n := range pending {
+		if _, err := e.db.ExecContext(ctx, `UPDATE risk_rules SET hits=hits+? WHERE id=?`, n, id); err != nil {
+			log.Printf("[risk/rules] hits: %v", err)
+			return
+		}
+	}
+}
+
+func rank(action string) int {
+	switch action {
+	case ActionAllow:
+		return 3
+	case ActionBlock:
+		return 2
+	case ActionChallenge:
+		return 1
+	}
+	return 0
+}
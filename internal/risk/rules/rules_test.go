@@ -0,0 +1,145 @@
+package rules
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"backend-go/internal/risk"
+
+	"github.com/gin-gonic/gin"
+)
+
+func openTest(t *testing.T) *Engine {
+	t.Helper()
+	e, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func mustAdd(t *testing.T, e *Engine, r Rule, ttl time.Duration) *Rule {
+	t.Helper()
+	out, err := e.Add(context.Background(), r, ttl)
+	if err != nil {
+		t.Fatalf("add %+v: %v", r, err)
+	}
+	return out
+}
+
+func TestEvaluate(t *testing.T) {
+	ctx := context.Background()
+	e := openTest(t)
+	now := time.Unix(1_700_000_000, 0)
+	e.now = func() time.Time { return now }
+
+	mustAdd(t, e, Rule{Kind: KindCIDR, Value: "203.0.113.0/24", Action: ActionBlock}, 0)
+	mustAdd(t, e, Rule{Kind: KindIP, Value: "203.0.113.7", Action: ActionAllow}, 0)
+	mustAdd(t, e, Rule{Scope: "comments", Kind: KindIP, Value: "198.51.100.1", Action: ActionChallenge}, time.Minute)
+	mustAdd(t, e, Rule{Kind: KindGroup, Value: "hosting", Action: ActionChallenge}, 0)
+	mustAdd(t, e, Rule{Scope: "roundnfc", Kind: KindIPHash, Value: "ABCD", Action: ActionBlock}, 0)
+	if _, err := e.PutGroup(ctx, Group{Name: "hosting", CIDRs: []string{"192.0.2.0/25", "2001:db8::/32"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	eval := func(scope, ip string, hash string) string {
+		var h func() string
+		if hash != "" {
+			h = func() string { return hash }
+		}
+		return e.Evaluate(ctx, scope, netip.MustParseAddr(ip), h).Action
+	}
+	cases := []struct {
+		scope, ip, hash, want string
+	}{
+		{"roundnfc", "203.0.113.9", "", ActionBlock},
+		{"roundnfc", "203.0.113.7", "", ActionAllow},
+		{"roundnfc", "::ffff:203.0.113.9", "", ActionBlock},
+		{"comments", "198.51.100.1", "", ActionChallenge},
+		{"roundnfc", "198.51.100.1", "", ""},
+		{"redirect", "192.0.2.10", "", ActionChallenge},
+		{"redirect", "2001:db8::5", "", ActionChallenge},
+		{"roundnfc", "192.0.2.200", "", ""},
+		{"roundnfc", "10.0.0.1", "abcd", ActionBlock},
+		{"comments", "10.0.0.1", "abcd", ""},
+	}
+	for _, tc := range cases {
+		if got := eval(tc.scope, tc.ip, tc.hash); got != tc.want {
+			t.Errorf("%s %s: got %q, want %q", tc.scope, tc.ip, got, tc.want)
+		}
+	}
+
+	now = now.Add(2 * time.Minute)
+	if got := eval("comments", "198.51.100.1", ""); got != "" {
+		t.Fatalf("expired rule still matches: %q", got)
+	}
+
+	for _, bad := range []Rule{
+		{Kind: KindIP, Value: "nope", Action: ActionBlock},
+		{Kind: KindCIDR, Value: "10.0.0.0/33", Action: ActionBlock},
+		{Kind: KindIPHash, Value: "abcd", Action: ActionBlock},
+		{Kind: KindIP, Value: "10.0.0.1", Action: "drop"},
+	} {
+		if _, err := e.Add(ctx, bad, 0); err == nil {
+			t.Errorf("accepted %+v", bad)
+		}
+	}
+}
+
+type fakeCaptcha struct{ calls int }
+
+func (f *fakeCaptcha) Provider() string { return "fake" }
+func (f *fakeCaptcha) Enabled() bool    { return true }
+func (f *fakeCaptcha) Verify(_ context.Context, token, _ string) (risk.Result, error) {
+	f.calls++
+	return risk.Result{Success: token == "ok"}, nil
+}
+
+func TestGuard(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	e := openTest(t)
+	mustAdd(t, e, Rule{Kind: KindIP, Value: "192.0.2.1", Action: ActionBlock}, 0)
+	mustAdd(t, e, Rule{Kind: KindIP, Value: "192.0.2.2", Action: ActionChallenge}, 0)
+
+	captcha := &fakeCaptcha{}
+	r := gin.New()
+	r.Use(e.Guard(GuardOptions{Scope: "x", Captcha: captcha}))
+	handler := func(c *gin.Context) {
+		// a handler's own captcha check must not re-verify the same token
+		if ok, _ := risk.Check(c, captcha, ""); !ok {
+			c.Status(http.StatusTeapot)
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.POST("/", handler)
+
+	do := func(method, ip, token string) int {
+		req := httptest.NewRequest(method, "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		if token != "" {
+			req.Header.Set("X-Captcha-Token", token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	if got := do(http.MethodGet, "192.0.2.1", ""); got != http.StatusForbidden {
+		t.Fatalf("blocked GET: %d", got)
+	}
+	if got := do(http.MethodGet, "192.0.2.2", ""); got != http.StatusOK {
+		t.Fatalf("challenge should not apply to GET: %d", got)
+	}
+	if got := do(http.MethodPost, "192.0.2.2", ""); got != http.StatusForbidden {
+		t.Fatalf("challenge without token: %d", got)
+	}
+	captcha.calls = 0
+	if got := do(http.MethodPost, "192.0.2.2", "ok"); got != http.StatusOK || captcha.calls != 1 {
+		t.Fatalf("challenge passed: %d, verify calls %d", got, captcha.calls)
+	}
+}
@@ -0,0 +1,256 @@
+package spam
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxTokens 限制一条文本参与训练 / 打分的去重 token 数。
+const maxTokens = 300
+
+// Train 用一条记录训练分类器，ref 是记录的唯一标识（如 "comment:<id>"）。
+// 同一条记录重复训练为同一类是空操作；改判为另一类时先撤销原来的计数，
+// 所以后台来回改状态不会把样本算两次。
+func (f *Filter) Train(ctx context.Context, ref, text string, spam bool) error {
+	if f == nil {
+		return nil
+	}
+	tx, err := f.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	prevSpam, prevTokens, found, err := trained(ctx, tx, ref)
+	if err != nil {
+		return err
+	}
+	if found {
+		if prevSpam == spam {
+			return nil
+		}
+		if err := count(ctx, tx, prevTokens, prevSpam, -1); err != nil {
+			return err
+		}
+	}
+	toks := tokenize(text)
+	if err := count(ctx, tx, toks, spam, 1); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO spam_trained(ref, spam, tokens, updated_at) VALUES(?,?,?,?)
+ON CONFLICT(ref) DO UPDATE SET spam=excluded.spam, tokens=excluded.tokens, updated_at=excluded.updated_at`,
+		ref, boolInt(spam), strings.Join(toks, "\n"), f.now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Forget 撤销一条记录的训练（例如后台把它改回待审）。
+func (f *Filter) Forget(ctx context.Context, ref string) error {
+	if f == nil {
+		return nil
+	}
+	tx, err := f.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	prevSpam, prevTokens, found, err := trained(ctx, tx, ref)
+	if err != nil || !found {
+		return err
+	}
+	if err := count(ctx, tx, prevTokens, prevSpam, -1); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM spam_trained WHERE ref=?`, ref); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Stats 是分类器的样本数。
+type Stats struct {
+	Spam   int  `json:"spam"`
+	Ham    int  `json:"ham"`
+	Tokens int  `json:"tokens"`
+	Ready  bool `json:"ready"`
+}
+
+// Stats 返回已训练的样本数；Ready 表示样本足够、已参与打分。
+func (f *Filter) Stats(ctx context.Context) (Stats, error) {
+	var st Stats
+	if f == nil {
+		return st, nil
+	}
+	if err := f.db.QueryRowContext(ctx,
+		`SELECT coalesce(sum(spam),0), count(1)-coalesce(sum(spam),0) FROM spam_trained`).Scan(&st.Spam, &st.Ham); err != nil {
+		return st, err
+	}
+	if err := f.db.QueryRowContext(ctx, `SELECT count(1) FROM spam_tokens`).Scan(&st.Tokens); err != nil {
+		return st, err
+	}
+	st.Ready = st.Spam >= f.cfg.MinTrained && st.Ham >= f.cfg.MinTrained && st.Spam > 0 && st.Ham > 0
+	return st, nil
+}
+
+// classify 返回 text 是垃圾的概率；样本不足或没有认识的 token 时返回 0。
+//
+// 按 token 是否出现计数（每条样本每个 token 只算一次），拉普拉斯平滑，
+// 两类先验取相等，避免「拒绝得多」就把所有新提交往垃圾那边推。
+func (f *Filter) classify(ctx context.Context, text string) (float64, error) {
+	st, err := f.Stats(ctx)
+	if err != nil || !st.Ready {
+		return 0, err
+	}
+	toks := tokenize(text)
+	if len(toks) == 0 {
+		return 0, nil
+	}
+	args := make([]any, len(toks))
+	for i, t := range toks {
+		args[i] = t
+	}
+	rows, err := f.db.QueryContext(ctx,
+		`SELECT spam, ham FROM spam_tokens WHERE token IN (?`+strings.Repeat(",?", len(toks)-1)+`)`, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var logOdds float64
+	known := 0
+	for rows.Next() {
+		var s, h int
+		if err := rows.Scan(&s, &h); err != nil {
+			return 0, err
+		}
+		if s+h == 0 {
+			continue
+		}
+		known++
+		ps := (float64(s) + 1) / (float64(st.Spam) + 2)
+		ph := (float64(h) + 1) / (float64(st.Ham) + 2)
+		logOdds += math.Log(ps) - math.Log(ph)
+	}
+	if err := rows.Err(); err != nil || known == 0 {
+		return 0, err
+	}
+	return 1 / (1 + math.Exp(-logOdds)), nil
+}
+
+func trained(ctx context.Context, tx *sql.Tx, ref string) (spam bool, toks []string, found bool, err error) {
+	var s int
+	var joined string
+	err = tx.QueryRowContext(ctx, `SELECT spam, tokens FROM spam_trained WHERE ref=?`, ref).Scan(&s, &joined)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil, false, nil
+	}
+	if err != nil {
+		return false, nil, false, err
+	}
+	if joined != "" {
+		toks = strings.Split(joined, "\n")
+	}
+	return s == 1, toks, true, nil
+}
+
+func count(ctx context.Context, tx *sql.Tx, toks []string, spam bool, delta int) error {
+	col := "ham"
+	if spam {
+		col = "spam"
+	}
+	for _, t := range toks {
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO spam_tokens(token, `+col+`) VALUES(?, max(?, 0))
+ON CONFLICT(token) DO UPDATE SET `+col+`=max(`+col+`+?, 0)`, t, delta, delta); err != nil {
+			return err
+		}
+	}
+	if delta < 0 {
+		_, err := tx.ExecContext(ctx, `DELETE FROM spam_tokens WHERE spam=0 AND ham=0`)
+		return err
+	}
+	return nil
+}
+
+// tokenize 把文本切成去重的 token：拉丁文字按词，汉字等不用空格分词的文字
+// 按相邻两字，链接归一为 "url:<host>"。
+func tokenize(text string) []string {
+	seen := map[string]bool{}
+	var out []string
+	add := func(t string) {
+		if t == "" || seen[t] || len(out) >= maxTokens {
+			return
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	for _, link := range linkRe.FindAllString(text, -1) {
+		add("url:" + linkHost(link))
+	}
+	text = linkRe.ReplaceAllString(strings.ToLower(text), " ")
+
+	var word []rune
+	var han []rune
+	flushWord := func() {
+		if n := len(word); n >= 2 && n <= 30 {
+			add(string(word))
+		}
+		word = word[:0]
+	}
+	flushHan := func() {
+		if len(han) == 1 {
+			add(string(han))
+		}
+		for i := 0; i+1 < len(han); i++ {
+			add(string(han[i : i+2]))
+		}
+		han = han[:0]
+	}
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flushWord()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			flushHan()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return out
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+func linkHost(link string) string {
+	l := strings.ToLower(link)
+	for _, p := range []string{"https://", "http://"} {
+		l = strings.TrimPrefix(l, p)
+	}
+	l = strings.TrimPrefix(l, "www.")
+	if i := strings.IndexAny(l, "/?#:"); i >= 0 {
+		l = l[:i]
+	}
+	if !utf8.ValidString(l) {
+		return ""
+	}
+	return l
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,294 @@
+// Package spam 给公开提交的文本（评论、RoundNFC 申请）打垃圾分：几条本地
+// 规则（链接数、屏蔽词、重复内容、同一 ip_hash 的提交频率）加一个朴素贝叶斯
+// 分类器，分类器从后台的处理动作里学习（标记 spam / 拒绝 = 垃圾，通过 = 正常）。
+//
+// 所有数据都存在模块自己的 SQLite 库里，不依赖外部服务。
+package spam
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"backend-go/internal/risk/ratelimit"
+)
+
+// Config 是一个模块的打分配置，见 ConfigFromEnv。
+type Config struct {
+	Enabled bool
+	// Threshold 是判为垃圾的分数线（0~1），达到后模块把记录放进待审 / 隔离。
+	Threshold float64
+	// MaxLinks 是不扣分的链接数上限。
+	MaxLinks int
+	// BlockedWords 命中任意一个即高分，匹配时忽略大小写。
+	BlockedWords []string
+	// RepeatWindow 内出现过相同内容（归一化后）即视为重复提交。
+	RepeatWindow time.Duration
+	// Velocity 是同一 ip_hash 的提交频率上限，超过即扣分。
+	Velocity ratelimit.Policy
+	// MinTrained 是分类器两类样本各自至少要有的条数，不足时不参与打分。
+	MinTrained int
+}
+
+// 各条规则的分值，多条命中时按 1-Π(1-w) 合并。
+const (
+	weightBlockedWord = 0.8
+	weightLinks       = 0.3 // 超出 MaxLinks 后每多一条再加 0.1，最多 0.6
+	weightRepeat      = 0.4 // 窗口内出现三次以上为 0.6
+	weightVelocity    = 0.4
+)
+
+// minRepeatRunes 以下的短文本（「好」「+1」）不做重复判断。
+const minRepeatRunes = 8
+
+// ConfigFromEnv 读取 <PREFIX>_SPAM_*：
+//
+//	_SPAM_ENABLED            默认 true
+//	_SPAM_THRESHOLD          默认 0.7
+//	_SPAM_MAX_LINKS          默认 2
+//	_SPAM_BLOCKED_WORDS      逗号分隔
+//	_SPAM_BLOCKED_WORDS_FILE 每行一个，# 开头为注释
+//	_SPAM_REPEAT_WINDOW      默认 24h
+//	_SPAM_VELOCITY           同一 ip_hash 的提交频率，默认 5/10m，off 关闭
+//	_SPAM_MIN_TRAINED        默认 10
+func ConfigFromEnv(prefix string) Config {
+	get := func(key string) string { return strings.TrimSpace(os.Getenv(prefix + "_SPAM_" + key)) }
+	cfg := Config{
+		Enabled:      !strings.EqualFold(get("ENABLED"), "false"),
+		Threshold:    0.7,
+		MaxLinks:     2,
+		RepeatWindow: 24 * time.Hour,
+		MinTrained:   10,
+	}
+	if v, err := strconv.ParseFloat(get("THRESHOLD"), 64); err == nil && v > 0 {
+		cfg.Threshold = v
+	}
+	if v, err := strconv.Atoi(get("MAX_LINKS")); err == nil && v >= 0 {
+		cfg.MaxLinks = v
+	}
+	if v, err := time.ParseDuration(get("REPEAT_WINDOW")); err == nil {
+		cfg.RepeatWindow = v
+	}
+	if v, err := strconv.Atoi(get("MIN_TRAINED")); err == nil && v >= 0 {
+		cfg.MinTrained = v
+	}
+	velocity := get("VELOCITY")
+	if velocity == "" {
+		velocity = "5/10m"
+	}
+	p, err := ratelimit.ParsePolicy("spam-velocity", velocity)
+	if err != nil {
+		log.Printf("[risk/spam] %s_SPAM_VELOCITY: %v, using 5/10m", prefix, err)
+		p, _ = ratelimit.ParsePolicy("spam-velocity", "5/10m")
+	}
+	cfg.Velocity = p
+
+	for _, w := range strings.Split(get("BLOCKED_WORDS"), ",") {
+		cfg.BlockedWords = appendWord(cfg.BlockedWords, w)
+	}
+	if path := get("BLOCKED_WORDS_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Printf("[risk/spam] %s_SPAM_BLOCKED_WORDS_FILE: %v", prefix, err)
+		} else {
+			sc := bufio.NewScanner(f)
+			for sc.Scan() {
+				if line := strings.TrimSpace(sc.Text()); !strings.HasPrefix(line, "#") {
+					cfg.BlockedWords = appendWord(cfg.BlockedWords, line)
+				}
+			}
+			_ = f.Close()
+		}
+	}
+	return cfg
+}
+
+func appendWord(words []string, w string) []string {
+	if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+		words = append(words, w)
+	}
+	return words
+}
+
+// Submission 是一次待打分的提交。
+type Submission struct {
+	Text   string
+	IPHash string
+}
+
+// Result 是打分结果。Reasons 记录命中的规则，便于后台解释为什么被拦。
+type Result struct {
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons,omitempty"`
+	Spam    bool     `json:"spam"`
+}
+
+// Filter 是一个模块的打分器，并发安全。nil 或未启用时所有提交都是 0 分。
+type Filter struct {
+	db  *sql.DB
+	cfg Config
+	now func() time.Time
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+// New 在 db 上建表。db 一般就是模块自己的库。
+func New(db *sql.DB, cfg Config) (*Filter, error) {
+	const ddl = `
+CREATE TABLE IF NOT EXISTS spam_events (
+  content_hash TEXT NOT NULL,
+  ip_hash      TEXT NOT NULL DEFAULT '',
+  created_at   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_spam_events_content ON spam_events(content_hash, created_at);
+CREATE INDEX IF NOT EXISTS idx_spam_events_ip ON spam_events(ip_hash, created_at);
+CREATE TABLE IF NOT EXISTS spam_tokens (
+  token TEXT PRIMARY KEY,
+  spam  INTEGER NOT NULL DEFAULT 0,
+  ham   INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS spam_trained (
+  ref        TEXT PRIMARY KEY,
+  spam       INTEGER NOT NULL,
+  tokens     TEXT NOT NULL,
+  updated_at DATETIME NOT NULL
+);`
+	if _, err := db.Exec(ddl); err != nil {
+		return nil, err
+	}
+	return &Filter{db: db, cfg: cfg, now: time.Now}, nil
+}
+
+// Enabled 表示是否会打分。
+func (f *Filter) Enabled() bool { return f != nil && f.cfg.Enabled }
+
+// Check 给一次提交打分，并把它记入重复 / 频率统计。出错时返回已算出的部分
+// 结果和错误，调用方一般记日志后按结果继续。
+func (f *Filter) Check(ctx context.Context, s Submission) (Result, error) {
+	if !f.Enabled() {
+		return Result{}, nil
+	}
+	var (
+		res   Result
+		rules []float64
+	)
+	hit := func(w float64, reason string) {
+		rules = append(rules, w)
+		res.Reasons = append(res.Reasons, reason)
+	}
+
+	lower := strings.ToLower(s.Text)
+	for _, w := range f.cfg.BlockedWords {
+		if strings.Contains(lower, w) {
+			hit(weightBlockedWord, "blocked_word:"+w)
+			break
+		}
+	}
+	if n := len(linkRe.FindAllString(s.Text, -1)); n > f.cfg.MaxLinks {
+		hit(math.Min(weightLinks+0.1*float64(n-f.cfg.MaxLinks-1), 0.6), fmt.Sprintf("links:%d", n))
+	}
+
+	now := f.now()
+	norm := normalize(s.Text)
+	contentHash := ""
+	if len([]rune(norm)) >= minRepeatRunes {
+		sum := sha256.Sum256([]byte(norm))
+		contentHash = hex.EncodeToString(sum[:])
+	}
+	f.prune(ctx, now)
+	if contentHash != "" && f.cfg.RepeatWindow > 0 {
+		var n int
+		if err := f.db.QueryRowContext(ctx,
+			`SELECT count(1) FROM spam_events WHERE content_hash=? AND created_at>=?`,
+			contentHash, now.Add(-f.cfg.RepeatWindow).Unix()).Scan(&n); err != nil {
+			return f.finish(res, rules, 0), err
+		}
+		switch {
+		case n >= 2:
+			hit(0.6, fmt.Sprintf("repeated:%d", n))
+		case n == 1:
+			hit(weightRepeat, "repeated:1")
+		}
+	}
+	if s.IPHash != "" && !f.cfg.Velocity.Disabled() {
+		var n int
+		if err := f.db.QueryRowContext(ctx,
+			`SELECT count(1) FROM spam_events WHERE ip_hash=? AND created_at>=?`,
+			s.IPHash, now.Add(-f.cfg.Velocity.Period).Unix()).Scan(&n); err != nil {
+			return f.finish(res, rules, 0), err
+		}
+		if n >= f.cfg.Velocity.Limit {
+			hit(weightVelocity, fmt.Sprintf("velocity:%d", n+1))
+		}
+	}
+	if _, err := f.db.ExecContext(ctx,
+		`INSERT INTO spam_events(content_hash, ip_hash, created_at) VALUES(?,?,?)`,
+		contentHash, s.IPHash, now.Unix()); err != nil {
+		return f.finish(res, rules, 0), err
+	}
+
+	p, err := f.classify(ctx, s.Text)
+	if err != nil {
+		return f.finish(res, rules, 0), err
+	}
+	if p > 0.5 {
+		res.Reasons = append(res.Reasons, fmt.Sprintf("bayes:%.2f", p))
+	}
+	return f.finish(res, rules, p), nil
+}
+
+// finish 合并规则分和分类器概率：规则之间按 1-Π(1-w)，再与分类器取大。
+func (f *Filter) finish(res Result, rules []float64, bayes float64) Result {
+	keep := 1.0
+	for _, w := range rules {
+		keep *= 1 - w
+	}
+	res.Score = math.Max(1-keep, bayes)
+	res.Score = math.Round(res.Score*1000) / 1000
+	res.Spam = res.Score >= f.cfg.Threshold
+	return res
+}
+
+// prune 每分钟最多一次删掉所有窗口都用不到的旧事件。
+func (f *Filter) prune(ctx context.Context, now time.Time) {
+	f.mu.Lock()
+	if now.Sub(f.lastPruned) < time.Minute {
+		f.mu.Unlock()
+		return
+	}
+	f.lastPruned = now
+	f.mu.Unlock()
+	keep := f.cfg.RepeatWindow
+	if f.cfg.Velocity.Period > keep {
+		keep = f.cfg.Velocity.Period
+	}
+	if _, err := f.db.ExecContext(ctx, `DELETE FROM spam_events WHERE created_at<?`, now.Add(-keep).Unix()); err != nil {
+		log.Printf("[risk/spam] prune: %v", err)
+	}
+}
+
+var linkRe = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"'）)]+`)
+
+// normalize 去掉空白和标点并转小写，用于重复内容判断。
+func normalize(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
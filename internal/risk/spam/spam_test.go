@@ -0,0 +1,164 @@
+package spam
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"backend-go/internal/risk/ratelimit"
+
+	_ "modernc.org/sqlite"
+)
+
+func openTest(t *testing.T, cfg Config) *Filter {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+	f, err := New(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func hasReason(r Result, prefix string) bool {
+	for _, s := range r.Reasons {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRules(t *testing.T) {
+	ctx := context.Background()
+	velocity, _ := ratelimit.ParsePolicy("v", "3/10m")
+	f := openTest(t, Config{
+		Enabled:      true,
+		Threshold:    0.7,
+		MaxLinks:     1,
+		BlockedWords: []string{"casino"},
+		RepeatWindow: time.Hour,
+		Velocity:     velocity,
+	})
+	now := time.Unix(1_700_000_000, 0)
+	f.now = func() time.Time { return now }
+
+	check := func(text, ip string) Result {
+		t.Helper()
+		r, err := f.Check(ctx, Submission{Text: text, IPHash: ip})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	if r := check("返图拍得很好看，谢谢老师！", "a"); r.Score != 0 || r.Spam {
+		t.Fatalf("clean text: %+v", r)
+	}
+	if r := check("Best CASINO bonus here", "b"); !r.Spam || !hasReason(r, "blocked_word:casino") {
+		t.Fatalf("blocked word: %+v", r)
+	}
+	if r := check("see https://a.example/x and www.b.example and http://c.example", "c"); !hasReason(r, "links:3") || r.Spam {
+		t.Fatalf("links: %+v", r)
+	}
+
+	// 换 IP 发同样的内容（标点、大小写不同也算）
+	if r := check("返图拍得很好看 谢谢老师", "d"); !hasReason(r, "repeated:1") {
+		t.Fatalf("repeat: %+v", r)
+	}
+	if r := check("好", "e"); hasReason(r, "repeated") {
+		t.Fatalf("short text should not count as repeat: %+v", r)
+	}
+
+	for i := 0; i < 3; i++ {
+		check(fmt.Sprintf("第 %d 条不同的留言内容", i), "f")
+	}
+	if r := check("第四条不同的留言内容", "f"); !hasReason(r, "velocity:4") {
+		t.Fatalf("velocity: %+v", r)
+	}
+	now = now.Add(11 * time.Minute)
+	if r := check("过了一会儿再留言的内容", "f"); hasReason(r, "velocity") {
+		t.Fatalf("velocity window not sliding: %+v", r)
+	}
+
+	var nilFilter *Filter
+	if r, err := nilFilter.Check(ctx, Submission{Text: "casino"}); err != nil || r.Score != 0 {
+		t.Fatalf("nil filter: %+v %v", r, err)
+	}
+}
+
+func TestBayes(t *testing.T) {
+	ctx := context.Background()
+	f := openTest(t, Config{Enabled: true, Threshold: 0.7, MaxLinks: 10, MinTrained: 3})
+
+	spam := []string{
+		"加微信领取免费代购优惠券",
+		"代购正品低价 加微信 详询",
+		"免费领取优惠券 点击链接",
+		"低价代购 免费送 加我微信",
+	}
+	ham := []string{
+		"漫展那天的返图收到啦，谢谢老师",
+		"想要一张签名，写给小明就好",
+		"老师 cos 的角色太还原了",
+		"返图什么时候可以发呀，期待",
+	}
+	for i, s := range spam {
+		if err := f.Train(ctx, fmt.Sprintf("s%d", i), s, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, s := range ham[:2] {
+		if err := f.Train(ctx, fmt.Sprintf("h%d", i), s, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p, _ := f.classify(ctx, "加微信领优惠券"); p != 0 {
+		t.Fatalf("classifier used before MinTrained: %v", p)
+	}
+	for i, s := range ham[2:] {
+		if err := f.Train(ctx, fmt.Sprintf("h%d", i+2), s, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := f.Check(ctx, Submission{Text: "加微信免费领优惠券"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Spam || !hasReason(r, "bayes:") {
+		t.Fatalf("spammy text: %+v", r)
+	}
+	if r, _ := f.Check(ctx, Submission{Text: "谢谢老师的返图"}); r.Spam {
+		t.Fatalf("ham text: %+v", r)
+	}
+
+	// 同一条记录重复训练不重复计数，改判时撤销原来的计数
+	st, _ := f.Stats(ctx)
+	if err := f.Train(ctx, "s0", spam[0], true); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := f.Stats(ctx); again != st {
+		t.Fatalf("retrain changed stats: %+v -> %+v", st, again)
+	}
+	if err := f.Train(ctx, "s0", spam[0], false); err != nil {
+		t.Fatal(err)
+	}
+	if st2, _ := f.Stats(ctx); st2.Spam != st.Spam-1 || st2.Ham != st.Ham+1 {
+		t.Fatalf("flip: %+v -> %+v", st, st2)
+	}
+	if err := f.Forget(ctx, "s0"); err != nil {
+		t.Fatal(err)
+	}
+	if st3, _ := f.Stats(ctx); st3.Spam != st.Spam-1 || st3.Ham != st.Ham {
+		t.Fatalf("forget: %+v -> %+v", st, st3)
+	}
+}
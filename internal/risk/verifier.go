@@ -0,0 +1,259 @@
+package risk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend-go/internal/auth/challenge"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Verifier 是人机验证的统一抽象：Cloudflare Turnstile、hCaptcha、reCAPTCHA
+// 走各自的 siteverify，PoW 是本地的工作量证明。
+type Verifier interface {
+	// Provider 是前端要渲染的组件："turnstile"、"hcaptcha"、"recaptcha"、"pow"、"off"。
+	Provider() string
+	// Enabled 为 false 时 Verify 总是通过（开发态、未配置 secret）。
+	Enabled() bool
+	// Verify 校验前端交上来的 token。err 只表示校验服务不可用，token 不对时
+	// 返回 Result{Success:false} 与 nil。
+	Verify(ctx context.Context, token, remoteIP string) (Result, error)
+}
+
+// Challenger 由需要服务端先下发题目的 Verifier 实现（PoW）。
+type Challenger interface {
+	Challenge(ctx context.Context) (any, error)
+}
+
+// Result 是一次校验的结果。
+type Result struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"errorCodes,omitempty"`
+	Hostname   string   `json:"hostname,omitempty"`
+	Action     string   `json:"action,omitempty"`
+	Score      float64  `json:"score,omitempty"` // 仅 reCAPTCHA v3
+}
+
+// 各家 siteverify 的默认地址。
+const (
+	TurnstileEndpoint = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+	HCaptchaEndpoint  = "https://api.hcaptcha.com/siteverify"
+	ReCAPTCHAEndpoint = "https://www.google.com/recaptcha/api/siteverify"
+)
+
+// SiteVerify 是三家共用的 siteverify 实现：POST secret + response + remoteip，
+// 读 {"success":…,"error-codes":[…]}。Endpoint 可换成本地替身。
+type SiteVerify struct {
+	Name     string // "turnstile" | "hcaptcha" | "recaptcha"
+	Endpoint string
+	Secret   string
+	SiteKey  string  // 公开给前端；hCaptcha 校验时也会带上
+	MinScore float64 // reCAPTCHA v3 分数下限，0 表示不看分数
+	Client   *http.Client
+}
+
+// NewTurnstile、NewHCaptcha、NewReCAPTCHA 用默认地址创建 SiteVerify。
+func NewTurnstile(secret string) *SiteVerify {
+	return &SiteVerify{Name: "turnstile", Endpoint: TurnstileEndpoint, Secret: secret}
+}
+
+func NewHCaptcha(secret string) *SiteVerify {
+	return &SiteVerify{Name: "hcaptcha", Endpoint: HCaptchaEndpoint, Secret: secret}
+}
+
+func NewReCAPTCHA(secret string, minScore float64) *SiteVerify {
+	return &SiteVerify{Name: "recaptcha", Endpoint: ReCAPTCHAEndpoint, Secret: secret, MinScore: minScore}
+}
+
+func (v *SiteVerify) Provider() string { return v.Name }
+
+func (v *SiteVerify) Enabled() bool { return strings.TrimSpace(v.Secret) != "" }
+
+func (v *SiteVerify) Verify(ctx context.Context, token, remoteIP string) (Result, error) {
+	if !v.Enabled() {
+		return Result{Success: true}, nil
+	}
+	if token == "" {
+		return Result{ErrorCodes: []string{"missing-input-response"}}, nil
+	}
+	form := url.Values{}
+	form.Set("secret", strings.TrimSpace(v.Secret))
+	form.Set("response", token)
+	if net.ParseIP(remoteIP) != nil {
+		form.Set("remoteip", remoteIP)
+	}
+	if v.Name == "hcaptcha" && v.SiteKey != "" {
+		form.Set("sitekey", v.SiteKey)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := v.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return Result{}, fmt.Errorf("%s siteverify: http %d", v.Name, resp.StatusCode)
+	}
+	var out struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+		Hostname   string   `json:"hostname"`
+		Action     string   `json:"action"`
+		Score      *float64 `json:"score"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return Result{}, err
+	}
+	r := Result{Success: out.Success, ErrorCodes: out.ErrorCodes, Hostname: out.Hostname, Action: out.Action}
+	if out.Score != nil {
+		r.Score = *out.Score
+		if r.Success && v.MinScore > 0 && r.Score < v.MinScore {
+			r.Success = false
+			r.ErrorCodes = append(r.ErrorCodes, "score-too-low")
+		}
+	}
+	return r, nil
+}
+
+// Off 不做任何校验。
+type Off struct{}
+
+func (Off) Provider() string { return "off" }
+
+func (Off) Enabled() bool { return false }
+
+func (Off) Verify(context.Context, string, string) (Result, error) { return Result{Success: true}, nil }
+
+// VerifierFromEnv 读 <PREFIX>_CAPTCHA_*：
+//
+//	_CAPTCHA_PROVIDER    turnstile | hcaptcha | recaptcha | pow | off
+//	                     （留空时：有 secret 就是 turnstile，否则 off）
+//	_CAPTCHA_SECRET      siteverify secret（留空沿用 <PREFIX>_TURNSTILE_SECRET）
+//	_CAPTCHA_SITE_KEY    给前端的 site key
+//	_CAPTCHA_VERIFY_URL  替换 siteverify 地址（本地替身、代理）
+//	_CAPTCHA_MIN_SCORE   reCAPTCHA v3 分数下限
+//	_CAPTCHA_POW_KEY / _CAPTCHA_POW_MAX_NUMBER / _CAPTCHA_POW_TTL_SECONDS  见 PoW
+//
+// store 记录 pow 已用过的题目，nil 时放内存（多副本部署请传共享的 SQL store）。
+func VerifierFromEnv(envPrefix string, store challenge.Store) (Verifier, error) {
+	env := func(name string) string { return strings.TrimSpace(os.Getenv(envPrefix + name)) }
+	secret := env("_CAPTCHA_SECRET")
+	if secret == "" {
+		secret = env("_TURNSTILE_SECRET")
+	}
+	provider := strings.ToLower(env("_CAPTCHA_PROVIDER"))
+	if provider == "" {
+		provider = "turnstile"
+		if secret == "" {
+			provider = "off"
+		}
+	}
+	var sv *SiteVerify
+	switch provider {
+	case "off", "none":
+		return Off{}, nil
+	case "pow":
+		n, _ := strconv.Atoi(env("_CAPTCHA_POW_MAX_NUMBER"))
+		ttl, _ := strconv.Atoi(env("_CAPTCHA_POW_TTL_SECONDS"))
+		return NewPoW(PoWConfig{
+			Key:       []byte(env("_CAPTCHA_POW_KEY")),
+			MaxNumber: n,
+			TTL:       time.Duration(ttl) * time.Second,
+			Store:     store,
+		})
+	case "turnstile":
+		sv = NewTurnstile(secret)
+	case "hcaptcha":
+		sv = NewHCaptcha(secret)
+	case "recaptcha":
+		score, _ := strconv.ParseFloat(env("_CAPTCHA_MIN_SCORE"), 64)
+		sv = NewReCAPTCHA(secret, score)
+	default:
+		return nil, fmt.Errorf("%s_CAPTCHA_PROVIDER: unknown provider %q", envPrefix, provider)
+	}
+	sv.SiteKey = env("_CAPTCHA_SITE_KEY")
+	if u := env("_CAPTCHA_VERIFY_URL"); u != "" {
+		sv.Endpoint = u
+	}
+	return sv, nil
+}
+
+// TokenHeaders 是前端可以放 token 的请求头，按顺序读取。
+var TokenHeaders = []string{"X-Captcha-Token", "CF-Turnstile-Response"}
+
+// TokenFrom 取请求里的 token：优先 body 里解析出的 bodyToken，其次 TokenHeaders。
+func TokenFrom(c *gin.Context, bodyToken string) string {
+	if t := strings.TrimSpace(bodyToken); t != "" {
+		return t
+	}
+	for _, h := range TokenHeaders {
+		if t := strings.TrimSpace(c.GetHeader(h)); t != "" {
+			return t
+		}
+	}
+	return ""
+}
+
+// ErrVerifierUnavailable 包装 Verify 返回的 err，便于 handler 统一回 502。
+var ErrVerifierUnavailable = errors.New("captcha verifier unavailable")
+
+// ContextKeyCaptchaPassed 由前置中间件（如 risk/rules 的 challenge）在本请求
+// 已通过人机验证后写入，之后的 Check 直接放行，避免同一个 token 被校验两次。
+const ContextKeyCaptchaPassed = "risk.captchaPassed"
+
+// Check 用 v 校验请求，返回 (通过, err)。err 非 nil 时是 ErrVerifierUnavailable。
+// 校验服务的超时限制在 6 秒内。
+func Check(c *gin.Context, v Verifier, bodyToken string) (bool, error) {
+	if v == nil || !v.Enabled() || c.GetBool(ContextKeyCaptchaPassed) {
+		return true, nil
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 6*time.Second)
+	defer cancel()
+	r, err := v.Verify(ctx, TokenFrom(c, bodyToken), c.ClientIP())
+	if err != nil {
+		return false, fmt.Errorf("%w: %s: %v", ErrVerifierUnavailable, v.Provider(), err)
+	}
+	return r.Success, nil
+}
+
+// ConfigHandler 返回 GET 接口：告诉前端用哪种验证、site key 是什么；
+// PoW 时顺带下发一道题。响应 {"provider":…,"siteKey":…,"challenge":…}。
+func ConfigHandler(v Verifier) gin.HandlerFunc {
+	if v == nil {
+		v = Off{}
+	}
+	return func(c *gin.Context) {
+		data := gin.H{"provider": v.Provider(), "enabled": v.Enabled()}
+		if sv, ok := v.(*SiteVerify); ok && sv.SiteKey != "" {
+			data["siteKey"] = sv.SiteKey
+		}
+		if ch, ok := v.(Challenger); ok && v.Enabled() {
+			challenge, err := ch.Challenge(c.Request.Context())
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "challenge failed", "data": nil})
+				return
+			}
+			data["challenge"] = challenge
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{"code": 0, "message": "ok", "data": data})
+	}
+}
@@ -0,0 +1,121 @@
+package risk
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend-go/internal/auth/challenge"
+)
+
+func TestSiteVerify(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		got = map[string]string{"secret": r.PostForm.Get("secret"), "response": r.PostForm.Get("response"),
+			"remoteip": r.PostForm.Get("remoteip"), "sitekey": r.PostForm.Get("sitekey")}
+		switch r.PostForm.Get("response") {
+		case "good":
+			_, _ = w.Write([]byte(`{"success":true,"score":0.9}`))
+		case "bot":
+			_, _ = w.Write([]byte(`{"success":true,"score":0.1}`))
+		default:
+			_, _ = w.Write([]byte(`{"success":false,"error-codes":["invalid-input-response"]}`))
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	h := NewHCaptcha("s3cret")
+	h.Endpoint, h.SiteKey = srv.URL, "site"
+	if r, err := h.Verify(ctx, "good", "203.0.113.7"); err != nil || !r.Success {
+		t.Fatalf("hcaptcha good: %+v %v", r, err)
+	}
+	if got["secret"] != "s3cret" || got["remoteip"] != "203.0.113.7" || got["sitekey"] != "site" {
+		t.Fatalf("form = %v", got)
+	}
+	if r, _ := h.Verify(ctx, "nope", ""); r.Success || len(r.ErrorCodes) != 1 {
+		t.Fatalf("hcaptcha bad: %+v", r)
+	}
+
+	rc := NewReCAPTCHA("k", 0.5)
+	rc.Endpoint = srv.URL
+	if r, _ := rc.Verify(ctx, "good", ""); !r.Success || r.Score != 0.9 {
+		t.Fatalf("recaptcha good: %+v", r)
+	}
+	if r, _ := rc.Verify(ctx, "bot", ""); r.Success {
+		t.Fatalf("recaptcha low score accepted: %+v", r)
+	}
+
+	if r, _ := NewTurnstile("").Verify(ctx, "", ""); !r.Success {
+		t.Fatal("disabled verifier should pass")
+	}
+	down := NewTurnstile("k")
+	down.Endpoint = srv.URL + "/missing"
+	srv.Config.Handler = http.NotFoundHandler()
+	if _, err := down.Verify(ctx, "good", ""); err == nil {
+		t.Fatal("http error not reported")
+	}
+}
+
+// solve brute-forces a PoW challenge the way the browser widget does.
+func solve(t *testing.T, ch PoWChallenge) string {
+	for n := 0; n <= ch.MaxNumber; n++ {
+		if powHash(ch.Salt, n) == ch.Challenge {
+			b, _ := json.Marshal(powSolution{Algorithm: ch.Algorithm, Challenge: ch.Challenge, Number: n, Salt: ch.Salt, Signature: ch.Signature})
+			return base64.StdEncoding.EncodeToString(b)
+		}
+	}
+	t.Fatal("challenge has no solution")
+	return ""
+}
+
+func TestPoW(t *testing.T) {
+	ctx := context.Background()
+	store := challenge.NewMemory()
+	p, err := NewPoW(PoWConfig{Key: []byte("0123456789abcdef0123"), MaxNumber: 2000, TTL: time.Minute, Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := p.Challenge(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := raw.(PoWChallenge)
+	// 下发题目不落库
+	if _, ok, _ := store.Take(ctx, "pow:"+ch.Challenge); ok {
+		t.Fatal("challenge stored on issue")
+	}
+	token := solve(t, ch)
+	if r, err := p.Verify(ctx, token, ""); err != nil || !r.Success {
+		t.Fatalf("valid solution rejected: %+v %v", r, err)
+	}
+	if r, _ := p.Verify(ctx, token, ""); r.Success {
+		t.Fatal("replayed solution accepted")
+	}
+	// 共用 store 的另一个副本也认得已用过的题
+	replica, _ := NewPoW(PoWConfig{Key: []byte("0123456789abcdef0123"), MaxNumber: 2000, Store: store})
+	if r, _ := replica.Verify(ctx, token, ""); r.Success {
+		t.Fatal("solution replayed on another replica")
+	}
+
+	raw, _ = p.Challenge(ctx)
+	ch = raw.(PoWChallenge)
+	ch.Signature = p.sign("forged")
+	if r, _ := p.Verify(ctx, solve(t, ch), ""); r.Success || r.ErrorCodes[0] != "invalid-signature" {
+		t.Fatalf("bad signature: %+v", r)
+	}
+
+	other, _ := NewPoW(PoWConfig{Key: []byte("another-key-0123456789"), MaxNumber: 2000})
+	raw, _ = p.Challenge(ctx)
+	if r, _ := other.Verify(ctx, solve(t, raw.(PoWChallenge)), ""); r.Success {
+		t.Fatal("challenge from another key accepted")
+	}
+	if r, _ := p.Verify(ctx, "not base64!", ""); r.Success {
+		t.Fatal("garbage accepted")
+	}
+}
@@ -1,48 +1,11 @@
 package roundnfc
 
 import (
-	"crypto/subtle"
-	"net/http"
-
-	"backend-go/internal/auth"
-
 	"github.com/gin-gonic/gin"
 )
 
+// adminRequired accepts an admin JWT, ROUNDNFC_ADMIN_APP_TOKEN or a scoped API
+// token. Routes behind it declare their scope with apitoken.Scope.
 func adminRequired(svc *Service) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		if validAppToken(c.GetHeader("X-App-Token"), svc.cfg.AdminAppToken) {
-			c.Set(auth.ContextKeySubject, "app-token")
-			c.Next()
-			return
-		}
-		if ok, err := verifyStoredAppToken(c.Request.Context(), svc, c.GetHeader(appTokenHeader)); err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"code": 1, "message": "token check failed"})
-			return
-		} else if ok {
-			c.Set(auth.ContextKeySubject, "app-token")
-			c.Next()
-			return
-		}
-
-		raw := auth.ExtractBearer(c.GetHeader("Authorization"))
-		if raw == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": 1, "message": "missing token"})
-			return
-		}
-		claims, err := auth.ParseToken(svc.cfg.JWTSecret, raw)
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": 1, "message": "invalid token"})
-			return
-		}
-		c.Set(auth.ContextKeySubject, claims.Subject)
-		c.Next()
-	}
-}
-
-func validAppToken(got, want string) bool {
-	if len(want) < 16 || got == "" || len(got) != len(want) {
-		return false
-	}
-	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+	return withEnvAppToken(svc, svc.tokens.Required(svc.cfg.JWTSecret, appTokenHeader, "X-App-Token"))
 }
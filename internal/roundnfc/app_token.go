@@ -2,40 +2,55 @@ package roundnfc
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/hex"
-	"log"
-	"net/http"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
 	"strings"
 
-	"backend-go/internal/auth"
+	"backend-go/internal/auth/apitoken"
 
 	"github.com/gin-gonic/gin"
 )
 
 const appTokenHeader = "X-RoundNFC-App-Token"
 
-func newAppTokenPlain() (string, error) {
-	var b [32]byte
-	if _, err := rand.Read(b[:]); err != nil {
-		return "", err
-	}
-	return "rnfca_" + base64.RawURLEncoding.EncodeToString(b[:]), nil
-}
+// Scopes accepted by RoundNFC API tokens. Each admin/app route declares one.
+const (
+	scopeBadgesRead       = "roundnfc:badges:read"
+	scopeBadgesWrite      = "roundnfc:badges:write"
+	scopeBadgesDelete     = "roundnfc:badges:delete"
+	scopeStylesRead       = "roundnfc:styles:read"
+	scopeStylesWrite      = "roundnfc:styles:write"
+	scopeSocialLinksRead  = "roundnfc:social-links:read"
+	scopeSocialLinksWrite = "roundnfc:social-links:write"
+	scopeUploadsCreate    = "roundnfc:uploads:create"
+	scopeObjectsRead      = "roundnfc:objects:read"
+	scopeNFCWritesCreate  = "roundnfc:nfc-writes:create"
+	scopeRequestsRead     = "roundnfc:requests:read"
+	scopeRequestsWrite    = "roundnfc:requests:write"
+)
 
-func appTokenHash(token string) string {
-	sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
-	return hex.EncodeToString(sum[:])
+var allScopes = []string{
+	scopeBadgesRead, scopeBadgesWrite, scopeBadgesDelete,
+	scopeStylesRead, scopeStylesWrite,
+	scopeSocialLinksRead, scopeSocialLinksWrite,
+	scopeUploadsCreate, scopeObjectsRead, scopeNFCWritesCreate,
+	scopeRequestsRead, scopeRequestsWrite,
 }
 
-func appTokenPrefix(token string) string {
-	token = strings.TrimSpace(token)
-	if len(token) <= 12 {
-		return token
+// defaultScopes is what a paired token gets when the admin doesn't pick scopes:
+// the Android writer can edit badges and record writes but not delete anything;
+// a frontend secret (shipped inside a public page) can only mint one-shot image URLs.
+func defaultScopes(purpose string) []string {
+	if purpose == "frontend" {
+		return []string{scopeObjectsRead}
 	}
-	return token[:12]
+	return []string{scopeBadgesRead, scopeBadgesWrite, scopeStylesRead, scopeUploadsCreate, scopeObjectsRead,
+		scopeNFCWritesCreate}
+}
+
+func newTokenService(db *sql.DB) (*apitoken.Service, error) {
+	return apitoken.New(db, apitoken.Options{Table: "api_tokens", Prefix: "rnfca_", Scopes: allScopes})
 }
 
 func normalizeAppTokenPurpose(purpose string) string {
@@ -47,35 +62,86 @@ func normalizeAppTokenPurpose(purpose string) string {
 	}
 }
 
-func verifyStoredAppToken(ctx context.Context, svc *Service, token string) (bool, error) {
-	token = strings.TrimSpace(token)
-	if token == "" {
-		return false, nil
+// migrateAppTokens moves rows from the pre-scope app_tokens table into the
+// shared token service and drops the old table. Existing tokens keep working
+// for what their purpose was meant for, but lose the blanket admin access.
+func migrateAppTokens(ctx context.Context, db *sql.DB, tokens *apitoken.Service) error {
+	var name string
+	err := db.QueryRowContext(ctx, `SELECT name FROM sqlite_master WHERE type='table' AND name='app_tokens'`).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
 	}
-	return svc.store.VerifyAppToken(ctx, appTokenHash(token))
-}
-
-func appTokenRequired(svc *Service) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		raw := strings.TrimSpace(c.GetHeader(appTokenHeader))
-		if raw == "" {
-			raw = strings.TrimSpace(c.GetHeader("X-App-Token"))
+	if err != nil {
+		return err
+	}
+	rows, err := db.QueryContext(ctx, `
+SELECT id,name,purpose,token_hash,token_prefix,enabled,last_used_at,created_at,updated_at FROM app_tokens`)
+	if err != nil {
+		return err
+	}
+	type legacy struct {
+		t    apitoken.Token
+		hash string
+	}
+	var items []legacy
+	for rows.Next() {
+		var l legacy
+		var enabled int
+		var last sql.NullTime
+		if err := rows.Scan(&l.t.ID, &l.t.Name, &l.t.Purpose, &l.hash, &l.t.TokenPrefix, &enabled, &last,
+			&l.t.CreatedAt, &l.t.UpdatedAt); err != nil {
+			rows.Close()
+			return err
 		}
-		ok, err := verifyStoredAppToken(c.Request.Context(), svc, raw)
-		if err != nil {
-			log.Printf("[roundnfc/app-auth] token check failed ip=%s err=%v", c.ClientIP(), err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"code": 1, "message": "token check failed"})
-			return
+		l.t.Enabled = enabled == 1
+		if last.Valid {
+			l.t.LastUsedAt = &last.Time
 		}
-		if !ok && validAppToken(raw, svc.cfg.AdminAppToken) {
-			ok = true
+		items = append(items, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, l := range items {
+		l.t.Purpose = normalizeAppTokenPurpose(l.t.Purpose)
+		l.t.Scopes = defaultScopes(l.t.Purpose)
+		if err := tokens.Import(ctx, &l.t, l.hash); err != nil {
+			return err
 		}
-		if !ok {
-			log.Printf("[roundnfc/app-auth] invalid token ip=%s ua=%q", c.ClientIP(), c.GetHeader("User-Agent"))
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": 1, "message": "invalid app token"})
-			return
+	}
+	_, err = db.ExecContext(ctx, `DROP TABLE app_tokens`)
+	return err
+}
+
+// envAppToken is the operator-configured ROUNDNFC_ADMIN_APP_TOKEN. It predates
+// scoped tokens and keeps full RoundNFC access.
+func envAppToken() *apitoken.Token {
+	return &apitoken.Token{ID: "env", Name: "ROUNDNFC_ADMIN_APP_TOKEN", Scopes: []string{"roundnfc:*"}, Enabled: true}
+}
+
+// withEnvAppToken marks the request as token-authenticated when it carries
+// ROUNDNFC_ADMIN_APP_TOKEN, then hands over to next.
+func withEnvAppToken(svc *Service, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, h := range []string{"X-App-Token", appTokenHeader} {
+			if validAppToken(c.GetHeader(h), svc.cfg.AdminAppToken) {
+				apitoken.SetContext(c, envAppToken())
+				break
+			}
 		}
-		c.Set(auth.ContextKeySubject, "app-token")
-		c.Next()
+		next(c)
+	}
+}
+
+// appTokenRequired guards the /app routes: API tokens only, no admin JWT.
+func appTokenRequired(svc *Service) gin.HandlerFunc {
+	return withEnvAppToken(svc, svc.tokens.Required(nil, appTokenHeader, "X-App-Token"))
+}
+
+func validAppToken(got, want string) bool {
+	if len(want) < 16 || got == "" || len(got) != len(want) {
+		return false
 	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
 }
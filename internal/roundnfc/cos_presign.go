@@ -2,19 +2,16 @@ package roundnfc
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/hex"
 	"errors"
-	"fmt"
 	"log"
-	"net/url"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
 
+	"backend-go/pkg/objstore"
+
 	"github.com/google/uuid"
 )
 
@@ -38,21 +35,29 @@ type COSObjectPresign struct {
 }
 
 func (s *Service) PresignUpload(ctx context.Context, badgeID, fileName, contentType, purpose string) (UploadPresign, error) {
-	_ = ctx
 	key, err := buildUploadObjectKey(badgeID, fileName, contentType, purpose)
 	if err != nil {
 		return UploadPresign{}, err
 	}
-	u, headers, err := s.presignCOSPut(key, cosPresignTTL)
+	if s.uploads == nil {
+		return UploadPresign{}, ErrCOSNotConfigured
+	}
+	req, err := s.uploads.PresignPut(ctx, key, objstore.PutConstraints{
+		ContentType: uploadContentType(key),
+		MaxSize:     s.cfg.MaxUploadBytes,
+	}, cosPresignTTL)
 	if err != nil {
 		return UploadPresign{}, err
 	}
-	log.Printf("roundnfc cos presign badge_id=%s purpose=%s object_key=%s bucket=%s region=%s secret_id_prefix=%s expires_in=%ds", badgeID, purpose, key, s.cfg.COSBucket, s.cfg.COSRegion, shortSecretID(s.cfg.COSSecretID), int(cosPresignTTL/time.Second))
+	// registered now so that a presigned upload the app never reports back
+	// is still collected as an orphan
+	s.registerObject(ctx, bucketUploads, key, 0)
+	log.Printf("roundnfc presign upload driver=%s badge_id=%s purpose=%s object_key=%s expires_in=%ds", s.cfg.StorageDriver, badgeID, purpose, key, int(cosPresignTTL/time.Second))
 	return UploadPresign{
-		UploadURL: u,
+		UploadURL: req.URL,
 		ObjectKey: key,
-		Method:    "PUT",
-		Headers:   headers,
+		Method:    req.Method,
+		Headers:   req.Headers,
 		ExpiresIn: int(cosPresignTTL / time.Second),
 	}, nil
 }
@@ -103,11 +108,21 @@ func buildUploadObjectKey(badgeID, fileName, contentType, purpose string) (strin
 	if err != nil {
 		return "", err
 	}
-	p := strings.ToLower(strings.TrimSpace(purpose))
-	if p == "coser-photo" || p == "cn-photo" || p == "badge-coser" {
-		return path.Join("roundnfc", "coser-photos", badgeID, uuid.NewString()+ext), nil
+	return path.Join(appUploadPrefix(badgeID, purpose), uuid.NewString()+ext), nil
+}
+
+// appUploadPrefix is the key prefix of an app upload; validateAppCOSObjectKey
+// accepts everything below it.
+func appUploadPrefix(badgeID, purpose string) string {
+	if isCoserPhotoPurpose(purpose) {
+		return path.Join("roundnfc", "coser-photos", badgeID)
 	}
-	return path.Join("roundnfc", "nfc-writes", badgeID, uuid.NewString()+ext), nil
+	return path.Join("roundnfc", "nfc-writes", badgeID)
+}
+
+func isCoserPhotoPurpose(purpose string) bool {
+	p := strings.ToLower(strings.TrimSpace(purpose))
+	return p == "coser-photo" || p == "cn-photo" || p == "badge-coser"
 }
 
 func uploadExt(fileName, contentType, purpose string) (string, error) {
@@ -141,6 +156,18 @@ func uploadExt(fileName, contentType, purpose string) (string, error) {
 	return "", errors.New("unsupported contentType")
 }
 
+// uploadContentType is the Content-Type a presigned upload must carry; it
+// follows the extension buildUploadObjectKey picked.
+func uploadContentType(key string) string {
+	switch path.Ext(key) {
+	case ".webp":
+		return "image/webp"
+	case ".png":
+		return "image/png"
+	}
+	return "image/jpeg"
+}
+
 var unsafePathChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
 
 func safePathSegment(v string) string {
@@ -148,104 +175,3 @@ func safePathSegment(v string) string {
 	v = strings.Trim(v, `/\`)
 	return unsafePathChars.ReplaceAllString(v, "_")
 }
-
-func (s *Service) presignCOSPut(objectKey string, ttl time.Duration) (string, map[string]string, error) {
-	cfg := s.cfg
-	if cfg.COSBucket == "" || cfg.COSRegion == "" || cfg.COSSecretID == "" || cfg.COSSecretKey == "" {
-		return "", nil, ErrCOSNotConfigured
-	}
-	scheme := strings.ToLower(strings.TrimSpace(cfg.COSScheme))
-	if scheme != "http" {
-		scheme = "https"
-	}
-	now := time.Now().Unix()
-	exp := now + int64(ttl/time.Second)
-	keyTime := fmt.Sprintf("%d;%d", now, exp)
-	host := fmt.Sprintf("%s.cos.%s.myqcloud.com", cfg.COSBucket, cfg.COSRegion)
-	uri := "/" + strings.TrimLeft(objectKey, "/")
-
-	headerList := "host"
-	httpString := strings.Join([]string{
-		"put",
-		uri,
-		"",
-		"host=" + strings.ToLower(host) + "\n",
-	}, "\n")
-	httpHash := sha1Hex([]byte(httpString))
-	stringToSign := strings.Join([]string{"sha1", keyTime, httpHash, ""}, "\n")
-	signKey := hmacSHA1Hex([]byte(cfg.COSSecretKey), []byte(keyTime))
-	signature := hmacSHA1Hex([]byte(signKey), []byte(stringToSign))
-
-	uploadURL := (&url.URL{
-		Scheme: scheme,
-		Host:   host,
-		Path:   uri,
-	}).String()
-	headers := map[string]string{
-		"Authorization": strings.Join([]string{
-			"q-sign-algorithm=sha1",
-			"q-ak=" + cfg.COSSecretID,
-			"q-sign-time=" + keyTime,
-			"q-key-time=" + keyTime,
-			"q-header-list=" + headerList,
-			"q-url-param-list=",
-			"q-signature=" + signature,
-		}, "&"),
-	}
-	return uploadURL, headers, nil
-}
-
-func (s *Service) presignCOSGet(objectKey string, ttl time.Duration) (string, error) {
-	cfg := s.cfg
-	if cfg.COSBucket == "" || cfg.COSRegion == "" || cfg.COSSecretID == "" || cfg.COSSecretKey == "" {
-		return "", ErrCOSNotConfigured
-	}
-	scheme := strings.ToLower(strings.TrimSpace(cfg.COSScheme))
-	if scheme != "http" {
-		scheme = "https"
-	}
-	now := time.Now().Unix()
-	exp := now + int64(ttl/time.Second)
-	keyTime := fmt.Sprintf("%d;%d", now, exp)
-	host := fmt.Sprintf("%s.cos.%s.myqcloud.com", cfg.COSBucket, cfg.COSRegion)
-	uri := "/" + strings.TrimLeft(objectKey, "/")
-
-	headerList := "host"
-	httpString := strings.Join([]string{
-		"get",
-		uri,
-		"",
-		"host=" + strings.ToLower(host) + "\n",
-	}, "\n")
-	httpHash := sha1Hex([]byte(httpString))
-	stringToSign := strings.Join([]string{"sha1", keyTime, httpHash, ""}, "\n")
-	signKey := hmacSHA1Hex([]byte(cfg.COSSecretKey), []byte(keyTime))
-	signature := hmacSHA1Hex([]byte(signKey), []byte(stringToSign))
-
-	u := &url.URL{
-		Scheme: scheme,
-		Host:   host,
-		Path:   uri,
-	}
-	q := u.Query()
-	q.Set("q-sign-algorithm", "sha1")
-	q.Set("q-ak", cfg.COSSecretID)
-	q.Set("q-sign-time", keyTime)
-	q.Set("q-key-time", keyTime)
-	q.Set("q-header-list", headerList)
-	q.Set("q-url-param-list", "")
-	q.Set("q-signature", signature)
-	u.RawQuery = q.Encode()
-	return u.String(), nil
-}
-
-func sha1Hex(b []byte) string {
-	h := sha1.Sum(b)
-	return hex.EncodeToString(h[:])
-}
-
-func hmacSHA1Hex(key, msg []byte) string {
-	mac := hmac.New(sha1.New, key)
-	mac.Write(msg)
-	return hex.EncodeToString(mac.Sum(nil))
-}
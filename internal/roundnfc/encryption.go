@@ -0,0 +1,130 @@
+package roundnfc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"backend-go/pkg/objstore"
+)
+
+// ----- Encryption at rest -----
+//
+// With ROUNDNFC_ENCRYPTION_KEYS set, the object store and the app upload
+// store are wrapped in objstore.Encrypted: every object is sealed with its
+// own data key, wrapped by the master key ROUNDNFC_ENCRYPTION_KEY_ID names.
+// Encrypted objects are streamed through /objects/ and /cos-objects/
+// instead of redirected to the driver. Direct uploads land in plaintext
+// and are sealed when their key is reported back (checkDirectUpload).
+//
+// To rotate, append a new key, point ROUNDNFC_ENCRYPTION_KEY_ID at it and
+// run RewrapObjects until Next is empty; the same pass encrypts objects
+// stored before encryption was turned on. The old key can go afterwards.
+// Those older objects are only served while
+// ROUNDNFC_ENCRYPTION_ALLOW_PLAINTEXT is on; otherwise an object without
+// the envelope header is refused rather than sent as stored.
+
+var ErrEncryptionDisabled = errors.New("roundnfc: encryption at rest not configured")
+
+// rewrapBatch caps the objects looked at per re-wrap call.
+const rewrapBatch = 200
+
+// encryptStores wraps objects and uploads; an upload store that is the
+// object store itself shares the wrapper.
+func encryptStores(ring *objstore.Keyring, allowPlaintext bool, objects objstore.Storage, uploads uploadStore) (objstore.Storage, uploadStore) {
+	enc := objstore.NewEncrypted(objects, ring)
+	enc.SetAllowPlaintext(allowPlaintext)
+	switch {
+	case uploads == nil:
+		return enc, nil
+	case uploads == objects:
+		return enc, enc
+	}
+	encUploads := objstore.NewEncrypted(uploads, ring)
+	encUploads.SetAllowPlaintext(allowPlaintext)
+	return enc, encUploads
+}
+
+// driver returns st without the encryption wrapper.
+func driver(st objstore.Storage) objstore.Storage {
+	if e, ok := st.(*objstore.Encrypted); ok {
+		return e.Inner()
+	}
+	return st
+}
+
+// RewrapReport is the outcome of one re-wrap batch. Next is the cursor for
+// the following call, empty once every object has been looked at.
+type RewrapReport struct {
+	DryRun    bool              `json:"dryRun"`
+	KeyID     string            `json:"keyId"`
+	Scanned   int               `json:"scanned"`
+	Rewrapped int               `json:"rewrapped"`
+	Encrypted int               `json:"encrypted"`
+	Failed    int               `json:"failed"`
+	Objects   []RewrappedObject `json:"objects"`
+	Next      string            `json:"next,omitempty"`
+}
+
+// RewrappedObject is one object that moved (or, with DryRun, would move)
+// to the current key. From is empty for a plaintext object.
+type RewrappedObject struct {
+	Key   string `json:"key"`
+	From  string `json:"from,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// RewrapObjects walks registered and referenced keys after the cursor in
+// key order and moves up to limit of them to the current master key,
+// encrypting plaintext ones on the way.
+func (s *Service) RewrapObjects(ctx context.Context, after string, limit int, dryRun bool) (*RewrapReport, error) {
+	if s.encryption == nil {
+		return nil, ErrEncryptionDisabled
+	}
+	if limit <= 0 || limit > rewrapBatch {
+		limit = rewrapBatch
+	}
+	objs, err := s.store.ObjectKeysAfter(ctx, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	rep := &RewrapReport{DryRun: dryRun, KeyID: s.encryption.Current(), Objects: []RewrappedObject{}}
+	for _, o := range objs {
+		rep.Next = o.Key
+		enc, ok := s.storeFor(o.Bucket).(*objstore.Encrypted)
+		if !ok || keyPurpose(strings.TrimPrefix(o.Key, originalsPrefix+"/")) == "" {
+			continue
+		}
+		rep.Scanned++
+		var from string
+		var changed bool
+		if dryRun {
+			from, err = enc.KeyID(ctx, o.Key)
+			changed = err == nil && from != rep.KeyID
+		} else {
+			from, changed, err = enc.Rewrap(ctx, o.Key)
+		}
+		switch {
+		case errors.Is(err, objstore.ErrNotFound):
+			continue
+		case err != nil:
+			log.Printf("[roundnfc] rewrap key=%s: %v", o.Key, err)
+			rep.Failed++
+			rep.Objects = append(rep.Objects, RewrappedObject{Key: o.Key, From: from, Error: err.Error()})
+			continue
+		case !changed:
+			continue
+		}
+		if from == "" {
+			rep.Encrypted++
+		} else {
+			rep.Rewrapped++
+		}
+		rep.Objects = append(rep.Objects, RewrappedObject{Key: o.Key, From: from})
+	}
+	if len(objs) < limit {
+		rep.Next = ""
+	}
+	return rep, nil
+}
@@ -0,0 +1,126 @@
+package roundnfc
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"backend-go/pkg/objstore"
+)
+
+func TestEncryptionAtRest(t *testing.T) {
+	store, err := openStore(filepath.Join(t.TempDir(), "roundnfc.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	dir := t.TempDir()
+	local, err := objstore.NewLocal(dir, []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k1 := "k1:" + hex.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	k2 := "k2:" + hex.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	ring, err := objstore.ParseKeyring(k1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an attachment stored before encryption was turned on
+	photo := exifJPEG(t)
+	if _, err := local.Put(context.Background(), "badges/b1/old.jpg", bytes.NewReader(photo), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpsertBadge(context.Background(), &Badge{ID: "b1", ImageURL: "badges/b1/old.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	objects, _ := encryptStores(ring, false, local, nil)
+	svc := &Service{
+		cfg:        Config{ObjectTTL: time.Minute, ObjectMaxUses: 1},
+		store:      store,
+		objects:    objects,
+		encryption: ring,
+	}
+	ctx := context.Background()
+	fetch := func(key string) []byte {
+		t.Helper()
+		token, err := svc.SignObject(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc, _, redirect, err := svc.ResolveObject(ctx, token)
+		if err != nil || redirect != "" {
+			t.Fatalf("resolve %s: %q %v", key, redirect, err)
+		}
+		defer rc.Close()
+		b, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatalf("read %s: %v", key, err)
+		}
+		return b
+	}
+
+	// new uploads are sealed on disk and come back in plaintext
+	key, _, size, err := svc.IngestImage(ctx, "uploads", bytes.NewReader(photo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := os.ReadFile(filepath.Join(dir, key))
+	if bytes.HasPrefix(raw, []byte{0xFF, 0xD8}) || int64(len(raw)) <= size {
+		t.Fatalf("stored in plaintext (%d bytes for %d)", len(raw), size)
+	}
+	if got := fetch(key); int64(len(got)) != size || !bytes.HasPrefix(got, []byte{0xFF, 0xD8}) {
+		t.Fatalf("fetched %d bytes", len(got))
+	}
+
+	// the older plaintext image is refused unless plaintext reads are on
+	token, err := svc.SignObject(ctx, "badges/b1/old.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := svc.ResolveObject(ctx, token); err != objstore.ErrPlaintext {
+		t.Fatalf("plaintext served without opt-in: %v", err)
+	}
+	svc.objects, _ = encryptStores(ring, true, local, nil)
+	if !bytes.Equal(fetch("badges/b1/old.jpg"), photo) {
+		t.Fatal("plaintext not served during migration")
+	}
+	svc.objects = objects
+
+	// rotation: k2 becomes current, the backfill re-wraps the upload and
+	// encrypts the older plaintext badge image
+	if svc.encryption, err = objstore.ParseKeyring(k1+","+k2, "k2"); err != nil {
+		t.Fatal(err)
+	}
+	svc.objects, _ = encryptStores(svc.encryption, false, local, nil)
+	rep, err := svc.RewrapObjects(ctx, "", 0, true)
+	if err != nil || rep.Rewrapped != 1 || rep.Encrypted != 1 || rep.Next != "" {
+		t.Fatalf("dry run: %+v %v", rep, err)
+	}
+	if rep, err = svc.RewrapObjects(ctx, "", 0, false); err != nil || rep.Rewrapped != 1 || rep.Encrypted != 1 {
+		t.Fatalf("rewrap: %+v %v", rep, err)
+	}
+	if rep, _ = svc.RewrapObjects(ctx, "", 0, false); rep.Rewrapped+rep.Encrypted != 0 {
+		t.Fatalf("second pass: %+v", rep)
+	}
+
+	// k1 can go now
+	only2, _ := objstore.ParseKeyring(k2, "")
+	svc.objects, _ = encryptStores(only2, false, local, nil)
+	if !bytes.Equal(fetch("badges/b1/old.jpg"), photo) || len(fetch(key)) != int(size) {
+		t.Fatal("objects unreadable without the retired key")
+	}
+	if raw, _ := os.ReadFile(filepath.Join(dir, "badges/b1/old.jpg")); strings.Contains(string(raw), "GPS") {
+		t.Fatal("backfill left the old image in plaintext")
+	}
+
+	svc.encryption = nil
+	if _, err := svc.RewrapObjects(ctx, "", 0, false); err != ErrEncryptionDisabled {
+		t.Fatalf("without keys: %v", err)
+	}
+}
@@ -25,31 +25,129 @@ func defaultEnv() []byte {
 		"# Auto-generated on " + now + "\n" +
 			"# RoundNFC module config.\n\n" +
 			"ROUNDNFC_SQLITE_PATH=databases/roundnfc/roundnfc.db\n\n" +
-			"# 对象存储 (Local driver)\n" +
+			"# 对象存储：local（本地磁盘，默认）| cos（附件、徽章图等也存进下面的 COS bucket）| s3（S3 兼容，见下方 ROUNDNFC_S3_*）| oss（阿里云 OSS）\n" +
+			"ROUNDNFC_STORAGE_DRIVER=local\n" +
 			"ROUNDNFC_OBJECT_DIR=storage/roundnfc/objects\n" +
 			"ROUNDNFC_OBJECT_HMAC_KEY=" + randHex(32) + "\n" +
 			"ROUNDNFC_OBJECT_TTL_SECONDS=120\n" +
-			"ROUNDNFC_MAX_UPLOAD_MB=8\n\n" +
+			"# 对象链接能用几次：0 有效期内不限次数（默认，视频拖动、图片重载）；N 最多 N 次；1 一次性\n" +
+			"# Range 分段和 304 也算一次，一次性链接只适合整个 fetch 一次的场景\n" +
+			"ROUNDNFC_OBJECT_MAX_USES=0\n" +
+			"# 0 不限；JPEG / PNG / WebP 要在内存里去元数据，另有 64MB 的硬上限\n" +
+			"ROUNDNFC_MAX_UPLOAD_MB=8\n" +
+			"# tus 断点续传：未完成的上传在最后一次 PATCH 之后保留多久\n" +
+			"ROUNDNFC_TUS_EXPIRE_HOURS=24\n" +
+			"# 孤儿对象清理：没有记录引用、且超过宽限期的对象按间隔删除（0 关闭）\n" +
+			"ROUNDNFC_GC_GRACE_HOURS=72\n" +
+			"ROUNDNFC_GC_INTERVAL_MINUTES=60\n" +
+			"# 徽章 / 模板图的缩放变体：srcset 宽度档位、签名 URL 有效期\n" +
+			"ROUNDNFC_IMAGE_WIDTHS=320,640,960,1280\n" +
+			"ROUNDNFC_IMAGE_URL_TTL_HOURS=24\n" +
+			"# 图片入库时会去掉 EXIF / GPS 等元数据；这些用途另存一份原文件（逗号分隔：attachment,badge,style-template,coser-photo,nfc-write）\n" +
+			"ROUNDNFC_KEEP_ORIGINALS=\n" +
+			"# 对象落盘加密（AES-256-GCM 信封加密）：id:32 字节密钥（hex 或 base64），逗号分隔；留空不加密。\n" +
+			"# 轮换：追加一把新 key 并把 ROUNDNFC_ENCRYPTION_KEY_ID 指向它，再调 POST /admin/storage/rewrap 直到 next 为空，之后删掉旧 key\n" +
+			"ROUNDNFC_ENCRYPTION_KEYS=k1:" + randHex(32) + "\n" +
+			"# 加密新对象用的 key id，留空取上面最后一把\n" +
+			"ROUNDNFC_ENCRYPTION_KEY_ID=\n" +
+			"# 迁移期：按明文返回启用加密之前存的对象，rewrap 跑完后关掉（true/false）\n" +
+			"ROUNDNFC_ENCRYPTION_ALLOW_PLAINTEXT=false\n" +
+			"# 本地存储时 App 直传 URL 的前缀（例如 https://api.example.com），留空则按请求的 Host 拼\n" +
+			"ROUNDNFC_PUBLIC_BASE_URL=\n\n" +
 			"# COS direct upload (private bucket; backend signs short PUT URLs)\n" +
 			"ROUNDNFC_COS_BUCKET=\n" +
 			"ROUNDNFC_COS_REGION=\n" +
 			"ROUNDNFC_COS_SECRET_ID=\n" +
 			"ROUNDNFC_COS_SECRET_KEY=\n" +
-			"ROUNDNFC_COS_SCHEME=https\n\n" +
+			"ROUNDNFC_COS_SCHEME=https\n" +
+			"# 自定义 / 内网域名，留空即 <bucket>.cos.<region>.myqcloud.com\n" +
+			"ROUNDNFC_COS_ENDPOINT=\n\n" +
+			"# S3 兼容存储（AWS S3 / MinIO / R2），ROUNDNFC_STORAGE_DRIVER=s3 时使用\n" +
+			"# ENDPOINT 留空即 https://s3.<region>.amazonaws.com；PATH_STYLE 留空时非 AWS 默认 true\n" +
+			"ROUNDNFC_S3_ENDPOINT=\n" +
+			"ROUNDNFC_S3_REGION=us-east-1\n" +
+			"ROUNDNFC_S3_BUCKET=\n" +
+			"ROUNDNFC_S3_ACCESS_KEY=\n" +
+			"ROUNDNFC_S3_SECRET_KEY=\n" +
+			"ROUNDNFC_S3_PATH_STYLE=\n" +
+			"# 预签名 URL 用的公网地址（服务端走内网 endpoint 时填）\n" +
+			"ROUNDNFC_S3_PUBLIC_ENDPOINT=\n\n" +
+			"# 阿里云 OSS，ROUNDNFC_STORAGE_DRIVER=oss 时使用\n" +
+			"# ENDPOINT 例如 oss-cn-hangzhou.aliyuncs.com；REGION 留空时从 endpoint 推出\n" +
+			"ROUNDNFC_OSS_BUCKET=\n" +
+			"ROUNDNFC_OSS_ENDPOINT=\n" +
+			"ROUNDNFC_OSS_REGION=\n" +
+			"ROUNDNFC_OSS_ACCESS_KEY_ID=\n" +
+			"ROUNDNFC_OSS_ACCESS_KEY_SECRET=\n" +
+			"# 签名版本 v4（默认）| v1\n" +
+			"ROUNDNFC_OSS_SIGN_VERSION=v4\n" +
+			"# ENDPOINT 是绑定到 bucket 的自定义域名时设为 true\n" +
+			"ROUNDNFC_OSS_CNAME=false\n" +
+			"# 预签名 URL 用的公网地址（服务端走 -internal 内网 endpoint 时填）\n" +
+			"ROUNDNFC_OSS_PUBLIC_ENDPOINT=\n\n" +
 			"# 风控\n" +
+			"# 人机验证：turnstile | hcaptcha | recaptcha | pow（本地工作量证明，无需第三方）| off\n" +
+			"#   留空时有 secret 即 turnstile。前端先 GET /captcha 取配置（pow 时附带题目）。\n" +
+			"ROUNDNFC_CAPTCHA_PROVIDER=\n" +
+			"ROUNDNFC_CAPTCHA_SECRET=\n" +
+			"ROUNDNFC_CAPTCHA_SITE_KEY=\n" +
+			"# reCAPTCHA v3 分数下限；pow 的 HMAC key（留空则每次启动随机）\n" +
+			"ROUNDNFC_CAPTCHA_MIN_SCORE=\n" +
+			"ROUNDNFC_CAPTCHA_POW_KEY=" + randHex(32) + "\n" +
+			"# 旧配置，ROUNDNFC_CAPTCHA_SECRET 留空时沿用\n" +
 			"ROUNDNFC_TURNSTILE_SECRET=\n" +
+			"# 公开接口限流（每 IP）：默认每分钟 ROUNDNFC_RATELIMIT_PER_MIN 次。\n" +
+			"#   单个接口可覆盖，格式 <次数>/<周期>[,burst=N][,algo=gcra|bucket]，off 关闭：\n" +
+			"#   ROUNDNFC_RATELIMIT_PHOTO_REQUEST / _AUTOGRAPH_REQUEST / _UPLOAD\n" +
+			"#   多副本共享计数见 RATELIMIT_STORE=sqlite。\n" +
+			"# IP / 网段拦截规则在后台 /admin/risk 管理，所有模块共用 RISK_SQLITE_PATH（默认 databases/risk/risk.db）。\n" +
 			"ROUNDNFC_RATELIMIT_PER_MIN=12\n\n" +
+			"# 返图 / To 签申请的垃圾分达到阈值时自动隔离（quarantined）；屏蔽词逗号分隔，或用 _FILE 每行一个\n" +
+			"#   其他：ROUNDNFC_SPAM_MAX_LINKS=2 / _REPEAT_WINDOW=24h / _VELOCITY=5/10m / _MIN_TRAINED=10 / _ENABLED=false\n" +
+			"ROUNDNFC_SPAM_THRESHOLD=0.7\n" +
+			"ROUNDNFC_SPAM_BLOCKED_WORDS=\n" +
+			"ROUNDNFC_SPAM_BLOCKED_WORDS_FILE=\n\n" +
 			"# 后台账号。\n" +
 			"#   首选：直接填 _ADMIN_PASSWORD（明文），启动时会在内存里 bcrypt。\n" +
 			"#   公网部署可改填 _ADMIN_PASSWORD_HASH（用 cmd/genpw 生成），并把明文那行删掉。\n" +
 			"#   两者都设时 HASH 胜出。两者都空则后台禁用。\n" +
+			"#   首次启动后密码哈希存入数据库，之后以数据库为准；用默认口令 admin 登录会被要求先改密。\n" +
+			"#   忘记密码时把 _ADMIN_PASSWORD_RESET 设为 true 重启一次，用 env 里的口令覆盖数据库。\n" +
 			"ROUNDNFC_ADMIN_USERNAME=admin\n" +
 			"ROUNDNFC_ADMIN_PASSWORD=admin\n" +
 			"ROUNDNFC_ADMIN_PASSWORD_HASH=\n" +
-			"# Optional Android/admin app token for selected admin APIs. Keep long and random.\n" +
+			"ROUNDNFC_ADMIN_PASSWORD_RESET=false\n" +
+			"# 改密规则：最短长度、至少包含几类字符（大写/小写/数字/符号）\n" +
+			"ROUNDNFC_PASSWORD_MIN_LENGTH=10\n" +
+			"ROUNDNFC_PASSWORD_MIN_CLASSES=0\n" +
+			"# 新密码哈希算法：bcrypt 或 argon2id；登录时参数较弱的旧哈希会自动升级\n" +
+			"ROUNDNFC_PASSWORD_HASH_ALGO=bcrypt\n" +
+			"ROUNDNFC_BCRYPT_COST=\n" +
+			"ROUNDNFC_ARGON2_TIME=\n" +
+			"ROUNDNFC_ARGON2_MEMORY_KB=\n" +
+			"ROUNDNFC_ARGON2_THREADS=\n" +
+			"# Optional legacy app token with full RoundNFC scope (roundnfc:*). Keep long and random;\n" +
+			"# prefer scoped tokens created under 安全设置 / POST /admin/api-tokens.\n" +
 			"ROUNDNFC_ADMIN_APP_TOKEN=\n" +
 			"ROUNDNFC_JWT_SECRET=" + randHex(32) + "\n" +
-			"ROUNDNFC_JWT_TTL_HOURS=12\n\n" +
+			"ROUNDNFC_JWT_TTL_HOURS=12\n" +
+			"# 后台 cookie 会话：JWT 放进 HttpOnly cookie，SPA 用 X-CSRF-Token 防 CSRF。\n" +
+			"#   留空跟随 HTTP_ADMIN_SESSION_COOKIE；SAMESITE 可选 strict/lax/none，SECURE 可选 auto/true/false。\n" +
+			"ROUNDNFC_SESSION_COOKIE=\n" +
+			"ROUNDNFC_SESSION_SAMESITE=strict\n" +
+			"ROUNDNFC_SESSION_COOKIE_SECURE=auto\n" +
+			"ROUNDNFC_SESSION_COOKIE_DOMAIN=\n\n" +
+			"# 登录防爆破：前 N 次失败不限速，之后按指数退避，封顶即临时锁定。\n" +
+			"#   留空使用默认值（3 次 / 2 秒起步 / 最长锁 15 分钟 / 24 小时后清零）。\n" +
+			"ROUNDNFC_LOGIN_FREE_ATTEMPTS=\n" +
+			"ROUNDNFC_LOGIN_BASE_DELAY_SECONDS=\n" +
+			"ROUNDNFC_LOGIN_MAX_LOCKOUT_MINUTES=\n" +
+			"ROUNDNFC_LOGIN_RESET_HOURS=\n" +
+			"# 失败 N 次后要求 Turnstile（0 关闭；secret 缺省沿用 ROUNDNFC_TURNSTILE_SECRET）\n" +
+			"ROUNDNFC_LOGIN_TURNSTILE_AFTER=0\n" +
+			"ROUNDNFC_LOGIN_TURNSTILE_SECRET=\n" +
+			"# 敏感操作（关闭 TOTP、删除 passkey/徽章、创建 app token）需在 N 分钟内重新验证\n" +
+			"ROUNDNFC_STEPUP_MAX_AGE_MINUTES=5\n\n" +
 			"# TOTP (Google Authenticator)\n" +
 			"ROUNDNFC_TOTP_ISSUER=RoundNFC\n\n" +
 			"# WebAuthn / Passkey\n" +
@@ -57,7 +155,26 @@ func defaultEnv() []byte {
 			"ROUNDNFC_WEBAUTHN_RPID=localhost\n" +
 			"ROUNDNFC_WEBAUTHN_RP_NAME=RoundNFC Admin\n" +
 			"# 多个 origin 用逗号分隔\n" +
-			"ROUNDNFC_WEBAUTHN_ORIGINS=http://localhost:5174,http://localhost:8081\n",
+			"ROUNDNFC_WEBAUTHN_ORIGINS=http://localhost:5174,http://localhost:8081\n" +
+			"# 认证器策略：允许的 AAGUID（逗号分隔，留空不限制）、是否要求厂商证明、是否要求用户验证\n" +
+			"#   设置 AAGUID 白名单时必须同时开启 REQUIRE_ATTESTATION；开启 REQUIRE_ATTESTATION 时必须配置 ATTESTATION_ROOTS，否则拒绝启动\n" +
+			"ROUNDNFC_WEBAUTHN_AAGUIDS=\n" +
+			"ROUNDNFC_WEBAUTHN_REQUIRE_ATTESTATION=false\n" +
+			"ROUNDNFC_WEBAUTHN_REQUIRE_UV=false\n" +
+			"# 证明证书根 CA（PEM 文件路径，开启 REQUIRE_ATTESTATION 时必填）\n" +
+			"ROUNDNFC_WEBAUTHN_ATTESTATION_ROOTS=\n\n" +
+			"# OIDC 单点登录（可选）。ISSUER/CLIENT_ID/REDIRECT_URL 与白名单都填才启用。\n" +
+			"#   REDIRECT_URL 指向 <本服务>/api/roundnfc/admin/oidc/callback；本地调试可用 go run ./cmd/mockoidc\n" +
+			"ROUNDNFC_OIDC_ISSUER=\n" +
+			"ROUNDNFC_OIDC_CLIENT_ID=\n" +
+			"ROUNDNFC_OIDC_CLIENT_SECRET=\n" +
+			"ROUNDNFC_OIDC_REDIRECT_URL=\n" +
+			"ROUNDNFC_OIDC_SCOPES=openid,email,profile\n" +
+			"# 允许映射为管理员的 sub / 已验证邮箱（逗号分隔）\n" +
+			"ROUNDNFC_OIDC_ALLOWED_SUBJECTS=\n" +
+			"ROUNDNFC_OIDC_ALLOWED_EMAILS=\n" +
+			"# 登录完成后跳回的后台登录页，token 通过 URL fragment 传回\n" +
+			"ROUNDNFC_OIDC_POST_LOGIN_REDIRECT=\n",
 	)
 }
 
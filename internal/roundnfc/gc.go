@@ -0,0 +1,101 @@
+package roundnfc
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"backend-go/pkg/objstore"
+)
+
+// gcBatch caps the objects looked at (and listed in the report) per pass.
+const gcBatch = 1000
+
+// GCReport is the outcome of one orphan collection pass. With DryRun the
+// objects are only listed.
+type GCReport struct {
+	DryRun  bool           `json:"dryRun"`
+	Cutoff  time.Time      `json:"cutoff"`
+	Objects []StoredObject `json:"objects"`
+	Count   int            `json:"count"`
+	Bytes   int64          `json:"bytes"`
+	Deleted int            `json:"deleted"`
+	Failed  int            `json:"failed"`
+}
+
+func (s *Service) registerObject(ctx context.Context, bucket, key string, size int64) {
+	if err := s.store.RegisterObject(ctx, bucket, key, size); err != nil {
+		log.Printf("[roundnfc] register object key=%s: %v", key, err)
+	}
+}
+
+func (s *Service) storeFor(bucket string) objstore.Storage {
+	if bucket == bucketUploads && s.uploads != nil {
+		return s.uploads
+	}
+	return s.objects
+}
+
+// CollectOrphans deletes registered objects that no row references and
+// that were last written or presigned more than GCGrace ago.
+func (s *Service) CollectOrphans(ctx context.Context, dryRun bool) (*GCReport, error) {
+	if err := s.store.RebuildObjectRefs(ctx); err != nil {
+		return nil, err
+	}
+	grace := s.cfg.GCGrace
+	if grace <= 0 {
+		grace = 72 * time.Hour
+	}
+	cutoff := time.Now().Add(-grace).UTC()
+	objs, err := s.store.OrphanObjects(ctx, cutoff, gcBatch)
+	if err != nil {
+		return nil, err
+	}
+	rep := &GCReport{DryRun: dryRun, Cutoff: cutoff, Objects: make([]StoredObject, 0, len(objs))}
+	for _, o := range objs {
+		rep.Objects = append(rep.Objects, o)
+		rep.Count++
+		rep.Bytes += o.Size
+		if dryRun {
+			continue
+		}
+		// registry first: a key that gained a ref in the meantime stays
+		ok, err := s.store.forgetOrphan(ctx, o.Key, cutoff)
+		if err != nil {
+			return rep, err
+		}
+		if !ok {
+			continue
+		}
+		if err := s.storeFor(o.Bucket).Delete(ctx, o.Key); err != nil {
+			log.Printf("[roundnfc/gc] delete key=%s: %v", o.Key, err)
+			rep.Failed++
+			// back in the registry, retried after another grace period
+			s.registerObject(ctx, o.Bucket, o.Key, o.Size)
+			continue
+		}
+		rep.Deleted++
+		s.dropImageVariants(ctx, o.Key)
+	}
+	return rep, nil
+}
+
+func (s *Service) gcLoop() {
+	t := time.NewTicker(s.cfg.GCInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+		}
+		rep, err := s.CollectOrphans(context.Background(), false)
+		if err != nil {
+			log.Printf("[roundnfc/gc] %v", err)
+			continue
+		}
+		if rep.Count > 0 {
+			log.Printf("[roundnfc/gc] orphans=%d deleted=%d failed=%d bytes=%d", rep.Count, rep.Deleted, rep.Failed, rep.Bytes)
+		}
+	}
+}
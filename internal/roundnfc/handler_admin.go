@@ -6,11 +6,15 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"backend-go/internal/auth/apitoken"
+	"backend-go/internal/risk/rules"
+	"backend-go/pkg/objstore"
+
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 )
 
 type adminHandler struct {
@@ -317,7 +321,7 @@ func jsonMarshalRaw(v any) ([]byte, error) {
 }
 
 func (h *adminHandler) ListAppTokens(c *gin.Context) {
-	items, err := h.svc.store.ListAppTokens(c.Request.Context())
+	items, err := h.svc.tokens.List(c.Request.Context(), "")
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, err.Error())
 		return
@@ -325,10 +329,11 @@ func (h *adminHandler) ListAppTokens(c *gin.Context) {
 	respondData(c, gin.H{"items": items})
 }
 
+// appTokenCreatePayload is the generic token payload plus the pairing API base.
+// Scopes default to the purpose's preset when omitted.
 type appTokenCreatePayload struct {
-	Name    string `json:"name"`
+	apitoken.CreatePayload
 	ApiBase string `json:"apiBase"`
-	Purpose string `json:"purpose"`
 }
 
 func (h *adminHandler) CreateAppToken(c *gin.Context) {
@@ -342,7 +347,10 @@ func (h *adminHandler) CreateAppToken(c *gin.Context) {
 		respondError(c, http.StatusBadRequest, "name required")
 		return
 	}
-	purpose := normalizeAppTokenPurpose(p.Purpose)
+	p.Purpose = normalizeAppTokenPurpose(p.Purpose)
+	if len(p.Scopes) == 0 {
+		p.Scopes = defaultScopes(p.Purpose)
+	}
 	apiBase, err := normalizePairingAPIBase(p.ApiBase)
 	if err != nil {
 		respondError(c, http.StatusBadRequest, "invalid apiBase")
@@ -351,25 +359,18 @@ func (h *adminHandler) CreateAppToken(c *gin.Context) {
 	if apiBase == "" {
 		apiBase = inferAPIBase(c.Request)
 	}
-	plain, err := newAppTokenPlain()
+	item, plain, err := h.svc.tokens.Create(c.Request.Context(), p.Spec())
 	if err != nil {
-		respondError(c, http.StatusInternalServerError, "token generate failed")
-		return
-	}
-	item := &AppToken{
-		ID:          uuid.NewString(),
-		Name:        name,
-		Purpose:     purpose,
-		TokenPrefix: appTokenPrefix(plain),
-		Enabled:     true,
-	}
-	if err := h.svc.store.InsertAppToken(c.Request.Context(), item, appTokenHash(plain)); err != nil {
+		if errors.Is(err, apitoken.ErrBadScope) || errors.Is(err, apitoken.ErrBadCIDR) {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
 		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
-	pairing := h.appPairingConfig(name, purpose, apiBase, plain)
-	log.Printf("[roundnfc/admin] create app token id=%q name=%q purpose=%q prefix=%q apiBase=%q ip=%s",
-		item.ID, item.Name, item.Purpose, item.TokenPrefix, apiBase, c.ClientIP())
+	pairing := h.appPairingConfig(name, item.Purpose, apiBase, plain)
+	log.Printf("[roundnfc/admin] create app token id=%q name=%q purpose=%q scopes=%v prefix=%q apiBase=%q ip=%s",
+		item.ID, item.Name, item.Purpose, item.Scopes, item.TokenPrefix, apiBase, c.ClientIP())
 	respondData(c, gin.H{"item": item, "token": plain, "pairing": pairing})
 }
 
@@ -383,8 +384,8 @@ func (h *adminHandler) UpdateAppToken(c *gin.Context) {
 		respondError(c, http.StatusBadRequest, "invalid body")
 		return
 	}
-	if err := h.svc.store.SetAppTokenEnabled(c.Request.Context(), c.Param("id"), p.Enabled); err != nil {
-		if errors.Is(err, ErrNotFound) {
+	if _, err := h.svc.tokens.Update(c.Request.Context(), c.Param("id"), apitoken.Patch{Enabled: &p.Enabled}); err != nil {
+		if errors.Is(err, apitoken.ErrNotFound) {
 			respondError(c, http.StatusNotFound, "not found")
 			return
 		}
@@ -396,8 +397,8 @@ func (h *adminHandler) UpdateAppToken(c *gin.Context) {
 }
 
 func (h *adminHandler) DeleteAppToken(c *gin.Context) {
-	if err := h.svc.store.DeleteAppToken(c.Request.Context(), c.Param("id")); err != nil {
-		if errors.Is(err, ErrNotFound) {
+	if err := h.svc.tokens.Delete(c.Request.Context(), c.Param("id")); err != nil {
+		if errors.Is(err, apitoken.ErrNotFound) {
 			respondError(c, http.StatusNotFound, "not found")
 			return
 		}
@@ -552,11 +553,29 @@ func (h *adminHandler) PresignUpload(c *gin.Context) {
 		}
 		return
 	}
+	out.UploadURL = absoluteURL(c, out.UploadURL)
 	log.Printf("[roundnfc/admin] presign upload badgeId=%q purpose=%q objectKey=%q contentType=%q expiresIn=%d ip=%s",
 		p.BadgeID, p.Purpose, out.ObjectKey, p.ContentType, out.ExpiresIn, c.ClientIP())
 	respondData(c, out)
 }
 
+// absoluteURL resolves a path-only presigned URL (local driver without
+// ROUNDNFC_PUBLIC_BASE_URL) against the request, so the app can PUT to it
+// the same way it PUTs to a bucket.
+func absoluteURL(c *gin.Context, u string) string {
+	if !strings.HasPrefix(u, "/") {
+		return u
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if p := c.GetHeader("X-Forwarded-Proto"); p == "https" || p == "http" {
+		scheme = p
+	}
+	return scheme + "://" + c.Request.Host + u
+}
+
 type nfcWritePayload struct {
 	BadgeID        string `json:"badgeId"`
 	TagUID         string `json:"tagUid"`
@@ -583,6 +602,10 @@ func (h *adminHandler) CreateNFCWrite(c *gin.Context) {
 		respondError(c, http.StatusBadRequest, "photoObjectKey must be an object key")
 		return
 	}
+	if err := h.svc.checkDirectUpload(c.Request.Context(), photoKey); err != nil {
+		respondError(c, http.StatusRequestEntityTooLarge, "photo too large")
+		return
+	}
 	writtenAt := time.Now().UTC()
 	if strings.TrimSpace(p.WrittenAt) != "" {
 		t, err := time.Parse(time.RFC3339, strings.TrimSpace(p.WrittenAt))
@@ -616,7 +639,12 @@ func (h *adminHandler) UpdatePhotoStatus(c *gin.Context) {
 		respondError(c, http.StatusBadRequest, "invalid status")
 		return
 	}
-	if err := h.svc.store.UpdatePhotoStatus(c.Request.Context(), c.Param("id"), p.Status); err != nil {
+	ctx := c.Request.Context()
+	req, err := h.svc.store.GetPhotoRequest(ctx, c.Param("id"))
+	if err == nil {
+		err = h.svc.store.UpdatePhotoStatus(ctx, req.ID, p.Status)
+	}
+	if err != nil {
 		if errors.Is(err, ErrNotFound) {
 			respondError(c, http.StatusNotFound, "not found")
 			return
@@ -624,6 +652,7 @@ func (h *adminHandler) UpdatePhotoStatus(c *gin.Context) {
 		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
+	h.svc.trainSpam(ctx, "photo/"+req.ID, req.spamText(), p.Status)
 	respondData(c, gin.H{"ok": true})
 }
 
@@ -644,7 +673,12 @@ func (h *adminHandler) UpdateAutographStatus(c *gin.Context) {
 		respondError(c, http.StatusBadRequest, "invalid status")
 		return
 	}
-	if err := h.svc.store.UpdateAutographStatus(c.Request.Context(), c.Param("id"), p.Status); err != nil {
+	ctx := c.Request.Context()
+	req, err := h.svc.store.GetAutographRequest(ctx, c.Param("id"))
+	if err == nil {
+		err = h.svc.store.UpdateAutographStatus(ctx, req.ID, p.Status)
+	}
+	if err != nil {
 		if errors.Is(err, ErrNotFound) {
 			respondError(c, http.StatusNotFound, "not found")
 			return
@@ -652,12 +686,162 @@ func (h *adminHandler) UpdateAutographStatus(c *gin.Context) {
 		respondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
+	h.svc.trainSpam(ctx, "autograph/"+req.ID, req.spamText(), p.Status)
 	respondData(c, gin.H{"ok": true})
 }
 
+// SpamStats reports the spam filter state; the classifier only takes part in
+// scoring once both classes reach ROUNDNFC_SPAM_MIN_TRAINED samples.
+func (h *adminHandler) SpamStats(c *gin.Context) {
+	st, err := h.svc.spam.Stats(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondData(c, gin.H{"enabled": h.svc.spam.Enabled(), "stats": st})
+}
+
+// StorageUsage reports registered objects per key prefix.
+func (h *adminHandler) StorageUsage(c *gin.Context) {
+	usage, err := h.svc.store.ObjectUsage(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondData(c, gin.H{"items": usage})
+}
+
+// GarbageReport lists the orphans the next GC pass would delete (dry run).
+func (h *adminHandler) GarbageReport(c *gin.Context) {
+	h.collectGarbage(c, true)
+}
+
+// CollectGarbage runs a GC pass now instead of waiting for the interval.
+func (h *adminHandler) CollectGarbage(c *gin.Context) {
+	h.collectGarbage(c, false)
+}
+
+func (h *adminHandler) collectGarbage(c *gin.Context, dryRun bool) {
+	rep, err := h.svc.CollectOrphans(c.Request.Context(), dryRun)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !dryRun {
+		log.Printf("[roundnfc/admin] gc orphans=%d deleted=%d failed=%d ip=%s", rep.Count, rep.Deleted, rep.Failed, c.ClientIP())
+	}
+	respondData(c, rep)
+}
+
+// StripMetadata runs one batch of the metadata backfill. Query: after
+// (cursor, the previous response's next), limit, dryRun.
+func (h *adminHandler) StripMetadata(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	dryRun := c.Query("dryRun") == "1" || c.Query("dryRun") == "true"
+	rep, err := h.svc.StripStoredMetadata(c.Request.Context(), c.Query("after"), limit, dryRun)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !dryRun && rep.Stripped > 0 {
+		log.Printf("[roundnfc/admin] strip metadata scanned=%d stripped=%d failed=%d ip=%s", rep.Scanned, rep.Stripped, rep.Failed, c.ClientIP())
+	}
+	respondData(c, rep)
+}
+
+// RewrapObjects moves a batch of stored objects to the current encryption
+// key and encrypts plaintext ones. Query: after (cursor), limit, dryRun.
+func (h *adminHandler) RewrapObjects(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	dryRun := c.Query("dryRun") == "1" || c.Query("dryRun") == "true"
+	rep, err := h.svc.RewrapObjects(c.Request.Context(), c.Query("after"), limit, dryRun)
+	if errors.Is(err, ErrEncryptionDisabled) {
+		respondError(c, http.StatusConflict, "encryption at rest not configured")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !dryRun && rep.Rewrapped+rep.Encrypted > 0 {
+		log.Printf("[roundnfc/admin] rewrap key=%s scanned=%d rewrapped=%d encrypted=%d failed=%d ip=%s", rep.KeyID, rep.Scanned, rep.Rewrapped, rep.Encrypted, rep.Failed, c.ClientIP())
+	}
+	respondData(c, rep)
+}
+
+// RevokeObjectLink invalidates an /objects/ or /cos-objects/ link before it
+// expires. Body: {token} (the token or the whole link).
+func (h *adminHandler) RevokeObjectLink(c *gin.Context) {
+	var p struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&p); err != nil || strings.TrimSpace(p.Token) == "" {
+		respondError(c, http.StatusBadRequest, "token required")
+		return
+	}
+	if err := h.svc.RevokeObjectToken(c.Request.Context(), p.Token); err != nil {
+		switch err {
+		case objstore.ErrTokenInvalid:
+			respondError(c, http.StatusBadRequest, "invalid token")
+		case objstore.ErrTokenExpired:
+			respondError(c, http.StatusGone, "token already expired")
+		default:
+			respondError(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	respondData(c, gin.H{"revoked": true})
+}
+
+// BlockRequestSender adds a risk rule against the ip_hash stored on a photo
+// or autograph request, so the sender can be blocked (or challenged) without
+// the raw IP. Body: {action, ttlSeconds, note}; action defaults to block.
+func (h *adminHandler) BlockRequestSender(table string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var p rules.CreatePayload
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&p); err != nil {
+				respondError(c, http.StatusBadRequest, "invalid body")
+				return
+			}
+		}
+		if p.Action == "" {
+			p.Action = rules.ActionBlock
+		}
+		ctx := c.Request.Context()
+		hash, err := h.svc.store.RequestIPHash(ctx, table, c.Param("id"))
+		if errors.Is(err, ErrNotFound) {
+			respondError(c, http.StatusNotFound, "not found")
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if hash == "" {
+			respondError(c, http.StatusConflict, "request has no ip hash")
+			return
+		}
+		if p.Note == "" {
+			p.Note = table + "/" + c.Param("id")
+		}
+		r, err := h.svc.rules.Add(ctx, rules.Rule{Scope: riskScope, Kind: rules.KindIPHash, Value: hash, Action: p.Action, Note: p.Note}, p.TTL())
+		if errors.Is(err, rules.ErrInvalid) {
+			respondError(c, http.StatusBadRequest, strings.TrimPrefix(err.Error(), "rules: "))
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		log.Printf("[roundnfc] risk rule %s: %s sender of %s/%s", r.ID, r.Action, table, c.Param("id"))
+		respondData(c, gin.H{"item": r})
+	}
+}
+
 func validStatus(s string) bool {
 	switch s {
-	case StatusNew, StatusHandled, StatusRejected:
+	case StatusNew, StatusHandled, StatusRejected, StatusQuarantined:
 		return true
 	}
 	return false
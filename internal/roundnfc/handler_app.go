@@ -109,6 +109,7 @@ func (h *appHandler) PresignCoserPhoto(c *gin.Context) {
 		respondError(c, http.StatusBadRequest, err.Error())
 		return
 	}
+	out.UploadURL = absoluteURL(c, out.UploadURL)
 	log.Printf("[roundnfc/app] presign coser photo badgeId=%q objectKey=%q contentType=%q expiresIn=%d ip=%s",
 		badgeID, out.ObjectKey, p.ContentType, out.ExpiresIn, c.ClientIP())
 	respondData(c, out)
@@ -169,6 +170,10 @@ func (h *appHandler) UpsertCoserBinding(c *gin.Context) {
 		respondError(c, http.StatusBadRequest, "photoObjectKey must be an object key")
 		return
 	}
+	if err := h.svc.checkDirectUpload(c.Request.Context(), photoKey); err != nil {
+		respondError(c, http.StatusRequestEntityTooLarge, "photo too large")
+		return
+	}
 	writtenAt := time.Now().UTC()
 	if strings.TrimSpace(p.WrittenAt) != "" {
 		t, err := time.Parse(time.RFC3339, strings.TrimSpace(p.WrittenAt))
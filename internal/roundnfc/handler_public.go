@@ -1,8 +1,11 @@
 package roundnfc
 
 import (
+	"io"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"backend-go/internal/risk"
 	"backend-go/pkg/objstore"
@@ -78,11 +81,7 @@ func (h *publicHandler) CreatePhotoRequest(c *gin.Context) {
 		respondError(c, http.StatusBadRequest, "name and contact required")
 		return
 	}
-	if !h.svc.rl.Allow("photo:" + c.ClientIP() + ":" + id) {
-		respondError(c, http.StatusTooManyRequests, "too many requests")
-		return
-	}
-	if !h.verifyTurnstile(c, p.TurnstileToken) {
+	if !h.verifyCaptcha(c, p.TurnstileToken) {
 		return
 	}
 	req := &PhotoRequest{
@@ -94,6 +93,11 @@ func (h *publicHandler) CreatePhotoRequest(c *gin.Context) {
 		AttachmentKeys: p.AttachmentKeys,
 		IPHash:         hashIP(c.ClientIP(), h.svc.cfg.AdminUsername),
 	}
+	score := h.svc.scoreSpam(c.Request.Context(), "photo/"+req.ID, req.spamText(), req.IPHash)
+	req.SpamScore, req.SpamReasons = score.Score, score.Reasons
+	if score.Spam {
+		req.Status = StatusQuarantined
+	}
 	if err := h.svc.store.InsertPhotoRequest(c.Request.Context(), req); err != nil {
 		respondError(c, http.StatusInternalServerError, "internal error")
 		return
@@ -122,11 +126,7 @@ func (h *publicHandler) CreateAutographRequest(c *gin.Context) {
 		respondError(c, http.StatusBadRequest, "name, contact and content required")
 		return
 	}
-	if !h.svc.rl.Allow("auto:" + c.ClientIP() + ":" + id) {
-		respondError(c, http.StatusTooManyRequests, "too many requests")
-		return
-	}
-	if !h.verifyTurnstile(c, p.TurnstileToken) {
+	if !h.verifyCaptcha(c, p.TurnstileToken) {
 		return
 	}
 	req := &AutographRequest{
@@ -139,6 +139,11 @@ func (h *publicHandler) CreateAutographRequest(c *gin.Context) {
 		AttachmentKeys: p.AttachmentKeys,
 		IPHash:         hashIP(c.ClientIP(), h.svc.cfg.AdminUsername),
 	}
+	score := h.svc.scoreSpam(c.Request.Context(), "autograph/"+req.ID, req.spamText(), req.IPHash)
+	req.SpamScore, req.SpamReasons = score.Score, score.Reasons
+	if score.Spam {
+		req.Status = StatusQuarantined
+	}
 	if err := h.svc.store.InsertAutographRequest(c.Request.Context(), req); err != nil {
 		respondError(c, http.StatusInternalServerError, "internal error")
 		return
@@ -147,11 +152,7 @@ func (h *publicHandler) CreateAutographRequest(c *gin.Context) {
 }
 
 func (h *publicHandler) UploadAttachment(c *gin.Context) {
-	if !h.svc.rl.Allow("upload:" + c.ClientIP()) {
-		respondError(c, http.StatusTooManyRequests, "too many requests")
-		return
-	}
-	if !h.verifyTurnstile(c, c.PostForm("turnstileToken")) {
+	if !h.verifyCaptcha(c, c.PostForm("turnstileToken")) {
 		return
 	}
 	f, _, err := c.Request.FormFile("file")
@@ -175,12 +176,23 @@ func (h *publicHandler) UploadAttachment(c *gin.Context) {
 	respondData(c, gin.H{"key": key, "mime": mime, "size": size})
 }
 
-// GET /objects/:token 返回 blob（一次性消费）。前端 fetch 后 createObjectURL 即可。
+// RequireCaptcha 是 tus 创建上传前的人机验证，token 放在请求头里
+// （见 risk.TokenHeaders），之后的 PATCH 不再校验。
+func (h *publicHandler) RequireCaptcha(c *gin.Context) {
+	if !h.verifyCaptcha(c, "") {
+		c.Abort()
+	}
+}
+
+// GET /objects/:token 返回 blob。token 默认在 ROUNDNFC_OBJECT_TTL_SECONDS
+// 内不限次数，支持 Range、ETag 和 If-None-Match；ROUNDNFC_OBJECT_MAX_USES
+// 限制次数时每个请求（包括 Range 分段和 304）都算一次使用。
+// 对象存在 COS 等云端时 302 到短时签名 URL。
 func (h *publicHandler) GetObject(c *gin.Context) {
-	rc, meta, err := h.svc.ResolveObject(c.Request.Context(), c.Param("token"))
+	rc, meta, redirect, err := h.svc.ResolveObject(c.Request.Context(), c.Param("token"))
 	if err != nil {
 		switch err {
-		case objstore.ErrTokenExpired, objstore.ErrTokenConsumed:
+		case objstore.ErrTokenExpired, objstore.ErrTokenConsumed, objstore.ErrTokenRevoked:
 			c.String(http.StatusGone, "link expired")
 		case objstore.ErrTokenInvalid:
 			c.String(http.StatusForbidden, "forbidden")
@@ -191,44 +203,74 @@ func (h *publicHandler) GetObject(c *gin.Context) {
 		}
 		return
 	}
+	if h.svc.cfg.ObjectMaxUses == 1 {
+		c.Header("Cache-Control", "no-store")
+	} else {
+		c.Header("Cache-Control", "private, no-cache")
+	}
+	if redirect != "" {
+		c.Redirect(http.StatusFound, redirect)
+		return
+	}
+	serveObject(c, rc, meta)
+}
+
+// serveObject 回对象内容并关闭 rc。
+func serveObject(c *gin.Context, rc io.ReadCloser, meta objstore.ObjectMeta) {
 	defer rc.Close()
-	c.Header("Cache-Control", "no-store")
 	c.Header("X-Content-Type-Options", "nosniff")
+	if meta.ETag != "" {
+		c.Header("ETag", `"`+meta.ETag+`"`)
+	}
+	// 本地驱动给的是文件；加密对象的解密流也能 Seek（云端驱动上只能往前
+	// 跳，前面的密文读掉丢弃）。ServeContent 处理 Range / If-None-Match /
+	// If-Range；大小未知时只能整个回
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		c.Header("Content-Type", meta.ContentType)
+		http.ServeContent(c.Writer, c.Request, "", time.Time{}, rs)
+		return
+	}
 	c.DataFromReader(http.StatusOK, meta.Size, meta.ContentType, rc, nil)
 }
 
-// GET /cos-objects/:token 消费一次性 token，并 302 到短时 COS 签名 GET URL。
+// GET /cos-objects/:token 消费一次性 token，并 302 到短时 COS 签名 GET URL；
+// 上传存储加密时直接回对象内容。
 func (h *publicHandler) RedirectCOSObject(c *gin.Context) {
-	u, err := h.svc.ResolveCOSObjectURL(c.Request.Context(), c.Param("token"))
+	rc, meta, redirect, err := h.svc.ResolveCOSObject(c.Request.Context(), c.Param("token"))
 	if err != nil {
 		switch err {
-		case objstore.ErrTokenExpired, objstore.ErrTokenConsumed:
+		case objstore.ErrTokenExpired, objstore.ErrTokenConsumed, objstore.ErrTokenRevoked:
 			c.String(http.StatusGone, "link expired")
 		case objstore.ErrTokenInvalid:
 			c.String(http.StatusForbidden, "forbidden")
 		case ErrCOSNotConfigured:
 			c.String(http.StatusServiceUnavailable, "cos not configured")
+		case objstore.ErrNotFound:
+			c.String(http.StatusNotFound, "not found")
 		default:
 			c.String(http.StatusInternalServerError, "internal error")
 		}
 		return
 	}
 	c.Header("Cache-Control", "no-store")
-	c.Redirect(http.StatusFound, u)
+	if redirect != "" {
+		c.Redirect(http.StatusFound, redirect)
+		return
+	}
+	serveObject(c, rc, meta)
 }
 
-func (h *publicHandler) verifyTurnstile(c *gin.Context, jsonToken string) bool {
-	tok := jsonToken
-	if tok == "" {
-		tok = c.GetHeader("CF-Turnstile-Response")
-	}
-	ok, err := risk.VerifyTurnstile(c.Request.Context(), h.svc.cfg.TurnstileSecret, tok, c.ClientIP())
+// verifyCaptcha checks the human-verification token (body field, X-Captcha-Token
+// or CF-Turnstile-Response) with the configured provider.
+func (h *publicHandler) verifyCaptcha(c *gin.Context, bodyToken string) bool {
+	ok, err := risk.Check(c, h.svc.captcha, bodyToken)
 	if err != nil {
-		respondError(c, http.StatusBadGateway, "turnstile verify failed")
+		log.Printf("[roundnfc] %v", err)
+		respondError(c, http.StatusBadGateway, "captcha verify failed")
 		return false
 	}
 	if !ok {
-		respondError(c, http.StatusForbidden, "turnstile verification failed")
+		respondError(c, http.StatusForbidden, "captcha verification failed")
 		return false
 	}
 	return true
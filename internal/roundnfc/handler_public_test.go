@@ -0,0 +1,146 @@
+package roundnfc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend-go/pkg/objstore"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamingStore hides Seek on Get bodies, like a cloud driver's response.
+type streamingStore struct{ objstore.Storage }
+
+func (s streamingStore) Get(ctx context.Context, key string) (io.ReadCloser, objstore.ObjectMeta, error) {
+	rc, meta, err := s.Storage.Get(ctx, key)
+	if err != nil {
+		return nil, meta, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{rc, rc}, meta, nil
+}
+
+// objectApp serves GET /objects/:token from a local store holding body at
+// "photos/a.bin". mode is "plain", "encrypted", or "encrypted-stream" for an
+// encrypted store over a driver that cannot seek.
+func objectApp(t *testing.T, maxUses int, mode string, body []byte) (*gin.Engine, *Service) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	var objects objstore.Storage
+	local, err := objstore.NewLocal(t.TempDir(), []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects = local
+	if mode != "plain" {
+		ring, err := objstore.ParseKeyring("k1:"+hex.EncodeToString(bytes.Repeat([]byte{1}, 32)), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode == "encrypted-stream" {
+			objects = streamingStore{local}
+		}
+		objects, _ = encryptStores(ring, false, objects, nil)
+	}
+	if _, err := objects.Put(context.Background(), "photos/a.bin", bytes.NewReader(body), ""); err != nil {
+		t.Fatal(err)
+	}
+	svc := &Service{cfg: Config{ObjectTTL: time.Minute, ObjectMaxUses: maxUses}, objects: objects}
+	r := gin.New()
+	r.GET("/objects/:token", newPublicHandler(svc, "/api").GetObject)
+	return r, svc
+}
+
+func getObject(r http.Handler, token string, hdr ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/objects/"+token, nil)
+	for i := 0; i+1 < len(hdr); i += 2 {
+		req.Header.Set(hdr[i], hdr[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestGetObject(t *testing.T) {
+	// three 64 KiB encryption chunks; no content type, so ServeContent sniffs
+	body := make([]byte, 150000)
+	_, _ = rand.Read(body)
+	for _, mode := range []string{"plain", "encrypted", "encrypted-stream"} {
+		r, svc := objectApp(t, 0, mode, body)
+		token, err := svc.SignObject(context.Background(), "photos/a.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := getObject(r, token)
+		etag := w.Header().Get("ETag")
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), body) || etag == "" ||
+			w.Header().Get("Cache-Control") != "private, no-cache" {
+			t.Fatalf("%s full: %d %d bytes etag=%q cc=%q", mode, w.Code, w.Body.Len(), etag, w.Header().Get("Cache-Control"))
+		}
+		for _, rng := range [][2]int{{4, 7}, {70000, 70009}, {len(body) - 10, len(body) - 1}} {
+			w = getObject(r, token, "Range", fmt.Sprintf("bytes=%d-%d", rng[0], rng[1]))
+			if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), body[rng[0]:rng[1]+1]) ||
+				w.Header().Get("Content-Range") != fmt.Sprintf("bytes %d-%d/%d", rng[0], rng[1], len(body)) {
+				t.Fatalf("%s range %v: %d %q", mode, rng, w.Code, w.Header().Get("Content-Range"))
+			}
+		}
+		if w := getObject(r, token, "If-None-Match", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Fatalf("%s if-none-match: %d", mode, w.Code)
+		}
+		if w := getObject(r, token, "If-None-Match", `"other"`); w.Code != http.StatusOK {
+			t.Fatalf("%s stale etag: %d", mode, w.Code)
+		}
+		// unlimited by default: the link keeps working until it expires or is revoked
+		if err := svc.RevokeObjectToken(context.Background(), token); err != nil {
+			t.Fatal(err)
+		}
+		if w := getObject(r, token); w.Code != http.StatusGone {
+			t.Fatalf("%s revoked: %d", mode, w.Code)
+		}
+	}
+}
+
+// With a cap, a Range request and a 304 each count as one use.
+func TestGetObjectMaxUses(t *testing.T) {
+	r, svc := objectApp(t, 2, "plain", []byte("0123456789"))
+	token, err := svc.SignObject(context.Background(), "photos/a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := getObject(r, token, "Range", "bytes=0-3")
+	if w.Code != http.StatusPartialContent || w.Header().Get("Cache-Control") != "private, no-cache" {
+		t.Fatalf("first: %d", w.Code)
+	}
+	if w := getObject(r, token, "If-None-Match", w.Header().Get("ETag")); w.Code != http.StatusNotModified {
+		t.Fatalf("second: %d", w.Code)
+	}
+	if w := getObject(r, token); w.Code != http.StatusGone {
+		t.Fatalf("third: %d", w.Code)
+	}
+
+	r, svc = objectApp(t, 1, "plain", []byte("0123456789"))
+	if token, err = svc.SignObject(context.Background(), "photos/a.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if w := getObject(r, token); w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("one-shot: %d %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+	if w := getObject(r, token); w.Code != http.StatusGone {
+		t.Fatalf("one-shot reused: %d", w.Code)
+	}
+	if w := getObject(r, "garbage"); w.Code != http.StatusForbidden {
+		t.Fatalf("invalid token: %d", w.Code)
+	}
+}
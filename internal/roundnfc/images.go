@@ -0,0 +1,98 @@
+package roundnfc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"backend-go/pkg/imgproxy"
+	"backend-go/pkg/objstore"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ----- Image variants -----
+//
+// Badge and style-template images are served resized through
+// GET <prefix>/images/<key>?w=&h=&fit=&q=&exp=&sig= (pkg/imgproxy). URLs are
+// signed, so only the sizes we hand out can be generated; variants are
+// cached in the object store under imageVariantPrefix. They are not in the
+// object registry: image_variants maps each to its source key, and the GC
+// drops them with the source (as does rewriting the source in place).
+
+const imageVariantPrefix = "image-variants"
+
+// stylePreviewWidth is the width of BadgeStyleTemplate.ImagePreviewURL.
+const stylePreviewWidth = 480
+
+// newImageServer signs with a key derived from ROUNDNFC_OBJECT_HMAC_KEY so
+// an image URL signature can never double as a one-shot object token.
+func newImageServer(cfg Config, objects objstore.Storage, store *Store) (*imgproxy.Server, error) {
+	mac := hmac.New(sha256.New, cfg.ObjectHMACKey)
+	mac.Write([]byte("roundnfc/images"))
+	return imgproxy.New(imgproxy.Config{
+		Key:         mac.Sum(nil),
+		Cache:       objects,
+		CachePrefix: imageVariantPrefix,
+		Source: func(ctx context.Context, key string) (io.ReadCloser, error) {
+			if !isObjectKey(key) || strings.HasPrefix(key, imageVariantPrefix+"/") || strings.HasPrefix(key, originalsPrefix+"/") {
+				return nil, objstore.ErrNotFound
+			}
+			rc, _, err := objects.Get(ctx, key)
+			return rc, err
+		},
+		OnCache: func(ctx context.Context, src, key string) {
+			if err := store.AddImageVariant(ctx, src, key); err != nil {
+				log.Printf("[roundnfc] image variant key=%s: %v", key, err)
+			}
+		},
+	})
+}
+
+// dropImageVariants deletes the cached variants of src. One that cannot be
+// deleted is recorded again and retried with the next drop.
+func (s *Service) dropImageVariants(ctx context.Context, src string) {
+	keys, err := s.store.TakeImageVariants(ctx, src)
+	if err != nil {
+		log.Printf("[roundnfc] image variants src=%s: %v", src, err)
+		return
+	}
+	for _, k := range keys {
+		if err := s.objects.Delete(ctx, k); err != nil {
+			log.Printf("[roundnfc] delete image variant key=%s: %v", k, err)
+			_ = s.store.AddImageVariant(ctx, src, k)
+		}
+	}
+}
+
+// imageExpiry rounds the URL expiry up to a whole ImageURLTTL window so
+// repeated page loads get identical, browser-cacheable URLs. A URL stays
+// valid for at least one window.
+func (s *Service) imageExpiry() time.Time {
+	ttl := s.cfg.ImageURLTTL
+	return time.Now().Truncate(ttl).Add(2 * ttl)
+}
+
+func (s *Service) imageBase(urlPrefix string) string {
+	return strings.TrimRight(urlPrefix, "/") + "/images"
+}
+
+// ImageURL returns a signed variant URL of key, width 0 meaning the
+// original size (re-encoded in the negotiated format).
+func (s *Service) ImageURL(key, urlPrefix string, width int) string {
+	return s.images.URL(s.imageBase(urlPrefix), key, imgproxy.Options{Width: width}, s.imageExpiry())
+}
+
+// ImageSrcset returns a srcset value with one entry per ImageWidths.
+func (s *Service) ImageSrcset(key, urlPrefix string) string {
+	return s.images.Srcset(s.imageBase(urlPrefix), key, s.cfg.ImageWidths, imgproxy.Options{}, s.imageExpiry())
+}
+
+// GET /images/*key serves a signed image variant.
+func (h *publicHandler) GetImage(c *gin.Context) {
+	h.svc.images.Serve(c.Writer, c.Request, strings.TrimPrefix(c.Param("key"), "/"))
+}
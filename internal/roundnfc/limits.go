@@ -0,0 +1,83 @@
+package roundnfc
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"backend-go/internal/risk/ratelimit"
+	"backend-go/internal/risk/rules"
+	"backend-go/internal/risk/spam"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rate-limited public routes. Each name can be tuned with
+// ROUNDNFC_RATELIMIT_<NAME>, e.g. ROUNDNFC_RATELIMIT_PHOTO_REQUEST=6/1m,burst=2.
+const (
+	rlPhotoRequest     = "photo-request"
+	rlAutographRequest = "autograph-request"
+	rlUpload           = "upload"
+)
+
+func newLimiter(policies map[string]ratelimit.Policy) *ratelimit.Limiter {
+	return ratelimit.New(ratelimit.Options{
+		Namespace: "roundnfc",
+		Store:     ratelimit.SharedStore(),
+		Policies:  policies,
+		Deny: func(c *gin.Context, _ ratelimit.Result) {
+			respondError(c, http.StatusTooManyRequests, "too many requests")
+		},
+	})
+}
+
+// riskScope is the module name used for risk rules (see risk/rules).
+const riskScope = "roundnfc"
+
+// riskGuard applies admin-managed IP / CIDR / ip_hash rules to public routes.
+func (s *Service) riskGuard() gin.HandlerFunc {
+	return s.rules.Guard(rules.GuardOptions{
+		Scope:   riskScope,
+		Hash:    func(ip string) string { return hashIP(ip, s.cfg.AdminUsername) },
+		Captcha: s.captcha,
+	})
+}
+
+// scoreSpam scores a public submission. Errors are logged and the partial
+// result is used, so a broken filter never blocks submissions.
+func (s *Service) scoreSpam(ctx context.Context, ref, text, ipHash string) spam.Result {
+	res, err := s.spam.Check(ctx, spam.Submission{Text: text, IPHash: ipHash})
+	if err != nil {
+		log.Printf("[roundnfc] spam check %s: %v", ref, err)
+	}
+	if res.Spam {
+		log.Printf("[roundnfc] %s quarantined: score=%.2f %v", ref, res.Score, res.Reasons)
+	}
+	return res
+}
+
+// trainSpam feeds an admin decision back into the spam classifier: rejected
+// requests are spam samples, handled ones ham, and releasing back to new or
+// quarantined withdraws the sample.
+func (s *Service) trainSpam(ctx context.Context, ref, text, status string) {
+	var err error
+	switch status {
+	case StatusRejected:
+		err = s.spam.Train(ctx, ref, text, true)
+	case StatusHandled:
+		err = s.spam.Train(ctx, ref, text, false)
+	default:
+		err = s.spam.Forget(ctx, ref)
+	}
+	if err != nil {
+		log.Printf("[roundnfc] spam train %s: %v", ref, err)
+	}
+}
+
+func (p *PhotoRequest) spamText() string {
+	return p.Name + "\n" + p.Contact + "\n" + p.Message
+}
+
+func (p *AutographRequest) spamText() string {
+	return p.Name + "\n" + p.Contact + "\n" + p.Target + "\n" + p.Content
+}
@@ -0,0 +1,174 @@
+package roundnfc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"strings"
+
+	"backend-go/pkg/imgmeta"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// ----- Image metadata -----
+//
+// JPEG / PNG / WebP images lose their EXIF, XMP and IPTC on the way in
+// (pkg/imgmeta): IngestImage and tus uploads before they are stored, app
+// direct uploads when their key is reported back (checkDirectUpload), and
+// older objects through the StripStoredMetadata backfill. Purposes listed
+// in ROUNDNFC_KEEP_ORIGINALS keep the untouched file under originalsPrefix;
+// it is never handed out and shares the lifecycle of the stripped object.
+
+const originalsPrefix = "originals"
+
+// Upload purposes, as used by ROUNDNFC_KEEP_ORIGINALS.
+const (
+	purposeAttachment    = "attachment"
+	purposeBadge         = "badge"
+	purposeStyleTemplate = "style-template"
+	purposeCoserPhoto    = "coser-photo"
+	purposeNFCWrite      = "nfc-write"
+)
+
+// maxStripBytes caps the images metadata is stripped from. Stripping holds
+// the whole file and its stripped copy in memory, so larger images are
+// refused at ingest (whatever ROUNDNFC_MAX_UPLOAD_MB says) and reported as
+// failed by stripStored.
+var maxStripBytes int64 = 64 << 20
+
+// metadataBatch caps the objects looked at per backfill call.
+const metadataBatch = 200
+
+// keyPurpose maps an object key to the purpose it was uploaded for.
+func keyPurpose(key string) string {
+	switch {
+	case strings.HasPrefix(key, "uploads/"):
+		return purposeAttachment
+	case strings.HasPrefix(key, "badges/"):
+		return purposeBadge
+	case strings.HasPrefix(key, "style-templates/"):
+		return purposeStyleTemplate
+	case strings.HasPrefix(key, "roundnfc/coser-photos/"):
+		return purposeCoserPhoto
+	case strings.HasPrefix(key, "roundnfc/nfc-writes/"):
+		return purposeNFCWrite
+	}
+	return ""
+}
+
+func originalKey(key string) string { return originalsPrefix + "/" + key }
+
+// keepOriginal stores the unstripped file next to key when the purpose
+// asks for it.
+func (s *Service) keepOriginal(ctx context.Context, bucket, key, mime string, data []byte) {
+	if !s.cfg.KeepOriginals[keyPurpose(key)] {
+		return
+	}
+	orig := originalKey(key)
+	if _, err := s.storeFor(bucket).Put(ctx, orig, bytes.NewReader(data), mime); err != nil {
+		log.Printf("[roundnfc] keep original key=%s: %v", orig, err)
+		return
+	}
+	s.registerObject(ctx, bucket, orig, int64(len(data)))
+}
+
+// MetadataReport is the outcome of one backfill batch. Next is the cursor
+// for the following call, empty once every object has been looked at.
+type MetadataReport struct {
+	DryRun     bool             `json:"dryRun"`
+	Scanned    int              `json:"scanned"`
+	Stripped   int              `json:"stripped"`
+	Reoriented int              `json:"reoriented"`
+	Failed     int              `json:"failed"`
+	BytesSaved int64            `json:"bytesSaved"`
+	Objects    []StrippedObject `json:"objects"`
+	Next       string           `json:"next,omitempty"`
+}
+
+// StrippedObject is one object that had (or, with DryRun, has) metadata.
+type StrippedObject struct {
+	Key         string   `json:"key"`
+	Removed     []string `json:"removed,omitempty"`
+	Orientation int      `json:"orientation,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// stripStored rewrites an already stored image in place without its
+// metadata. Local storage serves by extension, so the format must not
+// change under the key: a rotated WebP keeps an orientation-only EXIF
+// instead of being re-encoded (imgmeta.StripSameFormat).
+func (s *Service) stripStored(ctx context.Context, bucket, key string, dryRun bool) (*imgmeta.Result, int64, error) {
+	store := s.storeFor(bucket)
+	rc, _, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := io.ReadAll(io.LimitReader(rc, maxStripBytes+1))
+	_ = rc.Close()
+	if err != nil {
+		return nil, 0, err
+	}
+	if int64(len(data)) > maxStripBytes {
+		return nil, 0, ErrTooLarge
+	}
+	mime := mimetype.Detect(data).String()
+	res, err := imgmeta.StripSameFormat(data, mime)
+	if err != nil || !res.Changed() || dryRun {
+		return res, int64(len(data)), err
+	}
+	s.keepOriginal(ctx, bucket, key, mime, data)
+	if _, err := store.Put(ctx, key, bytes.NewReader(res.Data), res.MIME); err != nil {
+		return nil, 0, err
+	}
+	s.registerObject(ctx, bucket, key, int64(len(res.Data)))
+	// variants were made from the old pixels (maybe still unrotated)
+	s.dropImageVariants(ctx, key)
+	return res, int64(len(data)), nil
+}
+
+// StripStoredMetadata is the backfill for objects stored before ingest
+// stripped metadata: it walks registered and referenced keys after the
+// cursor in key order and strips up to limit of them.
+func (s *Service) StripStoredMetadata(ctx context.Context, after string, limit int, dryRun bool) (*MetadataReport, error) {
+	if limit <= 0 || limit > metadataBatch {
+		limit = metadataBatch
+	}
+	objs, err := s.store.ObjectKeysAfter(ctx, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	rep := &MetadataReport{DryRun: dryRun, Objects: []StrippedObject{}}
+	for _, o := range objs {
+		rep.Next = o.Key
+		if keyPurpose(o.Key) == "" {
+			continue
+		}
+		rep.Scanned++
+		res, size, err := s.stripStored(ctx, o.Bucket, o.Key, dryRun)
+		if err != nil {
+			if errors.Is(err, imgmeta.ErrMalformed) {
+				continue
+			}
+			log.Printf("[roundnfc] strip metadata key=%s: %v", o.Key, err)
+			rep.Failed++
+			rep.Objects = append(rep.Objects, StrippedObject{Key: o.Key, Error: err.Error()})
+			continue
+		}
+		if !res.Changed() {
+			continue
+		}
+		rep.Stripped++
+		if res.Reencoded {
+			rep.Reoriented++
+		}
+		rep.BytesSaved += size - int64(len(res.Data))
+		rep.Objects = append(rep.Objects, StrippedObject{Key: o.Key, Removed: res.Removed, Orientation: res.Orientation})
+	}
+	if len(objs) < limit {
+		rep.Next = ""
+	}
+	return rep, nil
+}
@@ -0,0 +1,143 @@
+package roundnfc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"backend-go/pkg/objstore"
+)
+
+// exifJPEG returns a JPEG carrying an EXIF block with a GPS-looking string.
+func exifJPEG(t *testing.T) []byte {
+	t.Helper()
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	tiff := []byte("II*\x00\x08\x00\x00\x00\x00\x00GPS 31.2304N 121.4737E")
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	seg := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(len(payload)+2))
+	out := append([]byte{}, enc.Bytes()[:2]...)
+	out = append(out, seg...)
+	out = append(out, payload...)
+	return append(out, enc.Bytes()[2:]...)
+}
+
+func TestStripMetadata(t *testing.T) {
+	store, err := openStore(filepath.Join(t.TempDir(), "roundnfc.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	objects, err := objstore.NewLocal(t.TempDir(), []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &Service{
+		cfg:     Config{GCGrace: time.Hour, KeepOriginals: map[string]bool{purposeAttachment: true}},
+		store:   store,
+		objects: objects,
+	}
+	ctx := context.Background()
+	read := func(key string) []byte {
+		t.Helper()
+		rc, _, err := objects.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("get %s: %v", key, err)
+		}
+		defer rc.Close()
+		b, _ := io.ReadAll(rc)
+		return b
+	}
+	photo := exifJPEG(t)
+
+	// ingest: stored without EXIF, the attachment policy keeps the original
+	key, mime, size, err := svc.IngestImage(ctx, "uploads", bytes.NewReader(photo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := read(key); mime != "image/jpeg" || int(size) != len(stored) || bytes.Contains(stored, []byte("GPS")) {
+		t.Fatalf("ingested %s %s %d bytes, contains GPS=%v", key, mime, size, bytes.Contains(stored, []byte("GPS")))
+	}
+	if orig := read(originalKey(key)); !bytes.Equal(orig, photo) {
+		t.Fatal("original not kept")
+	}
+
+	// backfill: an object stored before stripping is rewritten in place
+	// (badges keep no original)
+	if _, err := objects.Put(ctx, "badges/b1/old.jpg", bytes.NewReader(photo), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpsertBadge(ctx, &Badge{ID: "b1", ImageURL: "badges/b1/old.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	rep, err := svc.StripStoredMetadata(ctx, "", 0, true)
+	if err != nil || rep.Stripped != 1 || rep.Objects[0].Key != "badges/b1/old.jpg" {
+		t.Fatalf("dry run: %+v %v", rep, err)
+	}
+	if !bytes.Equal(read("badges/b1/old.jpg"), photo) {
+		t.Fatal("dry run rewrote the object")
+	}
+	rep, err = svc.StripStoredMetadata(ctx, "", 0, false)
+	if err != nil || rep.Stripped != 1 || rep.Next != "" {
+		t.Fatalf("backfill: %+v %v", rep, err)
+	}
+	if bytes.Contains(read("badges/b1/old.jpg"), []byte("GPS")) {
+		t.Fatal("backfill left EXIF behind")
+	}
+	if _, ok, _ := objects.Stat(ctx, originalKey("badges/b1/old.jpg")); ok {
+		t.Fatal("badge original kept against policy")
+	}
+	if rep, _ := svc.StripStoredMetadata(ctx, "", 0, false); rep.Stripped != 0 {
+		t.Fatalf("second pass: %+v", rep)
+	}
+
+	// the original goes with the attachment once nothing references it
+	if _, err := store.db.Exec(`UPDATE stored_objects SET seen_at=?`, time.Now().Add(-2*time.Hour).UTC()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.CollectOrphans(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{key, originalKey(key)} {
+		if _, ok, _ := objects.Stat(ctx, k); ok {
+			t.Fatalf("%s not collected", k)
+		}
+	}
+}
+
+func TestStripMetadataSizeCap(t *testing.T) {
+	store, err := openStore(filepath.Join(t.TempDir(), "roundnfc.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	objects, err := objstore.NewLocal(t.TempDir(), []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &Service{store: store, objects: objects}
+	ctx := context.Background()
+	photo := exifJPEG(t)
+	defer func(v int64) { maxStripBytes = v }(maxStripBytes)
+	maxStripBytes = int64(len(photo)) - 1
+
+	// no upload limit configured, but the image is too big to strip in memory
+	if _, _, _, err := svc.IngestImage(ctx, "uploads", bytes.NewReader(photo)); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("ingest: %v", err)
+	}
+	if _, err := objects.Put(ctx, "badges/b1/old.jpg", bytes.NewReader(photo), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := svc.stripStored(ctx, bucketObjects, "badges/b1/old.jpg", false); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("strip stored: %v", err)
+	}
+}
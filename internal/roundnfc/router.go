@@ -1,8 +1,14 @@
 package roundnfc
 
 import (
+	"net/http"
+
+	"backend-go/internal/auth/apitoken"
 	"backend-go/internal/authflow"
+	"backend-go/internal/risk"
+	"backend-go/internal/risk/ratelimit"
 	"backend-go/internal/roundnfc/envinit"
+	"backend-go/pkg/objstore"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,62 +30,105 @@ func AttachTo(engine *gin.Engine, prefix string) error {
 	flow := authflow.New(svc.AuthFlowConfig())
 
 	g := engine.Group(prefix)
+	svc.mountLocalObjects(g, prefix)
 
-	// public
-	g.GET("/badges/:id", pub.GetBadge)
-	g.GET("/style-templates", pub.ListStyleTemplates)
-	g.GET("/social-links", pub.ListSocialLinks)
-	g.POST("/badges/:id/photo-requests", pub.CreatePhotoRequest)
-	g.POST("/badges/:id/autograph-requests", pub.CreateAutographRequest)
-	g.POST("/uploads", pub.UploadAttachment)
-	g.GET("/objects/:token", pub.GetObject)
-	g.GET("/cos-objects/:token", pub.RedirectCOSObject)
+	// public — risk rules (block / challenge / allow) run before everything else
+	public := g.Group("", svc.riskGuard())
+	public.GET("/badges/:id", pub.GetBadge)
+	public.GET("/style-templates", pub.ListStyleTemplates)
+	public.GET("/social-links", pub.ListSocialLinks)
+	public.GET("/captcha", risk.ConfigHandler(svc.captcha))
+	perBadge := []ratelimit.KeyFunc{ratelimit.ByIP, ratelimit.ByParam("id")}
+	public.POST("/badges/:id/photo-requests", svc.limiter.Handle(rlPhotoRequest, perBadge...), pub.CreatePhotoRequest)
+	public.POST("/badges/:id/autograph-requests", svc.limiter.Handle(rlAutographRequest, perBadge...), pub.CreateAutographRequest)
+	public.POST("/uploads", svc.limiter.Handle(rlUpload), pub.UploadAttachment)
+	public.GET("/objects/:token", pub.GetObject)
+	public.GET("/cos-objects/:token", pub.RedirectCOSObject)
+	public.GET("/images/*key", pub.GetImage)
 
 	// admin — flow handles /login, /me, /totp/*, /webauthn/*
 	admin := g.Group("/admin")
 	flow.Mount(admin)
 
-	// badge + request management (require valid JWT or app token)
-	authed := admin.Group("", adminRequired(svc))
-	authed.GET("/badges", adm.ListBadges)
-	authed.POST("/badges", adm.UpsertBadge)
-	authed.GET("/badges/:id", adm.GetBadge)
-	authed.PUT("/badges/:id", adm.UpsertBadge)
-	authed.DELETE("/badges/:id", adm.DeleteBadge)
-	authed.POST("/badges/:id/image", adm.UploadBadgeImage)
-	authed.GET("/styles", apph.ListStyleTemplates)
-	authed.GET("/style-templates", adm.ListStyleTemplates)
-	authed.POST("/style-templates", adm.UpsertStyleTemplate)
-	authed.PUT("/style-templates/:key", adm.UpsertStyleTemplate)
-	authed.POST("/style-templates/:key/image", adm.UploadStyleTemplateImage)
-	authed.DELETE("/style-templates/:key", adm.DeleteStyleTemplate)
-	authed.GET("/social-links", adm.ListSocialLinks)
-	authed.PUT("/social-links", adm.ReplaceSocialLinks)
-	authed.POST("/uploads/presign", adm.PresignUpload)
-	authed.POST("/nfc-writes", adm.CreateNFCWrite)
-	authed.GET("/photo-requests", adm.ListPhotoRequests)
-	authed.PATCH("/photo-requests/:id", adm.UpdatePhotoStatus)
-	authed.GET("/autograph-requests", adm.ListAutographRequests)
-	authed.PATCH("/autograph-requests/:id", adm.UpdateAutographStatus)
-	authed.GET("/app-tokens", adm.ListAppTokens)
-	authed.POST("/app-tokens", adm.CreateAppToken)
-	authed.PATCH("/app-tokens/:id", adm.UpdateAppToken)
-	authed.DELETE("/app-tokens/:id", adm.DeleteAppToken)
+	// badge + request management (admin JWT or an API token carrying the
+	// route's scope)
+	authed := admin.Group("", adminRequired(svc), flow.PasswordGate())
+	authed.GET("/badges", apitoken.Scope(scopeBadgesRead), adm.ListBadges)
+	authed.POST("/badges", apitoken.Scope(scopeBadgesWrite), adm.UpsertBadge)
+	authed.GET("/badges/:id", apitoken.Scope(scopeBadgesRead), adm.GetBadge)
+	authed.PUT("/badges/:id", apitoken.Scope(scopeBadgesWrite), adm.UpsertBadge)
+	authed.DELETE("/badges/:id", apitoken.Scope(scopeBadgesDelete), flow.StepUp(), adm.DeleteBadge)
+	authed.POST("/badges/:id/image", apitoken.Scope(scopeBadgesWrite), adm.UploadBadgeImage)
+	authed.GET("/styles", apitoken.Scope(scopeStylesRead), apph.ListStyleTemplates)
+	authed.GET("/style-templates", apitoken.Scope(scopeStylesRead), adm.ListStyleTemplates)
+	authed.POST("/style-templates", apitoken.Scope(scopeStylesWrite), adm.UpsertStyleTemplate)
+	authed.PUT("/style-templates/:key", apitoken.Scope(scopeStylesWrite), adm.UpsertStyleTemplate)
+	authed.POST("/style-templates/:key/image", apitoken.Scope(scopeStylesWrite), adm.UploadStyleTemplateImage)
+	authed.DELETE("/style-templates/:key", apitoken.Scope(scopeStylesWrite), adm.DeleteStyleTemplate)
+	authed.GET("/social-links", apitoken.Scope(scopeSocialLinksRead), adm.ListSocialLinks)
+	authed.PUT("/social-links", apitoken.Scope(scopeSocialLinksWrite), adm.ReplaceSocialLinks)
+	authed.POST("/uploads/presign", apitoken.Scope(scopeUploadsCreate), adm.PresignUpload)
+	authed.POST("/nfc-writes", apitoken.Scope(scopeNFCWritesCreate), adm.CreateNFCWrite)
+	authed.GET("/photo-requests", apitoken.Scope(scopeRequestsRead), adm.ListPhotoRequests)
+	authed.PATCH("/photo-requests/:id", apitoken.Scope(scopeRequestsWrite), adm.UpdatePhotoStatus)
+	authed.GET("/autograph-requests", apitoken.Scope(scopeRequestsRead), adm.ListAutographRequests)
+	authed.PATCH("/autograph-requests/:id", apitoken.Scope(scopeRequestsWrite), adm.UpdateAutographStatus)
+	authed.POST("/photo-requests/:id/block", apitoken.AdminOnly(), adm.BlockRequestSender("photo_requests"))
+	authed.POST("/autograph-requests/:id/block", apitoken.AdminOnly(), adm.BlockRequestSender("autograph_requests"))
+	authed.GET("/spam", apitoken.Scope(scopeRequestsRead), adm.SpamStats)
+
+	// token management is admin-only: a token can never mint or widen tokens
+	tokens := authed.Group("", apitoken.AdminOnly())
+	tokens.GET("/app-tokens", adm.ListAppTokens)
+	tokens.POST("/app-tokens", flow.StepUp(), adm.CreateAppToken)
+	tokens.PATCH("/app-tokens/:id", flow.StepUp(), adm.UpdateAppToken)
+	tokens.DELETE("/app-tokens/:id", adm.DeleteAppToken)
+	svc.tokens.Mount(authed.Group("/api-tokens"), flow.StepUp())
+	// object registry: usage per prefix, orphan GC report / run
+	tokens.GET("/storage/usage", adm.StorageUsage)
+	tokens.GET("/storage/gc", adm.GarbageReport)
+	tokens.POST("/storage/gc", flow.StepUp(), adm.CollectGarbage)
+	tokens.POST("/storage/strip-metadata", flow.StepUp(), adm.StripMetadata)
+	tokens.POST("/storage/rewrap", flow.StepUp(), adm.RewrapObjects)
+	tokens.POST("/objects/revoke", adm.RevokeObjectLink)
+	// global risk rules and groups for every module (see risk/rules)
+	svc.rules.Mount(tokens.Group("/risk"), "")
 
 	// Android writer app. Pair by scanning the admin-generated QR code, then
 	// authenticate with X-RoundNFC-App-Token.
 	app := g.Group("/app", appTokenRequired(svc))
-	app.GET("/styles", apph.ListStyleTemplates)
-	app.GET("/style-templates", apph.ListStyleTemplates)
-	app.GET("/badges", adm.ListBadges)
-	app.GET("/badges/:id", adm.GetBadge)
-	app.POST("/badges", apph.UpsertBadgeStyle)
-	app.POST("/badges/:id/coser-photo/presign", apph.PresignCoserPhoto)
-	app.GET("/badges/:id/coser-binding", apph.GetCoserBinding)
-	app.POST("/badges/:id/coser-binding", apph.UpsertCoserBinding)
-	app.POST("/uploads/presign", adm.PresignUpload)
-	app.POST("/cos-objects/presign", apph.PresignCOSObject)
-	app.POST("/nfc-writes", adm.CreateNFCWrite)
+	app.GET("/styles", apitoken.Scope(scopeStylesRead), apph.ListStyleTemplates)
+	app.GET("/style-templates", apitoken.Scope(scopeStylesRead), apph.ListStyleTemplates)
+	app.GET("/badges", apitoken.Scope(scopeBadgesRead), adm.ListBadges)
+	app.GET("/badges/:id", apitoken.Scope(scopeBadgesRead), adm.GetBadge)
+	app.POST("/badges", apitoken.Scope(scopeBadgesWrite), apph.UpsertBadgeStyle)
+	app.POST("/badges/:id/coser-photo/presign", apitoken.Scope(scopeUploadsCreate), apph.PresignCoserPhoto)
+	app.GET("/badges/:id/coser-binding", apitoken.Scope(scopeBadgesRead), apph.GetCoserBinding)
+	app.POST("/badges/:id/coser-binding", apitoken.Scope(scopeBadgesWrite), apph.UpsertCoserBinding)
+	app.POST("/uploads/presign", apitoken.Scope(scopeUploadsCreate), adm.PresignUpload)
+	app.POST("/cos-objects/presign", apitoken.Scope(scopeObjectsRead), apph.PresignCOSObject)
+	app.POST("/nfc-writes", apitoken.Scope(scopeNFCWritesCreate), adm.CreateNFCWrite)
+
+	// resumable (tus) variants of POST /uploads and the app photo presign
+	if err := svc.mountTus(public, app, prefix, svc.limiter.Handle(rlUpload), pub.RequireCaptcha); err != nil {
+		return err
+	}
 
 	return nil
 }
+
+// mountLocalObjects serves presigned URLs of the local driver under
+// <prefix>/local-objects. Without ROUNDNFC_PUBLIC_BASE_URL the URLs are
+// relative and the handlers resolve them against the incoming request.
+// With encryption at rest only direct uploads use it (see encryption.go).
+func (s *Service) mountLocalObjects(g *gin.RouterGroup, prefix string) {
+	l, ok := driver(s.objects).(*objstore.Local)
+	if !ok {
+		return
+	}
+	l.BaseURL = s.cfg.PublicBaseURL + prefix + "/local-objects"
+	h := gin.WrapH(http.StripPrefix(prefix+"/local-objects", l.Handler()))
+	g.PUT("/local-objects/*key", h)
+	g.GET("/local-objects/*key", h)
+	g.HEAD("/local-objects/*key", h)
+}
@@ -13,11 +13,20 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"backend-go/internal/auth"
 	"backend-go/internal/auth/adminpw"
+	"backend-go/internal/auth/apitoken"
+	"backend-go/internal/auth/challenge"
 	"backend-go/internal/authflow"
 	"backend-go/internal/risk"
+	"backend-go/internal/risk/ratelimit"
+	"backend-go/internal/risk/rules"
+	"backend-go/internal/risk/spam"
+	"backend-go/pkg/imgmeta"
+	"backend-go/pkg/imgproxy"
 	"backend-go/pkg/objstore"
 
 	"github.com/gabriel-vasile/mimetype"
@@ -31,18 +40,45 @@ var (
 
 type Config struct {
 	DBPath            string
+	StorageDriver     string
 	ObjectDir         string
 	ObjectHMACKey     []byte
 	ObjectTTL         time.Duration
+	ObjectMaxUses     int
 	MaxUploadBytes    int64
+	TusExpiration     time.Duration
+	GCGrace           time.Duration
+	GCInterval        time.Duration
+	ImageURLTTL       time.Duration
+	ImageWidths       []int
+	KeepOriginals     map[string]bool
+	EncryptionKeys    string
+	EncryptionKeyID   string
+	AllowPlaintext    bool
 	COSBucket         string
 	COSRegion         string
 	COSSecretID       string
 	COSSecretKey      string
 	COSScheme         string
+	COSEndpoint       string
+	PublicBaseURL     string
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3AccessKey       string
+	S3SecretKey       string
+	S3PathStyle       bool
+	S3PublicEndpoint  string
+	OSSBucket         string
+	OSSEndpoint       string
+	OSSRegion         string
+	OSSAccessKeyID    string
+	OSSAccessSecret   string
+	OSSSignVersion    string
+	OSSCNAME          bool
+	OSSPublicEndpoint string
 	AdminAppToken     string
-	TurnstileSecret   string
-	RateLimitPerMin   int
+	RateLimits        map[string]ratelimit.Policy
 	AdminUsername     string
 	AdminPasswordHash string
 	JWTSecret         []byte
@@ -51,6 +87,14 @@ type Config struct {
 	WebAuthnRPID      string
 	WebAuthnRPName    string
 	WebAuthnOrigins   []string
+	LoginGuard        authflow.GuardConfig
+	WebAuthnPolicy    authflow.AttestationPolicy
+	StepUpMaxAge      time.Duration
+	OIDC              authflow.OIDCConfig
+	PasswordHashing   auth.HashPolicy
+	PasswordRules     auth.PasswordRules
+	AdminPWReset      bool
+	Session           authflow.SessionConfig
 }
 
 func ConfigFromEnv() Config {
@@ -68,24 +112,73 @@ func ConfigFromEnv() Config {
 		}
 		return def
 	}
+	var imageWidths []int
+	for _, v := range strings.Split(getStr("ROUNDNFC_IMAGE_WIDTHS", "320,640,960,1280"), ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n > 0 {
+			imageWidths = append(imageWidths, n)
+		}
+	}
+	keepOriginals := map[string]bool{}
+	for _, v := range strings.Split(os.Getenv("ROUNDNFC_KEEP_ORIGINALS"), ",") {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			keepOriginals[v] = true
+		}
+	}
 	origins := strings.Split(getStr("ROUNDNFC_WEBAUTHN_ORIGINS", "http://localhost:5174,http://localhost:8081"), ",")
 	for i, o := range origins {
 		origins[i] = strings.TrimSpace(o)
 	}
+	// ROUNDNFC_RATELIMIT_PER_MIN is the shared default; each route can be
+	// overridden with ROUNDNFC_RATELIMIT_<ROUTE>.
+	perMin := fmt.Sprintf("%d/1m", atoiOr("ROUNDNFC_RATELIMIT_PER_MIN", 12))
+	rateLimits := ratelimit.PoliciesFromEnv("ROUNDNFC", map[string]string{
+		rlPhotoRequest:     perMin,
+		rlAutographRequest: perMin,
+		rlUpload:           perMin,
+	})
+	// MinIO / Garage 等自建服务一般只支持 path-style；AWS 默认 virtual-hosted。
+	s3Endpoint := strings.TrimSpace(os.Getenv("ROUNDNFC_S3_ENDPOINT"))
 	return Config{
 		DBPath:            getStr("ROUNDNFC_SQLITE_PATH", "databases/roundnfc/roundnfc.db"),
+		StorageDriver:     strings.ToLower(getStr("ROUNDNFC_STORAGE_DRIVER", "local")),
 		ObjectDir:         getStr("ROUNDNFC_OBJECT_DIR", "storage/roundnfc/objects"),
 		ObjectHMACKey:     []byte(os.Getenv("ROUNDNFC_OBJECT_HMAC_KEY")),
 		ObjectTTL:         time.Duration(atoiOr("ROUNDNFC_OBJECT_TTL_SECONDS", 120)) * time.Second,
+		ObjectMaxUses:     max(atoiOr("ROUNDNFC_OBJECT_MAX_USES", 0), 0),
 		MaxUploadBytes:    int64(atoiOr("ROUNDNFC_MAX_UPLOAD_MB", 8)) * (1 << 20),
+		TusExpiration:     time.Duration(atoiOr("ROUNDNFC_TUS_EXPIRE_HOURS", 24)) * time.Hour,
+		GCGrace:           time.Duration(atoiOr("ROUNDNFC_GC_GRACE_HOURS", 72)) * time.Hour,
+		GCInterval:        time.Duration(atoiOr("ROUNDNFC_GC_INTERVAL_MINUTES", 60)) * time.Minute,
+		ImageURLTTL:       time.Duration(max(atoiOr("ROUNDNFC_IMAGE_URL_TTL_HOURS", 24), 1)) * time.Hour,
+		ImageWidths:       imageWidths,
+		KeepOriginals:     keepOriginals,
+		EncryptionKeys:    strings.TrimSpace(os.Getenv("ROUNDNFC_ENCRYPTION_KEYS")),
+		EncryptionKeyID:   strings.TrimSpace(os.Getenv("ROUNDNFC_ENCRYPTION_KEY_ID")),
+		AllowPlaintext:    strings.EqualFold(getStr("ROUNDNFC_ENCRYPTION_ALLOW_PLAINTEXT", "false"), "true"),
 		COSBucket:         strings.TrimSpace(os.Getenv("ROUNDNFC_COS_BUCKET")),
 		COSRegion:         strings.TrimSpace(os.Getenv("ROUNDNFC_COS_REGION")),
 		COSSecretID:       strings.TrimSpace(os.Getenv("ROUNDNFC_COS_SECRET_ID")),
 		COSSecretKey:      strings.TrimSpace(os.Getenv("ROUNDNFC_COS_SECRET_KEY")),
 		COSScheme:         getStr("ROUNDNFC_COS_SCHEME", "https"),
+		COSEndpoint:       strings.TrimSpace(os.Getenv("ROUNDNFC_COS_ENDPOINT")),
+		PublicBaseURL:     strings.TrimRight(strings.TrimSpace(os.Getenv("ROUNDNFC_PUBLIC_BASE_URL")), "/"),
+		S3Endpoint:        s3Endpoint,
+		S3Region:          getStr("ROUNDNFC_S3_REGION", "us-east-1"),
+		S3Bucket:          strings.TrimSpace(os.Getenv("ROUNDNFC_S3_BUCKET")),
+		S3AccessKey:       strings.TrimSpace(os.Getenv("ROUNDNFC_S3_ACCESS_KEY")),
+		S3SecretKey:       strings.TrimSpace(os.Getenv("ROUNDNFC_S3_SECRET_KEY")),
+		S3PathStyle:       strings.EqualFold(getStr("ROUNDNFC_S3_PATH_STYLE", strconv.FormatBool(!strings.Contains(s3Endpoint, "amazonaws.com"))), "true"),
+		S3PublicEndpoint:  strings.TrimSpace(os.Getenv("ROUNDNFC_S3_PUBLIC_ENDPOINT")),
+		OSSBucket:         strings.TrimSpace(os.Getenv("ROUNDNFC_OSS_BUCKET")),
+		OSSEndpoint:       strings.TrimSpace(os.Getenv("ROUNDNFC_OSS_ENDPOINT")),
+		OSSRegion:         strings.TrimSpace(os.Getenv("ROUNDNFC_OSS_REGION")),
+		OSSAccessKeyID:    strings.TrimSpace(os.Getenv("ROUNDNFC_OSS_ACCESS_KEY_ID")),
+		OSSAccessSecret:   strings.TrimSpace(os.Getenv("ROUNDNFC_OSS_ACCESS_KEY_SECRET")),
+		OSSSignVersion:    getStr("ROUNDNFC_OSS_SIGN_VERSION", "v4"),
+		OSSCNAME:          strings.EqualFold(getStr("ROUNDNFC_OSS_CNAME", "false"), "true"),
+		OSSPublicEndpoint: strings.TrimSpace(os.Getenv("ROUNDNFC_OSS_PUBLIC_ENDPOINT")),
 		AdminAppToken:     strings.TrimSpace(os.Getenv("ROUNDNFC_ADMIN_APP_TOKEN")),
-		TurnstileSecret:   os.Getenv("ROUNDNFC_TURNSTILE_SECRET"),
-		RateLimitPerMin:   atoiOr("ROUNDNFC_RATELIMIT_PER_MIN", 12),
+		RateLimits:        rateLimits,
 		AdminUsername:     getStr("ROUNDNFC_ADMIN_USERNAME", "admin"),
 		AdminPasswordHash: adminpw.Resolve("roundnfc", "ROUNDNFC"),
 		JWTSecret:         []byte(os.Getenv("ROUNDNFC_JWT_SECRET")),
@@ -94,6 +187,14 @@ func ConfigFromEnv() Config {
 		WebAuthnRPID:      getStr("ROUNDNFC_WEBAUTHN_RPID", "localhost"),
 		WebAuthnRPName:    getStr("ROUNDNFC_WEBAUTHN_RP_NAME", "RoundNFC Admin"),
 		WebAuthnOrigins:   origins,
+		LoginGuard:        authflow.GuardConfigFromEnv("ROUNDNFC"),
+		WebAuthnPolicy:    authflow.AttestationPolicyFromEnv("ROUNDNFC"),
+		StepUpMaxAge:      time.Duration(atoiOr("ROUNDNFC_STEPUP_MAX_AGE_MINUTES", 5)) * time.Minute,
+		OIDC:              authflow.OIDCConfigFromEnv("ROUNDNFC"),
+		PasswordHashing:   auth.HashPolicyFromEnv("ROUNDNFC"),
+		PasswordRules:     auth.PasswordRulesFromEnv("ROUNDNFC"),
+		AdminPWReset:      strings.EqualFold(getStr("ROUNDNFC_ADMIN_PASSWORD_RESET", "false"), "true"),
+		Session:           authflow.SessionConfigFromEnv("ROUNDNFC"),
 	}
 }
 
@@ -101,13 +202,33 @@ type Service struct {
 	cfg     Config
 	store   *Store
 	objects objstore.Storage
-	rl      *risk.RateLimiter
+	// uploads 是 App 直传 / 直取用的存储，见 newUploadStore。
+	uploads uploadStore
+	limiter *ratelimit.Limiter
+	captcha risk.Verifier
+	rules   *rules.Engine
+	spam    *spam.Filter
+	// challenges 存 WebAuthn challenge，落在同一个库里以便多副本共享
+	challenges challenge.Store
+	// tokens 是带 scope 的 API token（Android 写卡 App、外部前端等）
+	tokens *apitoken.Service
+	// images serves resized badge / template images, see images.go
+	images *imgproxy.Server
+	// encryption is the master keyring when objects are encrypted at rest,
+	// see encryption.go
+	encryption *objstore.Keyring
+	// objectTokens holds object token state for every driver; swept in the
+	// background until Close
+	objectTokens objstore.TokenStore
+	// stop ends gcLoop and the token sweep on Close
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 func NewServiceFromEnv() (*Service, error) {
 	cfg := ConfigFromEnv()
-	log.Printf("[roundnfc/config] db=%s object_dir=%s cos_bucket=%s cos_region=%s cos_secret_id_prefix=%s cos_scheme=%s",
-		cfg.DBPath, cfg.ObjectDir, cfg.COSBucket, cfg.COSRegion, shortSecretID(cfg.COSSecretID), cfg.COSScheme)
+	log.Printf("[roundnfc/config] db=%s storage=%s object_dir=%s cos_bucket=%s cos_region=%s cos_secret_id_prefix=%s cos_scheme=%s s3_endpoint=%s s3_bucket=%s oss_endpoint=%s oss_bucket=%s",
+		cfg.DBPath, cfg.StorageDriver, cfg.ObjectDir, cfg.COSBucket, cfg.COSRegion, shortSecretID(cfg.COSSecretID), cfg.COSScheme, cfg.S3Endpoint, cfg.S3Bucket, cfg.OSSEndpoint, cfg.OSSBucket)
 	store, err := openStore(cfg.DBPath)
 	if err != nil {
 		return nil, fmt.Errorf("open store: %w", err)
@@ -115,19 +236,183 @@ func NewServiceFromEnv() (*Service, error) {
 	if len(cfg.ObjectHMACKey) < 16 {
 		return nil, errors.New("ROUNDNFC_OBJECT_HMAC_KEY must be set (>=16 bytes)")
 	}
-	local, err := objstore.NewLocal(cfg.ObjectDir, cfg.ObjectHMACKey)
+	if err := cfg.WebAuthnPolicy.Validate(); err != nil {
+		return nil, fmt.Errorf("ROUNDNFC_WEBAUTHN_*: %w", err)
+	}
+	objects, err := newObjectStore(cfg)
 	if err != nil {
 		return nil, err
 	}
-	return &Service{
-		cfg:     cfg,
-		store:   store,
-		objects: local,
-		rl:      risk.NewRateLimiter(cfg.RateLimitPerMin, time.Minute),
-	}, nil
+	// object tokens live in the database so links survive a restart
+	objectTokens, err := objstore.NewSQLTokenStore(store.db, "object_tokens")
+	if err != nil {
+		return nil, fmt.Errorf("object tokens: %w", err)
+	}
+	objects.SetTokenStore(objectTokens)
+	uploads := newUploadStore(cfg, objects)
+	var encryption *objstore.Keyring
+	if cfg.EncryptionKeys != "" {
+		if encryption, err = objstore.ParseKeyring(cfg.EncryptionKeys, cfg.EncryptionKeyID); err != nil {
+			return nil, fmt.Errorf("ROUNDNFC_ENCRYPTION_KEYS: %w", err)
+		}
+		objects, uploads = encryptStores(encryption, cfg.AllowPlaintext, objects, uploads)
+		log.Printf("[roundnfc/config] objects encrypted at rest, key id=%s", encryption.Current())
+	}
+	images, err := newImageServer(cfg, objects, store)
+	if err != nil {
+		return nil, fmt.Errorf("image server: %w", err)
+	}
+	challenges, err := challenge.NewSQL(store.db, "admin_challenges")
+	if err != nil {
+		return nil, fmt.Errorf("challenge store: %w", err)
+	}
+	captcha, err := risk.VerifierFromEnv("ROUNDNFC", challenges)
+	if err != nil {
+		return nil, err
+	}
+	// One verifier (and one PoW challenge store) for public forms and the
+	// admin login unless the login has its own Turnstile secret.
+	if os.Getenv("ROUNDNFC_LOGIN_TURNSTILE_SECRET") == "" {
+		cfg.LoginGuard.Captcha = captcha
+	}
+	filter, err := spam.New(store.db, spam.ConfigFromEnv("ROUNDNFC"))
+	if err != nil {
+		return nil, fmt.Errorf("spam filter: %w", err)
+	}
+	tokens, err := newTokenService(store.db)
+	if err != nil {
+		return nil, fmt.Errorf("api tokens: %w", err)
+	}
+	if err := migrateAppTokens(context.Background(), store.db, tokens); err != nil {
+		return nil, fmt.Errorf("migrate app tokens: %w", err)
+	}
+	svc := &Service{
+		cfg:          cfg,
+		store:        store,
+		objects:      objects,
+		uploads:      uploads,
+		limiter:      newLimiter(cfg.RateLimits),
+		captcha:      captcha,
+		rules:        rules.Shared(),
+		spam:         filter,
+		challenges:   challenges,
+		tokens:       tokens,
+		images:       images,
+		encryption:   encryption,
+		objectTokens: objectTokens,
+		stop:         make(chan struct{}),
+	}
+	go objstore.SweepTokens(svc.objectTokens, svc.stop)
+	if cfg.GCInterval > 0 {
+		go svc.gcLoop()
+	}
+	return svc, nil
+}
+
+// newObjectStore picks the storage driver from ROUNDNFC_STORAGE_DRIVER.
+// One-shot object tokens are signed with ROUNDNFC_OBJECT_HMAC_KEY either way.
+func newObjectStore(cfg Config) (objstore.Storage, error) {
+	switch cfg.StorageDriver {
+	case "", "local":
+		return objstore.NewLocal(cfg.ObjectDir, cfg.ObjectHMACKey)
+	case "cos":
+		return newCOS(cfg)
+	case "s3":
+		return objstore.NewS3(objstore.S3Config{
+			Endpoint:       cfg.S3Endpoint,
+			Region:         cfg.S3Region,
+			Bucket:         cfg.S3Bucket,
+			AccessKey:      cfg.S3AccessKey,
+			SecretKey:      cfg.S3SecretKey,
+			PathStyle:      cfg.S3PathStyle,
+			PublicEndpoint: cfg.S3PublicEndpoint,
+		}, cfg.ObjectHMACKey)
+	case "oss":
+		return objstore.NewOSS(objstore.OSSConfig{
+			Bucket:          cfg.OSSBucket,
+			Endpoint:        cfg.OSSEndpoint,
+			CNAME:           cfg.OSSCNAME,
+			Region:          cfg.OSSRegion,
+			AccessKeyID:     cfg.OSSAccessKeyID,
+			AccessKeySecret: cfg.OSSAccessSecret,
+			SignVersion:     cfg.OSSSignVersion,
+			PublicEndpoint:  cfg.OSSPublicEndpoint,
+		}, cfg.ObjectHMACKey)
+	}
+	return nil, fmt.Errorf("unknown ROUNDNFC_STORAGE_DRIVER %q (local, cos, s3, oss)", cfg.StorageDriver)
+}
+
+func newCOS(cfg Config) (*objstore.COS, error) {
+	return objstore.NewCOS(objstore.COSConfig{
+		Bucket:    cfg.COSBucket,
+		Region:    cfg.COSRegion,
+		SecretID:  cfg.COSSecretID,
+		SecretKey: cfg.COSSecretKey,
+		Scheme:    cfg.COSScheme,
+		Endpoint:  cfg.COSEndpoint,
+	}, cfg.ObjectHMACKey)
+}
+
+// uploadStore is where app direct uploads (coser photos, NFC write photos)
+// land: it presigns the client's PUT and the later cos-objects GET, and is
+// stat'ed when the app reports the uploaded key back.
+type uploadStore interface {
+	objstore.Storage
+	objstore.Presigner
 }
 
-func (s *Service) Close() error { return s.store.Close() }
+// newUploadStore returns the object store itself — every driver presigns,
+// the local one through the handler mounted by mountLocalObjects. The one
+// exception keeps older deployments working: local driver plus COS
+// credentials still sends app uploads straight to COS.
+func newUploadStore(cfg Config, objects objstore.Storage) uploadStore {
+	if _, local := objects.(*objstore.Local); local {
+		if c, err := newCOS(cfg); err == nil {
+			return c
+		}
+	}
+	if u, ok := objects.(uploadStore); ok {
+		return u
+	}
+	return nil
+}
+
+// checkDirectUpload enforces MaxUploadBytes on an object the client put
+// through a presigned URL. Cloud presigned PUTs cannot carry a size limit,
+// so an oversized object is deleted here when its key is reported back.
+// The object is registered with its real size and its image metadata is
+// stripped and, with encryption at rest, the object sealed on the way.
+func (s *Service) checkDirectUpload(ctx context.Context, key string) error {
+	if key == "" || s.uploads == nil {
+		return nil
+	}
+	meta, ok, err := s.uploads.Stat(ctx, key)
+	if err != nil || !ok {
+		return nil
+	}
+	if s.cfg.MaxUploadBytes <= 0 || meta.Size <= s.cfg.MaxUploadBytes {
+		s.registerObject(ctx, bucketUploads, key, meta.Size)
+		// seal first: the store does not read a plaintext upload back
+		if enc, ok := s.uploads.(*objstore.Encrypted); ok {
+			if _, _, err := enc.Rewrap(ctx, key); err != nil {
+				log.Printf("[roundnfc] encrypt upload key=%s: %v", key, err)
+			}
+		}
+		if _, _, err := s.stripStored(ctx, bucketUploads, key, false); err != nil {
+			log.Printf("[roundnfc] strip metadata key=%s: %v", key, err)
+		}
+		return nil
+	}
+	if err := s.uploads.Delete(ctx, key); err != nil {
+		log.Printf("[roundnfc] delete oversized upload key=%s: %v", key, err)
+	}
+	return ErrTooLarge
+}
+
+func (s *Service) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	return s.store.Close()
+}
 
 // AuthFlowConfig returns the authflow.Config for this service.
 func (s *Service) AuthFlowConfig() authflow.Config {
@@ -141,6 +426,15 @@ func (s *Service) AuthFlowConfig() authflow.Config {
 		WebAuthnRPID:      s.cfg.WebAuthnRPID,
 		WebAuthnRPName:    s.cfg.WebAuthnRPName,
 		WebAuthnOrigins:   s.cfg.WebAuthnOrigins,
+		WebAuthnPolicy:    s.cfg.WebAuthnPolicy,
+		Guard:             s.cfg.LoginGuard,
+		Challenges:        s.challenges,
+		StepUpMaxAge:      s.cfg.StepUpMaxAge,
+		OIDC:              s.cfg.OIDC,
+		PasswordHashing:   s.cfg.PasswordHashing,
+		PasswordRules:     s.cfg.PasswordRules,
+		PasswordReset:     s.cfg.AdminPWReset,
+		Session:           s.cfg.Session,
 	}
 }
 
@@ -154,74 +448,179 @@ var allowedImageMIME = map[string]string{
 
 // IngestImage 受限大小读取 + MIME 嗅探 + 按 sha256 内容寻址并落盘。
 func (s *Service) IngestImage(ctx context.Context, prefix string, r io.Reader) (key, mime string, size int64, err error) {
-	var buf bytes.Buffer
+	return s.ingest(ctx, s.objects, prefix, r, allowedImageMIME)
+}
+
+// ingest 把 r 先写到临时文件（边写边算 sha256、留下开头用于嗅探），
+// 嗅探结果不在 allowed 里时拒绝，否则写进 store。tus 上传完成后的大文件
+// 也走这里：非图片从临时文件流式写入；要去元数据的 JPEG / PNG / WebP
+// 得整个读进内存，超过 maxStripBytes 的直接拒绝（不能带着 EXIF 存下）。
+func (s *Service) ingest(ctx context.Context, store objstore.Storage, prefix string, r io.Reader, allowed map[string]string) (key, mime string, size int64, err error) {
+	tmp, err := os.CreateTemp("", "roundnfc-ingest-*")
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
 	if s.cfg.MaxUploadBytes > 0 {
-		n, copyErr := io.CopyN(&buf, r, s.cfg.MaxUploadBytes+1)
-		if copyErr != nil && copyErr != io.EOF {
-			return "", "", 0, copyErr
-		}
-		if n > s.cfg.MaxUploadBytes {
+		r = io.LimitReader(r, s.cfg.MaxUploadBytes+1)
+	}
+	h := sha256.New()
+	head := &headBuffer{max: 3072}
+	n, err := io.Copy(io.MultiWriter(tmp, h, head), r)
+	if err != nil {
+		return "", "", 0, err
+	}
+	if s.cfg.MaxUploadBytes > 0 && n > s.cfg.MaxUploadBytes {
+		return "", "", 0, ErrTooLarge
+	}
+	mimeStr := mimetype.Detect(head.Bytes()).String()
+	ext, ok := allowed[mimeStr]
+	if !ok {
+		return "", "", 0, ErrUnsupportedMedia
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", "", 0, err
+	}
+	var body io.Reader = tmp
+	// 去掉 EXIF / XMP 等元数据（见 metadata.go），key 按去掉之后的内容算
+	var original []byte
+	originalMIME := mimeStr
+	if imgmeta.Supported(mimeStr) {
+		if n > maxStripBytes {
 			return "", "", 0, ErrTooLarge
 		}
-	} else {
-		if _, err := io.Copy(&buf, r); err != nil {
+		data, err := io.ReadAll(tmp)
+		if err != nil {
 			return "", "", 0, err
 		}
+		res, err := imgmeta.Strip(data, mimeStr)
+		if err != nil {
+			return "", "", 0, ErrUnsupportedMedia
+		}
+		if res.Changed() {
+			if ext, ok = allowed[res.MIME]; !ok {
+				return "", "", 0, ErrUnsupportedMedia
+			}
+			original, mimeStr, n = data, res.MIME, int64(len(res.Data))
+			h.Reset()
+			h.Write(res.Data)
+		}
+		body = bytes.NewReader(res.Data)
 	}
-	mt := mimetype.Detect(buf.Bytes())
-	mimeStr := mt.String()
-	ext, ok := allowedImageMIME[mimeStr]
-	if !ok {
-		return "", "", 0, ErrUnsupportedMedia
-	}
-	sum := sha256.Sum256(buf.Bytes())
-	hexsum := hex.EncodeToString(sum[:])
+	hexsum := hex.EncodeToString(h.Sum(nil))
 	objectKey := path.Join(prefix, hexsum[:2], hexsum+ext)
-	if _, exists, _ := s.objects.Stat(ctx, objectKey); !exists {
-		if _, err := s.objects.Put(ctx, objectKey, &buf, mimeStr); err != nil {
+	bucket := bucketObjects
+	if store != s.objects {
+		bucket = bucketUploads
+	}
+	if _, exists, _ := store.Stat(ctx, objectKey); !exists {
+		if _, err := store.Put(ctx, objectKey, body, mimeStr); err != nil {
 			return "", "", 0, err
 		}
+		if original != nil {
+			s.keepOriginal(ctx, bucket, objectKey, originalMIME, original)
+		}
+	}
+	s.registerObject(ctx, bucket, objectKey, n)
+	return objectKey, mimeStr, n, nil
+}
+
+// headBuffer 只保留写入内容的前 max 字节。
+type headBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		b.Buffer.Write(p[:room])
 	}
-	return objectKey, mimeStr, int64(buf.Len()), nil
+	return len(p), nil
 }
 
+// SignObject issues an object link token valid for ObjectTTL and
+// ObjectMaxUses fetches (1: one-shot, 0: unlimited until it expires).
 func (s *Service) SignObject(ctx context.Context, key string) (string, error) {
-	return s.objects.SignOneShot(ctx, key, s.cfg.ObjectTTL)
+	return s.objects.SignToken(ctx, key, s.objectTokenOptions())
 }
 
 func (s *Service) SignCOSObject(ctx context.Context, key string) (string, error) {
-	return s.objects.SignOneShot(ctx, "cos:"+key, s.cfg.ObjectTTL)
+	return s.objects.SignToken(ctx, "cos:"+key, s.objectTokenOptions())
+}
+
+func (s *Service) objectTokenOptions() objstore.TokenOptions {
+	return objstore.TokenOptions{TTL: s.cfg.ObjectTTL, MaxUses: s.cfg.ObjectMaxUses}
+}
+
+// RevokeObjectToken invalidates an object link before it expires. token may
+// also be the whole /objects/ or /cos-objects/ URL.
+func (s *Service) RevokeObjectToken(ctx context.Context, token string) error {
+	token, _, _ = strings.Cut(strings.TrimSpace(token), "?")
+	if i := strings.LastIndex(token, "/"); i >= 0 {
+		token = token[i+1:]
+	}
+	return s.objects.RevokeToken(ctx, token)
 }
 
-func (s *Service) ResolveObject(ctx context.Context, token string) (io.ReadCloser, objstore.ObjectMeta, error) {
+// ResolveObject consumes a one-shot token. Cloud drivers return a short-lived
+// presigned URL to redirect to instead of the object body; the local driver
+// streams from disk, a redirect would only add a round trip. Encrypted
+// objects are always streamed, only we can decrypt them.
+func (s *Service) ResolveObject(ctx context.Context, token string) (rc io.ReadCloser, meta objstore.ObjectMeta, redirect string, err error) {
 	key, err := s.objects.ResolveOneShot(ctx, token)
 	if err != nil {
-		return nil, objstore.ObjectMeta{}, err
+		return nil, objstore.ObjectMeta{}, "", err
+	}
+	switch s.objects.(type) {
+	case *objstore.Local, *objstore.Encrypted:
+	default:
+		u, err := s.uploads.PresignGet(ctx, key, cosDownloadPresignTTL)
+		return nil, objstore.ObjectMeta{Key: key}, u, err
 	}
-	return s.objects.Get(ctx, key)
+	rc, meta, err = s.objects.Get(ctx, key)
+	return rc, meta, "", err
 }
 
-func (s *Service) ResolveCOSObjectURL(ctx context.Context, token string) (string, error) {
+// ResolveCOSObject consumes a cos-objects token and returns a short-lived
+// presigned URL of the app upload, or its body when uploads are encrypted.
+func (s *Service) ResolveCOSObject(ctx context.Context, token string) (rc io.ReadCloser, meta objstore.ObjectMeta, redirect string, err error) {
 	key, err := s.objects.ResolveOneShot(ctx, token)
 	if err != nil {
-		return "", err
+		return nil, objstore.ObjectMeta{}, "", err
 	}
 	if !strings.HasPrefix(key, "cos:") {
-		return "", objstore.ErrTokenInvalid
+		return nil, objstore.ObjectMeta{}, "", objstore.ErrTokenInvalid
 	}
 	key = strings.TrimPrefix(key, "cos:")
 	if key == "" || isAbsoluteURL(key) {
-		return "", objstore.ErrTokenInvalid
+		return nil, objstore.ObjectMeta{}, "", objstore.ErrTokenInvalid
+	}
+	if s.uploads == nil {
+		return nil, objstore.ObjectMeta{}, "", ErrCOSNotConfigured
+	}
+	if _, enc := s.uploads.(*objstore.Encrypted); enc {
+		rc, meta, err = s.uploads.Get(ctx, key)
+		return rc, meta, "", err
 	}
-	return s.presignCOSGet(key, cosDownloadPresignTTL)
+	u, err := s.uploads.PresignGet(ctx, key, cosDownloadPresignTTL)
+	return nil, objstore.ObjectMeta{Key: key}, u, err
 }
 
-// PublicBadge 将内部 imageUrl（可能是 object key）转换为一次性下载 URL。
+// PublicBadge 将内部 imageUrl（可能是 object key）转换为一次性下载 URL，
+// 并附上缩放变体的 srcset。
 func (s *Service) PublicBadge(ctx context.Context, b *Badge, urlPrefix string) Badge {
 	out := *b
 	if out.ImageURL != "" && !isAbsoluteURL(out.ImageURL) {
-		if token, err := s.SignObject(ctx, out.ImageURL); err == nil {
+		key := out.ImageURL
+		if token, err := s.SignObject(ctx, key); err == nil {
 			out.ImageURL = strings.TrimRight(urlPrefix, "/") + "/objects/" + token
+			out.ImageSrcset = s.ImageSrcset(key, urlPrefix)
 		} else {
 			out.ImageURL = ""
 		}
@@ -233,6 +632,7 @@ func (s *Service) PublicBadge(ctx context.Context, b *Badge, urlPrefix string) B
 				out.StyleImageOriginalURL = t.ImageURL
 			} else if token, err := s.SignObject(ctx, t.ImageURL); err == nil {
 				out.StyleImageOriginalURL = strings.TrimRight(urlPrefix, "/") + "/objects/" + token
+				out.StyleImageSrcset = s.ImageSrcset(t.ImageURL, urlPrefix)
 			}
 		}
 	}
@@ -258,9 +658,9 @@ func (s *Service) PublicStyleTemplate(ctx context.Context, t BadgeStyleTemplate,
 		return t
 	}
 	if token, err := s.SignObject(ctx, t.ImageURL); err == nil {
-		u := strings.TrimRight(urlPrefix, "/") + "/objects/" + token
-		t.ImageOriginalURL = u
-		t.ImagePreviewURL = u
+		t.ImageOriginalURL = strings.TrimRight(urlPrefix, "/") + "/objects/" + token
+		t.ImagePreviewURL = s.ImageURL(t.ImageURL, urlPrefix, stylePreviewWidth)
+		t.ImageSrcset = s.ImageSrcset(t.ImageURL, urlPrefix)
 	}
 	return t
 }
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -95,18 +96,6 @@ CREATE TABLE IF NOT EXISTS nfc_writes (
   created_at       DATETIME NOT NULL
 );
 CREATE INDEX IF NOT EXISTS idx_nfc_writes_badge_written ON nfc_writes(badge_id, written_at);
-CREATE TABLE IF NOT EXISTS app_tokens (
-  id           TEXT PRIMARY KEY,
-  name         TEXT NOT NULL,
-  purpose      TEXT NOT NULL DEFAULT 'app',
-  token_hash   TEXT NOT NULL UNIQUE,
-  token_prefix TEXT NOT NULL DEFAULT '',
-  enabled      INTEGER NOT NULL DEFAULT 1,
-  last_used_at DATETIME,
-  created_at   DATETIME NOT NULL,
-  updated_at   DATETIME NOT NULL
-);
-CREATE INDEX IF NOT EXISTS idx_app_tokens_hash ON app_tokens(token_hash);
 CREATE TABLE IF NOT EXISTS badge_style_templates (
   key         TEXT PRIMARY KEY,
   label       TEXT NOT NULL DEFAULT '',
@@ -145,8 +134,13 @@ CREATE TABLE IF NOT EXISTS social_links (
 	if err := s.ensureColumn("badge_style_templates", "image_url", "TEXT NOT NULL DEFAULT ''"); err != nil {
 		return err
 	}
-	if err := s.ensureColumn("app_tokens", "purpose", "TEXT NOT NULL DEFAULT 'app'"); err != nil {
-		return err
+	for _, table := range []string{"photo_requests", "autograph_requests"} {
+		if err := s.ensureColumn(table, "spam_score", "REAL NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		if err := s.ensureColumn(table, "spam_reasons", "TEXT NOT NULL DEFAULT '[]'"); err != nil {
+			return err
+		}
 	}
 	if err := s.seedDefaultStyleTemplates(); err != nil {
 		return err
@@ -154,7 +148,10 @@ CREATE TABLE IF NOT EXISTS social_links (
 	if err := s.seedDefaultSocialLinks(); err != nil {
 		return err
 	}
-	return s.migrateAuth()
+	if err := s.migrateAuth(); err != nil {
+		return err
+	}
+	return s.migrateObjects()
 }
 
 func (s *Store) ensureColumn(table, column, spec string) error {
@@ -288,12 +285,33 @@ ON CONFLICT(id) DO UPDATE SET
   updated_at=excluded.updated_at`,
 		b.ID, b.Title, b.Series, b.Type, b.StyleKey, b.ImageURL, b.Description,
 		b.SerialNo, b.ReleasedAt, b.CreatedAt, b.UpdatedAt)
-	return err
+	if err != nil {
+		return err
+	}
+	s.updateObjectRefs(ctx, "badges/"+b.ID, b.ImageURL)
+	return nil
 }
 
+// DeleteBadge also drops the badge's coser binding, which would otherwise
+// keep the coser photo referenced forever.
 func (s *Store) DeleteBadge(ctx context.Context, id string) error {
-	_, err := s.db.ExecContext(ctx, `DELETE FROM badges WHERE id=?`, id)
-	return err
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM badges WHERE id=?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM badge_coser_bindings WHERE badge_id=?`, id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.updateObjectRefs(ctx, "badges/"+id)
+	s.updateObjectRefs(ctx, "badge_coser_bindings/"+id)
+	return nil
 }
 
 // ----- Badge Style Templates -----
@@ -383,7 +401,11 @@ ON CONFLICT(key) DO UPDATE SET
   enabled=excluded.enabled,
   updated_at=excluded.updated_at`,
 		t.Key, t.Label, t.Description, t.ImageURL, normalizeJSON(t.Payload), enabled, t.CreatedAt, t.UpdatedAt)
-	return err
+	if err != nil {
+		return err
+	}
+	s.updateObjectRefs(ctx, "badge_style_templates/"+t.Key, t.ImageURL)
+	return nil
 }
 
 func (s *Store) DeleteBadgeStyleTemplate(ctx context.Context, key string) error {
@@ -394,6 +416,7 @@ func (s *Store) DeleteBadgeStyleTemplate(ctx context.Context, key string) error
 	if n, _ := res.RowsAffected(); n == 0 {
 		return ErrNotFound
 	}
+	s.updateObjectRefs(ctx, "badge_style_templates/"+key)
 	return nil
 }
 
@@ -525,7 +548,11 @@ ON CONFLICT(badge_id) DO UPDATE SET
   written_at=excluded.written_at,
   updated_at=excluded.updated_at`,
 		b.BadgeID, b.CN, b.PhotoObjectKey, b.DeviceID, b.TagUID, nullableTime(b.WrittenAt), b.CreatedAt, b.UpdatedAt)
-	return err
+	if err != nil {
+		return err
+	}
+	s.updateObjectRefs(ctx, "badge_coser_bindings/"+b.BadgeID, b.PhotoObjectKey)
+	return nil
 }
 
 func (s *Store) GetBadgeCoserBinding(ctx context.Context, badgeID string) (*BadgeCoserBinding, error) {
@@ -553,95 +580,6 @@ func nullableTime(t time.Time) any {
 	return t
 }
 
-// ----- App Tokens -----
-
-func (s *Store) ListAppTokens(ctx context.Context) ([]AppToken, error) {
-	rows, err := s.db.QueryContext(ctx, `
-SELECT id,name,purpose,token_prefix,enabled,last_used_at,created_at,updated_at
-FROM app_tokens ORDER BY created_at DESC`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var out []AppToken
-	for rows.Next() {
-		var t AppToken
-		var enabled int
-		var last sql.NullTime
-		if err := rows.Scan(&t.ID, &t.Name, &t.Purpose, &t.TokenPrefix, &enabled, &last, &t.CreatedAt, &t.UpdatedAt); err != nil {
-			return nil, err
-		}
-		t.Enabled = enabled == 1
-		if last.Valid {
-			t.LastUsedAt = &last.Time
-		}
-		out = append(out, t)
-	}
-	return out, rows.Err()
-}
-
-func (s *Store) InsertAppToken(ctx context.Context, t *AppToken, tokenHash string) error {
-	now := time.Now().UTC()
-	t.CreatedAt = now
-	t.UpdatedAt = now
-	t.Purpose = normalizeAppTokenPurpose(t.Purpose)
-	enabled := 0
-	if t.Enabled {
-		enabled = 1
-	}
-	_, err := s.db.ExecContext(ctx, `
-INSERT INTO app_tokens(id,name,purpose,token_hash,token_prefix,enabled,created_at,updated_at)
-VALUES(?,?,?,?,?,?,?,?)`,
-		t.ID, t.Name, t.Purpose, tokenHash, t.TokenPrefix, enabled, t.CreatedAt, t.UpdatedAt)
-	return err
-}
-
-func (s *Store) SetAppTokenEnabled(ctx context.Context, id string, enabled bool) error {
-	flag := 0
-	if enabled {
-		flag = 1
-	}
-	res, err := s.db.ExecContext(ctx, `UPDATE app_tokens SET enabled=?, updated_at=? WHERE id=?`,
-		flag, time.Now().UTC(), id)
-	if err != nil {
-		return err
-	}
-	if n, _ := res.RowsAffected(); n == 0 {
-		return ErrNotFound
-	}
-	return nil
-}
-
-func (s *Store) DeleteAppToken(ctx context.Context, id string) error {
-	res, err := s.db.ExecContext(ctx, `DELETE FROM app_tokens WHERE id=?`, id)
-	if err != nil {
-		return err
-	}
-	if n, _ := res.RowsAffected(); n == 0 {
-		return ErrNotFound
-	}
-	return nil
-}
-
-func (s *Store) VerifyAppToken(ctx context.Context, tokenHash string) (bool, error) {
-	var id string
-	var enabled int
-	err := s.db.QueryRowContext(ctx, `SELECT id,enabled FROM app_tokens WHERE token_hash=?`, tokenHash).
-		Scan(&id, &enabled)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return false, nil
-		}
-		return false, err
-	}
-	if enabled != 1 {
-		return false, nil
-	}
-	now := time.Now().UTC()
-	_, _ = s.db.ExecContext(ctx, `UPDATE app_tokens SET last_used_at=?, updated_at=? WHERE id=?`, now, now, id)
-	return true, nil
-}
-
 // ----- helpers -----
 
 func encodeKeys(keys []string) string {
@@ -663,6 +601,20 @@ func decodeKeys(s string) []string {
 
 // ----- Photo Requests -----
 
+const photoColumns = `id,badge_id,name,contact,message,status,attachment_keys,ip_hash,spam_score,spam_reasons,created_at,updated_at`
+
+func scanPhotoRequest(row interface{ Scan(...any) error }) (*PhotoRequest, error) {
+	var p PhotoRequest
+	var keys, reasons string
+	if err := row.Scan(&p.ID, &p.BadgeID, &p.Name, &p.Contact, &p.Message, &p.Status,
+		&keys, &p.IPHash, &p.SpamScore, &reasons, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	p.AttachmentKeys = decodeKeys(keys)
+	p.SpamReasons = decodeKeys(reasons)
+	return &p, nil
+}
+
 func (s *Store) InsertPhotoRequest(ctx context.Context, p *PhotoRequest) error {
 	now := time.Now().UTC()
 	p.CreatedAt = now
@@ -671,11 +623,15 @@ func (s *Store) InsertPhotoRequest(ctx context.Context, p *PhotoRequest) error {
 		p.Status = StatusNew
 	}
 	_, err := s.db.ExecContext(ctx, `
-INSERT INTO photo_requests(id,badge_id,name,contact,message,status,attachment_keys,ip_hash,created_at,updated_at)
-VALUES(?,?,?,?,?,?,?,?,?,?)`,
+INSERT INTO photo_requests(id,badge_id,name,contact,message,status,attachment_keys,ip_hash,spam_score,spam_reasons,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?,?,?)`,
 		p.ID, p.BadgeID, p.Name, p.Contact, p.Message, p.Status, encodeKeys(p.AttachmentKeys),
-		p.IPHash, p.CreatedAt, p.UpdatedAt)
-	return err
+		p.IPHash, p.SpamScore, encodeKeys(p.SpamReasons), p.CreatedAt, p.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	s.updateRequestRefs(ctx, "photo_requests", p.ID)
+	return nil
 }
 
 func (s *Store) ListPhotoRequests(ctx context.Context, badgeID, status string, limit, offset int) ([]PhotoRequest, int, error) {
@@ -697,7 +653,7 @@ func (s *Store) ListPhotoRequests(ctx context.Context, badgeID, status string, l
 		where = "WHERE " + strings.Join(conds, " AND ")
 	}
 	rows, err := s.db.QueryContext(ctx, `
-SELECT id,badge_id,name,contact,message,status,attachment_keys,ip_hash,created_at,updated_at
+SELECT `+photoColumns+`
 FROM photo_requests `+where+` ORDER BY updated_at DESC LIMIT ? OFFSET ?`,
 		append(args, limit, offset)...)
 	if err != nil {
@@ -706,14 +662,11 @@ FROM photo_requests `+where+` ORDER BY updated_at DESC LIMIT ? OFFSET ?`,
 	defer rows.Close()
 	var out []PhotoRequest
 	for rows.Next() {
-		var p PhotoRequest
-		var keys string
-		if err := rows.Scan(&p.ID, &p.BadgeID, &p.Name, &p.Contact, &p.Message, &p.Status,
-			&keys, &p.IPHash, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		p, err := scanPhotoRequest(rows)
+		if err != nil {
 			return nil, 0, err
 		}
-		p.AttachmentKeys = decodeKeys(keys)
-		out = append(out, p)
+		out = append(out, *p)
 	}
 	var total int
 	if err := s.db.QueryRowContext(ctx, `SELECT count(1) FROM photo_requests `+where, args...).Scan(&total); err != nil {
@@ -722,6 +675,14 @@ FROM photo_requests `+where+` ORDER BY updated_at DESC LIMIT ? OFFSET ?`,
 	return out, total, nil
 }
 
+func (s *Store) GetPhotoRequest(ctx context.Context, id string) (*PhotoRequest, error) {
+	p, err := scanPhotoRequest(s.db.QueryRowContext(ctx, `SELECT `+photoColumns+` FROM photo_requests WHERE id=?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return p, err
+}
+
 func (s *Store) UpdatePhotoStatus(ctx context.Context, id, status string) error {
 	r, err := s.db.ExecContext(ctx,
 		`UPDATE photo_requests SET status=?, updated_at=? WHERE id=?`,
@@ -732,11 +693,26 @@ func (s *Store) UpdatePhotoStatus(ctx context.Context, id, status string) error
 	if n, _ := r.RowsAffected(); n == 0 {
 		return ErrNotFound
 	}
+	s.updateRequestRefs(ctx, "photo_requests", id)
 	return nil
 }
 
 // ----- Autograph Requests -----
 
+const autographColumns = `id,badge_id,name,contact,target,content,status,attachment_keys,ip_hash,spam_score,spam_reasons,created_at,updated_at`
+
+func scanAutographRequest(row interface{ Scan(...any) error }) (*AutographRequest, error) {
+	var p AutographRequest
+	var keys, reasons string
+	if err := row.Scan(&p.ID, &p.BadgeID, &p.Name, &p.Contact, &p.Target, &p.Content,
+		&p.Status, &keys, &p.IPHash, &p.SpamScore, &reasons, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	p.AttachmentKeys = decodeKeys(keys)
+	p.SpamReasons = decodeKeys(reasons)
+	return &p, nil
+}
+
 func (s *Store) InsertAutographRequest(ctx context.Context, p *AutographRequest) error {
 	now := time.Now().UTC()
 	p.CreatedAt = now
@@ -745,11 +721,15 @@ func (s *Store) InsertAutographRequest(ctx context.Context, p *AutographRequest)
 		p.Status = StatusNew
 	}
 	_, err := s.db.ExecContext(ctx, `
-INSERT INTO autograph_requests(id,badge_id,name,contact,target,content,status,attachment_keys,ip_hash,created_at,updated_at)
-VALUES(?,?,?,?,?,?,?,?,?,?,?)`,
+INSERT INTO autograph_requests(id,badge_id,name,contact,target,content,status,attachment_keys,ip_hash,spam_score,spam_reasons,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?)`,
 		p.ID, p.BadgeID, p.Name, p.Contact, p.Target, p.Content, p.Status,
-		encodeKeys(p.AttachmentKeys), p.IPHash, p.CreatedAt, p.UpdatedAt)
-	return err
+		encodeKeys(p.AttachmentKeys), p.IPHash, p.SpamScore, encodeKeys(p.SpamReasons), p.CreatedAt, p.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	s.updateRequestRefs(ctx, "autograph_requests", p.ID)
+	return nil
 }
 
 func (s *Store) ListAutographRequests(ctx context.Context, badgeID, status string, limit, offset int) ([]AutographRequest, int, error) {
@@ -771,7 +751,7 @@ func (s *Store) ListAutographRequests(ctx context.Context, badgeID, status strin
 		where = "WHERE " + strings.Join(conds, " AND ")
 	}
 	rows, err := s.db.QueryContext(ctx, `
-SELECT id,badge_id,name,contact,target,content,status,attachment_keys,ip_hash,created_at,updated_at
+SELECT `+autographColumns+`
 FROM autograph_requests `+where+` ORDER BY updated_at DESC LIMIT ? OFFSET ?`,
 		append(args, limit, offset)...)
 	if err != nil {
@@ -780,14 +760,11 @@ FROM autograph_requests `+where+` ORDER BY updated_at DESC LIMIT ? OFFSET ?`,
 	defer rows.Close()
 	var out []AutographRequest
 	for rows.Next() {
-		var p AutographRequest
-		var keys string
-		if err := rows.Scan(&p.ID, &p.BadgeID, &p.Name, &p.Contact, &p.Target, &p.Content,
-			&p.Status, &keys, &p.IPHash, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		p, err := scanAutographRequest(rows)
+		if err != nil {
 			return nil, 0, err
 		}
-		p.AttachmentKeys = decodeKeys(keys)
-		out = append(out, p)
+		out = append(out, *p)
 	}
 	var total int
 	if err := s.db.QueryRowContext(ctx, `SELECT count(1) FROM autograph_requests `+where, args...).Scan(&total); err != nil {
@@ -796,6 +773,14 @@ FROM autograph_requests `+where+` ORDER BY updated_at DESC LIMIT ? OFFSET ?`,
 	return out, total, nil
 }
 
+func (s *Store) GetAutographRequest(ctx context.Context, id string) (*AutographRequest, error) {
+	p, err := scanAutographRequest(s.db.QueryRowContext(ctx, `SELECT `+autographColumns+` FROM autograph_requests WHERE id=?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return p, err
+}
+
 func (s *Store) UpdateAutographStatus(ctx context.Context, id, status string) error {
 	r, err := s.db.ExecContext(ctx,
 		`UPDATE autograph_requests SET status=?, updated_at=? WHERE id=?`,
@@ -806,9 +791,24 @@ func (s *Store) UpdateAutographStatus(ctx context.Context, id, status string) er
 	if n, _ := r.RowsAffected(); n == 0 {
 		return ErrNotFound
 	}
+	s.updateRequestRefs(ctx, "autograph_requests", id)
 	return nil
 }
 
+// RequestIPHash returns the submitter ip_hash of a photo or autograph
+// request; table is "photo_requests" or "autograph_requests".
+func (s *Store) RequestIPHash(ctx context.Context, table, id string) (string, error) {
+	if table != "photo_requests" && table != "autograph_requests" {
+		return "", fmt.Errorf("unknown request table %q", table)
+	}
+	var h string
+	err := s.db.QueryRowContext(ctx, `SELECT ip_hash FROM `+table+` WHERE id=?`, id).Scan(&h)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return h, err
+}
+
 // ----- NFC Writes -----
 
 func (s *Store) InsertNFCWrite(ctx context.Context, w *NFCWrite) error {
@@ -824,5 +824,9 @@ func (s *Store) InsertNFCWrite(ctx context.Context, w *NFCWrite) error {
 INSERT INTO nfc_writes(id,badge_id,tag_uid,ndef_url,device_id,write_status,photo_object_key,written_at,created_at)
 VALUES(?,?,?,?,?,?,?,?,?)`,
 		w.ID, w.BadgeID, w.TagUID, w.NDEFURL, w.DeviceID, w.WriteStatus, w.PhotoObjectKey, w.WrittenAt, w.CreatedAt)
-	return err
+	if err != nil {
+		return err
+	}
+	s.updateObjectRefs(ctx, "nfc_writes/"+w.ID, w.PhotoObjectKey)
+	return nil
 }
@@ -23,12 +23,45 @@ CREATE TABLE IF NOT EXISTS admin_passkeys (
     name       TEXT NOT NULL DEFAULT '',
     public_key BLOB NOT NULL,
     counter    INTEGER NOT NULL DEFAULT 0,
-    created_at DATETIME NOT NULL
+    created_at DATETIME NOT NULL,
+    backup_eligible INTEGER NOT NULL DEFAULT 0,
+    backup_state    INTEGER NOT NULL DEFAULT 0,
+    flagged         TEXT NOT NULL DEFAULT '',
+    aaguid          TEXT NOT NULL DEFAULT '',
+    attestation     TEXT NOT NULL DEFAULT ''
 );
 CREATE INDEX IF NOT EXISTS idx_passkeys_username ON admin_passkeys(username);
+CREATE TABLE IF NOT EXISTS admin_credentials (
+    username      TEXT PRIMARY KEY,
+    password_hash TEXT NOT NULL,
+    must_change   INTEGER NOT NULL DEFAULT 0,
+    updated_at    DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS admin_login_attempts (
+    key          TEXT PRIMARY KEY,
+    failures     INTEGER NOT NULL DEFAULT 0,
+    last_failure DATETIME NOT NULL,
+    locked_until DATETIME
+);
 `
-	_, err := s.db.Exec(ddl)
-	return err
+	if _, err := s.db.Exec(ddl); err != nil {
+		return err
+	}
+	for _, col := range passkeyColumns {
+		if err := s.ensureColumn("admin_passkeys", col[0], col[1]); err != nil {
+			return err
+		}
+	}
+	return s.ensureColumn("admin_credentials", "sessions_valid_after", "INTEGER NOT NULL DEFAULT 0")
+}
+
+// passkeyColumns were added after admin_passkeys first shipped.
+var passkeyColumns = [][2]string{
+	{"backup_eligible", "INTEGER NOT NULL DEFAULT 0"},
+	{"backup_state", "INTEGER NOT NULL DEFAULT 0"},
+	{"flagged", "TEXT NOT NULL DEFAULT ''"},
+	{"aaguid", "TEXT NOT NULL DEFAULT ''"},
+	{"attestation", "TEXT NOT NULL DEFAULT ''"},
 }
 
 func (s *Store) GetTOTP(username string) (string, bool, error) {
@@ -59,9 +92,61 @@ ON CONFLICT(username) DO UPDATE SET
 	return err
 }
 
+// GetAdminPassword implements authflow.PasswordStore.
+func (s *Store) GetAdminPassword(username string) (string, bool, error) {
+	var hash string
+	var must int
+	err := s.db.QueryRowContext(context.Background(),
+		`SELECT password_hash, must_change FROM admin_credentials WHERE username=?`, username).
+		Scan(&hash, &must)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return hash, must == 1, nil
+}
+
+func (s *Store) SetAdminPassword(username, hash string, mustChange bool) error {
+	m := 0
+	if mustChange {
+		m = 1
+	}
+	_, err := s.db.ExecContext(context.Background(), `
+INSERT INTO admin_credentials(username, password_hash, must_change, updated_at) VALUES(?, ?, ?, ?)
+ON CONFLICT(username) DO UPDATE SET
+  password_hash=excluded.password_hash, must_change=excluded.must_change, updated_at=excluded.updated_at`,
+		username, hash, m, time.Now().UTC())
+	return err
+}
+
+// GetSessionsValidAfter implements authflow.SessionStore.
+func (s *Store) GetSessionsValidAfter(username string) (time.Time, error) {
+	var unix int64
+	err := s.db.QueryRowContext(context.Background(),
+		`SELECT sessions_valid_after FROM admin_credentials WHERE username=?`, username).Scan(&unix)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	if unix == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(unix, 0), nil
+}
+
+func (s *Store) SetSessionsValidAfter(username string, t time.Time) error {
+	_, err := s.db.ExecContext(context.Background(),
+		`UPDATE admin_credentials SET sessions_valid_after=? WHERE username=?`, t.Unix(), username)
+	return err
+}
+
 func (s *Store) GetCredentials(username string) ([]authflow.Credential, error) {
 	rows, err := s.db.QueryContext(context.Background(),
-		`SELECT id, username, name, public_key, counter, created_at
+		`SELECT id, username, name, public_key, counter, created_at, backup_eligible, backup_state, flagged, aaguid, attestation
 		 FROM admin_passkeys WHERE username=? ORDER BY created_at`, username)
 	if err != nil {
 		return nil, err
@@ -70,7 +155,8 @@ func (s *Store) GetCredentials(username string) ([]authflow.Credential, error) {
 	var out []authflow.Credential
 	for rows.Next() {
 		var c authflow.Credential
-		if err := rows.Scan(&c.ID, &c.Username, &c.Name, &c.PublicKey, &c.Counter, &c.CreatedAt); err != nil {
+		if err := rows.Scan(&c.ID, &c.Username, &c.Name, &c.PublicKey, &c.Counter, &c.CreatedAt,
+			&c.BackupEligible, &c.BackupState, &c.Flagged, &c.AAGUID, &c.AttestationType); err != nil {
 			return nil, err
 		}
 		out = append(out, c)
@@ -80,9 +166,11 @@ func (s *Store) GetCredentials(username string) ([]authflow.Credential, error) {
 
 func (s *Store) SaveCredential(c *authflow.Credential) error {
 	_, err := s.db.ExecContext(context.Background(),
-		`INSERT INTO admin_passkeys(id, username, name, public_key, counter, created_at)
-		 VALUES(?, ?, ?, ?, ?, ?)`,
-		c.ID, c.Username, c.Name, c.PublicKey, c.Counter, c.CreatedAt)
+		`INSERT INTO admin_passkeys(id, username, name, public_key, counter, created_at,
+		   backup_eligible, backup_state, aaguid, attestation)
+		 VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.ID, c.Username, c.Name, c.PublicKey, c.Counter, c.CreatedAt,
+		c.BackupEligible, c.BackupState, c.AAGUID, c.AttestationType)
 	return err
 }
 
@@ -92,15 +180,22 @@ func (s *Store) DeleteCredential(username, credID string) error {
 	return err
 }
 
-func (s *Store) UpdateCounter(credID string, counter uint32) error {
+func (s *Store) UpdateSignState(credID string, counter uint32, backupState bool) error {
+	_, err := s.db.ExecContext(context.Background(),
+		`UPDATE admin_passkeys SET counter=?, backup_state=? WHERE id=?`, counter, backupState, credID)
+	return err
+}
+
+func (s *Store) FlagCredential(credID, reason string) error {
 	_, err := s.db.ExecContext(context.Background(),
-		`UPDATE admin_passkeys SET counter=? WHERE id=?`, counter, credID)
+		`UPDATE admin_passkeys SET flagged=? WHERE id=?`, reason, credID)
 	return err
 }
 
 func (s *Store) ListCredentials(username string) ([]authflow.CredentialInfo, error) {
 	rows, err := s.db.QueryContext(context.Background(),
-		`SELECT id, name, created_at FROM admin_passkeys WHERE username=? ORDER BY created_at`, username)
+		`SELECT id, name, created_at, backup_eligible, backup_state, flagged, aaguid, attestation
+		 FROM admin_passkeys WHERE username=? ORDER BY created_at`, username)
 	if err != nil {
 		return nil, err
 	}
@@ -108,10 +203,89 @@ func (s *Store) ListCredentials(username string) ([]authflow.CredentialInfo, err
 	var out []authflow.CredentialInfo
 	for rows.Next() {
 		var c authflow.CredentialInfo
-		if err := rows.Scan(&c.ID, &c.Name, &c.CreatedAt); err != nil {
+		if err := rows.Scan(&c.ID, &c.Name, &c.CreatedAt, &c.BackupEligible, &c.BackupState, &c.Flagged,
+			&c.AAGUID, &c.AttestationType); err != nil {
 			return nil, err
 		}
 		out = append(out, c)
 	}
 	return out, nil
 }
+
+func (s *Store) GetLoginAttempt(key string) (*authflow.LoginAttempt, error) {
+	var a authflow.LoginAttempt
+	var locked sql.NullTime
+	err := s.db.QueryRowContext(context.Background(),
+		`SELECT key, failures, last_failure, locked_until FROM admin_login_attempts WHERE key=?`, key).
+		Scan(&a.Key, &a.Failures, &a.LastFailure, &locked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if locked.Valid {
+		a.LockedUntil = locked.Time
+	}
+	return &a, nil
+}
+
+// UpdateLoginAttempt holds a write lock (BEGIN IMMEDIATE) from the read to
+// the write, so concurrent logins, also from other processes on the same
+// file, cannot both pass the lockout check.
+func (s *Store) UpdateLoginAttempt(key string, fn func(a *authflow.LoginAttempt) bool) (*authflow.LoginAttempt, error) {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// wait for another process's write lock instead of failing right away
+	if _, err := conn.ExecContext(ctx, `PRAGMA busy_timeout = 5000`); err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		return nil, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_, _ = conn.ExecContext(ctx, `ROLLBACK`)
+		}
+	}()
+	a := authflow.LoginAttempt{Key: key}
+	var locked sql.NullTime
+	err = conn.QueryRowContext(ctx,
+		`SELECT failures, last_failure, locked_until FROM admin_login_attempts WHERE key=?`, key).
+		Scan(&a.Failures, &a.LastFailure, &locked)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if locked.Valid {
+		a.LockedUntil = locked.Time
+	}
+	if fn(&a) {
+		var lockedUntil any
+		if !a.LockedUntil.IsZero() {
+			lockedUntil = a.LockedUntil.UTC()
+		}
+		if _, err := conn.ExecContext(ctx, `
+INSERT INTO admin_login_attempts(key, failures, last_failure, locked_until) VALUES(?, ?, ?, ?)
+ON CONFLICT(key) DO UPDATE SET
+  failures=excluded.failures, last_failure=excluded.last_failure, locked_until=excluded.locked_until`,
+			key, a.Failures, a.LastFailure.UTC(), lockedUntil); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := conn.ExecContext(ctx, `COMMIT`); err != nil {
+		return nil, err
+	}
+	committed = true
+	return &a, nil
+}
+
+func (s *Store) DeleteLoginAttempt(key string) error {
+	_, err := s.db.ExecContext(context.Background(),
+		`DELETE FROM admin_login_attempts WHERE key=?`, key)
+	return err
+}
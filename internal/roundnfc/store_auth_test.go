@@ -0,0 +1,72 @@
+package roundnfc
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"backend-go/internal/authflow"
+)
+
+// Two handles on one file stand in for two replicas.
+func TestUpdateLoginAttemptAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roundnfc.db")
+	var stores []*Store
+	for i := 0; i < 2; i++ {
+		s, err := openStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = s.Close() })
+		stores = append(stores, s)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(s *Store) {
+			defer wg.Done()
+			_, err := s.UpdateLoginAttempt("ip:1.2.3.4", func(a *authflow.LoginAttempt) bool {
+				a.Failures++
+				a.LastFailure = time.Now()
+				return true
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(stores[i%2])
+	}
+	wg.Wait()
+	a, err := stores[0].GetLoginAttempt("ip:1.2.3.4")
+	if err != nil || a == nil || a.Failures != 20 {
+		t.Fatalf("failures = %+v, %v", a, err)
+	}
+}
+
+func TestSessionsValidAfter(t *testing.T) {
+	s, err := openStore(filepath.Join(t.TempDir(), "roundnfc.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	if at, err := s.GetSessionsValidAfter("admin"); err != nil || !at.IsZero() {
+		t.Fatalf("no row: %v %v", at, err)
+	}
+	if err := s.SetAdminPassword("admin", "hash", false); err != nil {
+		t.Fatal(err)
+	}
+	if at, err := s.GetSessionsValidAfter("admin"); err != nil || !at.IsZero() {
+		t.Fatalf("unset: %v %v", at, err)
+	}
+	now := time.Now()
+	if err := s.SetSessionsValidAfter("admin", now); err != nil {
+		t.Fatal(err)
+	}
+	// a later password write (e.g. a rehash on login) keeps the cutoff
+	if err := s.SetAdminPassword("admin", "rehashed", false); err != nil {
+		t.Fatal(err)
+	}
+	if at, err := s.GetSessionsValidAfter("admin"); err != nil || at.Unix() != now.Unix() {
+		t.Fatalf("cutoff = %v %v", at, err)
+	}
+}
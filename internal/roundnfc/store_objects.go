@@ -0,0 +1,368 @@
+package roundnfc
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ----- Object registry -----
+//
+// stored_objects lists every object the module wrote (ingested images,
+// presigned app uploads); object_refs records which row points at which
+// key, owner being "<table>/<id>". Objects without refs are orphans and
+// are deleted by the GC once they have not been seen for the grace period.
+
+// Which store an object lives in, see Service.storeFor.
+const (
+	bucketObjects = "objects"
+	bucketUploads = "uploads"
+)
+
+// StoredObject is one registry entry.
+type StoredObject struct {
+	Key       string    `json:"key"`
+	Bucket    string    `json:"bucket"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+	SeenAt    time.Time `json:"seenAt"`
+}
+
+// PrefixUsage is the storage used under one key prefix.
+type PrefixUsage struct {
+	Prefix          string `json:"prefix"`
+	Objects         int    `json:"objects"`
+	Bytes           int64  `json:"bytes"`
+	Orphans         int    `json:"orphans"`
+	OrphanBytes     int64  `json:"orphanBytes"`
+	ReferencedBytes int64  `json:"referencedBytes"`
+}
+
+// objectPrefixes are the key prefixes the module writes to; usage is
+// reported per prefix, anything else under its first path segment.
+var objectPrefixes = []string{"uploads", "badges", "style-templates", "roundnfc/coser-photos", "roundnfc/nfc-writes", originalsPrefix}
+
+func (s *Store) migrateObjects() error {
+	const ddl = `
+CREATE TABLE IF NOT EXISTS stored_objects (
+  key        TEXT PRIMARY KEY,
+  bucket     TEXT NOT NULL DEFAULT 'objects',
+  size       INTEGER NOT NULL DEFAULT 0,
+  created_at DATETIME NOT NULL,
+  seen_at    DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_stored_objects_seen ON stored_objects(seen_at);
+CREATE TABLE IF NOT EXISTS object_refs (
+  object_key TEXT NOT NULL,
+  owner      TEXT NOT NULL,
+  PRIMARY KEY (object_key, owner)
+);
+CREATE INDEX IF NOT EXISTS idx_object_refs_owner ON object_refs(owner);
+CREATE TABLE IF NOT EXISTS image_variants (
+  key        TEXT PRIMARY KEY,
+  source_key TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_image_variants_source ON image_variants(source_key);
+`
+	if _, err := s.db.Exec(ddl); err != nil {
+		return err
+	}
+	return s.RebuildObjectRefs(context.Background())
+}
+
+// isObjectKey reports whether v is a key in our storage rather than an
+// external URL or a static path (seeded templates, hand-entered badges).
+func isObjectKey(v string) bool {
+	return v != "" && !isAbsoluteURL(v) && !strings.HasPrefix(v, "/") && !strings.Contains(v, "..")
+}
+
+// RegisterObject records an object written to bucket. Registering an
+// existing key (content-addressed re-upload) refreshes seen_at so the GC
+// does not delete it before the new owner row is saved.
+func (s *Store) RegisterObject(ctx context.Context, bucket, key string, size int64) error {
+	if !isObjectKey(key) {
+		return nil
+	}
+	now := time.Now().UTC()
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO stored_objects(key,bucket,size,created_at,seen_at) VALUES(?,?,?,?,?)
+ON CONFLICT(key) DO UPDATE SET
+  size=CASE WHEN excluded.size > 0 THEN excluded.size ELSE stored_objects.size END,
+  seen_at=excluded.seen_at`,
+		key, bucket, size, now, now)
+	return err
+}
+
+// setObjectRefs replaces the keys owner points at.
+func (s *Store) setObjectRefs(ctx context.Context, owner string, keys ...string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM object_refs WHERE owner=?`, owner); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if !isObjectKey(k) {
+			continue
+		}
+		// a kept original lives and dies with the stripped object
+		for _, key := range []string{k, originalKey(k)} {
+			if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO object_refs(object_key,owner) VALUES(?,?)`, key, owner); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// updateObjectRefs is setObjectRefs for the write paths: the owner row is
+// already saved, so a failure is only logged and fixed by the next
+// RebuildObjectRefs.
+func (s *Store) updateObjectRefs(ctx context.Context, owner string, keys ...string) {
+	if err := s.setObjectRefs(ctx, owner, keys...); err != nil {
+		log.Printf("[roundnfc] object refs owner=%s: %v", owner, err)
+	}
+}
+
+// updateRequestRefs points a photo / autograph request at its attachments;
+// rejected requests release them.
+func (s *Store) updateRequestRefs(ctx context.Context, table, id string) {
+	var status, keys string
+	err := s.db.QueryRowContext(ctx, `SELECT status, attachment_keys FROM `+table+` WHERE id=?`, id).Scan(&status, &keys)
+	if err != nil {
+		log.Printf("[roundnfc] object refs owner=%s/%s: %v", table, id, err)
+		return
+	}
+	if status == StatusRejected {
+		keys = ""
+	}
+	s.updateObjectRefs(ctx, table+"/"+id, decodeKeys(keys)...)
+}
+
+// RebuildObjectRefs recomputes object_refs from the owning tables. Runs on
+// startup (backfill for databases older than the registry) and before each
+// GC pass, so a missed incremental update can never get an object deleted.
+// The owners are read under the same write lock (BEGIN IMMEDIATE) that
+// replaces the refs, so a row saved mid-rebuild cannot lose its refs.
+func (s *Store) RebuildObjectRefs(ctx context.Context) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// wait for another process's write lock instead of failing right away
+	if _, err := conn.ExecContext(ctx, `PRAGMA busy_timeout = 5000`); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_, _ = conn.ExecContext(context.Background(), `ROLLBACK`)
+		}
+	}()
+
+	type ref struct{ key, owner string }
+	var refs []ref
+	collect := func(query, table string, multi bool) error {
+		rows, err := conn.QueryContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id, v string
+			if err := rows.Scan(&id, &v); err != nil {
+				return err
+			}
+			keys := []string{v}
+			if multi {
+				keys = decodeKeys(v)
+			}
+			for _, k := range keys {
+				if isObjectKey(k) {
+					refs = append(refs, ref{k, table + "/" + id}, ref{originalKey(k), table + "/" + id})
+				}
+			}
+		}
+		return rows.Err()
+	}
+	sources := []struct {
+		query, table string
+		multi        bool
+	}{
+		{`SELECT id, image_url FROM badges`, "badges", false},
+		{`SELECT key, image_url FROM badge_style_templates`, "badge_style_templates", false},
+		{`SELECT badge_id, photo_object_key FROM badge_coser_bindings`, "badge_coser_bindings", false},
+		{`SELECT id, photo_object_key FROM nfc_writes`, "nfc_writes", false},
+		{`SELECT id, attachment_keys FROM photo_requests WHERE status<>'` + StatusRejected + `'`, "photo_requests", true},
+		{`SELECT id, attachment_keys FROM autograph_requests WHERE status<>'` + StatusRejected + `'`, "autograph_requests", true},
+	}
+	for _, src := range sources {
+		if err := collect(src.query, src.table, src.multi); err != nil {
+			return err
+		}
+	}
+	if _, err := conn.ExecContext(ctx, `DELETE FROM object_refs`); err != nil {
+		return err
+	}
+	for _, r := range refs {
+		if _, err := conn.ExecContext(ctx, `INSERT OR IGNORE INTO object_refs(object_key,owner) VALUES(?,?)`, r.key, r.owner); err != nil {
+			return err
+		}
+	}
+	if _, err := conn.ExecContext(ctx, `COMMIT`); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// OrphanObjects returns registered objects without refs last seen before
+// cutoff, oldest first.
+func (s *Store) OrphanObjects(ctx context.Context, cutoff time.Time, limit int) ([]StoredObject, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT o.key,o.bucket,o.size,o.created_at,o.seen_at FROM stored_objects o
+WHERE o.seen_at < ? AND NOT EXISTS (SELECT 1 FROM object_refs r WHERE r.object_key=o.key)
+ORDER BY o.seen_at, o.key LIMIT ?`, cutoff.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []StoredObject
+	for rows.Next() {
+		var o StoredObject
+		if err := rows.Scan(&o.Key, &o.Bucket, &o.Size, &o.CreatedAt, &o.SeenAt); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// ObjectKeysAfter lists registered or referenced keys greater than after,
+// in key order. Keys only known from refs (written before the registry)
+// get the bucket their prefix implies.
+func (s *Store) ObjectKeysAfter(ctx context.Context, after string, limit int) ([]StoredObject, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT key, bucket FROM stored_objects WHERE key > ?
+UNION
+SELECT object_key, CASE WHEN object_key LIKE 'roundnfc/%' THEN 'uploads' ELSE 'objects' END FROM object_refs
+WHERE object_key > ? AND object_key NOT IN (SELECT key FROM stored_objects)
+ORDER BY 1 LIMIT ?`, after, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []StoredObject
+	for rows.Next() {
+		var o StoredObject
+		if err := rows.Scan(&o.Key, &o.Bucket); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// AddImageVariant records key as a cached variant of src.
+func (s *Store) AddImageVariant(ctx context.Context, src, key string) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO image_variants(key,source_key) VALUES(?,?)
+ON CONFLICT(key) DO UPDATE SET source_key=excluded.source_key`, key, src)
+	return err
+}
+
+// TakeImageVariants forgets the variants of src and returns their keys.
+func (s *Store) TakeImageVariants(ctx context.Context, src string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `DELETE FROM image_variants WHERE source_key=? RETURNING key`, src)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// forgetOrphan drops key from the registry if it is still an orphan last
+// seen before cutoff; only then may the caller delete the object itself.
+func (s *Store) forgetOrphan(ctx context.Context, key string, cutoff time.Time) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+DELETE FROM stored_objects WHERE key=? AND seen_at < ?
+AND NOT EXISTS (SELECT 1 FROM object_refs r WHERE r.object_key=stored_objects.key)`, key, cutoff.UTC())
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ObjectUsage sums registered objects per prefix (see objectPrefixes).
+func (s *Store) ObjectUsage(ctx context.Context) ([]PrefixUsage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT o.key, o.size, EXISTS (SELECT 1 FROM object_refs r WHERE r.object_key=o.key)
+FROM stored_objects o`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byPrefix := map[string]*PrefixUsage{}
+	for rows.Next() {
+		var (
+			key        string
+			size       int64
+			referenced bool
+		)
+		if err := rows.Scan(&key, &size, &referenced); err != nil {
+			return nil, err
+		}
+		p := objectPrefix(key)
+		u, ok := byPrefix[p]
+		if !ok {
+			u = &PrefixUsage{Prefix: p}
+			byPrefix[p] = u
+		}
+		u.Objects++
+		u.Bytes += size
+		if referenced {
+			u.ReferencedBytes += size
+		} else {
+			u.Orphans++
+			u.OrphanBytes += size
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	out := make([]PrefixUsage, 0, len(byPrefix))
+	for _, u := range byPrefix {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Prefix < out[j].Prefix })
+	return out, nil
+}
+
+func objectPrefix(key string) string {
+	for _, p := range objectPrefixes {
+		if strings.HasPrefix(key, p+"/") {
+			return p
+		}
+	}
+	if i := strings.Index(key, "/"); i > 0 {
+		return key[:i]
+	}
+	return ""
+}
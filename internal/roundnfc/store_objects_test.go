@@ -0,0 +1,118 @@
+package roundnfc
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"backend-go/pkg/objstore"
+)
+
+func TestCollectOrphans(t *testing.T) {
+	store, err := openStore(filepath.Join(t.TempDir(), "roundnfc.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	objects, err := objstore.NewLocal(t.TempDir(), []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &Service{cfg: Config{GCGrace: time.Hour}, store: store, objects: objects}
+	ctx := context.Background()
+
+	put := func(key string) {
+		if _, err := objects.Put(ctx, key, strings.NewReader("data"), "image/png"); err != nil {
+			t.Fatal(err)
+		}
+		svc.registerObject(ctx, bucketObjects, key, 4)
+	}
+	for _, k := range []string{"badges/b1/old.png", "badges/b1/new.png", "uploads/aa/att.png", "uploads/bb/fresh.png", "style-templates/t/x.png"} {
+		put(k)
+	}
+	// cached variants go with their source
+	variants := map[string]string{"image-variants/aa/old-w100.jpg": "badges/b1/old.png", "image-variants/bb/new-w100.jpg": "badges/b1/new.png"}
+	for k, src := range variants {
+		if _, err := objects.Put(ctx, k, strings.NewReader("variant"), "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.AddImageVariant(ctx, src, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// everything but fresh.png is past the grace period
+	if _, err := store.db.Exec(`UPDATE stored_objects SET seen_at=? WHERE key<>'uploads/bb/fresh.png'`, time.Now().Add(-2*time.Hour).UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.UpsertBadge(ctx, &Badge{ID: "b1", ImageURL: "badges/b1/old.png"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpsertBadge(ctx, &Badge{ID: "b1", ImageURL: "badges/b1/new.png"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpsertBadge(ctx, &Badge{ID: "b2", ImageURL: "https://cdn.example.com/x.png"}); err != nil {
+		t.Fatal(err)
+	}
+	p := &PhotoRequest{ID: "p1", BadgeID: "b1", Name: "n", Contact: "c", AttachmentKeys: []string{"uploads/aa/att.png"}}
+	if err := store.InsertPhotoRequest(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	orphanKeys := func(dryRun bool) []string {
+		t.Helper()
+		rep, err := svc.CollectOrphans(ctx, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, o := range rep.Objects {
+			keys = append(keys, o.Key)
+		}
+		return keys
+	}
+	if got := strings.Join(orphanKeys(true), ","); got != "badges/b1/old.png,style-templates/t/x.png" {
+		t.Fatalf("dry run: %s", got)
+	}
+	if _, ok, _ := objects.Stat(ctx, "badges/b1/old.png"); !ok {
+		t.Fatal("dry run deleted an object")
+	}
+
+	// rejecting the request releases its attachment
+	if err := store.UpdatePhotoStatus(ctx, "p1", StatusRejected); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(orphanKeys(false), ","); got != "badges/b1/old.png,style-templates/t/x.png,uploads/aa/att.png" {
+		t.Fatalf("collected: %s", got)
+	}
+	for k, want := range map[string]bool{
+		"badges/b1/old.png": false, "uploads/aa/att.png": false, "image-variants/aa/old-w100.jpg": false,
+		"badges/b1/new.png": true, "uploads/bb/fresh.png": true, "image-variants/bb/new-w100.jpg": true,
+	} {
+		if _, ok, _ := objects.Stat(ctx, k); ok != want {
+			t.Fatalf("%s exists=%v, want %v", k, ok, want)
+		}
+	}
+
+	usage, err := store.ObjectUsage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 2 || usage[0].Prefix != "badges" || usage[0].Objects != 1 || usage[0].Orphans != 0 ||
+		usage[1].Prefix != "uploads" || usage[1].Orphans != 1 || usage[1].OrphanBytes != 4 {
+		t.Fatalf("usage: %+v", usage)
+	}
+
+	// deleting the badge releases its image once the grace period is over
+	if err := store.DeleteBadge(ctx, "b1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.db.Exec(`UPDATE stored_objects SET seen_at=?`, time.Now().Add(-2*time.Hour).UTC()); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(orphanKeys(false), ","); got != "badges/b1/new.png,uploads/bb/fresh.png" {
+		t.Fatalf("after badge delete: %s", got)
+	}
+}
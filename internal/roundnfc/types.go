@@ -12,8 +12,10 @@ type Badge struct {
 	Type                  string             `json:"type,omitempty"`
 	StyleKey              string             `json:"styleKey,omitempty"`
 	ImageURL              string             `json:"imageUrl,omitempty"`
+	ImageSrcset           string             `json:"imageSrcset,omitempty"`
 	StyleImageURL         string             `json:"styleImageUrl,omitempty"`
 	StyleImageOriginalURL string             `json:"styleImageOriginalUrl,omitempty"`
+	StyleImageSrcset      string             `json:"styleImageSrcset,omitempty"`
 	Description           string             `json:"description,omitempty"`
 	SerialNo              string             `json:"serialNo,omitempty"`
 	ReleasedAt            string             `json:"releasedAt,omitempty"`
@@ -29,6 +31,7 @@ type BadgeStyleTemplate struct {
 	ImageURL         string          `json:"imageUrl,omitempty"`
 	ImageOriginalURL string          `json:"imageOriginalUrl,omitempty"`
 	ImagePreviewURL  string          `json:"imagePreviewUrl,omitempty"`
+	ImageSrcset      string          `json:"imageSrcset,omitempty"`
 	Payload          json.RawMessage `json:"payload,omitempty"`
 	Enabled          bool            `json:"enabled"`
 	CreatedAt        time.Time       `json:"createdAt,omitempty"`
@@ -63,6 +66,9 @@ const (
 	StatusNew      = "new"
 	StatusHandled  = "handled"
 	StatusRejected = "rejected"
+	// StatusQuarantined is set on creation when the spam score reaches
+	// ROUNDNFC_SPAM_THRESHOLD; admins release it to new or reject it.
+	StatusQuarantined = "quarantined"
 )
 
 type PhotoRequest struct {
@@ -74,6 +80,8 @@ type PhotoRequest struct {
 	Status         string    `json:"status"`
 	AttachmentKeys []string  `json:"attachmentKeys,omitempty"`
 	IPHash         string    `json:"-"`
+	SpamScore      float64   `json:"spamScore"`
+	SpamReasons    []string  `json:"spamReasons,omitempty"`
 	CreatedAt      time.Time `json:"createdAt"`
 	UpdatedAt      time.Time `json:"updatedAt"`
 }
@@ -88,6 +96,8 @@ type AutographRequest struct {
 	Status         string    `json:"status"`
 	AttachmentKeys []string  `json:"attachmentKeys,omitempty"`
 	IPHash         string    `json:"-"`
+	SpamScore      float64   `json:"spamScore"`
+	SpamReasons    []string  `json:"spamReasons,omitempty"`
 	CreatedAt      time.Time `json:"createdAt"`
 	UpdatedAt      time.Time `json:"updatedAt"`
 }
@@ -104,17 +114,6 @@ type NFCWrite struct {
 	CreatedAt      time.Time `json:"createdAt"`
 }
 
-type AppToken struct {
-	ID          string     `json:"id"`
-	Name        string     `json:"name"`
-	Purpose     string     `json:"purpose"`
-	TokenPrefix string     `json:"tokenPrefix"`
-	Enabled     bool       `json:"enabled"`
-	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
-	CreatedAt   time.Time  `json:"createdAt"`
-	UpdatedAt   time.Time  `json:"updatedAt"`
-}
-
 type AppPairingConfig struct {
 	Protocol    string            `json:"protocol"`
 	Version     int               `json:"version"`
@@ -0,0 +1,146 @@
+package roundnfc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"backend-go/internal/auth/apitoken"
+	"backend-go/pkg/objstore"
+	"backend-go/pkg/tus"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Resumable uploads (tus 1.0) for clients on flaky networks. Chunks are
+// stored as part objects under tusPartPrefix until the last byte arrives;
+// the assembled file then goes through the same sniffing and
+// content-addressed naming as IngestImage.
+//
+//	<prefix>/uploads/tus      public attachments, same as POST /uploads
+//	<prefix>/app/uploads/tus  app photos (Upload-Metadata badgeId, purpose),
+//	                          same keys as the presigned app uploads
+//
+// GET <endpoint>/:id returns the ingested object once the upload is complete.
+
+const tusPartPrefix = "roundnfc/tus-parts"
+
+// Sniffed types accepted on the app endpoint; like uploadExt, NFC write
+// photos are JPEG only.
+var (
+	nfcWriteMIME   = map[string]string{"image/jpeg": ".jpg"}
+	coserPhotoMIME = map[string]string{"image/jpeg": ".jpg", "image/png": ".png", "image/webp": ".webp"}
+)
+
+type tusResult struct {
+	Key       string `json:"key,omitempty"`
+	ObjectKey string `json:"objectKey,omitempty"`
+	MIME      string `json:"mime"`
+	Size      int64  `json:"size"`
+}
+
+func (s *Service) newTusServer(table string, store objstore.Storage, basePath string,
+	onCreate func(context.Context, *tus.Upload) error,
+	onComplete func(context.Context, *tus.Upload, io.Reader) (*tusResult, error)) (*tus.Server, error) {
+	return tus.New(s.store.db, table, tus.Config{
+		Storage:    store,
+		PartPrefix: tusPartPrefix,
+		BasePath:   basePath,
+		MaxSize:    s.cfg.MaxUploadBytes,
+		Expiration: s.cfg.TusExpiration,
+		OnCreate:   onCreate,
+		OnComplete: func(ctx context.Context, u *tus.Upload, r io.Reader) ([]byte, error) {
+			res, err := onComplete(ctx, u, r)
+			switch {
+			case errors.Is(err, ErrTooLarge):
+				return nil, &tus.Error{Status: http.StatusRequestEntityTooLarge, Message: "file too large"}
+			case errors.Is(err, ErrUnsupportedMedia):
+				return nil, &tus.Error{Status: http.StatusUnsupportedMediaType, Message: "unsupported media"}
+			case err != nil:
+				return nil, err
+			}
+			return json.Marshal(res)
+		},
+	})
+}
+
+// mountTus registers the public and app tus endpoints. create is the
+// middleware chain for upload creation on the public endpoint (rate limit,
+// captcha); PATCH / HEAD / DELETE are addressed by the unguessable upload id.
+func (s *Service) mountTus(public, app *gin.RouterGroup, prefix string, create ...gin.HandlerFunc) error {
+	pub, err := s.newTusServer("tus_uploads", s.objects, prefix+"/uploads/tus", nil,
+		func(ctx context.Context, _ *tus.Upload, r io.Reader) (*tusResult, error) {
+			key, mime, size, err := s.ingest(ctx, s.objects, "uploads", r, allowedImageMIME)
+			if err != nil {
+				return nil, err
+			}
+			return &tusResult{Key: key, MIME: mime, Size: size}, nil
+		})
+	if err != nil {
+		return err
+	}
+	mountTusServer(public.Group("/uploads/tus"), pub, prefix+"/uploads/tus", create...)
+
+	if s.uploads == nil {
+		return nil
+	}
+	appSrv, err := s.newTusServer("tus_app_uploads", s.uploads, prefix+"/app/uploads/tus",
+		func(_ context.Context, u *tus.Upload) error {
+			badgeID := safePathSegment(u.Metadata["badgeId"])
+			if badgeID == "" {
+				return &tus.Error{Status: http.StatusBadRequest, Message: "badgeId required"}
+			}
+			u.Metadata["badgeId"] = badgeID
+			return nil
+		},
+		func(ctx context.Context, u *tus.Upload, r io.Reader) (*tusResult, error) {
+			purpose := u.Metadata["purpose"]
+			allowed := nfcWriteMIME
+			if isCoserPhotoPurpose(purpose) {
+				allowed = coserPhotoMIME
+			}
+			key, mime, size, err := s.ingest(ctx, s.uploads, appUploadPrefix(u.Metadata["badgeId"], purpose), r, allowed)
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("[roundnfc/app] tus upload complete id=%s objectKey=%q size=%d", u.ID, key, size)
+			return &tusResult{ObjectKey: key, MIME: mime, Size: size}, nil
+		})
+	if err != nil {
+		return err
+	}
+	mountTusServer(app.Group("/uploads/tus", apitoken.Scope(scopeUploadsCreate)), appSrv, prefix+"/app/uploads/tus")
+	return nil
+}
+
+// mountTusServer mounts srv on g. Only the collection POST creates uploads, so
+// create (rate limit, captcha) guards just that route: tus.Server ignores
+// X-HTTP-Method-Override except to turn a POST into PATCH or DELETE.
+func mountTusServer(g *gin.RouterGroup, srv *tus.Server, basePath string, create ...gin.HandlerFunc) {
+	h := gin.WrapH(http.StripPrefix(basePath, srv))
+	g.OPTIONS("", h)
+	g.POST("", append(append([]gin.HandlerFunc{}, create...), h)...)
+	for _, m := range []string{http.MethodHead, http.MethodPatch, http.MethodDelete, http.MethodOptions, http.MethodPost} {
+		// POST on an upload is a PATCH / DELETE behind X-HTTP-Method-Override
+		g.Handle(m, "/:id", h)
+	}
+	g.GET("/:id", func(c *gin.Context) {
+		u, err := srv.Get(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "internal error")
+			return
+		}
+		if u == nil {
+			respondError(c, http.StatusNotFound, "upload not found")
+			return
+		}
+		if !u.Done {
+			respondError(c, http.StatusConflict, "upload incomplete")
+			return
+		}
+		respondData(c, json.RawMessage(u.Result))
+	})
+}
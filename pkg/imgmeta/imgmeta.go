@@ -0,0 +1,456 @@
+// Package imgmeta 去掉 JPEG / PNG / WebP 里的元数据（EXIF、XMP、IPTC、
+// 注释和文本块），避免把相机序列号、GPS 坐标原样存下来再发出去。
+//
+// 只是剥离时直接改容器结构，像素数据一个字节不动；EXIF 里带了非默认的
+// 方向（Orientation ≠ 1）时先按方向把像素转正再重新编码，否则去掉 EXIF
+// 之后图就歪了。WebP 没有纯 Go 编码器，需要转正的 WebP 会改存成 JPEG
+// （带透明时 PNG），Result.MIME 是实际的格式；不能换格式时用
+// StripSameFormat。
+package imgmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/webp"
+)
+
+// ErrMalformed 表示文件结构解析不了；这种文件不应该原样存下。
+var ErrMalformed = errors.New("imgmeta: malformed image")
+
+// ErrTooLarge 表示需要转正的图像素数超过 MaxPixels，不解码。
+var ErrTooLarge = errors.New("imgmeta: image too large to reorient")
+
+// MaxPixels 是转正时允许解码的像素上限（防解压炸弹）。只剥离不转正的
+// 图不解码，不受这个限制。
+var MaxPixels = 50_000_000
+
+// reencodeQuality 是转正后重新编码 JPEG 的质量。
+const reencodeQuality = 92
+
+// Result 是 Strip 的结果。
+type Result struct {
+	Data []byte
+	MIME string
+	// Orientation 是原图 EXIF 里的方向，1 表示正常。
+	Orientation int
+	// Removed 列出去掉的元数据种类（exif、xmp、iptc、comment、text ……），
+	// 同一种只出现一次。
+	Removed []string
+	// Reencoded 表示像素按方向转正后重新编码过。
+	Reencoded bool
+}
+
+// Changed 报告输出和输入是否不同。
+func (r *Result) Changed() bool { return len(r.Removed) > 0 || r.Reencoded }
+
+func (r *Result) removed(kind string) {
+	for _, k := range r.Removed {
+		if k == kind {
+			return
+		}
+	}
+	r.Removed = append(r.Removed, kind)
+}
+
+// Supported 报告 mime 是否能处理。
+func Supported(mime string) bool {
+	switch mime {
+	case "image/jpeg", "image/png", "image/webp":
+		return true
+	}
+	return false
+}
+
+// Strip 去掉 data 里的元数据。mime 不受支持时原样返回。
+func Strip(data []byte, mime string) (*Result, error) {
+	switch mime {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	case "image/webp":
+		return stripWebP(data, false)
+	}
+	return &Result{Data: data, MIME: mime, Orientation: 1}, nil
+}
+
+// StripSameFormat 和 Strip 一样，但输出总是原来的格式：需要转正的 WebP
+// 不重新编码，只留一个仅含 Orientation 的 EXIF 块，由显示端转正。给已经
+// 按扩展名存下、不能换格式的对象用。
+func StripSameFormat(data []byte, mime string) (*Result, error) {
+	if mime == "image/webp" {
+		return stripWebP(data, true)
+	}
+	return Strip(data, mime)
+}
+
+// ----- JPEG -----
+
+// stripJPEG 逐段复制，只保留解码需要的段：APP0（JFIF）、APP2 里的 ICC
+// 色彩配置、APP14（Adobe 色彩变换）。EOI 之后的尾巴（MPF 多图、厂商数据，
+// 里面往往还有一份带 EXIF 的缩略图）整个丢掉。
+func stripJPEG(data []byte) (*Result, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, ErrMalformed
+	}
+	res := &Result{MIME: "image/jpeg", Orientation: 1}
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	var icc [][]byte
+	i := 2
+	for {
+		// 找下一个 marker（跳过填充的 0xFF）
+		if i+1 >= len(data) || data[i] != 0xFF {
+			return nil, ErrMalformed
+		}
+		for i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+1 >= len(data) {
+			return nil, ErrMalformed
+		}
+		marker := data[i+1]
+		if marker == 0xD9 { // EOI
+			out = append(out, 0xFF, 0xD9)
+			if i+2 < len(data) {
+				res.removed("trailer")
+			}
+			break
+		}
+		if marker >= 0xD0 && marker <= 0xD7 || marker == 0x01 {
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			return nil, ErrMalformed
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return nil, ErrMalformed
+		}
+		seg, payload := data[i:end], data[i+4:end]
+		switch {
+		case marker == 0xE1: // APP1: EXIF / XMP
+			if bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+				res.Orientation = exifOrientation(payload[6:])
+				res.removed("exif")
+			} else {
+				res.removed("xmp")
+			}
+		case marker == 0xED: // APP13: Photoshop / IPTC
+			res.removed("iptc")
+		case marker == 0xFE:
+			res.removed("comment")
+		case marker == 0xE2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00")):
+			icc = append(icc, seg)
+			out = append(out, seg...)
+		case marker == 0xE0 || marker == 0xEE:
+			out = append(out, seg...)
+		case marker >= 0xE0 && marker <= 0xEF:
+			res.removed("app")
+		default:
+			out = append(out, seg...)
+		}
+		i = end
+		if marker != 0xDA { // SOS 之后是熵编码数据，一直拷到下一个真正的 marker
+			continue
+		}
+		j := i
+		for ; j+1 < len(data); j++ {
+			if data[j] == 0xFF && data[j+1] != 0x00 && !(data[j+1] >= 0xD0 && data[j+1] <= 0xD7) && data[j+1] != 0xFF {
+				break
+			}
+		}
+		if j+1 >= len(data) {
+			return nil, ErrMalformed
+		}
+		out = append(out, data[i:j]...)
+		i = j
+	}
+	res.Data = out
+	if res.Orientation == 1 {
+		return res, nil
+	}
+	if err := checkPixels(out); err != nil {
+		return nil, err
+	}
+	img, err := jpeg.Decode(bytes.NewReader(out))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, 0xD8})
+	for _, seg := range icc {
+		buf.Write(seg)
+	}
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, orient(img, res.Orientation), &jpeg.Options{Quality: reencodeQuality}); err != nil {
+		return nil, err
+	}
+	buf.Write(enc.Bytes()[2:])
+	res.Data, res.Reencoded = buf.Bytes(), true
+	return res, nil
+}
+
+// ----- PNG -----
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// stripPNG 去掉 eXIf、tEXt / zTXt / iTXt（XMP 也放在 iTXt 里）和 tIME，
+// 其余块原样保留。
+func stripPNG(data []byte) (*Result, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, ErrMalformed
+	}
+	res := &Result{MIME: "image/png", Orientation: 1}
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	animated := false
+	for i := len(pngSignature); ; {
+		if i+12 > len(data) {
+			return nil, ErrMalformed
+		}
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + n
+		if n < 0 || end > len(data) {
+			return nil, ErrMalformed
+		}
+		typ := string(data[i+4 : i+8])
+		switch typ {
+		case "eXIf":
+			res.Orientation = exifOrientation(data[i+8 : i+8+n])
+			res.removed("exif")
+		case "tEXt", "zTXt", "iTXt":
+			res.removed("text")
+		case "tIME":
+			res.removed("time")
+		default:
+			if typ == "acTL" {
+				animated = true
+			}
+			out = append(out, data[i:end]...)
+		}
+		i = end
+		if typ == "IEND" {
+			if i < len(data) {
+				res.removed("trailer")
+			}
+			break
+		}
+	}
+	res.Data = out
+	// APNG 重新编码会丢掉动画，只剥离不转正
+	if res.Orientation == 1 || animated {
+		return res, nil
+	}
+	if err := checkPixels(out); err != nil {
+		return nil, err
+	}
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var buf bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, orient(img, res.Orientation)); err != nil {
+		return nil, err
+	}
+	res.Data, res.Reencoded = buf.Bytes(), true
+	return res, nil
+}
+
+// ----- WebP -----
+
+// VP8X 扩展头里的标志位。
+const (
+	webpFlagAnimation = 0x02
+	webpFlagXMP       = 0x04
+	webpFlagEXIF      = 0x08
+)
+
+// stripWebP 去掉 RIFF 里的 EXIF / XMP 块，同步清掉 VP8X 的对应标志位并
+// 重算 RIFF 长度。sameFormat 时需要转正的图不解码，末尾补一个最小 EXIF。
+func stripWebP(data []byte, sameFormat bool) (*Result, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrMalformed
+	}
+	res := &Result{MIME: "image/webp", Orientation: 1}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+	vp8x := -1
+	riffEnd := min(len(data), 8+int(binary.LittleEndian.Uint32(data[4:])))
+	for i := 12; i < riffEnd; {
+		if i+8 > riffEnd {
+			return nil, ErrMalformed
+		}
+		n := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + n + n&1
+		if n < 0 || i+8+n > riffEnd {
+			return nil, ErrMalformed
+		}
+		end = min(end, riffEnd)
+		switch string(data[i : i+4]) {
+		case "EXIF":
+			res.Orientation = exifOrientation(bytes.TrimPrefix(data[i+8:i+8+n], []byte("Exif\x00\x00")))
+			res.removed("exif")
+		case "XMP ":
+			res.removed("xmp")
+		case "VP8X":
+			vp8x = len(out) + 8
+			out = append(out, data[i:end]...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	if riffEnd < len(data) {
+		res.removed("trailer")
+	}
+	animated := false
+	if vp8x >= 0 && vp8x < len(out) {
+		out[vp8x] &^= webpFlagEXIF | webpFlagXMP
+		animated = out[vp8x]&webpFlagAnimation != 0
+	}
+	if sameFormat && res.Orientation != 1 && vp8x >= 0 && vp8x < len(out) {
+		out = append(out, "EXIF"...)
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(orientationTIFF)))
+		out = append(out, orientationTIFF...)
+		binary.LittleEndian.PutUint16(out[len(out)-8:], uint16(res.Orientation))
+		out[vp8x] |= webpFlagEXIF
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	if sameFormat && bytes.Equal(out, data) {
+		res.Removed = nil // 已经只剩方向了，再跑一遍不算改动
+	}
+	res.Data = out
+	if res.Orientation == 1 || animated || sameFormat {
+		return res, nil
+	}
+	if err := checkPixels(out); err != nil {
+		return nil, err
+	}
+	img, err := webp.Decode(bytes.NewReader(out))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	img = orient(img, res.Orientation)
+	var buf bytes.Buffer
+	if img.(*image.NRGBA).Opaque() {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: reencodeQuality})
+		res.MIME = "image/jpeg"
+	} else {
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+		res.MIME = "image/png"
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.Data, res.Reencoded = buf.Bytes(), true
+	return res, nil
+}
+
+// orientationTIFF 是只有一个 Orientation 条目的 TIFF（小端），值在倒数
+// 第 8、7 字节，由调用方填。
+var orientationTIFF = []byte{
+	'I', 'I', 0x2A, 0, 8, 0, 0, 0, // 头，IFD0 在偏移 8
+	1, 0, // 1 个条目
+	0x12, 0x01, 3, 0, 1, 0, 0, 0, 1, 0, 0, 0, // 0x0112 SHORT ×1
+	0, 0, 0, 0, // 没有下一个 IFD
+}
+
+// checkPixels 只读头部拿到宽高，超过 MaxPixels 的不再往下解码。
+func checkPixels(data []byte) error {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return ErrMalformed
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > int64(MaxPixels) {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// ----- EXIF orientation -----
+
+// exifOrientation 从 TIFF 结构（EXIF 去掉 "Exif\0\0" 前缀后的部分）的
+// IFD0 里读 Orientation（0x0112），读不到时返回 1。
+func exifOrientation(b []byte) int {
+	if len(b) < 8 {
+		return 1
+	}
+	var bo binary.ByteOrder
+	switch string(b[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return 1
+	}
+	if bo.Uint16(b[2:]) != 42 {
+		return 1
+	}
+	off := int(bo.Uint32(b[4:]))
+	if off < 8 || off+2 > len(b) {
+		return 1
+	}
+	n := int(bo.Uint16(b[off:]))
+	for k := 0; k < n; k++ {
+		e := off + 2 + 12*k
+		if e+12 > len(b) {
+			break
+		}
+		if bo.Uint16(b[e:]) != 0x0112 || bo.Uint16(b[e+2:]) != 3 {
+			continue
+		}
+		if v := int(bo.Uint16(b[e+8:])); v >= 1 && v <= 8 {
+			return v
+		}
+		break
+	}
+	return 1
+}
+
+// orient 按 EXIF 方向把图转正，返回 *image.NRGBA。
+func orient(img image.Image, o int) image.Image {
+	b := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch o {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,256 @@
+package imgmeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"testing"
+)
+
+// tiffOrientation 构造只有一个 Orientation 条目的 TIFF 结构。
+func tiffOrientation(o uint16) []byte {
+	b := []byte("II*\x00\x08\x00\x00\x00")
+	b = binary.LittleEndian.AppendUint16(b, 1)
+	b = binary.LittleEndian.AppendUint16(b, 0x0112)
+	b = binary.LittleEndian.AppendUint16(b, 3)
+	b = binary.LittleEndian.AppendUint32(b, 1)
+	b = binary.LittleEndian.AppendUint16(b, o)
+	b = append(b, 0, 0, 0, 0, 0, 0)
+	return append(b, []byte("GPS 31.2304N 121.4737E")...)
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// halves 左半红、右半蓝。
+func halves(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func isRed(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return r > 0xC000 && b < 0x4000
+}
+
+func TestStripJPEG(t *testing.T) {
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, halves(40, 20), &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	plain := enc.Bytes()
+	with := func(o uint16) []byte {
+		var b []byte
+		b = append(b, plain[:2]...)
+		b = append(b, jpegSegment(0xE1, append([]byte("Exif\x00\x00"), tiffOrientation(o)...))...)
+		b = append(b, jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"))...)
+		b = append(b, jpegSegment(0xED, []byte("Photoshop 3.0\x00IPTC"))...)
+		b = append(b, jpegSegment(0xFE, []byte("serial 12345"))...)
+		b = append(b, plain[2:]...)
+		return append(b, []byte("MPF trailer with GPS")...)
+	}
+
+	res, err := Strip(with(1), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res.Data, plain) || res.Reencoded || res.Orientation != 1 {
+		t.Fatalf("orientation 1: reencoded=%v orientation=%d equal=%v", res.Reencoded, res.Orientation, bytes.Equal(res.Data, plain))
+	}
+	if got := len(res.Removed); got != 5 {
+		t.Fatalf("removed: %v", res.Removed)
+	}
+
+	// 方向 6：顺时针转 90°，左边的红色到了上边
+	res, err = Strip(with(6), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Reencoded || res.Orientation != 6 || bytes.Contains(res.Data, []byte("GPS")) || bytes.Contains(res.Data, []byte("Exif")) {
+		t.Fatalf("orientation 6: %+v", res.Removed)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(res.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 20 || b.Dy() != 40 || !isRed(img.At(10, 5)) || isRed(img.At(10, 35)) {
+		t.Fatalf("rotated: %v", img.Bounds())
+	}
+
+	if _, err := Strip(plain[:len(plain)/2], "image/jpeg"); err != ErrMalformed {
+		t.Fatalf("truncated: %v", err)
+	}
+}
+
+func pngChunk(typ string, data []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	b = append(b, typ...)
+	b = append(b, data...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(append([]byte(typ), data...)))
+}
+
+func TestStripPNG(t *testing.T) {
+	var enc bytes.Buffer
+	if err := png.Encode(&enc, halves(40, 20)); err != nil {
+		t.Fatal(err)
+	}
+	plain := enc.Bytes()
+	ihdrEnd := 8 + 12 + 13
+	with := func(o uint16) []byte {
+		var b []byte
+		b = append(b, plain[:ihdrEnd]...)
+		b = append(b, pngChunk("eXIf", tiffOrientation(o))...)
+		b = append(b, pngChunk("tEXt", []byte("Comment\x00shot on serial 12345"))...)
+		return append(b, plain[ihdrEnd:]...)
+	}
+	res, err := Strip(with(1), "image/png")
+	if err != nil || !bytes.Equal(res.Data, plain) || !res.Changed() {
+		t.Fatalf("orientation 1: %v %+v", err, res)
+	}
+	res, err = Strip(with(8), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(res.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 方向 8：逆时针转 90°，左边的红色到了下边
+	if b := img.Bounds(); b.Dx() != 20 || b.Dy() != 40 || isRed(img.At(10, 5)) || !isRed(img.At(10, 35)) {
+		t.Fatalf("rotated: %v", img.Bounds())
+	}
+	if res, err := Strip(plain, "image/png"); err != nil || res.Changed() {
+		t.Fatalf("clean png: %v %+v", err, res)
+	}
+}
+
+// 需要转正的大图在解码之前就被拒掉；只剥离的不受影响。
+func TestMaxPixels(t *testing.T) {
+	var enc bytes.Buffer
+	if err := png.Encode(&enc, halves(40, 20)); err != nil {
+		t.Fatal(err)
+	}
+	plain := enc.Bytes()
+	// IHDR 声称 100000x100000，IDAT 还是原来那一点
+	ihdr := append([]byte(nil), plain[16:29]...)
+	binary.BigEndian.PutUint32(ihdr[0:], 100000)
+	binary.BigEndian.PutUint32(ihdr[4:], 100000)
+	bomb := func(o uint16) []byte {
+		b := append([]byte(nil), pngSignature...)
+		b = append(b, pngChunk("IHDR", ihdr)...)
+		b = append(b, pngChunk("eXIf", tiffOrientation(o))...)
+		return append(b, plain[33:]...)
+	}
+	if _, err := Strip(bomb(6), "image/png"); err != ErrTooLarge {
+		t.Fatalf("bomb with orientation 6: %v", err)
+	}
+	if res, err := Strip(bomb(1), "image/png"); err != nil || res.Reencoded {
+		t.Fatalf("bomb with orientation 1: %v", err)
+	}
+
+	defer func(n int) { MaxPixels = n }(MaxPixels)
+	MaxPixels = 40*20 - 1
+	enc.Reset()
+	if err := jpeg.Encode(&enc, halves(40, 20), nil); err != nil {
+		t.Fatal(err)
+	}
+	j := enc.Bytes()
+	rotated := append(append(append([]byte(nil), j[:2]...), jpegSegment(0xE1, append([]byte("Exif\x00\x00"), tiffOrientation(6)...))...), j[2:]...)
+	if _, err := Strip(rotated, "image/jpeg"); err != ErrTooLarge {
+		t.Fatalf("jpeg over the limit: %v", err)
+	}
+	MaxPixels = 40 * 20
+	if res, err := Strip(rotated, "image/jpeg"); err != nil || !res.Reencoded {
+		t.Fatalf("jpeg at the limit: %v", err)
+	}
+}
+
+func riffChunk(fourCC string, data []byte) []byte {
+	b := append([]byte(fourCC), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	b = append(b, data...)
+	if len(data)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func TestStripWebP(t *testing.T) {
+	simple, err := os.ReadFile("testdata/blue-purple-pink.lossy.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vp8 := simple[12:] // 150x100 的 VP8 块
+	with := func(o uint16) []byte {
+		hdr := []byte{webpFlagEXIF | webpFlagXMP, 0, 0, 0, 149, 0, 0, 99, 0, 0}
+		body := []byte("WEBP")
+		body = append(body, riffChunk("VP8X", hdr)...)
+		body = append(body, vp8...)
+		body = append(body, riffChunk("EXIF", tiffOrientation(o))...)
+		body = append(body, riffChunk("XMP ", []byte("<x:xmpmeta/>"))...)
+		return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+	}
+	res, err := Strip(with(1), "image/webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.MIME != "image/webp" || res.Reencoded || bytes.Contains(res.Data, []byte("GPS")) || len(res.Removed) != 2 {
+		t.Fatalf("orientation 1: %s %+v", res.MIME, res.Removed)
+	}
+	if flags := res.Data[20]; flags&(webpFlagEXIF|webpFlagXMP) != 0 {
+		t.Fatalf("VP8X flags not cleared: %#x", flags)
+	}
+	if size := binary.LittleEndian.Uint32(res.Data[4:]); int(size) != len(res.Data)-8 {
+		t.Fatalf("RIFF size %d for %d bytes", size, len(res.Data))
+	}
+	if cfg, format, err := image.DecodeConfig(bytes.NewReader(res.Data)); err != nil || format != "webp" || cfg.Width != 150 {
+		t.Fatalf("stripped webp: %v %s %v", cfg, format, err)
+	}
+
+	res, err = Strip(with(6), "image/webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(res.Data))
+	if err != nil || res.MIME != "image/jpeg" || cfg.Width != 100 || cfg.Height != 150 {
+		t.Fatalf("rotated webp: %s %v %v", res.MIME, cfg, err)
+	}
+
+	// 不能换格式：还是 WebP，只剩方向
+	res, err = StripSameFormat(with(6), "image/webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.MIME != "image/webp" || res.Reencoded || res.Orientation != 6 || bytes.Contains(res.Data, []byte("GPS")) {
+		t.Fatalf("same format: %s %+v", res.MIME, res)
+	}
+	if flags := res.Data[20]; flags&webpFlagEXIF == 0 || flags&webpFlagXMP != 0 {
+		t.Fatalf("VP8X flags: %#x", flags)
+	}
+	if size := binary.LittleEndian.Uint32(res.Data[4:]); int(size) != len(res.Data)-8 {
+		t.Fatalf("RIFF size %d for %d bytes", size, len(res.Data))
+	}
+	if cfg, format, err := image.DecodeConfig(bytes.NewReader(res.Data)); err != nil || format != "webp" || cfg.Width != 150 {
+		t.Fatalf("same format webp: %v %s %v", cfg, format, err)
+	}
+	again, err := StripSameFormat(res.Data, "image/webp")
+	if err != nil || again.Orientation != 6 || again.Changed() {
+		t.Fatalf("second pass: %+v %v", again, err)
+	}
+}
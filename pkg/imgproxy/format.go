@@ -0,0 +1,155 @@
+package imgproxy
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// EncodeFunc 把 img 编码成某种格式；quality 取值 1-100，无损格式可忽略。
+type EncodeFunc func(w io.Writer, img image.Image, quality int) error
+
+type format struct {
+	mime   string
+	ext    string
+	encode EncodeFunc
+}
+
+var (
+	jpegFormat = &format{mime: "image/jpeg", ext: ".jpg", encode: func(w io.Writer, img image.Image, q int) error {
+		return jpeg.Encode(w, flatten(img), &jpeg.Options{Quality: q})
+	}}
+	pngFormat = &format{mime: "image/png", ext: ".png", encode: func(w io.Writer, img image.Image, _ int) error {
+		return (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(w, img)
+	}}
+
+	mu sync.RWMutex
+	// modern 是按优先级排列的可选格式（avif、webp ……）。标准库和
+	// golang.org/x/image 都没有这类编码器，需要时由调用方 RegisterEncoder。
+	modern []*format
+)
+
+// RegisterEncoder 注册一种可协商的输出格式，先注册的优先。浏览器在 Accept
+// 里明确列出该 MIME 时才会用它；否则退回 JPEG（不透明）/ PNG（带透明）。
+func RegisterEncoder(mime, ext string, fn EncodeFunc) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, f := range modern {
+		if f.mime == mime {
+			f.ext, f.encode = ext, fn
+			return
+		}
+	}
+	modern = append(modern, &format{mime: mime, ext: ext, encode: fn})
+}
+
+// negotiate 返回候选格式：Accept 接受的第一个已注册格式，或 JPEG / PNG
+// 两个兜底（由 pick 按是否透明二选一）。
+func negotiate(accept string) []*format {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, f := range modern {
+		if accepts(accept, f.mime) {
+			return []*format{f}
+		}
+	}
+	return []*format{jpegFormat, pngFormat}
+}
+
+// accepts 只认明确列出且 q>0 的类型，不认 */* 和 image/*：那是所有
+// 浏览器都发的，不能说明它能解 WebP / AVIF。
+func accepts(accept, mime string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), mime) {
+			continue
+		}
+		for _, p := range fields[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if k == "q" {
+				if q, err := strconv.ParseFloat(v, 64); err == nil && q <= 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func pick(formats []*format, img image.Image) *format {
+	if len(formats) == 1 {
+		return formats[0]
+	}
+	if o, ok := img.(interface{ Opaque() bool }); ok && !o.Opaque() {
+		return pngFormat
+	}
+	return jpegFormat
+}
+
+// transform 按 o 缩放 / 裁剪；目标比原图大时保持原尺寸。
+func transform(img image.Image, o Options) image.Image {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	w, h := o.Width, o.Height
+	switch {
+	case w == 0 && h == 0:
+		return img
+	case w == 0:
+		w = max(1, sw*h/sh)
+	case h == 0:
+		h = max(1, sh*w/sw)
+	}
+	src := b
+	switch o.Fit {
+	case FitContain:
+		// 缩到框内：取较小的比例
+		if sw*h > sh*w {
+			h = max(1, sh*w/sw)
+		} else {
+			w = max(1, sw*h/sh)
+		}
+	case FitCover:
+		// 裁出和框同比例的中间部分
+		if sw*h > sh*w {
+			cw := sh * w / h
+			src = image.Rect(b.Min.X+(sw-cw)/2, b.Min.Y, b.Min.X+(sw-cw)/2+cw, b.Max.Y)
+		} else {
+			ch := sw * h / w
+			src = image.Rect(b.Min.X, b.Min.Y+(sh-ch)/2, b.Max.X, b.Min.Y+(sh-ch)/2+ch)
+		}
+	}
+	// 不放大：按同一比例收回到裁剪区域大小
+	if w > src.Dx() || h > src.Dy() {
+		if o.Fit == FitFill {
+			w, h = min(w, src.Dx()), min(h, src.Dy())
+		} else {
+			w, h = src.Dx(), src.Dy()
+		}
+	}
+	if src == b && w == sw && h == sh {
+		return img
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst
+}
+
+// flatten 把透明部分铺到白底上，JPEG 没有 alpha 通道。
+func flatten(img image.Image) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
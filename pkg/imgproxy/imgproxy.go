@@ -0,0 +1,318 @@
+// Package imgproxy 按需生成图片变体：缩放（宽 / 高 / fit）、质量，输出格式
+// 按 Accept 协商。变体 URL 用 HMAC 签名，避免被人拿来随意生成任意尺寸；
+// 生成过的变体缓存在 objstore.Storage 里。
+//
+// URL 形如 <base>/<src>?w=640&h=0&fit=cover&q=80&exp=...&sig=...，src 由
+// 挂载方解释（对象 key、头像 id 等），签名覆盖 src 和全部参数但不含格式，
+// 同一个 URL 对不同浏览器返回不同格式（Vary: Accept）。
+package imgproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend-go/pkg/objstore"
+
+	_ "golang.org/x/image/webp"
+)
+
+// Fit 决定同时给了宽高时怎么缩放。
+const (
+	FitContain = "contain" // 等比缩放到框内（默认）
+	FitCover   = "cover"   // 等比缩放铺满框，居中裁掉多余部分
+	FitFill    = "fill"    // 拉伸到框的尺寸
+)
+
+var ErrBadSignature = errors.New("imgproxy: bad signature")
+
+// Options 是一个变体的参数。Width / Height 为 0 表示按另一边等比；都为 0
+// 时保持原尺寸只转格式 / 质量。不会放大。
+type Options struct {
+	Width   int
+	Height  int
+	Fit     string
+	Quality int
+}
+
+// Source 打开 src 对应的原图。不存在时返回 objstore.ErrNotFound。
+type Source func(ctx context.Context, src string) (io.ReadCloser, error)
+
+// Config 配置一个变体服务。
+type Config struct {
+	// Key 是 URL 签名密钥，至少 16 字节。
+	Key    []byte
+	Source Source
+	// Cache 存生成好的变体，可为空（每次现算）。
+	Cache       objstore.Storage
+	CachePrefix string // 默认 image-variants
+	// OnCache 在变体写进 Cache 之后调用，调用方据此记下 src 有哪些变体，
+	// 原图删掉时一起清掉。可为空。
+	OnCache func(ctx context.Context, src, key string)
+	// MaxSide 是输出宽高上限，默认 4096。
+	MaxSide int
+	// MaxPixels 是原图像素上限（防解压炸弹），默认 50M。
+	MaxPixels int
+	// DefaultQuality 默认 80。
+	DefaultQuality int
+}
+
+// Server 校验签名并输出变体。
+type Server struct {
+	cfg Config
+	now func() time.Time
+}
+
+func New(cfg Config) (*Server, error) {
+	if len(cfg.Key) < 16 {
+		return nil, errors.New("imgproxy: key must be at least 16 bytes")
+	}
+	if cfg.Source == nil {
+		return nil, errors.New("imgproxy: source required")
+	}
+	if cfg.CachePrefix == "" {
+		cfg.CachePrefix = "image-variants"
+	}
+	if cfg.MaxSide <= 0 {
+		cfg.MaxSide = 4096
+	}
+	if cfg.MaxPixels <= 0 {
+		cfg.MaxPixels = 50_000_000
+	}
+	if cfg.DefaultQuality <= 0 || cfg.DefaultQuality > 100 {
+		cfg.DefaultQuality = 80
+	}
+	return &Server{cfg: cfg, now: time.Now}, nil
+}
+
+func (s *Server) normalize(o Options) Options {
+	if o.Width < 0 {
+		o.Width = 0
+	}
+	if o.Height < 0 {
+		o.Height = 0
+	}
+	o.Width = min(o.Width, s.cfg.MaxSide)
+	o.Height = min(o.Height, s.cfg.MaxSide)
+	switch o.Fit {
+	case FitCover, FitFill:
+	default:
+		o.Fit = FitContain
+	}
+	if o.Quality <= 0 || o.Quality > 100 {
+		o.Quality = s.cfg.DefaultQuality
+	}
+	return o
+}
+
+func (s *Server) sig(src string, o Options, exp int64) string {
+	mac := hmac.New(sha256.New, s.cfg.Key)
+	fmt.Fprintf(mac, "imgproxy\n%s\n%d\n%d\n%s\n%d\n%d", src, o.Width, o.Height, o.Fit, o.Quality, exp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Query 返回签好名的查询参数；exp 为零表示不过期。
+func (s *Server) Query(src string, o Options, exp time.Time) url.Values {
+	o = s.normalize(o)
+	var e int64
+	if !exp.IsZero() {
+		e = exp.Unix()
+	}
+	q := url.Values{}
+	if o.Width > 0 {
+		q.Set("w", strconv.Itoa(o.Width))
+	}
+	if o.Height > 0 {
+		q.Set("h", strconv.Itoa(o.Height))
+	}
+	q.Set("fit", o.Fit)
+	q.Set("q", strconv.Itoa(o.Quality))
+	if e > 0 {
+		q.Set("exp", strconv.FormatInt(e, 10))
+	}
+	q.Set("sig", s.sig(src, o, e))
+	return q
+}
+
+// URL 返回 <base>/<src>?<签名参数>。
+func (s *Server) URL(base, src string, o Options, exp time.Time) string {
+	return strings.TrimRight(base, "/") + "/" + escapePath(src) + "?" + s.Query(src, o, exp).Encode()
+}
+
+// Srcset 按 widths 生成 srcset 属性值（"url 320w, url 640w"）。o 的 Width
+// 被逐个替换，Height 按比例缩放。
+func (s *Server) Srcset(base, src string, widths []int, o Options, exp time.Time) string {
+	parts := make([]string, 0, len(widths))
+	for _, w := range widths {
+		v := o
+		v.Width = w
+		if o.Width > 0 && o.Height > 0 {
+			v.Height = o.Height * w / o.Width
+		}
+		parts = append(parts, s.URL(base, src, v, exp)+" "+strconv.Itoa(w)+"w")
+	}
+	return strings.Join(parts, ", ")
+}
+
+func escapePath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, seg := range segs {
+		segs[i] = url.PathEscape(seg)
+	}
+	return strings.Join(segs, "/")
+}
+
+// Verify 从查询参数解析并校验 Options。
+func (s *Server) Verify(src string, q url.Values) (Options, error) {
+	atoi := func(k string) int {
+		n, _ := strconv.Atoi(q.Get(k))
+		return n
+	}
+	o := s.normalize(Options{Width: atoi("w"), Height: atoi("h"), Fit: q.Get("fit"), Quality: atoi("q")})
+	exp, _ := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if src == "" || !hmac.Equal([]byte(q.Get("sig")), []byte(s.sig(src, o, exp))) {
+		return Options{}, ErrBadSignature
+	}
+	if exp > 0 && s.now().Unix() > exp {
+		return Options{}, ErrBadSignature
+	}
+	return o, nil
+}
+
+// ServeHTTP 处理 <src>?<参数>：路径（去掉挂载前缀后）就是 src。
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Serve(w, r, strings.TrimLeft(r.URL.Path, "/"))
+}
+
+// Serve 输出 src 的变体；src 由调用方从路由里取出。
+func (s *Server) Serve(w http.ResponseWriter, r *http.Request, src string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	o, err := s.Verify(src, r.URL.Query())
+	if err != nil {
+		http.Error(w, "signature does not match", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Vary", "Accept")
+	ctx := r.Context()
+	formats := negotiate(r.Header.Get("Accept"))
+	id := variantID(src, o)
+
+	// 命中缓存：协商出的候选格式里只会有一个被生成过
+	if s.cfg.Cache != nil {
+		for _, f := range formats {
+			rc, _, err := s.cfg.Cache.Get(ctx, s.cacheKey(id, f))
+			if err != nil {
+				continue
+			}
+			body, err := io.ReadAll(rc)
+			_ = rc.Close()
+			if err == nil {
+				s.write(w, r, f, id, body)
+				return
+			}
+		}
+	}
+
+	img, err := s.load(ctx, src)
+	if err != nil {
+		switch {
+		case errors.Is(err, objstore.ErrNotFound):
+			http.Error(w, "not found", http.StatusNotFound)
+		case errors.Is(err, errTooManyPixels), errors.Is(err, image.ErrFormat):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			log.Printf("[imgproxy] load %s: %v", src, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+	f := pick(formats, img)
+	var buf bytes.Buffer
+	if err := f.encode(&buf, transform(img, o), o.Quality); err != nil {
+		log.Printf("[imgproxy] encode %s as %s: %v", src, f.mime, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if s.cfg.Cache != nil {
+		key := s.cacheKey(id, f)
+		if _, err := s.cfg.Cache.Put(ctx, key, bytes.NewReader(buf.Bytes()), f.mime); err != nil {
+			log.Printf("[imgproxy] cache %s: %v", src, err)
+		} else if s.cfg.OnCache != nil {
+			s.cfg.OnCache(ctx, src, key)
+		}
+	}
+	s.write(w, r, f, id, buf.Bytes())
+}
+
+func (s *Server) write(w http.ResponseWriter, r *http.Request, f *format, id string, body []byte) {
+	etag := `"` + id[:32] + "-" + f.ext[1:] + `"`
+	h := w.Header()
+	h.Set("Content-Type", f.mime)
+	h.Set("ETag", etag)
+	h.Set("X-Content-Type-Options", "nosniff")
+	if exp, _ := strconv.ParseInt(r.URL.Query().Get("exp"), 10, 64); exp > 0 {
+		h.Set("Cache-Control", "private, max-age="+strconv.FormatInt(max(exp-s.now().Unix(), 0), 10))
+	} else {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(body)
+	}
+}
+
+// variantID 标识一个 (src, options)，格式另算。
+func variantID(src string, o Options) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\n%d\n%d\n%s\n%d", src, o.Width, o.Height, o.Fit, o.Quality)))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Server) cacheKey(id string, f *format) string {
+	return s.cfg.CachePrefix + "/" + id[:2] + "/" + id + f.ext
+}
+
+var errTooManyPixels = errors.New("imgproxy: source image too large")
+
+func (s *Server) load(ctx context.Context, src string) (image.Image, error) {
+	rc, err := s.cfg.Source(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > s.cfg.MaxPixels {
+		return nil, errTooManyPixels
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
@@ -0,0 +1,185 @@
+package imgproxy
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend-go/pkg/objstore"
+)
+
+func testImage(w, h int, alpha bool) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := uint8(255)
+			if alpha && x < w/2 {
+				a = 0
+			}
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 100, A: a})
+		}
+	}
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func newTestServer(t *testing.T) (*Server, *objstore.Local, *int) {
+	t.Helper()
+	sources := map[string][]byte{
+		"photos/opaque.png": testImage(400, 200, false),
+		"photos/alpha.png":  testImage(400, 200, true),
+	}
+	loads := new(int)
+	cache, err := objstore.NewLocal(t.TempDir(), []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(Config{
+		Key:   []byte("0123456789abcdef"),
+		Cache: cache,
+		Source: func(_ context.Context, src string) (io.ReadCloser, error) {
+			b, ok := sources[src]
+			if !ok {
+				return nil, objstore.ErrNotFound
+			}
+			*loads++
+			return io.NopCloser(bytes.NewReader(b)), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, cache, loads
+}
+
+func get(t *testing.T, s *Server, target string, header map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	http.StripPrefix("/img", s).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServeVariant(t *testing.T) {
+	s, cache, loads := newTestServer(t)
+	var cached []string
+	s.cfg.OnCache = func(_ context.Context, src, key string) { cached = append(cached, src+" "+key) }
+	u := s.URL("/img", "photos/opaque.png", Options{Width: 100, Height: 100, Fit: FitCover}, time.Time{})
+
+	rec := get(t, s, u, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/jpeg" || rec.Header().Get("Vary") != "Accept" {
+		t.Fatalf("variant: %d %v", rec.Code, rec.Header())
+	}
+	img, err := jpeg.Decode(rec.Body)
+	if err != nil || img.Bounds().Dx() != 100 || img.Bounds().Dy() != 100 {
+		t.Fatalf("cover result: %v %v", img.Bounds(), err)
+	}
+
+	// 第二次走缓存；带 ETag 时返回 304
+	etag := rec.Header().Get("ETag")
+	if rec := get(t, s, u, map[string]string{"If-None-Match": etag}); rec.Code != http.StatusNotModified {
+		t.Fatalf("if-none-match: %d", rec.Code)
+	}
+	if *loads != 1 {
+		t.Fatalf("source loaded %d times", *loads)
+	}
+	// 生成时报告一次缓存 key，命中缓存不再报告
+	if len(cached) != 1 || !strings.HasPrefix(cached[0], "photos/opaque.png image-variants/") {
+		t.Fatalf("OnCache: %q", cached)
+	}
+	if _, ok, _ := cache.Stat(context.Background(), strings.Fields(cached[0])[1]); !ok {
+		t.Fatalf("reported key not in cache: %s", cached[0])
+	}
+
+	// 改参数签名就对不上
+	if rec := get(t, s, strings.Replace(u, "w=100", "w=101", 1), nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("tampered: %d", rec.Code)
+	}
+	if rec := get(t, s, s.URL("/img", "photos/missing.png", Options{Width: 10}, time.Time{}), nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("missing: %d", rec.Code)
+	}
+	expired := s.URL("/img", "photos/opaque.png", Options{Width: 10}, time.Now().Add(-time.Minute))
+	if rec := get(t, s, expired, nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("expired: %d", rec.Code)
+	}
+}
+
+func TestFitAndFormat(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	cases := []struct {
+		src  string
+		opts Options
+		mime string
+		w, h int
+	}{
+		{"photos/opaque.png", Options{Width: 100}, "image/jpeg", 100, 50},
+		{"photos/opaque.png", Options{Width: 100, Height: 100}, "image/jpeg", 100, 50},
+		{"photos/opaque.png", Options{Width: 100, Height: 100, Fit: FitFill}, "image/jpeg", 100, 100},
+		{"photos/opaque.png", Options{Width: 1000}, "image/jpeg", 400, 200}, // 不放大
+		{"photos/alpha.png", Options{Height: 50}, "image/png", 100, 50},
+	}
+	for _, c := range cases {
+		rec := get(t, s, s.URL("/img", c.src, c.opts, time.Time{}), nil)
+		if rec.Header().Get("Content-Type") != c.mime {
+			t.Fatalf("%+v: content type %q", c.opts, rec.Header().Get("Content-Type"))
+		}
+		cfg, _, err := image.DecodeConfig(rec.Body)
+		if err != nil || cfg.Width != c.w || cfg.Height != c.h {
+			t.Fatalf("%+v: got %dx%d %v", c.opts, cfg.Width, cfg.Height, err)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	mu.Lock()
+	saved := modern
+	modern = nil
+	mu.Unlock()
+	t.Cleanup(func() { mu.Lock(); modern = saved; mu.Unlock() })
+
+	RegisterEncoder("image/webp", ".webp", func(w io.Writer, img image.Image, q int) error {
+		_, err := w.Write([]byte("webp"))
+		return err
+	})
+	s, cache, _ := newTestServer(t)
+	u := s.URL("/img", "photos/opaque.png", Options{Width: 50}, time.Time{})
+
+	rec := get(t, s, u, map[string]string{"Accept": "image/avif,image/webp,*/*"})
+	if rec.Header().Get("Content-Type") != "image/webp" || rec.Body.String() != "webp" {
+		t.Fatalf("webp: %v", rec.Header())
+	}
+	rec = get(t, s, u, map[string]string{"Accept": "image/webp;q=0,*/*"})
+	if rec.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("webp refused: %v", rec.Header())
+	}
+	o, _ := s.Verify("photos/opaque.png", s.Query("photos/opaque.png", Options{Width: 50}, time.Time{}))
+	id := variantID("photos/opaque.png", o)
+	for _, ext := range []string{".webp", ".jpg"} {
+		if _, ok, _ := cache.Stat(context.Background(), "image-variants/"+id[:2]+"/"+id+ext); !ok {
+			t.Fatalf("%s variant not cached", ext)
+		}
+	}
+}
+
+func TestSrcset(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	set := s.Srcset("/img", "a b/c.png", []int{320, 640}, Options{Width: 100, Height: 50, Fit: FitCover}, time.Time{})
+	parts := strings.Split(set, ", ")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "/img/a%20b/c.png?") || !strings.HasSuffix(parts[0], " 320w") ||
+		!strings.Contains(parts[1], "h=320") || !strings.HasSuffix(parts[1], " 640w") {
+		t.Fatalf("srcset: %s", set)
+	}
+}
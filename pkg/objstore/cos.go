@@ -1,51 +1,255 @@
-//go:build cos
-
 package objstore
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
-// COS 是腾讯云对象存储驱动的占位实现。
-//
-// 接入步骤：
-//  1. go get github.com/tencentyun/cos-go-sdk-v5
-//  2. 在 Put/Get/Delete/Stat 内调用 SDK；
-//  3. SignOneShot 推荐做法：服务端先 PutObject 到私有 Bucket，再用本地 HMAC token 表（参考 Local）
-//     将一次性 token 与真实 object key 关联；用户 GET 命中后再 302 到 cos 临时签名 URL。
-//  4. 构建：go build -tags=cos ./...
-type COS struct {
-	Bucket    string
-	Region    string
+// COSConfig 是腾讯云 COS 驱动的配置。
+type COSConfig struct {
+	Bucket    string // 带 APPID，例如 roundnfc-1250000000
+	Region    string // 例如 ap-shanghai
 	SecretID  string
 	SecretKey string
+	Scheme    string // https（默认）或 http
+	// Endpoint 覆盖默认的 <bucket>.cos.<region>.myqcloud.com，可以是
+	// 自定义域名 / 内网域名，也可以带 scheme（测试里指向本地替身）。
+	Endpoint string
+}
+
+// COS 是腾讯云对象存储驱动，直接调 XML API，不依赖官方 SDK。
+//
+// 一次性 token 和 Local 一样在本地签发；消费之后由调用方用 PresignGet
+// 换成短时的签名 URL 302 过去，对象本身不经过我们的服务。
+type COS struct {
+	cfg    COSConfig
+	signer COSSigner
+	scheme string
+	host   string
+	tokens *oneShot
+	now    func() time.Time
+
+	// Client 默认 http.DefaultClient。
+	Client *http.Client
 }
 
-func NewCOS(bucket, region, id, key string) (*COS, error) {
-	if bucket == "" || region == "" || id == "" || key == "" {
+// cosRequestTTL 是服务端请求签名的有效期。
+const cosRequestTTL = 10 * time.Minute
+
+func NewCOS(cfg COSConfig, hmacKey []byte) (*COS, error) {
+	if cfg.Bucket == "" || cfg.Region == "" || cfg.SecretID == "" || cfg.SecretKey == "" {
 		return nil, errors.New("objstore.cos: missing credentials")
 	}
-	return &COS{Bucket: bucket, Region: region, SecretID: id, SecretKey: key}, nil
+	if len(hmacKey) < 16 {
+		return nil, errors.New("objstore.cos: hmac key must be at least 16 bytes")
+	}
+	c := &COS{
+		cfg:    cfg,
+		signer: COSSigner{SecretID: cfg.SecretID, SecretKey: cfg.SecretKey},
+		scheme: "https",
+		host:   fmt.Sprintf("%s.cos.%s.myqcloud.com", cfg.Bucket, cfg.Region),
+		tokens: newOneShot(hmacKey),
+		now:    time.Now,
+		Client: http.DefaultClient,
+	}
+	if strings.EqualFold(strings.TrimSpace(cfg.Scheme), "http") {
+		c.scheme = "http"
+	}
+	if ep := strings.TrimRight(strings.TrimSpace(cfg.Endpoint), "/"); ep != "" {
+		if u, err := url.Parse(ep); err == nil && u.Host != "" {
+			c.scheme, c.host = u.Scheme, u.Host
+		} else {
+			c.host = ep
+		}
+	}
+	return c, nil
+}
+
+func (c *COS) objectURL(key string) *url.URL {
+	key = strings.TrimLeft(key, "/")
+	return &url.URL{Scheme: c.scheme, Host: c.host, Path: "/" + key, RawPath: "/" + escapePath(key)}
+}
+
+func (c *COS) do(ctx context.Context, method, key string, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	u := c.objectURL(key)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+	}
+	now := c.now()
+	req.Header.Set("Authorization", c.signer.Authorization(method, u.Path, c.host, now, now.Add(cosRequestTTL)))
+	return c.Client.Do(req)
 }
 
 func (c *COS) Put(ctx context.Context, key string, r io.Reader, contentType string) (ObjectMeta, error) {
-	return ObjectMeta{}, errors.New("objstore.cos: TODO Put")
+	body, size, cleanup, err := sizedBody(r)
+	if err != nil {
+		return ObjectMeta{}, err
+	}
+	defer cleanup()
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	resp, err := c.do(ctx, http.MethodPut, key, body, size, contentType)
+	if err != nil {
+		return ObjectMeta{}, err
+	}
+	defer drain(resp)
+	if resp.StatusCode/100 != 2 {
+		return ObjectMeta{}, respError("cos", "put", key, resp)
+	}
+	return ObjectMeta{Key: key, ContentType: contentType, Size: size, ETag: strings.Trim(resp.Header.Get("ETag"), `"`)}, nil
 }
+
 func (c *COS) Get(ctx context.Context, key string) (io.ReadCloser, ObjectMeta, error) {
-	return nil, ObjectMeta{}, errors.New("objstore.cos: TODO Get")
+	resp, err := c.do(ctx, http.MethodGet, key, nil, 0, "")
+	if err != nil {
+		return nil, ObjectMeta{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer drain(resp)
+		return nil, ObjectMeta{}, respError("cos", "get", key, resp)
+	}
+	return resp.Body, metaFromHeader(key, resp.Header), nil
 }
+
 func (c *COS) Delete(ctx context.Context, key string) error {
-	return errors.New("objstore.cos: TODO Delete")
+	resp, err := c.do(ctx, http.MethodDelete, key, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	defer drain(resp)
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return respError("cos", "delete", key, resp)
+	}
+	return nil
 }
+
 func (c *COS) Stat(ctx context.Context, key string) (ObjectMeta, bool, error) {
-	return ObjectMeta{}, false, errors.New("objstore.cos: TODO Stat")
+	resp, err := c.do(ctx, http.MethodHead, key, nil, 0, "")
+	if err != nil {
+		return ObjectMeta{}, false, err
+	}
+	defer drain(resp)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ObjectMeta{}, false, nil
+	case resp.StatusCode/100 != 2:
+		return ObjectMeta{}, false, respError("cos", "stat", key, resp)
+	}
+	return metaFromHeader(key, resp.Header), true, nil
 }
+
 func (c *COS) SignOneShot(ctx context.Context, key string, ttl time.Duration) (string, error) {
-	return "", errors.New("objstore.cos: TODO SignOneShot")
+	return c.tokens.sign(ctx, key, TokenOptions{TTL: ttl, MaxUses: 1})
 }
+
+func (c *COS) SignToken(ctx context.Context, key string, opts TokenOptions) (string, error) {
+	return c.tokens.sign(ctx, key, opts)
+}
+
 func (c *COS) ResolveOneShot(ctx context.Context, token string) (string, error) {
-	return "", errors.New("objstore.cos: TODO ResolveOneShot")
+	return c.tokens.resolve(ctx, token)
+}
+
+func (c *COS) RevokeToken(ctx context.Context, token string) error {
+	return c.tokens.revoke(ctx, token)
+}
+
+func (c *COS) SetTokenStore(ts TokenStore) { c.tokens.setStore(ts) }
+
+// PresignPut 返回直传 PUT 请求，签名放在 Authorization 头里。
+// COS 的签名只覆盖 host，PutConstraints 不做约束。
+func (c *COS) PresignPut(_ context.Context, key string, _ PutConstraints, ttl time.Duration) (PresignedRequest, error) {
+	u := c.objectURL(key)
+	now := c.now()
+	return PresignedRequest{
+		Method:  http.MethodPut,
+		URL:     u.String(),
+		Headers: map[string]string{"Authorization": c.signer.Authorization(http.MethodPut, u.Path, c.host, now, now.Add(ttl))},
+	}, nil
+}
+
+// PresignGet 返回 ttl 内有效的签名 GET URL（签名放在 query 里）。
+func (c *COS) PresignGet(_ context.Context, key string, ttl time.Duration) (string, error) {
+	u := c.objectURL(key)
+	now := c.now()
+	u.RawQuery = c.signer.Query(http.MethodGet, u.Path, c.host, now, now.Add(ttl)).Encode()
+	return u.String(), nil
+}
+
+// COSSigner 生成 COS XML API 的 q-sign-algorithm=sha1 签名，只签 host 头、
+// 不签 query 参数。
+type COSSigner struct {
+	SecretID  string
+	SecretKey string
+}
+
+// sign 返回 KeyTime 和签名；path 是未编码的路径（"/" + key）。
+func (s COSSigner) sign(method, path, host string, start, exp time.Time) (keyTime, signature string) {
+	keyTime = fmt.Sprintf("%d;%d", start.Unix(), exp.Unix())
+	httpString := strings.Join([]string{
+		strings.ToLower(method),
+		path,
+		"",
+		"host=" + url.QueryEscape(strings.ToLower(host)),
+		"",
+	}, "\n")
+	stringToSign := strings.Join([]string{"sha1", keyTime, sha1Hex([]byte(httpString)), ""}, "\n")
+	signKey := hmacSHA1Hex([]byte(s.SecretKey), []byte(keyTime))
+	return keyTime, hmacSHA1Hex([]byte(signKey), []byte(stringToSign))
+}
+
+// Authorization 返回放在 Authorization 头里的签名。
+func (s COSSigner) Authorization(method, path, host string, start, exp time.Time) string {
+	keyTime, sig := s.sign(method, path, host, start, exp)
+	return strings.Join([]string{
+		"q-sign-algorithm=sha1",
+		"q-ak=" + s.SecretID,
+		"q-sign-time=" + keyTime,
+		"q-key-time=" + keyTime,
+		"q-header-list=host",
+		"q-url-param-list=",
+		"q-signature=" + sig,
+	}, "&")
+}
+
+// Query 返回放在 URL query 里的签名，用于预签名链接。
+func (s COSSigner) Query(method, path, host string, start, exp time.Time) url.Values {
+	keyTime, sig := s.sign(method, path, host, start, exp)
+	q := url.Values{}
+	q.Set("q-sign-algorithm", "sha1")
+	q.Set("q-ak", s.SecretID)
+	q.Set("q-sign-time", keyTime)
+	q.Set("q-key-time", keyTime)
+	q.Set("q-header-list", "host")
+	q.Set("q-url-param-list", "")
+	q.Set("q-signature", sig)
+	return q
+}
+
+func sha1Hex(b []byte) string {
+	h := sha1.Sum(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA1Hex(key, msg []byte) string {
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	return hex.EncodeToString(mac.Sum(nil))
 }
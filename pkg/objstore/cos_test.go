@@ -0,0 +1,160 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeCOS 只认 q-sign 签名，签名放在 Authorization 头或 query 里都行。
+type fakeCOS struct {
+	signer COSSigner
+}
+
+func newFakeCOS(signer COSSigner) *fakeBucket {
+	return newFakeBucket((&fakeCOS{signer: signer}).authorized)
+}
+
+func (f *fakeCOS) authorized(r *http.Request, _ string) bool {
+	var params url.Values
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		params = url.Values{}
+		for _, kv := range strings.Split(auth, "&") {
+			k, v, _ := strings.Cut(kv, "=")
+			params.Set(k, v)
+		}
+	} else {
+		params = r.URL.Query()
+	}
+	if params.Get("q-ak") != f.signer.SecretID {
+		return false
+	}
+	var start, end int64
+	if parts := strings.SplitN(params.Get("q-key-time"), ";", 2); len(parts) == 2 {
+		start, _ = strconv.ParseInt(parts[0], 10, 64)
+		end, _ = strconv.ParseInt(parts[1], 10, 64)
+	}
+	now := time.Now().Unix()
+	if now < start-5 || now > end {
+		return false
+	}
+	_, want := f.signer.sign(r.Method, r.URL.Path, r.Host, time.Unix(start, 0), time.Unix(end, 0))
+	return params.Get("q-signature") == want
+}
+
+func TestCOS(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeCOS(COSSigner{SecretID: "AKIDtest", SecretKey: "secret"})
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	c, err := NewCOS(COSConfig{Bucket: "b-125", Region: "ap-shanghai", SecretID: "AKIDtest", SecretKey: "secret", Endpoint: srv.URL},
+		[]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "roundnfc/attachments/ab/图 1.png"
+	// 不知道长度的 reader 也要带 Content-Length 上传
+	meta, err := c.Put(ctx, key, io.MultiReader(strings.NewReader("hello "), strings.NewReader("cos")), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Size != 9 || meta.ETag == "" {
+		t.Fatalf("put meta: %+v", meta)
+	}
+	if _, ok := fake.objects[key]; !ok {
+		t.Fatalf("object not stored under %q", key)
+	}
+
+	st, ok, err := c.Stat(ctx, key)
+	if err != nil || !ok || st.Size != 9 || st.ContentType != "image/png" || st.ETag != meta.ETag {
+		t.Fatalf("stat: %+v %v %v", st, ok, err)
+	}
+	rc, gm, err := c.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(rc)
+	_ = rc.Close()
+	if string(got) != "hello cos" || gm.Size != 9 {
+		t.Fatalf("get: %q %+v", got, gm)
+	}
+
+	u, err := c.PresignGet(ctx, key, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello cos" {
+		t.Fatalf("presigned get: %d %q", resp.StatusCode, body)
+	}
+
+	tok, err := c.SignOneShot(ctx, key, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, err := c.ResolveOneShot(ctx, tok); err != nil || k != key {
+		t.Fatalf("resolve: %q %v", k, err)
+	}
+	if _, err := c.ResolveOneShot(ctx, tok); !errors.Is(err, ErrTokenConsumed) {
+		t.Fatalf("second resolve: %v", err)
+	}
+
+	if err := c.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := c.Stat(ctx, key); ok || err != nil {
+		t.Fatalf("stat after delete: %v %v", ok, err)
+	}
+	if _, _, err := c.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get after delete: %v", err)
+	}
+
+	bad, _ := NewCOS(COSConfig{Bucket: "b-125", Region: "ap-shanghai", SecretID: "AKIDtest", SecretKey: "wrong", Endpoint: srv.URL},
+		[]byte("0123456789abcdef"))
+	if _, err := bad.Put(ctx, "x", bytes.NewReader([]byte("x")), ""); err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Fatalf("wrong key: %v", err)
+	}
+}
+
+// 腾讯云请求签名文档里的示例密钥和 KeyTime，文档给出的 SignKey 先对一遍。
+// 本驱动只签 host，HttpString / StringToSign 按文档规则写出来附在下面，
+// 期望的签名由另一份独立实现算出。
+func TestCOSSignVector(t *testing.T) {
+	s := COSSigner{SecretID: "AKIDQjz3ltompVjBni5LitkWHFlFpwkn9U5q", SecretKey: "BQYIM75p8x0iWVFSIgqEKwFprpRSVHlz"}
+	const keyTime = "1557989151;1557996351"
+	if got := hmacSHA1Hex([]byte(s.SecretKey), []byte(keyTime)); got != "eb2519b498b02ac213cb1f3d1a3d27a3b3c9bc5f" {
+		t.Fatalf("SignKey = %s", got)
+	}
+	start, end := time.Unix(1557989151, 0), time.Unix(1557996351, 0)
+	host := "examplebucket-1250000000.cos.ap-beijing.myqcloud.com"
+
+	// HttpString:   "get\n/exampleobject(腾讯云)\n\nhost=examplebucket-1250000000.cos.ap-beijing.myqcloud.com\n"
+	// StringToSign: "sha1\n" + KeyTime + "\n" + sha1hex(HttpString) + "\n"
+	got := s.Authorization(http.MethodGet, "/exampleobject(腾讯云)", host, start, end)
+	want := "q-sign-algorithm=sha1&q-ak=AKIDQjz3ltompVjBni5LitkWHFlFpwkn9U5q" +
+		"&q-sign-time=1557989151;1557996351&q-key-time=1557989151;1557996351" +
+		"&q-header-list=host&q-url-param-list=&q-signature=9d3f9ce4b90c9da7af74d1bb0ea743f0da664d44"
+	if got != want {
+		t.Fatalf("authorization:\n got %s\nwant %s", got, want)
+	}
+	// host 大小写不影响签名
+	q := s.Query(http.MethodPut, "/exampleobject(腾讯云)", strings.ToUpper(host), start, end)
+	if q.Get("q-signature") != "a657be1d9ceb3dccf0cc5fd168a18bee5d20340e" || q.Get("q-key-time") != keyTime {
+		t.Fatalf("query: %v", q)
+	}
+}
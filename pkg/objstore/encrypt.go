@@ -0,0 +1,628 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	ErrCorrupt        = errors.New("objstore: encrypted object corrupt")
+	ErrUnknownKey     = errors.New("objstore: unknown encryption key id")
+	ErrEncryptedGet   = errors.New("objstore: encrypted objects cannot be presigned for download")
+	ErrNotPresignable = errors.New("objstore: driver does not presign")
+	ErrPlaintext      = errors.New("objstore: object is not encrypted")
+)
+
+// 加密对象的格式（信封加密）：
+//
+//	magic "OSE1" | len(keyID) 1B | keyID | chunkSize 4B | wrapped 60B | chunks...
+//
+// wrapped 是用主密钥 AES-256-GCM 加密的 32 字节数据密钥（nonce 12B +
+// 密文 32B + tag 16B），AAD 是 magic、keyID 和对象 key。每个对象一把随机
+// 数据密钥，正文按 chunkSize 切块分别用数据密钥 AES-256-GCM 加密，nonce
+// 是 8 字节块序号 + 3 个 0 + 最后一块标记，AAD 是对象 key，截断、重排、
+// 拼接、把整个对象挪到别的 key 下都解不开。块定长，所以能从密文大小算出
+// 明文大小，也能随机访问（Range）。
+const (
+	encMagic     = "OSE1"
+	encChunkSize = 64 << 10
+	encKeySize   = 32
+	encWrapSize  = 12 + encKeySize + 16
+	encTagSize   = 16
+)
+
+// Keyring 是主密钥表。当前 key 用来加密新对象，其余的只用来解开旧对象；
+// 轮换时加一把新 key 设为当前 key，再用 Encrypted.Rewrap 把旧对象的数据
+// 密钥换过来，之后旧 key 就可以删掉。
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring 校验每把主密钥是 32 字节，key id 是 1~255 字节且不含 ":" ","。
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	kr := &Keyring{current: current, keys: map[string]cipher.AEAD{}}
+	for id, k := range keys {
+		if id == "" || len(id) > 255 || strings.ContainsAny(id, ":,") {
+			return nil, fmt.Errorf("objstore: invalid key id %q", id)
+		}
+		if len(k) != encKeySize {
+			return nil, fmt.Errorf("objstore: key %q must be 32 bytes, got %d", id, len(k))
+		}
+		aead, err := newGCM(k)
+		if err != nil {
+			return nil, err
+		}
+		kr.keys[id] = aead
+	}
+	if _, ok := kr.keys[current]; !ok {
+		return nil, fmt.Errorf("objstore: current key %q not in keyring", current)
+	}
+	return kr, nil
+}
+
+// ParseKeyring 解析 "id:key,id:key" 形式的配置，key 是 base64（标准或
+// URL 编码）或 64 位 hex。current 为空时取最后一把。
+func ParseKeyring(spec, current string) (*Keyring, error) {
+	keys := map[string][]byte{}
+	last := ""
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, enc, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("objstore: key entry %q is not id:key", part)
+		}
+		id = strings.TrimSpace(id)
+		k, err := decodeKey(strings.TrimSpace(enc))
+		if err != nil {
+			return nil, fmt.Errorf("objstore: key %q: %w", id, err)
+		}
+		if _, dup := keys[id]; dup {
+			return nil, fmt.Errorf("objstore: duplicate key id %q", id)
+		}
+		keys[id], last = k, id
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("objstore: empty keyring")
+	}
+	if current = strings.TrimSpace(current); current == "" {
+		current = last
+	}
+	return NewKeyring(current, keys)
+}
+
+func decodeKey(s string) ([]byte, error) {
+	if len(s) == 2*encKeySize {
+		if b, err := hex.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+	return nil, errors.New("not base64 or hex")
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encHeader 是解析出来的对象头。
+type encHeader struct {
+	keyID     string
+	chunkSize int
+	wrapped   []byte
+}
+
+func (h *encHeader) len() int64 { return int64(len(encMagic) + 1 + len(h.keyID) + 4 + encWrapSize) }
+
+func (h *encHeader) marshal() []byte {
+	b := make([]byte, 0, h.len())
+	b = append(b, encMagic...)
+	b = append(b, byte(len(h.keyID)))
+	b = append(b, h.keyID...)
+	b = binary.BigEndian.AppendUint32(b, uint32(h.chunkSize))
+	return append(b, h.wrapped...)
+}
+
+// wrapAAD 里 keyID 带长度前缀，和对象 key 之间没有歧义。
+func wrapAAD(keyID, key string) []byte {
+	b := make([]byte, 0, len(encMagic)+1+len(keyID)+len(key))
+	b = append(b, encMagic...)
+	b = append(b, byte(len(keyID)))
+	b = append(b, keyID...)
+	return append(b, key...)
+}
+
+// Current 返回加密新对象用的 key id。
+func (kr *Keyring) Current() string { return kr.current }
+
+// wrap 用当前主密钥包对象 key 的数据密钥。
+func (kr *Keyring) wrap(dk []byte, key string) (*encHeader, error) {
+	nonce := make([]byte, 12, encWrapSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := kr.keys[kr.current].Seal(nonce, nonce, dk, wrapAAD(kr.current, key))
+	return &encHeader{keyID: kr.current, chunkSize: encChunkSize, wrapped: sealed}, nil
+}
+
+// unwrap 解出数据密钥；对象被挪到别的 key 下时解不开。
+func (kr *Keyring) unwrap(h *encHeader, key string) ([]byte, error) {
+	master, ok := kr.keys[h.keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, h.keyID)
+	}
+	dk, err := master.Open(nil, h.wrapped[:12], h.wrapped[12:], wrapAAD(h.keyID, key))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return dk, nil
+}
+
+// readHeader 读对象头。开头不是 magic 时返回 nil 和已经读掉的字节。
+func readHeader(r io.Reader) (*encHeader, []byte, error) {
+	head := make([]byte, len(encMagic)+1)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, nil, err
+	}
+	if n < len(head) || string(head[:len(encMagic)]) != encMagic {
+		return nil, head[:n], nil
+	}
+	rest := make([]byte, int(head[len(encMagic)])+4+encWrapSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, nil, ErrCorrupt
+	}
+	idLen := len(rest) - 4 - encWrapSize
+	h := &encHeader{
+		keyID:     string(rest[:idLen]),
+		chunkSize: int(binary.BigEndian.Uint32(rest[idLen:])),
+		wrapped:   rest[idLen+4:],
+	}
+	if h.keyID == "" || h.chunkSize <= 0 || h.chunkSize > 16<<20 {
+		return nil, nil, ErrCorrupt
+	}
+	return h, nil, nil
+}
+
+// plainSize 由密文大小算出明文大小：每块多 16 字节 tag，至少一块。
+func (h *encHeader) plainSize(size int64) int64 {
+	body := size - h.len()
+	if body < encTagSize {
+		return 0
+	}
+	unit := int64(h.chunkSize + encTagSize)
+	chunks := (body + unit - 1) / unit
+	return body - chunks*encTagSize
+}
+
+func chunkNonce(idx uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, idx)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// Encrypted 包装任意驱动，写入前加密、读出时解密，对调用方透明。
+// 开头没有 magic 的对象（启用加密之前写的，或客户端直传的）Get 默认
+// 返回 ErrPlaintext，不然对象头一坏就把密文原样发出去了；迁移期间可以
+// SetAllowPlaintext(true) 按明文返回，Rewrap 把它们加密完再关掉。
+//
+// token 相关方法直接转给内层驱动。Stat 要读对象头才知道明文大小，云端
+// 驱动上是一次 GET 而不是 HEAD。
+type Encrypted struct {
+	inner     Storage
+	ring      *Keyring
+	plaintext bool
+}
+
+func NewEncrypted(inner Storage, ring *Keyring) *Encrypted {
+	return &Encrypted{inner: inner, ring: ring}
+}
+
+// SetAllowPlaintext 决定 Get 是否按明文返回没有加密的对象，只用于启用
+// 加密之后、Rewrap 跑完之前的迁移期。
+func (e *Encrypted) SetAllowPlaintext(allow bool) { e.plaintext = allow }
+
+// Inner 返回被包装的驱动。
+func (e *Encrypted) Inner() Storage { return e.inner }
+
+func (e *Encrypted) Put(ctx context.Context, key string, r io.Reader, contentType string) (ObjectMeta, error) {
+	dk := make([]byte, encKeySize)
+	if _, err := rand.Read(dk); err != nil {
+		return ObjectMeta{}, err
+	}
+	h, err := e.ring.wrap(dk, key)
+	if err != nil {
+		return ObjectMeta{}, err
+	}
+	aead, err := newGCM(dk)
+	if err != nil {
+		return ObjectMeta{}, err
+	}
+	pr, pw := io.Pipe()
+	written := make(chan int64, 1)
+	go func() {
+		n, err := encryptTo(pw, h, aead, []byte(key), r)
+		written <- n
+		_ = pw.CloseWithError(err)
+	}()
+	meta, err := e.inner.Put(ctx, key, pr, contentType)
+	_ = pr.CloseWithError(errors.New("objstore: put finished"))
+	n := <-written
+	if err != nil {
+		return ObjectMeta{}, err
+	}
+	meta.Size = n
+	return meta, nil
+}
+
+// encryptTo 写对象头和密文块，返回明文字节数。读到一整块后多读一个字节
+// 判断是不是最后一块。
+func encryptTo(w io.Writer, h *encHeader, aead cipher.AEAD, aad []byte, r io.Reader) (int64, error) {
+	if _, err := w.Write(h.marshal()); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, h.chunkSize+1)
+	out := make([]byte, 0, h.chunkSize+encTagSize)
+	var total int64
+	pending := 0 // 上一轮多读的那个字节
+	for idx := uint64(0); ; idx++ {
+		n, err := io.ReadFull(r, buf[pending:])
+		n += pending
+		final := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !final {
+			return total, err
+		}
+		chunk := n
+		if !final {
+			chunk = h.chunkSize
+		}
+		out = aead.Seal(out[:0], chunkNonce(idx, final), buf[:chunk], aad)
+		if _, err := w.Write(out); err != nil {
+			return total, err
+		}
+		total += int64(chunk)
+		if final {
+			return total, nil
+		}
+		buf[0], pending = buf[h.chunkSize], 1
+	}
+}
+
+func (e *Encrypted) Get(ctx context.Context, key string) (io.ReadCloser, ObjectMeta, error) {
+	rc, meta, err := e.inner.Get(ctx, key)
+	if err != nil {
+		return nil, ObjectMeta{}, err
+	}
+	h, head, err := readHeader(rc)
+	if err != nil {
+		_ = rc.Close()
+		return nil, ObjectMeta{}, err
+	}
+	if h == nil {
+		if !e.plaintext {
+			_ = rc.Close()
+			return nil, ObjectMeta{}, ErrPlaintext
+		}
+		return plainReader(rc, head), meta, nil
+	}
+	dk, err := e.ring.unwrap(h, key)
+	if err != nil {
+		_ = rc.Close()
+		return nil, ObjectMeta{}, err
+	}
+	aead, err := newGCM(dk)
+	if err != nil {
+		_ = rc.Close()
+		return nil, ObjectMeta{}, err
+	}
+	d := &decReader{rc: rc, h: h, aead: aead, aad: []byte(key), size: -1}
+	if meta.Size <= 0 {
+		return d, meta, nil
+	}
+	d.size = h.plainSize(meta.Size)
+	meta.Size = d.size
+	rs, ok := rc.(io.ReadSeeker)
+	if !ok {
+		// 云端驱动的响应体不能 Seek：只往前跳，跳过的密文读掉丢弃
+		fs := &forwardSeeker{r: rc, pos: h.len()}
+		rs, d.rc = fs, struct {
+			io.Reader
+			io.Closer
+		}{fs, rc}
+	}
+	return &seekDecReader{decReader: d, rs: rs}, meta, nil
+}
+
+// forwardSeeker 让只能顺序读的响应体支持往前 Seek。
+type forwardSeeker struct {
+	r   io.Reader
+	pos int64
+}
+
+func (f *forwardSeeker) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *forwardSeeker) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekStart || offset < f.pos {
+		return 0, errors.New("objstore: stream can only seek forward")
+	}
+	n, err := io.CopyN(io.Discard, f.r, offset-f.pos)
+	f.pos += n
+	if err == io.EOF {
+		err = ErrCorrupt
+	}
+	return f.pos, err
+}
+
+// plainReader 把读掉的开头接回去；本地文件直接倒回开头，保留 Seek。
+func plainReader(rc io.ReadCloser, head []byte) io.ReadCloser {
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		if _, err := rs.Seek(0, io.SeekStart); err == nil {
+			return rc
+		}
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), rc), rc}
+}
+
+// decReader 逐块解密。
+type decReader struct {
+	rc   io.ReadCloser
+	h    *encHeader
+	aead cipher.AEAD
+	aad  []byte // 对象 key
+	size int64  // 明文大小，未知时 -1
+
+	idx  uint64
+	cbuf []byte
+	buf  []byte // 当前块还没读走的明文
+	done bool   // 已经解出最后一块
+	last []byte // 最近解出的一整块明文，块号是 idx-1
+}
+
+func (d *decReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// next 读入并解密第 idx 块。短块一定是最后一块；整块先按普通块解，
+// 不行再按最后一块解。最后一块之前就读到结尾说明被截断了。
+func (d *decReader) next() error {
+	if d.cbuf == nil {
+		d.cbuf = make([]byte, d.h.chunkSize+encTagSize)
+	}
+	n, err := io.ReadFull(d.rc, d.cbuf)
+	switch {
+	case err == io.EOF:
+		return ErrCorrupt
+	case err == io.ErrUnexpectedEOF:
+		if n < encTagSize {
+			return ErrCorrupt
+		}
+	case err != nil:
+		return err
+	}
+	ct := d.cbuf[:n]
+	if n == len(d.cbuf) {
+		if pt, err := d.aead.Open(nil, chunkNonce(d.idx, false), ct, d.aad); err == nil {
+			d.buf, d.last = pt, pt
+			d.idx++
+			return nil
+		}
+	}
+	pt, err := d.aead.Open(nil, chunkNonce(d.idx, true), ct, d.aad)
+	if err != nil {
+		return ErrCorrupt
+	}
+	d.buf, d.last, d.done = pt, pt, true
+	d.idx++
+	return nil
+}
+
+func (d *decReader) Close() error { return d.rc.Close() }
+
+// seekDecReader 支持随机访问，http.ServeContent 靠它处理 Range。本地
+// 文件可以任意跳；云端的响应体只能往前跳，落在刚解出的那一块里的
+// 回退（ServeContent 嗅探类型后回到开头、相邻的多段 Range）直接用
+// 缓存的明文。
+type seekDecReader struct {
+	*decReader
+	rs  io.ReadSeeker
+	pos int64
+	// seek 之后第一次 Read 才定位，连续 Seek 不读盘
+	pending bool
+}
+
+func (s *seekDecReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	}
+	if offset < 0 {
+		return 0, errors.New("objstore: negative seek")
+	}
+	s.pos, s.pending = offset, true
+	return offset, nil
+}
+
+func (s *seekDecReader) Read(p []byte) (int, error) {
+	if s.pending {
+		s.pending = false
+		s.buf = nil
+		if s.pos >= s.size {
+			s.done = true
+			return 0, io.EOF
+		}
+		cs := int64(s.h.chunkSize)
+		idx := s.pos / cs
+		if s.last != nil && uint64(idx)+1 == s.idx {
+			s.buf, s.done = s.last, (idx+1)*cs >= s.size
+		} else {
+			if _, err := s.rs.Seek(s.h.len()+idx*(cs+encTagSize), io.SeekStart); err != nil {
+				return 0, err
+			}
+			s.idx, s.done = uint64(idx), false
+			if err := s.next(); err != nil {
+				return 0, err
+			}
+		}
+		skip := s.pos - idx*cs
+		if skip > int64(len(s.buf)) {
+			return 0, ErrCorrupt
+		}
+		s.buf = s.buf[skip:]
+	}
+	n, err := s.decReader.Read(p)
+	s.pos += int64(n)
+	return n, err
+}
+
+func (e *Encrypted) Delete(ctx context.Context, key string) error {
+	return e.inner.Delete(ctx, key)
+}
+
+func (e *Encrypted) Stat(ctx context.Context, key string) (ObjectMeta, bool, error) {
+	meta, ok, err := e.inner.Stat(ctx, key)
+	if err != nil || !ok {
+		return meta, ok, err
+	}
+	h, err := e.header(ctx, key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ObjectMeta{}, false, nil
+		}
+		return ObjectMeta{}, false, err
+	}
+	if h != nil {
+		meta.Size = h.plainSize(meta.Size)
+	}
+	return meta, true, nil
+}
+
+func (e *Encrypted) header(ctx context.Context, key string) (*encHeader, error) {
+	rc, _, err := e.inner.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	h, _, err := readHeader(rc)
+	return h, err
+}
+
+// KeyID 返回对象用的主密钥 id；明文对象返回 ""。
+func (e *Encrypted) KeyID(ctx context.Context, key string) (string, error) {
+	h, err := e.header(ctx, key)
+	if err != nil || h == nil {
+		return "", err
+	}
+	return h.keyID, nil
+}
+
+// Rewrap 让对象改用当前主密钥：已加密的只重新包装数据密钥、替换
+// 对象头，正文原样拷回；明文对象整个加密。from 是原来的 key id（明文为
+// ""），已经是当前 key 时 changed 为 false、不写。
+func (e *Encrypted) Rewrap(ctx context.Context, key string) (from string, changed bool, err error) {
+	rc, meta, err := e.inner.Get(ctx, key)
+	if err != nil {
+		return "", false, err
+	}
+	defer rc.Close()
+	h, head, err := readHeader(rc)
+	if err != nil {
+		return "", false, err
+	}
+	if h == nil {
+		_, err := e.Put(ctx, key, io.MultiReader(bytes.NewReader(head), rc), meta.ContentType)
+		return "", err == nil, err
+	}
+	if h.keyID == e.ring.current {
+		return h.keyID, false, nil
+	}
+	dk, err := e.ring.unwrap(h, key)
+	if err != nil {
+		return h.keyID, false, err
+	}
+	nh, err := e.ring.wrap(dk, key)
+	if err != nil {
+		return h.keyID, false, err
+	}
+	nh.chunkSize = h.chunkSize
+	if _, err := e.inner.Put(ctx, key, io.MultiReader(bytes.NewReader(nh.marshal()), rc), meta.ContentType); err != nil {
+		return h.keyID, false, err
+	}
+	return h.keyID, true, nil
+}
+
+// PresignPut 转给内层驱动：客户端直传的是明文，调用方确认上传后应该
+// Rewrap 一次把它加密。
+func (e *Encrypted) PresignPut(ctx context.Context, key string, c PutConstraints, ttl time.Duration) (PresignedRequest, error) {
+	p, ok := e.inner.(Presigner)
+	if !ok {
+		return PresignedRequest{}, ErrNotPresignable
+	}
+	return p.PresignPut(ctx, key, c, ttl)
+}
+
+// PresignGet 总是失败：直连拿到的是密文，只能经 Get 解密后转发。
+func (e *Encrypted) PresignGet(context.Context, string, time.Duration) (string, error) {
+	return "", ErrEncryptedGet
+}
+
+func (e *Encrypted) SignOneShot(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return e.inner.SignOneShot(ctx, key, ttl)
+}
+
+func (e *Encrypted) SignToken(ctx context.Context, key string, opts TokenOptions) (string, error) {
+	return e.inner.SignToken(ctx, key, opts)
+}
+
+func (e *Encrypted) ResolveOneShot(ctx context.Context, token string) (string, error) {
+	return e.inner.ResolveOneShot(ctx, token)
+}
+
+func (e *Encrypted) RevokeToken(ctx context.Context, token string) error {
+	return e.inner.RevokeToken(ctx, token)
+}
+
+func (e *Encrypted) SetTokenStore(ts TokenStore) { e.inner.SetTokenStore(ts) }
@@ -0,0 +1,241 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKeyring(t *testing.T, spec, current string) *Keyring {
+	t.Helper()
+	kr, err := ParseKeyring(spec, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+func TestEncrypted(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	l, err := NewLocal(dir, []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	e := NewEncrypted(l, testKeyring(t, "k1:"+k1, ""))
+
+	for _, size := range []int{0, 1, encChunkSize, encChunkSize + 1, 3*encChunkSize - 7} {
+		data := make([]byte, size)
+		_, _ = rand.Read(data)
+		meta, err := e.Put(ctx, "obj.bin", bytes.NewReader(data), "")
+		if err != nil || meta.Size != int64(size) {
+			t.Fatalf("put %d: %+v %v", size, meta, err)
+		}
+		raw, _ := os.ReadFile(filepath.Join(dir, "obj.bin"))
+		if size > 16 && bytes.Contains(raw, data[:16]) {
+			t.Fatalf("size %d stored in plaintext", size)
+		}
+		if st, ok, err := e.Stat(ctx, "obj.bin"); !ok || err != nil || st.Size != int64(size) {
+			t.Fatalf("stat %d: %+v %v %v", size, st, ok, err)
+		}
+		rc, meta, err := e.Get(ctx, "obj.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil || !bytes.Equal(got, data) || meta.Size != int64(size) {
+			t.Fatalf("get %d: %d bytes, meta %+v, %v", size, len(got), meta, err)
+		}
+		// 本地文件可以随机访问
+		if size > 2*encChunkSize {
+			rs := rc.(io.ReadSeeker)
+			off := int64(encChunkSize - 3)
+			if _, err := rs.Seek(off, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			part := make([]byte, 10)
+			if _, err := io.ReadFull(rs, part); err != nil || !bytes.Equal(part, data[off:off+10]) {
+				t.Fatalf("seek read: %v", err)
+			}
+			if end, _ := rs.Seek(0, io.SeekEnd); end != int64(size) {
+				t.Fatalf("seek end = %d", end)
+			}
+		}
+		_ = rc.Close()
+	}
+
+	// 截断和篡改都读不出来
+	_, _ = e.Put(ctx, "t.bin", bytes.NewReader(make([]byte, 2*encChunkSize)), "")
+	raw, _ := os.ReadFile(filepath.Join(dir, "t.bin"))
+	for name, bad := range map[string][]byte{
+		"truncated": raw[:len(raw)-encChunkSize-encTagSize],
+		"flipped":   append(append([]byte{}, raw[:len(raw)-1]...), raw[len(raw)-1]^1),
+	} {
+		_ = os.WriteFile(filepath.Join(dir, "t.bin"), bad, 0o644)
+		rc, _, err := e.Get(ctx, "t.bin")
+		if err == nil {
+			_, err = io.ReadAll(rc)
+			_ = rc.Close()
+		}
+		if !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	// 对象头坏了不能当明文把密文发出去
+	raw[0] ^= 1
+	_ = os.WriteFile(filepath.Join(dir, "t.bin"), raw, 0o644)
+	if _, _, err := e.Get(ctx, "t.bin"); !errors.Is(err, ErrPlaintext) {
+		t.Fatalf("damaged magic: %v", err)
+	}
+
+	// 对象 key 绑在 AAD 里：整个挪到别的 key 下解不开
+	_, _ = e.Put(ctx, "a.bin", bytes.NewReader([]byte("object a")), "")
+	raw, _ = os.ReadFile(filepath.Join(dir, "a.bin"))
+	_ = os.WriteFile(filepath.Join(dir, "b.bin"), raw, 0o644)
+	if _, _, err := e.Get(ctx, "b.bin"); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("moved object: %v", err)
+	}
+
+	// 明文对象只在迁移期按明文读出，Rewrap 后加密
+	_, _ = l.Put(ctx, "plain.txt", bytes.NewReader([]byte("hello")), "")
+	if _, _, err := e.Get(ctx, "plain.txt"); !errors.Is(err, ErrPlaintext) {
+		t.Fatalf("plaintext without opt-in: %v", err)
+	}
+	e.SetAllowPlaintext(true)
+	rc, _, err := e.Get(ctx, "plain.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(rc)
+	_ = rc.Close()
+	if string(got) != "hello" {
+		t.Fatalf("plain passthrough: %q", got)
+	}
+	e.SetAllowPlaintext(false)
+	if from, changed, err := e.Rewrap(ctx, "plain.txt"); from != "" || !changed || err != nil {
+		t.Fatalf("encrypt plain: %q %v %v", from, changed, err)
+	}
+	if id, _ := e.KeyID(ctx, "plain.txt"); id != "k1" {
+		t.Fatalf("key id = %q", id)
+	}
+
+	// 轮换：新 key 写新对象，旧对象 Rewrap 之后去掉旧 key 仍然能读
+	k2 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	rotated := NewEncrypted(l, testKeyring(t, "k1:"+k1+",k2:"+k2, ""))
+	if from, changed, err := rotated.Rewrap(ctx, "obj.bin"); from != "k1" || !changed || err != nil {
+		t.Fatalf("rewrap: %q %v %v", from, changed, err)
+	}
+	if _, changed, _ := rotated.Rewrap(ctx, "obj.bin"); changed {
+		t.Fatal("second rewrap changed the object")
+	}
+	onlyK2 := NewEncrypted(l, testKeyring(t, "k2:"+k2, ""))
+	rc, _, err = onlyK2.Get(ctx, "obj.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil || len(got) != 3*encChunkSize-7 {
+		t.Fatalf("read after rewrap: %d %v", len(got), err)
+	}
+	if _, _, err := onlyK2.Get(ctx, "plain.txt"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("unknown key: %v", err)
+	}
+}
+
+// streamOnly 去掉内层 Get 结果的 Seek，模拟云端驱动的响应体。
+type streamOnly struct{ Storage }
+
+func (s streamOnly) Get(ctx context.Context, key string) (io.ReadCloser, ObjectMeta, error) {
+	rc, meta, err := s.Storage.Get(ctx, key)
+	if err != nil {
+		return nil, meta, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{rc, rc}, meta, nil
+}
+
+func TestEncryptedStreamSeek(t *testing.T) {
+	ctx := context.Background()
+	l, err := NewLocal(t.TempDir(), []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewEncrypted(streamOnly{l}, testKeyring(t, "k1:"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)), ""))
+	data := make([]byte, 3*encChunkSize-7)
+	_, _ = rand.Read(data)
+	if _, err := e.Put(ctx, "obj.bin", bytes.NewReader(data), ""); err != nil {
+		t.Fatal(err)
+	}
+	open := func() io.ReadSeeker {
+		rc, _, err := e.Get(ctx, "obj.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = rc.Close() })
+		return rc.(io.ReadSeeker)
+	}
+	read := func(rs io.ReadSeeker, off int64, n int) error {
+		if _, err := rs.Seek(off, io.SeekStart); err != nil {
+			return err
+		}
+		part := make([]byte, n)
+		if _, err := io.ReadFull(rs, part); err != nil {
+			return err
+		}
+		if !bytes.Equal(part, data[off:off+int64(n)]) {
+			t.Fatalf("read at %d: wrong bytes", off)
+		}
+		return nil
+	}
+
+	rs := open()
+	if end, _ := rs.Seek(0, io.SeekEnd); end != int64(len(data)) {
+		t.Fatalf("seek end = %d", end)
+	}
+	// 往前跳、回到刚解出的那一块（嗅探类型之后回开头）都行
+	for _, off := range []int64{0, 100, 0, encChunkSize + 5, encChunkSize + 1, 2*encChunkSize - 3, int64(len(data)) - 10} {
+		if err := read(rs, off, 10); err != nil {
+			t.Fatalf("seek to %d: %v", off, err)
+		}
+	}
+	if _, err := io.ReadAll(rs); err != nil {
+		t.Fatal(err)
+	}
+	// 跳回更早的块要重新 Get
+	if err := read(rs, 0, 10); err == nil {
+		t.Fatal("backward seek past the cached chunk succeeded")
+	}
+	if err := read(open(), int64(len(data))-1, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	hexKey := "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	kr, err := ParseKeyring(" old:"+hexKey+" , new:"+base64.RawURLEncoding.EncodeToString(make([]byte, 32)), "")
+	if err != nil || kr.Current() != "new" {
+		t.Fatalf("%v %v", kr, err)
+	}
+	for _, bad := range []struct{ spec, current string }{
+		{"", ""},
+		{"a:" + hexKey[:40], ""},
+		{"a:" + hexKey + ",a:" + hexKey, ""},
+		{"a:" + hexKey, "b"},
+		{hexKey, ""},
+	} {
+		if _, err := ParseKeyring(bad.spec, bad.current); err == nil {
+			t.Fatalf("accepted %+v", bad)
+		}
+	}
+}
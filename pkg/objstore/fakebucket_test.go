@@ -0,0 +1,87 @@
+package objstore
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fakeBucket 是各云驱动测试共用的内存 bucket，签名校验由驱动自己的替身
+// （fakeCOS / fakeS3 / fakeOSS）提供。
+type fakeBucket struct {
+	// authorized 校验请求签名，key 是已取出的对象名。
+	authorized func(r *http.Request, key string) bool
+	// key 从请求里取出对象名，false 表示 bucket 不对；nil 时取整个路径。
+	key func(r *http.Request) (string, bool)
+	// upperETag：OSS 的 ETag 是大写十六进制。
+	upperETag bool
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+}
+
+func newFakeBucket(authorized func(r *http.Request, key string) bool) *fakeBucket {
+	return &fakeBucket{authorized: authorized, objects: map[string]fakeObject{}}
+}
+
+func (f *fakeBucket) etag(data []byte) string {
+	sum := md5.Sum(data)
+	s := hex.EncodeToString(sum[:])
+	if f.upperETag {
+		s = strings.ToUpper(s)
+	}
+	return `"` + s + `"`
+}
+
+func (f *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, ok := strings.TrimPrefix(r.URL.Path, "/"), true
+	if f.key != nil {
+		key, ok = f.key(r)
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = io.WriteString(w, `<Error><Code>NoSuchBucket</Code></Error>`)
+		return
+	}
+	if !f.authorized(r, key) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = io.WriteString(w, `<?xml version="1.0"?><Error><Code>SignatureDoesNotMatch</Code><Message>bad signature</Message></Error>`)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, ok := f.objects[key]
+	switch r.Method {
+	case http.MethodPut:
+		if r.ContentLength < 0 {
+			w.WriteHeader(http.StatusLengthRequired)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		f.objects[key] = fakeObject{data: data, contentType: r.Header.Get("Content-Type")}
+		w.Header().Set("ETag", f.etag(data))
+	case http.MethodGet, http.MethodHead:
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", f.etag(obj.data))
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(obj.data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,102 @@
+package objstore
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 云端驱动（COS / S3 / OSS）共用的 HTTP 辅助函数。
+
+// sizedBody 返回能带 Content-Length 上传的 body：长度已知的 reader 直接用，
+// 否则先落到临时文件。cleanup 必须调用。
+func sizedBody(r io.Reader) (body io.Reader, size int64, cleanup func(), err error) {
+	noop := func() {}
+	switch v := r.(type) {
+	case *bytes.Buffer:
+		return v, int64(v.Len()), noop, nil
+	case *bytes.Reader:
+		return v, int64(v.Len()), noop, nil
+	case *strings.Reader:
+		return v, int64(v.Len()), noop, nil
+	case *os.File:
+		if st, err := v.Stat(); err == nil && st.Mode().IsRegular() {
+			if off, err := v.Seek(0, io.SeekCurrent); err == nil {
+				return v, st.Size() - off, noop, nil
+			}
+		}
+	}
+	tmp, err := os.CreateTemp("", "objstore-upload-*")
+	if err != nil {
+		return nil, 0, noop, err
+	}
+	cleanup = func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}
+	n, err := io.Copy(tmp, r)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, 0, noop, err
+	}
+	return tmp, n, cleanup, nil
+}
+
+// metaFromHeader 从 GET / HEAD / PUT 响应头里取对象元数据。
+func metaFromHeader(key string, h http.Header) ObjectMeta {
+	m := ObjectMeta{
+		Key:         key,
+		ContentType: h.Get("Content-Type"),
+		ETag:        strings.Trim(h.Get("ETag"), `"`),
+	}
+	if n, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil {
+		m.Size = n
+	}
+	return m
+}
+
+// respError 把非 2xx 响应转成错误。COS / S3 / OSS 的错误体都是
+// <Error><Code/><Message/></Error>。
+func respError(driver, op, key string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return fmt.Errorf("objstore.%s: %s %q: %s: %s", driver, op, key, e.Code, e.Message)
+	}
+	return fmt.Errorf("objstore.%s: %s %q: %s", driver, op, key, resp.Status)
+}
+
+// drain 读完并关闭响应体，以便复用连接。
+func drain(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	_ = resp.Body.Close()
+}
+
+// escapePath 按对象存储的要求编码 key：保留 "/"，其余按 RFC 3986。
+func escapePath(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -2,28 +2,23 @@ package objstore
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/hex"
 	"errors"
-	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
 type Local struct {
 	Dir     string
 	HMACKey []byte
+	// BaseURL 是 Handler 挂载的位置（可以是绝对 URL 或路径），PresignPut /
+	// PresignGet 的 URL 都以它开头；不设置就不能预签名。
+	BaseURL string
 
-	mu      sync.Mutex
-	pending map[string]int64
+	tokens *oneShot
 }
 
 func NewLocal(dir string, hmacKey []byte) (*Local, error) {
@@ -33,9 +28,7 @@ func NewLocal(dir string, hmacKey []byte) (*Local, error) {
 	if len(hmacKey) < 16 {
 		return nil, errors.New("objstore.local: hmac key must be at least 16 bytes")
 	}
-	l := &Local{Dir: dir, HMACKey: hmacKey, pending: map[string]int64{}}
-	go l.gcLoop()
-	return l, nil
+	return &Local{Dir: dir, HMACKey: hmacKey, tokens: newOneShot(hmacKey)}, nil
 }
 
 func (l *Local) abs(key string) string {
@@ -84,7 +77,7 @@ func (l *Local) Get(_ context.Context, key string) (io.ReadCloser, ObjectMeta, e
 		return nil, ObjectMeta{}, err
 	}
 	ct := contentTypeByExt(filepath.Ext(key))
-	return f, ObjectMeta{Key: key, ContentType: ct, Size: st.Size()}, nil
+	return f, ObjectMeta{Key: key, ContentType: ct, Size: st.Size(), ETag: localETag(st)}, nil
 }
 
 func (l *Local) Delete(_ context.Context, key string) error {
@@ -102,92 +95,33 @@ func (l *Local) Stat(_ context.Context, key string) (ObjectMeta, bool, error) {
 		}
 		return ObjectMeta{}, false, err
 	}
-	return ObjectMeta{Key: key, Size: st.Size()}, true, nil
+	return ObjectMeta{Key: key, Size: st.Size(), ETag: localETag(st)}, true, nil
 }
 
-// token = base64url(payload) + "." + base64url(hmac); payload = key|exp|nonce
-func (l *Local) SignOneShot(_ context.Context, key string, ttl time.Duration) (string, error) {
-	if ttl <= 0 {
-		ttl = 2 * time.Minute
-	}
-	nb := make([]byte, 16)
-	if _, err := rand.Read(nb); err != nil {
-		return "", err
-	}
-	nonce := hex.EncodeToString(nb)
-	exp := time.Now().Add(ttl).Unix()
-	payload := fmt.Sprintf("%s|%d|%s", key, exp, nonce)
-
-	mac := hmac.New(sha256.New, l.HMACKey)
-	mac.Write([]byte(payload))
-	tok := base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
-		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
-
-	l.mu.Lock()
-	l.pending[nonce] = exp
-	l.mu.Unlock()
-	return tok, nil
+// localETag 由大小和修改时间拼成（和云端驱动一样不带引号）；Put 总是整
+// 文件替换，内容变了这两个也会变。
+func localETag(st os.FileInfo) string {
+	return strconv.FormatInt(st.Size(), 16) + "-" + strconv.FormatInt(st.ModTime().UnixNano(), 16)
 }
 
-func (l *Local) ResolveOneShot(_ context.Context, token string) (string, error) {
-	parts := strings.SplitN(token, ".", 2)
-	if len(parts) != 2 {
-		return "", ErrTokenInvalid
-	}
-	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
-	if err != nil {
-		return "", ErrTokenInvalid
-	}
-	gotSig, err := base64.RawURLEncoding.DecodeString(parts[1])
-	if err != nil {
-		return "", ErrTokenInvalid
-	}
-	mac := hmac.New(sha256.New, l.HMACKey)
-	mac.Write(payload)
-	if !hmac.Equal(gotSig, mac.Sum(nil)) {
-		return "", ErrTokenInvalid
-	}
-	segs := strings.SplitN(string(payload), "|", 3)
-	if len(segs) != 3 {
-		return "", ErrTokenInvalid
-	}
-	key, expStr, nonce := segs[0], segs[1], segs[2]
-	exp, err := strconv.ParseInt(expStr, 10, 64)
-	if err != nil {
-		return "", ErrTokenInvalid
-	}
-	if time.Now().Unix() > exp {
-		return "", ErrTokenExpired
-	}
+func (l *Local) SignOneShot(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return l.tokens.sign(ctx, key, TokenOptions{TTL: ttl, MaxUses: 1})
+}
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	storedExp, ok := l.pending[nonce]
-	if !ok {
-		return "", ErrTokenConsumed
-	}
-	if storedExp != exp {
-		return "", ErrTokenInvalid
-	}
-	delete(l.pending, nonce)
-	return key, nil
+func (l *Local) SignToken(ctx context.Context, key string, opts TokenOptions) (string, error) {
+	return l.tokens.sign(ctx, key, opts)
 }
 
-func (l *Local) gcLoop() {
-	t := time.NewTicker(time.Minute)
-	defer t.Stop()
-	for range t.C {
-		now := time.Now().Unix()
-		l.mu.Lock()
-		for k, v := range l.pending {
-			if v < now {
-				delete(l.pending, k)
-			}
-		}
-		l.mu.Unlock()
-	}
+func (l *Local) ResolveOneShot(ctx context.Context, token string) (string, error) {
+	return l.tokens.resolve(ctx, token)
 }
 
+func (l *Local) RevokeToken(ctx context.Context, token string) error {
+	return l.tokens.revoke(ctx, token)
+}
+
+func (l *Local) SetTokenStore(ts TokenStore) { l.tokens.setStore(ts) }
+
 func contentTypeByExt(ext string) string {
 	switch strings.ToLower(ext) {
 	case ".webp":
@@ -0,0 +1,154 @@
+package objstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Local 的预签名：URL 形如 <BaseURL>/<key>?ct=..&max=..&exp=..&sig=..，
+// sig 是 HMAC-SHA256(method, key, ct, max, exp)。和云端的预签名 URL 一样
+// 在有效期内可以重复使用，由 Handler 校验并读写磁盘。
+
+// ErrNoBaseURL 表示 Local.BaseURL 没设置，无法生成预签名 URL。
+var ErrNoBaseURL = errors.New("objstore.local: BaseURL not set")
+
+func (l *Local) presignSig(method, key, ct, maxSize, exp string) string {
+	mac := hmac.New(sha256.New, l.HMACKey)
+	mac.Write([]byte(strings.Join([]string{"local-presign", method, key, ct, maxSize, exp}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (l *Local) presignURL(method, key string, c PutConstraints, ttl time.Duration) (string, error) {
+	if l.BaseURL == "" {
+		return "", ErrNoBaseURL
+	}
+	key = strings.TrimLeft(key, "/")
+	maxSize := ""
+	if c.MaxSize > 0 {
+		maxSize = strconv.FormatInt(c.MaxSize, 10)
+	}
+	exp := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	q := url.Values{}
+	if c.ContentType != "" {
+		q.Set("ct", c.ContentType)
+	}
+	if maxSize != "" {
+		q.Set("max", maxSize)
+	}
+	q.Set("exp", exp)
+	q.Set("sig", l.presignSig(method, key, c.ContentType, maxSize, exp))
+	return strings.TrimRight(l.BaseURL, "/") + "/" + escapePath(key) + "?" + q.Encode(), nil
+}
+
+// PresignPut 返回指向 Handler 的上传 URL；ContentType 和 MaxSize 都由
+// Handler 强制。
+func (l *Local) PresignPut(_ context.Context, key string, c PutConstraints, ttl time.Duration) (PresignedRequest, error) {
+	u, err := l.presignURL(http.MethodPut, key, c, ttl)
+	if err != nil {
+		return PresignedRequest{}, err
+	}
+	var headers map[string]string
+	if c.ContentType != "" {
+		headers = map[string]string{"Content-Type": c.ContentType}
+	}
+	return PresignedRequest{Method: http.MethodPut, URL: u, Headers: headers}, nil
+}
+
+// PresignGet 返回指向 Handler 的下载 URL。
+func (l *Local) PresignGet(_ context.Context, key string, ttl time.Duration) (string, error) {
+	return l.presignURL(http.MethodGet, key, PutConstraints{}, ttl)
+}
+
+// Handler 处理 PresignPut / PresignGet 签出来的 URL，挂在 BaseURL 对应的
+// 路径上，请求路径（去掉前缀后）就是 key。
+func (l *Local) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimLeft(r.URL.Path, "/")
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+		q := r.URL.Query()
+		ct, maxSize, exp := q.Get("ct"), q.Get("max"), q.Get("exp")
+		want := l.presignSig(method, key, ct, maxSize, exp)
+		if key == "" || !hmac.Equal([]byte(q.Get("sig")), []byte(want)) {
+			http.Error(w, "signature does not match", http.StatusForbidden)
+			return
+		}
+		if e, err := strconv.ParseInt(exp, 10, 64); err != nil || time.Now().Unix() > e {
+			http.Error(w, "request expired", http.StatusForbidden)
+			return
+		}
+		switch method {
+		case http.MethodPut:
+			l.servePut(w, r, key, ct, maxSize)
+		case http.MethodGet:
+			l.serveGet(w, r, key)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func (l *Local) servePut(w http.ResponseWriter, r *http.Request, key, ct, maxSize string) {
+	got := r.Header.Get("Content-Type")
+	if ct != "" {
+		if mt, _, err := mime.ParseMediaType(got); err != nil || !strings.EqualFold(mt, ct) {
+			http.Error(w, "content type does not match", http.StatusForbidden)
+			return
+		}
+	}
+	body := io.Reader(r.Body)
+	var limit int64
+	if maxSize != "" {
+		limit, _ = strconv.ParseInt(maxSize, 10, 64)
+		if r.ContentLength > limit {
+			http.Error(w, "entity too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	if _, err := l.Put(r.Context(), key, body, got); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "entity too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (l *Local) serveGet(w http.ResponseWriter, r *http.Request, key string) {
+	f, err := os.Open(l.abs(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil || st.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeByExt(filepath.Ext(key)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", st.ModTime(), f)
+}
@@ -0,0 +1,81 @@
+package objstore
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalPresign(t *testing.T) {
+	ctx := context.Background()
+	l, err := NewLocal(t.TempDir(), []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.PresignGet(ctx, "x", time.Minute); err != ErrNoBaseURL {
+		t.Fatalf("without BaseURL: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/uploads/", http.StripPrefix("/uploads", l.Handler()))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	l.BaseURL = srv.URL + "/uploads"
+
+	key := "roundnfc/coser-photos/b1/图 1.jpg"
+	pr, err := l.PresignPut(ctx, key, PutConstraints{ContentType: "image/jpeg", MaxSize: 8}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(u, ct, body string) int {
+		req, _ := http.NewRequest(pr.Method, u, strings.NewReader(body))
+		req.Header.Set("Content-Type", ct)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drain(resp)
+		return resp.StatusCode
+	}
+	if code := put(pr.URL, "image/png", "jpeg"); code != http.StatusForbidden {
+		t.Fatalf("wrong content type: %d", code)
+	}
+	if code := put(pr.URL, "image/jpeg", "too large body"); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("too large: %d", code)
+	}
+	if code := put(strings.Replace(pr.URL, "max=8", "max=80", 1), "image/jpeg", "too large body"); code != http.StatusForbidden {
+		t.Fatalf("tampered max: %d", code)
+	}
+	if _, ok, _ := l.Stat(ctx, key); ok {
+		t.Fatal("rejected upload left a file behind")
+	}
+	if code := put(pr.URL, pr.Headers["Content-Type"], "jpeg"); code != http.StatusOK {
+		t.Fatalf("presigned put: %d", code)
+	}
+
+	u, err := l.PresignGet(ctx, key, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "jpeg" || resp.Header.Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("presigned get: %d %q %q", resp.StatusCode, body, resp.Header.Get("Content-Type"))
+	}
+	// GET 的签名不能拿来 PUT
+	if code := put(u, "image/jpeg", "evil"); code != http.StatusForbidden {
+		t.Fatalf("put with get signature: %d", code)
+	}
+
+	expired, _ := l.PresignGet(ctx, key, -time.Minute)
+	if resp, err := http.Get(expired); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expired: %v %v", resp.StatusCode, err)
+	}
+}
@@ -1,6 +1,7 @@
 // Package objstore 是对象存储抽象。
-// 现有实现：Local（本地磁盘 + HMAC 一次性 URL）。
-// 预留实现：COS / OSS（构建 tag 控制，见 cos.go / oss.go）。
+// 现有实现：Local（本地磁盘 + HMAC 签名的对象 token）、COS（腾讯云 XML API）、
+// S3（SigV4，兼容 MinIO / Garage 等）、OSS（阿里云，V1 / V4 签名）；
+// Encrypted 可以包在任意一个外面做落盘加密。
 package objstore
 
 import (
@@ -15,6 +16,7 @@ var (
 	ErrTokenInvalid  = errors.New("objstore: token invalid")
 	ErrTokenExpired  = errors.New("objstore: token expired")
 	ErrTokenConsumed = errors.New("objstore: token already consumed")
+	ErrTokenRevoked  = errors.New("objstore: token revoked")
 )
 
 type ObjectMeta struct {
@@ -32,6 +34,37 @@ type Storage interface {
 
 	// SignOneShot 颁发一次性 token；只在第一次 ResolveOneShot 时有效。
 	SignOneShot(ctx context.Context, key string, ttl time.Duration) (token string, err error)
-	// ResolveOneShot 校验并消费 token，返回真实 key。
+	// SignToken 颁发按 opts 限制有效期和使用次数的 token。
+	SignToken(ctx context.Context, key string, opts TokenOptions) (token string, err error)
+	// ResolveOneShot 校验 token 并记一次使用，返回真实 key。
 	ResolveOneShot(ctx context.Context, token string) (key string, err error)
+	// RevokeToken 让还没过期的 token 立即失效。
+	RevokeToken(ctx context.Context, token string) error
+	// SetTokenStore 换掉保存 token 状态的地方（默认在进程内存里）。
+	SetTokenStore(ts TokenStore)
+}
+
+// Presigner 由能让客户端直连上传 / 下载的驱动实现（Local、COS、S3、OSS）。
+type Presigner interface {
+	// PresignPut 返回 ttl 内有效的直传请求，客户端必须原样带上 Headers。
+	PresignPut(ctx context.Context, key string, c PutConstraints, ttl time.Duration) (PresignedRequest, error)
+	// PresignGet 返回 ttl 内有效的下载 URL。
+	PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// PutConstraints 约束直传请求，零值表示不限制。
+type PutConstraints struct {
+	// ContentType 非空时签进请求，客户端必须带同样的 Content-Type
+	// （COS 的签名不覆盖它，见 COS.PresignPut）。
+	ContentType string
+	// MaxSize 是允许的最大字节数。只有 Local 能在上传时拦下；云端的预签名
+	// PUT 表达不了上限，调用方在使用对象前应该 Stat 一下。
+	MaxSize int64
+}
+
+// PresignedRequest 是一个签好名的 HTTP 请求。
+type PresignedRequest struct {
+	Method  string
+	URL     string
+	Headers map[string]string
 }
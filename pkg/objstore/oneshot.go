@@ -0,0 +1,161 @@
+package objstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenOptions 决定一个对象 token 的有效期和可用次数。
+type TokenOptions struct {
+	// TTL 是有效期，<=0 时为 2 分钟。
+	TTL time.Duration
+	// MaxUses 是最多能用几次：1 即一次性链接；0 表示有效期内不限次数，
+	// 这种 token 不在 TokenStore 里登记（无状态），只查吊销表。
+	MaxUses int
+}
+
+// oneShot 是各驱动共用的对象 token：HMAC 签名防篡改，有次数限制的 token
+// 在 TokenStore 里记剩余次数。云端驱动也用它，消费后再换成短时的预签名 URL。
+// 驱动不清理过期记录：默认的 MemoryTokenStore 自己顺带清，换进来的存储由
+// 持有者用 SweepTokens 清。
+type oneShot struct {
+	key []byte
+
+	mu    sync.RWMutex
+	store TokenStore
+}
+
+func newOneShot(key []byte) *oneShot {
+	return &oneShot{key: key, store: NewMemoryTokenStore()}
+}
+
+func (o *oneShot) tokenStore() TokenStore {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.store
+}
+
+func (o *oneShot) setStore(ts TokenStore) {
+	o.mu.Lock()
+	o.store = ts
+	o.mu.Unlock()
+}
+
+// token = base64url(payload) + "." + base64url(hmac)；payload = key|exp|nonce|maxUses
+func (o *oneShot) sign(ctx context.Context, key string, opts TokenOptions) (string, error) {
+	if opts.TTL <= 0 {
+		opts.TTL = 2 * time.Minute
+	}
+	if opts.MaxUses < 0 {
+		opts.MaxUses = 0
+	}
+	nb := make([]byte, 16)
+	if _, err := rand.Read(nb); err != nil {
+		return "", err
+	}
+	nonce := hex.EncodeToString(nb)
+	exp := time.Now().Add(opts.TTL)
+	payload := fmt.Sprintf("%s|%d|%s|%d", key, exp.Unix(), nonce, opts.MaxUses)
+
+	mac := hmac.New(sha256.New, o.key)
+	mac.Write([]byte(payload))
+	tok := base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	if opts.MaxUses > 0 {
+		if err := o.tokenStore().Issue(ctx, nonce, opts.MaxUses, exp); err != nil {
+			return "", err
+		}
+	}
+	return tok, nil
+}
+
+type parsedToken struct {
+	key     string
+	exp     int64
+	nonce   string
+	maxUses int
+}
+
+// parse 校验签名和有效期。
+func (o *oneShot) parse(token string) (*parsedToken, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrTokenInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	gotSig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	mac := hmac.New(sha256.New, o.key)
+	mac.Write(payload)
+	if !hmac.Equal(gotSig, mac.Sum(nil)) {
+		return nil, ErrTokenInvalid
+	}
+	// key 里可能有 "|"，从右边切出后三段
+	s := string(payload)
+	fields := make([]string, 3)
+	for n := 2; n >= 0; n-- {
+		i := strings.LastIndex(s, "|")
+		if i < 0 {
+			return nil, ErrTokenInvalid
+		}
+		fields[n], s = s[i+1:], s[:i]
+	}
+	t := &parsedToken{key: s, nonce: fields[1]}
+	if t.exp, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return nil, ErrTokenInvalid
+	}
+	if t.maxUses, err = strconv.Atoi(fields[2]); err != nil {
+		return nil, ErrTokenInvalid
+	}
+	if time.Now().Unix() > t.exp {
+		return nil, ErrTokenExpired
+	}
+	return t, nil
+}
+
+// resolve 校验 token 并记一次使用，返回真实 key。
+func (o *oneShot) resolve(ctx context.Context, token string) (string, error) {
+	t, err := o.parse(token)
+	if err != nil {
+		return "", err
+	}
+	store := o.tokenStore()
+	if t.maxUses > 0 {
+		if err := store.Consume(ctx, t.nonce); err != nil {
+			return "", err
+		}
+		return t.key, nil
+	}
+	revoked, err := store.Revoked(ctx, t.nonce)
+	if err != nil {
+		return "", err
+	}
+	if revoked {
+		return "", ErrTokenRevoked
+	}
+	return t.key, nil
+}
+
+// revoke 让一个还没过期的 token 立即失效。
+func (o *oneShot) revoke(ctx context.Context, token string) error {
+	t, err := o.parse(token)
+	if err != nil {
+		return err
+	}
+	return o.tokenStore().Revoke(ctx, t.nonce, time.Unix(t.exp, 0))
+}
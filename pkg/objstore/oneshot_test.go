@@ -0,0 +1,136 @@
+package objstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestTokens(t *testing.T) {
+	ctx := context.Background()
+	l, err := NewLocal(t.TempDir(), []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := l.SignToken(ctx, "a|b.png", TokenOptions{TTL: time.Minute, MaxUses: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if key, err := l.ResolveOneShot(ctx, tok); err != nil || key != "a|b.png" {
+			t.Fatalf("use %d: %q %v", i, key, err)
+		}
+	}
+	if _, err := l.ResolveOneShot(ctx, tok); !errors.Is(err, ErrTokenConsumed) {
+		t.Fatalf("third use: %v", err)
+	}
+
+	// 不限次数的 token 不登记，只能吊销
+	tok, _ = l.SignToken(ctx, "c.png", TokenOptions{TTL: time.Minute})
+	for i := 0; i < 3; i++ {
+		if _, err := l.ResolveOneShot(ctx, tok); err != nil {
+			t.Fatalf("unlimited use %d: %v", i, err)
+		}
+	}
+	if err := l.RevokeToken(ctx, tok); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.ResolveOneShot(ctx, tok); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("after revoke: %v", err)
+	}
+	if err := l.RevokeToken(ctx, tok[:len(tok)-2]+"xx"); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("revoke forged token: %v", err)
+	}
+
+	// TTL <= 0 取默认的 2 分钟
+	tok, _ = l.SignToken(ctx, "d.png", TokenOptions{TTL: -time.Minute})
+	if _, err := l.ResolveOneShot(ctx, tok); err != nil {
+		t.Fatalf("default ttl: %v", err)
+	}
+}
+
+func TestSQLTokenStoreSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+	dir, key := t.TempDir(), []byte("0123456789abcdef")
+	open := func() *Local {
+		l, err := NewLocal(dir, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts, err := NewSQLTokenStore(db, "object_tokens")
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.SetTokenStore(ts)
+		return l
+	}
+
+	one, _ := open().SignOneShot(ctx, "x.png", time.Minute)
+	multi, _ := open().SignToken(ctx, "y.png", TokenOptions{TTL: time.Minute, MaxUses: 3})
+	unlimited, _ := open().SignToken(ctx, "z.png", TokenOptions{TTL: time.Minute})
+
+	// 新实例（相当于重启）照样认之前发出的 token
+	l := open()
+	if k, err := l.ResolveOneShot(ctx, one); err != nil || k != "x.png" {
+		t.Fatalf("one-shot after restart: %q %v", k, err)
+	}
+	if _, err := l.ResolveOneShot(ctx, one); !errors.Is(err, ErrTokenConsumed) {
+		t.Fatalf("one-shot reuse: %v", err)
+	}
+	if _, err := l.ResolveOneShot(ctx, multi); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.RevokeToken(ctx, multi); err != nil {
+		t.Fatal(err)
+	}
+	if err := open().RevokeToken(ctx, unlimited); err != nil {
+		t.Fatal(err)
+	}
+	for _, tok := range []string{multi, unlimited} {
+		if _, err := open().ResolveOneShot(ctx, tok); !errors.Is(err, ErrTokenRevoked) {
+			t.Fatalf("revoked token: %v", err)
+		}
+	}
+	ts, _ := NewSQLTokenStore(db, "object_tokens")
+	if err := ts.Sweep(ctx, time.Now().Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM object_tokens`).Scan(&n); err != nil || n != 0 {
+		t.Fatalf("after sweep: %d rows %v", n, err)
+	}
+}
+
+// 内存存储不靠后台 goroutine：过期记录在之后的写入里被清掉。
+func TestMemoryTokenStoreSweepsInline(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryTokenStore()
+	_ = m.Issue(ctx, "old", 1, time.Now().Add(-time.Second))
+	_ = m.Revoke(ctx, "gone", time.Now().Add(-time.Second))
+	m.nextSweep = time.Time{}
+	_ = m.Issue(ctx, "new", 1, time.Now().Add(time.Minute))
+	if len(m.tokens) != 1 || m.tokens["new"] == nil {
+		t.Fatalf("tokens after sweep: %v", m.tokens)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() { SweepTokens(m, stop); close(done) }()
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("SweepTokens did not stop")
+	}
+}
@@ -1,50 +1,232 @@
-//go:build oss
-
 package objstore
 
 import (
 	"context"
 	"errors"
 	"io"
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
-// OSS 是阿里云对象存储驱动的占位实现。
-//
-// 接入步骤：
-//  1. go get github.com/aliyun/aliyun-oss-go-sdk
-//  2. 实现 Put/Get/Delete/Stat；
-//  3. SignOneShot 走「私有 bucket + 我方一次性 token」组合，详见 cos.go 注释。
-//  4. 构建：go build -tags=oss ./...
+// OSSConfig 是阿里云 OSS 驱动的配置。
+type OSSConfig struct {
+	Bucket string
+	// Endpoint 例如 oss-cn-hangzhou.aliyuncs.com（可带 scheme，默认 https），
+	// 请求发往 <bucket>.<endpoint>；CNAME 为 true 时直接发往 Endpoint。
+	Endpoint string
+	CNAME    bool
+	// Region 例如 cn-hangzhou，V4 签名要用；留空时从 oss-<region>.aliyuncs.com 推出来。
+	Region          string
+	AccessKeyID     string
+	AccessKeySecret string
+	// SignVersion 为 v4（默认）或 v1。
+	SignVersion string
+	// PublicEndpoint 只用于预签名 URL，例如服务端走 -internal 内网 endpoint、
+	// 浏览器 / App 走公网 endpoint 或 CDN 域名。
+	PublicEndpoint string
+	PublicCNAME    bool
+}
+
+// OSS 是阿里云对象存储驱动，直接调 REST API，不依赖官方 SDK。
+// 一次性 token 在本地签发，消费后由调用方用 PresignGet 302 过去（同 COS）。
 type OSS struct {
-	Bucket    string
-	Endpoint  string
-	SecretID  string
-	SecretKey string
+	cfg    OSSConfig
+	signer OSSSigner
+	base   *url.URL
+	public *url.URL
+	tokens *oneShot
+	now    func() time.Time
+
+	// Client 默认 http.DefaultClient。
+	Client *http.Client
 }
 
-func NewOSS(bucket, endpoint, id, key string) (*OSS, error) {
-	if bucket == "" || endpoint == "" || id == "" || key == "" {
+func NewOSS(cfg OSSConfig, hmacKey []byte) (*OSS, error) {
+	if cfg.Bucket == "" || cfg.Endpoint == "" || cfg.AccessKeyID == "" || cfg.AccessKeySecret == "" {
 		return nil, errors.New("objstore.oss: missing credentials")
 	}
-	return &OSS{Bucket: bucket, Endpoint: endpoint, SecretID: id, SecretKey: key}, nil
+	if len(hmacKey) < 16 {
+		return nil, errors.New("objstore.oss: hmac key must be at least 16 bytes")
+	}
+	base, err := ossHost(cfg.Bucket, cfg.Endpoint, cfg.CNAME)
+	if err != nil {
+		return nil, err
+	}
+	public := base
+	if cfg.PublicEndpoint != "" {
+		if public, err = ossHost(cfg.Bucket, cfg.PublicEndpoint, cfg.PublicCNAME); err != nil {
+			return nil, err
+		}
+	}
+	version := strings.ToLower(strings.TrimSpace(cfg.SignVersion))
+	if version == "" {
+		version = "v4"
+	}
+	if version != "v1" && version != "v4" {
+		return nil, errors.New("objstore.oss: sign version must be v1 or v4")
+	}
+	if cfg.Region == "" {
+		cfg.Region = ossRegion(cfg.Endpoint)
+	}
+	if version == "v4" && cfg.Region == "" {
+		return nil, errors.New("objstore.oss: region required for v4 signing")
+	}
+	return &OSS{
+		cfg: cfg,
+		signer: OSSSigner{
+			AccessKeyID:     cfg.AccessKeyID,
+			AccessKeySecret: cfg.AccessKeySecret,
+			Region:          cfg.Region,
+			Version:         version,
+		},
+		base:   base,
+		public: public,
+		tokens: newOneShot(hmacKey),
+		now:    time.Now,
+		Client: http.DefaultClient,
+	}, nil
+}
+
+func ossHost(bucket, endpoint string, cname bool) (*url.URL, error) {
+	u, err := parseEndpoint(endpoint)
+	if err != nil {
+		return nil, errors.New("objstore.oss: invalid endpoint " + endpoint)
+	}
+	if !cname {
+		u.Host = bucket + "." + u.Host
+	}
+	return &url.URL{Scheme: u.Scheme, Host: u.Host}, nil
+}
+
+// ossRegion 从 oss-cn-hangzhou(-internal).aliyuncs.com 里取出 cn-hangzhou。
+func ossRegion(endpoint string) string {
+	u, err := parseEndpoint(endpoint)
+	if err != nil {
+		return ""
+	}
+	host, _, _ := strings.Cut(u.Hostname(), ".")
+	if !strings.HasPrefix(host, "oss-") {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "oss-"), "-internal")
+}
+
+func (o *OSS) objectURL(base *url.URL, key string) *url.URL {
+	key = strings.TrimLeft(key, "/")
+	return &url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/" + key, RawPath: "/" + escapePath(key)}
+}
+
+func (o *OSS) do(ctx context.Context, method, key string, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, o.objectURL(o.base, key).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+	}
+	o.signer.SignRequest(req, o.cfg.Bucket, key, o.now())
+	return o.Client.Do(req)
 }
 
 func (o *OSS) Put(ctx context.Context, key string, r io.Reader, contentType string) (ObjectMeta, error) {
-	return ObjectMeta{}, errors.New("objstore.oss: TODO Put")
+	body, size, cleanup, err := sizedBody(r)
+	if err != nil {
+		return ObjectMeta{}, err
+	}
+	defer cleanup()
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	resp, err := o.do(ctx, http.MethodPut, key, body, size, contentType)
+	if err != nil {
+		return ObjectMeta{}, err
+	}
+	defer drain(resp)
+	if resp.StatusCode/100 != 2 {
+		return ObjectMeta{}, respError("oss", "put", key, resp)
+	}
+	return ObjectMeta{Key: key, ContentType: contentType, Size: size, ETag: strings.Trim(resp.Header.Get("ETag"), `"`)}, nil
 }
+
 func (o *OSS) Get(ctx context.Context, key string) (io.ReadCloser, ObjectMeta, error) {
-	return nil, ObjectMeta{}, errors.New("objstore.oss: TODO Get")
+	resp, err := o.do(ctx, http.MethodGet, key, nil, 0, "")
+	if err != nil {
+		return nil, ObjectMeta{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer drain(resp)
+		return nil, ObjectMeta{}, respError("oss", "get", key, resp)
+	}
+	return resp.Body, metaFromHeader(key, resp.Header), nil
 }
+
 func (o *OSS) Delete(ctx context.Context, key string) error {
-	return errors.New("objstore.oss: TODO Delete")
+	resp, err := o.do(ctx, http.MethodDelete, key, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	defer drain(resp)
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return respError("oss", "delete", key, resp)
+	}
+	return nil
 }
+
 func (o *OSS) Stat(ctx context.Context, key string) (ObjectMeta, bool, error) {
-	return ObjectMeta{}, false, errors.New("objstore.oss: TODO Stat")
+	resp, err := o.do(ctx, http.MethodHead, key, nil, 0, "")
+	if err != nil {
+		return ObjectMeta{}, false, err
+	}
+	defer drain(resp)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ObjectMeta{}, false, nil
+	case resp.StatusCode/100 != 2:
+		return ObjectMeta{}, false, respError("oss", "stat", key, resp)
+	}
+	return metaFromHeader(key, resp.Header), true, nil
 }
+
 func (o *OSS) SignOneShot(ctx context.Context, key string, ttl time.Duration) (string, error) {
-	return "", errors.New("objstore.oss: TODO SignOneShot")
+	return o.tokens.sign(ctx, key, TokenOptions{TTL: ttl, MaxUses: 1})
+}
+
+func (o *OSS) SignToken(ctx context.Context, key string, opts TokenOptions) (string, error) {
+	return o.tokens.sign(ctx, key, opts)
 }
+
 func (o *OSS) ResolveOneShot(ctx context.Context, token string) (string, error) {
-	return "", errors.New("objstore.oss: TODO ResolveOneShot")
+	return o.tokens.resolve(ctx, token)
+}
+
+func (o *OSS) RevokeToken(ctx context.Context, token string) error {
+	return o.tokens.revoke(ctx, token)
+}
+
+func (o *OSS) SetTokenStore(ts TokenStore) { o.tokens.setStore(ts) }
+
+// PresignPut 返回 query 签名的 PUT URL；c.ContentType 非空时签进去，浏览器
+// 直传必须带同样的 Content-Type。
+func (o *OSS) PresignPut(_ context.Context, key string, c PutConstraints, ttl time.Duration) (PresignedRequest, error) {
+	h := http.Header{}
+	var headers map[string]string
+	if c.ContentType != "" {
+		h.Set("Content-Type", c.ContentType)
+		headers = map[string]string{"Content-Type": c.ContentType}
+	}
+	return PresignedRequest{
+		Method:  http.MethodPut,
+		URL:     o.signer.PresignURL(http.MethodPut, o.objectURL(o.public, key), o.cfg.Bucket, key, h, ttl, o.now()),
+		Headers: headers,
+	}, nil
+}
+
+// PresignGet 返回 query 签名的 GET URL。
+func (o *OSS) PresignGet(_ context.Context, key string, ttl time.Duration) (string, error) {
+	return o.signer.PresignURL(http.MethodGet, o.objectURL(o.public, key), o.cfg.Bucket, key, nil, ttl, o.now()), nil
 }
@@ -0,0 +1,271 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeOSS 校验 V1 / V4 签名（Authorization 头或 URL query），按
+// virtual-hosted 的 Host 头认 bucket。
+type fakeOSS struct {
+	signer OSSSigner
+	bucket string
+}
+
+func newFakeOSS(signer OSSSigner, bucket string) *fakeBucket {
+	f := &fakeOSS{signer: signer, bucket: bucket}
+	b := newFakeBucket(f.authorized)
+	b.key = func(r *http.Request) (string, bool) {
+		host, _, _ := strings.Cut(r.Host, ".")
+		return strings.TrimPrefix(r.URL.Path, "/"), host == f.bucket
+	}
+	b.upperETag = true
+	return b
+}
+
+func (f *fakeOSS) authorized(r *http.Request, key string) bool {
+	q := r.URL.Query()
+	auth := r.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(auth, "OSS "):
+		want := f.signer.v1Signature(r.Method, r.Header, r.Header.Get("Date"), ossResource(f.bucket, key))
+		return auth == "OSS "+f.signer.AccessKeyID+":"+want
+	case q.Get("OSSAccessKeyId") != "":
+		exp, _ := strconv.ParseInt(q.Get("Expires"), 10, 64)
+		if q.Get("OSSAccessKeyId") != f.signer.AccessKeyID || time.Now().Unix() > exp {
+			return false
+		}
+		return q.Get("Signature") == f.signer.v1Signature(r.Method, r.Header, q.Get("Expires"), ossResource(f.bucket, key))
+	case strings.HasPrefix(auth, ossV4Algorithm+" "):
+		var credential, signature string
+		for _, kv := range strings.Split(strings.TrimPrefix(auth, ossV4Algorithm+" "), ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(kv), "=")
+			switch k {
+			case "Credential":
+				credential = v
+			case "Signature":
+				signature = v
+			}
+		}
+		t, _ := time.Parse(sigV4TimeFormat, r.Header.Get("X-Oss-Date"))
+		return credential == f.signer.AccessKeyID+"/"+f.signer.v4Scope(t) &&
+			signature == f.signer.v4Signature(r.Method, f.bucket, key, q, r.Header, t)
+	case q.Get("x-oss-signature") != "":
+		t, _ := time.Parse(sigV4TimeFormat, q.Get("x-oss-date"))
+		exp, _ := strconv.Atoi(q.Get("x-oss-expires"))
+		if q.Get("x-oss-credential") != f.signer.AccessKeyID+"/"+f.signer.v4Scope(t) || time.Now().After(t.Add(time.Duration(exp)*time.Second)) {
+			return false
+		}
+		return q.Get("x-oss-signature") == f.signer.v4Signature(r.Method, f.bucket, key, q, r.Header, t)
+	}
+	return false
+}
+
+// pinnedClient 把所有请求都连到 addr，这样 <bucket>.oss-xx.aliyuncs.com
+// 也能打到本地替身。
+func pinnedClient(addr string) *http.Client {
+	var d net.Dialer
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+func TestOSS(t *testing.T) {
+	for _, version := range []string{"v1", "v4"} {
+		t.Run(version, func(t *testing.T) { testOSS(t, version) })
+	}
+}
+
+func testOSS(t *testing.T, version string) {
+	ctx := context.Background()
+	fake := newFakeOSS(OSSSigner{AccessKeyID: "LTAItest", AccessKeySecret: "secret", Region: "cn-hangzhou", Version: version}, "roundnfc")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	client := pinnedClient(srv.Listener.Addr().String())
+
+	cfg := OSSConfig{
+		Bucket:          "roundnfc",
+		Endpoint:        "http://oss-cn-hangzhou.aliyuncs.com",
+		AccessKeyID:     "LTAItest",
+		AccessKeySecret: "secret",
+		SignVersion:     version,
+	}
+	o, err := NewOSS(cfg, []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Client = client
+
+	key := "roundnfc/attachments/ab/图 1+2.png"
+	meta, err := o.Put(ctx, key, io.MultiReader(strings.NewReader("hello "), strings.NewReader("oss")), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Size != 9 || meta.ETag == "" {
+		t.Fatalf("put meta: %+v", meta)
+	}
+	if _, ok := fake.objects[key]; !ok {
+		t.Fatalf("object not stored under %q", key)
+	}
+
+	st, ok, err := o.Stat(ctx, key)
+	if err != nil || !ok || st.Size != 9 || st.ContentType != "image/png" || st.ETag != meta.ETag {
+		t.Fatalf("stat: %+v %v %v", st, ok, err)
+	}
+	if _, ok, err := o.Stat(ctx, "missing"); ok || err != nil {
+		t.Fatalf("stat missing: %v %v", ok, err)
+	}
+	rc, _, err := o.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(rc)
+	_ = rc.Close()
+	if string(got) != "hello oss" {
+		t.Fatalf("get: %q", got)
+	}
+
+	pr, err := o.PresignPut(ctx, "roundnfc/direct.jpg", PutConstraints{ContentType: "image/jpeg"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(pr.URL, "http://roundnfc.oss-cn-hangzhou.aliyuncs.com/") {
+		t.Fatalf("presigned put url: %s", pr.URL)
+	}
+	put := func(ct string) int {
+		req, _ := http.NewRequest(pr.Method, pr.URL, strings.NewReader("jpeg"))
+		req.Header.Set("Content-Type", ct)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drain(resp)
+		return resp.StatusCode
+	}
+	if code := put("image/png"); code != http.StatusForbidden {
+		t.Fatalf("presigned put with wrong content type: %d", code)
+	}
+	if code := put(pr.Headers["Content-Type"]); code != http.StatusOK {
+		t.Fatalf("presigned put: %d", code)
+	}
+
+	u, err := o.PresignGet(ctx, key, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello oss" {
+		t.Fatalf("presigned get: %d %q", resp.StatusCode, body)
+	}
+
+	tok, err := o.SignOneShot(ctx, key, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, err := o.ResolveOneShot(ctx, tok); err != nil || k != key {
+		t.Fatalf("resolve: %q %v", k, err)
+	}
+
+	if err := o.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := o.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get after delete: %v", err)
+	}
+
+	cfg.AccessKeySecret = "wrong"
+	bad, _ := NewOSS(cfg, []byte("0123456789abcdef"))
+	bad.Client = client
+	if _, err := bad.Put(ctx, "x", bytes.NewReader([]byte("x")), ""); err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Fatalf("wrong key: %v", err)
+	}
+}
+
+func TestOSSRegion(t *testing.T) {
+	for endpoint, want := range map[string]string{
+		"oss-cn-hangzhou.aliyuncs.com":                  "cn-hangzhou",
+		"https://oss-cn-shanghai-internal.aliyuncs.com": "cn-shanghai",
+		"https://oss-ap-southeast-1.aliyuncs.com/":      "ap-southeast-1",
+		"https://static.example.com":                    "",
+	} {
+		if got := ossRegion(endpoint); got != want {
+			t.Errorf("ossRegion(%q) = %q, want %q", endpoint, got, want)
+		}
+	}
+	if _, err := NewOSS(OSSConfig{Bucket: "b", Endpoint: "static.example.com", CNAME: true, AccessKeyID: "a", AccessKeySecret: "s"},
+		[]byte("0123456789abcdef")); err == nil {
+		t.Fatal("v4 without region should fail")
+	}
+}
+
+// OSS 文档里 V1 头签名的示例（PUT oss-example/nelson）。
+func TestOSSV1Vector(t *testing.T) {
+	s := OSSSigner{AccessKeyID: "44CF9590006BF252F707", AccessKeySecret: "OtxrzxIsfpFjA7SwPzILwy8Bw21TLhquhboDYROV", Version: "v1"}
+	req, _ := http.NewRequest(http.MethodPut, "http://oss-example.oss-cn-hangzhou.aliyuncs.com/nelson", nil)
+	req.Header.Set("Content-MD5", "ODBGOERFMDMzQTczRUY3NUE3NzA5QzdFNUYzMDQxNEM=")
+	req.Header.Set("Content-Type", "text/html")
+	req.Header.Set("X-OSS-Meta-Author", "foo@bar.com")
+	req.Header.Set("X-OSS-Magic", "abracadabra")
+	s.SignRequest(req, "oss-example", "nelson", time.Date(2005, 11, 17, 18, 49, 58, 0, time.UTC))
+	if got := req.Header.Get("Date"); got != "Thu, 17 Nov 2005 18:49:58 GMT" {
+		t.Fatalf("date: %s", got)
+	}
+	if got := req.Header.Get("Authorization"); got != "OSS 44CF9590006BF252F707:26NBxoKdsyly4EDv6inkoDft/yA=" {
+		t.Fatalf("authorization: %s", got)
+	}
+}
+
+// V4 的规范请求按文档规则写出来附在下面，期望的签名由另一份独立实现算出；
+// key 里的空格和 "+" 用来检查路径编码。
+func TestOSSV4Vector(t *testing.T) {
+	s := OSSSigner{AccessKeyID: "LTAI5tExample", AccessKeySecret: "yourAccessKeySecret", Region: "cn-hangzhou"}
+	at := time.Date(2023, 12, 3, 12, 12, 12, 0, time.UTC)
+	const key = "photos/a b+c.jpg"
+
+	// PUT
+	// /examplebucket/photos/a%20b%2Bc.jpg
+	//
+	// content-md5:eB5eJF1ptWaXm4bijSPyxw==
+	// content-type:text/plain
+	// x-oss-content-sha256:UNSIGNED-PAYLOAD
+	// x-oss-date:20231203T121212Z
+	// x-oss-meta-author:alice
+	//
+	//
+	// UNSIGNED-PAYLOAD
+	req, _ := http.NewRequest(http.MethodPut, "https://examplebucket.oss-cn-hangzhou.aliyuncs.com/photos/a%20b%2Bc.jpg", nil)
+	req.Header.Set("Content-MD5", "eB5eJF1ptWaXm4bijSPyxw==")
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Oss-Meta-Author", "alice")
+	req.Header.Set("User-Agent", "not signed")
+	s.SignRequest(req, "examplebucket", key, at)
+	want := "OSS4-HMAC-SHA256 Credential=LTAI5tExample/20231203/cn-hangzhou/oss/aliyun_v4_request," +
+		"Signature=b5dd9eb6d15cdb7696431b607b0fe24b8ce97a671fe04d63b75cc4d69e993de6"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("authorization:\n got %s\nwant %s", got, want)
+	}
+
+	// GET，query 里签 x-oss-credential / date / expires / signature-version
+	u, _ := url.Parse("https://examplebucket.oss-cn-hangzhou.aliyuncs.com/photos/a%20b%2Bc.jpg")
+	signed, _ := url.Parse(s.PresignURL(http.MethodGet, u, "examplebucket", key, nil, time.Hour, at))
+	if got := signed.Query().Get("x-oss-signature"); got != "04b3dc179e396cdce503a4d21acda85cbb7cd375c490ec56a858d00806be6616" {
+		t.Fatalf("presigned: %s", signed)
+	}
+}
@@ -0,0 +1,213 @@
+package objstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Config 是 S3 兼容驱动的配置。
+type S3Config struct {
+	// Endpoint 例如 https://s3.amazonaws.com、http://minio:9000；
+	// 留空为 https://s3.<region>.amazonaws.com。
+	Endpoint  string
+	Region    string // 默认 us-east-1
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle 用 <endpoint>/<bucket>/<key>，MinIO / Garage 一般需要；
+	// 否则用 <bucket>.<endpoint>/<key>（virtual-hosted）。
+	PathStyle bool
+	// PublicEndpoint 只用于预签名 URL：服务端走内网地址、浏览器 / App 走
+	// 公网地址时填公网的，签名里的 host 会随之变化。
+	PublicEndpoint string
+}
+
+// S3 是 S3 兼容的对象存储驱动（AWS Signature V4），不依赖 SDK。
+// 上传不计算 payload 哈希（UNSIGNED-PAYLOAD），body 直接流过去。
+type S3 struct {
+	cfg    S3Config
+	signer sigV4
+	base   *url.URL
+	public *url.URL
+	tokens *oneShot
+	now    func() time.Time
+
+	// Client 默认 http.DefaultClient。
+	Client *http.Client
+}
+
+func NewS3(cfg S3Config, hmacKey []byte) (*S3, error) {
+	if cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("objstore.s3: missing bucket or credentials")
+	}
+	if len(hmacKey) < 16 {
+		return nil, errors.New("objstore.s3: hmac key must be at least 16 bytes")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	base, err := parseEndpoint(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	public := base
+	if cfg.PublicEndpoint != "" {
+		if public, err = parseEndpoint(cfg.PublicEndpoint); err != nil {
+			return nil, err
+		}
+	}
+	return &S3{
+		cfg:    cfg,
+		signer: sigV4{AccessKey: cfg.AccessKey, SecretKey: cfg.SecretKey, Region: cfg.Region, Service: "s3"},
+		base:   base,
+		public: public,
+		tokens: newOneShot(hmacKey),
+		now:    time.Now,
+		Client: http.DefaultClient,
+	}, nil
+}
+
+func parseEndpoint(s string) (*url.URL, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "/")
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return nil, errors.New("objstore.s3: invalid endpoint " + s)
+	}
+	return u, nil
+}
+
+func (s *S3) objectURL(base *url.URL, key string) *url.URL {
+	key = strings.TrimLeft(key, "/")
+	u := &url.URL{Scheme: base.Scheme, Host: base.Host}
+	p := "/" + key
+	if s.cfg.PathStyle {
+		p = "/" + s.cfg.Bucket + p
+	} else {
+		u.Host = s.cfg.Bucket + "." + base.Host
+	}
+	u.Path = strings.TrimRight(base.Path, "/") + p
+	u.RawPath = escapePath(u.Path)
+	return u
+}
+
+func (s *S3) do(ctx context.Context, method, key string, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(s.base, key).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+	}
+	s.signer.signRequest(req, "", s.now())
+	return s.Client.Do(req)
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, contentType string) (ObjectMeta, error) {
+	body, size, cleanup, err := sizedBody(r)
+	if err != nil {
+		return ObjectMeta{}, err
+	}
+	defer cleanup()
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, body, size, contentType)
+	if err != nil {
+		return ObjectMeta{}, err
+	}
+	defer drain(resp)
+	if resp.StatusCode/100 != 2 {
+		return ObjectMeta{}, respError("s3", "put", key, resp)
+	}
+	return ObjectMeta{Key: key, ContentType: contentType, Size: size, ETag: strings.Trim(resp.Header.Get("ETag"), `"`)}, nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, ObjectMeta, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0, "")
+	if err != nil {
+		return nil, ObjectMeta{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer drain(resp)
+		return nil, ObjectMeta{}, respError("s3", "get", key, resp)
+	}
+	return resp.Body, metaFromHeader(key, resp.Header), nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	defer drain(resp)
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return respError("s3", "delete", key, resp)
+	}
+	return nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (ObjectMeta, bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, 0, "")
+	if err != nil {
+		return ObjectMeta{}, false, err
+	}
+	defer drain(resp)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ObjectMeta{}, false, nil
+	case resp.StatusCode/100 != 2:
+		return ObjectMeta{}, false, respError("s3", "stat", key, resp)
+	}
+	return metaFromHeader(key, resp.Header), true, nil
+}
+
+func (s *S3) SignOneShot(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return s.tokens.sign(ctx, key, TokenOptions{TTL: ttl, MaxUses: 1})
+}
+
+func (s *S3) SignToken(ctx context.Context, key string, opts TokenOptions) (string, error) {
+	return s.tokens.sign(ctx, key, opts)
+}
+
+func (s *S3) ResolveOneShot(ctx context.Context, token string) (string, error) {
+	return s.tokens.resolve(ctx, token)
+}
+
+func (s *S3) RevokeToken(ctx context.Context, token string) error {
+	return s.tokens.revoke(ctx, token)
+}
+
+func (s *S3) SetTokenStore(ts TokenStore) { s.tokens.setStore(ts) }
+
+// PresignPut 返回 query 签名的 PUT URL；c.ContentType 非空时签进去，客户端
+// 必须带同样的 Content-Type。
+func (s *S3) PresignPut(_ context.Context, key string, c PutConstraints, ttl time.Duration) (PresignedRequest, error) {
+	var headers map[string]string
+	if c.ContentType != "" {
+		headers = map[string]string{"Content-Type": c.ContentType}
+	}
+	return PresignedRequest{
+		Method:  http.MethodPut,
+		URL:     s.signer.presign(http.MethodPut, s.objectURL(s.public, key), headers, ttl, s.now()),
+		Headers: headers,
+	}, nil
+}
+
+// PresignGet 返回 query 签名的 GET URL。
+func (s *S3) PresignGet(_ context.Context, key string, ttl time.Duration) (string, error) {
+	return s.signer.presign(http.MethodGet, s.objectURL(s.public, key), nil, ttl, s.now()), nil
+}
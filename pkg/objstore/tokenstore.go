@@ -0,0 +1,199 @@
+package objstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// TokenStore 保存对象 token 的状态：有次数限制的 token 还剩几次、哪些
+// token 被吊销了。默认是进程内的内存表，重启后已发出的一次性链接全部
+// 失效；换成 SQLTokenStore 之后重启不受影响，多副本也能共享。
+type TokenStore interface {
+	// Issue 登记一个最多能用 uses 次的 token，记录保留到 exp。
+	Issue(ctx context.Context, id string, uses int, exp time.Time) error
+	// Consume 用掉一次。不存在或已用完返回 ErrTokenConsumed，被吊销返回
+	// ErrTokenRevoked。
+	Consume(ctx context.Context, id string) error
+	// Revoke 吊销 token，记录保留到 exp（过期后签名校验就会拒绝）。
+	Revoke(ctx context.Context, id string, exp time.Time) error
+	// Revoked 报告不限次数的 token 是否被吊销。
+	Revoked(ctx context.Context, id string) (bool, error)
+	// Sweep 清掉 now 之前过期的记录。
+	Sweep(ctx context.Context, now time.Time) error
+}
+
+type tokenState struct {
+	uses    int
+	revoked bool
+	exp     time.Time
+}
+
+// tokenSweepEvery 是清理过期 token 记录的间隔。
+const tokenSweepEvery = time.Minute
+
+// MemoryTokenStore 是进程内的 TokenStore。过期记录在之后的 Issue / Revoke
+// 里被顺带清掉，不需要后台 goroutine。
+type MemoryTokenStore struct {
+	mu        sync.Mutex
+	tokens    map[string]*tokenState
+	nextSweep time.Time
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: map[string]*tokenState{}}
+}
+
+// sweepLocked 每 tokenSweepEvery 清一次过期记录，调用方持有 m.mu。
+func (m *MemoryTokenStore) sweepLocked(now time.Time) {
+	if now.Before(m.nextSweep) {
+		return
+	}
+	for id, t := range m.tokens {
+		if t.exp.Before(now) {
+			delete(m.tokens, id)
+		}
+	}
+	m.nextSweep = now.Add(tokenSweepEvery)
+}
+
+func (m *MemoryTokenStore) Issue(_ context.Context, id string, uses int, exp time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweepLocked(time.Now())
+	m.tokens[id] = &tokenState{uses: uses, exp: exp}
+	return nil
+}
+
+func (m *MemoryTokenStore) Consume(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[id]
+	switch {
+	case ok && t.revoked:
+		return ErrTokenRevoked
+	case !ok || t.uses <= 0:
+		return ErrTokenConsumed
+	}
+	t.uses--
+	return nil
+}
+
+func (m *MemoryTokenStore) Revoke(_ context.Context, id string, exp time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweepLocked(time.Now())
+	if t, ok := m.tokens[id]; ok {
+		t.revoked = true
+		return nil
+	}
+	m.tokens[id] = &tokenState{revoked: true, exp: exp}
+	return nil
+}
+
+func (m *MemoryTokenStore) Revoked(_ context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[id]
+	return ok && t.revoked, nil
+}
+
+func (m *MemoryTokenStore) Sweep(_ context.Context, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextSweep = time.Time{}
+	m.sweepLocked(now)
+	return nil
+}
+
+// SweepTokens 每隔一分钟清一次 ts 里的过期记录，直到 stop 关闭。由 TokenStore
+// 的持有者启动一次；同一个存储挂在几个驱动上也只需要一个。
+func SweepTokens(ts TokenStore, stop <-chan struct{}) {
+	t := time.NewTicker(tokenSweepEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			if err := ts.Sweep(context.Background(), now); err != nil {
+				log.Printf("[objstore] sweep tokens: %v", err)
+			}
+		}
+	}
+}
+
+var tokenTable = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// SQLTokenStore 把 token 状态存在一张表里（SQLite 方言）。
+type SQLTokenStore struct {
+	db    *sql.DB
+	table string
+}
+
+// NewSQLTokenStore 建表（若不存在）。table 只允许字母、数字和下划线。
+func NewSQLTokenStore(db *sql.DB, table string) (*SQLTokenStore, error) {
+	if !tokenTable.MatchString(table) {
+		return nil, fmt.Errorf("objstore: invalid table name %q", table)
+	}
+	ddl := `CREATE TABLE IF NOT EXISTS ` + table + ` (
+    id         TEXT PRIMARY KEY,
+    uses_left  INTEGER NOT NULL DEFAULT 0,
+    revoked    INTEGER NOT NULL DEFAULT 0,
+    expires_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_` + table + `_expires ON ` + table + `(expires_at);`
+	if _, err := db.Exec(ddl); err != nil {
+		return nil, err
+	}
+	return &SQLTokenStore{db: db, table: table}, nil
+}
+
+func (s *SQLTokenStore) Issue(ctx context.Context, id string, uses int, exp time.Time) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO `+s.table+`(id, uses_left, expires_at) VALUES(?, ?, ?)`, id, uses, exp.Unix())
+	return err
+}
+
+func (s *SQLTokenStore) Consume(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE `+s.table+` SET uses_left=uses_left-1 WHERE id=? AND uses_left>0 AND revoked=0`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return nil
+	}
+	revoked, err := s.Revoked(ctx, id)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return ErrTokenRevoked
+	}
+	return ErrTokenConsumed
+}
+
+func (s *SQLTokenStore) Revoke(ctx context.Context, id string, exp time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO `+s.table+`(id, revoked, expires_at) VALUES(?, 1, ?)
+ON CONFLICT(id) DO UPDATE SET revoked=1`, id, exp.Unix())
+	return err
+}
+
+func (s *SQLTokenStore) Revoked(ctx context.Context, id string) (bool, error) {
+	var revoked bool
+	err := s.db.QueryRowContext(ctx, `SELECT revoked FROM `+s.table+` WHERE id=?`, id).Scan(&revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return revoked, err
+}
+
+func (s *SQLTokenStore) Sweep(ctx context.Context, now time.Time) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE expires_at < ?`, now.Unix())
+	return err
+}
@@ -0,0 +1,215 @@
+package tus
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Upload 是一个上传的状态。
+type Upload struct {
+	ID       string
+	Length   int64
+	Offset   int64
+	Metadata map[string]string
+	// Result 是 OnComplete 的返回值，上传完成前为空。
+	Result    []byte
+	Done      bool
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type part struct {
+	Offset int64
+	Size   int64
+	// Key 是分片在 Storage 里的 key；旧版本写的记录为空，按 offset 推出来。
+	Key string
+}
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sqlStore 把上传状态放在 <table> 和 <table>_parts 两张表里（SQLite 方言），
+// 多副本共用一个库时 PATCH 打到哪个副本都行。
+type sqlStore struct {
+	db    *sql.DB
+	table string
+}
+
+func newSQLStore(db *sql.DB, table string) (*sqlStore, error) {
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("tus: invalid table name %q", table)
+	}
+	ddl := `CREATE TABLE IF NOT EXISTS ` + table + ` (
+    id            TEXT PRIMARY KEY,
+    length        INTEGER NOT NULL,
+    upload_offset INTEGER NOT NULL DEFAULT 0,
+    metadata      TEXT NOT NULL DEFAULT '',
+    result        BLOB,
+    done          INTEGER NOT NULL DEFAULT 0,
+    created_at    INTEGER NOT NULL,
+    expires_at    INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_` + table + `_expires ON ` + table + `(expires_at);
+CREATE TABLE IF NOT EXISTS ` + table + `_parts (
+    upload_id   TEXT NOT NULL,
+    part_offset INTEGER NOT NULL,
+    size        INTEGER NOT NULL,
+    part_key    TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (upload_id, part_offset)
+);`
+	if _, err := db.Exec(ddl); err != nil {
+		return nil, err
+	}
+	s := &sqlStore{db: db, table: table}
+	// part_key 是后加的列
+	if err := s.ensureColumn(table+"_parts", "part_key", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sqlStore) ensureColumn(table, column, spec string) error {
+	rows, err := s.db.Query(`PRAGMA table_info(` + table + `)`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			def              any
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &def, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + spec)
+	return err
+}
+
+func (s *sqlStore) create(ctx context.Context, u *Upload) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO `+s.table+`(id, length, metadata, created_at, expires_at) VALUES(?, ?, ?, ?, ?)`,
+		u.ID, u.Length, encodeMetadata(u.Metadata), u.CreatedAt.UnixMilli(), u.ExpiresAt.UnixMilli())
+	return err
+}
+
+// get 返回上传；不存在时 (nil, nil)。过期与否由调用方判断。
+func (s *sqlStore) get(ctx context.Context, id string) (*Upload, error) {
+	var (
+		u                  Upload
+		meta               string
+		done               int
+		created, expiresAt int64
+	)
+	err := s.db.QueryRowContext(ctx, `SELECT id, length, upload_offset, metadata, result, done, created_at, expires_at FROM `+s.table+` WHERE id=?`, id).
+		Scan(&u.ID, &u.Length, &u.Offset, &meta, &u.Result, &done, &created, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.Metadata = parseMetadata(meta)
+	u.Done = done == 1
+	u.CreatedAt = time.UnixMilli(created)
+	u.ExpiresAt = time.UnixMilli(expiresAt)
+	return &u, nil
+}
+
+// advance 在 offset 仍是 from 时记下新分片（存在 key 下）并把 offset 推到
+// from+size；被别的请求抢先时 ok=false。
+func (s *sqlStore) advance(ctx context.Context, id string, from, size int64, key string, expiresAt time.Time) (ok bool, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	res, err := tx.ExecContext(ctx, `UPDATE `+s.table+` SET upload_offset=?, expires_at=? WHERE id=? AND upload_offset=? AND done=0`,
+		from+size, expiresAt.UnixMilli(), id, from)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO `+s.table+`_parts(upload_id, part_offset, size, part_key) VALUES(?, ?, ?, ?)`, id, from, size, key); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (s *sqlStore) parts(ctx context.Context, id string) ([]part, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT part_offset, size, part_key FROM `+s.table+`_parts WHERE upload_id=? ORDER BY part_offset`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []part
+	for rows.Next() {
+		var p part
+		if err := rows.Scan(&p.Offset, &p.Size, &p.Key); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// finish 记下结果并清掉分片记录；行保留到过期，HEAD / GET 还能查到结果。
+func (s *sqlStore) finish(ctx context.Context, id string, result []byte) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, `UPDATE `+s.table+` SET done=1, result=? WHERE id=?`, result, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM `+s.table+`_parts WHERE upload_id=?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) remove(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE id=?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM `+s.table+`_parts WHERE upload_id=?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// expired 返回 before 之前过期的上传 id。
+func (s *sqlStore) expired(ctx context.Context, before time.Time, limit int) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM `+s.table+` WHERE expires_at < ? LIMIT ?`, before.UnixMilli(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
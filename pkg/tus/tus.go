@@ -0,0 +1,576 @@
+// Package tus 实现 tus 1.0 断点续传的服务端：core（HEAD / PATCH / OPTIONS）
+// 以及 creation、creation-with-upload、termination、expiration 扩展。
+//
+// 每个 PATCH 的内容先落到本地临时文件（连接中断时已收到的部分照样保留），
+// 再作为一个分片写进 objstore.Storage；上传状态存在 SQL 表里，多副本共用
+// 一个库即可。最后一个字节到达后按顺序把分片拼成一个流交给 OnComplete，
+// 随后删除分片。
+package tus
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backend-go/pkg/objstore"
+)
+
+const (
+	Version    = "1.0.0"
+	Extensions = "creation,creation-with-upload,termination,expiration"
+
+	offsetContentType = "application/offset+octet-stream"
+)
+
+// 浏览器端跨域使用时，RequestHeaders 要加进 CORS 允许的请求头，
+// ResponseHeaders 要加进暴露的响应头。
+var (
+	RequestHeaders  = []string{"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "X-HTTP-Method-Override"}
+	ResponseHeaders = []string{"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length", "Upload-Metadata", "Upload-Expires"}
+)
+
+// Config 配置一个 tus 端点。
+type Config struct {
+	// Storage 存分片，key 为 <PartPrefix>/<id>/<offset>-<随机后缀>：抢输
+	// advance 的副本删掉的只是自己写的那份。
+	Storage    objstore.Storage
+	PartPrefix string // 默认 tus-parts
+	// BasePath 是端点挂载的 URL 路径，创建时的 Location 为 BasePath/<id>。
+	BasePath string
+	// MaxSize 是 Upload-Length 上限（Tus-Max-Size），0 为不限。
+	MaxSize int64
+	// Expiration 是上传在最后一次 PATCH 之后保留的时间，默认 24h。
+	Expiration time.Duration
+	// TempDir 存 PATCH 的临时文件，默认 os.TempDir()。
+	TempDir string
+	// OnCreate 在创建上传前调用，可以检查 Metadata 或改写它；返回 *Error
+	// 时按其状态码拒绝创建。可为空。
+	OnCreate func(ctx context.Context, u *Upload) error
+	// OnComplete 在上传完整后调用，r 是按顺序拼起来的全部内容。返回值记在
+	// Upload.Result 里；返回 *Error 可以指定响应状态码，上传随之作废。
+	OnComplete func(ctx context.Context, u *Upload, r io.Reader) ([]byte, error)
+}
+
+// Error 是带 HTTP 状态码的错误，OnComplete 用它拒绝内容（413、415 等）。
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Server 是 tus 端点。
+type Server struct {
+	cfg   Config
+	store *sqlStore
+	now   func() time.Time
+
+	// locks 让同一个上传的 PATCH 在本进程内串行，上传完成或作废时删掉；
+	// 跨副本靠 advance 的条件更新。
+	locks sync.Map
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// New 建表（<table> 与 <table>_parts）并返回 Server。
+func New(db *sql.DB, table string, cfg Config) (*Server, error) {
+	if cfg.Storage == nil {
+		return nil, errors.New("tus: storage required")
+	}
+	if cfg.OnComplete == nil {
+		return nil, errors.New("tus: OnComplete required")
+	}
+	if cfg.PartPrefix == "" {
+		cfg.PartPrefix = "tus-parts"
+	}
+	if cfg.Expiration <= 0 {
+		cfg.Expiration = 24 * time.Hour
+	}
+	if cfg.TempDir == "" {
+		cfg.TempDir = os.TempDir()
+	}
+	cfg.BasePath = strings.TrimRight(cfg.BasePath, "/")
+	store, err := newSQLStore(db, table)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{cfg: cfg, store: store, now: time.Now}, nil
+}
+
+// Get 返回上传状态；不存在或已过期时 (nil, nil)。
+func (s *Server) Get(ctx context.Context, id string) (*Upload, error) {
+	u, err := s.store.get(ctx, id)
+	if err != nil || u == nil || s.now().After(u.ExpiresAt) {
+		return nil, err
+	}
+	return u, nil
+}
+
+// ServeHTTP 处理集合（POST / OPTIONS）和单个上传（HEAD / PATCH / DELETE）。
+// 请求路径应当已经去掉 BasePath 前缀，剩下的就是上传 id。
+//
+// X-HTTP-Method-Override 只在 POST 上生效，且只能改成 PATCH 或 DELETE：
+// 创建上传只认真正的 POST，挂在其他方法上的限流、验证码就绕不过去。
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := r.Method
+	if m := r.Header.Get("X-HTTP-Method-Override"); m != "" && r.Method == http.MethodPost {
+		method = strings.ToUpper(m)
+		if method != http.MethodPatch && method != http.MethodDelete {
+			http.Error(w, "invalid X-HTTP-Method-Override", http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Tus-Resumable", Version)
+	if method == http.MethodOptions {
+		w.Header().Set("Tus-Version", Version)
+		w.Header().Set("Tus-Extension", Extensions)
+		if s.cfg.MaxSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(s.cfg.MaxSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != Version {
+		w.Header().Set("Tus-Version", Version)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+	id := strings.Trim(r.URL.Path, "/")
+	switch {
+	case id == "" && method == http.MethodPost:
+		s.create(w, r)
+	case id != "" && method == http.MethodHead:
+		s.head(w, r, id)
+	case id != "" && method == http.MethodPatch:
+		s.patch(w, r, id)
+	case id != "" && method == http.MethodDelete:
+		s.terminate(w, r, id)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	s.maybeSweep(ctx)
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "Upload-Defer-Length not supported", http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if s.cfg.MaxSize > 0 && length > s.cfg.MaxSize {
+		http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	meta, err := decodeMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+	id, err := newID()
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	now := s.now()
+	u := &Upload{ID: id, Length: length, Metadata: meta, CreatedAt: now, ExpiresAt: now.Add(s.cfg.Expiration)}
+	if s.cfg.OnCreate != nil {
+		if err := s.cfg.OnCreate(ctx, u); err != nil {
+			var e *Error
+			if errors.As(err, &e) {
+				http.Error(w, e.Message, e.Status)
+				return
+			}
+			log.Printf("[tus] OnCreate: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := s.store.create(ctx, u); err != nil {
+		log.Printf("[tus] create: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", s.cfg.BasePath+"/"+id)
+	w.Header().Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+
+	// creation-with-upload：创建请求里直接带第一段内容
+	if r.Header.Get("Content-Type") == offsetContentType && r.ContentLength != 0 {
+		status, offset, msg := s.write(ctx, u, r.Body)
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		if status >= 400 {
+			http.Error(w, msg, status)
+			return
+		}
+	} else if length == 0 {
+		// 空文件创建即完成
+		if status, msg := s.complete(ctx, u); status >= 400 {
+			http.Error(w, msg, status)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) head(w http.ResponseWriter, r *http.Request, id string) {
+	u, err := s.Get(r.Context(), id)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if u == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	if len(u.Metadata) > 0 {
+		w.Header().Set("Upload-Metadata", encodeMetadata(u.Metadata))
+	}
+	w.Header().Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) patch(w http.ResponseWriter, r *http.Request, id string) {
+	ctx := r.Context()
+	if r.Header.Get("Content-Type") != offsetContentType {
+		http.Error(w, "Content-Type must be "+offsetContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	u, unlock, err := s.lockUpload(ctx, id, s.Get)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if u == nil {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	defer unlock()
+	if offset != u.Offset {
+		http.Error(w, "Upload-Offset mismatch", http.StatusConflict)
+		return
+	}
+	status, newOffset, msg := s.write(ctx, u, r.Body)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	if status >= 400 {
+		http.Error(w, msg, status)
+		return
+	}
+	w.Header().Set("Upload-Expires", s.now().Add(s.cfg.Expiration).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) terminate(w http.ResponseWriter, r *http.Request, id string) {
+	u, unlock, err := s.lockUpload(r.Context(), id, s.store.get)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if u == nil {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	defer unlock()
+	if err := s.discard(r.Context(), id); err != nil {
+		log.Printf("[tus] terminate %s: %v", id, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// write 把 body 追加到上传末尾，满了就完成上传。返回状态码、新的 offset
+// 和出错时的说明。
+func (s *Server) write(ctx context.Context, u *Upload, body io.Reader) (status int, offset int64, msg string) {
+	if u.Done {
+		return http.StatusNoContent, u.Offset, ""
+	}
+	remaining := u.Length - u.Offset
+	tmp, err := os.CreateTemp(s.cfg.TempDir, "tus-*.part")
+	if err != nil {
+		log.Printf("[tus] temp file: %v", err)
+		return http.StatusInternalServerError, u.Offset, "internal error"
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	// 多读一个字节用来发现超出 Upload-Length 的请求
+	n, readErr := io.Copy(tmp, io.LimitReader(body, remaining+1))
+	if n > remaining {
+		return http.StatusRequestEntityTooLarge, u.Offset, "body exceeds Upload-Length"
+	}
+	if n > 0 {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return http.StatusInternalServerError, u.Offset, "internal error"
+		}
+		key, err := s.newPartKey(u.ID, u.Offset)
+		if err != nil {
+			return http.StatusInternalServerError, u.Offset, "internal error"
+		}
+		if _, err := s.cfg.Storage.Put(ctx, key, tmp, "application/octet-stream"); err != nil {
+			log.Printf("[tus] store part %s: %v", key, err)
+			return http.StatusInternalServerError, u.Offset, "internal error"
+		}
+		ok, err := s.store.advance(ctx, u.ID, u.Offset, n, key, s.now().Add(s.cfg.Expiration))
+		if err != nil || !ok {
+			_ = s.cfg.Storage.Delete(ctx, key)
+			if err != nil {
+				log.Printf("[tus] advance %s: %v", u.ID, err)
+				return http.StatusInternalServerError, u.Offset, "internal error"
+			}
+			return http.StatusConflict, u.Offset, "Upload-Offset mismatch"
+		}
+		u.Offset += n
+	}
+	if readErr != nil {
+		// 客户端断开：已经收到的部分保留下来，等它 HEAD 之后续传
+		return http.StatusBadRequest, u.Offset, "incomplete body"
+	}
+	if u.Offset == u.Length {
+		if status, msg := s.complete(ctx, u); status >= 400 {
+			return status, u.Offset, msg
+		}
+	}
+	return http.StatusNoContent, u.Offset, ""
+}
+
+// complete 把分片拼起来交给 OnComplete。OnComplete 拒绝时上传作废；
+// 其他错误保留分片，客户端在 offset == length 处再 PATCH 一次即可重试。
+func (s *Server) complete(ctx context.Context, u *Upload) (int, string) {
+	parts, err := s.store.parts(ctx, u.ID)
+	if err != nil {
+		log.Printf("[tus] parts %s: %v", u.ID, err)
+		return http.StatusInternalServerError, "internal error"
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Offset < parts[j].Offset })
+	keys := make([]string, 0, len(parts))
+	for _, p := range parts {
+		keys = append(keys, s.partKey(u.ID, p))
+	}
+	r := &partReader{ctx: ctx, storage: s.cfg.Storage, keys: keys}
+	defer r.Close()
+	result, err := s.cfg.OnComplete(ctx, u, r)
+	if err != nil {
+		var e *Error
+		if errors.As(err, &e) {
+			if derr := s.discard(ctx, u.ID); derr != nil {
+				log.Printf("[tus] discard %s: %v", u.ID, derr)
+			}
+			return e.Status, e.Message
+		}
+		log.Printf("[tus] complete %s: %v", u.ID, err)
+		return http.StatusInternalServerError, "internal error"
+	}
+	if err := s.store.finish(ctx, u.ID, result); err != nil {
+		log.Printf("[tus] finish %s: %v", u.ID, err)
+		return http.StatusInternalServerError, "internal error"
+	}
+	u.Done, u.Result = true, result
+	s.locks.Delete(u.ID)
+	for _, k := range keys {
+		if err := s.cfg.Storage.Delete(ctx, k); err != nil {
+			log.Printf("[tus] delete part %s: %v", k, err)
+		}
+	}
+	return http.StatusNoContent, ""
+}
+
+// discard 删掉上传的全部分片和记录。
+func (s *Server) discard(ctx context.Context, id string) error {
+	parts, err := s.store.parts(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if err := s.cfg.Storage.Delete(ctx, s.partKey(id, p)); err != nil {
+			return err
+		}
+	}
+	s.locks.Delete(id)
+	return s.store.remove(ctx, id)
+}
+
+const sweepInterval = 10 * time.Minute
+
+// Sweep 删除过期的上传（expiration 扩展），返回删掉的个数。创建上传时会按
+// 间隔顺带调用，也可以由定时任务调用。
+func (s *Server) Sweep(ctx context.Context) (int, error) {
+	ids, err := s.store.expired(ctx, s.now(), 500)
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		if err := s.discard(ctx, id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+func (s *Server) maybeSweep(ctx context.Context) {
+	now := s.now()
+	s.mu.Lock()
+	due := now.Sub(s.lastSweep) >= sweepInterval
+	if due {
+		s.lastSweep = now
+	}
+	s.mu.Unlock()
+	if !due {
+		return
+	}
+	if n, err := s.Sweep(ctx); err != nil {
+		log.Printf("[tus] sweep: %v", err)
+	} else if n > 0 {
+		log.Printf("[tus] swept %d expired uploads", n)
+	}
+}
+
+// lockUpload 先用 get 查到上传再加锁，加锁后再查一次（等锁期间可能已经完成或
+// 作废）。查不到时不持有锁，也不在 locks 里留条目：随便编的 id 不会让它变大。
+func (s *Server) lockUpload(ctx context.Context, id string, get func(context.Context, string) (*Upload, error)) (*Upload, func(), error) {
+	if u, err := get(ctx, id); err != nil || u == nil {
+		return nil, nil, err
+	}
+	v, _ := s.locks.LoadOrStore(id, &sync.Mutex{})
+	m := v.(*sync.Mutex)
+	m.Lock()
+	u, err := get(ctx, id)
+	if err != nil || u == nil {
+		s.locks.CompareAndDelete(id, m)
+		m.Unlock()
+		return nil, nil, err
+	}
+	return u, m.Unlock, nil
+}
+
+// newPartKey 给一次写入分配独享的 key。两个副本同时写同一个 offset 时，
+// 固定的 key 会让后写的覆盖先写的，抢输的一方再把它删掉。
+func (s *Server) newPartKey(id string, offset int64) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s/%016d-%s", s.cfg.PartPrefix, id, offset, hex.EncodeToString(b)), nil
+}
+
+// partKey 返回记录里的 key；旧版本的记录没有，用当时的固定格式。
+func (s *Server) partKey(id string, p part) string {
+	if p.Key != "" {
+		return p.Key
+	}
+	return fmt.Sprintf("%s/%s/%016d", s.cfg.PartPrefix, id, p.Offset)
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// partReader 按顺序逐个打开分片，同一时间只持有一个。
+type partReader struct {
+	ctx     context.Context
+	storage objstore.Storage
+	keys    []string
+	cur     io.ReadCloser
+}
+
+func (p *partReader) Read(b []byte) (int, error) {
+	for {
+		if p.cur == nil {
+			if len(p.keys) == 0 {
+				return 0, io.EOF
+			}
+			rc, _, err := p.storage.Get(p.ctx, p.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			p.cur, p.keys = rc, p.keys[1:]
+		}
+		n, err := p.cur.Read(b)
+		if err == io.EOF {
+			_ = p.cur.Close()
+			p.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (p *partReader) Close() error {
+	if p.cur != nil {
+		return p.cur.Close()
+	}
+	return nil
+}
+
+// Upload-Metadata：逗号分隔的 "key base64(value)"，value 可省略。
+func decodeMetadata(h string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, pair := range strings.Split(h, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(pair, " ")
+		val, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+		if err != nil || k == "" {
+			return nil, errors.New("tus: invalid metadata")
+		}
+		out[k] = string(val)
+	}
+	return out, nil
+}
+
+func encodeMetadata(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if m[k] == "" {
+			parts = append(parts, k)
+		} else {
+			parts = append(parts, k+" "+base64.StdEncoding.EncodeToString([]byte(m[k])))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseMetadata(s string) map[string]string {
+	m, _ := decodeMetadata(s)
+	return m
+}
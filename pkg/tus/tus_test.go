@@ -0,0 +1,304 @@
+package tus
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"backend-go/pkg/objstore"
+
+	_ "modernc.org/sqlite"
+)
+
+type testEnv struct {
+	srv     *Server
+	http    *httptest.Server
+	objects *objstore.Local
+	got     chan string
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+	objects, err := objstore.NewLocal(t.TempDir(), []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &testEnv{objects: objects, got: make(chan string, 1)}
+	env.srv, err = New(db, "tus_uploads", Config{
+		Storage:  objects,
+		BasePath: "/files",
+		MaxSize:  64,
+		TempDir:  t.TempDir(),
+		OnCreate: func(_ context.Context, u *Upload) error {
+			if _, ok := u.Metadata["reject"]; ok {
+				return &Error{Status: http.StatusForbidden, Message: "rejected"}
+			}
+			return nil
+		},
+		OnComplete: func(_ context.Context, u *Upload, r io.Reader) ([]byte, error) {
+			b, err := io.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			if strings.HasPrefix(string(b), "bad") {
+				return nil, &Error{Status: http.StatusUnsupportedMediaType, Message: "unsupported media"}
+			}
+			env.got <- u.Metadata["filename"] + ":" + string(b)
+			return []byte(`{"key":"done"}`), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/files/", http.StripPrefix("/files", env.srv))
+	mux.Handle("/files", http.StripPrefix("/files", env.srv))
+	env.http = httptest.NewServer(mux)
+	t.Cleanup(env.http.Close)
+	return env
+}
+
+func (e *testEnv) do(t *testing.T, method, path string, body string, headers map[string]string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, e.http.URL+path, strings.NewReader(body))
+	req.Header.Set("Tus-Resumable", Version)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return resp
+}
+
+func (e *testEnv) create(t *testing.T, length int) string {
+	t.Helper()
+	resp := e.do(t, http.MethodPost, "/files", "", map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename YS5qcGc=,private",
+	})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create: %d", resp.StatusCode)
+	}
+	loc := resp.Header.Get("Location")
+	if !strings.HasPrefix(loc, "/files/") || resp.Header.Get("Upload-Expires") == "" {
+		t.Fatalf("create headers: %v", resp.Header)
+	}
+	return loc
+}
+
+// partFiles 数 Storage 里还留着的分片文件。
+func (e *testEnv) partFiles(t *testing.T, id string) int {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(e.objects.Dir, "tus-parts", id))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func patch(offset int) map[string]string {
+	return map[string]string{"Content-Type": offsetContentType, "Upload-Offset": strconv.Itoa(offset)}
+}
+
+func TestUpload(t *testing.T) {
+	e := newTestEnv(t)
+
+	resp := e.do(t, http.MethodOptions, "/files", "", nil)
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Tus-Max-Size") != "64" || !strings.Contains(resp.Header.Get("Tus-Extension"), "termination") {
+		t.Fatalf("options: %d %v", resp.StatusCode, resp.Header)
+	}
+	if resp := e.do(t, http.MethodPost, "/files", "", map[string]string{"Tus-Resumable": "0.2.0", "Upload-Length": "1"}); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("wrong version: %d", resp.StatusCode)
+	}
+	if resp := e.do(t, http.MethodPost, "/files", "", map[string]string{"Upload-Length": "65"}); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("too large: %d", resp.StatusCode)
+	}
+	if resp := e.do(t, http.MethodPost, "/files", "", map[string]string{"Upload-Length": "1", "Upload-Metadata": "reject"}); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("OnCreate reject: %d", resp.StatusCode)
+	}
+
+	loc := e.create(t, 11)
+	if resp := e.do(t, http.MethodPatch, loc, "hello", patch(0)); resp.StatusCode != http.StatusNoContent || resp.Header.Get("Upload-Offset") != "5" {
+		t.Fatalf("patch 1: %d %v", resp.StatusCode, resp.Header)
+	}
+	// 重发同一段（客户端没收到响应）要被拒
+	if resp := e.do(t, http.MethodPatch, loc, "hello", patch(0)); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("stale offset: %d", resp.StatusCode)
+	}
+	if resp := e.do(t, http.MethodPatch, loc, " world", map[string]string{"Upload-Offset": "5"}); resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("wrong content type: %d", resp.StatusCode)
+	}
+	resp = e.do(t, http.MethodHead, loc, "", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Upload-Offset") != "5" || resp.Header.Get("Upload-Length") != "11" ||
+		resp.Header.Get("Cache-Control") != "no-store" || !strings.Contains(resp.Header.Get("Upload-Metadata"), "filename YS5qcGc=") {
+		t.Fatalf("head: %d %v", resp.StatusCode, resp.Header)
+	}
+	if resp := e.do(t, http.MethodPatch, loc, " world and more", patch(5)); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("past length: %d", resp.StatusCode)
+	}
+	if resp := e.do(t, http.MethodPatch, loc, " world", patch(5)); resp.StatusCode != http.StatusNoContent || resp.Header.Get("Upload-Offset") != "11" {
+		t.Fatalf("patch 2: %d %v", resp.StatusCode, resp.Header)
+	}
+	if got := <-e.got; got != "a.jpg:hello world" {
+		t.Fatalf("completed with %q", got)
+	}
+	u, err := e.srv.Get(context.Background(), strings.TrimPrefix(loc, "/files/"))
+	if err != nil || u == nil || !u.Done || string(u.Result) != `{"key":"done"}` {
+		t.Fatalf("upload after completion: %+v %v", u, err)
+	}
+	if n := e.partFiles(t, u.ID); n != 0 {
+		t.Fatalf("%d parts left after completion", n)
+	}
+}
+
+func TestCreationWithUploadAndReject(t *testing.T) {
+	e := newTestEnv(t)
+	resp := e.do(t, http.MethodPost, "/files", "bad!", map[string]string{
+		"Upload-Length": "4",
+		"Content-Type":  offsetContentType,
+	})
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("rejected content: %d", resp.StatusCode)
+	}
+	// 被 OnComplete 拒掉的上传直接作废
+	if resp := e.do(t, http.MethodHead, resp.Header.Get("Location"), "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("head after reject: %d", resp.StatusCode)
+	}
+}
+
+func TestTerminateAndExpire(t *testing.T) {
+	e := newTestEnv(t)
+	loc := e.create(t, 10)
+	if resp := e.do(t, http.MethodPatch, loc, "abc", patch(0)); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("patch: %d", resp.StatusCode)
+	}
+	if resp := e.do(t, http.MethodDelete, loc, "", nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: %d", resp.StatusCode)
+	}
+	if resp := e.do(t, http.MethodHead, loc, "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("head after delete: %d", resp.StatusCode)
+	}
+	if n := e.partFiles(t, strings.TrimPrefix(loc, "/files/")); n != 0 {
+		t.Fatalf("%d parts left after termination", n)
+	}
+
+	loc = e.create(t, 10)
+	if resp := e.do(t, http.MethodPatch, loc, "abc", patch(0)); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("patch: %d", resp.StatusCode)
+	}
+	e.srv.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+	if resp := e.do(t, http.MethodPatch, loc, "def", patch(3)); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("patch after expiry: %d", resp.StatusCode)
+	}
+	if n, err := e.srv.Sweep(context.Background()); err != nil || n != 1 {
+		t.Fatalf("sweep: %d %v", n, err)
+	}
+}
+
+// 不存在的 id 直接 404，不在 locks 里留条目。
+func TestUnknownUploadTakesNoLock(t *testing.T) {
+	e := newTestEnv(t)
+	for i := 0; i < 20; i++ {
+		id := "/files/nope" + strconv.Itoa(i)
+		if resp := e.do(t, http.MethodPatch, id, "abc", patch(0)); resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("patch unknown: %d", resp.StatusCode)
+		}
+		if resp := e.do(t, http.MethodDelete, id, "", nil); resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("delete unknown: %d", resp.StatusCode)
+		}
+	}
+	loc := e.create(t, 10)
+	if resp := e.do(t, http.MethodPatch, loc, "abc", patch(0)); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("patch: %d", resp.StatusCode)
+	}
+	if resp := e.do(t, http.MethodDelete, loc, "", nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: %d", resp.StatusCode)
+	}
+	n := 0
+	e.srv.locks.Range(func(any, any) bool { n++; return true })
+	if n != 0 {
+		t.Fatalf("%d locks left", n)
+	}
+}
+
+func TestMethodOverride(t *testing.T) {
+	e := newTestEnv(t)
+	// 只有 POST 能被改写，OPTIONS 等方法上的覆盖头不起作用
+	for _, m := range []string{http.MethodOptions, http.MethodPatch, http.MethodHead} {
+		resp := e.do(t, m, "/files", "", map[string]string{"X-HTTP-Method-Override": "POST", "Upload-Length": "3"})
+		if resp.StatusCode == http.StatusCreated || resp.Header.Get("Location") != "" {
+			t.Fatalf("%s overridden to POST created an upload", m)
+		}
+	}
+	for _, m := range []string{"POST", "OPTIONS", "HEAD", "GET"} {
+		if resp := e.do(t, http.MethodPost, "/files", "", map[string]string{"X-HTTP-Method-Override": m, "Upload-Length": "3"}); resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("POST overridden to %s: %d", m, resp.StatusCode)
+		}
+	}
+
+	loc := e.create(t, 6)
+	hdr := patch(0)
+	hdr["X-HTTP-Method-Override"] = "patch"
+	if resp := e.do(t, http.MethodPost, loc, "abc", hdr); resp.StatusCode != http.StatusNoContent || resp.Header.Get("Upload-Offset") != "3" {
+		t.Fatalf("POST as PATCH: %d %v", resp.StatusCode, resp.Header)
+	}
+	// PATCH 带覆盖头仍按 PATCH 处理
+	hdr = patch(3)
+	hdr["X-HTTP-Method-Override"] = "DELETE"
+	if resp := e.do(t, http.MethodPatch, loc, "def", hdr); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PATCH with override: %d", resp.StatusCode)
+	}
+	if got := <-e.got; got != "a.jpg:abcdef" {
+		t.Fatalf("completed with %q", got)
+	}
+	loc = e.create(t, 6)
+	if resp := e.do(t, http.MethodPost, loc, "", map[string]string{"X-HTTP-Method-Override": "DELETE"}); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("POST as DELETE: %d", resp.StatusCode)
+	}
+	if resp := e.do(t, http.MethodHead, loc, "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("head after delete: %d", resp.StatusCode)
+	}
+}
+
+// 两个副本拿着同一个 offset 写：抢输 advance 的一方只删自己的分片，
+// 不会覆盖或删掉赢家写下的内容。
+func TestLostAdvanceKeepsWinnerPart(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	id := strings.TrimPrefix(e.create(t, 6), "/files/")
+	a, _ := e.srv.Get(ctx, id)
+	b, _ := e.srv.Get(ctx, id)
+	if status, off, _ := e.srv.write(ctx, a, strings.NewReader("abc")); status != http.StatusNoContent || off != 3 {
+		t.Fatalf("winner: %d %d", status, off)
+	}
+	if status, _, _ := e.srv.write(ctx, b, strings.NewReader("xyz")); status != http.StatusConflict {
+		t.Fatalf("loser: %d", status)
+	}
+	if n := e.partFiles(t, id); n != 1 {
+		t.Fatalf("%d part files, want 1", n)
+	}
+	if resp := e.do(t, http.MethodPatch, "/files/"+id, "def", patch(3)); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("patch: %d", resp.StatusCode)
+	}
+	if got := <-e.got; got != "a.jpg:abcdef" {
+		t.Fatalf("completed with %q", got)
+	}
+}
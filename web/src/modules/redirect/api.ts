@@ -10,6 +10,11 @@ export interface LoginResult {
   expiresAt?: string
   username?: string
   needsTOTP?: boolean
+  /** 用默认口令登录成功：token 可用，但其他后台接口在改密前都会被拒绝。 */
+  mustChangePassword?: boolean
+  /** cookie 会话：JWT 已写进 HttpOnly cookie，没有 token，改用 csrfToken。 */
+  session?: boolean
+  csrfToken?: string
 }
 
 export async function login(username: string, password: string, totpCode?: string) {
@@ -69,8 +74,10 @@ export async function deletePasskey(id: string) {
   await M.http().delete(`/admin/webauthn/credentials/${encodeURIComponent(id)}`)
 }
 
+// An empty username starts a usernameless (discoverable credential) login.
 export async function beginPasskeyLogin(username: string) {
-  const resp = await M.http().post('/admin/webauthn/login/begin', { username })
+  const body = username ? { username } : { discoverable: true }
+  const resp = await M.http().post('/admin/webauthn/login/begin', body)
   return M.unwrap<LoginBeginOptions>(resp)
 }
 
@@ -79,6 +86,64 @@ export async function finishPasskeyLogin(sessionId: string, credential: object)
   return M.unwrap<LoginResult>(resp)
 }
 
+// ----- OIDC single sign-on -----
+
+export async function getOIDCStatus() {
+  const resp = await M.http().get('/admin/oidc/status')
+  return M.unwrap<{ enabled: boolean }>(resp)
+}
+
+/** Full-page URL that starts the provider redirect. */
+export function oidcStartURL() {
+  return M.http().getUri({ url: '/admin/oidc/start' })
+}
+
+/** Reads #token=… (or #session=true&csrfToken=…) / #oidc_error=… left by the OIDC callback redirect. */
+export function takeOIDCFragment(): { result?: LoginResult; error?: string } | null {
+  if (typeof window === 'undefined' || !window.location.hash) return null
+  const p = new URLSearchParams(window.location.hash.slice(1))
+  const token = p.get('token')
+  const csrfToken = p.get('csrfToken')
+  const error = p.get('oidc_error')
+  if (!token && !csrfToken && !error) return null
+  history.replaceState(null, '', window.location.pathname + window.location.search)
+  if (error) return { error }
+  return {
+    result: {
+      token: token ?? undefined,
+      session: p.get('session') === 'true',
+      csrfToken: csrfToken ?? undefined,
+      expiresAt: p.get('expiresAt') ?? '',
+      username: p.get('username') ?? '',
+    },
+  }
+}
+
+// ----- Password -----
+
+/** 修改后台密码；成功后返回新 token（旧 token 仍有效直至过期）。 */
+export async function changePassword(currentPassword: string, newPassword: string) {
+  const resp = await M.http().post('/admin/password', { currentPassword, newPassword })
+  return M.unwrap<LoginResult>(resp)
+}
+
+// ----- Step-up re-authentication (answer to a "reauth required" 403) -----
+
+export async function reauth(body: { totpCode?: string; password?: string }) {
+  const resp = await M.http().post('/admin/reauth', body)
+  return M.unwrap<LoginResult>(resp)
+}
+
+export async function beginPasskeyReauth() {
+  const resp = await M.http().post('/admin/reauth/webauthn/begin', {})
+  return M.unwrap<LoginBeginOptions>(resp)
+}
+
+export async function finishPasskeyReauth(sessionId: string, credential: object) {
+  const resp = await M.http().post('/admin/reauth/webauthn/finish', { sessionId, credential })
+  return M.unwrap<LoginResult>(resp)
+}
+
 // ----- Rules -----
 
 export async function listRules(params: { q?: string; limit?: number; offset?: number } = {}) {
@@ -23,7 +23,7 @@ export interface BadgeCoserBinding {
   updatedAt?: string
 }
 
-export type RequestStatus = 'new' | 'handled' | 'rejected'
+export type RequestStatus = 'new' | 'handled' | 'rejected' | 'quarantined'
 
 export interface PhotoRequest {
   id: string
@@ -32,6 +32,8 @@ export interface PhotoRequest {
   contact: string
   message?: string
   status: RequestStatus
+  spamScore?: number
+  spamReasons?: string[]
   createdAt: string
   updatedAt: string
 }
@@ -44,6 +46,8 @@ export interface AutographRequest {
   target: string
   content: string
   status: RequestStatus
+  spamScore?: number
+  spamReasons?: string[]
   createdAt: string
   updatedAt: string
 }
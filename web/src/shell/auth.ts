@@ -4,6 +4,17 @@ export interface AuthState {
   token: string | null
   username: string | null
   expiresAt: string | null
+  /** cookie 会话：JWT 在 HttpOnly cookie 里，这里只存 CSRF token。 */
+  csrf?: string | null
+}
+
+/** 登录类接口的返回：bearer 模式带 token，cookie 会话带 session + csrfToken。 */
+export interface SessionResult {
+  token?: string
+  username?: string
+  expiresAt?: string
+  session?: boolean
+  csrfToken?: string
 }
 
 function storageKey(name: string) {
@@ -34,26 +45,33 @@ export function defineModuleAuthStore(moduleName: string) {
   return defineStore(`auth.${moduleName}`, {
     state: (): AuthState => load(moduleName),
     getters: {
-      isLoggedIn: (s) => !!s.token,
+      isLoggedIn: (s) => !!s.token || !!s.csrf,
     },
     actions: {
-      set(token: string, username: string, expiresAt: string) {
+      set(token: string | null, username: string, expiresAt: string, csrf: string | null = null) {
         this.token = token
         this.username = username
         this.expiresAt = expiresAt
+        this.csrf = csrf
         try {
           localStorage.setItem(
             storageKey(moduleName),
-            JSON.stringify({ token, username, expiresAt }),
+            JSON.stringify({ token, username, expiresAt, csrf }),
           )
         } catch {
           /* storage 不可用时静默 */
         }
       },
+      /** 保存登录 / 重新验证 / 改密接口的返回，兼容 bearer 与 cookie 会话。 */
+      setSession(r: SessionResult) {
+        if (r.session) this.set(null, r.username ?? '', r.expiresAt ?? '', r.csrfToken ?? null)
+        else this.set(r.token ?? null, r.username ?? '', r.expiresAt ?? '')
+      },
       clear() {
         this.token = null
         this.username = null
         this.expiresAt = null
+        this.csrf = null
         try {
           localStorage.removeItem(storageKey(moduleName))
         } catch {
@@ -7,14 +7,20 @@ const refs = new Map<string, Ref<string>>()
 const listeners = new Set<(name: string, value: string) => void>()
 
 // 后台 server 在 admin 端口的 index.html 注入：
-//   <script>window.__ROAST_RUNTIME={apiBase:"http://host:8080"}</script>
+//   <script>window.__ROAST_RUNTIME={apiBase:"http://host:8080",credentials:true}</script>
 // 这样 SPA 一加载就知道 API 在哪，不用手点 BackendSwitcher。
 declare global {
   interface Window {
-    __ROAST_RUNTIME?: { apiBase?: string }
+    __ROAST_RUNTIME?: { apiBase?: string; credentials?: boolean }
   }
 }
 
+/** API 对 admin 源放行 CORS 凭据时为 true，请求带上 cookie（后台 cookie 会话需要）。 */
+export function getRuntimeCredentials(): boolean {
+  if (typeof window === 'undefined') return false
+  return window.__ROAST_RUNTIME?.credentials === true
+}
+
 export function getRuntimeApiBase(): string {
   if (typeof window === 'undefined') return ''
   const v = window.__ROAST_RUNTIME?.apiBase
@@ -1,12 +1,14 @@
 import type { AxiosInstance } from 'axios'
 import { defineModuleAuthStore } from './auth'
 import { createHttp, unwrap } from './http'
-import { getApiBase, onApiBaseChange } from './backend'
+import { promptReauth } from './reauth'
+import { getApiBase, getRuntimeCredentials, onApiBaseChange } from './backend'
 
 /**
  * 为一个后台模块生成「一套运行时」：
  *  - useAuth：该模块专属的 Pinia auth store
- *  - http：含 JWT 注入、01 自动跳登录的 axios 实例
+ *  - http：含 JWT / CSRF 注入、01 自动跳登录、敏感操作重新验证后重试、
+ *    未改默认口令时跳安全设置的 axios 实例（cookie 会话时带凭据）
  *  - unwrap：ApiResult 解包
  */
 export function defineModule(opts: { name: string; apiPrefix: string }) {
@@ -24,6 +26,8 @@ export function defineModule(opts: { name: string; apiPrefix: string }) {
       httpInstance = createHttp({
         baseURL: (base || '') + opts.apiPrefix,
         getToken: () => useAuth().token,
+        getCsrfToken: () => useAuth().csrf,
+        withCredentials: getRuntimeCredentials(),
         onUnauthorized: () => {
           useAuth().clear()
           if (typeof window !== 'undefined') {
@@ -36,6 +40,20 @@ export function defineModule(opts: { name: string; apiPrefix: string }) {
             }
           }
         },
+        onPasswordChangeRequired: () => {
+          if (typeof window === 'undefined') return
+          const base = import.meta.env.BASE_URL.replace(/\/$/, '')
+          const securityPath = `${base}/m/${opts.name}/security`
+          if (window.location.pathname !== securityPath) {
+            window.location.assign(`${securityPath}?changePassword=1`)
+          }
+        },
+        onReauthRequired: async (info) => {
+          const r = await promptReauth(http(), info)
+          if (!r) return false
+          useAuth().setSession(r)
+          return true
+        },
       })
     }
     return httpInstance
@@ -1,28 +1,55 @@
-import axios, { type AxiosInstance } from 'axios'
+import axios, { type AxiosInstance, type InternalAxiosRequestConfig } from 'axios'
 import type { ApiResult } from './types'
 
 export interface CreateHttpOptions {
   baseURL: string
   /** 请求发起时调用，返回当前模块的 JWT（或 null）。 */
   getToken?: () => string | null | undefined
+  /** cookie 会话下的 CSRF token，非 GET 请求放进 X-CSRF-Token。 */
+  getCsrfToken?: () => string | null | undefined
+  /** 跨域时带上 cookie。 */
+  withCredentials?: boolean
   /** 401 时回调，常用于清 token + 跳登录页。 */
   onUnauthorized?: () => void
+  /** 403 "reauth required" 时回调；返回 true 表示已拿到新 token，原请求会重试一次。 */
+  onReauthRequired?: (info: ReauthRequired) => Promise<boolean>
+  /** 403 "password change required"（仍在用默认口令）时回调，常用于跳到安全设置页。 */
+  onPasswordChangeRequired?: () => void
 }
 
 export function createHttp(opts: CreateHttpOptions): AxiosInstance {
-  const http = axios.create({ baseURL: opts.baseURL, timeout: 15_000 })
+  const http = axios.create({
+    baseURL: opts.baseURL,
+    timeout: 15_000,
+    withCredentials: opts.withCredentials ?? false,
+  })
   http.interceptors.request.use((config) => {
     const t = opts.getToken?.()
     if (t) {
       config.headers = config.headers ?? {}
       ;(config.headers as Record<string, string>).Authorization = `Bearer ${t}`
     }
+    const csrf = opts.getCsrfToken?.()
+    const method = (config.method ?? 'get').toLowerCase()
+    if (csrf && !['get', 'head', 'options'].includes(method)) {
+      config.headers = config.headers ?? {}
+      ;(config.headers as Record<string, string>)['X-CSRF-Token'] = csrf
+    }
     return config
   })
   http.interceptors.response.use(
     (resp) => resp,
-    (error) => {
+    async (error) => {
       if (error?.response?.status === 401) opts.onUnauthorized?.()
+      if (passwordChangeRequired(error)) opts.onPasswordChangeRequired?.()
+      const info = reauthRequired(error)
+      const config = error?.config as (InternalAxiosRequestConfig & { _reauthRetried?: boolean }) | undefined
+      if (info && config && !config._reauthRetried && opts.onReauthRequired) {
+        if (await opts.onReauthRequired(info)) {
+          config._reauthRetried = true
+          return http.request(config)
+        }
+      }
       return Promise.reject(error)
     },
   )
@@ -44,3 +71,26 @@ export function extractMessage(err: unknown, fallback = '请求失败'): string
   }
   return e?.response?.data?.message || e?.message || fallback
 }
+
+export interface ReauthRequired {
+  methods: Array<'totp' | 'webauthn' | 'password'>
+  maxAgeSeconds: number
+}
+
+/** 敏感操作返回 403 "reauth required" 时取出可用的重新验证方式，否则返回 null。 */
+export function reauthRequired(err: unknown): ReauthRequired | null {
+  const e = err as {
+    response?: { status?: number; data?: { data?: { reauthRequired?: boolean } & ReauthRequired } }
+  }
+  const d = e?.response?.data?.data
+  if (e?.response?.status !== 403 || !d?.reauthRequired) return null
+  return { methods: d.methods ?? [], maxAgeSeconds: d.maxAgeSeconds ?? 0 }
+}
+
+/** 管理员还没替换默认口令时，后台接口返回 403 且 data.passwordChangeRequired 为 true。 */
+export function passwordChangeRequired(err: unknown): boolean {
+  const e = err as {
+    response?: { status?: number; data?: { data?: { passwordChangeRequired?: boolean } } }
+  }
+  return e?.response?.status === 403 && !!e?.response?.data?.data?.passwordChangeRequired
+}
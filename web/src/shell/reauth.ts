@@ -0,0 +1,123 @@
+// Step-up prompt shown when the backend answers 403 "reauth required".
+// Asks for a TOTP code / password, or runs a passkey assertion, against the
+// module's /admin/reauth endpoints and resolves with the fresh login result.
+import '@material/web/dialog/dialog.js'
+import '@material/web/button/text-button.js'
+import '@material/web/button/filled-button.js'
+import '@material/web/textfield/filled-text-field.js'
+import type { AxiosInstance } from 'axios'
+import type { ReauthRequired } from './http'
+import { unwrap } from './http'
+import { getCredential, type LoginBeginOptions } from './webauthn'
+
+export interface ReauthResult {
+  token?: string
+  expiresAt: string
+  username: string
+  session?: boolean
+  csrfToken?: string
+}
+
+/** Resolves with a fresh token, or null if the user cancelled / failed. */
+export function promptReauth(http: AxiosInstance, info: ReauthRequired): Promise<ReauthResult | null> {
+  return new Promise((resolve) => {
+    const dialog = document.createElement('md-dialog') as HTMLElement & {
+      show: () => void
+      close: (reason?: string) => void
+    }
+    let result: ReauthResult | null = null
+
+    const headline = document.createElement('div')
+    headline.setAttribute('slot', 'headline')
+    headline.textContent = '需要重新验证身份'
+    dialog.appendChild(headline)
+
+    const content = document.createElement('div')
+    content.setAttribute('slot', 'content')
+    content.style.display = 'flex'
+    content.style.flexDirection = 'column'
+    content.style.gap = '12px'
+    dialog.appendChild(content)
+
+    const hasTOTP = info.methods.includes('totp')
+    const hasPasskey = info.methods.includes('webauthn')
+    const hasPassword = info.methods.includes('password')
+
+    const error = document.createElement('div')
+    error.style.color = 'var(--md-sys-color-error, #b3261e)'
+    error.style.fontSize = '13px'
+
+    let field: (HTMLElement & { value: string }) | null = null
+    if (hasTOTP || hasPassword) {
+      field = document.createElement('md-filled-text-field') as HTMLElement & { value: string }
+      field.setAttribute('label', hasTOTP ? 'TOTP 验证码' : '密码')
+      field.setAttribute('type', hasTOTP ? 'text' : 'password')
+      if (hasTOTP) field.setAttribute('inputmode', 'numeric')
+      content.appendChild(field)
+    }
+    content.appendChild(error)
+
+    const actions = document.createElement('div')
+    actions.setAttribute('slot', 'actions')
+
+    const cancelBtn = document.createElement('md-text-button')
+    cancelBtn.textContent = '取消'
+    cancelBtn.addEventListener('click', () => dialog.close('cancel'))
+    actions.appendChild(cancelBtn)
+
+    const fail = (e: unknown) => {
+      const err = e as { response?: { data?: { message?: string } }; message?: string }
+      error.textContent = err?.response?.data?.message || err?.message || '验证失败'
+    }
+
+    if (hasPasskey) {
+      const passkeyBtn = document.createElement('md-text-button')
+      passkeyBtn.textContent = '使用 Passkey'
+      passkeyBtn.addEventListener('click', async () => {
+        try {
+          const begin = unwrap<LoginBeginOptions>(await http.post('/admin/reauth/webauthn/begin', {}))
+          const credential = await getCredential(begin)
+          result = unwrap<ReauthResult>(
+            await http.post('/admin/reauth/webauthn/finish', { sessionId: begin.sessionId, credential }),
+          )
+          dialog.close('ok')
+        } catch (e) {
+          fail(e)
+        }
+      })
+      actions.appendChild(passkeyBtn)
+    }
+
+    if (field) {
+      const okBtn = document.createElement('md-filled-button')
+      okBtn.textContent = '验证'
+      okBtn.addEventListener('click', async () => {
+        const v = field!.value.trim()
+        if (!v) return
+        try {
+          const body = hasTOTP ? { totpCode: v } : { password: v }
+          result = unwrap<ReauthResult>(await http.post('/admin/reauth', body))
+          dialog.close('ok')
+        } catch (e) {
+          fail(e)
+        }
+      })
+      actions.appendChild(okBtn)
+    }
+
+    dialog.appendChild(actions)
+    dialog.addEventListener('closed', () => {
+      dialog.remove()
+      resolve(result)
+    })
+
+    document.body.appendChild(dialog)
+    requestAnimationFrame(() => {
+      try {
+        dialog.show()
+      } catch {
+        setTimeout(() => dialog.show(), 0)
+      }
+    })
+  })
+}
@@ -51,8 +51,8 @@ router.beforeEach((to) => {
   try {
     const raw = localStorage.getItem(`roast.admin.${modName}.auth`)
     if (raw) {
-      const obj = JSON.parse(raw) as { token?: string; expiresAt?: string }
-      if (obj.token && (!obj.expiresAt || new Date(obj.expiresAt).getTime() > Date.now())) {
+      const obj = JSON.parse(raw) as { token?: string; csrf?: string; expiresAt?: string }
+      if ((obj.token || obj.csrf) && (!obj.expiresAt || new Date(obj.expiresAt).getTime() > Date.now())) {
         return true
       }
     }
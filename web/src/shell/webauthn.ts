@@ -25,6 +25,8 @@ export interface RegBeginOptions {
   userDisplayName: string
   timeout: number
   excludeCredentialIds: string[]
+  attestation?: AttestationConveyancePreference
+  userVerification?: UserVerificationRequirement
   sessionId: string
 }
 
@@ -33,6 +35,7 @@ export interface LoginBeginOptions {
   rpId: string
   timeout: number
   allowCredentials: Array<{ type: string; id: string }>
+  userVerification?: UserVerificationRequirement
   sessionId: string
 }
 
@@ -47,6 +50,7 @@ export async function createCredential(opts: RegBeginOptions): Promise<object> {
         displayName: opts.userDisplayName,
       },
       pubKeyCredParams: [
+        { alg: -8, type: 'public-key' as const },
         { alg: -7, type: 'public-key' as const },
         { alg: -257, type: 'public-key' as const },
       ],
@@ -55,8 +59,11 @@ export async function createCredential(opts: RegBeginOptions): Promise<object> {
         id: b64urlToBuf(id),
         type: 'public-key' as const,
       })),
-      authenticatorSelection: { residentKey: 'preferred', userVerification: 'preferred' },
-      attestation: 'none',
+      authenticatorSelection: {
+        residentKey: 'preferred',
+        userVerification: opts.userVerification ?? 'preferred',
+      },
+      attestation: opts.attestation ?? 'none',
     },
   })) as PublicKeyCredential
   if (!cred) throw new Error('navigator.credentials.create returned null')
@@ -82,7 +89,7 @@ export async function getCredential(opts: LoginBeginOptions): Promise<object> {
         id: b64urlToBuf(c.id),
         type: 'public-key' as const,
       })),
-      userVerification: 'preferred',
+      userVerification: opts.userVerification ?? 'preferred',
     },
   })) as PublicKeyCredential
   if (!cred) throw new Error('navigator.credentials.get returned null')